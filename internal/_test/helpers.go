@@ -54,6 +54,22 @@ func testRequest(handler http.Handler, url string, method string, i interface{})
 	return response
 }
 
+func HeaderRequest(handler http.Handler, url string, method string, body string, headers map[string]string) *httptest.ResponseRecorder {
+	var reader io.Reader = nil
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	request, _ := http.NewRequest(method, url, reader)
+	for k, v := range headers {
+		request.Header.Set(k, v)
+	}
+	response := httptest.NewRecorder()
+
+	handler.ServeHTTP(response, request)
+
+	return response
+}
+
 func PostRequest(handler http.Handler, url string, i interface{}) *httptest.ResponseRecorder {
 	return testRequest(handler, url, http.MethodPost, i)
 }
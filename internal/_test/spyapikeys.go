@@ -0,0 +1,137 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package _test
+
+import (
+	"github.com/LearningByExample/go-microservice/internal/app/data"
+	"github.com/LearningByExample/go-microservice/internal/app/store"
+)
+
+type spyApiKeys struct {
+	AddApiKeyWasCall     bool
+	GetApiKeyWasCall     bool
+	GetAllApiKeysWasCall bool
+	FindApiKeyWasCall    bool
+	RotateApiKeyWasCall  bool
+	RevokeApiKeyWasCall  bool
+	ApiKeyId             int
+	ApiKeyParameters     data.ApiKey
+	addApiKeyFunc        func(name string, prefix string, hash string, scopes []string) (int, error)
+	getApiKeyFunc        func(id int) (data.ApiKey, error)
+	getAllApiKeysFunc    func() ([]data.ApiKey, error)
+	findApiKeyFunc       func(hash string) (data.ApiKey, error)
+	rotateApiKeyFunc     func(id int, prefix string, hash string) error
+	revokeApiKeyFunc     func(id int) error
+}
+
+func (s *spyApiKeys) resetApiKeys() {
+	s.AddApiKeyWasCall = false
+	s.GetApiKeyWasCall = false
+	s.GetAllApiKeysWasCall = false
+	s.FindApiKeyWasCall = false
+	s.RotateApiKeyWasCall = false
+	s.RevokeApiKeyWasCall = false
+	s.ApiKeyId = 0
+	s.ApiKeyParameters = data.ApiKey{}
+	s.addApiKeyFunc = func(name string, prefix string, hash string, scopes []string) (int, error) {
+		return 0, nil
+	}
+	s.getApiKeyFunc = func(id int) (data.ApiKey, error) {
+		return data.ApiKey{}, nil
+	}
+	s.getAllApiKeysFunc = func() ([]data.ApiKey, error) {
+		return []data.ApiKey{}, nil
+	}
+	s.findApiKeyFunc = func(hash string) (data.ApiKey, error) {
+		return data.ApiKey{}, store.ApiKeyNotFound
+	}
+	s.rotateApiKeyFunc = func(id int, prefix string, hash string) error {
+		return nil
+	}
+	s.revokeApiKeyFunc = func(id int) error {
+		return nil
+	}
+}
+
+func (s *SpyStore) AddApiKey(name string, prefix string, hash string, scopes []string) (int, error) {
+	var err error = nil
+	s.AddApiKeyWasCall = true
+	s.ApiKeyParameters = data.ApiKey{Name: name, Prefix: prefix, Hash: hash, Scopes: scopes}
+	s.ApiKeyParameters.Id, err = s.addApiKeyFunc(name, prefix, hash, scopes)
+	return s.ApiKeyParameters.Id, err
+}
+
+func (s *SpyStore) GetApiKey(id int) (data.ApiKey, error) {
+	s.GetApiKeyWasCall = true
+	s.ApiKeyId = id
+	return s.getApiKeyFunc(id)
+}
+
+func (s *SpyStore) GetAllApiKeys() ([]data.ApiKey, error) {
+	s.GetAllApiKeysWasCall = true
+	return s.getAllApiKeysFunc()
+}
+
+func (s *SpyStore) FindApiKey(hash string) (data.ApiKey, error) {
+	s.FindApiKeyWasCall = true
+	s.ApiKeyParameters.Hash = hash
+	return s.findApiKeyFunc(hash)
+}
+
+func (s *SpyStore) RotateApiKey(id int, prefix string, hash string) error {
+	s.RotateApiKeyWasCall = true
+	s.ApiKeyId = id
+	s.ApiKeyParameters.Prefix = prefix
+	s.ApiKeyParameters.Hash = hash
+	return s.rotateApiKeyFunc(id, prefix, hash)
+}
+
+func (s *SpyStore) RevokeApiKey(id int) error {
+	s.RevokeApiKeyWasCall = true
+	s.ApiKeyId = id
+	return s.revokeApiKeyFunc(id)
+}
+
+func (s *SpyStore) WhenAddApiKey(addApiKeyFunc func(name string, prefix string, hash string, scopes []string) (int, error)) {
+	s.addApiKeyFunc = addApiKeyFunc
+}
+
+func (s *SpyStore) WhenGetApiKey(getApiKeyFunc func(id int) (data.ApiKey, error)) {
+	s.getApiKeyFunc = getApiKeyFunc
+}
+
+func (s *SpyStore) WhenGetAllApiKeys(getAllApiKeysFunc func() ([]data.ApiKey, error)) {
+	s.getAllApiKeysFunc = getAllApiKeysFunc
+}
+
+func (s *SpyStore) WhenFindApiKey(findApiKeyFunc func(hash string) (data.ApiKey, error)) {
+	s.findApiKeyFunc = findApiKeyFunc
+}
+
+func (s *SpyStore) WhenRotateApiKey(rotateApiKeyFunc func(id int, prefix string, hash string) error) {
+	s.rotateApiKeyFunc = rotateApiKeyFunc
+}
+
+func (s *SpyStore) WhenRevokeApiKey(revokeApiKeyFunc func(id int) error) {
+	s.revokeApiKeyFunc = revokeApiKeyFunc
+}
@@ -0,0 +1,154 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package _test
+
+import (
+	"github.com/LearningByExample/go-microservice/internal/app/data"
+)
+
+type spyOwners struct {
+	AddOwnerWasCall     bool
+	GetOwnerWasCall     bool
+	GetAllOwnersWasCall bool
+	GetOwnersWasCall    bool
+	UpdateOwnerWasCall  bool
+	DeleteOwnerWasCall  bool
+	SetOwnerWasCall     bool
+	OwnerId             int
+	ReassignTo          int
+	OwnerParameters     data.Owner
+	addOwnerFunc        func(name string, email string) (int, error)
+	getOwnerFunc        func(id int) (data.Owner, error)
+	getAllOwnersFunc    func() ([]data.Owner, error)
+	getOwnersFunc       func(ids []int) ([]data.Owner, error)
+	updateOwnerFunc     func(id int, name string, email string) (bool, error)
+	deleteOwnerFunc     func(id int, reassignTo int) error
+	setOwnerFunc        func(petId int, ownerId int) (bool, error)
+}
+
+func (s *spyOwners) resetOwners() {
+	s.AddOwnerWasCall = false
+	s.GetOwnerWasCall = false
+	s.GetAllOwnersWasCall = false
+	s.GetOwnersWasCall = false
+	s.UpdateOwnerWasCall = false
+	s.DeleteOwnerWasCall = false
+	s.SetOwnerWasCall = false
+	s.OwnerId = 0
+	s.ReassignTo = 0
+	s.OwnerParameters = data.Owner{}
+	s.addOwnerFunc = func(name string, email string) (int, error) {
+		return 0, nil
+	}
+	s.getOwnerFunc = func(id int) (data.Owner, error) {
+		return data.Owner{}, nil
+	}
+	s.getAllOwnersFunc = func() ([]data.Owner, error) {
+		return []data.Owner{}, nil
+	}
+	s.getOwnersFunc = func(ids []int) ([]data.Owner, error) {
+		return []data.Owner{}, nil
+	}
+	s.updateOwnerFunc = func(id int, name string, email string) (bool, error) {
+		return false, nil
+	}
+	s.deleteOwnerFunc = func(id int, reassignTo int) error {
+		return nil
+	}
+	s.setOwnerFunc = func(petId int, ownerId int) (bool, error) {
+		return false, nil
+	}
+}
+
+func (s *SpyStore) AddOwner(name string, email string) (int, error) {
+	var err error = nil
+	s.AddOwnerWasCall = true
+	s.OwnerParameters = data.Owner{Name: name, Email: email}
+	s.OwnerParameters.Id, err = s.addOwnerFunc(name, email)
+	return s.OwnerParameters.Id, err
+}
+
+func (s *SpyStore) GetOwner(id int) (data.Owner, error) {
+	s.GetOwnerWasCall = true
+	s.OwnerId = id
+	return s.getOwnerFunc(id)
+}
+
+func (s *SpyStore) GetAllOwners() ([]data.Owner, error) {
+	s.GetAllOwnersWasCall = true
+	return s.getAllOwnersFunc()
+}
+
+func (s *SpyStore) GetOwners(ids []int) ([]data.Owner, error) {
+	s.GetOwnersWasCall = true
+	return s.getOwnersFunc(ids)
+}
+
+func (s *SpyStore) UpdateOwner(id int, name string, email string) (bool, error) {
+	s.UpdateOwnerWasCall = true
+	s.OwnerId = id
+	s.OwnerParameters = data.Owner{Name: name, Email: email}
+	return s.updateOwnerFunc(id, name, email)
+}
+
+func (s *SpyStore) DeleteOwner(id int, reassignTo int) error {
+	s.DeleteOwnerWasCall = true
+	s.OwnerId = id
+	s.ReassignTo = reassignTo
+	return s.deleteOwnerFunc(id, reassignTo)
+}
+
+func (s *SpyStore) SetPetOwner(petId int, ownerId int) (bool, error) {
+	s.SetOwnerWasCall = true
+	s.Id = petId
+	s.OwnerId = ownerId
+	return s.setOwnerFunc(petId, ownerId)
+}
+
+func (s *SpyStore) WhenAddOwner(addOwnerFunc func(name string, email string) (int, error)) {
+	s.addOwnerFunc = addOwnerFunc
+}
+
+func (s *SpyStore) WhenGetOwner(getOwnerFunc func(id int) (data.Owner, error)) {
+	s.getOwnerFunc = getOwnerFunc
+}
+
+func (s *SpyStore) WhenGetAllOwners(getAllOwnersFunc func() ([]data.Owner, error)) {
+	s.getAllOwnersFunc = getAllOwnersFunc
+}
+
+func (s *SpyStore) WhenGetOwners(getOwnersFunc func(ids []int) ([]data.Owner, error)) {
+	s.getOwnersFunc = getOwnersFunc
+}
+
+func (s *SpyStore) WhenUpdateOwner(updateOwnerFunc func(id int, name string, email string) (bool, error)) {
+	s.updateOwnerFunc = updateOwnerFunc
+}
+
+func (s *SpyStore) WhenDeleteOwner(deleteOwnerFunc func(id int, reassignTo int) error) {
+	s.deleteOwnerFunc = deleteOwnerFunc
+}
+
+func (s *SpyStore) WhenSetPetOwner(setOwnerFunc func(petId int, ownerId int) (bool, error)) {
+	s.setOwnerFunc = setOwnerFunc
+}
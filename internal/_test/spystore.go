@@ -23,29 +23,68 @@
 package _test
 
 import (
+	"context"
 	"github.com/LearningByExample/go-microservice/internal/app/data"
+	"github.com/LearningByExample/go-microservice/internal/app/store"
 	"log"
+	"time"
 )
 
 type SpyStore struct {
-	DeleteWasCall  bool
-	GetWasCall     bool
-	GetAllWasCall  bool
-	AddWasCall     bool
-	UpdateWasCall  bool
-	OpenWasCall    bool
-	CloseWasCall   bool
-	IsReadyWasCall bool
-	Id             int
-	PetParameters  data.Pet
-	deleteFunc     func(id int) error
-	getFunc        func(id int) (data.Pet, error)
-	getAllFunc     func() ([]data.Pet, error)
-	addFunc        func(name string, race string, mod string) (int, error)
-	updateFunc     func(id int, name string, race string, mod string) (bool, error)
-	openFunc       func() error
-	closeFunc      func() error
-	isReadyFunc    func() error
+	DeleteWasCall     bool
+	GetWasCall        bool
+	GetAllWasCall     bool
+	AddWasCall        bool
+	UpdateWasCall     bool
+	OpenWasCall       bool
+	CloseWasCall      bool
+	IsReadyWasCall    bool
+	ForEachWasCall    bool
+	ImportWasCall     bool
+	BatchWasCall      bool
+	FindWasCall       bool
+	CountWasCall      bool
+	RestoreWasCall    bool
+	PurgeWasCall      bool
+	HistoryWasCall    bool
+	TransitionWasCall bool
+	SearchWasCall     bool
+	SetTagsWasCall    bool
+	Id                int
+	PetParameters     data.Pet
+	ImportedPets      []data.Pet
+	Operations        []store.PetOperation
+	Atomic            bool
+	Query             store.PetQuery
+	PurgeBefore       time.Time
+	Status            data.PetStatus
+	SearchText        string
+	Tags              []string
+	Attributes        map[string]string
+	Offset            int
+	Limit             int
+	Ctx               context.Context
+	deleteFunc        func(id int) error
+	getFunc           func(id int) (data.Pet, error)
+	getAllFunc        func() ([]data.Pet, error)
+	addFunc           func(name string, race string, mod string) (int, error)
+	updateFunc        func(id int, name string, race string, mod string) (bool, error)
+	openFunc          func() error
+	closeFunc         func() error
+	isReadyFunc       func() error
+	forEachFunc       func(fn func(pet data.Pet) error) error
+	importFunc        func(pets []data.Pet) error
+	batchFunc         func(ops []store.PetOperation, atomic bool) ([]store.PetOperationResult, error)
+	findFunc          func(query store.PetQuery) ([]data.Pet, error)
+	restoreFunc       func(id int) error
+	purgeFunc         func(before time.Time) ([]int, error)
+	historyFunc       func(id int, offset int, limit int) ([]data.PetChange, int, error)
+	transitionFunc    func(id int, to data.PetStatus) (data.Pet, error)
+	searchFunc        func(text string, offset int, limit int) ([]data.PetMatch, int, error)
+	setTagsFunc       func(id int, tags []string, attributes map[string]string) (bool, error)
+	spyOwners
+	spyWebhooks
+	spyApiKeys
 }
 
 func (s *SpyStore) Reset() {
@@ -57,6 +96,21 @@ func (s *SpyStore) Reset() {
 	s.OpenWasCall = false
 	s.CloseWasCall = false
 	s.IsReadyWasCall = false
+	s.ForEachWasCall = false
+	s.ImportWasCall = false
+	s.BatchWasCall = false
+	s.FindWasCall = false
+	s.CountWasCall = false
+	s.RestoreWasCall = false
+	s.PurgeWasCall = false
+	s.HistoryWasCall = false
+	s.TransitionWasCall = false
+	s.SearchWasCall = false
+	s.SetTagsWasCall = false
+	s.Tags = nil
+	s.Attributes = nil
+	s.Status = ""
+	s.SearchText = ""
 	s.Id = 0
 	s.PetParameters = data.Pet{
 		Id:   0,
@@ -88,6 +142,47 @@ func (s *SpyStore) Reset() {
 	s.isReadyFunc = func() error {
 		return nil
 	}
+	s.ImportedPets = nil
+	s.Operations = nil
+	s.Atomic = false
+	s.Query = store.PetQuery{}
+	s.PurgeBefore = time.Time{}
+	s.Offset = 0
+	s.Limit = 0
+	s.Ctx = nil
+	s.forEachFunc = func(fn func(pet data.Pet) error) error {
+		return nil
+	}
+	s.importFunc = func(pets []data.Pet) error {
+		return nil
+	}
+	s.batchFunc = func(ops []store.PetOperation, atomic bool) ([]store.PetOperationResult, error) {
+		return make([]store.PetOperationResult, len(ops)), nil
+	}
+	s.findFunc = func(query store.PetQuery) ([]data.Pet, error) {
+		return []data.Pet{}, nil
+	}
+	s.restoreFunc = func(id int) error {
+		return nil
+	}
+	s.purgeFunc = func(before time.Time) ([]int, error) {
+		return []int{}, nil
+	}
+	s.historyFunc = func(id int, offset int, limit int) ([]data.PetChange, int, error) {
+		return []data.PetChange{}, 0, nil
+	}
+	s.transitionFunc = func(id int, to data.PetStatus) (data.Pet, error) {
+		return data.Pet{Id: id, Status: to}, nil
+	}
+	s.searchFunc = func(text string, offset int, limit int) ([]data.PetMatch, int, error) {
+		return []data.PetMatch{}, 0, nil
+	}
+	s.setTagsFunc = func(id int, tags []string, attributes map[string]string) (bool, error) {
+		return true, nil
+	}
+	s.resetOwners()
+	s.resetWebhooks()
+	s.resetApiKeys()
 }
 
 func (s *SpyStore) AddPet(name string, race string, mod string) (int, error) {
@@ -129,6 +224,86 @@ func (s *SpyStore) UpdatePet(id int, name string, race string, mod string) (bool
 	return s.updateFunc(id, name, race, mod)
 }
 
+func (s *SpyStore) ForEachPet(fn func(pet data.Pet) error) error {
+	s.ForEachWasCall = true
+	return s.forEachFunc(fn)
+}
+
+func (s *SpyStore) ImportPets(pets []data.Pet) error {
+	s.ImportWasCall = true
+	s.ImportedPets = append(s.ImportedPets, pets...)
+	return s.importFunc(pets)
+}
+
+func (s *SpyStore) BatchPets(ops []store.PetOperation, atomic bool) ([]store.PetOperationResult, error) {
+	s.BatchWasCall = true
+	s.Operations = ops
+	s.Atomic = atomic
+	return s.batchFunc(ops, atomic)
+}
+
+func (s *SpyStore) FindPets(query store.PetQuery) ([]data.Pet, error) {
+	s.FindWasCall = true
+	s.Query = query
+	return s.findFunc(query)
+}
+
+// CountPets counts what the find function would find without the page of the query
+func (s *SpyStore) CountPets(query store.PetQuery) (int, error) {
+	s.CountWasCall = true
+	query.AfterId, query.Limit = 0, 0
+	pets, err := s.findFunc(query)
+	return len(pets), err
+}
+
+func (s *SpyStore) RestorePet(id int) error {
+	s.RestoreWasCall = true
+	s.Id = id
+	return s.restoreFunc(id)
+}
+
+func (s *SpyStore) TransitionPet(id int, to data.PetStatus) (data.Pet, error) {
+	s.TransitionWasCall = true
+	s.Id = id
+	s.Status = to
+	return s.transitionFunc(id, to)
+}
+
+func (s *SpyStore) PurgePets(before time.Time) ([]int, error) {
+	s.PurgeWasCall = true
+	s.PurgeBefore = before
+	return s.purgeFunc(before)
+}
+
+func (s *SpyStore) PetHistory(id int, offset int, limit int) ([]data.PetChange, int, error) {
+	s.HistoryWasCall = true
+	s.Id = id
+	s.Offset = offset
+	s.Limit = limit
+	return s.historyFunc(id, offset, limit)
+}
+
+func (s *SpyStore) SetPetTags(id int, tags []string, attributes map[string]string) (bool, error) {
+	s.SetTagsWasCall = true
+	s.Id = id
+	s.Tags = tags
+	s.Attributes = attributes
+	return s.setTagsFunc(id, tags, attributes)
+}
+
+func (s *SpyStore) SearchPets(text string, offset int, limit int) ([]data.PetMatch, int, error) {
+	s.SearchWasCall = true
+	s.SearchText = text
+	s.Offset = offset
+	s.Limit = limit
+	return s.searchFunc(text, offset, limit)
+}
+
+func (s *SpyStore) WithContext(ctx context.Context) store.PetStore {
+	s.Ctx = ctx
+	return s
+}
+
 func (s *SpyStore) Open() error {
 	s.OpenWasCall = true
 	log.Println("Spy pet store opened.")
@@ -178,6 +353,46 @@ func (s *SpyStore) WhenIsReady(isReadyFunc func() error) {
 	s.isReadyFunc = isReadyFunc
 }
 
+func (s *SpyStore) WhenForEachPet(forEachFunc func(fn func(pet data.Pet) error) error) {
+	s.forEachFunc = forEachFunc
+}
+
+func (s *SpyStore) WhenImportPets(importFunc func(pets []data.Pet) error) {
+	s.importFunc = importFunc
+}
+
+func (s *SpyStore) WhenBatchPets(batchFunc func(ops []store.PetOperation, atomic bool) ([]store.PetOperationResult, error)) {
+	s.batchFunc = batchFunc
+}
+
+func (s *SpyStore) WhenFindPets(findFunc func(query store.PetQuery) ([]data.Pet, error)) {
+	s.findFunc = findFunc
+}
+
+func (s *SpyStore) WhenRestorePet(restoreFunc func(id int) error) {
+	s.restoreFunc = restoreFunc
+}
+
+func (s *SpyStore) WhenTransitionPet(transitionFunc func(id int, to data.PetStatus) (data.Pet, error)) {
+	s.transitionFunc = transitionFunc
+}
+
+func (s *SpyStore) WhenPurgePets(purgeFunc func(before time.Time) ([]int, error)) {
+	s.purgeFunc = purgeFunc
+}
+
+func (s *SpyStore) WhenPetHistory(historyFunc func(id int, offset int, limit int) ([]data.PetChange, int, error)) {
+	s.historyFunc = historyFunc
+}
+
+func (s *SpyStore) WhenSetPetTags(setTagsFunc func(id int, tags []string, attributes map[string]string) (bool, error)) {
+	s.setTagsFunc = setTagsFunc
+}
+
+func (s *SpyStore) WhenSearchPets(searchFunc func(text string, offset int, limit int) ([]data.PetMatch, int, error)) {
+	s.searchFunc = searchFunc
+}
+
 func NewSpyStore() SpyStore {
 	spyStore := SpyStore{}
 	spyStore.Reset()
@@ -0,0 +1,180 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package _test
+
+import (
+	"github.com/LearningByExample/go-microservice/internal/app/data"
+	"time"
+)
+
+type spyWebhooks struct {
+	AddWebhookWasCall     bool
+	GetWebhookWasCall     bool
+	GetAllWebhooksWasCall bool
+	UpdateWebhookWasCall  bool
+	DisableWebhookWasCall bool
+	DeleteWebhookWasCall  bool
+	DeliveriesWasCall     bool
+	WebhookId             int
+	WebhookParameters     data.Webhook
+	addWebhookFunc        func(url string, events []string, secret string, enabled bool) (int, error)
+	getWebhookFunc        func(id int) (data.Webhook, error)
+	getAllWebhooksFunc    func() ([]data.Webhook, error)
+	updateWebhookFunc     func(id int, url string, events []string, secret string, enabled bool) (bool, error)
+	disableWebhookFunc    func(id int) error
+	deleteWebhookFunc     func(id int) error
+	deliveriesFunc        func(id int, offset int, limit int) ([]data.Delivery, int, error)
+	pendingFunc           func() (int, error)
+}
+
+func (s *spyWebhooks) resetWebhooks() {
+	s.AddWebhookWasCall = false
+	s.GetWebhookWasCall = false
+	s.GetAllWebhooksWasCall = false
+	s.UpdateWebhookWasCall = false
+	s.DisableWebhookWasCall = false
+	s.DeleteWebhookWasCall = false
+	s.DeliveriesWasCall = false
+	s.WebhookId = 0
+	s.WebhookParameters = data.Webhook{}
+	s.addWebhookFunc = func(url string, events []string, secret string, enabled bool) (int, error) {
+		return 0, nil
+	}
+	s.getWebhookFunc = func(id int) (data.Webhook, error) {
+		return data.Webhook{}, nil
+	}
+	s.getAllWebhooksFunc = func() ([]data.Webhook, error) {
+		return []data.Webhook{}, nil
+	}
+	s.updateWebhookFunc = func(id int, url string, events []string, secret string, enabled bool) (bool, error) {
+		return false, nil
+	}
+	s.disableWebhookFunc = func(id int) error {
+		return nil
+	}
+	s.deleteWebhookFunc = func(id int) error {
+		return nil
+	}
+	s.deliveriesFunc = func(id int, offset int, limit int) ([]data.Delivery, int, error) {
+		return []data.Delivery{}, 0, nil
+	}
+	s.pendingFunc = func() (int, error) {
+		return 0, nil
+	}
+}
+
+func (s *SpyStore) AddWebhook(url string, events []string, secret string, enabled bool) (int, error) {
+	var err error = nil
+	s.AddWebhookWasCall = true
+	s.WebhookParameters = data.Webhook{Url: url, Events: events, Secret: secret, Enabled: enabled}
+	s.WebhookParameters.Id, err = s.addWebhookFunc(url, events, secret, enabled)
+	return s.WebhookParameters.Id, err
+}
+
+func (s *SpyStore) GetWebhook(id int) (data.Webhook, error) {
+	s.GetWebhookWasCall = true
+	s.WebhookId = id
+	return s.getWebhookFunc(id)
+}
+
+func (s *SpyStore) GetAllWebhooks() ([]data.Webhook, error) {
+	s.GetAllWebhooksWasCall = true
+	return s.getAllWebhooksFunc()
+}
+
+func (s *SpyStore) UpdateWebhook(id int, url string, events []string, secret string, enabled bool) (bool, error) {
+	s.UpdateWebhookWasCall = true
+	s.WebhookId = id
+	s.WebhookParameters = data.Webhook{Id: id, Url: url, Events: events, Secret: secret, Enabled: enabled}
+	return s.updateWebhookFunc(id, url, events, secret, enabled)
+}
+
+func (s *SpyStore) DisableWebhook(id int) error {
+	s.DisableWebhookWasCall = true
+	s.WebhookId = id
+	return s.disableWebhookFunc(id)
+}
+
+func (s *SpyStore) DeleteWebhook(id int) error {
+	s.DeleteWebhookWasCall = true
+	s.WebhookId = id
+	return s.deleteWebhookFunc(id)
+}
+
+func (s *SpyStore) EnqueueDeliveries(_ string, _ string) (int, error) {
+	return 0, nil
+}
+
+func (s *SpyStore) ClaimDeliveries(_ time.Time, _ time.Duration, _ int) ([]data.Delivery, error) {
+	return []data.Delivery{}, nil
+}
+
+func (s *SpyStore) RecordDeliveryAttempt(_ int, _ data.DeliveryAttempt, _ data.DeliveryStatus, _ time.Time) (int, error) {
+	return 0, nil
+}
+
+func (s *SpyStore) PendingDeliveries() (int, error) {
+	return s.pendingFunc()
+}
+
+func (s *SpyStore) WebhookDeliveries(id int, offset int, limit int) ([]data.Delivery, int, error) {
+	s.DeliveriesWasCall = true
+	s.WebhookId = id
+	s.Offset = offset
+	s.Limit = limit
+	return s.deliveriesFunc(id, offset, limit)
+}
+
+func (s *SpyStore) WhenAddWebhook(addWebhookFunc func(url string, events []string, secret string,
+	enabled bool) (int, error)) {
+	s.addWebhookFunc = addWebhookFunc
+}
+
+func (s *SpyStore) WhenGetWebhook(getWebhookFunc func(id int) (data.Webhook, error)) {
+	s.getWebhookFunc = getWebhookFunc
+}
+
+func (s *SpyStore) WhenGetAllWebhooks(getAllWebhooksFunc func() ([]data.Webhook, error)) {
+	s.getAllWebhooksFunc = getAllWebhooksFunc
+}
+
+func (s *SpyStore) WhenUpdateWebhook(updateWebhookFunc func(id int, url string, events []string, secret string,
+	enabled bool) (bool, error)) {
+	s.updateWebhookFunc = updateWebhookFunc
+}
+
+func (s *SpyStore) WhenDisableWebhook(disableWebhookFunc func(id int) error) {
+	s.disableWebhookFunc = disableWebhookFunc
+}
+
+func (s *SpyStore) WhenDeleteWebhook(deleteWebhookFunc func(id int) error) {
+	s.deleteWebhookFunc = deleteWebhookFunc
+}
+
+func (s *SpyStore) WhenWebhookDeliveries(deliveriesFunc func(id int, offset int, limit int) ([]data.Delivery, int, error)) {
+	s.deliveriesFunc = deliveriesFunc
+}
+
+func (s *SpyStore) WhenPendingDeliveries(pendingFunc func() (int, error)) {
+	s.pendingFunc = pendingFunc
+}
@@ -0,0 +1,86 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package _test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/golang-jwt/jwt/v4"
+	"math/big"
+)
+
+// TokenKeys are keys generated for the tests, to publish them in a JWKS and mint tokens signed with them
+type TokenKeys struct {
+	Rsa    *rsa.PrivateKey
+	Ec     *ecdsa.PrivateKey
+	Secret []byte
+}
+
+func NewTokenKeys() TokenKeys {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	secret := make([]byte, 32)
+	_, _ = rand.Read(secret)
+	return TokenKeys{Rsa: rsaKey, Ec: ecKey, Secret: secret}
+}
+
+func encodeInt(value *big.Int, size int) string {
+	bytes := value.Bytes()
+	if len(bytes) < size {
+		bytes = append(make([]byte, size-len(bytes)), bytes...)
+	}
+	return base64.RawURLEncoding.EncodeToString(bytes)
+}
+
+// Jwks gets a JWKS with the public keys and the secret, with the key ids rsa, ec and hmac
+func (k TokenKeys) Jwks() []byte {
+	keys := []map[string]string{
+		{"kty": "RSA", "kid": "rsa", "use": "sig", "alg": "RS256", "n": encodeInt(k.Rsa.N, 0),
+			"e": encodeInt(big.NewInt(int64(k.Rsa.E)), 0)},
+		{"kty": "EC", "kid": "ec", "crv": "P-256", "x": encodeInt(k.Ec.X, 32), "y": encodeInt(k.Ec.Y, 32)},
+		{"kty": "oct", "kid": "hmac", "alg": "HS256", "k": base64.RawURLEncoding.EncodeToString(k.Secret)},
+	}
+	bytes, _ := json.Marshal(map[string]interface{}{"keys": keys})
+	return bytes
+}
+
+// Sign mints a token with the key for the algorithm, with the key id when it is not empty
+func (k TokenKeys) Sign(alg string, kid string, claims jwt.MapClaims) string {
+	var key interface{} = k.Secret
+	switch alg {
+	case "RS256":
+		key = k.Rsa
+	case "ES256":
+		key = k.Ec
+	}
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(alg), claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, _ := token.SignedString(key)
+	return signed
+}
@@ -0,0 +1,127 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package blob
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	NotFound   = errors.New("can not find blob")
+	InvalidKey = errors.New("invalid blob key")
+)
+
+type Reader interface {
+	io.ReadSeeker
+	io.Closer
+}
+
+type Info struct {
+	Size    int64
+	ModTime time.Time
+}
+
+type Store interface {
+	Put(key string, r io.Reader) error
+	Get(key string) (Reader, Info, error)
+	Delete(key string) error
+}
+
+type fileStore struct {
+	root string
+}
+
+func (fs fileStore) path(key string) (string, error) {
+	if key == "" || strings.Contains(key, "..") || filepath.IsAbs(key) {
+		return "", InvalidKey
+	}
+	return filepath.Join(fs.root, filepath.FromSlash(key)), nil
+}
+
+func (fs fileStore) Put(key string, r io.Reader) error {
+	path, err := fs.path(key)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(path)
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(dir, ".upload-")
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(tmp, r); err == nil {
+		err = tmp.Close()
+	} else {
+		_ = tmp.Close()
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+	}
+	return err
+}
+
+func (fs fileStore) Get(key string) (Reader, Info, error) {
+	path, err := fs.path(key)
+	if err != nil {
+		return nil, Info{}, err
+	}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, Info{}, NotFound
+	} else if err != nil {
+		return nil, Info{}, err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, Info{}, err
+	}
+	return file, Info{Size: stat.Size(), ModTime: stat.ModTime()}, nil
+}
+
+func (fs fileStore) Delete(key string) error {
+	path, err := fs.path(key)
+	if err != nil {
+		return err
+	}
+	if err = os.Remove(path); os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func NewFileStore(root string) Store {
+	return fileStore{root: root}
+}
@@ -0,0 +1,95 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package blob
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestFileStore(t *testing.T) (Store, string) {
+	t.Helper()
+	root, err := ioutil.TempDir("", "blob")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	return NewFileStore(root), root
+}
+
+func TestFileStore(t *testing.T) {
+	fs, root := newTestFileStore(t)
+	//noinspection GoUnhandledErrorResult
+	defer os.RemoveAll(root)
+
+	t.Run("should put and get a blob", func(t *testing.T) {
+		if err := fs.Put("1/original", strings.NewReader("content")); err != nil {
+			t.Fatalf("want not error, got %v", err)
+		}
+		r, info, err := fs.Get("1/original")
+		if err != nil {
+			t.Fatalf("want not error, got %v", err)
+		}
+		//noinspection GoUnhandledErrorResult
+		defer r.Close()
+		got, _ := ioutil.ReadAll(r)
+		if string(got) != "content" || info.Size != 7 || info.ModTime.IsZero() {
+			t.Fatalf("got %q with %v, want content", got, info)
+		}
+		if _, err = os.Stat(filepath.Join(root, "1", "original")); err != nil {
+			t.Fatalf("want blob in root, got %v", err)
+		}
+	})
+
+	t.Run("should replace a blob", func(t *testing.T) {
+		_ = fs.Put("1/original", strings.NewReader("other"))
+		r, _, _ := fs.Get("1/original")
+		//noinspection GoUnhandledErrorResult
+		defer r.Close()
+		if got, _ := ioutil.ReadAll(r); string(got) != "other" {
+			t.Fatalf("got %q, want other", got)
+		}
+	})
+
+	t.Run("should delete a blob", func(t *testing.T) {
+		if err := fs.Delete("1/original"); err != nil {
+			t.Fatalf("want not error, got %v", err)
+		}
+		if _, _, err := fs.Get("1/original"); err != NotFound {
+			t.Fatalf("got %v, want %v", err, NotFound)
+		}
+		if err := fs.Delete("1/original"); err != nil {
+			t.Fatalf("want not error deleting twice, got %v", err)
+		}
+	})
+
+	t.Run("should reject invalid keys", func(t *testing.T) {
+		for _, key := range []string{"", "../outside", "/abs"} {
+			if err := fs.Put(key, strings.NewReader("x")); err != InvalidKey {
+				t.Fatalf("got %v for %q, want %v", err, key, InvalidKey)
+			}
+		}
+	})
+}
@@ -23,31 +23,202 @@
 package config
 
 import (
+	"crypto/tls"
 	"encoding/json"
 	"errors"
+	"github.com/LearningByExample/go-microservice/internal/app/logging"
 	"io/ioutil"
+	"net"
 	"os"
+	"strconv"
+	"strings"
 )
 
 var (
 	InvalidCfg = errors.New("invalid configuration")
 )
 
+// TlsCfg serves HTTPS with a certificate reloaded when it is rotated, verifying the client certificates with the CA
+// bundle when it is set
+type TlsCfg struct {
+	CertFile       string   `json:"cert-file"`
+	KeyFile        string   `json:"key-file"`
+	MinVersion     string   `json:"min-version"`
+	CipherSuites   []string `json:"cipher-suites"`
+	ClientCaFile   string   `json:"client-ca-file"`
+	ClientAuth     string   `json:"client-auth"`
+	ReloadInterval int      `json:"reload-interval"`
+}
+
+const (
+	ClientAuthRequire        = "require"
+	ClientAuthOptional       = "optional"
+	defaultTlsMinVersion     = "1.2"
+	defaultTlsClientAuth     = ClientAuthRequire
+	defaultTlsReloadInterval = 30000
+)
+
+var (
+	tlsVersions = map[string]uint16{
+		"1.0": tls.VersionTLS10,
+		"1.1": tls.VersionTLS11,
+		"1.2": tls.VersionTLS12,
+		"1.3": tls.VersionTLS13,
+	}
+)
+
+func (cfg TlsCfg) IsEnabled() bool {
+	return cfg.CertFile != ""
+}
+
+func (cfg TlsCfg) Version() uint16 {
+	return tlsVersions[cfg.MinVersion]
+}
+
+// Ciphers gets the ids of the configured cipher suites, skipping the unknown or insecure ones
+func (cfg TlsCfg) Ciphers() []uint16 {
+	var ids []uint16 = nil
+	for _, name := range cfg.CipherSuites {
+		for _, suite := range tls.CipherSuites() {
+			if suite.Name == name {
+				ids = append(ids, suite.ID)
+			}
+		}
+	}
+	return ids
+}
+
+func (cfg TlsCfg) isValid() bool {
+	if !cfg.IsEnabled() {
+		return cfg.KeyFile == "" && cfg.ClientCaFile == ""
+	}
+	_, found := tlsVersions[cfg.MinVersion]
+	return cfg.KeyFile != "" && found && len(cfg.Ciphers()) == len(cfg.CipherSuites) && cfg.ReloadInterval >= 0 &&
+		(cfg.ClientAuth == ClientAuthRequire || cfg.ClientAuth == ClientAuthOptional)
+}
+
+// ServerCfg has the HTTP listeners, at least one of the TCP port, the Unix socket and systemd socket activation, and
+// the gRPC and admin ports when they are set
 type ServerCfg struct {
-	Port int `json:"port"`
+	Port             int    `json:"port"`
+	Socket           string `json:"socket"`
+	SocketMode       string `json:"socket-mode"`
+	SocketActivation bool   `json:"socket-activation"`
+	GrpcPort         int    `json:"grpc-port"`
+	AdminPort        int    `json:"admin-port"`
+	AdminAddress     string `json:"admin-address"`
+	LogLevel         string `json:"log-level"`
+	DrainPeriod      int    `json:"drain-period"`
+	ShutdownTimeout  int    `json:"shutdown-timeout"`
+	Tls              TlsCfg `json:"tls"`
+}
+
+const (
+	defaultDrainPeriod     = 5000
+	defaultShutdownTimeout = 20000
+	defaultSocketMode      = "0660"
+	defaultAdminAddress    = "127.0.0.1"
+)
+
+func (cfg ServerCfg) IsGrpcEnabled() bool {
+	return cfg.GrpcPort != 0
+}
+
+func (cfg ServerCfg) IsAdminEnabled() bool {
+	return cfg.AdminPort != 0
+}
+
+// AdminAddr gets the address of the admin listener, that is only bound to the loopback interface by default as the
+// admin endpoints are not authenticated
+func (cfg ServerCfg) AdminAddr() string {
+	return net.JoinHostPort(cfg.AdminAddress, strconv.Itoa(cfg.AdminPort))
+}
+
+// SocketFileMode gets the permissions of the Unix socket file from its octal representation
+func (cfg ServerCfg) SocketFileMode() os.FileMode {
+	mode, _ := strconv.ParseUint(cfg.SocketMode, 8, 32)
+	return os.FileMode(mode) & os.ModePerm
+}
+
+func (cfg ServerCfg) isValidSocket() bool {
+	if cfg.Socket == "" {
+		return true
+	}
+	mode, err := strconv.ParseUint(cfg.SocketMode, 8, 32)
+	return err == nil && os.FileMode(mode)&^os.ModePerm == 0
 }
 
 func (cfg ServerCfg) isValid() bool {
-	return cfg.Port != 0
+	return (cfg.Port != 0 || cfg.Socket != "" || cfg.SocketActivation) && cfg.isValidSocket() &&
+		(!cfg.IsGrpcEnabled() || cfg.GrpcPort != cfg.Port) && cfg.Tls.isValid() && logging.IsValidLevel(cfg.LogLevel) &&
+		(!cfg.IsAdminEnabled() || (cfg.AdminPort != cfg.Port && cfg.AdminPort != cfg.GrpcPort)) &&
+		(cfg.AdminAddress == "" || net.ParseIP(cfg.AdminAddress) != nil) &&
+		cfg.DrainPeriod >= 0 && cfg.ShutdownTimeout >= 0
+}
+
+type PurgeCfg struct {
+	Retention int `json:"retention"`
+	Interval  int `json:"interval"`
+}
+
+func (cfg PurgeCfg) IsEnabled() bool {
+	return cfg.Retention != 0 && cfg.Interval != 0
+}
+
+func (cfg PurgeCfg) isValid() bool {
+	return !cfg.IsEnabled() || (cfg.Retention > 0 && cfg.Interval > 0)
+}
+
+type OutboxCfg struct {
+	Publisher string `json:"publisher"`
+	Path      string `json:"path"`
+	Url       string `json:"url"`
+	Interval  int    `json:"interval"`
+	BatchSize int    `json:"batch-size"`
+	Timeout   int    `json:"timeout"`
+}
+
+const (
+	LogPublisher           = "log"
+	FilePublisher          = "file"
+	HttpPublisher          = "http"
+	defaultOutboxInterval  = 1000
+	defaultOutboxBatchSize = 100
+	defaultOutboxTimeout   = 5000
+)
+
+func (cfg OutboxCfg) IsEnabled() bool {
+	return cfg.Publisher != ""
+}
+
+func (cfg OutboxCfg) isValid() bool {
+	if !cfg.IsEnabled() {
+		return true
+	}
+	if cfg.Interval <= 0 || cfg.BatchSize <= 0 || cfg.Timeout <= 0 {
+		return false
+	}
+	switch cfg.Publisher {
+	case LogPublisher:
+		return true
+	case FilePublisher:
+		return cfg.Path != ""
+	case HttpPublisher:
+		return cfg.Url != ""
+	}
+	return false
 }
 
 type StoreCfg struct {
 	Name       string        `json:"name"`
 	Postgresql PostgreSQLCfg `json:"postgresql"`
+	Purge      PurgeCfg      `json:"purge"`
+	Outbox     OutboxCfg     `json:"outbox"`
 }
 
 func (cfg StoreCfg) isValid() bool {
-	return cfg.Name != "" && !(cfg.Name == "postgreSQL" && !cfg.Postgresql.isValid())
+	return cfg.Name != "" && !(cfg.Name == "postgreSQL" && !cfg.Postgresql.isValid()) && cfg.Outbox.isValid() &&
+		cfg.Purge.isValid()
 }
 
 type PoolConfig struct {
@@ -76,19 +247,276 @@ func (pool PoolConfig) isValid() bool {
 	return pool.MaxOpenConns != 0 && pool.MaxIdleConns != 0 && pool.MaxTimeConns != 0
 }
 
+type PhotosCfg struct {
+	Path      string `json:"path"`
+	Layout    string `json:"layout"`
+	MaxSize   int64  `json:"max-size"`
+	MaxPixels int    `json:"max-pixels"`
+	ThumbSize int    `json:"thumb-size"`
+}
+
+const (
+	PhotoIdVar       = "{id}"
+	PhotoSizeVar     = "{size}"
+	defaultLayout    = PhotoIdVar + "/" + PhotoSizeVar
+	defaultMaxSize   = 5 << 20
+	defaultMaxPixels = 20000000
+	defaultThumbMax  = 128
+)
+
+func (cfg PhotosCfg) IsEnabled() bool {
+	return cfg.Path != ""
+}
+
+func (cfg PhotosCfg) isValid() bool {
+	return !cfg.IsEnabled() || (strings.Contains(cfg.Layout, PhotoIdVar) &&
+		strings.Contains(cfg.Layout, PhotoSizeVar) && cfg.MaxSize > 0 && cfg.MaxPixels > 0 && cfg.ThumbSize > 0)
+}
+
+type WebhooksCfg struct {
+	Interval    int `json:"interval"`
+	Timeout     int `json:"timeout"`
+	BatchSize   int `json:"batch-size"`
+	MaxAttempts int `json:"max-attempts"`
+	MaxFailures int `json:"max-failures"`
+	Backoff     int `json:"backoff"`
+	MaxBackoff  int `json:"max-backoff"`
+}
+
+const (
+	defaultWebhookTimeout     = 5000
+	defaultWebhookBatchSize   = 50
+	defaultWebhookMaxAttempts = 8
+	defaultWebhookMaxFailures = 20
+	defaultWebhookBackoff     = 1000
+	defaultWebhookMaxBackoff  = 3600000
+)
+
+func (cfg WebhooksCfg) IsEnabled() bool {
+	return cfg.Interval != 0
+}
+
+func (cfg WebhooksCfg) isValid() bool {
+	return !cfg.IsEnabled() || (cfg.Interval > 0 && cfg.Timeout > 0 && cfg.BatchSize > 0 && cfg.MaxAttempts > 0 &&
+		cfg.MaxFailures > 0 && cfg.Backoff > 0 && cfg.MaxBackoff >= cfg.Backoff)
+}
+
+type GraphqlCfg struct {
+	MaxDepth      int `json:"max-depth"`
+	MaxComplexity int `json:"max-complexity"`
+}
+
+const (
+	defaultGraphqlMaxDepth      = 10
+	defaultGraphqlMaxComplexity = 1000
+)
+
+func (cfg GraphqlCfg) isValid() bool {
+	return cfg.MaxDepth > 0 && cfg.MaxComplexity > 0
+}
+
+// JwtCfg validates the bearer tokens with the keys of a JWKS file, reloaded when it changes, or inlined in the config
+type JwtCfg struct {
+	JwksFile       string              `json:"jwks-file"`
+	Jwks           json.RawMessage     `json:"jwks"`
+	Issuer         string              `json:"issuer"`
+	Audience       string              `json:"audience"`
+	ClockSkew      int                 `json:"clock-skew"`
+	ReloadInterval int                 `json:"reload-interval"`
+	RolesClaim     string              `json:"roles-claim"`
+	OwnerClaim     string              `json:"owner-claim"`
+	Roles          map[string][]string `json:"roles"`
+}
+
+const (
+	defaultJwtClockSkew      = 60000
+	defaultJwtReloadInterval = 30000
+	defaultJwtRolesClaim     = "roles"
+	defaultJwtOwnerClaim     = "owner_id"
+)
+
+func (cfg JwtCfg) IsEnabled() bool {
+	return cfg.JwksFile != "" || len(cfg.Jwks) != 0
+}
+
+func (cfg JwtCfg) isValid() bool {
+	return !cfg.IsEnabled() || (cfg.ClockSkew >= 0 && cfg.ReloadInterval >= 0 && cfg.RolesClaim != "")
+}
+
+type AuthCfg struct {
+	Enabled      bool                `json:"enabled"`
+	BootstrapKey string              `json:"bootstrap-key"`
+	Jwt          JwtCfg              `json:"jwt"`
+	PolicyFile   string              `json:"policy-file"`
+	ClientCerts  map[string][]string `json:"client-certs"`
+}
+
+const (
+	minBootstrapKeyLength = 32
+)
+
+func (cfg AuthCfg) isValid() bool {
+	return (cfg.BootstrapKey == "" || len(cfg.BootstrapKey) >= minBootstrapKeyLength) && cfg.Jwt.isValid() &&
+		(cfg.PolicyFile == "" || cfg.Enabled)
+}
+
+// RateLimitCfg limits the requests of each client with the rules inlined in the config and the ones of a file, that is
+// reloaded when it changes, trusting the X-Forwarded-For header of the proxies in the CIDRs
+type RateLimitCfg struct {
+	Rules          json.RawMessage `json:"rules"`
+	RulesFile      string          `json:"rules-file"`
+	ReloadInterval int             `json:"reload-interval"`
+	MaxClients     int             `json:"max-clients"`
+	TrustedProxies []string        `json:"trusted-proxies"`
+}
+
+const (
+	defaultRateLimitReloadInterval = 30000
+	defaultRateLimitMaxClients     = 10000
+)
+
+func (cfg RateLimitCfg) IsEnabled() bool {
+	return cfg.RulesFile != "" || len(cfg.Rules) != 0
+}
+
+// Proxies gets the networks of the trusted proxies, a single address is taken as a network of one
+func (cfg RateLimitCfg) Proxies() []*net.IPNet {
+	proxies := make([]*net.IPNet, 0, len(cfg.TrustedProxies))
+	for _, proxy := range cfg.TrustedProxies {
+		if !strings.Contains(proxy, "/") {
+			if ip := net.ParseIP(proxy); ip != nil && ip.To4() != nil {
+				proxy += "/32"
+			} else {
+				proxy += "/128"
+			}
+		}
+		if _, network, err := net.ParseCIDR(proxy); err == nil {
+			proxies = append(proxies, network)
+		}
+	}
+	return proxies
+}
+
+func (cfg RateLimitCfg) isValid() bool {
+	return !cfg.IsEnabled() || (cfg.ReloadInterval >= 0 && cfg.MaxClients > 0 &&
+		len(cfg.Proxies()) == len(cfg.TrustedProxies))
+}
+
+// HealthCfg are the thresholds of the readiness checks, durations are in milliseconds and the free disk in megabytes
+type HealthCfg struct {
+	Timeout           int `json:"timeout"`
+	CacheTtl          int `json:"cache-ttl"`
+	MaxPoolSaturation int `json:"max-pool-saturation"`
+	MinFreeDisk       int `json:"min-free-disk"`
+	MaxWebhookBacklog int `json:"max-webhook-backlog"`
+}
+
+const (
+	defaultHealthTimeout           = 2000
+	defaultHealthCacheTtl          = 1000
+	defaultHealthMaxPoolSaturation = 90
+	defaultHealthMinFreeDisk       = 100
+	defaultHealthMaxWebhookBacklog = 1000
+)
+
+func (cfg HealthCfg) isValid() bool {
+	return cfg.Timeout > 0 && cfg.CacheTtl >= 0 && cfg.MaxPoolSaturation > 0 && cfg.MaxPoolSaturation <= 100 &&
+		cfg.MinFreeDisk >= 0 && cfg.MaxWebhookBacklog > 0
+}
+
 type CfgData struct {
-	Server ServerCfg `json:"server"`
-	Store  StoreCfg  `json:"store"`
+	Server    ServerCfg    `json:"server"`
+	Store     StoreCfg     `json:"store"`
+	Photos    PhotosCfg    `json:"photos"`
+	Webhooks  WebhooksCfg  `json:"webhooks"`
+	Graphql   GraphqlCfg   `json:"graphql"`
+	Auth      AuthCfg      `json:"auth"`
+	Health    HealthCfg    `json:"health"`
+	RateLimit RateLimitCfg `json:"rate-limit"`
 }
 
 func (cfg CfgData) isValid() bool {
-	return cfg.Server.isValid() && cfg.Store.isValid()
+	return cfg.Server.isValid() && cfg.Store.isValid() && cfg.Photos.isValid() && cfg.Webhooks.isValid() &&
+		cfg.Graphql.isValid() && cfg.Auth.isValid() && cfg.Health.isValid() &&
+		cfg.RateLimit.isValid()
+}
+
+const (
+	redacted = "[redacted]"
+)
+
+// Redacted gets a copy of the configuration without the secrets, to show it
+func (cfg CfgData) Redacted() CfgData {
+	if cfg.Store.Postgresql.Password != "" {
+		cfg.Store.Postgresql.Password = redacted
+	}
+	if cfg.Auth.BootstrapKey != "" {
+		cfg.Auth.BootstrapKey = redacted
+	}
+	if len(cfg.Auth.Jwt.Jwks) != 0 {
+		cfg.Auth.Jwt.Jwks = json.RawMessage(strconv.Quote(redacted))
+	}
+	return cfg
 }
 
 func GetConfig(path string) (CfgData, error) {
 	cfg := CfgData{
-		Server: ServerCfg{},
-		Store:  StoreCfg{},
+		Server: ServerCfg{
+			SocketMode:      defaultSocketMode,
+			AdminAddress:    defaultAdminAddress,
+			LogLevel:        logging.Info,
+			DrainPeriod:     defaultDrainPeriod,
+			ShutdownTimeout: defaultShutdownTimeout,
+			Tls: TlsCfg{
+				MinVersion:     defaultTlsMinVersion,
+				ClientAuth:     defaultTlsClientAuth,
+				ReloadInterval: defaultTlsReloadInterval,
+			},
+		},
+		Store: StoreCfg{
+			Outbox: OutboxCfg{
+				Interval:  defaultOutboxInterval,
+				BatchSize: defaultOutboxBatchSize,
+				Timeout:   defaultOutboxTimeout,
+			},
+		},
+		Photos: PhotosCfg{
+			Layout:    defaultLayout,
+			MaxSize:   defaultMaxSize,
+			MaxPixels: defaultMaxPixels,
+			ThumbSize: defaultThumbMax,
+		},
+		Webhooks: WebhooksCfg{
+			Timeout:     defaultWebhookTimeout,
+			BatchSize:   defaultWebhookBatchSize,
+			MaxAttempts: defaultWebhookMaxAttempts,
+			MaxFailures: defaultWebhookMaxFailures,
+			Backoff:     defaultWebhookBackoff,
+			MaxBackoff:  defaultWebhookMaxBackoff,
+		},
+		Graphql: GraphqlCfg{
+			MaxDepth:      defaultGraphqlMaxDepth,
+			MaxComplexity: defaultGraphqlMaxComplexity,
+		},
+		Auth: AuthCfg{
+			Jwt: JwtCfg{
+				ClockSkew:      defaultJwtClockSkew,
+				ReloadInterval: defaultJwtReloadInterval,
+				RolesClaim:     defaultJwtRolesClaim,
+				OwnerClaim:     defaultJwtOwnerClaim,
+			},
+		},
+		Health: HealthCfg{
+			Timeout:           defaultHealthTimeout,
+			CacheTtl:          defaultHealthCacheTtl,
+			MaxPoolSaturation: defaultHealthMaxPoolSaturation,
+			MinFreeDisk:       defaultHealthMinFreeDisk,
+			MaxWebhookBacklog: defaultHealthMaxWebhookBacklog,
+		},
+		RateLimit: RateLimitCfg{
+			ReloadInterval: defaultRateLimitReloadInterval,
+			MaxClients:     defaultRateLimitMaxClients,
+		},
 	}
 
 	file, err := os.Open(path)
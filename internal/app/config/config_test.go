@@ -23,7 +23,10 @@
 package config
 
 import (
+	"crypto/tls"
+	"github.com/LearningByExample/go-microservice/internal/app/logging"
 	"path/filepath"
+	"reflect"
 	"testing"
 )
 
@@ -34,6 +37,28 @@ const (
 	badPostgreSQLFile = "bad-postgresql.json"
 	badFile           = "bad.json"
 	invalidFile       = "invalid.json"
+	photosFile        = "photos.json"
+	badPhotosFile     = "bad-photos.json"
+	webhooksFile      = "webhooks.json"
+	badWebhooksFile   = "bad-webhooks.json"
+	graphqlFile       = "graphql.json"
+	badGraphqlFile    = "bad-graphql.json"
+	authFile          = "auth.json"
+	badAuthFile       = "bad-auth.json"
+	jwtFile           = "jwt.json"
+	badJwtFile        = "bad-jwt.json"
+	outboxFile        = "outbox.json"
+	badOutboxFile     = "bad-outbox.json"
+	tlsFile           = "tls.json"
+	badTlsFile        = "bad-tls.json"
+	adminFile         = "admin.json"
+	badAdminFile      = "bad-admin.json"
+	healthFile        = "health.json"
+	socketFile        = "socket.json"
+	rateLimitFile     = "rate-limit.json"
+	badRateLimitFile  = "bad-rate-limit.json"
+	badSocketFile     = "bad-socket.json"
+	badHealthFile     = "bad-health.json"
 	wrongPath         = "wrong"
 )
 
@@ -93,3 +118,427 @@ func TestGetConfig(t *testing.T) {
 	})
 
 }
+
+func TestServerCfg(t *testing.T) {
+	t.Run("should have gRPC disabled by default", func(t *testing.T) {
+		cfg, _ := GetConfig(filepath.Join(testDataFolder, cfgFile))
+		if cfg.Server.IsGrpcEnabled() {
+			t.Fatal("want disabled got enabled")
+		}
+	})
+
+	t.Run("should have gRPC enabled with a port", func(t *testing.T) {
+		if !(ServerCfg{Port: 8080, GrpcPort: 9090}).IsGrpcEnabled() {
+			t.Fatal("want enabled got disabled")
+		}
+	})
+
+	t.Run("should fail with the same port for HTTP and gRPC", func(t *testing.T) {
+		if (ServerCfg{Port: 8080, GrpcPort: 8080}).isValid() {
+			t.Fatal("want invalid got valid")
+		}
+	})
+}
+
+func TestSocketCfg(t *testing.T) {
+	t.Run("should get default socket mode", func(t *testing.T) {
+		cfg, _ := GetConfig(filepath.Join(testDataFolder, cfgFile))
+		if cfg.Server.Socket != "" || cfg.Server.SocketActivation || cfg.Server.SocketFileMode() != 0660 {
+			t.Fatalf("got %v, want no sockets and mode 0660", cfg.Server)
+		}
+	})
+
+	t.Run("should get socket config without a port", func(t *testing.T) {
+		cfg, err := GetConfig(filepath.Join(testDataFolder, socketFile))
+		if err != nil || cfg.Server.Socket != "/run/go-microservice/http.sock" || !cfg.Server.SocketActivation ||
+			cfg.Server.SocketFileMode() != 0600 {
+			t.Fatalf("got %v and %v, want socket with mode 0600 and activation", cfg.Server, err)
+		}
+	})
+
+	t.Run("should fail with an invalid socket mode", func(t *testing.T) {
+		if _, err := GetConfig(filepath.Join(testDataFolder, badSocketFile)); err != InvalidCfg {
+			t.Fatalf("got %v, want %v", err, InvalidCfg)
+		}
+	})
+
+	t.Run("should fail without port nor sockets", func(t *testing.T) {
+		if (ServerCfg{SocketMode: "0660"}).isValid() {
+			t.Fatal("want invalid got valid")
+		}
+	})
+}
+
+func TestShutdownCfg(t *testing.T) {
+	t.Run("should get default drain period and shutdown timeout", func(t *testing.T) {
+		cfg, _ := GetConfig(filepath.Join(testDataFolder, cfgFile))
+		if cfg.Server.DrainPeriod != 5000 || cfg.Server.ShutdownTimeout != 20000 {
+			t.Fatalf("got %v, want drain period 5000 and shutdown timeout 20000", cfg.Server)
+		}
+	})
+
+	t.Run("should get shutdown config", func(t *testing.T) {
+		cfg, err := GetConfig(filepath.Join(testDataFolder, adminFile))
+		if err != nil || cfg.Server.DrainPeriod != 0 || cfg.Server.ShutdownTimeout != 1000 {
+			t.Fatalf("got %v and %v, want drain period 0 and shutdown timeout 1000", cfg.Server, err)
+		}
+	})
+
+	t.Run("should fail with a negative drain period", func(t *testing.T) {
+		if (ServerCfg{Port: 8080, DrainPeriod: -1}).isValid() {
+			t.Fatal("want invalid got valid")
+		}
+	})
+}
+
+func TestAdminCfg(t *testing.T) {
+	t.Run("should have the admin port disabled by default", func(t *testing.T) {
+		cfg, _ := GetConfig(filepath.Join(testDataFolder, cfgFile))
+		if cfg.Server.IsAdminEnabled() || cfg.Server.LogLevel != logging.Info {
+			t.Fatalf("got %v, want admin disabled and %q log level", cfg.Server, logging.Info)
+		}
+	})
+
+	t.Run("should get admin config", func(t *testing.T) {
+		cfg, err := GetConfig(filepath.Join(testDataFolder, adminFile))
+		if err != nil || cfg.Server.AdminPort != 9000 || cfg.Server.LogLevel != logging.Error {
+			t.Fatalf("got %v and %v, want admin port 9000 and %q log level", cfg.Server, err, logging.Error)
+		}
+		if got := cfg.Server.AdminAddr(); got != "127.0.0.1:9000" {
+			t.Fatalf("got %q, want admin bound to the loopback interface by default", got)
+		}
+	})
+
+	t.Run("should bind the admin port to the admin address", func(t *testing.T) {
+		if got := (ServerCfg{AdminPort: 9000, AdminAddress: "::1"}).AdminAddr(); got != "[::1]:9000" {
+			t.Fatalf("got %q, want %q", got, "[::1]:9000")
+		}
+		if got := (ServerCfg{AdminPort: 9000}).AdminAddr(); got != ":9000" {
+			t.Fatalf("got %q, want %q", got, ":9000")
+		}
+	})
+
+	t.Run("should fail with an invalid admin address", func(t *testing.T) {
+		if (ServerCfg{Port: 8080, AdminPort: 9000, AdminAddress: "admin.local", LogLevel: logging.Info}).isValid() {
+			t.Fatal("want invalid got valid")
+		}
+	})
+
+	t.Run("should fail with the same port for the service and the admin", func(t *testing.T) {
+		if _, err := GetConfig(filepath.Join(testDataFolder, badAdminFile)); err != InvalidCfg {
+			t.Fatalf("got %v, want %v", err, InvalidCfg)
+		}
+	})
+
+	t.Run("should fail with an invalid log level", func(t *testing.T) {
+		if (ServerCfg{Port: 8080, LogLevel: "verbose"}).isValid() {
+			t.Fatal("want invalid got valid")
+		}
+	})
+}
+
+func TestRedacted(t *testing.T) {
+	cfg := CfgData{
+		Store: StoreCfg{Postgresql: PostgreSQLCfg{User: "petuser", Password: "petpwd"}},
+		Auth:  AuthCfg{BootstrapKey: "pk_0123456789abcdef0123456789abcdef", Jwt: JwtCfg{Jwks: []byte(`{"keys":[]}`)}},
+	}
+	got := cfg.Redacted()
+
+	if got.Store.Postgresql.Password != redacted || got.Auth.BootstrapKey != redacted ||
+		string(got.Auth.Jwt.Jwks) != `"[redacted]"` || got.Store.Postgresql.User != "petuser" {
+		t.Fatalf("got %v, want secrets redacted", got)
+	}
+	if cfg.Store.Postgresql.Password != "petpwd" {
+		t.Fatalf("got %q, want original config unchanged", cfg.Store.Postgresql.Password)
+	}
+	if empty := (CfgData{}).Redacted(); empty.Auth.BootstrapKey != "" || len(empty.Auth.Jwt.Jwks) != 0 {
+		t.Fatalf("got %v, want empty secrets kept empty", empty)
+	}
+}
+
+func TestTlsCfg(t *testing.T) {
+	t.Run("should be disabled by default", func(t *testing.T) {
+		cfg, _ := GetConfig(filepath.Join(testDataFolder, cfgFile))
+		if cfg.Server.Tls.IsEnabled() {
+			t.Fatal("want disabled got enabled")
+		}
+	})
+
+	t.Run("should get tls config with defaults", func(t *testing.T) {
+		cfg, err := GetConfig(filepath.Join(testDataFolder, tlsFile))
+		want := TlsCfg{CertFile: "/etc/pets/tls.crt", KeyFile: "/etc/pets/tls.key", MinVersion: "1.3",
+			CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}, ClientCaFile: "/etc/pets/ca.crt",
+			ClientAuth: ClientAuthRequire, ReloadInterval: 30000}
+		if err != nil || !reflect.DeepEqual(cfg.Server.Tls, want) {
+			t.Fatalf("got %v and %v, want %v", cfg.Server.Tls, err, want)
+		}
+		if cfg.Server.Tls.Version() != tls.VersionTLS13 {
+			t.Fatalf("got version %x, want %x", cfg.Server.Tls.Version(), tls.VersionTLS13)
+		}
+		ciphers := cfg.Server.Tls.Ciphers()
+		if len(ciphers) != 1 || ciphers[0] != tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 {
+			t.Fatalf("got ciphers %v, want %v", ciphers, tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256)
+		}
+	})
+
+	t.Run("should fail with an insecure cipher suite", func(t *testing.T) {
+		if _, err := GetConfig(filepath.Join(testDataFolder, badTlsFile)); err != InvalidCfg {
+			t.Fatalf("got %v, want %v", err, InvalidCfg)
+		}
+	})
+
+	t.Run("should fail with invalid settings", func(t *testing.T) {
+		cases := map[string]TlsCfg{
+			"key without certificate": {KeyFile: "tls.key"},
+			"certificate without key": {CertFile: "tls.crt", MinVersion: "1.2", ClientAuth: ClientAuthRequire},
+			"unknown version": {CertFile: "tls.crt", KeyFile: "tls.key", MinVersion: "2.0",
+				ClientAuth: ClientAuthRequire},
+			"unknown client auth": {CertFile: "tls.crt", KeyFile: "tls.key", MinVersion: "1.2", ClientAuth: "maybe"},
+		}
+		for name, cfg := range cases {
+			if cfg.isValid() {
+				t.Fatalf("got valid, want invalid with %s", name)
+			}
+		}
+	})
+}
+
+func TestPurgeCfg(t *testing.T) {
+	t.Run("should be disabled without interval", func(t *testing.T) {
+		if (PurgeCfg{Retention: 1}).IsEnabled() {
+			t.Fatal("want disabled got enabled")
+		}
+	})
+
+	t.Run("should be enabled with retention and interval", func(t *testing.T) {
+		if !(PurgeCfg{Retention: 1, Interval: 1}).IsEnabled() {
+			t.Fatal("want enabled got disabled")
+		}
+	})
+
+	t.Run("should fail with negative retention or interval", func(t *testing.T) {
+		cases := map[string]StoreCfg{
+			"negative retention": {Name: "in-memory", Purge: PurgeCfg{Retention: -1, Interval: 1}},
+			"negative interval":  {Name: "in-memory", Purge: PurgeCfg{Retention: 1, Interval: -1}},
+		}
+		for name, cfg := range cases {
+			if cfg.isValid() {
+				t.Fatalf("got valid, want invalid with %s", name)
+			}
+		}
+	})
+}
+
+func TestPhotosCfg(t *testing.T) {
+	t.Run("should be disabled by default", func(t *testing.T) {
+		cfg, _ := GetConfig(filepath.Join(testDataFolder, cfgFile))
+		if cfg.Photos.IsEnabled() {
+			t.Fatal("want disabled got enabled")
+		}
+	})
+
+	t.Run("should get photos config", func(t *testing.T) {
+		cfg, err := GetConfig(filepath.Join(testDataFolder, photosFile))
+		want := PhotosCfg{Path: "/tmp/pet-photos", Layout: "pets/{id}-{size}", MaxSize: 1048576, MaxPixels: 4000000,
+			ThumbSize: 64}
+		if err != nil || cfg.Photos != want {
+			t.Fatalf("got %v and %v, want %v", cfg.Photos, err, want)
+		}
+	})
+
+	t.Run("should fail with a layout without size", func(t *testing.T) {
+		if _, err := GetConfig(filepath.Join(testDataFolder, badPhotosFile)); err != InvalidCfg {
+			t.Fatalf("got %v, want %v", err, InvalidCfg)
+		}
+	})
+}
+
+func TestWebhooksCfg(t *testing.T) {
+	t.Run("should be disabled by default", func(t *testing.T) {
+		cfg, _ := GetConfig(filepath.Join(testDataFolder, cfgFile))
+		if cfg.Webhooks.IsEnabled() {
+			t.Fatal("want disabled got enabled")
+		}
+	})
+
+	t.Run("should get webhooks config with defaults", func(t *testing.T) {
+		cfg, err := GetConfig(filepath.Join(testDataFolder, webhooksFile))
+		want := WebhooksCfg{Interval: 1000, Timeout: 2000, BatchSize: 50, MaxAttempts: 5, MaxFailures: 20,
+			Backoff: 1000, MaxBackoff: 3600000}
+		if err != nil || cfg.Webhooks != want {
+			t.Fatalf("got %v and %v, want %v", cfg.Webhooks, err, want)
+		}
+	})
+
+	t.Run("should fail with a max backoff lower than the backoff", func(t *testing.T) {
+		if _, err := GetConfig(filepath.Join(testDataFolder, badWebhooksFile)); err != InvalidCfg {
+			t.Fatalf("got %v, want %v", err, InvalidCfg)
+		}
+	})
+}
+
+func TestOutboxCfg(t *testing.T) {
+	t.Run("should be disabled by default", func(t *testing.T) {
+		cfg, _ := GetConfig(filepath.Join(testDataFolder, cfgFile))
+		if cfg.Store.Outbox.IsEnabled() {
+			t.Fatal("want disabled got enabled")
+		}
+	})
+
+	t.Run("should get outbox config with defaults", func(t *testing.T) {
+		cfg, err := GetConfig(filepath.Join(testDataFolder, outboxFile))
+		want := OutboxCfg{Publisher: FilePublisher, Path: "/tmp/outbox.ndjson", Interval: 1000, BatchSize: 10,
+			Timeout: 5000}
+		if err != nil || cfg.Store.Outbox != want {
+			t.Fatalf("got %v and %v, want %v", cfg.Store.Outbox, err, want)
+		}
+	})
+
+	t.Run("should fail with an http publisher without url", func(t *testing.T) {
+		if _, err := GetConfig(filepath.Join(testDataFolder, badOutboxFile)); err != InvalidCfg {
+			t.Fatalf("got %v, want %v", err, InvalidCfg)
+		}
+	})
+
+	t.Run("should fail with an unknown publisher", func(t *testing.T) {
+		if (OutboxCfg{Publisher: "kafka", Interval: 1, BatchSize: 1, Timeout: 1}).isValid() {
+			t.Fatal("want invalid got valid")
+		}
+	})
+}
+
+func TestGraphqlCfg(t *testing.T) {
+	t.Run("should get default limits", func(t *testing.T) {
+		cfg, _ := GetConfig(filepath.Join(testDataFolder, cfgFile))
+		want := GraphqlCfg{MaxDepth: 10, MaxComplexity: 1000}
+		if cfg.Graphql != want {
+			t.Fatalf("got %v, want %v", cfg.Graphql, want)
+		}
+	})
+
+	t.Run("should get graphql config", func(t *testing.T) {
+		cfg, err := GetConfig(filepath.Join(testDataFolder, graphqlFile))
+		want := GraphqlCfg{MaxDepth: 6, MaxComplexity: 1000}
+		if err != nil || cfg.Graphql != want {
+			t.Fatalf("got %v and %v, want %v", cfg.Graphql, err, want)
+		}
+	})
+
+	t.Run("should fail without a positive max complexity", func(t *testing.T) {
+		if _, err := GetConfig(filepath.Join(testDataFolder, badGraphqlFile)); err != InvalidCfg {
+			t.Fatalf("got %v, want %v", err, InvalidCfg)
+		}
+	})
+}
+
+func TestHealthCfg(t *testing.T) {
+	t.Run("should get default thresholds", func(t *testing.T) {
+		cfg, _ := GetConfig(filepath.Join(testDataFolder, cfgFile))
+		want := HealthCfg{Timeout: 2000, CacheTtl: 1000, MaxPoolSaturation: 90, MinFreeDisk: 100, MaxWebhookBacklog: 1000}
+		if cfg.Health != want {
+			t.Fatalf("got %v, want %v", cfg.Health, want)
+		}
+	})
+
+	t.Run("should get health config", func(t *testing.T) {
+		cfg, err := GetConfig(filepath.Join(testDataFolder, healthFile))
+		want := HealthCfg{Timeout: 2000, CacheTtl: 0, MaxPoolSaturation: 75, MinFreeDisk: 500, MaxWebhookBacklog: 1000}
+		if err != nil || cfg.Health != want {
+			t.Fatalf("got %v and %v, want %v", cfg.Health, err, want)
+		}
+	})
+
+	t.Run("should fail with a pool saturation over 100", func(t *testing.T) {
+		if _, err := GetConfig(filepath.Join(testDataFolder, badHealthFile)); err != InvalidCfg {
+			t.Fatalf("got %v, want %v", err, InvalidCfg)
+		}
+	})
+}
+
+func TestRateLimitCfg(t *testing.T) {
+	t.Run("should have rate limit disabled by default", func(t *testing.T) {
+		cfg, _ := GetConfig(filepath.Join(testDataFolder, cfgFile))
+		if cfg.RateLimit.IsEnabled() || cfg.RateLimit.MaxClients != 10000 || cfg.RateLimit.ReloadInterval != 30000 {
+			t.Fatalf("got %v, want disabled with 10000 clients and 30000 reload interval", cfg.RateLimit)
+		}
+	})
+
+	t.Run("should get rate limit config", func(t *testing.T) {
+		cfg, err := GetConfig(filepath.Join(testDataFolder, rateLimitFile))
+		if err != nil || !cfg.RateLimit.IsEnabled() || cfg.RateLimit.MaxClients != 100 {
+			t.Fatalf("got %v and %v, want enabled with 100 clients", cfg.RateLimit, err)
+		}
+		proxies := cfg.RateLimit.Proxies()
+		if len(proxies) != 2 || proxies[0].String() != "10.0.0.0/8" || proxies[1].String() != "127.0.0.1/32" {
+			t.Fatalf("got %v, want the trusted proxies", proxies)
+		}
+	})
+
+	t.Run("should fail with an invalid trusted proxy", func(t *testing.T) {
+		if _, err := GetConfig(filepath.Join(testDataFolder, badRateLimitFile)); err != InvalidCfg {
+			t.Fatalf("got %v, want %v", err, InvalidCfg)
+		}
+	})
+}
+
+func TestAuthCfg(t *testing.T) {
+	t.Run("should be disabled by default", func(t *testing.T) {
+		cfg, _ := GetConfig(filepath.Join(testDataFolder, cfgFile))
+		if cfg.Auth.Enabled {
+			t.Fatal("want disabled got enabled")
+		}
+	})
+
+	t.Run("should get auth config", func(t *testing.T) {
+		cfg, err := GetConfig(filepath.Join(testDataFolder, authFile))
+		want := AuthCfg{Enabled: true, BootstrapKey: "pk_0123456789abcdef0123456789abcdef",
+			Jwt:         JwtCfg{ClockSkew: 60000, ReloadInterval: 30000, RolesClaim: "roles", OwnerClaim: "owner_id"},
+			ClientCerts: map[string][]string{"CN=john,O=Pets": {"pets:read"}}}
+		if err != nil || !reflect.DeepEqual(cfg.Auth, want) {
+			t.Fatalf("got %v and %v, want %v", cfg.Auth, err, want)
+		}
+	})
+
+	t.Run("should fail with a policy without authentication", func(t *testing.T) {
+		if (AuthCfg{PolicyFile: "policy.json"}).isValid() {
+			t.Fatal("want invalid got valid")
+		}
+	})
+
+	t.Run("should fail with a short bootstrap key", func(t *testing.T) {
+		if _, err := GetConfig(filepath.Join(testDataFolder, badAuthFile)); err != InvalidCfg {
+			t.Fatalf("got %v, want %v", err, InvalidCfg)
+		}
+	})
+}
+
+func TestJwtCfg(t *testing.T) {
+	t.Run("should be disabled by default", func(t *testing.T) {
+		cfg, _ := GetConfig(filepath.Join(testDataFolder, cfgFile))
+		if cfg.Auth.Jwt.IsEnabled() {
+			t.Fatal("want disabled got enabled")
+		}
+	})
+
+	t.Run("should get jwt config with defaults", func(t *testing.T) {
+		cfg, err := GetConfig(filepath.Join(testDataFolder, jwtFile))
+		want := JwtCfg{JwksFile: "/etc/pets/jwks.json", Issuer: "https://gateway.example.com", Audience: "pets",
+			ClockSkew: 60000, ReloadInterval: 30000, RolesClaim: "roles", OwnerClaim: "owner_id",
+			Roles: map[string][]string{"viewer": {"pets:read"}, "editor": {"pets:read", "pets:write"}}}
+		if err != nil || !reflect.DeepEqual(cfg.Auth.Jwt, want) {
+			t.Fatalf("got %v and %v, want %v", cfg.Auth.Jwt, err, want)
+		}
+	})
+
+	t.Run("should be enabled with inlined keys", func(t *testing.T) {
+		if !(JwtCfg{Jwks: []byte(`{"keys":[]}`)}).IsEnabled() {
+			t.Fatal("want enabled got disabled")
+		}
+	})
+
+	t.Run("should fail with a negative clock skew", func(t *testing.T) {
+		if _, err := GetConfig(filepath.Join(testDataFolder, badJwtFile)); err != InvalidCfg {
+			t.Fatalf("got %v, want %v", err, InvalidCfg)
+		}
+	})
+}
@@ -26,4 +26,29 @@ const (
 	ContentType         = "Content-Type"
 	ApplicationJsonUtf8 = "application/json; charset=utf-8"
 	Location            = "Location"
+	Accept              = "Accept"
+	ApplicationJson     = "application/json"
+	ApplicationNDJson   = "application/x-ndjson"
+	TextCsv             = "text/csv"
+	TextCsvUtf8         = "text/csv; charset=utf-8"
+	RequestId           = "X-Request-Id"
+	CacheControl        = "Cache-Control"
+	ETag                = "ETag"
+	MultipartFormData   = "multipart/form-data"
+	ImageJpeg           = "image/jpeg"
+	ImagePng            = "image/png"
+	TextEventStream     = "text/event-stream"
+	TextMetrics         = "text/plain; version=0.0.4; charset=utf-8"
+	LastEventId         = "Last-Event-ID"
+	WebhookSignature    = "X-Webhook-Signature"
+	WebhookEvent        = "X-Webhook-Event"
+	WebhookDelivery     = "X-Webhook-Delivery"
+	ApiKey              = "X-Api-Key"
+	Authorization       = "Authorization"
+	WwwAuthenticate     = "WWW-Authenticate"
+	XForwardedFor       = "X-Forwarded-For"
+	RetryAfter          = "Retry-After"
+	RateLimitLimit      = "RateLimit-Limit"
+	RateLimitRemaining  = "RateLimit-Remaining"
+	RateLimitReset      = "RateLimit-Reset"
 )
@@ -23,21 +23,256 @@
 package data
 
 import (
+	"encoding/json"
 	"fmt"
 	"sort"
+	"time"
 )
 
 type Pet struct {
-	Id   int    `json:"id"`
-	Name string `json:"name"`
-	Race string `json:"race"`
-	Mod  string `json:"mod"`
+	Id         int               `json:"id"`
+	Name       string            `json:"name"`
+	Race       string            `json:"race"`
+	Mod        string            `json:"mod"`
+	Status     PetStatus         `json:"status"`
+	OwnerId    *int              `json:"ownerId,omitempty"`
+	Tags       []string          `json:"tags,omitempty"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	CreatedAt  time.Time         `json:"createdAt"`
+	UpdatedAt  time.Time         `json:"updatedAt"`
+	DeletedAt  *time.Time        `json:"deletedAt,omitempty"`
 }
 
 func (p Pet) String() string {
 	return fmt.Sprintf("{ Id: %d, Name: %q, Race: %q, Mod: %q }", p.Id, p.Name, p.Race, p.Mod)
 }
 
+func (p Pet) IsDeleted() bool {
+	return p.DeletedAt != nil
+}
+
+type PetStatus string
+
+const (
+	Available PetStatus = "available"
+	Reserved  PetStatus = "reserved"
+	Adopted   PetStatus = "adopted"
+	Returned  PetStatus = "returned"
+	Withdrawn PetStatus = "withdrawn"
+)
+
+var transitions = map[PetStatus][]PetStatus{
+	Available: {Reserved, Withdrawn},
+	Reserved:  {Adopted, Available, Withdrawn},
+	Adopted:   {Returned},
+	Returned:  {Available, Withdrawn},
+	Withdrawn: {Available},
+}
+
+func (s PetStatus) IsValid() bool {
+	_, found := transitions[s]
+	return found
+}
+
+func (s PetStatus) CanTransitionTo(to PetStatus) bool {
+	for _, next := range transitions[s] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+func (p Pet) Owner() int {
+	if p.OwnerId == nil {
+		return 0
+	}
+	return *p.OwnerId
+}
+
+func SortedTags(tags []string) []string {
+	if len(tags) == 0 {
+		return nil
+	}
+	sorted := append([]string{}, tags...)
+	sort.Strings(sorted)
+	result := sorted[:1]
+	for _, tag := range sorted[1:] {
+		if tag != result[len(result)-1] {
+			result = append(result, tag)
+		}
+	}
+	return result
+}
+
+func (p Pet) SameTags(tags []string, attributes map[string]string) bool {
+	if len(p.Tags) != len(tags) || len(p.Attributes) != len(attributes) {
+		return false
+	}
+	for i := range tags {
+		if p.Tags[i] != tags[i] {
+			return false
+		}
+	}
+	for key, value := range attributes {
+		if current, found := p.Attributes[key]; !found || current != value {
+			return false
+		}
+	}
+	return true
+}
+
+type Owner struct {
+	Id        int       `json:"id"`
+	Name      string    `json:"name"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+func (o Owner) String() string {
+	return fmt.Sprintf("{ Id: %d, Name: %q, Email: %q }", o.Id, o.Name, o.Email)
+}
+
+type Webhook struct {
+	Id        int       `json:"id"`
+	Url       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Secret    string    `json:"-"`
+	Enabled   bool      `json:"enabled"`
+	Failures  int       `json:"failures"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+func (w Webhook) Accepts(event string) bool {
+	for _, e := range w.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	ScopePetsRead  = "pets:read"
+	ScopePetsWrite = "pets:write"
+	ScopeAdmin     = "admin"
+)
+
+func IsValidScope(scope string) bool {
+	switch scope {
+	case ScopePetsRead, ScopePetsWrite, ScopeAdmin:
+		return true
+	}
+	return false
+}
+
+type ApiKey struct {
+	Id        int        `json:"id"`
+	Name      string     `json:"name"`
+	Prefix    string     `json:"prefix"`
+	Hash      string     `json:"-"`
+	Scopes    []string   `json:"scopes"`
+	CreatedAt time.Time  `json:"createdAt"`
+	RotatedAt *time.Time `json:"rotatedAt,omitempty"`
+	RevokedAt *time.Time `json:"revokedAt,omitempty"`
+}
+
+func (k ApiKey) IsRevoked() bool {
+	return k.RevokedAt != nil
+}
+
+func hasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// HasScope checks if the key grants a scope, admin keys grant all of them
+func (k ApiKey) HasScope(scope string) bool {
+	return hasScope(k.Scopes, scope)
+}
+
+// Principal is an authenticated caller, with the scopes granted by its roles and the owner it acts for if any
+type Principal struct {
+	Subject string
+	Roles   []string
+	Scopes  []string
+	OwnerId int
+}
+
+func (p Principal) HasScope(scope string) bool {
+	return hasScope(p.Scopes, scope)
+}
+
+type DeliveryStatus string
+
+const (
+	DeliveryPending   DeliveryStatus = "pending"
+	DeliveryDelivered DeliveryStatus = "delivered"
+	DeliveryFailed    DeliveryStatus = "failed"
+)
+
+type DeliveryAttempt struct {
+	At         time.Time `json:"at"`
+	StatusCode int       `json:"statusCode,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+func (a DeliveryAttempt) Succeeded() bool {
+	return a.Error == "" && a.StatusCode >= 200 && a.StatusCode < 300
+}
+
+type Delivery struct {
+	Id          int               `json:"id"`
+	WebhookId   int               `json:"webhookId"`
+	Event       string            `json:"event"`
+	Payload     string            `json:"payload"`
+	Status      DeliveryStatus    `json:"status"`
+	Attempts    []DeliveryAttempt `json:"attempts"`
+	NextAttempt time.Time         `json:"nextAttempt"`
+	CreatedAt   time.Time         `json:"createdAt"`
+}
+
+type PetAction string
+
+const (
+	CreateAction     PetAction = "create"
+	UpdateAction     PetAction = "update"
+	DeleteAction     PetAction = "delete"
+	RestoreAction    PetAction = "restore"
+	TransitionAction PetAction = "transition"
+)
+
+type PetChange struct {
+	Id        int       `json:"id"`
+	PetId     int       `json:"petId"`
+	Action    PetAction `json:"action"`
+	Timestamp time.Time `json:"timestamp"`
+	Actor     string    `json:"actor"`
+	RequestId string    `json:"requestId,omitempty"`
+	Before    *Pet      `json:"before,omitempty"`
+	After     *Pet      `json:"after,omitempty"`
+}
+
+type OutboxEvent struct {
+	Id        int64           `json:"id"`
+	PetId     int             `json:"petId"`
+	Type      PetAction       `json:"type"`
+	Payload   json.RawMessage `json:"payload"`
+	CreatedAt time.Time       `json:"createdAt"`
+}
+
+type PetMatch struct {
+	Pet        Pet               `json:"pet"`
+	Rank       float64           `json:"rank"`
+	Highlights map[string]string `json:"highlights,omitempty"`
+}
+
 type PetMap map[int]Pet
 
 func (pm PetMap) Values() []Pet {
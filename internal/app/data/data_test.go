@@ -61,3 +61,126 @@ func TestSortValues(t *testing.T) {
 		t.Fatalf("got %v want %v", got, want)
 	}
 }
+
+func TestPetOwner(t *testing.T) {
+	ownerId := 3
+
+	if got := (Pet{}).Owner(); got != 0 {
+		t.Fatalf("got owner %d want 0", got)
+	}
+	if got := (Pet{OwnerId: &ownerId}).Owner(); got != ownerId {
+		t.Fatalf("got owner %d want %d", got, ownerId)
+	}
+}
+
+func TestOwner(t *testing.T) {
+	owner := Owner{
+		Id:    1,
+		Name:  "a",
+		Email: "b",
+	}
+
+	got := owner.String()
+	want := "{ Id: 1, Name: \"a\", Email: \"b\" }"
+
+	if got != want {
+		t.Fatalf("error get owner string got %v, want %v", got, want)
+	}
+}
+
+func TestPetStatus(t *testing.T) {
+	type testCase struct {
+		from PetStatus
+		to   PetStatus
+		want bool
+	}
+
+	var cases = []testCase{
+		{from: Available, to: Reserved, want: true},
+		{from: Available, to: Adopted, want: false},
+		{from: Reserved, to: Adopted, want: true},
+		{from: Reserved, to: Available, want: true},
+		{from: Adopted, to: Returned, want: true},
+		{from: Adopted, to: Available, want: false},
+		{from: Returned, to: Available, want: true},
+		{from: Withdrawn, to: Available, want: true},
+		{from: Withdrawn, to: Reserved, want: false},
+		{from: "lost", to: Available, want: false},
+	}
+
+	for _, tt := range cases {
+		if got := tt.from.CanTransitionTo(tt.to); got != tt.want {
+			t.Fatalf("transition from %q to %q got %t, want %t", tt.from, tt.to, got, tt.want)
+		}
+	}
+
+	if !Adopted.IsValid() || PetStatus("lost").IsValid() {
+		t.Fatal("error validating pet status")
+	}
+}
+
+func TestPetTags(t *testing.T) {
+	if got := SortedTags([]string{}); got != nil {
+		t.Fatalf("got tags %v want nil", got)
+	}
+	if got, want := SortedTags([]string{"dog", "big", "dog"}), []string{"big", "dog"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got tags %v want %v", got, want)
+	}
+
+	pet := Pet{Tags: []string{"big", "dog"}, Attributes: map[string]string{"color": "black"}}
+	if !pet.SameTags([]string{"big", "dog"}, map[string]string{"color": "black"}) {
+		t.Fatalf("got different tags for %v", pet)
+	}
+	if pet.SameTags([]string{"big", "dog"}, map[string]string{"color": "white"}) {
+		t.Fatalf("got same attributes for %v", pet)
+	}
+	if pet.SameTags([]string{"big"}, map[string]string{"color": "black"}) {
+		t.Fatalf("got same tags for %v", pet)
+	}
+	if !(Pet{}).SameTags(nil, map[string]string{}) {
+		t.Fatal("got different tags for empty pet")
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	webhook := Webhook{Events: []string{"created", "deleted"}}
+	if !webhook.Accepts("deleted") {
+		t.Fatalf("got not accepted for %v", webhook)
+	}
+	if webhook.Accepts("updated") {
+		t.Fatalf("got accepted for %v", webhook)
+	}
+
+	type testCase struct {
+		attempt DeliveryAttempt
+		want    bool
+	}
+	for _, tt := range []testCase{
+		{attempt: DeliveryAttempt{StatusCode: 204}, want: true},
+		{attempt: DeliveryAttempt{StatusCode: 302}, want: false},
+		{attempt: DeliveryAttempt{StatusCode: 500}, want: false},
+		{attempt: DeliveryAttempt{Error: "timeout"}, want: false},
+	} {
+		if got := tt.attempt.Succeeded(); got != tt.want {
+			t.Fatalf("got %v for %v, want %v", got, tt.attempt, tt.want)
+		}
+	}
+}
+
+func TestApiKey(t *testing.T) {
+	key := ApiKey{Scopes: []string{ScopePetsRead}}
+	if !key.HasScope(ScopePetsRead) || key.HasScope(ScopePetsWrite) || key.HasScope(ScopeAdmin) {
+		t.Fatalf("got wrong scopes for %v", key)
+	}
+	admin := ApiKey{Scopes: []string{ScopeAdmin}}
+	if !admin.HasScope(ScopePetsWrite) || !admin.HasScope(ScopeAdmin) {
+		t.Fatalf("got missing scopes for %v", admin)
+	}
+	principal := Principal{Subject: "john", Roles: []string{"viewer"}, Scopes: []string{ScopePetsRead}}
+	if !principal.HasScope(ScopePetsRead) || principal.HasScope(ScopePetsWrite) {
+		t.Fatalf("got wrong scopes for %v", principal)
+	}
+	if IsValidScope("pets:delete") || !IsValidScope(ScopeAdmin) {
+		t.Fatal("got wrong valid scopes")
+	}
+}
@@ -0,0 +1,191 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package events
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"sync"
+	"time"
+)
+
+type Type string
+
+const (
+	Created Type = "created"
+	Updated Type = "updated"
+	Deleted Type = "deleted"
+	// Resync is sent instead of the replay when the missed events are no longer buffered, or the last id is from
+	// another process, the subscriber should reload the pets and resume from the id of the resync event
+	Resync Type = "resync"
+)
+
+const (
+	DefaultReplaySize = 1000
+	subscriberBuffer  = 64
+	epochShift        = 32
+)
+
+type Event struct {
+	Id    int64 `json:"-"`
+	Type  Type  `json:"type"`
+	PetId int   `json:"petId"`
+}
+
+type Publisher func(t Type, petId int)
+
+// Broker numbers the published events, keeps the last ones for replaying them to reconnecting subscribers and
+// fans them out to the live subscribers. Subscribers that do not keep up are dropped, they could resume from the
+// replay buffer using the id of the last event they got. Ids are prefixed with a random epoch of the process, so
+// ids from a previous run or from another replica are detected and answered with a resync.
+type Broker struct {
+	mu          sync.Mutex
+	epoch       int64
+	lastId      int64
+	size        int
+	replay      []Event
+	subscribers map[*Subscription]bool
+	closed      bool
+}
+
+type Subscription struct {
+	Events <-chan Event
+	Replay []Event
+	ch     chan Event
+	broker *Broker
+}
+
+func (b *Broker) Publish(t Type, petId int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+
+	b.lastId++
+	event := Event{Id: b.lastId, Type: t, PetId: petId}
+	if b.size > 0 {
+		if len(b.replay) == b.size {
+			b.replay = append(b.replay[:0], b.replay[1:]...)
+		}
+		b.replay = append(b.replay, event)
+	}
+
+	for sub := range b.subscribers {
+		select {
+		case sub.ch <- event:
+		default:
+			delete(b.subscribers, sub)
+			close(sub.ch)
+		}
+	}
+}
+
+// replayable tells if all the events after lastId are still buffered
+func (b *Broker) replayable(lastId int64) bool {
+	if lastId>>epochShift != b.epoch>>epochShift || lastId > b.lastId {
+		return false
+	}
+	oldest := b.lastId
+	if len(b.replay) > 0 {
+		oldest = b.replay[0].Id - 1
+	}
+	return lastId >= oldest
+}
+
+// Subscribe returns a subscription that replays the buffered events after lastId, if any, and then gets the new ones.
+// When those events are not buffered the replay is a single resync event.
+func (b *Broker) Subscribe(lastId int64) *Subscription {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ch := make(chan Event, subscriberBuffer)
+	sub := &Subscription{Events: ch, ch: ch, broker: b}
+	if lastId > 0 && !b.replayable(lastId) {
+		sub.Replay = []Event{{Id: b.lastId, Type: Resync}}
+	} else if lastId > 0 {
+		for _, event := range b.replay {
+			if event.Id > lastId {
+				sub.Replay = append(sub.Replay, event)
+			}
+		}
+	}
+	if b.closed {
+		close(ch)
+	} else {
+		b.subscribers[sub] = true
+	}
+	return sub
+}
+
+func (s *Subscription) Close() {
+	b := s.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subscribers[s] {
+		delete(b.subscribers, s)
+		close(s.ch)
+	}
+}
+
+// Close ends all the subscriptions, it should be called before shutting down the server so open streams finish.
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for sub := range b.subscribers {
+		delete(b.subscribers, sub)
+		close(sub.ch)
+	}
+}
+
+// LastId returns the id of the last published event, or the epoch when there is none.
+func (b *Broker) LastId() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.lastId
+}
+
+// newEpoch returns a random, never zero, epoch shifted over the event sequence
+func newEpoch() int64 {
+	var bytes [4]byte
+	var epoch = uint32(time.Now().UnixNano())
+	if _, err := rand.Read(bytes[:]); err == nil {
+		epoch = binary.BigEndian.Uint32(bytes[:])
+	}
+	return int64(epoch>>1|1) << epochShift
+}
+
+func newBroker(size int, epoch int64) *Broker {
+	return &Broker{
+		epoch:       epoch,
+		lastId:      epoch,
+		size:        size,
+		replay:      make([]Event, 0, size),
+		subscribers: make(map[*Subscription]bool),
+	}
+}
+
+func NewBroker(size int) *Broker {
+	return newBroker(size, newEpoch())
+}
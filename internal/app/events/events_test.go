@@ -0,0 +1,271 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/LearningByExample/go-microservice/internal/app/config"
+	"github.com/LearningByExample/go-microservice/internal/app/data"
+	"github.com/LearningByExample/go-microservice/internal/app/store"
+	"github.com/LearningByExample/go-microservice/internal/app/store/memory"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func receive(t *testing.T, sub *Subscription) Event {
+	t.Helper()
+	select {
+	case event, ok := <-sub.Events:
+		if !ok {
+			t.Fatal("subscription closed")
+		}
+		return event
+	case <-time.After(time.Second):
+		t.Fatal("event not received")
+	}
+	return Event{}
+}
+
+func TestBroker(t *testing.T) {
+	t.Run("should publish to subscribers", func(t *testing.T) {
+		b := newBroker(10, 0)
+		sub := b.Subscribe(0)
+		defer sub.Close()
+
+		b.Publish(Created, 3)
+		b.Publish(Deleted, 3)
+
+		if got, want := receive(t, sub), (Event{Id: 1, Type: Created, PetId: 3}); got != want {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+		if got, want := receive(t, sub), (Event{Id: 2, Type: Deleted, PetId: 3}); got != want {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("should replay events after the last id", func(t *testing.T) {
+		b := newBroker(2, 0)
+		for i := 1; i <= 4; i++ {
+			b.Publish(Updated, i)
+		}
+
+		want := []Event{{Id: 3, Type: Updated, PetId: 3}, {Id: 4, Type: Updated, PetId: 4}}
+		if got := b.Subscribe(2).Replay; !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+		if got := b.Subscribe(3).Replay; !reflect.DeepEqual(got, want[1:]) {
+			t.Fatalf("got %v, want %v", got, want[1:])
+		}
+		if got := b.Subscribe(4).Replay; len(got) != 0 {
+			t.Fatalf("got %v, want no replay", got)
+		}
+		if got := b.Subscribe(0).Replay; len(got) != 0 {
+			t.Fatalf("got %v, want no replay", got)
+		}
+	})
+
+	t.Run("should resync when the missed events are not buffered", func(t *testing.T) {
+		epoch := int64(5) << epochShift
+		b := newBroker(2, epoch)
+		for i := 1; i <= 4; i++ {
+			b.Publish(Updated, i)
+		}
+
+		want := []Event{{Id: epoch + 4, Type: Resync}}
+		for _, lastId := range []int64{epoch + 1, epoch + 5, 4, int64(6)<<epochShift + 3} {
+			if got := b.Subscribe(lastId).Replay; !reflect.DeepEqual(got, want) {
+				t.Fatalf("got %v for %d, want %v", got, lastId, want)
+			}
+		}
+		if got := b.Subscribe(epoch + 2).Replay; len(got) != 2 || got[0].Id != epoch+3 {
+			t.Fatalf("got %v, want replay from %d", got, epoch+3)
+		}
+	})
+
+	t.Run("should number events after a random epoch", func(t *testing.T) {
+		b := NewBroker(10)
+		b.Publish(Created, 1)
+
+		if got := b.LastId(); got>>epochShift == 0 || got != b.epoch+1 {
+			t.Fatalf("got id %d, want id 1 after epoch %d", got, b.epoch)
+		}
+		if other := NewBroker(10); other.epoch == b.epoch {
+			t.Fatalf("got epoch %d twice, want different epochs", b.epoch)
+		}
+	})
+
+	t.Run("should drop slow subscribers", func(t *testing.T) {
+		b := newBroker(0, 0)
+		sub := b.Subscribe(0)
+		for i := 0; i <= subscriberBuffer; i++ {
+			b.Publish(Updated, i)
+		}
+
+		count := 0
+		for range sub.Events {
+			count++
+		}
+		if count != subscriberBuffer {
+			t.Fatalf("got %d events, want %d", count, subscriberBuffer)
+		}
+		sub.Close()
+	})
+
+	t.Run("should end subscriptions when closed", func(t *testing.T) {
+		b := newBroker(10, 0)
+		sub := b.Subscribe(0)
+		b.Close()
+		b.Publish(Created, 1)
+
+		if _, ok := <-sub.Events; ok {
+			t.Fatal("want subscription closed")
+		}
+		if _, ok := <-b.Subscribe(0).Events; ok {
+			t.Fatal("want new subscription closed")
+		}
+	})
+}
+
+type spyNotifier struct {
+	payloads []string
+	listen   func(ctx context.Context, fn func(payload string)) error
+}
+
+func (n *spyNotifier) NotifyEvent(payload string) error {
+	n.payloads = append(n.payloads, payload)
+	return nil
+}
+
+func (n *spyNotifier) ListenEvents(ctx context.Context, fn func(payload string)) error {
+	return n.listen(ctx, fn)
+}
+
+func TestNotify(t *testing.T) {
+	t.Run("should notify events", func(t *testing.T) {
+		notifier := &spyNotifier{}
+
+		NotifyPublisher(notifier)(Deleted, 7)
+
+		if want := []string{`{"type":"deleted","petId":7}`}; !reflect.DeepEqual(notifier.payloads, want) {
+			t.Fatalf("got %v, want %v", notifier.payloads, want)
+		}
+	})
+
+	t.Run("should publish listened events", func(t *testing.T) {
+		b := newBroker(10, 0)
+		sub := b.Subscribe(0)
+		ctx, cancel := context.WithCancel(context.Background())
+		calls := 0
+		notifier := &spyNotifier{listen: func(ctx context.Context, fn func(payload string)) error {
+			calls++
+			fn("not json")
+			fn(`{"type":"updated","petId":2}`)
+			cancel()
+			return errors.New("connection lost")
+		}}
+
+		b.Listen(notifier)(ctx)
+
+		if got, want := receive(t, sub), (Event{Id: 1, Type: Updated, PetId: 2}); got != want || calls != 1 {
+			t.Fatalf("got %v after %d calls, want %v", got, calls, want)
+		}
+	})
+}
+
+func TestWebhookPublisher(t *testing.T) {
+	ps := memory.NewInMemoryPetStore(config.CfgData{})
+	ws := ps.(store.WebhookStore)
+	id, _ := ws.AddWebhook("http://example.com", []string{string(Deleted)}, "secret", true)
+	b := newBroker(10, 0)
+
+	Combine(b.Publish, WebhookPublisher(ws))(Deleted, 7)
+
+	deliveries, _, _ := ws.WebhookDeliveries(id, 0, 10)
+	if len(deliveries) != 1 || deliveries[0].Event != string(Deleted) {
+		t.Fatalf("got %v, want a deleted delivery", deliveries)
+	}
+	payload := WebhookPayload{}
+	if err := json.Unmarshal([]byte(deliveries[0].Payload), &payload); err != nil || payload.PetId != 7 ||
+		payload.Type != Deleted || payload.Timestamp.IsZero() {
+		t.Fatalf("got %v and %v, want payload for pet 7", payload, err)
+	}
+	if b.lastId != 1 {
+		t.Fatalf("got %d events, want 1", b.lastId)
+	}
+}
+
+func TestStore(t *testing.T) {
+	b := newBroker(100, 0)
+	b.Publish(Created, 0)
+	ps := NewStore(memory.NewInMemoryPetStore(config.CfgData{}), b.Publish).WithContext(context.Background())
+	owners, ok := ps.(store.OwnerStore)
+	if !ok {
+		t.Fatal("want owner store")
+	}
+
+	id, _ := ps.AddPet("Fluffy", "dog", "happy")
+	other, _ := ps.AddPet("Lion", "cat", "brave")
+	_, _ = ps.UpdatePet(id, "Fluffy", "dog", "happy")
+	_, _ = ps.UpdatePet(id, "Fluffy", "dog", "sad")
+	_, _ = ps.TransitionPet(id, data.Reserved)
+	_, _ = ps.TransitionPet(id, data.Returned)
+	_, _ = ps.SetPetTags(id, []string{"small"}, nil)
+	_ = ps.DeletePet(other)
+	_ = ps.DeletePet(other)
+	_ = ps.RestorePet(other)
+	imported := []data.Pet{{Name: "Snowflake", Race: "mouse", Mod: "nervous"}}
+	_ = ps.ImportPets(imported)
+	_, _ = ps.BatchPets([]store.PetOperation{
+		{Type: store.CreateOperation, Pet: data.Pet{Name: "Ghost", Race: "cat", Mod: "shy"}},
+		{Type: store.DeleteOperation, Pet: data.Pet{Id: 99}},
+	}, false)
+	owner, _ := owners.AddOwner("John", "john@example.com")
+	_, _ = owners.SetPetOwner(id, owner)
+	_ = owners.DeleteOwner(owner, 0)
+	_ = owners.DeleteOwner(owner, 0)
+	_ = ps.DeletePet(id)
+	_ = owners.DeleteOwner(owner, 0)
+
+	want := []Event{
+		{Type: Created, PetId: id},
+		{Type: Created, PetId: other},
+		{Type: Updated, PetId: id},
+		{Type: Updated, PetId: id},
+		{Type: Updated, PetId: id},
+		{Type: Deleted, PetId: other},
+		{Type: Created, PetId: other},
+		{Type: Created, PetId: imported[0].Id},
+		{Type: Created, PetId: imported[0].Id + 1},
+		{Type: Updated, PetId: id},
+		{Type: Deleted, PetId: id},
+	}
+	for i := range want {
+		want[i].Id = int64(i + 2)
+	}
+	if got := b.Subscribe(1).Replay; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
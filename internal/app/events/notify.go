@@ -0,0 +1,72 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/LearningByExample/go-microservice/internal/app/store"
+	"log"
+	"time"
+)
+
+const (
+	listenRetry = 5 * time.Second
+)
+
+// NotifyPublisher publishes the events through the notifier, so they reach the brokers of every replica listening.
+func NotifyPublisher(notifier store.EventNotifier) Publisher {
+	return func(t Type, petId int) {
+		payload, err := json.Marshal(Event{Type: t, PetId: petId})
+		if err == nil {
+			err = notifier.NotifyEvent(string(payload))
+		}
+		if err != nil {
+			log.Printf("Error %v notifying %s event for pet %d", err, t, petId)
+		}
+	}
+}
+
+func (b *Broker) publishPayload(payload string) {
+	event := Event{}
+	if err := json.Unmarshal([]byte(payload), &event); err != nil {
+		log.Printf("Error %v decoding event %q", err, payload)
+		return
+	}
+	b.Publish(event.Type, event.PetId)
+}
+
+// Listen returns a worker publishing in the broker the events notified by any replica.
+func (b *Broker) Listen(notifier store.EventNotifier) func(ctx context.Context) {
+	return func(ctx context.Context) {
+		for ctx.Err() == nil {
+			if err := notifier.ListenEvents(ctx, b.publishPayload); err != nil {
+				log.Printf("Error %v listening events, retrying in %v", err, listenRetry)
+				select {
+				case <-ctx.Done():
+				case <-time.After(listenRetry):
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,151 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package events
+
+import (
+	"context"
+	"github.com/LearningByExample/go-microservice/internal/app/data"
+	"github.com/LearningByExample/go-microservice/internal/app/store"
+)
+
+type petStore struct {
+	store.PetStore
+	publish Publisher
+}
+
+type ownerPetStore struct {
+	petStore
+	store.OwnerStore
+}
+
+func (s petStore) AddPet(name string, race string, mod string) (int, error) {
+	id, err := s.PetStore.AddPet(name, race, mod)
+	if err == nil {
+		s.publish(Created, id)
+	}
+	return id, err
+}
+
+func (s petStore) UpdatePet(id int, name string, race string, mod string) (bool, error) {
+	change, err := s.PetStore.UpdatePet(id, name, race, mod)
+	if err == nil && change {
+		s.publish(Updated, id)
+	}
+	return change, err
+}
+
+func (s petStore) DeletePet(id int) error {
+	err := s.PetStore.DeletePet(id)
+	if err == nil {
+		s.publish(Deleted, id)
+	}
+	return err
+}
+
+// RestorePet publishes a created event as the pet is back for the clients that removed it when it was deleted
+func (s petStore) RestorePet(id int) error {
+	err := s.PetStore.RestorePet(id)
+	if err == nil {
+		s.publish(Created, id)
+	}
+	return err
+}
+
+func (s petStore) TransitionPet(id int, to data.PetStatus) (data.Pet, error) {
+	pet, err := s.PetStore.TransitionPet(id, to)
+	if err == nil {
+		s.publish(Updated, id)
+	}
+	return pet, err
+}
+
+func (s petStore) SetPetTags(id int, tags []string, attributes map[string]string) (bool, error) {
+	change, err := s.PetStore.SetPetTags(id, tags, attributes)
+	if err == nil && change {
+		s.publish(Updated, id)
+	}
+	return change, err
+}
+
+func (s petStore) ImportPets(pets []data.Pet) error {
+	err := s.PetStore.ImportPets(pets)
+	if err == nil {
+		for _, pet := range pets {
+			s.publish(Created, pet.Id)
+		}
+	}
+	return err
+}
+
+func (s petStore) BatchPets(ops []store.PetOperation, atomic bool) ([]store.PetOperationResult, error) {
+	results, err := s.PetStore.BatchPets(ops, atomic)
+	if err == nil {
+		types := map[store.OperationType]Type{
+			store.CreateOperation: Created,
+			store.UpdateOperation: Updated,
+			store.DeleteOperation: Deleted,
+		}
+		for i, result := range results {
+			if result.Err == nil && result.Changed {
+				s.publish(types[ops[i].Type], result.Id)
+			}
+		}
+	}
+	return results, err
+}
+
+func (s petStore) WithContext(ctx context.Context) store.PetStore {
+	return NewStore(s.PetStore.WithContext(ctx), s.publish)
+}
+
+func (s ownerPetStore) SetPetOwner(petId int, ownerId int) (bool, error) {
+	change, err := s.OwnerStore.SetPetOwner(petId, ownerId)
+	if err == nil && change {
+		s.publish(Updated, petId)
+	}
+	return change, err
+}
+
+func (s ownerPetStore) DeleteOwner(id int, reassignTo int) error {
+	owned, err := s.PetStore.FindPets(store.PetQuery{OwnerId: id})
+	if err == nil {
+		if err = s.OwnerStore.DeleteOwner(id, reassignTo); err == nil {
+			for _, pet := range owned {
+				s.publish(Updated, pet.Id)
+			}
+		}
+	}
+	return err
+}
+
+func (s ownerPetStore) WithContext(ctx context.Context) store.PetStore {
+	return NewStore(s.PetStore.WithContext(ctx), s.publish)
+}
+
+// NewStore decorates the store for publishing an event for every pet change, keeping its optional capabilities.
+func NewStore(ps store.PetStore, publish Publisher) store.PetStore {
+	if owners, ok := ps.(store.OwnerStore); ok {
+		return ownerPetStore{petStore: petStore{PetStore: ps, publish: publish}, OwnerStore: owners}
+	}
+	return petStore{PetStore: ps, publish: publish}
+}
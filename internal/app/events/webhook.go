@@ -0,0 +1,58 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package events
+
+import (
+	"encoding/json"
+	"github.com/LearningByExample/go-microservice/internal/app/store"
+	"log"
+	"time"
+)
+
+type WebhookPayload struct {
+	Type      Type      `json:"type"`
+	PetId     int       `json:"petId"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// WebhookPublisher enqueues a delivery of each event for every webhook subscribed to its type, it should be
+// used where the change happens so every event is delivered once whatever the number of replicas.
+func WebhookPublisher(ws store.WebhookStore) Publisher {
+	return func(t Type, petId int) {
+		payload, err := json.Marshal(WebhookPayload{Type: t, PetId: petId, Timestamp: time.Now().UTC()})
+		if err == nil {
+			_, err = ws.EnqueueDeliveries(string(t), string(payload))
+		}
+		if err != nil {
+			log.Printf("Error %v enqueuing %s event for pet %d", err, t, petId)
+		}
+	}
+}
+
+func Combine(publishers ...Publisher) Publisher {
+	return func(t Type, petId int) {
+		for _, publish := range publishers {
+			publish(t, petId)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package health
+
+import (
+	"context"
+	"fmt"
+	"github.com/LearningByExample/go-microservice/internal/app/store"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Store checks the connectivity of the store
+func Store(ps store.PetStore, timeout time.Duration) Check {
+	return Check{
+		Name:     "store",
+		Critical: true,
+		Timeout:  timeout,
+		Run: func(ctx context.Context) error {
+			return ps.WithContext(ctx).IsReady()
+		},
+	}
+}
+
+// scopedSchema queries the schema of a store with the context of a check, so its timeout cancels the query
+func scopedSchema(schema store.SchemaStore, ctx context.Context) store.SchemaStore {
+	if ps, ok := schema.(store.PetStore); ok {
+		if scoped, ok := ps.WithContext(ctx).(store.SchemaStore); ok {
+			return scoped
+		}
+	}
+	return schema
+}
+
+// scopedWebhooks queries the webhooks of a store with the context of a check, so its timeout cancels the query
+func scopedWebhooks(webhooks store.WebhookStore, ctx context.Context) store.WebhookStore {
+	if ps, ok := webhooks.(store.PetStore); ok {
+		if scoped, ok := ps.WithContext(ctx).(store.WebhookStore); ok {
+			return scoped
+		}
+	}
+	return webhooks
+}
+
+// Pool checks that the connections in use of a pool are under a percentage of its maximum, a pool without maximum is
+// never saturated
+func Pool(monitor store.PoolMonitor, maxSaturation int, timeout time.Duration) Check {
+	return Check{
+		Name:    "pool",
+		Timeout: timeout,
+		Run: func(_ context.Context) error {
+			stats := monitor.PoolStats()
+			if stats.MaxOpen > 0 && stats.InUse*100 >= stats.MaxOpen*maxSaturation {
+				return fmt.Errorf("%d of %d connections in use", stats.InUse, stats.MaxOpen)
+			}
+			return nil
+		},
+	}
+}
+
+// Schema checks that the database has been migrated at least to the version the store expects, a newer version is
+// fine as it has been migrated by a newer release during a rolling update
+func Schema(schema store.SchemaStore, timeout time.Duration) Check {
+	return Check{
+		Name:     "schema",
+		Critical: true,
+		Timeout:  timeout,
+		Run: func(ctx context.Context) error {
+			got, want, err := scopedSchema(schema, ctx).SchemaVersion()
+			if err == nil && got < want {
+				err = fmt.Errorf("schema version %d, want %d", got, want)
+			}
+			return err
+		},
+	}
+}
+
+// existingPath gets the path or its closest parent that exists, as the directories of the files are created on write
+func existingPath(path string) string {
+	for {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			return path
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path
+		}
+		path = parent
+	}
+}
+
+// Disk checks that the file system of a path has free space
+func Disk(name string, path string, minFree uint64, timeout time.Duration) Check {
+	return Check{
+		Name:    name,
+		Timeout: timeout,
+		Run: func(_ context.Context) error {
+			free, err := freeSpace(existingPath(path))
+			if err == nil && free < minFree {
+				err = fmt.Errorf("%d bytes free, want %d", free, minFree)
+			}
+			return err
+		},
+	}
+}
+
+// Backlog checks that the pending webhook deliveries do not grow over a maximum
+func Backlog(webhooks store.WebhookStore, max int, timeout time.Duration) Check {
+	return Check{
+		Name:    "webhooks",
+		Timeout: timeout,
+		Run: func(ctx context.Context) error {
+			pending, err := scopedWebhooks(webhooks, ctx).PendingDeliveries()
+			if err == nil && pending > max {
+				err = fmt.Errorf("%d pending deliveries, want at most %d", pending, max)
+			}
+			return err
+		},
+	}
+}
@@ -0,0 +1,165 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package health
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// Up is the status of a passing check and of a report where all the checks pass
+	Up = "up"
+	// Degraded is the status of a report where only checks that are not critical fail
+	Degraded = "degraded"
+	// Down is the status of a failing check and of a report where a critical check fails
+	Down = "down"
+)
+
+var (
+	TimedOut = errors.New("check timed out")
+)
+
+// Check is a named check of a component, a failing critical check makes the service not ready
+type Check struct {
+	Name     string
+	Critical bool
+	Timeout  time.Duration
+	Run      func(ctx context.Context) error
+}
+
+type Result struct {
+	Name      string  `json:"name"`
+	Status    string  `json:"status"`
+	Critical  bool    `json:"critical"`
+	LatencyMs float64 `json:"latencyMs"`
+	Error     string  `json:"error,omitempty"`
+}
+
+type Report struct {
+	Status    string    `json:"status"`
+	CheckedAt time.Time `json:"checkedAt"`
+	Checks    []Result  `json:"checks"`
+}
+
+// Err gets an error when the report is down, to use it where only the readiness matters
+func (r Report) Err() error {
+	for _, result := range r.Checks {
+		if result.Critical && result.Status == Down {
+			return errors.New(result.Name + ": " + result.Error)
+		}
+	}
+	return nil
+}
+
+// Checker runs the registered checks concurrently, caching the report so frequent probes do not reach the
+// components, the callers that arrive while the checks run wait for the same report.
+type Checker struct {
+	mu     sync.Mutex
+	checks []Check
+	ttl    time.Duration
+	cached *Report
+	now    func() time.Time
+}
+
+func (c *Checker) Register(check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checks = append(c.checks, check)
+	c.cached = nil
+}
+
+// run gets the result of a check, a check that does not end in its timeout is reported as down while it ends
+func (c *Checker) run(ctx context.Context, check Check) Result {
+	if check.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, check.Timeout)
+		defer cancel()
+	}
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- check.Run(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = TimedOut
+	}
+	result := Result{
+		Name:      check.Name,
+		Status:    Up,
+		Critical:  check.Critical,
+		LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		result.Status = Down
+		result.Error = err.Error()
+	}
+	return result
+}
+
+func (c *Checker) Check(ctx context.Context) Report {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	if c.cached != nil && now.Sub(c.cached.CheckedAt) < c.ttl {
+		return *c.cached
+	}
+
+	results := make([]Result, len(c.checks))
+	var wg sync.WaitGroup
+	wg.Add(len(c.checks))
+	for i, check := range c.checks {
+		go func(i int, check Check) {
+			defer wg.Done()
+			results[i] = c.run(ctx, check)
+		}(i, check)
+	}
+	wg.Wait()
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Name < results[j].Name
+	})
+
+	report := Report{Status: Up, CheckedAt: now, Checks: results}
+	for _, result := range results {
+		if result.Status == Down {
+			if result.Critical {
+				report.Status = Down
+				break
+			}
+			report.Status = Degraded
+		}
+	}
+	c.cached = &report
+	return report
+}
+
+func NewChecker(ttl time.Duration) *Checker {
+	return &Checker{ttl: ttl, now: time.Now}
+}
@@ -0,0 +1,221 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package health
+
+import (
+	"context"
+	"errors"
+	"github.com/LearningByExample/go-microservice/internal/_test"
+	"github.com/LearningByExample/go-microservice/internal/app/store"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func passing(name string, critical bool) Check {
+	return Check{Name: name, Critical: critical, Run: func(_ context.Context) error {
+		return nil
+	}}
+}
+
+func failing(name string, critical bool) Check {
+	return Check{Name: name, Critical: critical, Run: func(_ context.Context) error {
+		return errors.New("failed")
+	}}
+}
+
+func TestChecker(t *testing.T) {
+	type testCase struct {
+		name   string
+		checks []Check
+		want   string
+	}
+	cases := []testCase{
+		{name: "up without checks", checks: nil, want: Up},
+		{name: "up when all pass", checks: []Check{passing("a", true), passing("b", false)}, want: Up},
+		{name: "degraded when non critical fails", checks: []Check{passing("a", true), failing("b", false)}, want: Degraded},
+		{name: "down when critical fails", checks: []Check{failing("a", true), failing("b", false)}, want: Down},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			checker := NewChecker(0)
+			for _, check := range tt.checks {
+				checker.Register(check)
+			}
+			report := checker.Check(context.Background())
+			if report.Status != tt.want || len(report.Checks) != len(tt.checks) {
+				t.Fatalf("got %v, want %s", report, tt.want)
+			}
+			if (report.Err() != nil) != (tt.want == Down) {
+				t.Fatalf("got error %v for %s", report.Err(), report.Status)
+			}
+		})
+	}
+
+	t.Run("should sort results by name", func(t *testing.T) {
+		checker := NewChecker(0)
+		checker.Register(passing("b", false))
+		checker.Register(failing("a", true))
+
+		report := checker.Check(context.Background())
+		if report.Checks[0].Name != "a" || report.Checks[0].Error != "failed" || report.Checks[1].Name != "b" {
+			t.Fatalf("got %v, want sorted results", report.Checks)
+		}
+	})
+
+	t.Run("should time out slow checks", func(t *testing.T) {
+		checker := NewChecker(0)
+		release := make(chan struct{})
+		defer close(release)
+		checker.Register(Check{Name: "slow", Critical: true, Timeout: 10 * time.Millisecond,
+			Run: func(_ context.Context) error {
+				<-release
+				return nil
+			}})
+
+		report := checker.Check(context.Background())
+		if report.Status != Down || report.Checks[0].Error != TimedOut.Error() {
+			t.Fatalf("got %v, want timed out", report)
+		}
+	})
+
+	t.Run("should cache the report", func(t *testing.T) {
+		now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+		checker := NewChecker(time.Second)
+		checker.now = func() time.Time {
+			return now
+		}
+		runs := 0
+		checker.Register(Check{Name: "counted", Run: func(_ context.Context) error {
+			runs++
+			return nil
+		}})
+
+		_ = checker.Check(context.Background())
+		_ = checker.Check(context.Background())
+		if runs != 1 {
+			t.Fatalf("got %d runs, want 1", runs)
+		}
+		now = now.Add(time.Second)
+		if report := checker.Check(context.Background()); runs != 2 || !report.CheckedAt.Equal(now) {
+			t.Fatalf("got %d runs and %v, want the report checked again", runs, report)
+		}
+	})
+}
+
+type poolFunc func() store.PoolStats
+
+func (f poolFunc) PoolStats() store.PoolStats {
+	return f()
+}
+
+type schemaFunc func() (int, int, error)
+
+func (f schemaFunc) SchemaVersion() (int, int, error) {
+	return f()
+}
+
+type schemaSpy struct {
+	*_test.SpyStore
+}
+
+func (s schemaSpy) SchemaVersion() (int, int, error) {
+	return 3, 3, nil
+}
+
+func TestChecks(t *testing.T) {
+	spyStore := _test.NewSpyStore()
+	readyErr := errors.New("not ready")
+
+	type testCase struct {
+		name  string
+		check Check
+		fails bool
+	}
+	cases := []testCase{
+		{name: "store ready", check: Store(&spyStore, 0)},
+		{name: "pool under saturation", check: Pool(poolFunc(func() store.PoolStats {
+			return store.PoolStats{MaxOpen: 10, InUse: 8}
+		}), 90, 0)},
+		{name: "pool without maximum", check: Pool(poolFunc(func() store.PoolStats {
+			return store.PoolStats{InUse: 100}
+		}), 90, 0)},
+		{name: "pool saturated", check: Pool(poolFunc(func() store.PoolStats {
+			return store.PoolStats{MaxOpen: 10, InUse: 9}
+		}), 90, 0), fails: true},
+		{name: "schema migrated", check: Schema(schemaFunc(func() (int, int, error) {
+			return 3, 3, nil
+		}), 0)},
+		{name: "schema outdated", check: Schema(schemaFunc(func() (int, int, error) {
+			return 2, 3, nil
+		}), 0), fails: true},
+		{name: "schema migrated by a newer release", check: Schema(schemaFunc(func() (int, int, error) {
+			return 4, 3, nil
+		}), 0)},
+		{name: "schema error", check: Schema(schemaFunc(func() (int, int, error) {
+			return 0, 3, readyErr
+		}), 0), fails: true},
+		{name: "disk with space", check: Disk("disk", os.TempDir(), 0, 0)},
+		{name: "disk without space", check: Disk("disk", os.TempDir(), ^uint64(0), 0), fails: true},
+		{name: "disk not created", check: Disk("disk", filepath.Join(os.TempDir(), "missing", "path"), 0, 0)},
+		{name: "webhook backlog", check: Backlog(&spyStore, 10, 0)},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.check.Run(context.Background()); (err != nil) != tt.fails {
+				t.Fatalf("got %v, want failing %t", err, tt.fails)
+			}
+		})
+	}
+
+	t.Run("should fail when store is not ready", func(t *testing.T) {
+		spyStore.WhenIsReady(func() error {
+			return readyErr
+		})
+		if err := Store(&spyStore, 0).Run(context.Background()); err != readyErr {
+			t.Fatalf("got %v, want %v", err, readyErr)
+		}
+	})
+
+	t.Run("should query the store with the context of the check", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		for _, check := range []Check{Store(&spyStore, 0), Schema(schemaSpy{&spyStore}, 0), Backlog(&spyStore, 10, 0)} {
+			spyStore.Ctx = nil
+			_ = check.Run(ctx)
+			if spyStore.Ctx != ctx {
+				t.Fatalf("got %v, want the context of the check %s", spyStore.Ctx, check.Name)
+			}
+		}
+	})
+
+	t.Run("should fail with a webhook backlog", func(t *testing.T) {
+		spyStore.WhenPendingDeliveries(func() (int, error) {
+			return 11, nil
+		})
+		if err := Backlog(&spyStore, 10, 0).Run(context.Background()); err == nil {
+			t.Fatal("want error, got none")
+		}
+	})
+}
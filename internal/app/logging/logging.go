@@ -0,0 +1,92 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package logging
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync/atomic"
+)
+
+const (
+	// Info logs everything, the default level
+	Info = "info"
+	// Error logs only the errors, the lines that start with "Error" as all the errors of the service are logged
+	Error = "error"
+)
+
+var (
+	InvalidLevel = errors.New("invalid log level")
+	levels       = []string{Info, Error}
+	current      int32
+	errorPrefix  = []byte("Error ")
+	errorWord    = []byte(" Error ")
+)
+
+func levelIndex(name string) int {
+	for i, level := range levels {
+		if level == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func IsValidLevel(name string) bool {
+	return levelIndex(name) != -1
+}
+
+// SetLevel changes the level of the logs written with the writer, it could be changed while the service is running
+func SetLevel(name string) error {
+	i := levelIndex(name)
+	if i == -1 {
+		return InvalidLevel
+	}
+	atomic.StoreInt32(&current, int32(i))
+	return nil
+}
+
+func Level() string {
+	return levels[atomic.LoadInt32(&current)]
+}
+
+type writer struct {
+	out io.Writer
+}
+
+func isError(line []byte) bool {
+	return bytes.HasPrefix(line, errorPrefix) || bytes.Contains(line, errorWord)
+}
+
+func (w writer) Write(line []byte) (int, error) {
+	if Level() == Error && !isError(line) {
+		return len(line), nil
+	}
+	return w.out.Write(line)
+}
+
+// NewWriter filters the lines written by the log package with the current level
+func NewWriter(out io.Writer) io.Writer {
+	return writer{out: out}
+}
@@ -0,0 +1,73 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package logging
+
+import (
+	"bytes"
+	"log"
+	"testing"
+)
+
+func TestLevel(t *testing.T) {
+	defer SetLevel(Info)
+
+	t.Run("should be info by default", func(t *testing.T) {
+		if got := Level(); got != Info {
+			t.Fatalf("got %q, want %q", got, Info)
+		}
+	})
+
+	t.Run("should change the level", func(t *testing.T) {
+		if err := SetLevel(Error); err != nil || Level() != Error {
+			t.Fatalf("got %q and %v, want %q", Level(), err, Error)
+		}
+	})
+
+	t.Run("should reject an invalid level", func(t *testing.T) {
+		if err := SetLevel("verbose"); err != InvalidLevel || Level() != Error {
+			t.Fatalf("got %q and %v, want %v keeping %q", Level(), err, InvalidLevel, Error)
+		}
+	})
+}
+
+func TestWriter(t *testing.T) {
+	defer SetLevel(Info)
+	out := &bytes.Buffer{}
+	logger := log.New(NewWriter(out), "", log.LstdFlags)
+
+	logger.Print("Starting server ...")
+	logger.Printf("Error %v opening store", "boom")
+	_ = SetLevel(Error)
+	logger.Print("HTTP server listening ...")
+	logger.Printf("Error %v closing store", "boom")
+
+	got := out.String()
+	for _, want := range []string{"Starting server", "Error boom opening store", "Error boom closing store"} {
+		if !bytes.Contains([]byte(got), []byte(want)) {
+			t.Fatalf("got %q, want %q logged", got, want)
+		}
+	}
+	if bytes.Contains([]byte(got), []byte("listening")) {
+		t.Fatalf("got %q, want info filtered at error level", got)
+	}
+}
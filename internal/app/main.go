@@ -26,11 +26,13 @@ import (
 	"errors"
 	"flag"
 	"github.com/LearningByExample/go-microservice/internal/app/config"
+	"github.com/LearningByExample/go-microservice/internal/app/logging"
 	"github.com/LearningByExample/go-microservice/internal/app/server"
 	"github.com/LearningByExample/go-microservice/internal/app/store"
 	"github.com/LearningByExample/go-microservice/internal/app/store/memory"
 	"github.com/LearningByExample/go-microservice/internal/app/store/psqlstore"
 	"log"
+	"os"
 )
 
 var (
@@ -58,6 +60,7 @@ func run(cfgPath string) error {
 	cfg, err := config.GetConfig(cfgPath)
 	if err == nil {
 		log.Println("Config loaded.")
+		_ = logging.SetLevel(cfg.Server.LogLevel)
 		addProviders()
 		var st store.PetStore
 		st, err = store.GetStoreFromProvider(cfg)
@@ -76,7 +79,12 @@ func run(cfgPath string) error {
 }
 
 func main() {
+	if isPolicyTest(os.Args) {
+		policyTest()
+		return
+	}
 	print(dog)
+	log.SetOutput(logging.NewWriter(os.Stderr))
 	cfgPath := flag.String("config", "config/default.json", "configuration file path")
 	flag.Parse()
 	if err := run(*cfgPath); err != nil {
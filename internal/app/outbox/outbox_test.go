@@ -0,0 +1,233 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package outbox
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/LearningByExample/go-microservice/internal/app/config"
+	"github.com/LearningByExample/go-microservice/internal/app/data"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeOutbox struct {
+	mu      sync.Mutex
+	pending []data.OutboxEvent
+	calls   int
+}
+
+func (f *fakeOutbox) add(count int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := 0; i < count; i++ {
+		id := int64(len(f.pending) + 1)
+		f.pending = append(f.pending, data.OutboxEvent{Id: id, PetId: 1, Type: data.UpdateAction,
+			Payload: json.RawMessage(`{}`)})
+	}
+}
+
+func (f *fakeOutbox) RelayOutbox(limit int, publish func(event data.OutboxEvent) error) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	count := 0
+	for len(f.pending) != 0 && count < limit {
+		if err := publish(f.pending[0]); err != nil {
+			return count, err
+		}
+		f.pending = f.pending[1:]
+		count++
+	}
+	return count, nil
+}
+
+type spyPublisher struct {
+	mu        sync.Mutex
+	published []int64
+	fail      error
+	closed    bool
+}
+
+func (p *spyPublisher) Publish(_ context.Context, event data.OutboxEvent) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.fail != nil {
+		return p.fail
+	}
+	p.published = append(p.published, event.Id)
+	return nil
+}
+
+func (p *spyPublisher) Close() error {
+	p.closed = true
+	return nil
+}
+
+func newTestRelay(source *fakeOutbox, publisher *spyPublisher) Relay {
+	return Relay{source: source, publisher: publisher, interval: time.Hour, timeout: time.Second, batchSize: 2}
+}
+
+func TestRelay(t *testing.T) {
+	t.Run("should relay all the pending events in batches", func(t *testing.T) {
+		source, publisher := &fakeOutbox{}, &spyPublisher{}
+		source.add(5)
+
+		if count := newTestRelay(source, publisher).drain(context.Background()); count != 5 || source.calls != 3 {
+			t.Fatalf("got %d events in %d calls, want 5 in 3", count, source.calls)
+		}
+		if want := []int64{1, 2, 3, 4, 5}; !reflect.DeepEqual(publisher.published, want) {
+			t.Fatalf("got %v, want %v", publisher.published, want)
+		}
+	})
+
+	t.Run("should stop relaying on errors", func(t *testing.T) {
+		source, publisher := &fakeOutbox{}, &spyPublisher{fail: errors.New("unavailable")}
+		source.add(5)
+
+		if count := newTestRelay(source, publisher).drain(context.Background()); count != 0 || len(source.pending) != 5 {
+			t.Fatalf("got %d events and %d pending, want 0 and 5", count, len(source.pending))
+		}
+	})
+
+	t.Run("should drain the outbox when stopped", func(t *testing.T) {
+		source, publisher := &fakeOutbox{}, &spyPublisher{}
+		source.add(3)
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			newTestRelay(source, publisher).Run(ctx)
+			close(done)
+		}()
+		cancel()
+
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("relay did not stop")
+		}
+		if len(source.pending) != 0 || len(publisher.published) != 3 || !publisher.closed {
+			t.Fatalf("got %d pending and %v published, want drained and closed", len(source.pending), publisher.published)
+		}
+	})
+
+	t.Run("should relay periodically", func(t *testing.T) {
+		source, publisher := &fakeOutbox{}, &spyPublisher{}
+		source.add(1)
+		relay := newTestRelay(source, publisher)
+		relay.interval = time.Millisecond
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go relay.Run(ctx)
+
+		deadline := time.Now().Add(time.Second)
+		for {
+			publisher.mu.Lock()
+			count := len(publisher.published)
+			publisher.mu.Unlock()
+			if count == 1 {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("event was not relayed")
+			}
+			time.Sleep(time.Millisecond)
+		}
+	})
+}
+
+func TestPublishers(t *testing.T) {
+	event := data.OutboxEvent{Id: 7, PetId: 3, Type: data.DeleteAction, Payload: json.RawMessage(`{"petId":3}`)}
+
+	t.Run("should fail with unknown publisher", func(t *testing.T) {
+		if _, err := NewPublisher(config.OutboxCfg{Publisher: "kafka"}); err != UnknownPublisher {
+			t.Fatalf("got %v, want %v", err, UnknownPublisher)
+		}
+	})
+
+	t.Run("should log events", func(t *testing.T) {
+		publisher, _ := NewPublisher(config.OutboxCfg{Publisher: config.LogPublisher})
+
+		if err := publisher.Publish(context.Background(), event); err != nil {
+			t.Fatalf("want not error, got %v", err)
+		}
+		if err := publisher.Close(); err != nil {
+			t.Fatalf("want not error, got %v", err)
+		}
+	})
+
+	t.Run("should append events to a file", func(t *testing.T) {
+		dir, _ := ioutil.TempDir("", "outbox")
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "outbox.ndjson")
+		publisher, _ := NewPublisher(config.OutboxCfg{Publisher: config.FilePublisher, Path: path})
+
+		_ = publisher.Publish(context.Background(), event)
+		_ = publisher.Publish(context.Background(), event)
+		if err := publisher.Close(); err != nil {
+			t.Fatalf("want not error, got %v", err)
+		}
+
+		file, _ := os.Open(path)
+		defer file.Close()
+		lines := 0
+		for scanner := bufio.NewScanner(file); scanner.Scan(); lines++ {
+			got := data.OutboxEvent{}
+			if err := json.Unmarshal(scanner.Bytes(), &got); err != nil || !reflect.DeepEqual(got, event) {
+				t.Fatalf("got %v and %v, want %v", got, err, event)
+			}
+		}
+		if lines != 2 {
+			t.Fatalf("got %d lines, want 2", lines)
+		}
+	})
+
+	t.Run("should post events", func(t *testing.T) {
+		var got data.OutboxEvent
+		status := http.StatusAccepted
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_ = json.NewDecoder(r.Body).Decode(&got)
+			w.WriteHeader(status)
+		}))
+		defer ts.Close()
+		publisher, _ := NewPublisher(config.OutboxCfg{Publisher: config.HttpPublisher, Url: ts.URL, Timeout: 1000})
+		defer publisher.Close()
+
+		if err := publisher.Publish(context.Background(), event); err != nil || !reflect.DeepEqual(got, event) {
+			t.Fatalf("got %v and %v, want %v", got, err, event)
+		}
+		status = http.StatusServiceUnavailable
+		if err := publisher.Publish(context.Background(), event); err == nil {
+			t.Fatal("want error, got nil")
+		}
+	})
+}
@@ -0,0 +1,137 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/LearningByExample/go-microservice/internal/app/config"
+	"github.com/LearningByExample/go-microservice/internal/app/constants"
+	"github.com/LearningByExample/go-microservice/internal/app/data"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	UnknownPublisher = errors.New("unknown outbox publisher")
+)
+
+// Publisher hands the outbox events to other systems, an event is only marked as published when it returns no error.
+type Publisher interface {
+	Publish(ctx context.Context, event data.OutboxEvent) error
+	Close() error
+}
+
+type logPublisher struct{}
+
+func (logPublisher) Publish(_ context.Context, event data.OutboxEvent) error {
+	log.Printf("Outbox event %d %s for pet %d: %s", event.Id, event.Type, event.PetId, event.Payload)
+	return nil
+}
+
+func (logPublisher) Close() error {
+	return nil
+}
+
+type filePublisher struct {
+	path string
+	mu   sync.Mutex
+	file *os.File
+}
+
+func (p *filePublisher) Publish(_ context.Context, event data.OutboxEvent) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	line, err := json.Marshal(event)
+	if err == nil && p.file == nil {
+		p.file, err = os.OpenFile(p.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	}
+	if err == nil {
+		if _, err = p.file.Write(append(line, '\n')); err == nil {
+			err = p.file.Sync()
+		}
+	}
+	return err
+}
+
+func (p *filePublisher) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.file == nil {
+		return nil
+	}
+	err := p.file.Close()
+	p.file = nil
+	return err
+}
+
+type httpPublisher struct {
+	url    string
+	client *http.Client
+}
+
+func (p httpPublisher) Publish(ctx context.Context, event data.OutboxEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	r.Header.Set(constants.ContentType, constants.ApplicationJsonUtf8)
+	response, err := p.client.Do(r)
+	if err != nil {
+		return err
+	}
+	_ = response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("publishing outbox event %d got status %d", event.Id, response.StatusCode)
+	}
+	return nil
+}
+
+func (p httpPublisher) Close() error {
+	p.client.CloseIdleConnections()
+	return nil
+}
+
+func NewPublisher(cfg config.OutboxCfg) (Publisher, error) {
+	switch cfg.Publisher {
+	case config.LogPublisher:
+		return logPublisher{}, nil
+	case config.FilePublisher:
+		return &filePublisher{path: cfg.Path}, nil
+	case config.HttpPublisher:
+		timeout := time.Duration(cfg.Timeout) * time.Millisecond
+		return httpPublisher{url: cfg.Url, client: &http.Client{Timeout: timeout}}, nil
+	}
+	return nil, UnknownPublisher
+}
@@ -0,0 +1,92 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package outbox
+
+import (
+	"context"
+	"github.com/LearningByExample/go-microservice/internal/app/config"
+	"github.com/LearningByExample/go-microservice/internal/app/data"
+	"github.com/LearningByExample/go-microservice/internal/app/store"
+	"log"
+	"time"
+)
+
+// Relay publishes periodically the pending outbox events, when stopped it drains the outbox before closing the
+// publisher so the events recorded before shutting down are not left behind.
+type Relay struct {
+	source    store.OutboxStore
+	publisher Publisher
+	interval  time.Duration
+	timeout   time.Duration
+	batchSize int
+}
+
+func (r Relay) drain(ctx context.Context) int {
+	total := 0
+	for ctx.Err() == nil {
+		count, err := r.source.RelayOutbox(r.batchSize, func(event data.OutboxEvent) error {
+			return r.publisher.Publish(ctx, event)
+		})
+		total += count
+		if err != nil {
+			log.Printf("Error %v relaying outbox events", err)
+			break
+		}
+		if count < r.batchSize {
+			break
+		}
+	}
+	return total
+}
+
+func (r Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			drainCtx, cancel := context.WithTimeout(context.Background(), r.timeout)
+			count := r.drain(drainCtx)
+			cancel()
+			log.Printf("Outbox drained, %d events relayed.", count)
+			if err := r.publisher.Close(); err != nil {
+				log.Printf("Error %v closing outbox publisher", err)
+			}
+			return
+		case <-ticker.C:
+			r.drain(ctx)
+		}
+	}
+}
+
+func NewRelay(cfg config.OutboxCfg, source store.OutboxStore) (Relay, error) {
+	publisher, err := NewPublisher(cfg)
+	return Relay{
+		source:    source,
+		publisher: publisher,
+		interval:  time.Duration(cfg.Interval) * time.Millisecond,
+		timeout:   time.Duration(cfg.Timeout) * time.Millisecond,
+		batchSize: cfg.BatchSize,
+	}, err
+}
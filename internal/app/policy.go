@@ -0,0 +1,100 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"github.com/LearningByExample/go-microservice/internal/app/policy"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+const (
+	policyCommand     = "policy"
+	policyTestCommand = "test"
+	stdinPath         = "-"
+)
+
+var (
+	errPolicyDenied = errors.New("policy denied the request")
+)
+
+type policyTestResult struct {
+	Request  policy.Request  `json:"request"`
+	Decision policy.Decision `json:"decision"`
+}
+
+func readRequest(path string, stdin io.Reader) (policy.Request, error) {
+	req := policy.Request{}
+	var raw []byte
+	var err error
+	if path == stdinPath {
+		raw, err = ioutil.ReadAll(stdin)
+	} else {
+		raw, err = ioutil.ReadFile(path)
+	}
+	if err == nil {
+		err = json.Unmarshal(raw, &req)
+	}
+	return req, err
+}
+
+// runPolicyTest evaluates a sample request against a policy, writing the decision and failing when it is denied
+func runPolicyTest(args []string, stdin io.Reader, stdout io.Writer) error {
+	flags := flag.NewFlagSet(policyCommand+" "+policyTestCommand, flag.ContinueOnError)
+	policyPath := flags.String("policy", "config/policy.json", "policy file path")
+	requestPath := flags.String("request", stdinPath, "request file path, - for the standard input")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	pol, err := policy.Load(*policyPath)
+	if err != nil {
+		return err
+	}
+	req, err := readRequest(*requestPath, stdin)
+	if err != nil {
+		return err
+	}
+
+	result := policyTestResult{Request: req, Decision: pol.Evaluate(req)}
+	encoder := json.NewEncoder(stdout)
+	encoder.SetIndent("", "  ")
+	if err = encoder.Encode(result); err == nil && !result.Decision.Allowed {
+		err = errPolicyDenied
+	}
+	return err
+}
+
+func isPolicyTest(args []string) bool {
+	return len(args) > 2 && args[1] == policyCommand && args[2] == policyTestCommand
+}
+
+func policyTest() {
+	if err := runPolicyTest(os.Args[3:], os.Stdin, os.Stdout); err != nil {
+		logFatal(err)
+	}
+}
@@ -0,0 +1,241 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package policy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/LearningByExample/go-microservice/internal/app/data"
+	"github.com/LearningByExample/go-microservice/internal/app/reqctx"
+	"github.com/LearningByExample/go-microservice/internal/app/resperr"
+	"io/ioutil"
+	"strings"
+)
+
+const (
+	ActionRead   = "read"
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+)
+
+const (
+	FieldName       = "name"
+	FieldRace       = "race"
+	FieldMod        = "mod"
+	FieldStatus     = "status"
+	FieldTags       = "tags"
+	FieldAttributes = "attributes"
+	FieldOwner      = "owner"
+	FieldPhoto      = "photo"
+)
+
+const (
+	// WhenOwner limits a rule to the pets owned by the owner the subject acts for
+	WhenOwner = "owner"
+)
+
+var (
+	validActions = map[string]bool{ActionRead: true, ActionCreate: true, ActionUpdate: true, ActionDelete: true}
+	validFields  = map[string]bool{FieldName: true, FieldRace: true, FieldMod: true, FieldStatus: true, FieldTags: true,
+		FieldAttributes: true, FieldOwner: true, FieldPhoto: true}
+)
+
+// Rule allows some actions, only on the listed fields when there are any and only on the subject pets when it is
+// limited to the owner.
+type Rule struct {
+	Actions []string `json:"actions"`
+	Fields  []string `json:"fields,omitempty"`
+	When    string   `json:"when,omitempty"`
+}
+
+// Policy has the rules of each role, anything that no rule allows is denied.
+type Policy struct {
+	Roles map[string][]Rule `json:"roles"`
+}
+
+type Subject struct {
+	Id      string   `json:"id"`
+	Roles   []string `json:"roles"`
+	OwnerId int      `json:"ownerId,omitempty"`
+}
+
+type Request struct {
+	Subject Subject   `json:"subject"`
+	Action  string    `json:"action"`
+	Pet     *data.Pet `json:"pet,omitempty"`
+	Fields  []string  `json:"fields,omitempty"`
+}
+
+// Decision explains which rule allowed a request or why none of the rules did.
+type Decision struct {
+	Allowed bool   `json:"allowed"`
+	Reason  string `json:"reason"`
+}
+
+func (d Decision) String() string {
+	if d.Allowed {
+		return "allowed, " + d.Reason
+	}
+	return "denied, " + d.Reason
+}
+
+// Err is the error returned to the callers for a denied request
+func (d Decision) Err() error {
+	return resperr.FromErrorMessage(resperr.Forbidden, []string{d.Reason})
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (r Rule) validate() error {
+	if len(r.Actions) == 0 {
+		return fmt.Errorf("rule without actions")
+	}
+	for _, action := range r.Actions {
+		if !validActions[action] {
+			return fmt.Errorf("unknown action %q", action)
+		}
+	}
+	for _, field := range r.Fields {
+		if !validFields[field] {
+			return fmt.Errorf("unknown field %q", field)
+		}
+	}
+	if r.When != "" && r.When != WhenOwner {
+		return fmt.Errorf("unknown condition %q", r.When)
+	}
+	return nil
+}
+
+// deniedFields gets the fields of the request that the rule does not allow
+func (r Rule) deniedFields(fields []string) []string {
+	denied := make([]string, 0)
+	if len(r.Fields) != 0 {
+		for _, field := range fields {
+			if !contains(r.Fields, field) {
+				denied = append(denied, field)
+			}
+		}
+	}
+	return denied
+}
+
+func sortedRoles(roles []string) []string {
+	sorted := data.SortedTags(roles)
+	if sorted == nil {
+		return []string{}
+	}
+	return sorted
+}
+
+func (p Policy) evaluate(req Request, checkPet bool) Decision {
+	roles := sortedRoles(req.Subject.Roles)
+	if len(roles) == 0 {
+		return Decision{Reason: fmt.Sprintf("subject %q has no roles", req.Subject.Id)}
+	}
+
+	reasons := make([]string, 0)
+	for _, role := range roles {
+		for i, rule := range p.Roles[role] {
+			if !contains(rule.Actions, req.Action) {
+				continue
+			}
+			name := fmt.Sprintf("role %q rule %d", role, i+1)
+			if checkPet && rule.When == WhenOwner &&
+				(req.Pet == nil || req.Subject.OwnerId == 0 || req.Pet.Owner() != req.Subject.OwnerId) {
+				reasons = append(reasons, fmt.Sprintf("%s only allows %q on the pets of the subject", name,
+					req.Action))
+				continue
+			}
+			if denied := rule.deniedFields(req.Fields); len(denied) != 0 {
+				reasons = append(reasons, fmt.Sprintf("%s does not allow %q on %s", name, req.Action,
+					strings.Join(denied, ", ")))
+				continue
+			}
+			return Decision{Allowed: true, Reason: fmt.Sprintf("%s allows %q", name, req.Action)}
+		}
+	}
+
+	if len(reasons) == 0 {
+		return Decision{Reason: fmt.Sprintf("no rule allows %q for roles %s", req.Action, strings.Join(roles, ", "))}
+	}
+	return Decision{Reason: strings.Join(reasons, "; ")}
+}
+
+// Evaluate decides a request, a request is allowed when one rule of the roles of the subject allows all of it
+func (p Policy) Evaluate(req Request) Decision {
+	return p.evaluate(req, true)
+}
+
+// Permits decides a request before knowing the pet, so the rules limited to the owner could still allow it
+func (p Policy) Permits(req Request) Decision {
+	return p.evaluate(req, false)
+}
+
+func (p Policy) validate() error {
+	for role, rules := range p.Roles {
+		for i, rule := range rules {
+			if err := rule.validate(); err != nil {
+				return fmt.Errorf("role %q rule %d: %v", role, i+1, err)
+			}
+		}
+	}
+	return nil
+}
+
+func Parse(raw []byte) (Policy, error) {
+	p := Policy{}
+	err := json.Unmarshal(raw, &p)
+	if err == nil {
+		err = p.validate()
+	}
+	return p, err
+}
+
+func Load(path string) (Policy, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Policy{}, err
+	}
+	return Parse(raw)
+}
+
+// SubjectFrom gets the authenticated caller of a request, requests without one have no roles and are denied
+func SubjectFrom(ctx context.Context) Subject {
+	subject := Subject{Id: reqctx.Actor(ctx)}
+	if grants, ok := reqctx.GrantsOf(ctx); ok {
+		if principal, ok := grants.(data.Principal); ok {
+			subject.Roles = principal.Roles
+			subject.OwnerId = principal.OwnerId
+		}
+	}
+	return subject
+}
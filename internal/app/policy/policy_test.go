@@ -0,0 +1,339 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package policy
+
+import (
+	"context"
+	"github.com/LearningByExample/go-microservice/internal/app/config"
+	"github.com/LearningByExample/go-microservice/internal/app/data"
+	"github.com/LearningByExample/go-microservice/internal/app/reqctx"
+	"github.com/LearningByExample/go-microservice/internal/app/resperr"
+	"github.com/LearningByExample/go-microservice/internal/app/store"
+	"github.com/LearningByExample/go-microservice/internal/app/store/memory"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testPolicy = `{
+  "roles": {
+    "staff": [{"actions": ["read", "create", "update", "delete"]}],
+    "volunteer": [{"actions": ["read"]}, {"actions": ["update"], "fields": ["mod"]}],
+    "owner": [{"actions": ["read"], "when": "owner"}]
+  }
+}`
+
+func mustParse(t *testing.T, raw string) Policy {
+	t.Helper()
+	p, err := Parse([]byte(raw))
+	if err != nil {
+		t.Fatalf("want not error, got %v", err)
+	}
+	return p
+}
+
+func ownedPet(id int, owner int) *data.Pet {
+	return &data.Pet{Id: id, OwnerId: &owner}
+}
+
+func asSubject(id string, ownerId int, roles ...string) context.Context {
+	principal := data.Principal{Subject: id, Roles: roles, OwnerId: ownerId}
+	return reqctx.WithGrants(reqctx.WithActor(context.Background(), id), principal)
+}
+
+func isForbidden(err error) bool {
+	rErr, ok := err.(resperr.ResponseError)
+	return ok && rErr.Status() == http.StatusForbidden
+}
+
+func TestEvaluate(t *testing.T) {
+	p := mustParse(t, testPolicy)
+	staff := Subject{Id: "ann", Roles: []string{"staff"}}
+	volunteer := Subject{Id: "bob", Roles: []string{"volunteer"}}
+	owner := Subject{Id: "eve", Roles: []string{"owner"}, OwnerId: 7}
+
+	cases := []struct {
+		name    string
+		req     Request
+		allowed bool
+		reason  string
+	}{
+		{"staff update any pet", Request{Subject: staff, Action: ActionUpdate, Pet: ownedPet(1, 3),
+			Fields: []string{FieldName, FieldRace}}, true, `role "staff" rule 1 allows "update"`},
+		{"volunteer update mod", Request{Subject: volunteer, Action: ActionUpdate, Pet: ownedPet(1, 3),
+			Fields: []string{FieldMod}}, true, `role "volunteer" rule 2 allows "update"`},
+		{"volunteer update name", Request{Subject: volunteer, Action: ActionUpdate, Pet: ownedPet(1, 3),
+			Fields: []string{FieldName, FieldMod}}, false, `role "volunteer" rule 2 does not allow "update" on name`},
+		{"volunteer delete", Request{Subject: volunteer, Action: ActionDelete, Pet: ownedPet(1, 3)}, false,
+			`no rule allows "delete" for roles volunteer`},
+		{"owner read own pet", Request{Subject: owner, Action: ActionRead, Pet: ownedPet(1, 7)}, true,
+			`role "owner" rule 1 allows "read"`},
+		{"owner read other pet", Request{Subject: owner, Action: ActionRead, Pet: ownedPet(1, 3)}, false,
+			`role "owner" rule 1 only allows "read" on the pets of the subject`},
+		{"owner read pet without owner", Request{Subject: owner, Action: ActionRead, Pet: &data.Pet{Id: 1}}, false,
+			`role "owner" rule 1 only allows "read" on the pets of the subject`},
+		{"subject without roles", Request{Subject: Subject{Id: "anonymous"}, Action: ActionRead}, false,
+			`subject "anonymous" has no roles`},
+		{"unknown role", Request{Subject: Subject{Id: "joe", Roles: []string{"visitor"}}, Action: ActionRead}, false,
+			`no rule allows "read" for roles visitor`},
+	}
+
+	for _, tt := range cases {
+		t.Run("should decide "+tt.name, func(t *testing.T) {
+			got := p.Evaluate(tt.req)
+			if got.Allowed != tt.allowed || got.Reason != tt.reason {
+				t.Fatalf("got %v, want allowed %t with reason %q", got, tt.allowed, tt.reason)
+			}
+		})
+	}
+
+	t.Run("should permit a request that the owner rules could allow", func(t *testing.T) {
+		req := Request{Subject: owner, Action: ActionRead, Pet: ownedPet(1, 3)}
+		if got := p.Permits(req); !got.Allowed {
+			t.Fatalf("got %v, want allowed", got)
+		}
+	})
+
+	t.Run("should deny with a forbidden error", func(t *testing.T) {
+		got := Decision{Reason: "no rule"}.Err()
+		if rErr := got.(resperr.ResponseError); rErr.Status() != http.StatusForbidden ||
+			len(rErr.Message) != 1 || rErr.Message[0] != "no rule" {
+			t.Fatalf("got %v, want forbidden with the reason", got)
+		}
+	})
+}
+
+func TestParse(t *testing.T) {
+	cases := map[string]string{
+		"no actions":     `{"roles": {"staff": [{"fields": ["mod"]}]}}`,
+		"unknown action": `{"roles": {"staff": [{"actions": ["fly"]}]}}`,
+		"unknown field":  `{"roles": {"staff": [{"actions": ["update"], "fields": ["color"]}]}}`,
+		"unknown when":   `{"roles": {"staff": [{"actions": ["read"], "when": "friday"}]}}`,
+		"invalid json":   `{"roles": [}`,
+	}
+	for name, raw := range cases {
+		t.Run("should fail with "+name, func(t *testing.T) {
+			if _, err := Parse([]byte(raw)); err == nil {
+				t.Fatalf("got nil, want error")
+			}
+		})
+	}
+
+	t.Run("should load a policy file", func(t *testing.T) {
+		file, _ := ioutil.TempFile("", "policy*.json")
+		defer os.Remove(file.Name())
+		_, _ = file.WriteString(testPolicy)
+		_ = file.Close()
+
+		got, err := Load(file.Name())
+		if err != nil || len(got.Roles) != 3 {
+			t.Fatalf("got %v and %v, want three roles", got, err)
+		}
+		if _, err := Load(file.Name() + ".missing"); err == nil {
+			t.Fatalf("got nil, want error")
+		}
+	})
+}
+
+func TestSubjectFrom(t *testing.T) {
+	got := SubjectFrom(asSubject("eve", 7, "owner"))
+	if got.Id != "eve" || got.OwnerId != 7 || len(got.Roles) != 1 || got.Roles[0] != "owner" {
+		t.Fatalf("got %v, want eve acting for owner 7", got)
+	}
+	if got := SubjectFrom(context.Background()); len(got.Roles) != 0 {
+		t.Fatalf("got %v, want subject without roles", got)
+	}
+}
+
+func TestStore(t *testing.T) {
+	base := memory.NewInMemoryPetStore(config.CfgData{})
+	owners := base.(store.OwnerStore)
+	owner, _ := owners.AddOwner("Eve", "eve@example.com")
+	id, _ := base.AddPet("Fluff", "dog", "happy")
+	other, _ := base.AddPet("Lion", "cat", "brave")
+	_, _ = owners.SetPetOwner(id, owner)
+
+	ps := NewStore(base, mustParse(t, testPolicy))
+	if _, ok := ps.(store.OwnerStore); !ok {
+		t.Fatal("want owner store")
+	}
+	staff := ps.WithContext(asSubject("ann", 0, "staff"))
+	volunteer := ps.WithContext(asSubject("bob", 0, "volunteer"))
+	eve := ps.WithContext(asSubject("eve", owner, "owner"))
+
+	t.Run("should let staff update any pet", func(t *testing.T) {
+		if change, err := staff.UpdatePet(other, "Leo", "cat", "brave"); !change || err != nil {
+			t.Fatalf("got %t and %v, want change", change, err)
+		}
+		if _, err := staff.TransitionPet(other, data.Reserved); err != nil {
+			t.Fatalf("want not error, got %v", err)
+		}
+	})
+
+	t.Run("should let volunteers only change the mod", func(t *testing.T) {
+		if change, err := volunteer.UpdatePet(other, "Leo", "cat", "sleepy"); !change || err != nil {
+			t.Fatalf("got %t and %v, want change", change, err)
+		}
+		if _, err := volunteer.UpdatePet(other, "Lion", "cat", "sleepy"); !isForbidden(err) {
+			t.Fatalf("got %v, want forbidden", err)
+		}
+		if _, err := volunteer.AddPet("Ghost", "cat", "shy"); !isForbidden(err) {
+			t.Fatalf("got %v, want forbidden", err)
+		}
+		if _, err := volunteer.SetPetTags(other, []string{"small"}, nil); !isForbidden(err) {
+			t.Fatalf("got %v, want forbidden", err)
+		}
+		if err := volunteer.DeletePet(other); !isForbidden(err) {
+			t.Fatalf("got %v, want forbidden", err)
+		}
+		if _, err := volunteer.(store.OwnerStore).SetPetOwner(other, owner); !isForbidden(err) {
+			t.Fatalf("got %v, want forbidden", err)
+		}
+	})
+
+	t.Run("should leave not found errors to the store", func(t *testing.T) {
+		if _, err := volunteer.UpdatePet(other+10, "Leo", "cat", "sleepy"); err != store.PetNotFound {
+			t.Fatalf("got %v, want %v", err, store.PetNotFound)
+		}
+	})
+
+	t.Run("should let owners only read their pets", func(t *testing.T) {
+		if _, err := eve.GetPet(id); err != nil {
+			t.Fatalf("want not error, got %v", err)
+		}
+		if _, err := eve.GetPet(other); !isForbidden(err) {
+			t.Fatalf("got %v, want forbidden", err)
+		}
+		if pets, _ := eve.FindPets(store.PetQuery{}); len(pets) != 1 || pets[0].Id != id {
+			t.Fatalf("got %v, want only pet %d", pets, id)
+		}
+		if pets, _ := eve.GetAllPets(); len(pets) != 1 || pets[0].Id != id {
+			t.Fatalf("got %v, want only pet %d", pets, id)
+		}
+		count := 0
+		_ = eve.ForEachPet(func(pet data.Pet) error {
+			count++
+			return nil
+		})
+		if count != 1 {
+			t.Fatalf("got %d pets, want 1", count)
+		}
+		if _, _, err := eve.PetHistory(other, 0, 0); !isForbidden(err) {
+			t.Fatalf("got %v, want forbidden", err)
+		}
+		if _, err := eve.UpdatePet(id, "Fluff", "dog", "sad"); !isForbidden(err) {
+			t.Fatalf("got %v, want forbidden", err)
+		}
+	})
+
+	t.Run("should fill the page with the pets that owners could read", func(t *testing.T) {
+		query := store.PetQuery{Sort: []store.PetSort{{Field: store.SortById, Descending: true}}, Limit: 1}
+		if pets, _ := eve.FindPets(query); len(pets) != 1 || pets[0].Id != id {
+			t.Fatalf("got %v, want only pet %d", pets, id)
+		}
+		if count, err := eve.CountPets(query); count != 1 || err != nil {
+			t.Fatalf("got %d and %v, want 1 pet", count, err)
+		}
+	})
+
+	t.Run("should only count the matches that owners could read", func(t *testing.T) {
+		if matches, total, err := eve.SearchPets("cat", 1, 10); err != nil || len(matches) != 0 || total != 0 {
+			t.Fatalf("got %v, %d and %v, want no matches", matches, total, err)
+		}
+		if matches, total, err := eve.SearchPets("dog", 0, 10); err != nil || total != 1 || matches[0].Pet.Id != id {
+			t.Fatalf("got %v, %d and %v, want only pet %d", matches, total, err, id)
+		}
+	})
+
+	t.Run("should authorize the photo of a pet", func(t *testing.T) {
+		pet, _ := base.GetPet(id)
+		if err := volunteer.(Authorizer).AuthorizePet(pet, ActionUpdate, []string{FieldPhoto}); !isForbidden(err) {
+			t.Fatalf("got %v, want forbidden", err)
+		}
+		if err := staff.(Authorizer).AuthorizePet(pet, ActionUpdate, []string{FieldPhoto}); err != nil {
+			t.Fatalf("want not error, got %v", err)
+		}
+	})
+
+	t.Run("should tell which pets could be read for their events", func(t *testing.T) {
+		if !eve.(Authorizer).CanReadPet(id) || eve.(Authorizer).CanReadPet(other) {
+			t.Fatalf("want only pet %d readable by the owner", id)
+		}
+		if eve.(Authorizer).CanReadPet(other+10) || !volunteer.(Authorizer).CanReadPet(other+10) {
+			t.Fatal("want purged pets readable only without an owner rule")
+		}
+	})
+
+	t.Run("should deny only the forbidden operations of a batch", func(t *testing.T) {
+		ops := []store.PetOperation{
+			{Type: store.UpdateOperation, Pet: data.Pet{Id: other, Name: "Leo", Race: "cat", Mod: "calm"}},
+			{Type: store.DeleteOperation, Pet: data.Pet{Id: other}},
+		}
+		results, err := volunteer.BatchPets(ops, false)
+		if err != nil || len(results) != 2 || results[0].Err != nil || !results[0].Changed ||
+			!isForbidden(results[1].Err) || results[1].Id != other {
+			t.Fatalf("got %v and %v, want second operation forbidden", results, err)
+		}
+		if _, err := volunteer.BatchPets(ops, true); !isForbidden(err) {
+			t.Fatalf("got %v, want forbidden", err)
+		}
+	})
+
+	t.Run("should deny purging and importing without a rule for it", func(t *testing.T) {
+		if _, err := volunteer.PurgePets(time.Now()); !isForbidden(err) {
+			t.Fatalf("got %v, want forbidden", err)
+		}
+		if err := volunteer.ImportPets([]data.Pet{{Name: "Ghost", Race: "cat", Mod: "shy"}}); !isForbidden(err) {
+			t.Fatalf("got %v, want forbidden", err)
+		}
+		if _, err := staff.PurgePets(time.Now()); err != nil {
+			t.Fatalf("want not error, got %v", err)
+		}
+	})
+
+	t.Run("should authorize reassigning the pets of a deleted owner", func(t *testing.T) {
+		from, _ := owners.AddOwner("Max", "max@example.com")
+		to, _ := owners.AddOwner("Zoe", "zoe@example.com")
+		_, _ = owners.SetPetOwner(other, from)
+
+		if err := volunteer.(store.OwnerStore).DeleteOwner(from, to); !isForbidden(err) {
+			t.Fatalf("got %v, want forbidden", err)
+		}
+		if pet, _ := base.GetPet(other); pet.OwnerId == nil || *pet.OwnerId != from {
+			t.Fatalf("got owner %v, want %d", pet.OwnerId, from)
+		}
+		if err := staff.(store.OwnerStore).DeleteOwner(from, to); err != nil {
+			t.Fatalf("want not error, got %v", err)
+		}
+	})
+
+	t.Run("should deny a subject without roles", func(t *testing.T) {
+		_, err := ps.GetPet(id)
+		if !isForbidden(err) || !strings.Contains(err.Error(), "forbidden") {
+			t.Fatalf("got %v, want forbidden", err)
+		}
+	})
+}
@@ -0,0 +1,408 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package policy
+
+import (
+	"context"
+	"fmt"
+	"github.com/LearningByExample/go-microservice/internal/app/data"
+	"github.com/LearningByExample/go-microservice/internal/app/store"
+	"log"
+	"time"
+)
+
+type petStore struct {
+	store.PetStore
+	policy Policy
+	ctx    context.Context
+}
+
+type ownerPetStore struct {
+	petStore
+	store.OwnerStore
+}
+
+// Authorizer authorizes an action on the resources of a pet that are kept outside the store, like its photo or its
+// change events
+type Authorizer interface {
+	AuthorizePet(pet data.Pet, action string, fields []string) error
+	CanReadPet(id int) bool
+}
+
+func target(pet *data.Pet) string {
+	if pet == nil {
+		return "all pets"
+	}
+	if pet.Id == 0 {
+		return "a new pet"
+	}
+	return fmt.Sprintf("pet %d", pet.Id)
+}
+
+// LogDecision explains in the logs why a request was allowed or denied
+func LogDecision(req Request, decision Decision) {
+	outcome := "denied"
+	if decision.Allowed {
+		outcome = "allowed"
+	}
+	log.Printf("Policy %s %q on %s for %q with roles %v: %s", outcome, req.Action, target(req.Pet), req.Subject.Id,
+		req.Subject.Roles, decision.Reason)
+}
+
+func (s petStore) authorize(action string, pet *data.Pet, fields []string) error {
+	req := Request{Subject: SubjectFrom(s.ctx), Action: action, Pet: pet, Fields: fields}
+	decision := s.policy.Evaluate(req)
+	LogDecision(req, decision)
+	if !decision.Allowed {
+		return decision.Err()
+	}
+	return nil
+}
+
+// readable filters the pets that could be read, without logging a decision for each of them
+func (s petStore) readable(pets []data.Pet) []data.Pet {
+	subject := SubjectFrom(s.ctx)
+	allowed := make([]data.Pet, 0, len(pets))
+	for i := range pets {
+		if s.policy.Evaluate(Request{Subject: subject, Action: ActionRead, Pet: &pets[i]}).Allowed {
+			allowed = append(allowed, pets[i])
+		}
+	}
+	return allowed
+}
+
+func (s petStore) current(id int) (data.Pet, error) {
+	pets, err := s.PetStore.FindPets(store.PetQuery{Ids: []int{id}, IncludeDeleted: true})
+	if err == nil && len(pets) == 0 {
+		err = store.PetNotFound
+	}
+	if err != nil {
+		return data.Pet{}, err
+	}
+	return pets[0], nil
+}
+
+func changedFields(current data.Pet, name string, race string, mod string) []string {
+	fields := make([]string, 0, 3)
+	if current.Name != name {
+		fields = append(fields, FieldName)
+	}
+	if current.Race != race {
+		fields = append(fields, FieldRace)
+	}
+	if current.Mod != mod {
+		fields = append(fields, FieldMod)
+	}
+	return fields
+}
+
+func createdFields(pet data.Pet) []string {
+	fields := []string{FieldName, FieldRace, FieldMod}
+	if pet.Status != "" {
+		fields = append(fields, FieldStatus)
+	}
+	if len(pet.Tags) != 0 {
+		fields = append(fields, FieldTags)
+	}
+	if len(pet.Attributes) != 0 {
+		fields = append(fields, FieldAttributes)
+	}
+	if pet.OwnerId != nil {
+		fields = append(fields, FieldOwner)
+	}
+	return fields
+}
+
+// authorizeChange authorizes an action on a pet that exists, leaving to the store the errors for the missing ones
+func (s petStore) authorizeChange(id int, action string, fields func(current data.Pet) []string) error {
+	current, err := s.current(id)
+	if err == store.PetNotFound {
+		return nil
+	}
+	if err == nil {
+		var changed []string = nil
+		if fields != nil {
+			changed = fields(current)
+		}
+		err = s.authorize(action, &current, changed)
+	}
+	return err
+}
+
+func (s petStore) AddPet(name string, race string, mod string) (int, error) {
+	pet := data.Pet{Name: name, Race: race, Mod: mod}
+	if err := s.authorize(ActionCreate, &pet, createdFields(pet)); err != nil {
+		return 0, err
+	}
+	return s.PetStore.AddPet(name, race, mod)
+}
+
+func (s petStore) GetPet(id int) (data.Pet, error) {
+	pet, err := s.PetStore.GetPet(id)
+	if err == nil {
+		if err = s.authorize(ActionRead, &pet, nil); err != nil {
+			pet = data.Pet{}
+		}
+	}
+	return pet, err
+}
+
+func (s petStore) GetAllPets() ([]data.Pet, error) {
+	pets, err := s.PetStore.GetAllPets()
+	if err == nil {
+		pets = s.readable(pets)
+	}
+	return pets, err
+}
+
+// FindPets filters the pets before taking the page, so a page is only short when there are no more pets to read
+func (s petStore) FindPets(query store.PetQuery) ([]data.Pet, error) {
+	limit := query.Limit
+	query.Limit = 0
+	pets, err := s.PetStore.FindPets(query)
+	if err == nil {
+		pets = s.readable(pets)
+	}
+	if limit > 0 && len(pets) > limit {
+		pets = pets[:limit]
+	}
+	return pets, err
+}
+
+func (s petStore) CountPets(query store.PetQuery) (int, error) {
+	query.AfterId, query.Limit = 0, 0
+	pets, err := s.FindPets(query)
+	return len(pets), err
+}
+
+func (s petStore) ForEachPet(fn func(pet data.Pet) error) error {
+	subject := SubjectFrom(s.ctx)
+	return s.PetStore.ForEachPet(func(pet data.Pet) error {
+		if s.policy.Evaluate(Request{Subject: subject, Action: ActionRead, Pet: &pet}).Allowed {
+			return fn(pet)
+		}
+		return nil
+	})
+}
+
+// SearchPets filters all the matches before taking the page, so the total only counts the pets that could be read
+func (s petStore) SearchPets(text string, offset int, limit int) ([]data.PetMatch, int, error) {
+	matches, _, err := s.PetStore.SearchPets(text, 0, 0)
+	if err != nil {
+		return nil, 0, err
+	}
+	subject := SubjectFrom(s.ctx)
+	allowed := make([]data.PetMatch, 0, len(matches))
+	for i := range matches {
+		if s.policy.Evaluate(Request{Subject: subject, Action: ActionRead, Pet: &matches[i].Pet}).Allowed {
+			allowed = append(allowed, matches[i])
+		}
+	}
+	total := len(allowed)
+	page := make([]data.PetMatch, 0)
+	if offset < total {
+		end := total
+		if limit > 0 && offset+limit < total {
+			end = offset + limit
+		}
+		page = append(page, allowed[offset:end]...)
+	}
+	return page, total, nil
+}
+
+func (s petStore) PetHistory(id int, offset int, limit int) ([]data.PetChange, int, error) {
+	if err := s.authorizeChange(id, ActionRead, nil); err != nil {
+		return nil, 0, err
+	}
+	return s.PetStore.PetHistory(id, offset, limit)
+}
+
+func (s petStore) UpdatePet(id int, name string, race string, mod string) (bool, error) {
+	err := s.authorizeChange(id, ActionUpdate, func(current data.Pet) []string {
+		return changedFields(current, name, race, mod)
+	})
+	if err != nil {
+		return false, err
+	}
+	return s.PetStore.UpdatePet(id, name, race, mod)
+}
+
+func (s petStore) DeletePet(id int) error {
+	if err := s.authorizeChange(id, ActionDelete, nil); err != nil {
+		return err
+	}
+	return s.PetStore.DeletePet(id)
+}
+
+// RestorePet is allowed to the subjects that could delete the pet, as it undoes the deletion
+func (s petStore) RestorePet(id int) error {
+	if err := s.authorizeChange(id, ActionDelete, nil); err != nil {
+		return err
+	}
+	return s.PetStore.RestorePet(id)
+}
+
+func (s petStore) TransitionPet(id int, to data.PetStatus) (data.Pet, error) {
+	err := s.authorizeChange(id, ActionUpdate, func(current data.Pet) []string {
+		return []string{FieldStatus}
+	})
+	if err != nil {
+		return data.Pet{}, err
+	}
+	return s.PetStore.TransitionPet(id, to)
+}
+
+func (s petStore) SetPetTags(id int, tags []string, attributes map[string]string) (bool, error) {
+	err := s.authorizeChange(id, ActionUpdate, func(current data.Pet) []string {
+		fields := make([]string, 0, 2)
+		if !current.SameTags(data.SortedTags(tags), current.Attributes) {
+			fields = append(fields, FieldTags)
+		}
+		if !current.SameTags(current.Tags, attributes) {
+			fields = append(fields, FieldAttributes)
+		}
+		return fields
+	})
+	if err != nil {
+		return false, err
+	}
+	return s.PetStore.SetPetTags(id, tags, attributes)
+}
+
+func (s petStore) ImportPets(pets []data.Pet) error {
+	for i := range pets {
+		if err := s.authorize(ActionCreate, &pets[i], createdFields(pets[i])); err != nil {
+			return err
+		}
+	}
+	return s.PetStore.ImportPets(pets)
+}
+
+func (s petStore) authorizeOperation(op store.PetOperation) error {
+	switch op.Type {
+	case store.CreateOperation:
+		return s.authorize(ActionCreate, &op.Pet, createdFields(op.Pet))
+	case store.UpdateOperation:
+		return s.authorizeChange(op.Pet.Id, ActionUpdate, func(current data.Pet) []string {
+			return changedFields(current, op.Pet.Name, op.Pet.Race, op.Pet.Mod)
+		})
+	case store.DeleteOperation:
+		return s.authorizeChange(op.Pet.Id, ActionDelete, nil)
+	}
+	return nil
+}
+
+// BatchPets denies an atomic batch with any denied operation, otherwise only the denied operations fail
+func (s petStore) BatchPets(ops []store.PetOperation, atomic bool) ([]store.PetOperationResult, error) {
+	denied := make([]error, len(ops))
+	allowed := make([]store.PetOperation, 0, len(ops))
+	for i, op := range ops {
+		if denied[i] = s.authorizeOperation(op); denied[i] == nil {
+			allowed = append(allowed, op)
+		} else if atomic {
+			return nil, denied[i]
+		}
+	}
+
+	results, err := s.PetStore.BatchPets(allowed, atomic)
+	if err != nil || len(allowed) == len(ops) {
+		return results, err
+	}
+	merged := make([]store.PetOperationResult, len(ops))
+	next := 0
+	for i, op := range ops {
+		if denied[i] != nil {
+			merged[i] = store.PetOperationResult{Id: op.Pet.Id, Err: denied[i]}
+		} else {
+			merged[i] = results[next]
+			next++
+		}
+	}
+	return merged, nil
+}
+
+// PurgePets requires a rule that allows deleting any pet
+func (s petStore) PurgePets(before time.Time) ([]int, error) {
+	if err := s.authorize(ActionDelete, nil, nil); err != nil {
+		return nil, err
+	}
+	return s.PetStore.PurgePets(before)
+}
+
+func (s petStore) AuthorizePet(pet data.Pet, action string, fields []string) error {
+	return s.authorize(action, &pet, fields)
+}
+
+// CanReadPet tells if a pet could be read, including the deleted ones, without logging a decision as it is asked for
+// every change event; a purged pet is judged without its data, so only the rules that do not need it allow it
+func (s petStore) CanReadPet(id int) bool {
+	pet, err := s.current(id)
+	if err == store.PetNotFound {
+		pet, err = data.Pet{Id: id}, nil
+	}
+	return err == nil && len(s.readable([]data.Pet{pet})) == 1
+}
+
+func (s petStore) WithContext(ctx context.Context) store.PetStore {
+	return newStore(s.PetStore.WithContext(ctx), s.policy, ctx)
+}
+
+func (s ownerPetStore) SetPetOwner(petId int, ownerId int) (bool, error) {
+	err := s.authorizeChange(petId, ActionUpdate, func(current data.Pet) []string {
+		return []string{FieldOwner}
+	})
+	if err != nil {
+		return false, err
+	}
+	return s.OwnerStore.SetPetOwner(petId, ownerId)
+}
+
+// DeleteOwner authorizes changing the owner of every pet of the owner, as they are reassigned or left without owner
+func (s ownerPetStore) DeleteOwner(id int, reassignTo int) error {
+	pets, err := s.PetStore.FindPets(store.PetQuery{OwnerId: id, IncludeDeleted: true})
+	for i := 0; err == nil && i < len(pets); i++ {
+		err = s.authorize(ActionUpdate, &pets[i], []string{FieldOwner})
+	}
+	if err != nil {
+		return err
+	}
+	return s.OwnerStore.DeleteOwner(id, reassignTo)
+}
+
+func (s ownerPetStore) WithContext(ctx context.Context) store.PetStore {
+	return newStore(s.PetStore.WithContext(ctx), s.policy, ctx)
+}
+
+func newStore(ps store.PetStore, policy Policy, ctx context.Context) store.PetStore {
+	decorated := petStore{PetStore: ps, policy: policy, ctx: ctx}
+	if owners, ok := ps.(store.OwnerStore); ok {
+		return ownerPetStore{petStore: decorated, OwnerStore: owners}
+	}
+	return decorated
+}
+
+// NewStore decorates the store for authorizing with the policy every pet operation of the subject of the context,
+// keeping its optional capabilities.
+func NewStore(ps store.PetStore, policy Policy) store.PetStore {
+	return newStore(ps, policy, context.Background())
+}
@@ -0,0 +1,96 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	examplePolicy        = "../../config/policy.json"
+	allowedPolicyRequest = "policy-request-allowed.json"
+	deniedPolicyRequest  = "policy-request-denied.json"
+	invalidPolicy        = "bad-policy.json"
+)
+
+func TestRunPolicyTest(t *testing.T) {
+	t.Run("should allow a request", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		args := []string{"-policy", examplePolicy, "-request", filepath.Join(testDataFolder, allowedPolicyRequest)}
+		if err := runPolicyTest(args, nil, out); err != nil {
+			t.Fatalf("want not error, got %v", err)
+		}
+		got := policyTestResult{}
+		if err := json.Unmarshal(out.Bytes(), &got); err != nil || !got.Decision.Allowed {
+			t.Fatalf("got %v and %v, want allowed decision", out.String(), err)
+		}
+	})
+
+	t.Run("should deny a request explaining why", func(t *testing.T) {
+		out := &bytes.Buffer{}
+		args := []string{"-policy", examplePolicy, "-request", filepath.Join(testDataFolder, deniedPolicyRequest)}
+		if err := runPolicyTest(args, nil, out); err != errPolicyDenied {
+			t.Fatalf("got %v, want %v", err, errPolicyDenied)
+		}
+		got := policyTestResult{}
+		if err := json.Unmarshal(out.Bytes(), &got); err != nil || got.Decision.Allowed ||
+			!strings.Contains(got.Decision.Reason, `does not allow "update" on name`) {
+			t.Fatalf("got %v and %v, want denied decision", out.String(), err)
+		}
+	})
+
+	t.Run("should read the request from the standard input", func(t *testing.T) {
+		in := strings.NewReader(`{"subject": {"id": "bob", "roles": ["owner"], "ownerId": 3},
+			"action": "read", "pet": {"id": 1, "ownerId": 3}}`)
+		if err := runPolicyTest([]string{"-policy", examplePolicy}, in, &bytes.Buffer{}); err != nil {
+			t.Fatalf("want not error, got %v", err)
+		}
+	})
+
+	t.Run("should fail with an invalid policy or request", func(t *testing.T) {
+		cases := [][]string{
+			{"-policy", filepath.Join(testDataFolder, invalidPolicy)},
+			{"-policy", "missing.json"},
+			{"-policy", examplePolicy, "-request", "missing.json"},
+			{"-unknown"},
+		}
+		for _, args := range cases {
+			if err := runPolicyTest(args, strings.NewReader("{}"), &bytes.Buffer{}); err == nil || err == errPolicyDenied {
+				t.Fatalf("got nil, want error for %v", args)
+			}
+		}
+	})
+}
+
+func TestIsPolicyTest(t *testing.T) {
+	if !isPolicyTest([]string{"cmd", "policy", "test"}) {
+		t.Fatalf("got false, want policy test")
+	}
+	if isPolicyTest([]string{"cmd", "-config", "policy"}) {
+		t.Fatalf("got true, want not policy test")
+	}
+}
@@ -0,0 +1,128 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package ratelimit
+
+import (
+	"container/list"
+	"math"
+	"sync"
+	"time"
+)
+
+// Decision is the result of taking a token of a bucket, with the values of the rate limit headers
+type Decision struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration
+	RetryAfter time.Duration
+}
+
+// bucket has the tokens left of a client, refilled at the rate of the rule up to its burst
+type bucket struct {
+	key    string
+	tokens float64
+	last   time.Time
+}
+
+// decide refills the bucket and tells if it has a token, taking it when asked to
+func (b *bucket) decide(rule Rule, now time.Time, take bool) Decision {
+	burst := float64(rule.Burst)
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens += elapsed * rule.Rate
+	}
+	// the burst could be lowered since the last request when the rules are reloaded
+	b.tokens = math.Min(burst, b.tokens)
+	b.last = now
+
+	decision := Decision{Limit: rule.Burst}
+	if b.tokens >= 1 {
+		if take {
+			b.tokens--
+		}
+		decision.Allowed = true
+	} else {
+		decision.RetryAfter = seconds((1 - b.tokens) / rule.Rate)
+	}
+	decision.Remaining = int(b.tokens)
+	decision.Reset = seconds((burst - b.tokens) / rule.Rate)
+	return decision
+}
+
+func seconds(value float64) time.Duration {
+	return time.Duration(math.Ceil(value)) * time.Second
+}
+
+// Limiter has a token bucket by client and rule, evicting the least recently used when there are more clients than
+// its maximum so the memory is bounded, an evicted client starts again with a full bucket.
+type Limiter struct {
+	mu      sync.Mutex
+	max     int
+	buckets map[string]*list.Element
+	lru     *list.List
+}
+
+// Allow takes a token of the bucket of the client for the rule
+func (l *Limiter) Allow(rule Rule, client string, now time.Time) Decision {
+	key := rule.id() + "|" + client
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	element, found := l.buckets[key]
+	if found {
+		l.lru.MoveToFront(element)
+	} else {
+		element = l.lru.PushFront(&bucket{key: key, tokens: float64(rule.Burst), last: now})
+		l.buckets[key] = element
+		if l.lru.Len() > l.max {
+			oldest := l.lru.Back()
+			l.lru.Remove(oldest)
+			delete(l.buckets, oldest.Value.(*bucket).key)
+		}
+	}
+	return element.Value.(*bucket).decide(rule, now, true)
+}
+
+// Peek tells if the bucket of the client for the rule has a token without taking it, a new client does not get a
+// bucket until it takes one
+func (l *Limiter) Peek(rule Rule, client string, now time.Time) Decision {
+	key := rule.id() + "|" + client
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if element, found := l.buckets[key]; found {
+		return element.Value.(*bucket).decide(rule, now, false)
+	}
+	return (&bucket{key: key, tokens: float64(rule.Burst), last: now}).decide(rule, now, false)
+}
+
+// Len gets the number of buckets in memory
+func (l *Limiter) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lru.Len()
+}
+
+func NewLimiter(max int) *Limiter {
+	return &Limiter{max: max, buckets: make(map[string]*list.Element), lru: list.New()}
+}
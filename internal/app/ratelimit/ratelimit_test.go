@@ -0,0 +1,223 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package ratelimit
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+var (
+	testNow = time.Date(2020, 3, 9, 8, 7, 36, 0, time.UTC)
+)
+
+func TestLimiter(t *testing.T) {
+	rule := Rule{Path: "/pets", Rate: 1, Burst: 2}
+
+	t.Run("should allow the burst and then refill at the rate", func(t *testing.T) {
+		limiter := NewLimiter(10)
+
+		first := limiter.Allow(rule, "john", testNow)
+		want := Decision{Allowed: true, Limit: 2, Remaining: 1, Reset: time.Second}
+		if first != want {
+			t.Fatalf("got %v, want %v", first, want)
+		}
+		if got := limiter.Allow(rule, "john", testNow); !got.Allowed || got.Remaining != 0 || got.Reset != 2*time.Second {
+			t.Fatalf("got %v, want the last token", got)
+		}
+		got := limiter.Allow(rule, "john", testNow.Add(500*time.Millisecond))
+		want = Decision{Allowed: false, Limit: 2, Remaining: 0, Reset: 2 * time.Second, RetryAfter: time.Second}
+		if got != want {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+		if got := limiter.Allow(rule, "john", testNow.Add(1500*time.Millisecond)); !got.Allowed {
+			t.Fatalf("got %v, want a refilled token", got)
+		}
+	})
+
+	t.Run("should have a bucket by client and rule", func(t *testing.T) {
+		limiter := NewLimiter(10)
+		other := Rule{Path: "/owners", Rate: 1, Burst: 1}
+
+		_ = limiter.Allow(other, "john", testNow)
+		if got := limiter.Allow(other, "john", testNow); got.Allowed {
+			t.Fatalf("got %v, want not allowed", got)
+		}
+		if got := limiter.Allow(other, "jane", testNow); !got.Allowed {
+			t.Fatalf("got %v, want allowed for other client", got)
+		}
+		if got := limiter.Allow(rule, "john", testNow); !got.Allowed {
+			t.Fatalf("got %v, want allowed for other rule", got)
+		}
+	})
+
+	t.Run("should lower the tokens when the burst is lowered", func(t *testing.T) {
+		limiter := NewLimiter(10)
+
+		_ = limiter.Allow(Rule{Path: "/pets", Rate: 1, Burst: 10}, "john", testNow)
+		if got := limiter.Allow(rule, "john", testNow); !got.Allowed || got.Remaining != 1 {
+			t.Fatalf("got %v, want the tokens of the new burst", got)
+		}
+	})
+
+	t.Run("should peek a bucket without taking its tokens", func(t *testing.T) {
+		limiter := NewLimiter(10)
+
+		if got := limiter.Peek(rule, "john", testNow); !got.Allowed || got.Remaining != 2 || limiter.Len() != 0 {
+			t.Fatalf("got %v and %d buckets, want a full bucket not kept", got, limiter.Len())
+		}
+		_ = limiter.Allow(rule, "john", testNow)
+		_ = limiter.Allow(rule, "john", testNow)
+		if got := limiter.Peek(rule, "john", testNow); got.Allowed || got.RetryAfter != time.Second {
+			t.Fatalf("got %v, want an empty bucket", got)
+		}
+		if got := limiter.Peek(rule, "john", testNow.Add(time.Second)); !got.Allowed || got.Remaining != 1 {
+			t.Fatalf("got %v, want a refilled token", got)
+		}
+		if got := limiter.Allow(rule, "john", testNow.Add(time.Second)); !got.Allowed {
+			t.Fatalf("got %v, want the peeked token still there", got)
+		}
+	})
+
+	t.Run("should evict the least recently used clients", func(t *testing.T) {
+		limiter := NewLimiter(2)
+		single := Rule{Path: "/pets", Rate: 1, Burst: 1}
+
+		_ = limiter.Allow(single, "john", testNow)
+		_ = limiter.Allow(single, "jane", testNow)
+		_ = limiter.Allow(single, "john", testNow)
+		_ = limiter.Allow(single, "bob", testNow)
+
+		if limiter.Len() != 2 {
+			t.Fatalf("got %d buckets, want 2", limiter.Len())
+		}
+		if got := limiter.Allow(single, "john", testNow); got.Allowed {
+			t.Fatalf("got %v, want the bucket of the recently used client kept", got)
+		}
+		if got := limiter.Allow(single, "jane", testNow); !got.Allowed {
+			t.Fatalf("got %v, want the evicted client with a full bucket", got)
+		}
+	})
+}
+
+func TestParseRules(t *testing.T) {
+	type testCase struct {
+		name  string
+		raw   string
+		valid bool
+	}
+	cases := []testCase{
+		{name: "rules", raw: `[{"path":"/pets","methods":["POST"],"rate":5,"burst":10}]`, valid: true},
+		{name: "unlimited rule", raw: `[{"path":"/health/"}]`, valid: true},
+		{name: "negative rate", raw: `[{"path":"/pets","rate":-1,"burst":10}]`},
+		{name: "rate without burst", raw: `[{"path":"/pets","rate":5}]`},
+		{name: "not json", raw: `rules`},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseRules([]byte(tt.raw))
+			if (err == nil) != tt.valid {
+				t.Fatalf("got %v, want valid %t", err, tt.valid)
+			}
+		})
+	}
+
+	t.Run("should tell the invalid rule", func(t *testing.T) {
+		if _, err := ParseRules([]byte(`[{"rate":1,"burst":1},{"rate":1}]`)); !errors.Is(err, InvalidRule) {
+			t.Fatalf("got %v, want %v", err, InvalidRule)
+		}
+	})
+}
+
+func TestRulesMatch(t *testing.T) {
+	rules, err := NewRules("", []byte(`[
+		{"path":"/pets","methods":["post","PUT"],"rate":1,"burst":1},
+		{"path":"/health/"},
+		{"path":"/","rate":10,"burst":20}
+	]`))
+	if err != nil {
+		t.Fatalf("error creating rules: %v", err)
+	}
+
+	type testCase struct {
+		method string
+		path   string
+		want   int
+	}
+	cases := []testCase{
+		{method: "POST", path: "/pets", want: 1},
+		{method: "PUT", path: "/pets/1", want: 1},
+		{method: "GET", path: "/pets/1", want: 20},
+		{method: "GET", path: "/health/readiness", want: 0},
+	}
+	for _, tt := range cases {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			if rule, found := rules.Match(tt.method, tt.path); !found || rule.Burst != tt.want {
+				t.Fatalf("got %v and %t, want burst %d", rule, found, tt.want)
+			}
+		})
+	}
+
+	t.Run("should not match without rules", func(t *testing.T) {
+		empty, _ := NewRules("", nil)
+		if rule, found := empty.Match("GET", "/pets"); found {
+			t.Fatalf("got %v, want none", rule)
+		}
+	})
+}
+
+func TestRulesReload(t *testing.T) {
+	file, err := ioutil.TempFile("", "rules")
+	if err != nil {
+		t.Fatalf("error creating temp file: %v", err)
+	}
+	defer os.Remove(file.Name())
+	_, _ = file.WriteString(`[{"path":"/pets","rate":1,"burst":5}]`)
+	_ = file.Close()
+
+	rules, err := NewRules(file.Name(), []byte(`[{"path":"/","rate":1,"burst":1}]`))
+	if rule, _ := rules.Match("GET", "/pets"); err != nil || rule.Burst != 5 {
+		t.Fatalf("got %v and %v, want the rule of the file first", rule, err)
+	}
+
+	_ = ioutil.WriteFile(file.Name(), []byte(`[{"path":"/pets","rate":1,"burst":3}]`), 0644)
+	_ = os.Chtimes(file.Name(), testNow, testNow)
+	if err = rules.Reload(); err != nil {
+		t.Fatalf("want not error, got %v", err)
+	}
+	if rule, _ := rules.Match("GET", "/pets"); rule.Burst != 3 {
+		t.Fatalf("got %v, want the reloaded rule", rule)
+	}
+
+	_ = ioutil.WriteFile(file.Name(), []byte(`[{"path":"/pets","rate":1}]`), 0644)
+	_ = os.Chtimes(file.Name(), testNow.Add(time.Hour), testNow.Add(time.Hour))
+	if err = rules.Reload(); err == nil {
+		t.Fatal("want error, got nil")
+	}
+	if rule, _ := rules.Match("GET", "/pets"); rule.Burst != 3 {
+		t.Fatalf("got %v, want the previous rule kept", rule)
+	}
+}
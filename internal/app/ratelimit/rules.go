@@ -0,0 +1,174 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package ratelimit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	InvalidRule = errors.New("invalid rate limit rule")
+)
+
+// Rule limits the requests with a path prefix and one of the methods, any path or method when they are empty, to a
+// rate by second with a burst. A rule without rate does not limit the requests it matches.
+type Rule struct {
+	Path    string   `json:"path"`
+	Methods []string `json:"methods"`
+	Rate    float64  `json:"rate"`
+	Burst   int      `json:"burst"`
+}
+
+func (r Rule) IsUnlimited() bool {
+	return r.Rate == 0
+}
+
+func (r Rule) id() string {
+	return strings.Join(r.Methods, ",") + " " + r.Path
+}
+
+func (r Rule) matches(method string, path string) bool {
+	if !strings.HasPrefix(path, r.Path) {
+		return false
+	}
+	if len(r.Methods) == 0 {
+		return true
+	}
+	for _, allowed := range r.Methods {
+		if strings.EqualFold(allowed, method) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r Rule) isValid() bool {
+	return r.Rate >= 0 && (r.IsUnlimited() || r.Burst >= 1)
+}
+
+func ParseRules(raw []byte) ([]Rule, error) {
+	var rules []Rule
+	if err := json.Unmarshal(raw, &rules); err != nil {
+		return nil, err
+	}
+	for i, rule := range rules {
+		if !rule.isValid() {
+			return nil, fmt.Errorf("%w %d", InvalidRule, i)
+		}
+	}
+	return rules, nil
+}
+
+// Rules has the rules inlined in the configuration and the ones of a file, that are reloaded when it changes keeping
+// the previous rules if the file is not valid. The rules of the file are matched first.
+type Rules struct {
+	mu       sync.RWMutex
+	static   []Rule
+	loaded   []Rule
+	path     string
+	modified time.Time
+}
+
+// Match gets the first rule for a request
+func (s *Rules) Match(method string, path string) (Rule, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, rules := range [][]Rule{s.loaded, s.static} {
+		for _, rule := range rules {
+			if rule.matches(method, path) {
+				return rule, true
+			}
+		}
+	}
+	return Rule{}, false
+}
+
+func (s *Rules) Reload() error {
+	if s.path == "" {
+		return nil
+	}
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+	s.mu.RLock()
+	unchanged := info.ModTime().Equal(s.modified)
+	s.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+	raw, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+	rules, err := ParseRules(raw)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.loaded = rules
+	s.modified = info.ModTime()
+	log.Printf("Loaded %d rate limit rules from %q.", len(rules), s.path)
+	return nil
+}
+
+func (s *Rules) Watch(interval time.Duration) func(ctx context.Context) {
+	return func(ctx context.Context) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := s.Reload(); err != nil {
+					log.Printf("Error %v reloading rate limit rules from %q", err, s.path)
+				}
+			}
+		}
+	}
+}
+
+func NewRules(path string, inline []byte) (*Rules, error) {
+	set := &Rules{path: path}
+	var err error = nil
+	if len(inline) != 0 {
+		set.static, err = ParseRules(inline)
+	}
+	if err == nil {
+		err = set.Reload()
+	}
+	return set, err
+}
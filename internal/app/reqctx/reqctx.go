@@ -0,0 +1,83 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package reqctx
+
+import (
+	"context"
+)
+
+type key int
+
+const (
+	actorKey key = iota
+	requestIdKey
+	grantsKey
+)
+
+const (
+	Anonymous = "anonymous"
+)
+
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey, actor)
+}
+
+func Actor(ctx context.Context) string {
+	if actor, ok := ctx.Value(actorKey).(string); ok && actor != "" {
+		return actor
+	}
+	return Anonymous
+}
+
+func WithRequestId(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIdKey, id)
+}
+
+func RequestId(ctx context.Context) string {
+	if id, ok := ctx.Value(requestIdKey).(string); ok {
+		return id
+	}
+	return ""
+}
+
+// Grants are the permissions of an authenticated caller
+type Grants interface {
+	HasScope(scope string) bool
+}
+
+func WithGrants(ctx context.Context, grants Grants) context.Context {
+	return context.WithValue(ctx, grantsKey, grants)
+}
+
+func GrantsOf(ctx context.Context) (Grants, bool) {
+	grants, ok := ctx.Value(grantsKey).(Grants)
+	return grants, ok
+}
+
+// Allowed reports if the caller has the scope, requests that were not authenticated carry no grants and are allowed
+func Allowed(ctx context.Context, scope string) bool {
+	if grants, ok := ctx.Value(grantsKey).(Grants); ok {
+		return grants.HasScope(scope)
+	}
+	return true
+}
@@ -0,0 +1,87 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package reqctx
+
+import (
+	"context"
+	"testing"
+)
+
+func TestActor(t *testing.T) {
+	t.Run("should be anonymous without actor", func(t *testing.T) {
+		if got := Actor(context.Background()); got != Anonymous {
+			t.Fatalf("got %q, want %q", got, Anonymous)
+		}
+	})
+
+	t.Run("should get actor", func(t *testing.T) {
+		ctx := WithActor(context.Background(), "john")
+		if got := Actor(ctx); got != "john" {
+			t.Fatalf("got %q, want %q", got, "john")
+		}
+	})
+}
+
+func TestRequestId(t *testing.T) {
+	t.Run("should be empty without request id", func(t *testing.T) {
+		if got := RequestId(context.Background()); got != "" {
+			t.Fatalf("got %q, want empty", got)
+		}
+	})
+
+	t.Run("should get request id", func(t *testing.T) {
+		ctx := WithRequestId(context.Background(), "abc")
+		if got := RequestId(ctx); got != "abc" {
+			t.Fatalf("got %q, want %q", got, "abc")
+		}
+	})
+}
+
+type testGrants []string
+
+func (g testGrants) HasScope(scope string) bool {
+	for _, s := range g {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func TestAllowed(t *testing.T) {
+	t.Run("should be allowed without grants", func(t *testing.T) {
+		if !Allowed(context.Background(), "write") {
+			t.Fatal("want allowed got denied")
+		}
+	})
+
+	t.Run("should check the grants", func(t *testing.T) {
+		ctx := WithGrants(context.Background(), testGrants{"read"})
+		if !Allowed(ctx, "read") || Allowed(ctx, "write") {
+			t.Fatal("want only read allowed")
+		}
+		if grants, ok := GrantsOf(ctx); !ok || !grants.HasScope("read") {
+			t.Fatalf("got %v, want grants", grants)
+		}
+	})
+}
@@ -37,6 +37,13 @@ const (
 	badRequest       = "bad request"
 	resourceNotFound = "resource not found"
 	invalidResource  = "invalid resource"
+	notAcceptable    = "not acceptable"
+	unsupportedMedia = "unsupported media type"
+	conflict         = "conflict"
+	tooLarge         = "request entity too large"
+	unauthorized     = "unauthorized"
+	forbidden        = "forbidden"
+	tooManyRequests  = "too many requests"
 )
 
 type ResponseError struct {
@@ -94,5 +101,12 @@ var (
 	NotBodyProvided = NewResErrForStr(notBodyProvided, http.StatusBadRequest)
 	BadRequest      = NewResErrForStr(badRequest, http.StatusBadRequest)
 	NotFound        = NewResErrForStr(resourceNotFound, http.StatusNotFound)
+	NotAcceptable   = NewResErrForStr(notAcceptable, http.StatusNotAcceptable)
+	UnsupportedType = NewResErrForStr(unsupportedMedia, http.StatusUnsupportedMediaType)
+	Conflict        = NewResErrForStr(conflict, http.StatusConflict)
+	TooLarge        = NewResErrForStr(tooLarge, http.StatusRequestEntityTooLarge)
+	Unauthorized    = NewResErrForStr(unauthorized, http.StatusUnauthorized)
+	Forbidden       = NewResErrForStr(forbidden, http.StatusForbidden)
+	TooManyRequests = NewResErrForStr(tooManyRequests, http.StatusTooManyRequests)
 	None            = ResponseError{status: http.StatusOK}
 )
@@ -0,0 +1,143 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package server
+
+import (
+	"encoding/json"
+	"github.com/LearningByExample/go-microservice/internal/app/config"
+	"github.com/LearningByExample/go-microservice/internal/app/logging"
+	"github.com/LearningByExample/go-microservice/internal/app/resperr"
+	"log"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"runtime/debug"
+)
+
+const (
+	metricsPath      = "/metrics"
+	pprofPath        = "/debug/pprof/"
+	pprofCmdlinePath = "/debug/pprof/cmdline"
+	pprofProfilePath = "/debug/pprof/profile"
+	pprofSymbolPath  = "/debug/pprof/symbol"
+	pprofTracePath   = "/debug/pprof/trace"
+	buildInfoPath    = "/buildinfo"
+	configPath       = "/config"
+	logLevelPath     = "/loglevel"
+	logLevelNotValid = "log level must be info or error"
+)
+
+var (
+	// buildVersion is set when building with -ldflags "-X <package>.buildVersion=<version>"
+	buildVersion = "dev"
+)
+
+type buildInfo struct {
+	Version       string `json:"version"`
+	GoVersion     string `json:"goVersion"`
+	Module        string `json:"module,omitempty"`
+	ModuleVersion string `json:"moduleVersion,omitempty"`
+}
+
+type logLevel struct {
+	Level string `json:"level"`
+}
+
+// adminHandler serves the internals of the service, only in the admin listener
+type adminHandler struct {
+	cfg config.CfgData
+}
+
+func (h adminHandler) buildInfo() buildInfo {
+	info := buildInfo{Version: buildVersion, GoVersion: runtime.Version()}
+	if module, ok := debug.ReadBuildInfo(); ok {
+		info.Module = module.Main.Path
+		info.ModuleVersion = module.Main.Version
+	}
+	return info
+}
+
+func (h adminHandler) putLogLevel(r *http.Request) error {
+	if r.Body == nil {
+		return resperr.NotBodyProvided
+	}
+	level := logLevel{}
+	if err := json.NewDecoder(r.Body).Decode(&level); err != nil {
+		return resperr.InvalidResource
+	}
+	if err := logging.SetLevel(level.Level); err != nil {
+		return resperr.FromErrorMessage(resperr.InvalidResource, []string{logLevelNotValid})
+	}
+	log.Printf("Log level changed to %q", level.Level)
+	return nil
+}
+
+func (h adminHandler) serve(w http.ResponseWriter, r *http.Request) error {
+	switch {
+	case r.URL.Path == buildInfoPath && r.Method == http.MethodGet:
+		return writeJson(w, h.buildInfo())
+	case r.URL.Path == configPath && r.Method == http.MethodGet:
+		return writeJson(w, h.cfg.Redacted())
+	case r.URL.Path == logLevelPath && r.Method == http.MethodGet:
+		return writeJson(w, logLevel{Level: logging.Level()})
+	case r.URL.Path == logLevelPath && r.Method == http.MethodPut:
+		if err := h.putLogLevel(r); err != nil {
+			return err
+		}
+		return writeJson(w, logLevel{Level: logging.Level()})
+	case r.URL.Path == buildInfoPath || r.URL.Path == configPath || r.URL.Path == logLevelPath:
+		return resperr.BadRequest
+	}
+	return resperr.NotFound
+}
+
+func (h adminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var rErr = resperr.None
+
+	if err := h.serve(w, r); err != nil {
+		rErr = resperr.FromError(err)
+	}
+
+	if rErr.Status() != http.StatusOK {
+		log.Printf("Error %v in %s request %q", rErr, r.Method, r.URL.Path)
+	}
+
+	if rErr.Status() != resperr.None.Status() {
+		rErr.Write(w)
+	}
+}
+
+// newAdminMux routes the health checks, the metrics, the profiles, the build info, the config and the log level
+func newAdminMux(cfg config.CfgData, health http.Handler, m *metrics) *http.ServeMux {
+	mux := http.NewServeMux()
+	admin := adminHandler{cfg: cfg}
+	mux.Handle(rootPath, admin)
+	mux.Handle(healthPath, health)
+	mux.Handle(metricsPath, m)
+	mux.HandleFunc(pprofPath, pprof.Index)
+	mux.HandleFunc(pprofCmdlinePath, pprof.Cmdline)
+	mux.HandleFunc(pprofProfilePath, pprof.Profile)
+	mux.HandleFunc(pprofSymbolPath, pprof.Symbol)
+	mux.HandleFunc(pprofTracePath, pprof.Trace)
+	return mux
+}
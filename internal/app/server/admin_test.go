@@ -0,0 +1,211 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/LearningByExample/go-microservice/internal/_test"
+	"github.com/LearningByExample/go-microservice/internal/app/config"
+	"github.com/LearningByExample/go-microservice/internal/app/logging"
+	"github.com/LearningByExample/go-microservice/internal/app/resperr"
+)
+
+func TestAdminHandler(t *testing.T) {
+	defer logging.SetLevel(logging.Info)
+	st := _test.NewSpyStore()
+	cfg := config.CfgData{
+		Server: config.ServerCfg{Port: 8080, AdminPort: 9000},
+		Auth:   config.AuthCfg{Enabled: true, BootstrapKey: testBootstrapKey},
+	}
+	handler := newAdminMux(cfg, NewHealthHandler(&st), newMetrics())
+
+	t.Run("should get the build info", func(t *testing.T) {
+		response := _test.GetRequest(handler, buildInfoPath)
+		got := buildInfo{}
+		if err := json.NewDecoder(response.Body).Decode(&got); err != nil || got.Version != buildVersion ||
+			!strings.HasPrefix(got.GoVersion, "go") {
+			t.Fatalf("got %v and %v, want build info", got, err)
+		}
+	})
+
+	t.Run("should get the config without secrets", func(t *testing.T) {
+		response := _test.GetRequest(handler, configPath)
+		got := config.CfgData{}
+		if err := json.NewDecoder(response.Body).Decode(&got); err != nil || got.Server.AdminPort != 9000 ||
+			got.Auth.BootstrapKey == testBootstrapKey {
+			t.Fatalf("got %v and %v, want config without the bootstrap key", got, err)
+		}
+	})
+
+	t.Run("should change the log level", func(t *testing.T) {
+		response := _test.PutRequest(handler, logLevelPath, logLevel{Level: logging.Error})
+		if response.Code != http.StatusOK || logging.Level() != logging.Error {
+			t.Fatalf("got %d and level %q, want %q", response.Code, logging.Level(), logging.Error)
+		}
+		got := logLevel{}
+		response = _test.GetRequest(handler, logLevelPath)
+		if err := json.NewDecoder(response.Body).Decode(&got); err != nil || got.Level != logging.Error {
+			t.Fatalf("got %v and %v, want level %q", got, err, logging.Error)
+		}
+	})
+
+	t.Run("should reject an invalid log level", func(t *testing.T) {
+		response := _test.PutRequest(handler, logLevelPath, logLevel{Level: "verbose"})
+		_test.AssertResponseError(t, response, resperr.FromErrorMessage(resperr.InvalidResource,
+			[]string{logLevelNotValid}))
+	})
+
+	t.Run("should check the health", func(t *testing.T) {
+		if response := _test.GetRequest(handler, livenessUrl); response.Code != http.StatusOK {
+			t.Fatalf("got %d, want %d", response.Code, http.StatusOK)
+		}
+	})
+
+	t.Run("should serve the profiles", func(t *testing.T) {
+		if response := _test.GetRequest(handler, pprofPath); response.Code != http.StatusOK {
+			t.Fatalf("got %d, want %d", response.Code, http.StatusOK)
+		}
+	})
+
+	t.Run("should reject unknown paths and methods", func(t *testing.T) {
+		_test.AssertResponseError(t, _test.GetRequest(handler, "/pets"), resperr.NotFound)
+		_test.AssertResponseError(t, _test.DeleteRequest(handler, configPath), resperr.BadRequest)
+	})
+}
+
+func TestMetrics(t *testing.T) {
+	m := newMetrics()
+	handler := m.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			w.WriteHeader(http.StatusCreated)
+		}
+	}))
+	_test.GetRequest(handler, "/pets")
+	_test.GetRequest(handler, "/pets")
+	_test.PostRequest(handler, "/pets", nil)
+	_test.HeaderRequest(handler, "/pets", "MADEUP", "", nil)
+	_test.HeaderRequest(handler, "/pets", "INVENTED", "", nil)
+
+	response := _test.GetRequest(m, metricsPath)
+	got := response.Body.String()
+	for _, want := range []string{
+		"# TYPE http_requests_total counter",
+		`http_requests_total{method="GET",code="200"} 2`,
+		`http_requests_total{method="POST",code="201"} 1`,
+		`http_request_duration_seconds_count{method="GET",code="200"} 2`,
+		`http_requests_total{method="OTHER",code="200"} 2`,
+		"go_goroutines ",
+	} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	}
+}
+
+func getBody(t *testing.T, url string) (int, string) {
+	t.Helper()
+	client := http.Client{Timeout: 5 * time.Second}
+	var response *http.Response
+	var err error
+	for i := 0; i < 50; i++ {
+		if response, err = client.Get(url); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("error getting %q: %v", url, err)
+	}
+	defer response.Body.Close()
+	body, _ := ioutil.ReadAll(response.Body)
+	return response.StatusCode, string(body)
+}
+
+func TestServerWithAdmin(t *testing.T) {
+	st := _test.NewSpyStore()
+	cfg := config.CfgData{
+		Server: config.ServerCfg{Port: 8080, AdminPort: 9000, AdminAddress: "127.0.0.1"},
+	}
+	srv := NewServer(cfg, &st).(*server)
+	if want := "127.0.0.1:9000"; srv.admin.Addr != want {
+		t.Fatalf("got admin address %q, want %q", srv.admin.Addr, want)
+	}
+	srv.adminLn = localListener(t)
+
+	addr, errs := serveLocal(t, srv)
+
+	if code, _ := getBody(t, fmt.Sprintf("http://%s/pets/1", addr)); code != http.StatusOK {
+		t.Fatalf("got %d, want %d", code, http.StatusOK)
+	}
+	if code, _ := getBody(t, fmt.Sprintf("http://%s%s", addr, livenessUrl)); code != http.StatusNotFound {
+		t.Fatalf("got %d, want health checks only in the admin port", code)
+	}
+	admin := fmt.Sprintf("http://%s", srv.adminLn.Addr())
+	if code, _ := getBody(t, admin+livenessUrl); code != http.StatusOK {
+		t.Fatalf("got %d, want %d", code, http.StatusOK)
+	}
+	want := `http_requests_total{method="GET",code="200"} 1`
+	if _, body := getBody(t, admin+metricsPath); !strings.Contains(body, want) {
+		t.Fatalf("got %q, want %q", body, want)
+	}
+
+	srv.Stop()
+
+	if got := <-errs; len(got) != 0 {
+		t.Fatalf("want no errors, got %v", got)
+	}
+}
+
+func TestServerWithAdminPortInUse(t *testing.T) {
+	listener, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer listener.Close()
+
+	st := _test.NewSpyStore()
+	cfg := config.CfgData{
+		Server: config.ServerCfg{Port: 8080, AdminPort: listener.Addr().(*net.TCPAddr).Port},
+	}
+	srv := NewServer(cfg, &st).(*server)
+
+	ln := localListener(t)
+	if got := srv.Listen(ln); len(got) != 1 {
+		t.Fatalf("got %v, want the error of the admin listener", got)
+	}
+	if conn, err := net.Dial("tcp", ln.Addr().String()); err == nil {
+		_ = conn.Close()
+		t.Fatal("want the given listener closed, got connected")
+	}
+	if !st.CloseWasCall {
+		t.Fatal("close was not called")
+	}
+}
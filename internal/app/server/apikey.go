@@ -0,0 +1,243 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/LearningByExample/go-microservice/internal/app/constants"
+	"github.com/LearningByExample/go-microservice/internal/app/data"
+	"github.com/LearningByExample/go-microservice/internal/app/resperr"
+	"github.com/LearningByExample/go-microservice/internal/app/store"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+)
+
+type apiKeyHandler struct {
+	apiKeyIdPathReg     *regexp.Regexp
+	apiKeyNoIdPathReg   *regexp.Regexp
+	apiKeyRotatePathReg *regexp.Regexp
+	data                store.PetStore
+	methods             methodsMap
+}
+
+type apiKeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+// apiKeyResponse includes the key itself, that is only shown when the key is created or rotated
+type apiKeyResponse struct {
+	data.ApiKey
+	Key string `json:"key"`
+}
+
+const (
+	apiKeyPath          = "/apikeys"
+	apiKeySlash         = "/apikeys/"
+	apiKeyIdExpr        = `^\/apikeys\/(\d*)$`
+	apiKeyNotIdExpr     = `^\/apikeys$`
+	apiKeyRotateExpr    = `^\/apikeys\/(\d+)\/rotate$`
+	apiKeyLocation      = "/apikeys/%d"
+	maxApiKeyNameLength = 100
+	apiKeyNameEmpty     = "api key name cannot be empty"
+	apiKeyNameTooLong   = "api key name is too long"
+	apiKeyScopesEmpty   = "api key scopes cannot be empty"
+	apiKeyScopeNotValid = "api key scope is not valid"
+)
+
+func apiKeysFor(s store.PetStore, r *http.Request) store.ApiKeyStore {
+	return s.WithContext(r.Context()).(store.ApiKeyStore)
+}
+
+func apiKeyError(err error) error {
+	if err == store.ApiKeyNotFound {
+		return resperr.NotFound
+	}
+	return err
+}
+
+func (s apiKeyHandler) apiKeyID(path string) (int, error) {
+	matches := s.apiKeyIdPathReg.FindStringSubmatch(path)
+	if len(matches) == 2 {
+		return strconv.Atoi(matches[1])
+	}
+	return 0, ErrPathNotValid
+}
+
+func validApiKey(key apiKeyRequest) error {
+	msg := make([]string, 0, 2)
+
+	if key.Name == "" {
+		msg = append(msg, apiKeyNameEmpty)
+	} else if len(key.Name) > maxApiKeyNameLength {
+		msg = append(msg, apiKeyNameTooLong)
+	}
+	if len(key.Scopes) == 0 {
+		msg = append(msg, apiKeyScopesEmpty)
+	}
+	for _, scope := range key.Scopes {
+		if !data.IsValidScope(scope) {
+			msg = append(msg, apiKeyScopeNotValid)
+			break
+		}
+	}
+
+	if len(msg) == 0 {
+		return nil
+	} else {
+		return resperr.FromErrorMessage(resperr.InvalidResource, msg)
+	}
+}
+
+func decodeApiKey(r *http.Request) (apiKeyRequest, error) {
+	key := apiKeyRequest{}
+	if r.Body == nil {
+		return key, resperr.NotBodyProvided
+	}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&key); err != nil {
+		return key, resperr.InvalidResource
+	}
+	key.Scopes = data.SortedTags(key.Scopes)
+	return key, validApiKey(key)
+}
+
+func (s apiKeyHandler) getApiKeyRequest(w http.ResponseWriter, r *http.Request) error {
+	if s.apiKeyNoIdPathReg.MatchString(r.URL.Path) {
+		keys, err := apiKeysFor(s.data, r).GetAllApiKeys()
+		if err != nil {
+			return err
+		}
+		return writeJson(w, keys)
+	}
+	if id, err := s.apiKeyID(r.URL.Path); err == nil {
+		key, err := apiKeysFor(s.data, r).GetApiKey(id)
+		if err != nil {
+			return apiKeyError(err)
+		}
+		return writeJson(w, key)
+	}
+	return resperr.InvalidUrl
+}
+
+func (s apiKeyHandler) postApiKeyRequest(w http.ResponseWriter, r *http.Request) error {
+	if matches := s.apiKeyRotatePathReg.FindStringSubmatch(r.URL.Path); len(matches) == 2 {
+		id, _ := strconv.Atoi(matches[1])
+		return s.rotateApiKey(w, r, id)
+	}
+	if !s.apiKeyNoIdPathReg.MatchString(r.URL.Path) {
+		return resperr.InvalidUrl
+	}
+	request, err := decodeApiKey(r)
+	if err != nil {
+		return err
+	}
+	plain, prefix, hash, err := newApiKey()
+	if err != nil {
+		return err
+	}
+	ks := apiKeysFor(s.data, r)
+	id, err := ks.AddApiKey(request.Name, prefix, hash, request.Scopes)
+	if err != nil {
+		return err
+	}
+	key, err := ks.GetApiKey(id)
+	if err != nil {
+		return apiKeyError(err)
+	}
+	w.Header().Set(constants.Location, fmt.Sprintf(apiKeyLocation, id))
+	return writeJson(w, apiKeyResponse{ApiKey: key, Key: plain})
+}
+
+// rotateApiKey replaces the key keeping its name and scopes, the previous key stops working at once
+func (s apiKeyHandler) rotateApiKey(w http.ResponseWriter, r *http.Request, id int) error {
+	plain, prefix, hash, err := newApiKey()
+	if err != nil {
+		return err
+	}
+	ks := apiKeysFor(s.data, r)
+	if err = ks.RotateApiKey(id, prefix, hash); err != nil {
+		return apiKeyError(err)
+	}
+	key, err := ks.GetApiKey(id)
+	if err != nil {
+		return apiKeyError(err)
+	}
+	return writeJson(w, apiKeyResponse{ApiKey: key, Key: plain})
+}
+
+func (s apiKeyHandler) deleteApiKeyRequest(w http.ResponseWriter, r *http.Request) error {
+	id, err := s.apiKeyID(r.URL.Path)
+	if err != nil {
+		return resperr.InvalidUrl
+	}
+	if err = apiKeysFor(s.data, r).RevokeApiKey(id); err != nil {
+		return apiKeyError(err)
+	}
+	w.Header().Add(constants.ContentType, constants.ApplicationJsonUtf8)
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+func (s apiKeyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var rErr = resperr.None
+
+	if method, found := s.methods[r.Method]; found {
+		if err := method(w, r); err != nil {
+			rErr = resperr.FromError(err)
+		}
+	} else {
+		rErr = resperr.BadRequest
+	}
+
+	if rErr.Status() != http.StatusOK {
+		log.Printf("Error %v in %s request %q", rErr, r.Method, r.URL.Path)
+	}
+
+	if rErr.Status() != resperr.None.Status() {
+		rErr.Write(w)
+	}
+}
+
+func (s apiKeyHandler) addMethod(httpMethod string, handlerFunc handlerFunc) {
+	s.methods[httpMethod] = handlerFunc
+}
+
+func NewApiKeyHandler(store store.PetStore) http.Handler {
+	kh := apiKeyHandler{
+		apiKeyIdPathReg:     regexp.MustCompile(apiKeyIdExpr),
+		apiKeyNoIdPathReg:   regexp.MustCompile(apiKeyNotIdExpr),
+		apiKeyRotatePathReg: regexp.MustCompile(apiKeyRotateExpr),
+		data:                store,
+		methods:             make(methodsMap),
+	}
+
+	kh.addMethod(http.MethodGet, kh.getApiKeyRequest)
+	kh.addMethod(http.MethodPost, kh.postApiKeyRequest)
+	kh.addMethod(http.MethodDelete, kh.deleteApiKeyRequest)
+
+	return kh
+}
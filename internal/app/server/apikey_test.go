@@ -0,0 +1,249 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/LearningByExample/go-microservice/internal/_test"
+	"github.com/LearningByExample/go-microservice/internal/app/constants"
+	"github.com/LearningByExample/go-microservice/internal/app/data"
+	"github.com/LearningByExample/go-microservice/internal/app/resperr"
+	"github.com/LearningByExample/go-microservice/internal/app/store"
+)
+
+func TestApiKeyRequests(t *testing.T) {
+	spyStore := _test.NewSpyStore()
+	handler := NewApiKeyHandler(&spyStore)
+	key := apiKeyRequest{Name: "ci", Scopes: []string{data.ScopePetsRead}}
+
+	type testCase struct {
+		name    string
+		method  string
+		path    string
+		body    interface{}
+		prepare func()
+		want    resperr.ResponseError
+		status  int
+	}
+
+	var cases = []testCase{
+		{
+			name:   "should get all api keys",
+			method: http.MethodGet,
+			path:   "/apikeys",
+			want:   resperr.None,
+			status: http.StatusOK,
+		},
+		{
+			name:   "should get api key",
+			method: http.MethodGet,
+			path:   "/apikeys/1",
+			want:   resperr.None,
+			status: http.StatusOK,
+		},
+		{
+			name:   "should not found api key",
+			method: http.MethodGet,
+			path:   "/apikeys/1",
+			prepare: func() {
+				spyStore.WhenGetApiKey(func(id int) (data.ApiKey, error) {
+					return data.ApiKey{}, store.ApiKeyNotFound
+				})
+			},
+			want:   resperr.NotFound,
+			status: http.StatusNotFound,
+		},
+		{
+			name:   "should fail with invalid url",
+			method: http.MethodGet,
+			path:   "/apikeys/1/other",
+			want:   resperr.InvalidUrl,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "should add api key",
+			method: http.MethodPost,
+			path:   "/apikeys",
+			body:   key,
+			want:   resperr.None,
+			status: http.StatusOK,
+		},
+		{
+			name:   "should not add api key with an id",
+			method: http.MethodPost,
+			path:   "/apikeys/1",
+			body:   key,
+			want:   resperr.InvalidUrl,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "should not add invalid api key",
+			method: http.MethodPost,
+			path:   "/apikeys",
+			body:   apiKeyRequest{Scopes: []string{"pets:delete"}},
+			want: resperr.FromErrorMessage(resperr.InvalidResource,
+				[]string{apiKeyNameEmpty, apiKeyScopeNotValid}),
+			status: http.StatusUnprocessableEntity,
+		},
+		{
+			name:   "should not add api key without scopes",
+			method: http.MethodPost,
+			path:   "/apikeys",
+			body:   apiKeyRequest{Name: strings.Repeat("n", maxApiKeyNameLength+1)},
+			want:   resperr.FromErrorMessage(resperr.InvalidResource, []string{apiKeyNameTooLong, apiKeyScopesEmpty}),
+			status: http.StatusUnprocessableEntity,
+		},
+		{
+			name:   "should not add api key with a bad body",
+			method: http.MethodPost,
+			path:   "/apikeys",
+			body:   "bad",
+			want:   resperr.InvalidResource,
+			status: http.StatusUnprocessableEntity,
+		},
+		{
+			name:   "should rotate api key",
+			method: http.MethodPost,
+			path:   "/apikeys/1/rotate",
+			want:   resperr.None,
+			status: http.StatusOK,
+		},
+		{
+			name:   "should not rotate api key that does not exist",
+			method: http.MethodPost,
+			path:   "/apikeys/1/rotate",
+			prepare: func() {
+				spyStore.WhenRotateApiKey(func(id int, prefix string, hash string) error {
+					return store.ApiKeyNotFound
+				})
+			},
+			want:   resperr.NotFound,
+			status: http.StatusNotFound,
+		},
+		{
+			name:   "should revoke api key",
+			method: http.MethodDelete,
+			path:   "/apikeys/1",
+			want:   resperr.None,
+			status: http.StatusOK,
+		},
+		{
+			name:   "should not revoke api key that does not exist",
+			method: http.MethodDelete,
+			path:   "/apikeys/1",
+			prepare: func() {
+				spyStore.WhenRevokeApiKey(func(id int) error {
+					return store.ApiKeyNotFound
+				})
+			},
+			want:   resperr.NotFound,
+			status: http.StatusNotFound,
+		},
+		{
+			name:   "should fail with bad method",
+			method: http.MethodPut,
+			path:   "/apikeys/1",
+			want:   resperr.BadRequest,
+			status: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			spyStore.Reset()
+			if tt.prepare != nil {
+				tt.prepare()
+			}
+
+			response := _test.HeaderRequest(handler, tt.path, tt.method, requestBody(tt.body), nil)
+
+			if response.Code != tt.status {
+				t.Fatalf("got status %d, want %d", response.Code, tt.status)
+			}
+			_test.AssertResponseError(t, response, tt.want)
+		})
+	}
+}
+
+func TestApiKeyRequestParameters(t *testing.T) {
+	spyStore := _test.NewSpyStore()
+	handler := NewApiKeyHandler(&spyStore)
+
+	t.Run("should add api key returning it once", func(t *testing.T) {
+		spyStore.Reset()
+		spyStore.WhenAddApiKey(func(name string, prefix string, hash string, scopes []string) (int, error) {
+			return 7, nil
+		})
+		spyStore.WhenGetApiKey(func(id int) (data.ApiKey, error) {
+			return data.ApiKey{Id: id, Name: spyStore.ApiKeyParameters.Name, Prefix: spyStore.ApiKeyParameters.Prefix,
+				Hash: spyStore.ApiKeyParameters.Hash, Scopes: spyStore.ApiKeyParameters.Scopes}, nil
+		})
+
+		response := _test.PostRequest(handler, "/apikeys",
+			apiKeyRequest{Name: "ci", Scopes: []string{data.ScopePetsWrite, data.ScopePetsRead, data.ScopePetsRead}})
+
+		if got := response.Header().Get(constants.Location); got != "/apikeys/7" {
+			t.Fatalf("got location %q, want %q", got, "/apikeys/7")
+		}
+		got := apiKeyResponse{}
+		_ = json.NewDecoder(response.Body).Decode(&got)
+		params := spyStore.ApiKeyParameters
+		if !strings.HasPrefix(got.Key, params.Prefix) || hashApiKey(got.Key) != params.Hash || got.Hash != "" {
+			t.Fatalf("got key %v, want key matching %v", got, params)
+		}
+		want := []string{data.ScopePetsRead, data.ScopePetsWrite}
+		if got.Id != 7 || !reflect.DeepEqual(params.Scopes, want) {
+			t.Fatalf("got %v with scopes %v, want id 7 and scopes %v", got, params.Scopes, want)
+		}
+	})
+
+	t.Run("should rotate api key with a new key", func(t *testing.T) {
+		spyStore.Reset()
+
+		response := _test.PostRequest(handler, "/apikeys/3/rotate", nil)
+
+		got := apiKeyResponse{}
+		_ = json.NewDecoder(response.Body).Decode(&got)
+		if spyStore.ApiKeyId != 3 || got.Key == "" || hashApiKey(got.Key) != spyStore.ApiKeyParameters.Hash {
+			t.Fatalf("got %v for key %d, want rotated key 3", got, spyStore.ApiKeyId)
+		}
+	})
+
+	t.Run("should get api key without hash", func(t *testing.T) {
+		spyStore.Reset()
+		spyStore.WhenGetApiKey(func(id int) (data.ApiKey, error) {
+			return data.ApiKey{Id: id, Name: "ci", Hash: "secret-hash"}, nil
+		})
+
+		response := _test.GetRequest(handler, "/apikeys/3")
+
+		if body := response.Body.String(); strings.Contains(body, "secret-hash") {
+			t.Fatalf("got %q, want no hash", body)
+		}
+	})
+}
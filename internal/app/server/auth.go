@@ -0,0 +1,208 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"github.com/LearningByExample/go-microservice/internal/app/config"
+	"github.com/LearningByExample/go-microservice/internal/app/constants"
+	"github.com/LearningByExample/go-microservice/internal/app/data"
+	"github.com/LearningByExample/go-microservice/internal/app/reqctx"
+	"github.com/LearningByExample/go-microservice/internal/app/resperr"
+	"github.com/LearningByExample/go-microservice/internal/app/store"
+	"github.com/LearningByExample/go-microservice/internal/app/token"
+	"log"
+	"net/http"
+	"strings"
+)
+
+const (
+	apiKeyPrefix       = "pk_"
+	apiKeyPrefixLength = len(apiKeyPrefix) + 8
+	apiKeyActor        = "apikey:"
+	bootstrapKeyName   = "bootstrap"
+	bearerScheme       = "Bearer "
+	bearerChallenge    = "Bearer"
+)
+
+func hashApiKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// newApiKey generates a random key, returning it with the prefix shown to identify it and the hash to store
+func newApiKey() (string, string, string, error) {
+	bytes := make([]byte, 24)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", "", "", err
+	}
+	key := apiKeyPrefix + hex.EncodeToString(bytes)
+	return key, key[:apiKeyPrefixLength], hashApiKey(key), nil
+}
+
+// requiredScope gets the scope needed for a request, an empty scope means that the path is public
+func requiredScope(method string, path string) string {
+	switch {
+	case strings.HasPrefix(path, healthPath):
+		return ""
+	case path == apiKeyPath || strings.HasPrefix(path, apiKeySlash),
+		path == webhookPath || strings.HasPrefix(path, webhookSlash):
+		return data.ScopeAdmin
+	case method == http.MethodGet || method == http.MethodHead || path == graphqlPath:
+		return data.ScopePetsRead
+	}
+	return data.ScopePetsWrite
+}
+
+// credentials are the API key, the bearer token or the subject of the verified client certificate of a caller, the
+// token is used first, then the key and the certificate only when neither is sent
+type credentials struct {
+	apiKey string
+	bearer string
+	cert   string
+}
+
+func bearerToken(authorization string) string {
+	if len(authorization) > len(bearerScheme) && strings.EqualFold(authorization[:len(bearerScheme)], bearerScheme) {
+		return strings.TrimSpace(authorization[len(bearerScheme):])
+	}
+	return ""
+}
+
+type authenticator struct {
+	ps        store.PetStore
+	bootstrap data.ApiKey
+	verifier  *token.Verifier
+	certs     map[string][]string
+}
+
+// authenticateKey finds the key that is not revoked, the bootstrap key from the configuration is never stored
+func (a authenticator) authenticateKey(ctx context.Context, key string) (data.ApiKey, error) {
+	if key == "" {
+		return data.ApiKey{}, resperr.Unauthorized
+	}
+	hash := hashApiKey(key)
+	if a.bootstrap.Hash != "" && subtle.ConstantTimeCompare([]byte(hash), []byte(a.bootstrap.Hash)) == 1 {
+		return a.bootstrap, nil
+	}
+	if keys, ok := a.ps.WithContext(ctx).(store.ApiKeyStore); ok {
+		found, err := keys.FindApiKey(hash)
+		if err == store.ApiKeyNotFound {
+			err = resperr.Unauthorized
+		}
+		return found, err
+	}
+	return data.ApiKey{}, resperr.Unauthorized
+}
+
+func (a authenticator) authenticateToken(raw string) (data.Principal, error) {
+	if a.verifier == nil {
+		return data.Principal{}, resperr.Unauthorized
+	}
+	principal, err := a.verifier.Verify(raw)
+	if err != nil {
+		return principal, resperr.FromErrorMessage(resperr.Unauthorized, []string{err.Error()})
+	}
+	return principal, nil
+}
+
+// authenticateCert grants the scopes configured for the subject of a certificate, that the TLS handshake has verified,
+// a subject without scopes is authenticated but forbidden
+func (a authenticator) authenticateCert(subject string) data.Principal {
+	scopes := a.certs[subject]
+	return data.Principal{Subject: clientCertActor + subject, Roles: scopes, Scopes: scopes}
+}
+
+// authorize authenticates the caller and checks its scope, returning the context of the authenticated caller
+func (a authenticator) authorize(ctx context.Context, creds credentials, scope string) (context.Context, error) {
+	var grants reqctx.Grants = nil
+	var actor = ""
+	var err error = nil
+
+	if creds.bearer != "" {
+		var principal data.Principal
+		principal, err = a.authenticateToken(creds.bearer)
+		grants, actor = principal, principal.Subject
+	} else if creds.apiKey == "" && creds.cert != "" {
+		principal := a.authenticateCert(creds.cert)
+		grants, actor = principal, principal.Subject
+	} else {
+		var key data.ApiKey
+		key, err = a.authenticateKey(ctx, creds.apiKey)
+		actor = apiKeyActor + key.Name
+		grants = data.Principal{Subject: actor, Roles: key.Scopes, Scopes: key.Scopes}
+	}
+	if err != nil {
+		return ctx, err
+	}
+	ctx = reqctx.WithGrants(reqctx.WithActor(ctx, actor), grants)
+	if !grants.HasScope(scope) {
+		return ctx, resperr.Forbidden
+	}
+	return ctx, nil
+}
+
+func (a authenticator) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scope := requiredScope(r.Method, r.URL.Path)
+		if scope == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		creds := credentials{
+			apiKey: r.Header.Get(constants.ApiKey),
+			bearer: bearerToken(r.Header.Get(constants.Authorization)),
+			cert:   clientCertSubject(r),
+		}
+		ctx, err := a.authorize(r.Context(), creds, scope)
+		if err != nil {
+			rErr := resperr.FromError(err)
+			log.Printf("Error %v in %s request %q by %s", rErr, r.Method, r.URL.Path, reqctx.Actor(ctx))
+			if rErr.Status() == http.StatusUnauthorized && a.verifier != nil {
+				w.Header().Set(constants.WwwAuthenticate, bearerChallenge)
+			}
+			rErr.Write(w)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func newAuthenticator(cfg config.AuthCfg, ps store.PetStore) (*authenticator, error) {
+	var err error = nil
+	auth := &authenticator{ps: ps, certs: cfg.ClientCerts}
+	if cfg.BootstrapKey != "" {
+		auth.bootstrap = data.ApiKey{Name: bootstrapKeyName, Prefix: cfg.BootstrapKey[:apiKeyPrefixLength],
+			Hash: hashApiKey(cfg.BootstrapKey), Scopes: []string{data.ScopeAdmin}}
+	}
+	if cfg.Jwt.IsEnabled() {
+		var verifier token.Verifier
+		verifier, err = token.NewVerifier(cfg.Jwt)
+		auth.verifier = &verifier
+	}
+	return auth, err
+}
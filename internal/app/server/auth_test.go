@@ -0,0 +1,347 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/LearningByExample/go-microservice/internal/_test"
+	"github.com/LearningByExample/go-microservice/internal/app/config"
+	"github.com/LearningByExample/go-microservice/internal/app/constants"
+	"github.com/LearningByExample/go-microservice/internal/app/data"
+	"github.com/LearningByExample/go-microservice/internal/app/reqctx"
+	"github.com/LearningByExample/go-microservice/internal/app/resperr"
+	"github.com/LearningByExample/go-microservice/internal/app/store"
+	"github.com/LearningByExample/go-microservice/internal/app/token"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	testBootstrapKey = "pk_0123456789abcdef0123456789abcdef"
+	testReadKey      = "pk_read"
+)
+
+func TestNewApiKey(t *testing.T) {
+	key, prefix, hash, err := newApiKey()
+	if err != nil || !strings.HasPrefix(key, apiKeyPrefix) || !strings.HasPrefix(key, prefix) {
+		t.Fatalf("got %q with prefix %q and %v, want generated key", key, prefix, err)
+	}
+	if len(prefix) != apiKeyPrefixLength || hash != hashApiKey(key) || len(hash) != 64 {
+		t.Fatalf("got prefix %q and hash %q, want prefix and hash of %q", prefix, hash, key)
+	}
+	if other, _, _, _ := newApiKey(); other == key {
+		t.Fatalf("got %q twice, want different keys", key)
+	}
+}
+
+func TestRequiredScope(t *testing.T) {
+	var cases = []struct {
+		method string
+		path   string
+		want   string
+	}{
+		{method: http.MethodGet, path: "/health/liveness", want: ""},
+		{method: http.MethodGet, path: "/pets", want: data.ScopePetsRead},
+		{method: http.MethodHead, path: "/pets/1", want: data.ScopePetsRead},
+		{method: http.MethodPost, path: "/graphql", want: data.ScopePetsRead},
+		{method: http.MethodPost, path: "/pets", want: data.ScopePetsWrite},
+		{method: http.MethodDelete, path: "/owners/1", want: data.ScopePetsWrite},
+		{method: http.MethodGet, path: "/apikeys", want: data.ScopeAdmin},
+		{method: http.MethodGet, path: "/webhooks/1/deliveries", want: data.ScopeAdmin},
+	}
+
+	for _, tt := range cases {
+		if got := requiredScope(tt.method, tt.path); got != tt.want {
+			t.Fatalf("got %q for %s %s, want %q", got, tt.method, tt.path, tt.want)
+		}
+	}
+}
+
+func TestAuthenticator(t *testing.T) {
+	spyStore := _test.NewSpyStore()
+	actor := ""
+	auth, _ := newAuthenticator(config.AuthCfg{Enabled: true, BootstrapKey: testBootstrapKey}, &spyStore)
+	handler := auth.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actor = reqctx.Actor(r.Context())
+		w.WriteHeader(http.StatusOK)
+	}))
+	readKey := data.ApiKey{Id: 1, Name: "reader", Scopes: []string{data.ScopePetsRead}}
+
+	type testCase struct {
+		name   string
+		method string
+		path   string
+		key    string
+		want   resperr.ResponseError
+		actor  string
+	}
+
+	var cases = []testCase{
+		{
+			name:   "should allow health without key",
+			method: http.MethodGet,
+			path:   "/health/readiness",
+			want:   resperr.None,
+			actor:  reqctx.Anonymous,
+		},
+		{
+			name:   "should reject request without key",
+			method: http.MethodGet,
+			path:   "/pets",
+			want:   resperr.Unauthorized,
+		},
+		{
+			name:   "should reject unknown key",
+			method: http.MethodGet,
+			path:   "/pets",
+			key:    "pk_unknown",
+			want:   resperr.Unauthorized,
+		},
+		{
+			name:   "should allow key with scope",
+			method: http.MethodGet,
+			path:   "/pets",
+			key:    testReadKey,
+			want:   resperr.None,
+			actor:  "apikey:reader",
+		},
+		{
+			name:   "should forbid key without scope",
+			method: http.MethodPost,
+			path:   "/pets",
+			key:    testReadKey,
+			want:   resperr.Forbidden,
+		},
+		{
+			name:   "should allow bootstrap key as admin",
+			method: http.MethodPost,
+			path:   "/apikeys",
+			key:    testBootstrapKey,
+			want:   resperr.None,
+			actor:  "apikey:bootstrap",
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			spyStore.Reset()
+			spyStore.WhenFindApiKey(func(hash string) (data.ApiKey, error) {
+				if hash == hashApiKey(testReadKey) {
+					return readKey, nil
+				}
+				return data.ApiKey{}, store.ApiKeyNotFound
+			})
+			actor = ""
+
+			response := _test.HeaderRequest(handler, tt.path, tt.method, "", map[string]string{constants.ApiKey: tt.key})
+
+			_test.AssertResponseError(t, response, tt.want)
+			if actor != tt.actor {
+				t.Fatalf("got actor %q, want %q", actor, tt.actor)
+			}
+		})
+	}
+
+	t.Run("should not look up the bootstrap key", func(t *testing.T) {
+		spyStore.Reset()
+
+		_ = _test.HeaderRequest(handler, "/pets", http.MethodGet, "", map[string]string{constants.ApiKey: testBootstrapKey})
+
+		if spyStore.FindApiKeyWasCall {
+			t.Fatal("want bootstrap key not looked up in the store")
+		}
+	})
+}
+
+func TestAuthenticatorWithClientCerts(t *testing.T) {
+	spyStore := _test.NewSpyStore()
+	actor := ""
+	auth, _ := newAuthenticator(config.AuthCfg{Enabled: true,
+		ClientCerts: map[string][]string{"CN=john,O=Pets": {data.ScopePetsRead}}}, &spyStore)
+	handler := auth.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actor = reqctx.Actor(r.Context())
+		w.WriteHeader(http.StatusOK)
+	}))
+	certFor := func(name string) *tls.ConnectionState {
+		cert := &x509.Certificate{Subject: pkix.Name{CommonName: name, Organization: []string{"Pets"}}}
+		return &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+	}
+
+	type testCase struct {
+		name   string
+		method string
+		tls    *tls.ConnectionState
+		key    string
+		want   resperr.ResponseError
+		actor  string
+	}
+
+	var cases = []testCase{
+		{
+			name:   "should allow certificate with scope",
+			method: http.MethodGet,
+			tls:    certFor("john"),
+			want:   resperr.None,
+			actor:  "cert:CN=john,O=Pets",
+		},
+		{
+			name:   "should forbid certificate without scope",
+			method: http.MethodPost,
+			tls:    certFor("john"),
+			want:   resperr.Forbidden,
+		},
+		{
+			name:   "should forbid certificate without configured scopes",
+			method: http.MethodGet,
+			tls:    certFor("eve"),
+			want:   resperr.Forbidden,
+		},
+		{
+			name:   "should use the key before the certificate",
+			method: http.MethodGet,
+			tls:    certFor("john"),
+			key:    "pk_unknown",
+			want:   resperr.Unauthorized,
+		},
+		{
+			name:   "should reject a connection without certificate",
+			method: http.MethodGet,
+			tls:    &tls.ConnectionState{},
+			want:   resperr.Unauthorized,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			spyStore.Reset()
+			spyStore.WhenFindApiKey(func(hash string) (data.ApiKey, error) {
+				return data.ApiKey{}, store.ApiKeyNotFound
+			})
+			actor = ""
+			request := httptest.NewRequest(tt.method, "/pets", nil)
+			request.TLS = tt.tls
+			if tt.key != "" {
+				request.Header.Set(constants.ApiKey, tt.key)
+			}
+			response := httptest.NewRecorder()
+
+			handler.ServeHTTP(response, request)
+
+			_test.AssertResponseError(t, response, tt.want)
+			if actor != tt.actor {
+				t.Fatalf("got actor %q, want %q", actor, tt.actor)
+			}
+		})
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	var cases = map[string]string{
+		"Bearer abc":  "abc",
+		"bearer  abc": "abc",
+		"Basic abc":   "",
+		"Bearer":      "",
+		"":            "",
+	}
+
+	for header, want := range cases {
+		if got := bearerToken(header); got != want {
+			t.Fatalf("got %q for %q, want %q", got, header, want)
+		}
+	}
+}
+
+func TestAuthenticatorWithTokens(t *testing.T) {
+	spyStore := _test.NewSpyStore()
+	keys := _test.NewTokenKeys()
+	actor := ""
+	auth, _ := newAuthenticator(config.AuthCfg{Enabled: true, Jwt: config.JwtCfg{Jwks: keys.Jwks(), Audience: "pets",
+		RolesClaim: "roles", Roles: map[string][]string{"viewer": {data.ScopePetsRead}}}}, &spyStore)
+	handler := auth.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actor = reqctx.Actor(r.Context())
+		w.WriteHeader(http.StatusOK)
+	}))
+	viewer := keys.Sign("RS256", "rsa", jwt.MapClaims{"sub": "john", "aud": "pets", "roles": []string{"viewer"},
+		"exp": time.Now().Add(time.Hour).Unix()})
+	expired := keys.Sign("ES256", "ec", jwt.MapClaims{"sub": "john", "aud": "pets", "roles": []string{"viewer"},
+		"exp": time.Now().Add(-time.Hour).Unix()})
+
+	type testCase struct {
+		name   string
+		method string
+		token  string
+		want   resperr.ResponseError
+		actor  string
+	}
+
+	var cases = []testCase{
+		{
+			name:   "should allow token with scope",
+			method: http.MethodGet,
+			token:  viewer,
+			want:   resperr.None,
+			actor:  "john",
+		},
+		{
+			name:   "should forbid token without scope",
+			method: http.MethodPost,
+			token:  viewer,
+			want:   resperr.Forbidden,
+		},
+		{
+			name:   "should reject expired token",
+			method: http.MethodGet,
+			token:  expired,
+			want:   resperr.FromErrorMessage(resperr.Unauthorized, []string{token.TokenExpired.Error()}),
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			actor = ""
+			headers := map[string]string{constants.Authorization: "Bearer " + tt.token}
+
+			response := _test.HeaderRequest(handler, "/pets", tt.method, "", headers)
+
+			_test.AssertResponseError(t, response, tt.want)
+			if actor != tt.actor {
+				t.Fatalf("got actor %q, want %q", actor, tt.actor)
+			}
+		})
+	}
+
+	t.Run("should challenge for a token", func(t *testing.T) {
+		response := _test.GetRequest(handler, "/pets")
+
+		if got := response.Header().Get(constants.WwwAuthenticate); got != bearerChallenge {
+			t.Fatalf("got %q, want %q", got, bearerChallenge)
+		}
+	})
+}
@@ -0,0 +1,232 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/LearningByExample/go-microservice/internal/app/constants"
+	"github.com/LearningByExample/go-microservice/internal/app/data"
+	"github.com/LearningByExample/go-microservice/internal/app/resperr"
+	"github.com/LearningByExample/go-microservice/internal/app/store"
+	"log"
+	"net/http"
+)
+
+const (
+	petBatchPath       = "/pets/batch"
+	maxBatchOperations = 1000
+	batchEmpty         = "batch must have at least one operation"
+	batchTooLarge      = "batch cannot have more than %d operations"
+	operationNotValid  = "operation %q is not valid"
+	operationNoId      = "operation id must be provided"
+	operationAborted   = "operation aborted"
+)
+
+type batchOperation struct {
+	Op  store.OperationType `json:"op"`
+	Id  int                 `json:"id"`
+	Pet data.Pet            `json:"pet"`
+}
+
+type batchRequest struct {
+	Atomic     bool             `json:"atomic"`
+	Operations []batchOperation `json:"operations"`
+}
+
+type batchResult struct {
+	Index  int                 `json:"index"`
+	Op     store.OperationType `json:"op"`
+	Id     int                 `json:"id,omitempty"`
+	Status int                 `json:"status"`
+	Errors []string            `json:"errors,omitempty"`
+}
+
+type batchResponse struct {
+	Atomic    bool          `json:"atomic"`
+	Committed bool          `json:"committed"`
+	Results   []batchResult `json:"results"`
+}
+
+type batchHandler struct {
+	data store.PetStore
+}
+
+func validOperation(op batchOperation) error {
+	switch op.Op {
+	case store.CreateOperation:
+		return validPet(op.Pet)
+	case store.UpdateOperation:
+		if op.Id <= 0 {
+			return resperr.FromErrorMessage(resperr.InvalidResource, []string{operationNoId})
+		}
+		return validPet(op.Pet)
+	case store.DeleteOperation:
+		if op.Id <= 0 {
+			return resperr.FromErrorMessage(resperr.InvalidResource, []string{operationNoId})
+		}
+		return nil
+	}
+	return resperr.FromErrorMessage(resperr.InvalidResource, []string{fmt.Sprintf(operationNotValid, op.Op)})
+}
+
+func operationError(err error) resperr.ResponseError {
+	switch err {
+	case store.PetNotFound:
+		return resperr.NotFound
+	case store.InvalidOperation:
+		return resperr.InvalidResource
+	}
+	return resperr.FromError(err)
+}
+
+func (r *batchResult) fail(err error) {
+	rErr := operationError(err)
+	r.Status = rErr.Status()
+	if len(rErr.Message) != 0 {
+		r.Errors = rErr.Message
+	} else {
+		r.Errors = []string{rErr.ErrorStr}
+	}
+}
+
+func (r *batchResult) succeed(op store.OperationType, result store.PetOperationResult) {
+	if result.Id != 0 {
+		r.Id = result.Id
+	}
+	r.Status = http.StatusOK
+	if op == store.UpdateOperation && !result.Changed {
+		r.Status = http.StatusNotModified
+	}
+}
+
+func (res *batchResponse) abort() {
+	res.Committed = false
+	for i := range res.Results {
+		if res.Results[i].Errors == nil {
+			res.Results[i].Status = http.StatusFailedDependency
+			res.Results[i].Errors = []string{operationAborted}
+		}
+	}
+}
+
+func (h batchHandler) runBatch(req batchRequest) (batchResponse, error) {
+	res := batchResponse{
+		Atomic:    req.Atomic,
+		Committed: true,
+		Results:   make([]batchResult, len(req.Operations)),
+	}
+
+	ops := make([]store.PetOperation, 0, len(req.Operations))
+	indexes := make([]int, 0, len(req.Operations))
+	for i, op := range req.Operations {
+		res.Results[i] = batchResult{Index: i, Op: op.Op, Id: op.Id}
+		if err := validOperation(op); err != nil {
+			res.Results[i].fail(err)
+			continue
+		}
+		pet := op.Pet
+		pet.Id = op.Id
+		ops = append(ops, store.PetOperation{Type: op.Op, Pet: pet})
+		indexes = append(indexes, i)
+	}
+
+	if req.Atomic && len(ops) != len(req.Operations) {
+		res.abort()
+		return res, nil
+	}
+
+	results, err := h.data.BatchPets(ops, req.Atomic)
+	if err != nil {
+		return res, err
+	}
+
+	failed := false
+	for i, result := range results {
+		r := &res.Results[indexes[i]]
+		if result.Err != nil {
+			failed = true
+			r.fail(result.Err)
+		} else {
+			r.succeed(ops[i].Type, result)
+		}
+	}
+
+	if req.Atomic && failed {
+		res.abort()
+	}
+	return res, nil
+}
+
+func (h batchHandler) postBatchRequest(w http.ResponseWriter, r *http.Request) error {
+	if r.Body == nil {
+		return resperr.NotBodyProvided
+	}
+
+	req := batchRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return resperr.InvalidResource
+	}
+	if len(req.Operations) == 0 {
+		return resperr.FromErrorMessage(resperr.InvalidResource, []string{batchEmpty})
+	}
+	if len(req.Operations) > maxBatchOperations {
+		return resperr.FromErrorMessage(resperr.InvalidResource, []string{fmt.Sprintf(batchTooLarge, maxBatchOperations)})
+	}
+
+	res, err := h.runBatch(req)
+	if err == nil {
+		w.Header().Add(constants.ContentType, constants.ApplicationJsonUtf8)
+		w.WriteHeader(http.StatusOK)
+		encoder := json.NewEncoder(w)
+		if err := encoder.Encode(res); err != nil {
+			return resperr.WrittenJson
+		}
+	}
+	return err
+}
+
+func (h batchHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var rErr = resperr.None
+	h.data = h.data.WithContext(r.Context())
+
+	if r.URL.Path != petBatchPath {
+		rErr = resperr.NotFound
+	} else if r.Method != http.MethodPost {
+		rErr = resperr.BadRequest
+	} else if err := h.postBatchRequest(w, r); err != nil {
+		rErr = resperr.FromError(err)
+	}
+
+	if rErr.Status() != http.StatusOK {
+		log.Printf("Error %v in %s request %q", rErr, r.Method, r.URL.Path)
+	}
+
+	if rErr.Status() != resperr.None.Status() {
+		rErr.Write(w)
+	}
+}
+
+func NewBatchHandler(store store.PetStore) http.Handler {
+	return batchHandler{data: store}
+}
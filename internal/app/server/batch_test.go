@@ -0,0 +1,220 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/LearningByExample/go-microservice/internal/_test"
+	"github.com/LearningByExample/go-microservice/internal/app/data"
+	"github.com/LearningByExample/go-microservice/internal/app/resperr"
+	"github.com/LearningByExample/go-microservice/internal/app/store"
+)
+
+func TestBatchRequest(t *testing.T) {
+	spyStore := _test.NewSpyStore()
+	handler := NewBatchHandler(&spyStore)
+
+	validPet := data.Pet{Name: "Fluffy", Race: "dog", Mod: "happy"}
+	operations := []batchOperation{
+		{Op: store.CreateOperation, Pet: validPet},
+		{Op: store.UpdateOperation, Id: 2, Pet: validPet},
+		{Op: store.DeleteOperation, Id: 3},
+	}
+
+	type testCase struct {
+		name       string
+		request    batchRequest
+		storeFunc  func(ops []store.PetOperation, atomic bool) ([]store.PetOperationResult, error)
+		wantCalled bool
+		want       batchResponse
+	}
+
+	var cases = []testCase{
+		{
+			name:    "should apply all operations",
+			request: batchRequest{Atomic: true, Operations: operations},
+			storeFunc: func(ops []store.PetOperation, atomic bool) ([]store.PetOperationResult, error) {
+				return []store.PetOperationResult{
+					{Id: 1, Changed: true},
+					{Id: 2, Changed: false},
+					{Id: 3, Changed: true},
+				}, nil
+			},
+			wantCalled: true,
+			want: batchResponse{
+				Atomic:    true,
+				Committed: true,
+				Results: []batchResult{
+					{Index: 0, Op: store.CreateOperation, Id: 1, Status: http.StatusOK},
+					{Index: 1, Op: store.UpdateOperation, Id: 2, Status: http.StatusNotModified},
+					{Index: 2, Op: store.DeleteOperation, Id: 3, Status: http.StatusOK},
+				},
+			},
+		},
+		{
+			name:    "should abort atomic batch on store failure",
+			request: batchRequest{Atomic: true, Operations: operations},
+			storeFunc: func(ops []store.PetOperation, atomic bool) ([]store.PetOperationResult, error) {
+				return []store.PetOperationResult{
+					{}, {Id: 2, Err: store.PetNotFound}, {},
+				}, nil
+			},
+			wantCalled: true,
+			want: batchResponse{
+				Atomic:    true,
+				Committed: false,
+				Results: []batchResult{
+					{Index: 0, Op: store.CreateOperation, Status: http.StatusFailedDependency,
+						Errors: []string{operationAborted}},
+					{Index: 1, Op: store.UpdateOperation, Id: 2, Status: http.StatusNotFound,
+						Errors: []string{resperr.NotFound.ErrorStr}},
+					{Index: 2, Op: store.DeleteOperation, Id: 3, Status: http.StatusFailedDependency,
+						Errors: []string{operationAborted}},
+				},
+			},
+		},
+		{
+			name: "should not call store on invalid atomic batch",
+			request: batchRequest{Atomic: true, Operations: []batchOperation{
+				{Op: store.CreateOperation, Pet: validPet},
+				{Op: "move", Id: 2},
+			}},
+			wantCalled: false,
+			want: batchResponse{
+				Atomic:    true,
+				Committed: false,
+				Results: []batchResult{
+					{Index: 0, Op: store.CreateOperation, Status: http.StatusFailedDependency,
+						Errors: []string{operationAborted}},
+					{Index: 1, Op: "move", Id: 2, Status: http.StatusUnprocessableEntity,
+						Errors: []string{"operation \"move\" is not valid"}},
+				},
+			},
+		},
+		{
+			name: "should apply valid operations independently",
+			request: batchRequest{Atomic: false, Operations: []batchOperation{
+				{Op: store.CreateOperation, Pet: data.Pet{Name: "Fluffy"}},
+				{Op: store.DeleteOperation},
+				{Op: store.DeleteOperation, Id: 3},
+				{Op: store.DeleteOperation, Id: 4},
+			}},
+			storeFunc: func(ops []store.PetOperation, atomic bool) ([]store.PetOperationResult, error) {
+				return []store.PetOperationResult{
+					{Id: 3, Err: store.PetNotFound},
+					{Id: 4, Changed: true},
+				}, nil
+			},
+			wantCalled: true,
+			want: batchResponse{
+				Atomic:    false,
+				Committed: true,
+				Results: []batchResult{
+					{Index: 0, Op: store.CreateOperation, Status: http.StatusUnprocessableEntity,
+						Errors: []string{petRaceNotEmpty, petModNotEmpty}},
+					{Index: 1, Op: store.DeleteOperation, Status: http.StatusUnprocessableEntity,
+						Errors: []string{operationNoId}},
+					{Index: 2, Op: store.DeleteOperation, Id: 3, Status: http.StatusNotFound,
+						Errors: []string{resperr.NotFound.ErrorStr}},
+					{Index: 3, Op: store.DeleteOperation, Id: 4, Status: http.StatusOK},
+				},
+			},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			spyStore.Reset()
+			if tt.storeFunc != nil {
+				spyStore.WhenBatchPets(tt.storeFunc)
+			}
+
+			response := _test.PostRequest(handler, petBatchPath, tt.request)
+
+			if response.Code != http.StatusOK {
+				t.Fatalf("got %v, want %v", response.Code, http.StatusOK)
+			}
+			got := batchResponse{}
+			if err := json.NewDecoder(response.Body).Decode(&got); err != nil {
+				t.Fatalf("got error, %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			if spyStore.BatchWasCall != tt.wantCalled {
+				t.Fatalf("batch was call got %t, want %t", spyStore.BatchWasCall, tt.wantCalled)
+			}
+			if tt.wantCalled && spyStore.Atomic != tt.request.Atomic {
+				t.Fatalf("atomic got %t, want %t", spyStore.Atomic, tt.request.Atomic)
+			}
+		})
+	}
+}
+
+func TestBatchRequestErrors(t *testing.T) {
+	spyStore := _test.NewSpyStore()
+	handler := NewBatchHandler(&spyStore)
+
+	tooMany := batchRequest{Operations: make([]batchOperation, maxBatchOperations+1)}
+
+	t.Run("should fail with no body", func(t *testing.T) {
+		response := _test.PostRequest(handler, petBatchPath, nil)
+		_test.AssertResponseError(t, response, resperr.NotBodyProvided)
+	})
+
+	t.Run("should fail with invalid json", func(t *testing.T) {
+		response := _test.PostRequest(handler, petBatchPath, "{")
+		_test.AssertResponseError(t, response, resperr.InvalidResource)
+	})
+
+	t.Run("should fail with empty batch", func(t *testing.T) {
+		response := _test.PostRequest(handler, petBatchPath, batchRequest{})
+		_test.AssertResponseError(t, response, resperr.FromErrorMessage(resperr.InvalidResource,
+			[]string{batchEmpty}))
+	})
+
+	t.Run("should fail with too many operations", func(t *testing.T) {
+		response := _test.PostRequest(handler, petBatchPath, tooMany)
+		_test.AssertResponseError(t, response, resperr.FromErrorMessage(resperr.InvalidResource,
+			[]string{"batch cannot have more than 1000 operations"}))
+	})
+
+	t.Run("should fail with invalid method", func(t *testing.T) {
+		response := _test.GetRequest(handler, petBatchPath)
+		_test.AssertResponseError(t, response, resperr.BadRequest)
+	})
+
+	t.Run("should fail with store error", func(t *testing.T) {
+		spyStore.Reset()
+		spyStore.WhenBatchPets(func(ops []store.PetOperation, atomic bool) ([]store.PetOperationResult, error) {
+			return nil, mockError
+		})
+		request := batchRequest{Operations: []batchOperation{{Op: store.DeleteOperation, Id: 1}}}
+		response := _test.PostRequest(handler, petBatchPath, request)
+		_test.AssertResponseError(t, response, resperr.FromError(mockError))
+	})
+}
@@ -0,0 +1,198 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/LearningByExample/go-microservice/internal/app/config"
+	"github.com/LearningByExample/go-microservice/internal/app/constants"
+	"github.com/LearningByExample/go-microservice/internal/app/data"
+	"github.com/LearningByExample/go-microservice/internal/app/store"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	signaturePrefix  = "sha256="
+	maxResponseDrain = 4 << 10
+)
+
+type deliverer struct {
+	ws          store.WebhookStore
+	client      *http.Client
+	interval    time.Duration
+	lease       time.Duration
+	batchSize   int
+	maxAttempts int
+	maxFailures int
+	backoff     time.Duration
+	maxBackoff  time.Duration
+	now         func() time.Time
+	jitter      func(d time.Duration) time.Duration
+}
+
+func signPayload(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write(payload)
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+func halfJitter(d time.Duration) time.Duration {
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(d-half)+1))
+}
+
+func (d deliverer) retryDelay(attempts int) time.Duration {
+	delay := d.backoff
+	for i := 1; i < attempts && delay < d.maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > d.maxBackoff {
+		delay = d.maxBackoff
+	}
+	return d.jitter(delay)
+}
+
+func (d deliverer) post(ctx context.Context, webhook data.Webhook, delivery data.Delivery) data.DeliveryAttempt {
+	attempt := data.DeliveryAttempt{At: d.now()}
+	payload := []byte(delivery.Payload)
+
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.Url, strings.NewReader(delivery.Payload))
+	if err == nil {
+		r.Header.Set(constants.ContentType, constants.ApplicationJsonUtf8)
+		r.Header.Set(constants.WebhookEvent, delivery.Event)
+		r.Header.Set(constants.WebhookDelivery, strconv.Itoa(delivery.Id))
+		r.Header.Set(constants.WebhookSignature, signPayload(webhook.Secret, payload))
+		var response *http.Response
+		if response, err = d.client.Do(r); err == nil {
+			attempt.StatusCode = response.StatusCode
+			_, _ = io.Copy(ioutil.Discard, io.LimitReader(response.Body, maxResponseDrain))
+			_ = response.Body.Close()
+		}
+	}
+	if err != nil {
+		attempt.Error = err.Error()
+	}
+	return attempt
+}
+
+// deliver posts a delivery recording the attempt, failed deliveries are retried with exponential backoff until they
+// run out of attempts and webhooks failing too many times in a row are disabled.
+func (d deliverer) deliver(ctx context.Context, webhook data.Webhook, delivery data.Delivery) data.Webhook {
+	attempt := d.post(ctx, webhook, delivery)
+	if ctx.Err() != nil {
+		return webhook
+	}
+
+	attempts := len(delivery.Attempts) + 1
+	status, next := data.DeliveryDelivered, attempt.At
+	if !attempt.Succeeded() {
+		status, next = data.DeliveryPending, attempt.At.Add(d.retryDelay(attempts))
+		if attempts >= d.maxAttempts {
+			status = data.DeliveryFailed
+		}
+		log.Printf("Error delivering %d to webhook %d, attempt %d got %d %q.", delivery.Id, webhook.Id, attempts,
+			attempt.StatusCode, attempt.Error)
+	}
+
+	failures, err := d.ws.RecordDeliveryAttempt(delivery.Id, attempt, status, next)
+	if err != nil {
+		log.Printf("Error %v recording attempt of delivery %d", err, delivery.Id)
+	} else if failures >= d.maxFailures {
+		if err = d.ws.DisableWebhook(webhook.Id); err != nil {
+			log.Printf("Error %v disabling webhook %d", err, webhook.Id)
+		} else {
+			log.Printf("Disabled webhook %d after %d failures.", webhook.Id, failures)
+			webhook.Enabled = false
+		}
+	}
+	return webhook
+}
+
+func (d deliverer) deliverDue(ctx context.Context) {
+	deliveries, err := d.ws.ClaimDeliveries(d.now(), d.lease, d.batchSize)
+	if err != nil {
+		log.Printf("Error %v claiming webhook deliveries", err)
+		return
+	}
+
+	webhooks := make(map[int]data.Webhook)
+	for _, delivery := range deliveries {
+		if ctx.Err() != nil {
+			return
+		}
+		webhook, found := webhooks[delivery.WebhookId]
+		if !found {
+			if webhook, err = d.ws.GetWebhook(delivery.WebhookId); err != nil {
+				log.Printf("Error %v getting webhook %d", err, delivery.WebhookId)
+				continue
+			}
+		}
+		if webhook.Enabled {
+			webhook = d.deliver(ctx, webhook, delivery)
+		}
+		webhooks[webhook.Id] = webhook
+	}
+}
+
+func (d deliverer) run(ctx context.Context) {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.deliverDue(ctx)
+		}
+	}
+}
+
+func newDeliverer(cfg config.WebhooksCfg, ws store.WebhookStore) deliverer {
+	timeout := time.Duration(cfg.Timeout) * time.Millisecond
+	interval := time.Duration(cfg.Interval) * time.Millisecond
+	return deliverer{
+		ws:       ws,
+		client:   &http.Client{Timeout: timeout},
+		interval: interval,
+		// deliveries are posted one after another, claims should last until the whole batch could time out
+		lease:       time.Duration(cfg.BatchSize)*timeout + interval,
+		batchSize:   cfg.BatchSize,
+		maxAttempts: cfg.MaxAttempts,
+		maxFailures: cfg.MaxFailures,
+		backoff:     time.Duration(cfg.Backoff) * time.Millisecond,
+		maxBackoff:  time.Duration(cfg.MaxBackoff) * time.Millisecond,
+		now:         time.Now,
+		jitter:      halfJitter,
+	}
+}
@@ -0,0 +1,191 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/LearningByExample/go-microservice/internal/app/config"
+	"github.com/LearningByExample/go-microservice/internal/app/constants"
+	"github.com/LearningByExample/go-microservice/internal/app/data"
+	"github.com/LearningByExample/go-microservice/internal/app/store"
+	"github.com/LearningByExample/go-microservice/internal/app/store/memory"
+)
+
+type receiver struct {
+	mu       sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   []string
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	body, _ := ioutil.ReadAll(r.Body)
+	rc.requests = append(rc.requests, r)
+	rc.bodies = append(rc.bodies, string(body))
+	status := http.StatusNoContent
+	if len(rc.statuses) != 0 {
+		status, rc.statuses = rc.statuses[0], rc.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func newTestDeliverer(ws store.WebhookStore, now *time.Time) deliverer {
+	d := newDeliverer(config.WebhooksCfg{Interval: 1, Timeout: 1000, BatchSize: 10, MaxAttempts: 3, MaxFailures: 4,
+		Backoff: 1000, MaxBackoff: 3000}, ws)
+	d.now = func() time.Time {
+		return *now
+	}
+	d.jitter = func(d time.Duration) time.Duration {
+		return d
+	}
+	return d
+}
+
+func TestDeliverer(t *testing.T) {
+	var now time.Time
+
+	t.Run("should post signed payloads", func(t *testing.T) {
+		rc := &receiver{}
+		ts := httptest.NewServer(rc)
+		defer ts.Close()
+		ws := memory.NewInMemoryPetStore(config.CfgData{}).(store.WebhookStore)
+		id, _ := ws.AddWebhook(ts.URL, []string{"created"}, "secret", true)
+		_, _ = ws.EnqueueDeliveries("created", `{"petId":1}`)
+		now = time.Now()
+		d := newTestDeliverer(ws, &now)
+
+		d.deliverDue(context.Background())
+
+		if len(rc.requests) != 1 || rc.bodies[0] != `{"petId":1}` {
+			t.Fatalf("got %v, want the payload", rc.bodies)
+		}
+		headers := rc.requests[0].Header
+		want := signPayload("secret", []byte(`{"petId":1}`))
+		if got := headers.Get(constants.WebhookSignature); got != want {
+			t.Fatalf("got signature %q, want %q", got, want)
+		}
+		if headers.Get(constants.WebhookEvent) != "created" || headers.Get(constants.WebhookDelivery) != "1" {
+			t.Fatalf("got headers %v, want event and delivery", headers)
+		}
+		deliveries, _, _ := ws.WebhookDeliveries(id, 0, 10)
+		if deliveries[0].Status != data.DeliveryDelivered || deliveries[0].Attempts[0].StatusCode != http.StatusNoContent {
+			t.Fatalf("got %v, want delivered", deliveries[0])
+		}
+	})
+
+	t.Run("should retry with backoff until running out of attempts", func(t *testing.T) {
+		rc := &receiver{statuses: []int{http.StatusInternalServerError, http.StatusBadGateway, http.StatusNotFound}}
+		ts := httptest.NewServer(rc)
+		defer ts.Close()
+		ws := memory.NewInMemoryPetStore(config.CfgData{}).(store.WebhookStore)
+		id, _ := ws.AddWebhook(ts.URL, []string{"created"}, "secret", true)
+		_, _ = ws.EnqueueDeliveries("created", `{}`)
+		now = time.Now()
+		start := now
+		d := newTestDeliverer(ws, &now)
+
+		type step struct {
+			at     time.Duration
+			status data.DeliveryStatus
+			next   time.Duration
+		}
+		for _, s := range []step{
+			{at: 0, status: data.DeliveryPending, next: time.Second},
+			{at: time.Second, status: data.DeliveryPending, next: 2 * time.Second},
+			{at: 3 * time.Second, status: data.DeliveryFailed},
+		} {
+			now = start.Add(s.at)
+			d.deliverDue(context.Background())
+
+			deliveries, _, _ := ws.WebhookDeliveries(id, 0, 10)
+			if deliveries[0].Status != s.status || (s.next != 0 && !deliveries[0].NextAttempt.Equal(now.Add(s.next))) {
+				t.Fatalf("got %v at %v, want %s next in %v", deliveries[0], s.at, s.status, s.next)
+			}
+		}
+		now = start.Add(time.Hour)
+		d.deliverDue(context.Background())
+		if len(rc.requests) != 3 {
+			t.Fatalf("got %d requests, want 3", len(rc.requests))
+		}
+	})
+
+	t.Run("should disable webhooks after repeated failures", func(t *testing.T) {
+		ts := httptest.NewServer(http.NotFoundHandler())
+		ts.Close()
+		ws := memory.NewInMemoryPetStore(config.CfgData{}).(store.WebhookStore)
+		id, _ := ws.AddWebhook(ts.URL, []string{"deleted"}, "secret", true)
+		for i := 0; i < 5; i++ {
+			_, _ = ws.EnqueueDeliveries("deleted", `{}`)
+		}
+		now = time.Now()
+		d := newTestDeliverer(ws, &now)
+
+		d.deliverDue(context.Background())
+
+		webhook, _ := ws.GetWebhook(id)
+		deliveries, _, _ := ws.WebhookDeliveries(id, 0, 10)
+		if webhook.Enabled || webhook.Failures != 4 || len(deliveries[4].Attempts) != 0 {
+			t.Fatalf("got %v with %v, want disabled after 4 failures", webhook, deliveries)
+		}
+		if deliveries[0].Attempts[0].Error == "" {
+			t.Fatalf("got %v, want connection error", deliveries[0].Attempts)
+		}
+	})
+
+	t.Run("should stop when context is done", func(t *testing.T) {
+		ws := memory.NewInMemoryPetStore(config.CfgData{}).(store.WebhookStore)
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			newTestDeliverer(ws, &now).run(ctx)
+			close(done)
+		}()
+		cancel()
+
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("deliverer did not stop")
+		}
+	})
+}
+
+func TestRetryDelay(t *testing.T) {
+	d := deliverer{backoff: time.Second, maxBackoff: 5 * time.Second, jitter: halfJitter}
+
+	for attempts, want := range []time.Duration{time.Second, time.Second, 2 * time.Second, 4 * time.Second,
+		5 * time.Second, 5 * time.Second} {
+		if got := d.retryDelay(attempts); got < want/2 || got > want {
+			t.Fatalf("got %v for %d attempts, want between %v and %v", got, attempts, want/2, want)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/LearningByExample/go-microservice/internal/app/constants"
+	"github.com/LearningByExample/go-microservice/internal/app/events"
+	"github.com/LearningByExample/go-microservice/internal/app/policy"
+	"github.com/LearningByExample/go-microservice/internal/app/resperr"
+	"github.com/LearningByExample/go-microservice/internal/app/store"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	petEventsPath    = "/pets/events"
+	defaultHeartbeat = 15 * time.Second
+)
+
+type eventsHandler struct {
+	broker    *events.Broker
+	data      store.PetStore
+	heartbeat time.Duration
+}
+
+// canSend tells if an event could be sent to the subject of a stream, the resyncs always and the changes of a pet
+// only when the store has no policy or the policy lets the subject read the pet
+func canSend(ps store.PetStore, event events.Event) bool {
+	if event.Type == events.Resync {
+		return true
+	}
+	authorizer, ok := ps.(policy.Authorizer)
+	return !ok || authorizer.CanReadPet(event.PetId)
+}
+
+func lastEventId(r *http.Request) (int64, error) {
+	value := r.Header.Get(constants.LastEventId)
+	if value == "" {
+		return 0, nil
+	}
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || id < 0 {
+		return 0, resperr.BadRequest
+	}
+	return id, nil
+}
+
+func writeEvent(w http.ResponseWriter, event events.Event) error {
+	bytes, err := json.Marshal(event)
+	if err == nil {
+		_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.Id, event.Type, bytes)
+	}
+	return err
+}
+
+func (h eventsHandler) getEventsRequest(w http.ResponseWriter, r *http.Request) error {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return errors.New("streaming not supported")
+	}
+	lastId, err := lastEventId(r)
+	if err != nil {
+		return err
+	}
+
+	ps := h.data.WithContext(r.Context())
+	sub := h.broker.Subscribe(lastId)
+	defer sub.Close()
+
+	w.Header().Add(constants.ContentType, constants.TextEventStream)
+	w.Header().Add(constants.CacheControl, "no-cache")
+	w.Header().Add("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for _, event := range sub.Replay {
+		if !canSend(ps, event) {
+			continue
+		}
+		if err := writeEvent(w, event); err != nil {
+			return nil
+		}
+	}
+	flusher.Flush()
+
+	ticker := time.NewTicker(h.heartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case event, ok := <-sub.Events:
+			if !ok {
+				return nil
+			}
+			if !canSend(ps, event) {
+				continue
+			}
+			if err := writeEvent(w, event); err != nil {
+				return nil
+			}
+		case <-ticker.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return nil
+			}
+		case <-r.Context().Done():
+			return nil
+		}
+		flusher.Flush()
+	}
+}
+
+func (h eventsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var rErr = resperr.None
+
+	if r.URL.Path != petEventsPath {
+		rErr = resperr.NotFound
+	} else if r.Method != http.MethodGet {
+		rErr = resperr.BadRequest
+	} else if err := h.getEventsRequest(w, r); err != nil {
+		rErr = resperr.FromError(err)
+	}
+
+	if rErr.Status() != http.StatusOK {
+		log.Printf("Error %v in %s request %q", rErr, r.Method, r.URL.Path)
+	}
+
+	if rErr.Status() != resperr.None.Status() {
+		rErr.Write(w)
+	}
+}
+
+func NewEventsHandler(broker *events.Broker, data store.PetStore) http.Handler {
+	return eventsHandler{broker: broker, data: data, heartbeat: defaultHeartbeat}
+}
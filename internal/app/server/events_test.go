@@ -0,0 +1,239 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/LearningByExample/go-microservice/internal/_test"
+	"github.com/LearningByExample/go-microservice/internal/app/config"
+	"github.com/LearningByExample/go-microservice/internal/app/constants"
+	"github.com/LearningByExample/go-microservice/internal/app/data"
+	"github.com/LearningByExample/go-microservice/internal/app/events"
+	"github.com/LearningByExample/go-microservice/internal/app/policy"
+	"github.com/LearningByExample/go-microservice/internal/app/reqctx"
+	"github.com/LearningByExample/go-microservice/internal/app/resperr"
+	"github.com/LearningByExample/go-microservice/internal/app/store"
+	"github.com/LearningByExample/go-microservice/internal/app/store/memory"
+)
+
+func newEventsTestStore() store.PetStore {
+	return memory.NewInMemoryPetStore(config.CfgData{})
+}
+
+func readEvents(t *testing.T, reader *bufio.Reader, count int) []string {
+	t.Helper()
+	lines := make([]string, 0)
+	for len(lines) < count {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("got error, %v", err)
+		}
+		if line = strings.TrimSuffix(line, "\n"); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func TestEventsRequest(t *testing.T) {
+	t.Run("should stream events", func(t *testing.T) {
+		broker := events.NewBroker(10)
+		broker.Publish(events.Created, 1)
+		first := broker.LastId()
+		broker.Publish(events.Updated, 1)
+		ts := httptest.NewServer(NewEventsHandler(broker, newEventsTestStore()))
+		defer ts.Close()
+
+		request, _ := http.NewRequest(http.MethodGet, ts.URL+petEventsPath, nil)
+		request.Header.Set(constants.LastEventId, strconv.FormatInt(first, 10))
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatalf("got error, %v", err)
+		}
+		defer response.Body.Close()
+
+		if response.StatusCode != http.StatusOK {
+			t.Fatalf("got %v, want %v", response.StatusCode, http.StatusOK)
+		}
+		if got := response.Header.Get(constants.ContentType); got != constants.TextEventStream {
+			t.Fatalf("got %q, want %q", got, constants.TextEventStream)
+		}
+
+		reader := bufio.NewReader(response.Body)
+		got := readEvents(t, reader, 3)
+		want := []string{fmt.Sprintf("id: %d", first+1), "event: updated", `data: {"type":"updated","petId":1}`}
+		if strings.Join(got, "\n") != strings.Join(want, "\n") {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+
+		broker.Publish(events.Deleted, 1)
+		got = readEvents(t, reader, 3)
+		want = []string{fmt.Sprintf("id: %d", first+2), "event: deleted", `data: {"type":"deleted","petId":1}`}
+		if strings.Join(got, "\n") != strings.Join(want, "\n") {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+
+		broker.Close()
+		if rest, err := ioutil.ReadAll(reader); err != nil || strings.TrimSpace(string(rest)) != "" {
+			t.Fatalf("got %q and %v, want stream closed", rest, err)
+		}
+	})
+
+	t.Run("should ask for a resync with an unknown last event id", func(t *testing.T) {
+		broker := events.NewBroker(10)
+		broker.Publish(events.Created, 1)
+		ts := httptest.NewServer(NewEventsHandler(broker, newEventsTestStore()))
+		defer ts.Close()
+		defer broker.Close()
+
+		request, _ := http.NewRequest(http.MethodGet, ts.URL+petEventsPath, nil)
+		request.Header.Set(constants.LastEventId, "1")
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatalf("got error, %v", err)
+		}
+		defer response.Body.Close()
+
+		got := readEvents(t, bufio.NewReader(response.Body), 2)
+		want := []string{fmt.Sprintf("id: %d", broker.LastId()), "event: resync"}
+		if strings.Join(got, "\n") != strings.Join(want, "\n") {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("should only send the events of the pets the subject could read", func(t *testing.T) {
+		base := newEventsTestStore()
+		owner, _ := base.(store.OwnerStore).AddOwner("Eve", "eve@example.com")
+		id, _ := base.AddPet("Fluff", "dog", "happy")
+		other, _ := base.AddPet("Lion", "cat", "brave")
+		_, _ = base.(store.OwnerStore).SetPetOwner(id, owner)
+		pol, err := policy.Parse([]byte(`{"roles": {"owner": [{"actions": ["read"], "when": "owner"}]}}`))
+		if err != nil {
+			t.Fatalf("want not error, got %v", err)
+		}
+
+		broker := events.NewBroker(10)
+		broker.Publish(events.Created, id)
+		first := broker.LastId()
+		broker.Publish(events.Updated, other)
+		broker.Publish(events.Updated, id)
+		handler := NewEventsHandler(broker, policy.NewStore(base, pol))
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal := data.Principal{Subject: "eve", Roles: []string{"owner"}, OwnerId: owner}
+			handler.ServeHTTP(w, r.WithContext(reqctx.WithGrants(reqctx.WithActor(r.Context(), "eve"), principal)))
+		}))
+		defer ts.Close()
+		defer broker.Close()
+
+		request, _ := http.NewRequest(http.MethodGet, ts.URL+petEventsPath, nil)
+		request.Header.Set(constants.LastEventId, strconv.FormatInt(first, 10))
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatalf("got error, %v", err)
+		}
+		defer response.Body.Close()
+
+		reader := bufio.NewReader(response.Body)
+		got := readEvents(t, reader, 3)
+		want := []string{fmt.Sprintf("id: %d", first+2), "event: updated",
+			fmt.Sprintf(`data: {"type":"updated","petId":%d}`, id)}
+		if strings.Join(got, "\n") != strings.Join(want, "\n") {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+
+		broker.Publish(events.Deleted, other)
+		broker.Publish(events.Deleted, id)
+		got = readEvents(t, reader, 3)
+		want = []string{fmt.Sprintf("id: %d", first+4), "event: deleted",
+			fmt.Sprintf(`data: {"type":"deleted","petId":%d}`, id)}
+		if strings.Join(got, "\n") != strings.Join(want, "\n") {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("should send heartbeats", func(t *testing.T) {
+		broker := events.NewBroker(10)
+		ts := httptest.NewServer(eventsHandler{broker: broker, data: newEventsTestStore(), heartbeat: 10 * time.Millisecond})
+		defer ts.Close()
+		defer broker.Close()
+
+		response, err := http.Get(ts.URL + petEventsPath)
+		if err != nil {
+			t.Fatalf("got error, %v", err)
+		}
+		defer response.Body.Close()
+
+		if got := readEvents(t, bufio.NewReader(response.Body), 1); got[0] != ": keep-alive" {
+			t.Fatalf("got %v, want keep-alive comment", got)
+		}
+	})
+
+	type testCase struct {
+		name    string
+		path    string
+		method  string
+		headers map[string]string
+		want    resperr.ResponseError
+	}
+
+	var cases = []testCase{
+		{
+			name:   "should fail with wrong method",
+			path:   petEventsPath,
+			method: http.MethodPost,
+			want:   resperr.BadRequest,
+		},
+		{
+			name:   "should fail with wrong path",
+			path:   petEventsPath + "/1",
+			method: http.MethodGet,
+			want:   resperr.NotFound,
+		},
+		{
+			name:    "should fail with invalid last event id",
+			path:    petEventsPath,
+			method:  http.MethodGet,
+			headers: map[string]string{constants.LastEventId: "abc"},
+			want:    resperr.BadRequest,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := NewEventsHandler(events.NewBroker(10), newEventsTestStore())
+
+			response := _test.HeaderRequest(handler, tt.path, tt.method, "", tt.headers)
+
+			_test.AssertResponseError(t, response, tt.want)
+		})
+	}
+}
@@ -0,0 +1,560 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/LearningByExample/go-microservice/internal/app/config"
+	"github.com/LearningByExample/go-microservice/internal/app/data"
+	"github.com/LearningByExample/go-microservice/internal/app/reqctx"
+	"github.com/LearningByExample/go-microservice/internal/app/resperr"
+	"github.com/LearningByExample/go-microservice/internal/app/store"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	graphqlPath         = "/graphql"
+	firstArg            = "first"
+	afterArg            = "after"
+	defaultGraphqlFirst = 20
+	maxGraphqlFirst     = 100
+	cursorPrefix        = "pet:"
+	queryNotEmpty       = "query cannot be empty"
+)
+
+var (
+	InvalidId     = errors.New("invalid id")
+	InvalidCursor = errors.New("invalid cursor")
+	InvalidFirst  = errors.New("first cannot be negative")
+)
+
+type graphqlRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+type graphqlKey int
+
+const (
+	resolverKey graphqlKey = iota
+)
+
+// resolver holds the per request state: the store bound to the request context and the batched loaders
+type resolver struct {
+	data   store.PetStore
+	pets   *loader
+	owners *loader
+}
+
+type petEdge struct {
+	Cursor string   `json:"cursor"`
+	Node   data.Pet `json:"node"`
+}
+
+type pageInfo struct {
+	HasNextPage bool   `json:"hasNextPage"`
+	EndCursor   string `json:"endCursor"`
+}
+
+type petConnection struct {
+	Edges      []petEdge `json:"edges"`
+	PageInfo   pageInfo  `json:"pageInfo"`
+	TotalCount int       `json:"totalCount"`
+}
+
+func newResolver(ps store.PetStore) *resolver {
+	r := &resolver{data: ps}
+	r.pets = newLoader(r.loadPets)
+	r.owners = newLoader(r.loadOwners)
+	return r
+}
+
+func resolverFrom(ctx context.Context) *resolver {
+	return ctx.Value(resolverKey).(*resolver)
+}
+
+func (r *resolver) loadPets(ids []int) (map[int]interface{}, error) {
+	pets, err := r.data.FindPets(store.PetQuery{Ids: ids})
+	found := make(map[int]interface{}, len(pets))
+	for _, pet := range pets {
+		found[pet.Id] = pet
+	}
+	return found, err
+}
+
+func (r *resolver) loadOwners(ids []int) (map[int]interface{}, error) {
+	found := make(map[int]interface{}, len(ids))
+	ownerStore, ok := r.data.(store.OwnerStore)
+	if !ok {
+		return found, nil
+	}
+	owners, err := ownerStore.GetOwners(ids)
+	for _, owner := range owners {
+		found[owner.Id] = owner
+	}
+	return found, err
+}
+
+func encodeCursor(id int) string {
+	return base64.StdEncoding.EncodeToString([]byte(cursorPrefix + strconv.Itoa(id)))
+}
+
+func decodeCursor(cursor string) (int, error) {
+	if bytes, err := base64.StdEncoding.DecodeString(cursor); err == nil {
+		if value := string(bytes); strings.HasPrefix(value, cursorPrefix) {
+			if id, err := strconv.Atoi(strings.TrimPrefix(value, cursorPrefix)); err == nil {
+				return id, nil
+			}
+		}
+	}
+	return 0, InvalidCursor
+}
+
+func argId(value interface{}) (int, error) {
+	if str, ok := value.(string); ok {
+		if id, err := strconv.Atoi(str); err == nil && id > 0 {
+			return id, nil
+		}
+	}
+	return 0, InvalidId
+}
+
+// graphqlError keeps the validation messages of the response errors, the rest are already readable
+func graphqlError(err error) error {
+	if rErr, ok := err.(resperr.ResponseError); ok {
+		return errors.New(responseMessage(rErr))
+	}
+	return err
+}
+
+func petFilter(args map[string]interface{}) (store.PetQuery, error) {
+	query := store.PetQuery{Sort: []store.PetSort{{Field: store.SortById}}}
+	filter, _ := args["filter"].(map[string]interface{})
+	for _, value := range asList(filter["ids"]) {
+		id, err := argId(value)
+		if err != nil {
+			return query, err
+		}
+		query.Ids = append(query.Ids, id)
+	}
+	if value, found := filter["ownerId"]; found && value != nil {
+		id, err := argId(value)
+		if err != nil {
+			return query, err
+		}
+		query.OwnerId = id
+	}
+	tags := make([]string, 0)
+	for _, value := range asList(filter["tags"]) {
+		tags = append(tags, value.(string))
+	}
+	query.Tags = data.SortedTags(tags)
+	for _, value := range asList(filter["attributes"]) {
+		attribute := value.(map[string]interface{})
+		if query.Attributes == nil {
+			query.Attributes = make(map[string]string)
+		}
+		query.Attributes[attribute["key"].(string)] = attribute["value"].(string)
+	}
+	query.IncludeDeleted, _ = filter["includeDeleted"].(bool)
+	return query, nil
+}
+
+func sortedKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func asList(value interface{}) []interface{} {
+	list, _ := value.([]interface{})
+	return list
+}
+
+func resolvePets(p graphql.ResolveParams) (interface{}, error) {
+	query, err := petFilter(p.Args)
+	if err != nil {
+		return nil, err
+	}
+	if err = allowDeleted(p.Context, query.IncludeDeleted); err != nil {
+		return nil, graphqlError(err)
+	}
+	first, _ := p.Args[firstArg].(int)
+	if first < 0 {
+		return nil, InvalidFirst
+	}
+	if first > maxGraphqlFirst {
+		first = maxGraphqlFirst
+	}
+	after := 0
+	if cursor, ok := p.Args[afterArg].(string); ok {
+		if after, err = decodeCursor(cursor); err != nil {
+			return nil, err
+		}
+	}
+
+	// one more pet than the page tells if there is a next one
+	ps := resolverFrom(p.Context).data
+	query.AfterId, query.Limit = after, first+1
+	pets, err := ps.FindPets(query)
+	if err != nil {
+		return nil, err
+	}
+	total, err := ps.CountPets(query)
+	if err != nil {
+		return nil, err
+	}
+	end := first
+	if end > len(pets) {
+		end = len(pets)
+	}
+
+	connection := petConnection{Edges: make([]petEdge, 0, end), TotalCount: total}
+	for _, pet := range pets[:end] {
+		connection.Edges = append(connection.Edges, petEdge{Cursor: encodeCursor(pet.Id), Node: pet})
+	}
+	connection.PageInfo.HasNextPage = end < len(pets)
+	if len(connection.Edges) > 0 {
+		connection.PageInfo.EndCursor = connection.Edges[len(connection.Edges)-1].Cursor
+	}
+	return connection, nil
+}
+
+func resolvePet(p graphql.ResolveParams) (interface{}, error) {
+	id, err := argId(p.Args["id"])
+	if err != nil {
+		return nil, err
+	}
+	return resolverFrom(p.Context).pets.load(id), nil
+}
+
+func petInput(p graphql.ResolveParams) data.Pet {
+	input := p.Args["input"].(map[string]interface{})
+	return data.Pet{Name: input["name"].(string), Race: input["race"].(string), Mod: input["mod"].(string)}
+}
+
+func resolveAddPet(p graphql.ResolveParams) (interface{}, error) {
+	var pet = petInput(p)
+	var err = validPet(pet)
+	ps := resolverFrom(p.Context).data
+	if err == nil {
+		if pet.Id, err = ps.AddPet(pet.Name, pet.Race, pet.Mod); err == nil {
+			pet, err = ps.GetPet(pet.Id)
+		}
+	}
+	return pet, graphqlError(err)
+}
+
+func resolveUpdatePet(p graphql.ResolveParams) (interface{}, error) {
+	var pet = petInput(p)
+	var err = validPet(pet)
+	ps := resolverFrom(p.Context).data
+	if err == nil {
+		if pet.Id, err = argId(p.Args["id"]); err == nil {
+			if _, err = ps.UpdatePet(pet.Id, pet.Name, pet.Race, pet.Mod); err == nil {
+				pet, err = ps.GetPet(pet.Id)
+			}
+		}
+	}
+	return pet, graphqlError(err)
+}
+
+func resolveDeletePet(p graphql.ResolveParams) (interface{}, error) {
+	id, err := argId(p.Args["id"])
+	if err == nil {
+		err = resolverFrom(p.Context).data.DeletePet(id)
+	}
+	return id, err
+}
+
+// withWriteScope rejects the mutations of the callers without the scope to write pets
+func withWriteScope(resolve graphql.FieldResolveFn) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		if !reqctx.Allowed(p.Context, data.ScopePetsWrite) {
+			return nil, graphqlError(resperr.Forbidden)
+		}
+		return resolve(p)
+	}
+}
+
+func resolveOwner(p graphql.ResolveParams) (interface{}, error) {
+	pet := p.Source.(data.Pet)
+	if pet.OwnerId == nil {
+		return nil, nil
+	}
+	return resolverFrom(p.Context).owners.load(*pet.OwnerId), nil
+}
+
+func resolveTime(get func(pet data.Pet) *time.Time) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		if t := get(p.Source.(data.Pet)); t != nil {
+			return t.Format(time.RFC3339Nano), nil
+		}
+		return nil, nil
+	}
+}
+
+func newGraphqlSchema() (graphql.Schema, error) {
+	attributeType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Attribute",
+		Fields: graphql.Fields{
+			"key":   &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"value": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		},
+	})
+	ownerType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Owner",
+		Fields: graphql.Fields{
+			"id":    &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+			"name":  &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"email": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+		},
+	})
+	petType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Pet",
+		Fields: graphql.Fields{
+			"id":   &graphql.Field{Type: graphql.NewNonNull(graphql.ID)},
+			"name": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"race": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"mod":  &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"status": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.String),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return string(p.Source.(data.Pet).Status), nil
+				},
+			},
+			"tags": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.String))),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return append([]string{}, p.Source.(data.Pet).Tags...), nil
+				},
+			},
+			"attributes": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(attributeType))),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					attributes := p.Source.(data.Pet).Attributes
+					result := make([]map[string]string, 0, len(attributes))
+					for _, key := range sortedKeys(attributes) {
+						result = append(result, map[string]string{"key": key, "value": attributes[key]})
+					}
+					return result, nil
+				},
+			},
+			"owner": &graphql.Field{Type: ownerType, Resolve: resolveOwner},
+			"createdAt": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.String),
+				Resolve: resolveTime(func(pet data.Pet) *time.Time {
+					return &pet.CreatedAt
+				}),
+			},
+			"updatedAt": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.String),
+				Resolve: resolveTime(func(pet data.Pet) *time.Time {
+					return &pet.UpdatedAt
+				}),
+			},
+			"deletedAt": &graphql.Field{
+				Type: graphql.String,
+				Resolve: resolveTime(func(pet data.Pet) *time.Time {
+					return pet.DeletedAt
+				}),
+			},
+		},
+	})
+	connectionType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "PetConnection",
+		Fields: graphql.Fields{
+			"edges": &graphql.Field{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(
+				graphql.NewObject(graphql.ObjectConfig{
+					Name: "PetEdge",
+					Fields: graphql.Fields{
+						"cursor": &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+						"node":   &graphql.Field{Type: graphql.NewNonNull(petType)},
+					},
+				}))))},
+			"pageInfo": &graphql.Field{Type: graphql.NewNonNull(graphql.NewObject(graphql.ObjectConfig{
+				Name: "PageInfo",
+				Fields: graphql.Fields{
+					"hasNextPage": &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean)},
+					"endCursor":   &graphql.Field{Type: graphql.String},
+				},
+			}))},
+			"totalCount": &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+		},
+	})
+	filterType := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name: "PetFilter",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"ids":     &graphql.InputObjectFieldConfig{Type: graphql.NewList(graphql.NewNonNull(graphql.ID))},
+			"ownerId": &graphql.InputObjectFieldConfig{Type: graphql.ID},
+			"tags":    &graphql.InputObjectFieldConfig{Type: graphql.NewList(graphql.NewNonNull(graphql.String))},
+			"attributes": &graphql.InputObjectFieldConfig{Type: graphql.NewList(graphql.NewNonNull(
+				graphql.NewInputObject(graphql.InputObjectConfig{
+					Name: "AttributeInput",
+					Fields: graphql.InputObjectConfigFieldMap{
+						"key":   &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
+						"value": &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
+					},
+				})))},
+			"includeDeleted": &graphql.InputObjectFieldConfig{Type: graphql.Boolean},
+		},
+	})
+	inputType := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name: "PetInput",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"name": &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
+			"race": &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
+			"mod":  &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
+		},
+	})
+	idArg := &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)}
+	inputArg := &graphql.ArgumentConfig{Type: graphql.NewNonNull(inputType)}
+
+	return graphql.NewSchema(graphql.SchemaConfig{
+		Query: graphql.NewObject(graphql.ObjectConfig{
+			Name: "Query",
+			Fields: graphql.Fields{
+				"pet": &graphql.Field{
+					Type:    petType,
+					Args:    graphql.FieldConfigArgument{"id": idArg},
+					Resolve: resolvePet,
+				},
+				"pets": &graphql.Field{
+					Type: graphql.NewNonNull(connectionType),
+					Args: graphql.FieldConfigArgument{
+						"filter": &graphql.ArgumentConfig{Type: filterType},
+						firstArg: &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: defaultGraphqlFirst},
+						afterArg: &graphql.ArgumentConfig{Type: graphql.String},
+					},
+					Resolve: resolvePets,
+				},
+			},
+		}),
+		Mutation: graphql.NewObject(graphql.ObjectConfig{
+			Name: "Mutation",
+			Fields: graphql.Fields{
+				"addPet": &graphql.Field{
+					Type:    graphql.NewNonNull(petType),
+					Args:    graphql.FieldConfigArgument{"input": inputArg},
+					Resolve: withWriteScope(resolveAddPet),
+				},
+				"updatePet": &graphql.Field{
+					Type:    graphql.NewNonNull(petType),
+					Args:    graphql.FieldConfigArgument{"id": idArg, "input": inputArg},
+					Resolve: withWriteScope(resolveUpdatePet),
+				},
+				"deletePet": &graphql.Field{
+					Type:    graphql.NewNonNull(graphql.ID),
+					Args:    graphql.FieldConfigArgument{"id": idArg},
+					Resolve: withWriteScope(resolveDeletePet),
+				},
+			},
+		}),
+	})
+}
+
+type graphqlHandler struct {
+	schema        graphql.Schema
+	data          store.PetStore
+	maxDepth      int
+	maxComplexity int
+}
+
+func (h graphqlHandler) execute(ctx context.Context, req graphqlRequest) *graphql.Result {
+	doc, err := parser.Parse(parser.ParseParams{Source: source.NewSource(&source.Source{Body: []byte(req.Query)})})
+	if err != nil {
+		return &graphql.Result{Errors: gqlerrors.FormatErrors(err)}
+	}
+	if validation := graphql.ValidateDocument(&h.schema, doc, nil); !validation.IsValid {
+		return &graphql.Result{Errors: validation.Errors}
+	}
+	if err = checkLimits(doc, req.OperationName, req.Variables, h.maxDepth, h.maxComplexity); err != nil {
+		return &graphql.Result{Errors: gqlerrors.FormatErrors(err)}
+	}
+
+	return graphql.Execute(graphql.ExecuteParams{
+		Schema:        h.schema,
+		AST:           doc,
+		OperationName: req.OperationName,
+		Args:          req.Variables,
+		Context:       context.WithValue(ctx, resolverKey, newResolver(h.data.WithContext(ctx))),
+	})
+}
+
+func (h graphqlHandler) postGraphqlRequest(w http.ResponseWriter, r *http.Request) error {
+	if r.Body == nil {
+		return resperr.NotBodyProvided
+	}
+	req := graphqlRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return resperr.BadRequest
+	}
+	if strings.TrimSpace(req.Query) == "" {
+		return resperr.FromErrorMessage(resperr.BadRequest, []string{queryNotEmpty})
+	}
+	return writeJson(w, h.execute(r.Context(), req))
+}
+
+func (h graphqlHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var rErr = resperr.None
+
+	if r.URL.Path != graphqlPath {
+		rErr = resperr.NotFound
+	} else if r.Method != http.MethodPost {
+		rErr = resperr.BadRequest
+	} else if err := h.postGraphqlRequest(w, r); err != nil {
+		rErr = resperr.FromError(err)
+	}
+
+	if rErr.Status() != http.StatusOK {
+		log.Printf("Error %v in %s request %q", rErr, r.Method, r.URL.Path)
+	}
+
+	if rErr.Status() != resperr.None.Status() {
+		rErr.Write(w)
+	}
+}
+
+func NewGraphqlHandler(cfg config.GraphqlCfg, store store.PetStore) (http.Handler, error) {
+	schema, err := newGraphqlSchema()
+	if err != nil {
+		return nil, err
+	}
+	return graphqlHandler{schema: schema, data: store, maxDepth: cfg.MaxDepth, maxComplexity: cfg.MaxComplexity}, nil
+}
@@ -0,0 +1,416 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/LearningByExample/go-microservice/internal/_test"
+	"github.com/LearningByExample/go-microservice/internal/app/config"
+	"github.com/LearningByExample/go-microservice/internal/app/data"
+	"github.com/LearningByExample/go-microservice/internal/app/reqctx"
+	"github.com/LearningByExample/go-microservice/internal/app/resperr"
+	"github.com/LearningByExample/go-microservice/internal/app/store"
+	"github.com/LearningByExample/go-microservice/internal/app/store/memory"
+	"github.com/graphql-go/graphql/language/parser"
+)
+
+type countingStore struct {
+	store.PetStore
+	store.OwnerStore
+	findPets  int
+	getOwners int
+	query     store.PetQuery
+}
+
+func (s *countingStore) FindPets(query store.PetQuery) ([]data.Pet, error) {
+	s.findPets++
+	s.query = query
+	return s.PetStore.FindPets(query)
+}
+
+func (s *countingStore) GetOwners(ids []int) ([]data.Owner, error) {
+	s.getOwners++
+	return s.OwnerStore.GetOwners(ids)
+}
+
+func (s *countingStore) WithContext(_ context.Context) store.PetStore {
+	return s
+}
+
+type graphqlResponse struct {
+	Data   json.RawMessage `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+func newTestGraphqlStore() *countingStore {
+	ps := memory.NewInMemoryPetStore(config.CfgData{})
+	owners := ps.(store.OwnerStore)
+	owner, _ := owners.AddOwner("John", "john@example.com")
+	for _, name := range []string{"Fluff", "Lion", "Whiskers", "Rex"} {
+		id, _ := ps.AddPet(name, "dog", "happy")
+		_, _ = owners.SetPetOwner(id, owner)
+	}
+	_, _ = ps.SetPetTags(2, []string{"big"}, map[string]string{"color": "brown"})
+	return &countingStore{PetStore: ps, OwnerStore: owners}
+}
+
+func newTestGraphqlHandler(t *testing.T, ps store.PetStore, cfg config.GraphqlCfg) http.Handler {
+	handler, err := NewGraphqlHandler(cfg, ps)
+	if err != nil {
+		t.Fatalf("error creating handler: %v", err)
+	}
+	return handler
+}
+
+func graphqlQuery(t *testing.T, handler http.Handler, query string, variables map[string]interface{},
+	result interface{}) graphqlResponse {
+	t.Helper()
+	response := _test.PostRequest(handler, graphqlPath, graphqlRequest{Query: query, Variables: variables})
+	if response.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", response.Code, http.StatusOK)
+	}
+	gr := graphqlResponse{}
+	if err := json.NewDecoder(response.Body).Decode(&gr); err != nil {
+		t.Fatalf("error decoding response: %v", err)
+	}
+	if result != nil && len(gr.Data) != 0 {
+		if err := json.Unmarshal(gr.Data, result); err != nil {
+			t.Fatalf("error decoding data: %v", err)
+		}
+	}
+	return gr
+}
+
+type testGraphqlPet struct {
+	Id         string `json:"id"`
+	Name       string `json:"name"`
+	Status     string `json:"status"`
+	Tags       []string
+	Attributes []struct {
+		Key   string `json:"key"`
+		Value string `json:"value"`
+	} `json:"attributes"`
+	Owner *struct {
+		Name string `json:"name"`
+	} `json:"owner"`
+}
+
+type testConnection struct {
+	Pets struct {
+		Edges []struct {
+			Cursor string         `json:"cursor"`
+			Node   testGraphqlPet `json:"node"`
+		} `json:"edges"`
+		PageInfo struct {
+			HasNextPage bool   `json:"hasNextPage"`
+			EndCursor   string `json:"endCursor"`
+		} `json:"pageInfo"`
+		TotalCount int `json:"totalCount"`
+	} `json:"pets"`
+}
+
+func (c testConnection) names() string {
+	names := make([]string, 0)
+	for _, edge := range c.Pets.Edges {
+		names = append(names, edge.Node.Name)
+	}
+	return strings.Join(names, ",")
+}
+
+var testGraphqlCfg = config.GraphqlCfg{MaxDepth: 10, MaxComplexity: 1000}
+
+func TestGraphqlQueries(t *testing.T) {
+	ps := newTestGraphqlStore()
+	handler := newTestGraphqlHandler(t, ps, testGraphqlCfg)
+
+	t.Run("should get a pet", func(t *testing.T) {
+		result := struct {
+			Pet testGraphqlPet `json:"pet"`
+		}{}
+		gr := graphqlQuery(t, handler, `{ pet(id: 2) { id name status tags attributes { key value } owner { name } } }`,
+			nil, &result)
+		pet := result.Pet
+		if len(gr.Errors) != 0 || pet.Id != "2" || pet.Name != "Lion" || pet.Status != "available" ||
+			len(pet.Tags) != 1 || len(pet.Attributes) != 1 || pet.Attributes[0].Value != "brown" ||
+			pet.Owner == nil || pet.Owner.Name != "John" {
+			t.Fatalf("got %+v and %v, want pet Lion", pet, gr.Errors)
+		}
+	})
+
+	t.Run("should get null for a pet that does not exist", func(t *testing.T) {
+		gr := graphqlQuery(t, handler, `{ pet(id: 99) { name } }`, nil, nil)
+		if len(gr.Errors) != 0 || string(gr.Data) != `{"pet":null}` {
+			t.Fatalf("got %s and %v, want null pet", gr.Data, gr.Errors)
+		}
+	})
+
+	t.Run("should page the pets", func(t *testing.T) {
+		query := `query Page($after: String) {
+			pets(first: 3, after: $after) { edges { cursor node { name } } pageInfo { hasNextPage endCursor } totalCount }
+		}`
+		first := testConnection{}
+		graphqlQuery(t, handler, query, nil, &first)
+		if first.names() != "Fluff,Lion,Whiskers" || !first.Pets.PageInfo.HasNextPage || first.Pets.TotalCount != 4 {
+			t.Fatalf("got %+v, want the first page", first)
+		}
+
+		second := testConnection{}
+		graphqlQuery(t, handler, query, map[string]interface{}{"after": first.Pets.PageInfo.EndCursor}, &second)
+		if second.names() != "Rex" || second.Pets.PageInfo.HasNextPage {
+			t.Fatalf("got %+v, want the last page", second)
+		}
+		if ps.query.AfterId != 3 || ps.query.Limit != 4 {
+			t.Fatalf("got query %+v, want the page after pet 3 asked to the store", ps.query)
+		}
+	})
+
+	t.Run("should filter the pets", func(t *testing.T) {
+		result := testConnection{}
+		graphqlQuery(t, handler, `{ pets(filter: { tags: ["big"] }) { edges { node { name } } } }`, nil, &result)
+		if result.names() != "Lion" {
+			t.Fatalf("got %q, want Lion", result.names())
+		}
+	})
+
+	t.Run("should fail with an invalid cursor", func(t *testing.T) {
+		gr := graphqlQuery(t, handler, `{ pets(after: "nope") { totalCount } }`, nil, nil)
+		if len(gr.Errors) != 1 || gr.Errors[0].Message != InvalidCursor.Error() {
+			t.Fatalf("got %v, want %v", gr.Errors, InvalidCursor)
+		}
+	})
+}
+
+func TestGraphqlBatching(t *testing.T) {
+	ps := newTestGraphqlStore()
+	handler := newTestGraphqlHandler(t, ps, testGraphqlCfg)
+
+	t.Run("should load the owners of a page at once", func(t *testing.T) {
+		ps.findPets, ps.getOwners = 0, 0
+		result := testConnection{}
+		graphqlQuery(t, handler, `{ pets { edges { node { name owner { name } } } } }`, nil, &result)
+		if len(result.Pets.Edges) != 4 || result.Pets.Edges[3].Node.Owner.Name != "John" {
+			t.Fatalf("got %+v, want 4 pets with owner", result)
+		}
+		if ps.findPets != 1 || ps.getOwners != 1 {
+			t.Fatalf("got %d find pets and %d get owners calls, want 1 and 1", ps.findPets, ps.getOwners)
+		}
+	})
+
+	t.Run("should load the pets of a query at once", func(t *testing.T) {
+		ps.findPets = 0
+		gr := graphqlQuery(t, handler, `{ a: pet(id: 1) { name } b: pet(id: 3) { name } c: pet(id: 1) { id } }`, nil, nil)
+		if len(gr.Errors) != 0 || string(gr.Data) != `{"a":{"name":"Fluff"},"b":{"name":"Whiskers"},"c":{"id":"1"}}` {
+			t.Fatalf("got %s and %v, want pets 1 and 3", gr.Data, gr.Errors)
+		}
+		if ps.findPets != 1 {
+			t.Fatalf("got %d find pets calls, want 1", ps.findPets)
+		}
+	})
+}
+
+func TestGraphqlMutations(t *testing.T) {
+	ps := newTestGraphqlStore()
+	handler := newTestGraphqlHandler(t, ps, testGraphqlCfg)
+
+	t.Run("should add a pet", func(t *testing.T) {
+		result := struct {
+			AddPet testGraphqlPet `json:"addPet"`
+		}{}
+		gr := graphqlQuery(t, handler, `mutation { addPet(input: {name: "Tom", race: "cat", mod: "lazy"}) { id name } }`,
+			nil, &result)
+		if len(gr.Errors) != 0 || result.AddPet.Id != "5" || result.AddPet.Name != "Tom" {
+			t.Fatalf("got %+v and %v, want pet Tom", result.AddPet, gr.Errors)
+		}
+	})
+
+	t.Run("should not add an invalid pet", func(t *testing.T) {
+		gr := graphqlQuery(t, handler, `mutation { addPet(input: {name: "", race: "cat", mod: "lazy"}) { id } }`, nil, nil)
+		if len(gr.Errors) != 1 || gr.Errors[0].Message != petNameNotEmpty {
+			t.Fatalf("got %v, want %q", gr.Errors, petNameNotEmpty)
+		}
+	})
+
+	t.Run("should update a pet", func(t *testing.T) {
+		result := struct {
+			UpdatePet testGraphqlPet `json:"updatePet"`
+		}{}
+		gr := graphqlQuery(t, handler, `mutation Update($id: ID!) {
+			updatePet(id: $id, input: {name: "Tommy", race: "cat", mod: "lazy"}) { name }
+		}`, map[string]interface{}{"id": "5"}, &result)
+		if len(gr.Errors) != 0 || result.UpdatePet.Name != "Tommy" {
+			t.Fatalf("got %+v and %v, want pet Tommy", result.UpdatePet, gr.Errors)
+		}
+	})
+
+	t.Run("should delete a pet", func(t *testing.T) {
+		gr := graphqlQuery(t, handler, `mutation { deletePet(id: 5) }`, nil, nil)
+		if len(gr.Errors) != 0 || string(gr.Data) != `{"deletePet":"5"}` {
+			t.Fatalf("got %s and %v, want deleted pet 5", gr.Data, gr.Errors)
+		}
+		if _, err := ps.GetPet(5); err != store.PetNotFound {
+			t.Fatalf("got %v, want %v", err, store.PetNotFound)
+		}
+	})
+
+	t.Run("should not add a pet without the write scope", func(t *testing.T) {
+		readOnly := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			grants := data.ApiKey{Scopes: []string{data.ScopePetsRead}}
+			handler.ServeHTTP(w, r.WithContext(reqctx.WithGrants(r.Context(), grants)))
+		})
+		gr := graphqlQuery(t, readOnly, `mutation { addPet(input: {name: "Tom", race: "cat", mod: "lazy"}) { id } }`,
+			nil, nil)
+		if len(gr.Errors) != 1 || gr.Errors[0].Message != resperr.Forbidden.ErrorStr {
+			t.Fatalf("got %v, want %v", gr.Errors, resperr.Forbidden)
+		}
+	})
+
+	t.Run("should not find deleted pets without the admin scope", func(t *testing.T) {
+		readOnly := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			grants := data.ApiKey{Scopes: []string{data.ScopePetsRead}}
+			handler.ServeHTTP(w, r.WithContext(reqctx.WithGrants(r.Context(), grants)))
+		})
+		gr := graphqlQuery(t, readOnly, `{ pets(filter: {includeDeleted: true}) { totalCount } }`, nil, nil)
+		if len(gr.Errors) != 1 || gr.Errors[0].Message != resperr.Forbidden.ErrorStr {
+			t.Fatalf("got %v, want %v", gr.Errors, resperr.Forbidden)
+		}
+	})
+
+	t.Run("should not update a pet that does not exist", func(t *testing.T) {
+		gr := graphqlQuery(t, handler, `mutation { updatePet(id: 5, input: {name: "a", race: "b", mod: "c"}) { id } }`,
+			nil, nil)
+		if len(gr.Errors) != 1 || gr.Errors[0].Message != store.PetNotFound.Error() {
+			t.Fatalf("got %v, want %v", gr.Errors, store.PetNotFound)
+		}
+	})
+}
+
+func TestGraphqlLimits(t *testing.T) {
+	ps := newTestGraphqlStore()
+	handler := newTestGraphqlHandler(t, ps, config.GraphqlCfg{MaxDepth: 4, MaxComplexity: 100})
+
+	type testCase struct {
+		name  string
+		query string
+		want  string
+	}
+
+	var cases = []testCase{
+		{
+			name:  "should accept a query within the limits",
+			query: `{ pets(first: 10) { edges { node { name } } } }`,
+			want:  "",
+		},
+		{
+			name:  "should reject a query too deep",
+			query: `{ pets(first: 1) { edges { node { owner { name } } } } }`,
+			want:  "query depth 5 exceeds the limit of 4",
+		},
+		{
+			name:  "should reject a query too deep using fragments",
+			query: `{ pets(first: 1) { ...edges } } fragment edges on PetConnection { edges { node { owner { name } } } }`,
+			want:  "query depth 5 exceeds the limit of 4",
+		},
+		{
+			name:  "should reject a query too complex",
+			query: `{ pets(first: 50) { edges { node { name } } } }`,
+			want:  "query complexity 151 exceeds the limit of 100",
+		},
+		{
+			name:  "should reject a query too complex using the default page size",
+			query: `{ a: pets { edges { node { name race } } } b: pets { totalCount } }`,
+			want:  "query complexity 102 exceeds the limit of 100",
+		},
+		{
+			name:  "should report validation errors",
+			query: `{ pets { unknown } }`,
+			want:  `Cannot query field "unknown" on type "PetConnection".`,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			gr := graphqlQuery(t, handler, tt.query, nil, nil)
+			got := ""
+			if len(gr.Errors) != 0 {
+				got = gr.Errors[0].Message
+			}
+			if got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckLimits(t *testing.T) {
+	doc, err := parser.Parse(parser.ParseParams{Source: `
+		query Small { pet(id: 1) { name } }
+		query Big($first: Int) { pets(first: $first) { edges { node { name race mod } } } }
+	`})
+	if err != nil {
+		t.Fatalf("error parsing: %v", err)
+	}
+
+	if err := checkLimits(doc, "Small", nil, 2, 2); err != nil {
+		t.Fatalf("got %v, want no error", err)
+	}
+	variables := map[string]interface{}{"first": float64(30)}
+	want := "query complexity 151 exceeds the limit of 150"
+	if err := checkLimits(doc, "Big", variables, 4, 150); err == nil || err.Error() != want {
+		t.Fatalf("got %v, want %q", err, want)
+	}
+}
+
+func TestGraphqlHandler(t *testing.T) {
+	handler := newTestGraphqlHandler(t, newTestGraphqlStore(), testGraphqlCfg)
+
+	t.Run("should only accept posts", func(t *testing.T) {
+		_test.AssertResponseError(t, _test.GetRequest(handler, graphqlPath), resperr.BadRequest)
+	})
+
+	t.Run("should fail with an invalid body", func(t *testing.T) {
+		_test.AssertResponseError(t, _test.PostRequest(handler, graphqlPath, "{"), resperr.BadRequest)
+	})
+
+	t.Run("should fail without a query", func(t *testing.T) {
+		response := _test.PostRequest(handler, graphqlPath, graphqlRequest{})
+		_test.AssertResponseError(t, response, resperr.FromErrorMessage(resperr.BadRequest, []string{queryNotEmpty}))
+	})
+
+	t.Run("should not find other paths", func(t *testing.T) {
+		_test.AssertResponseError(t, _test.PostRequest(handler, "/graphql/other", "{}"), resperr.NotFound)
+	})
+
+	t.Run("should report syntax errors", func(t *testing.T) {
+		gr := graphqlQuery(t, handler, `{ pets `, nil, nil)
+		if len(gr.Errors) != 1 || !strings.Contains(gr.Errors[0].Message, "Syntax Error") {
+			t.Fatalf("got %v, want a syntax error", gr.Errors)
+		}
+	})
+}
@@ -0,0 +1,135 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package server
+
+import (
+	"fmt"
+	"github.com/graphql-go/graphql/language/ast"
+	"strconv"
+	"strings"
+)
+
+const (
+	queryTooDeep    = "query depth %d exceeds the limit of %d"
+	queryTooComplex = "query complexity %d exceeds the limit of %d"
+)
+
+// connectionFields are the fields returning a page of results, with the page size used when first is not set
+var connectionFields = map[string]int{
+	"pets": defaultGraphqlFirst,
+}
+
+type queryCost struct {
+	fragments map[string]*ast.FragmentDefinition
+	variables map[string]interface{}
+	visiting  map[string]bool
+}
+
+func (q queryCost) pageSize(field *ast.Field) int {
+	size, isConnection := connectionFields[field.Name.Value]
+	for _, arg := range field.Arguments {
+		if arg.Name.Value != firstArg {
+			continue
+		}
+		isConnection = true
+		switch value := arg.Value.(type) {
+		case *ast.IntValue:
+			size, _ = strconv.Atoi(value.Value)
+		case *ast.Variable:
+			switch v := q.variables[value.Name.Value].(type) {
+			case float64:
+				size = int(v)
+			case int:
+				size = v
+			}
+		}
+	}
+	if !isConnection || size < 1 {
+		return 1
+	}
+	return size
+}
+
+// measure returns the depth and the complexity of a selection set: each field costs one plus the complexity of its
+// own selection, multiplied by the page size for connections. Introspection fields are free.
+func (q queryCost) measure(set *ast.SelectionSet) (int, int) {
+	depth, complexity := 0, 0
+	if set == nil {
+		return depth, complexity
+	}
+	for _, selection := range set.Selections {
+		d, c := 0, 0
+		switch s := selection.(type) {
+		case *ast.Field:
+			if strings.HasPrefix(s.Name.Value, "__") {
+				continue
+			}
+			d, c = q.measure(s.SelectionSet)
+			d, c = d+1, 1+q.pageSize(s)*c
+		case *ast.InlineFragment:
+			d, c = q.measure(s.SelectionSet)
+		case *ast.FragmentSpread:
+			name := s.Name.Value
+			if fragment, found := q.fragments[name]; found && !q.visiting[name] {
+				q.visiting[name] = true
+				d, c = q.measure(fragment.SelectionSet)
+				delete(q.visiting, name)
+			}
+		}
+		if d > depth {
+			depth = d
+		}
+		complexity += c
+	}
+	return depth, complexity
+}
+
+// checkLimits rejects the operations that are too deep or too complex before they are executed
+func checkLimits(doc *ast.Document, operationName string, variables map[string]interface{}, maxDepth int,
+	maxComplexity int) error {
+	q := queryCost{
+		fragments: make(map[string]*ast.FragmentDefinition),
+		variables: variables,
+		visiting:  make(map[string]bool),
+	}
+	for _, definition := range doc.Definitions {
+		if fragment, ok := definition.(*ast.FragmentDefinition); ok {
+			q.fragments[fragment.Name.Value] = fragment
+		}
+	}
+
+	for _, definition := range doc.Definitions {
+		op, ok := definition.(*ast.OperationDefinition)
+		if !ok || (operationName != "" && (op.Name == nil || op.Name.Value != operationName)) {
+			continue
+		}
+		depth, complexity := q.measure(op.SelectionSet)
+		if depth > maxDepth {
+			return fmt.Errorf(queryTooDeep, depth, maxDepth)
+		}
+		if complexity > maxComplexity {
+			return fmt.Errorf(queryTooComplex, complexity, maxComplexity)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,470 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"github.com/LearningByExample/go-microservice/internal/app/constants"
+	"github.com/LearningByExample/go-microservice/internal/app/data"
+	"github.com/LearningByExample/go-microservice/internal/app/events"
+	"github.com/LearningByExample/go-microservice/internal/app/petpb"
+	"github.com/LearningByExample/go-microservice/internal/app/reqctx"
+	"github.com/LearningByExample/go-microservice/internal/app/resperr"
+	"github.com/LearningByExample/go-microservice/internal/app/store"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/empty"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	grpccreds "google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	petServiceName      = "pet.v1.PetService"
+	healthWatchInterval = time.Second
+	grpcHealthPrefix    = "/grpc.health.v1.Health/"
+	grpcGetPet          = "/" + petServiceName + "/GetPet"
+	grpcListPets        = "/" + petServiceName + "/ListPets"
+	grpcWatchPets       = "/" + petServiceName + "/WatchPets"
+)
+
+var (
+	errNoGrpcTls = errors.New("no TLS configuration for gRPC")
+)
+
+type petService struct {
+	data   store.PetStore
+	broker *events.Broker
+}
+
+func toTimestamp(t time.Time) *timestamp.Timestamp {
+	if ts, err := ptypes.TimestampProto(t); err == nil {
+		return ts
+	}
+	return nil
+}
+
+func toProtoPet(pet data.Pet) *petpb.Pet {
+	result := &petpb.Pet{
+		Id:         int64(pet.Id),
+		Name:       pet.Name,
+		Race:       pet.Race,
+		Mod:        pet.Mod,
+		Status:     string(pet.Status),
+		OwnerId:    int64(pet.Owner()),
+		Tags:       pet.Tags,
+		Attributes: pet.Attributes,
+		CreatedAt:  toTimestamp(pet.CreatedAt),
+		UpdatedAt:  toTimestamp(pet.UpdatedAt),
+	}
+	if pet.DeletedAt != nil {
+		result.DeletedAt = toTimestamp(*pet.DeletedAt)
+	}
+	return result
+}
+
+func responseMessage(rErr resperr.ResponseError) string {
+	if len(rErr.Message) != 0 {
+		return strings.Join(rErr.Message, ", ")
+	}
+	return rErr.ErrorStr
+}
+
+// grpcError maps the store and validation errors to the status codes the gRPC clients expect
+func grpcError(err error) error {
+	switch err {
+	case nil:
+		return nil
+	case store.PetNotFound:
+		return status.Error(codes.NotFound, err.Error())
+	case store.IllegalStatus, store.InvalidOwner:
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case context.Canceled:
+		return status.Error(codes.Canceled, err.Error())
+	case context.DeadlineExceeded:
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	}
+	if rErr, ok := err.(resperr.ResponseError); ok {
+		switch rErr.Status() {
+		case http.StatusUnauthorized:
+			return status.Error(codes.Unauthenticated, responseMessage(rErr))
+		case http.StatusForbidden:
+			return status.Error(codes.PermissionDenied, responseMessage(rErr))
+		}
+		return status.Error(codes.InvalidArgument, responseMessage(rErr))
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+func (s petService) dataFor(ctx context.Context) store.PetStore {
+	return s.data.WithContext(ctx)
+}
+
+func (s petService) getPet(ctx context.Context, id int) (*petpb.Pet, error) {
+	pet, err := s.dataFor(ctx).GetPet(id)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return toProtoPet(pet), nil
+}
+
+func (s petService) CreatePet(ctx context.Context, req *petpb.CreatePetRequest) (*petpb.Pet, error) {
+	var id = 0
+	var err = validPet(data.Pet{Name: req.Name, Race: req.Race, Mod: req.Mod})
+	if err == nil {
+		id, err = s.dataFor(ctx).AddPet(req.Name, req.Race, req.Mod)
+	}
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return s.getPet(ctx, id)
+}
+
+func (s petService) GetPet(ctx context.Context, req *petpb.GetPetRequest) (*petpb.Pet, error) {
+	return s.getPet(ctx, int(req.Id))
+}
+
+func (s petService) ListPets(req *petpb.ListPetsRequest, stream petpb.PetService_ListPetsServer) error {
+	if err := allowDeleted(stream.Context(), req.IncludeDeleted); err != nil {
+		return grpcError(err)
+	}
+	query := store.PetQuery{
+		OwnerId:        int(req.OwnerId),
+		Tags:           data.SortedTags(req.Tags),
+		Attributes:     req.Attributes,
+		IncludeDeleted: req.IncludeDeleted,
+	}
+	for _, id := range req.Ids {
+		query.Ids = append(query.Ids, int(id))
+	}
+
+	pets, err := s.dataFor(stream.Context()).FindPets(query)
+	for i := 0; err == nil && i < len(pets); i++ {
+		err = stream.Send(toProtoPet(pets[i]))
+	}
+	return grpcError(err)
+}
+
+func (s petService) UpdatePet(ctx context.Context, req *petpb.UpdatePetRequest) (*petpb.Pet, error) {
+	var err = validPet(data.Pet{Name: req.Name, Race: req.Race, Mod: req.Mod})
+	if err == nil {
+		_, err = s.dataFor(ctx).UpdatePet(int(req.Id), req.Name, req.Race, req.Mod)
+	}
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return s.getPet(ctx, int(req.Id))
+}
+
+func (s petService) DeletePet(ctx context.Context, req *petpb.DeletePetRequest) (*empty.Empty, error) {
+	if err := s.dataFor(ctx).DeletePet(int(req.Id)); err != nil {
+		return nil, grpcError(err)
+	}
+	return &empty.Empty{}, nil
+}
+
+func toProtoEvent(event events.Event) *petpb.PetEvent {
+	return &petpb.PetEvent{Id: event.Id, Type: string(event.Type), PetId: int64(event.PetId)}
+}
+
+func (s petService) WatchPets(req *petpb.WatchPetsRequest, stream petpb.PetService_WatchPetsServer) error {
+	ps := s.dataFor(stream.Context())
+	sub := s.broker.Subscribe(req.LastEventId)
+	defer sub.Close()
+
+	for _, event := range sub.Replay {
+		if !canSend(ps, event) {
+			continue
+		}
+		if err := stream.Send(toProtoEvent(event)); err != nil {
+			return err
+		}
+	}
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return grpcError(stream.Context().Err())
+		case event, ok := <-sub.Events:
+			if !ok {
+				return nil
+			}
+			if !canSend(ps, event) {
+				continue
+			}
+			if err := stream.Send(toProtoEvent(event)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// healthService implements the standard gRPC health checking protocol on top of the store readiness, for the
+// whole server and for the pet service.
+type healthService struct {
+	ps       store.PetStore
+	interval time.Duration
+	done     chan struct{}
+	once     sync.Once
+}
+
+func (h *healthService) status(service string) (grpc_health_v1.HealthCheckResponse_ServingStatus, error) {
+	if service != "" && service != petServiceName {
+		return grpc_health_v1.HealthCheckResponse_SERVICE_UNKNOWN, status.Error(codes.NotFound, "unknown service")
+	}
+	select {
+	case <-h.done:
+		return grpc_health_v1.HealthCheckResponse_NOT_SERVING, nil
+	default:
+	}
+	if err := h.ps.IsReady(); err != nil {
+		return grpc_health_v1.HealthCheckResponse_NOT_SERVING, nil
+	}
+	return grpc_health_v1.HealthCheckResponse_SERVING, nil
+}
+
+func (h *healthService) Check(_ context.Context, req *grpc_health_v1.HealthCheckRequest) (*grpc_health_v1.HealthCheckResponse, error) {
+	current, err := h.status(req.Service)
+	if err != nil {
+		return nil, err
+	}
+	return &grpc_health_v1.HealthCheckResponse{Status: current}, nil
+}
+
+// Watch sends the current status and then any change, unknown services are reported as such instead of failing
+func (h *healthService) Watch(req *grpc_health_v1.HealthCheckRequest, stream grpc_health_v1.Health_WatchServer) error {
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+
+	last := grpc_health_v1.HealthCheckResponse_ServingStatus(-1)
+	for {
+		current, _ := h.status(req.Service)
+		if current != last {
+			if err := stream.Send(&grpc_health_v1.HealthCheckResponse{Status: current}); err != nil {
+				return err
+			}
+			last = current
+		}
+		select {
+		case <-stream.Context().Done():
+			return grpcError(stream.Context().Err())
+		case <-h.done:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// shutdown reports the server as not serving and ends the watches, so a graceful stop does not wait for them
+func (h *healthService) shutdown() {
+	h.once.Do(func() {
+		close(h.done)
+	})
+}
+
+// grpcCertSubject gets the subject of the verified client certificate of the peer of a call, empty without one
+func grpcCertSubject(ctx context.Context) string {
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(grpccreds.TLSInfo); ok {
+			return verifiedSubject(&info.State)
+		}
+	}
+	return ""
+}
+
+// grpcContext gets the context of a call with its request id, and the subject of its client certificate as the actor
+func grpcContext(ctx context.Context) context.Context {
+	if subject := grpcCertSubject(ctx); subject != "" {
+		ctx = reqctx.WithActor(ctx, clientCertActor+subject)
+	}
+	id := ""
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(constants.RequestId); len(values) > 0 {
+			id = values[0]
+		}
+	}
+	if id == "" || len(id) > maxRequestIdLength {
+		id = newRequestId()
+	}
+	return reqctx.WithRequestId(ctx, id)
+}
+
+func unaryRequestId(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler) (interface{}, error) {
+	return handler(grpcContext(ctx), req)
+}
+
+type requestIdStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s requestIdStream) Context() context.Context {
+	return s.ctx
+}
+
+func streamRequestId(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo,
+	handler grpc.StreamHandler) error {
+	return handler(srv, requestIdStream{ServerStream: ss, ctx: grpcContext(ss.Context())})
+}
+
+// grpcScope gets the scope needed for a method, the health service is public
+func grpcScope(fullMethod string) string {
+	switch fullMethod {
+	case grpcGetPet, grpcListPets, grpcWatchPets:
+		return data.ScopePetsRead
+	}
+	if strings.HasPrefix(fullMethod, grpcHealthPrefix) {
+		return ""
+	}
+	return data.ScopePetsWrite
+}
+
+func (a *authenticator) grpcAuthorize(ctx context.Context, fullMethod string) (context.Context, error) {
+	scope := grpcScope(fullMethod)
+	if scope == "" {
+		return ctx, nil
+	}
+	creds := credentials{cert: grpcCertSubject(ctx)}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(constants.ApiKey); len(values) > 0 {
+			creds.apiKey = values[0]
+		}
+		if values := md.Get(constants.Authorization); len(values) > 0 {
+			creds.bearer = bearerToken(values[0])
+		}
+	}
+	ctx, err := a.authorize(ctx, creds, scope)
+	return ctx, grpcError(err)
+}
+
+func (a *authenticator) unaryAuth(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := a.grpcAuthorize(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (a *authenticator) streamAuth(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler) error {
+	ctx, err := a.grpcAuthorize(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	return handler(srv, requestIdStream{ServerStream: ss, ctx: ctx})
+}
+
+type grpcServer struct {
+	gs        *grpc.Server
+	addr      string
+	ln        net.Listener
+	health    *healthService
+	broker    *events.Broker
+	tlsConfig *tls.Config
+}
+
+func (g *grpcServer) listen() error {
+	var err error
+	if g.ln == nil {
+		g.ln, err = net.Listen("tcp", g.addr)
+	}
+	return err
+}
+
+// configForClient handshakes with the TLS configuration that is set when listening, sharing the reloaded certificate
+// with the HTTP server
+func (g *grpcServer) configForClient(_ *tls.ClientHelloInfo) (*tls.Config, error) {
+	if g.tlsConfig == nil {
+		return nil, errNoGrpcTls
+	}
+	return g.tlsConfig, nil
+}
+
+// setTls serves gRPC with a copy of the TLS configuration of the HTTP server, negotiating HTTP/2 as gRPC requires
+func (g *grpcServer) setTls(tlsConfig *tls.Config) {
+	g.tlsConfig = tlsConfig.Clone()
+	g.tlsConfig.NextProtos = []string{"h2"}
+}
+
+func (g *grpcServer) serve() error {
+	err := g.gs.Serve(g.ln)
+	if err == grpc.ErrServerStopped {
+		err = nil
+	}
+	return err
+}
+
+// stop ends the streams that would never finish on their own and waits for the pending RPCs until the deadline, then
+// it closes their connections
+func (g *grpcServer) stop(deadline context.Context) error {
+	g.health.shutdown()
+	g.broker.Close()
+	done := make(chan struct{})
+	go func() {
+		g.gs.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-deadline.Done():
+		g.gs.Stop()
+		<-done
+		return deadline.Err()
+	}
+}
+
+// newGrpcServer creates the gRPC server, that is served with TLS when it is secure as the bearer tokens and the API
+// keys should not cross the network in plaintext
+func newGrpcServer(addr string, ps store.PetStore, data store.PetStore, broker *events.Broker,
+	auth *authenticator, secure bool) *grpcServer {
+	unary := []grpc.UnaryServerInterceptor{unaryRequestId}
+	stream := []grpc.StreamServerInterceptor{streamRequestId}
+	if auth != nil {
+		unary = append(unary, auth.unaryAuth)
+		stream = append(stream, auth.streamAuth)
+	}
+	g := &grpcServer{addr: addr, broker: broker}
+	opts := []grpc.ServerOption{grpc.ChainUnaryInterceptor(unary...), grpc.ChainStreamInterceptor(stream...)}
+	if secure {
+		opts = append(opts, grpc.Creds(grpccreds.NewTLS(&tls.Config{GetConfigForClient: g.configForClient})))
+	}
+	g.gs = grpc.NewServer(opts...)
+	g.health = &healthService{ps: ps, interval: healthWatchInterval, done: make(chan struct{})}
+	petpb.RegisterPetServiceServer(g.gs, petService{data: data, broker: broker})
+	grpc_health_v1.RegisterHealthServer(g.gs, g.health)
+	return g
+}
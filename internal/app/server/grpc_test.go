@@ -0,0 +1,436 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/LearningByExample/go-microservice/internal/_test"
+	"github.com/LearningByExample/go-microservice/internal/app/config"
+	"github.com/LearningByExample/go-microservice/internal/app/constants"
+	"github.com/LearningByExample/go-microservice/internal/app/data"
+	"github.com/LearningByExample/go-microservice/internal/app/events"
+	"github.com/LearningByExample/go-microservice/internal/app/petpb"
+	"github.com/LearningByExample/go-microservice/internal/app/policy"
+	"github.com/LearningByExample/go-microservice/internal/app/store"
+	"github.com/LearningByExample/go-microservice/internal/app/store/memory"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/golang/protobuf/ptypes/empty"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func startTestGrpc(t *testing.T, ps store.PetStore, auth *authenticator) (*grpc.ClientConn, *events.Broker, func()) {
+	broker := events.NewBroker(events.DefaultReplaySize)
+	conn, stop := serveTestGrpc(t, ps, events.NewStore(ps, broker.Publish), broker, auth)
+	return conn, broker, stop
+}
+
+func serveTestGrpc(t *testing.T, ps store.PetStore, data store.PetStore, broker *events.Broker,
+	auth *authenticator) (*grpc.ClientConn, func()) {
+	g := newGrpcServer("", ps, data, broker, auth, false)
+	g.health.interval = 10 * time.Millisecond
+	lis := bufconn.Listen(1024 * 1024)
+	go func() {
+		_ = g.gs.Serve(lis)
+	}()
+
+	conn, err := grpc.Dial("bufnet", grpc.WithInsecure(), grpc.WithContextDialer(
+		func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.Dial()
+		}))
+	if err != nil {
+		t.Fatalf("error dialing: %v", err)
+	}
+	return conn, func() {
+		_ = conn.Close()
+		_ = g.stop(context.Background())
+	}
+}
+
+func codeOf(err error) codes.Code {
+	return status.Code(err)
+}
+
+func TestGrpcPetService(t *testing.T) {
+	ps := memory.NewInMemoryPetStore(config.CfgData{})
+	conn, _, stop := startTestGrpc(t, ps, nil)
+	defer stop()
+	client := petpb.NewPetServiceClient(conn)
+	ctx := context.Background()
+
+	t.Run("should create and get a pet", func(t *testing.T) {
+		created, err := client.CreatePet(ctx, &petpb.CreatePetRequest{Name: "Fluff", Race: "Dog", Mod: "Happy"})
+		if err != nil || created.Id == 0 || created.Name != "Fluff" || created.CreatedAt == nil {
+			t.Fatalf("got %v and %v, want a created pet", created, err)
+		}
+
+		got, err := client.GetPet(ctx, &petpb.GetPetRequest{Id: created.Id})
+		if err != nil || got.Id != created.Id || got.Race != "Dog" || got.Status != "available" {
+			t.Fatalf("got %v and %v, want %v", got, err, created)
+		}
+	})
+
+	t.Run("should fail creating an invalid pet", func(t *testing.T) {
+		_, err := client.CreatePet(ctx, &petpb.CreatePetRequest{Name: "Fluff"})
+		if got := codeOf(err); got != codes.InvalidArgument {
+			t.Fatalf("got %v, want %v", got, codes.InvalidArgument)
+		}
+	})
+
+	t.Run("should map not found pets", func(t *testing.T) {
+		_, err := client.GetPet(ctx, &petpb.GetPetRequest{Id: 999})
+		if got := codeOf(err); got != codes.NotFound {
+			t.Fatalf("got %v, want %v", got, codes.NotFound)
+		}
+		_, err = client.UpdatePet(ctx, &petpb.UpdatePetRequest{Id: 999, Name: "a", Race: "b", Mod: "c"})
+		if got := codeOf(err); got != codes.NotFound {
+			t.Fatalf("got %v, want %v", got, codes.NotFound)
+		}
+		_, err = client.DeletePet(ctx, &petpb.DeletePetRequest{Id: 999})
+		if got := codeOf(err); got != codes.NotFound {
+			t.Fatalf("got %v, want %v", got, codes.NotFound)
+		}
+	})
+
+	t.Run("should update a pet", func(t *testing.T) {
+		got, err := client.UpdatePet(ctx, &petpb.UpdatePetRequest{Id: 1, Name: "Fluffy", Race: "Dog", Mod: "Calm"})
+		if err != nil || got.Name != "Fluffy" || got.Mod != "Calm" {
+			t.Fatalf("got %v and %v, want an updated pet", got, err)
+		}
+	})
+
+	t.Run("should stream the pets", func(t *testing.T) {
+		_, _ = client.CreatePet(ctx, &petpb.CreatePetRequest{Name: "Whiskers", Race: "Cat", Mod: "Sleepy"})
+		stream, err := client.ListPets(ctx, &petpb.ListPetsRequest{})
+		if err != nil {
+			t.Fatalf("error listing: %v", err)
+		}
+		names := make([]string, 0)
+		for {
+			pet, err := stream.Recv()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("error receiving: %v", err)
+			}
+			names = append(names, pet.Name)
+		}
+		if len(names) != 2 || names[0] != "Fluffy" || names[1] != "Whiskers" {
+			t.Fatalf("got %v, want [Fluffy Whiskers]", names)
+		}
+	})
+
+	t.Run("should delete a pet", func(t *testing.T) {
+		if _, err := client.DeletePet(ctx, &petpb.DeletePetRequest{Id: 2}); err != nil {
+			t.Fatalf("error deleting: %v", err)
+		}
+		_, err := client.GetPet(ctx, &petpb.GetPetRequest{Id: 2})
+		if got := codeOf(err); got != codes.NotFound {
+			t.Fatalf("got %v, want %v", got, codes.NotFound)
+		}
+	})
+}
+
+func TestGrpcWatchPets(t *testing.T) {
+	ps := memory.NewInMemoryPetStore(config.CfgData{})
+	conn, broker, stop := startTestGrpc(t, ps, nil)
+	defer stop()
+	client := petpb.NewPetServiceClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	broker.Publish(events.Created, 1)
+	first := broker.LastId()
+	stream, err := client.WatchPets(ctx, &petpb.WatchPetsRequest{LastEventId: 0})
+	if err != nil {
+		t.Fatalf("error watching: %v", err)
+	}
+	// the stream is open once the replay, empty here, has been subscribed
+	time.Sleep(50 * time.Millisecond)
+	if _, err := client.CreatePet(ctx, &petpb.CreatePetRequest{Name: "Fluff", Race: "Dog", Mod: "Happy"}); err != nil {
+		t.Fatalf("error creating: %v", err)
+	}
+
+	event, err := stream.Recv()
+	if err != nil || event.Id != first+1 || event.Type != string(events.Created) || event.PetId != 1 {
+		t.Fatalf("got %v and %v, want created event %d for pet 1", event, err, first+1)
+	}
+
+	t.Run("should replay the events after the last id", func(t *testing.T) {
+		replay, err := client.WatchPets(ctx, &petpb.WatchPetsRequest{LastEventId: first})
+		if err != nil {
+			t.Fatalf("error watching: %v", err)
+		}
+		event, err := replay.Recv()
+		if err != nil || event.Id != first+1 {
+			t.Fatalf("got %v and %v, want event %d", event, err, first+1)
+		}
+	})
+
+	t.Run("should resync with an unknown last id", func(t *testing.T) {
+		replay, err := client.WatchPets(ctx, &petpb.WatchPetsRequest{LastEventId: 1})
+		if err != nil {
+			t.Fatalf("error watching: %v", err)
+		}
+		event, err := replay.Recv()
+		if err != nil || event.Id != first+1 || event.Type != string(events.Resync) {
+			t.Fatalf("got %v and %v, want resync event %d", event, err, first+1)
+		}
+	})
+
+	t.Run("should end the watch when the broker closes", func(t *testing.T) {
+		broker.Close()
+		if _, err := stream.Recv(); err != io.EOF {
+			t.Fatalf("got %v, want %v", err, io.EOF)
+		}
+	})
+}
+
+func TestGrpcWatchPetsWithPolicy(t *testing.T) {
+	base := memory.NewInMemoryPetStore(config.CfgData{})
+	id, _ := base.AddPet("Fluff", "dog", "happy")
+	pol, err := policy.Parse([]byte(`{"roles": {"staff": [{"actions": ["read"]}]}}`))
+	if err != nil {
+		t.Fatalf("want not error, got %v", err)
+	}
+	broker := events.NewBroker(events.DefaultReplaySize)
+	conn, stop := serveTestGrpc(t, base, policy.NewStore(base, pol), broker, nil)
+	defer stop()
+	client := petpb.NewPetServiceClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	broker.Publish(events.Created, id)
+	stream, err := client.WatchPets(ctx, &petpb.WatchPetsRequest{LastEventId: broker.LastId() - 1})
+	if err != nil {
+		t.Fatalf("error watching: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	broker.Publish(events.Updated, id)
+	broker.Close()
+
+	if event, err := stream.Recv(); err != io.EOF {
+		t.Fatalf("got %v and %v, want no events for a caller that cannot read the pet", event, err)
+	}
+}
+
+func TestGrpcHealth(t *testing.T) {
+	st := _test.NewSpyStore()
+	conn, _, stop := startTestGrpc(t, &st, nil)
+	defer stop()
+	client := grpc_health_v1.NewHealthClient(conn)
+	ctx := context.Background()
+
+	t.Run("should be serving when the store is ready", func(t *testing.T) {
+		st.Reset()
+		for _, service := range []string{"", petServiceName} {
+			got, err := client.Check(ctx, &grpc_health_v1.HealthCheckRequest{Service: service})
+			if err != nil || got.Status != grpc_health_v1.HealthCheckResponse_SERVING {
+				t.Fatalf("got %v and %v, want %v", got, err, grpc_health_v1.HealthCheckResponse_SERVING)
+			}
+		}
+		if !st.IsReadyWasCall {
+			t.Fatal("is ready was not called")
+		}
+	})
+
+	t.Run("should not be serving when the store is not ready", func(t *testing.T) {
+		st.Reset()
+		st.WhenIsReady(func() error {
+			return errors.New("not ready")
+		})
+		got, err := client.Check(ctx, &grpc_health_v1.HealthCheckRequest{})
+		if err != nil || got.Status != grpc_health_v1.HealthCheckResponse_NOT_SERVING {
+			t.Fatalf("got %v and %v, want %v", got, err, grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+		}
+	})
+
+	t.Run("should not find unknown services", func(t *testing.T) {
+		_, err := client.Check(ctx, &grpc_health_v1.HealthCheckRequest{Service: "unknown"})
+		if got := codeOf(err); got != codes.NotFound {
+			t.Fatalf("got %v, want %v", got, codes.NotFound)
+		}
+	})
+}
+
+type toggleReadyStore struct {
+	store.PetStore
+	notReady int32
+}
+
+func (s *toggleReadyStore) IsReady() error {
+	if atomic.LoadInt32(&s.notReady) != 0 {
+		return errors.New("not ready")
+	}
+	return nil
+}
+
+func TestGrpcHealthWatch(t *testing.T) {
+	ps := &toggleReadyStore{PetStore: memory.NewInMemoryPetStore(config.CfgData{})}
+	conn, _, stop := startTestGrpc(t, ps, nil)
+	defer stop()
+	client := grpc_health_v1.NewHealthClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, err := client.Watch(ctx, &grpc_health_v1.HealthCheckRequest{})
+	if err != nil {
+		t.Fatalf("error watching: %v", err)
+	}
+	if got, err := stream.Recv(); err != nil || got.Status != grpc_health_v1.HealthCheckResponse_SERVING {
+		t.Fatalf("got %v and %v, want %v", got, err, grpc_health_v1.HealthCheckResponse_SERVING)
+	}
+
+	atomic.StoreInt32(&ps.notReady, 1)
+	if got, err := stream.Recv(); err != nil || got.Status != grpc_health_v1.HealthCheckResponse_NOT_SERVING {
+		t.Fatalf("got %v and %v, want %v", got, err, grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+	}
+}
+
+func TestGrpcStop(t *testing.T) {
+	ps := memory.NewInMemoryPetStore(config.CfgData{})
+	broker := events.NewBroker(events.DefaultReplaySize)
+	g := newGrpcServer("", ps, events.NewStore(ps, broker.Publish), broker, nil, false)
+	opened := make(chan struct{})
+	g.gs.RegisterService(&grpc.ServiceDesc{
+		ServiceName: "test.Blocking",
+		HandlerType: (*interface{})(nil),
+		Streams: []grpc.StreamDesc{{StreamName: "Block", ServerStreams: true,
+			Handler: func(_ interface{}, stream grpc.ServerStream) error {
+				close(opened)
+				<-stream.Context().Done()
+				return nil
+			}}},
+	}, struct{}{})
+	lis := bufconn.Listen(1024 * 1024)
+	go func() {
+		_ = g.gs.Serve(lis)
+	}()
+	conn, err := grpc.Dial("bufnet", grpc.WithInsecure(), grpc.WithContextDialer(
+		func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.Dial()
+		}))
+	if err != nil {
+		t.Fatalf("error dialing: %v", err)
+	}
+	defer conn.Close()
+	stream, err := conn.NewStream(context.Background(), &grpc.StreamDesc{ServerStreams: true}, "/test.Blocking/Block")
+	if err != nil {
+		t.Fatalf("error opening stream: %v", err)
+	}
+	_ = stream.CloseSend()
+	<-opened
+
+	deadline, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := g.stop(deadline); err != context.DeadlineExceeded {
+		t.Fatalf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+	if err := stream.RecvMsg(&empty.Empty{}); codeOf(err) != codes.Unavailable {
+		t.Fatalf("got %v, want the stream closed", err)
+	}
+}
+
+func TestGrpcAuth(t *testing.T) {
+	ps := memory.NewInMemoryPetStore(config.CfgData{})
+	reader, prefix, hash, _ := newApiKey()
+	_, _ = ps.(store.ApiKeyStore).AddApiKey("reader", prefix, hash, []string{data.ScopePetsRead})
+	keys := _test.NewTokenKeys()
+	auth, _ := newAuthenticator(config.AuthCfg{Enabled: true, Jwt: config.JwtCfg{Jwks: keys.Jwks(), RolesClaim: "roles",
+		Roles: map[string][]string{"editor": {data.ScopePetsWrite}}}}, ps)
+	conn, _, stop := startTestGrpc(t, ps, auth)
+	defer stop()
+	client := petpb.NewPetServiceClient(conn)
+	withKey := func(key string) context.Context {
+		return metadata.AppendToOutgoingContext(context.Background(), constants.ApiKey, key)
+	}
+
+	t.Run("should reject calls without key", func(t *testing.T) {
+		_, err := client.GetPet(context.Background(), &petpb.GetPetRequest{Id: 1})
+		if got := codeOf(err); got != codes.Unauthenticated {
+			t.Fatalf("got %v, want %v", got, codes.Unauthenticated)
+		}
+	})
+
+	t.Run("should allow reading with the read scope", func(t *testing.T) {
+		_, err := client.GetPet(withKey(reader), &petpb.GetPetRequest{Id: 1})
+		if got := codeOf(err); got != codes.NotFound {
+			t.Fatalf("got %v, want %v", got, codes.NotFound)
+		}
+	})
+
+	t.Run("should forbid writing with the read scope", func(t *testing.T) {
+		_, err := client.CreatePet(withKey(reader), &petpb.CreatePetRequest{Name: "Fluff", Race: "Dog", Mod: "Happy"})
+		if got := codeOf(err); got != codes.PermissionDenied {
+			t.Fatalf("got %v, want %v", got, codes.PermissionDenied)
+		}
+	})
+
+	t.Run("should allow writing with a bearer token", func(t *testing.T) {
+		bearer := keys.Sign("HS256", "hmac", jwt.MapClaims{"sub": "john", "roles": "editor",
+			"exp": time.Now().Add(time.Hour).Unix()})
+		ctx := metadata.AppendToOutgoingContext(context.Background(), constants.Authorization, "Bearer "+bearer)
+		if _, err := client.CreatePet(ctx, &petpb.CreatePetRequest{Name: "Fluff", Race: "Dog", Mod: "Happy"}); err != nil {
+			t.Fatalf("want not error, got %v", err)
+		}
+	})
+
+	t.Run("should forbid listing deleted pets with the read scope", func(t *testing.T) {
+		stream, err := client.ListPets(withKey(reader), &petpb.ListPetsRequest{IncludeDeleted: true})
+		if err == nil {
+			_, err = stream.Recv()
+		}
+		if got := codeOf(err); got != codes.PermissionDenied {
+			t.Fatalf("got %v, want %v", got, codes.PermissionDenied)
+		}
+	})
+
+	t.Run("should reject streams without key", func(t *testing.T) {
+		stream, err := client.ListPets(context.Background(), &petpb.ListPetsRequest{})
+		if err == nil {
+			_, err = stream.Recv()
+		}
+		if got := codeOf(err); got != codes.Unauthenticated {
+			t.Fatalf("got %v, want %v", got, codes.Unauthenticated)
+		}
+	})
+
+	t.Run("should keep health public", func(t *testing.T) {
+		health := grpc_health_v1.NewHealthClient(conn)
+		if _, err := health.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{}); err != nil {
+			t.Fatalf("want not error, got %v", err)
+		}
+	})
+}
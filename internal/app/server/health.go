@@ -23,34 +23,40 @@
 package server
 
 import (
+	"context"
+	"encoding/json"
+	"github.com/LearningByExample/go-microservice/internal/app/config"
+	"github.com/LearningByExample/go-microservice/internal/app/constants"
+	"github.com/LearningByExample/go-microservice/internal/app/health"
 	"github.com/LearningByExample/go-microservice/internal/app/resperr"
 	"github.com/LearningByExample/go-microservice/internal/app/store"
 	"log"
 	"net/http"
+	"path/filepath"
+	"time"
 )
 
 type healthHandler struct {
-	ps store.PetStore
+	checker  *health.Checker
+	started  func() bool
+	draining func() bool
+}
+
+type healthStatus struct {
+	Status string `json:"status"`
 }
 
 const (
 	readinessUrl = "/health/readiness"
 	livenessUrl  = "/health/liveness"
+	startupUrl   = "/health/startup"
 )
 
 func (h healthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	var rErr = resperr.None
 
-	switch r.URL.Path {
-	case livenessUrl:
-		break
-	case readinessUrl:
-		if err := h.isReady(); err != nil {
-			rErr = resperr.FromError(err)
-		}
-		break
-	default:
-		rErr = resperr.NotFound
+	if err := h.serve(w, r); err != nil {
+		rErr = resperr.FromError(err)
 	}
 
 	if rErr.Status() != http.StatusOK {
@@ -62,11 +68,81 @@ func (h healthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (h healthHandler) isReady() error {
-	return h.ps.IsReady()
+func (h healthHandler) serve(w http.ResponseWriter, r *http.Request) error {
+	switch r.URL.Path {
+	case livenessUrl:
+		return writeHealth(w, healthStatus{Status: health.Up}, false)
+	case readinessUrl:
+		if h.draining() {
+			report := health.Report{Status: health.Down, CheckedAt: time.Now(), Checks: make([]health.Result, 0)}
+			return writeHealth(w, report, true)
+		}
+		// the checks are not bound to the request, a probe that gives up should not cache a failing report
+		report := h.checker.Check(context.Background())
+		if err := report.Err(); err != nil {
+			log.Printf("Error %v in %s request %q", err, r.Method, r.URL.Path)
+		}
+		return writeHealth(w, report, report.Status == health.Down)
+	case startupUrl:
+		if !h.started() {
+			return writeHealth(w, healthStatus{Status: health.Down}, true)
+		}
+		return writeHealth(w, healthStatus{Status: health.Up}, false)
+	}
+	return resperr.NotFound
 }
 
-func NewHealthHandler(ps store.PetStore) http.Handler {
-	h := healthHandler{ps: ps}
+// writeHealth writes a health body, with a service unavailable status when it is down so probes fail
+func writeHealth(w http.ResponseWriter, value interface{}, down bool) error {
+	w.Header().Add(constants.ContentType, constants.ApplicationJsonUtf8)
+	if down {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		return resperr.WrittenJson
+	}
+	return nil
+}
+
+// newChecker registers the checks of the components the store and the configuration have
+func newChecker(cfg config.CfgData, ps store.PetStore) *health.Checker {
+	checker := health.NewChecker(time.Duration(cfg.Health.CacheTtl) * time.Millisecond)
+	timeout := time.Duration(cfg.Health.Timeout) * time.Millisecond
+	minFree := uint64(cfg.Health.MinFreeDisk) << 20
+
+	checker.Register(health.Store(ps, timeout))
+	if monitor, ok := ps.(store.PoolMonitor); ok {
+		checker.Register(health.Pool(monitor, cfg.Health.MaxPoolSaturation, timeout))
+	}
+	if schema, ok := ps.(store.SchemaStore); ok {
+		checker.Register(health.Schema(schema, timeout))
+	}
+	if cfg.Photos.IsEnabled() {
+		checker.Register(health.Disk("photos-disk", cfg.Photos.Path, minFree, timeout))
+	}
+	if cfg.Store.Outbox.Publisher == config.FilePublisher {
+		checker.Register(health.Disk("outbox-disk", filepath.Dir(cfg.Store.Outbox.Path), minFree, timeout))
+	}
+	if ws, ok := ps.(store.WebhookStore); ok && cfg.Webhooks.IsEnabled() {
+		checker.Register(health.Backlog(ws, cfg.Health.MaxWebhookBacklog, timeout))
+	}
+	return checker
+}
+
+func newHealthHandler(checker *health.Checker, started func() bool, draining func() bool) http.Handler {
+	h := healthHandler{checker: checker, started: started, draining: draining}
 	return &h
 }
+
+// NewHealthHandler gets a handler that is ready when the store is, without caching, always started and never draining
+func NewHealthHandler(ps store.PetStore) http.Handler {
+	checker := health.NewChecker(0)
+	checker.Register(health.Store(ps, time.Duration(0)))
+	return newHealthHandler(checker, func() bool {
+		return true
+	}, func() bool {
+		return false
+	})
+}
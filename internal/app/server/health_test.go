@@ -23,16 +23,34 @@
 package server
 
 import (
+	"encoding/json"
 	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
 	"github.com/LearningByExample/go-microservice/internal/_test"
+	"github.com/LearningByExample/go-microservice/internal/app/config"
+	"github.com/LearningByExample/go-microservice/internal/app/health"
 	"github.com/LearningByExample/go-microservice/internal/app/resperr"
-	"testing"
 )
 
 var (
 	mockError = errors.New("mock error")
 )
 
+func assertHealth(t *testing.T, response *httptest.ResponseRecorder, code int, status string) health.Report {
+	t.Helper()
+	var report health.Report
+	if err := json.NewDecoder(response.Body).Decode(&report); err != nil {
+		t.Fatalf("got %v decoding %q", err, response.Body.String())
+	}
+	if response.Code != code || report.Status != status {
+		t.Fatalf("got %d and %q, want %d and %q", response.Code, report.Status, code, status)
+	}
+	return report
+}
+
 func Test_healthHandler(t *testing.T) {
 	spyStore := _test.NewSpyStore()
 	h := NewHealthHandler(&spyStore)
@@ -44,7 +62,10 @@ func Test_healthHandler(t *testing.T) {
 		})
 		request := _test.GetRequest(h, readinessUrl)
 
-		_test.AssertResponseError(t, request, resperr.None)
+		report := assertHealth(t, request, http.StatusOK, health.Up)
+		if len(report.Checks) != 1 || report.Checks[0].Name != "store" || report.Checks[0].Status != health.Up {
+			t.Fatalf("got %v, want the store check", report.Checks)
+		}
 		got := spyStore.IsReadyWasCall
 		want := true
 		if got != want {
@@ -59,7 +80,10 @@ func Test_healthHandler(t *testing.T) {
 		})
 		request := _test.GetRequest(h, readinessUrl)
 
-		_test.AssertResponseError(t, request, resperr.FromError(mockError))
+		report := assertHealth(t, request, http.StatusServiceUnavailable, health.Down)
+		if report.Checks[0].Error != mockError.Error() {
+			t.Fatalf("got %v, want error %v", report.Checks[0], mockError)
+		}
 		got := spyStore.IsReadyWasCall
 		want := true
 		if got != want {
@@ -70,7 +94,7 @@ func Test_healthHandler(t *testing.T) {
 	t.Run("liveness should work", func(t *testing.T) {
 		request := _test.GetRequest(h, livenessUrl)
 
-		_test.AssertResponseError(t, request, resperr.None)
+		assertHealth(t, request, http.StatusOK, health.Up)
 	})
 
 	t.Run("invalid url should fail", func(t *testing.T) {
@@ -80,3 +104,59 @@ func Test_healthHandler(t *testing.T) {
 	})
 
 }
+
+func never() bool {
+	return false
+}
+
+func Test_healthHandlerStartup(t *testing.T) {
+	started := false
+	h := newHealthHandler(health.NewChecker(0), func() bool {
+		return started
+	}, never)
+
+	assertHealth(t, _test.GetRequest(h, startupUrl), http.StatusServiceUnavailable, health.Down)
+	started = true
+	assertHealth(t, _test.GetRequest(h, startupUrl), http.StatusOK, health.Up)
+}
+
+func Test_healthHandlerDraining(t *testing.T) {
+	spyStore := _test.NewSpyStore()
+	draining := false
+	h := newHealthHandler(newChecker(config.CfgData{}, &spyStore), func() bool {
+		return true
+	}, func() bool {
+		return draining
+	})
+
+	assertHealth(t, _test.GetRequest(h, readinessUrl), http.StatusOK, health.Up)
+	draining = true
+	spyStore.Reset()
+	assertHealth(t, _test.GetRequest(h, readinessUrl), http.StatusServiceUnavailable, health.Down)
+	if spyStore.IsReadyWasCall {
+		t.Fatal("want checks not run while draining")
+	}
+	assertHealth(t, _test.GetRequest(h, livenessUrl), http.StatusOK, health.Up)
+}
+
+func Test_healthHandlerDegraded(t *testing.T) {
+	spyStore := _test.NewSpyStore()
+	spyStore.WhenPendingDeliveries(func() (int, error) {
+		return 11, nil
+	})
+	cfg := config.CfgData{
+		Webhooks: config.WebhooksCfg{Interval: 1000},
+		Health:   config.HealthCfg{MaxWebhookBacklog: 10},
+	}
+	h := newHealthHandler(newChecker(cfg, &spyStore), func() bool {
+		return true
+	}, never)
+
+	report := assertHealth(t, _test.GetRequest(h, readinessUrl), http.StatusOK, health.Degraded)
+	if len(report.Checks) != 2 || report.Checks[1].Name != "webhooks" || report.Checks[1].Status != health.Down {
+		t.Fatalf("got %v, want the webhooks check down", report.Checks)
+	}
+	if err := report.Err(); err != nil {
+		t.Fatalf("want not error, got %v", err)
+	}
+}
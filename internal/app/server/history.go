@@ -0,0 +1,93 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package server
+
+import (
+	"encoding/json"
+	"github.com/LearningByExample/go-microservice/internal/app/constants"
+	"github.com/LearningByExample/go-microservice/internal/app/data"
+	"github.com/LearningByExample/go-microservice/internal/app/resperr"
+	"github.com/LearningByExample/go-microservice/internal/app/store"
+	"net/http"
+	"strconv"
+)
+
+const (
+	historyResource     = "history"
+	offsetParam         = "offset"
+	limitParam          = "limit"
+	defaultHistoryLimit = 20
+	maxHistoryLimit     = 100
+)
+
+type historyPage struct {
+	Total  int              `json:"total"`
+	Offset int              `json:"offset"`
+	Limit  int              `json:"limit"`
+	Items  []data.PetChange `json:"items"`
+}
+
+func queryInt(r *http.Request, name string, defaultValue int, min int, max int) (int, error) {
+	if value := r.URL.Query().Get(name); value != "" {
+		if i, err := strconv.Atoi(value); err == nil && i >= min && i <= max {
+			return i, nil
+		}
+		return 0, resperr.InvalidUrl
+	}
+	return defaultValue, nil
+}
+
+func pageParams(r *http.Request, defaultLimit int, maxLimit int) (int, int, error) {
+	offset, err := queryInt(r, offsetParam, 0, 0, int(^uint(0)>>1))
+	if err != nil {
+		return 0, 0, err
+	}
+	limit, err := queryInt(r, limitParam, defaultLimit, 1, maxLimit)
+	if err != nil {
+		return 0, 0, err
+	}
+	return offset, limit, nil
+}
+
+func (s petHandler) historyPetRequest(w http.ResponseWriter, r *http.Request, id int) error {
+	offset, limit, err := pageParams(r, defaultHistoryLimit, maxHistoryLimit)
+	if err != nil {
+		return err
+	}
+
+	changes, total, err := s.dataFor(r).PetHistory(id, offset, limit)
+	if err == store.PetNotFound {
+		return resperr.NotFound
+	} else if err != nil {
+		return err
+	}
+
+	w.Header().Add(constants.ContentType, constants.ApplicationJsonUtf8)
+	w.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(w)
+	page := historyPage{Total: total, Offset: offset, Limit: limit, Items: changes}
+	if err := encoder.Encode(page); err != nil {
+		return resperr.WrittenJson
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/LearningByExample/go-microservice/internal/_test"
+	"github.com/LearningByExample/go-microservice/internal/app/data"
+	"github.com/LearningByExample/go-microservice/internal/app/resperr"
+	"github.com/LearningByExample/go-microservice/internal/app/store"
+)
+
+func TestHistoryPetRequest(t *testing.T) {
+	spyStore := _test.NewSpyStore()
+	handler := NewPetHandler(&spyStore)
+	changes := []data.PetChange{
+		{
+			Id:        1,
+			PetId:     3,
+			Action:    data.CreateAction,
+			Timestamp: time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC),
+			Actor:     "john",
+			After:     &data.Pet{Id: 3, Name: "Fluff", Race: "dog", Mod: "happy"},
+		},
+	}
+
+	type testCase struct {
+		name       string
+		path       string
+		history    func(id int, offset int, limit int) ([]data.PetChange, int, error)
+		want       resperr.ResponseError
+		called     bool
+		wantOffset int
+		wantLimit  int
+	}
+
+	var cases = []testCase{
+		{
+			name: "should get history",
+			path: "/pets/3/history",
+			history: func(id int, offset int, limit int) ([]data.PetChange, int, error) {
+				return changes, 1, nil
+			},
+			want:       resperr.None,
+			called:     true,
+			wantOffset: 0,
+			wantLimit:  defaultHistoryLimit,
+		},
+		{
+			name:       "should get history page",
+			path:       "/pets/3/history?offset=10&limit=5",
+			want:       resperr.None,
+			called:     true,
+			wantOffset: 10,
+			wantLimit:  5,
+		},
+		{
+			name: "should not found",
+			path: "/pets/3/history",
+			history: func(id int, offset int, limit int) ([]data.PetChange, int, error) {
+				return nil, 0, store.PetNotFound
+			},
+			want:   resperr.NotFound,
+			called: true,
+		},
+		{
+			name: "should fail on store error",
+			path: "/pets/3/history",
+			history: func(id int, offset int, limit int) ([]data.PetChange, int, error) {
+				return nil, 0, mockError
+			},
+			want:   resperr.FromError(mockError),
+			called: true,
+		},
+		{
+			name:   "should fail with invalid limit",
+			path:   "/pets/3/history?limit=1000",
+			want:   resperr.InvalidUrl,
+			called: false,
+		},
+		{
+			name:   "should fail with invalid offset",
+			path:   "/pets/3/history?offset=-1",
+			want:   resperr.InvalidUrl,
+			called: false,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			spyStore.Reset()
+			if tt.history != nil {
+				spyStore.WhenPetHistory(tt.history)
+			}
+
+			response := _test.GetRequest(handler, tt.path)
+
+			_test.AssertResponseError(t, response, tt.want)
+			if spyStore.HistoryWasCall != tt.called {
+				t.Fatalf("history was call got %t, want %t", spyStore.HistoryWasCall, tt.called)
+			}
+			if tt.want.Status() == http.StatusOK {
+				if spyStore.Id != 3 || spyStore.Offset != tt.wantOffset || spyStore.Limit != tt.wantLimit {
+					t.Fatalf("got id %d, offset %d, limit %d, want 3, %d, %d",
+						spyStore.Id, spyStore.Offset, spyStore.Limit, tt.wantOffset, tt.wantLimit)
+				}
+				got := historyPage{}
+				if err := json.NewDecoder(response.Body).Decode(&got); err != nil {
+					t.Fatalf("error decoding history, got %v", err)
+				}
+				if tt.history != nil && !reflect.DeepEqual(got.Items, changes) {
+					t.Fatalf("got %v, want %v", got.Items, changes)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	listenPidEnv     = "LISTEN_PID"
+	listenFdsEnv     = "LISTEN_FDS"
+	listenFdNamesEnv = "LISTEN_FDNAMES"
+)
+
+var (
+	// activationFdStart is the first file descriptor passed by systemd, the ones before are stdin, stdout and stderr
+	activationFdStart = 3
+	errNoActivation   = errors.New("no sockets passed by socket activation")
+	errNoListeners    = errors.New("no listeners configured")
+)
+
+// activationListeners gets the listeners of the sockets that systemd passed to this process, unsetting the variables
+// so the child processes do not take them as their own
+func activationListeners() ([]net.Listener, error) {
+	pid, _ := strconv.Atoi(os.Getenv(listenPidEnv))
+	count, _ := strconv.Atoi(os.Getenv(listenFdsEnv))
+	names := strings.Split(os.Getenv(listenFdNamesEnv), ":")
+	_ = os.Unsetenv(listenPidEnv)
+	_ = os.Unsetenv(listenFdsEnv)
+	_ = os.Unsetenv(listenFdNamesEnv)
+	if pid != os.Getpid() || count <= 0 {
+		return nil, errNoActivation
+	}
+
+	listeners := make([]net.Listener, 0, count)
+	for i := 0; i < count; i++ {
+		name := fmt.Sprintf("LISTEN_FD_%d", activationFdStart+i)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		file := os.NewFile(uintptr(activationFdStart+i), name)
+		ln, err := net.FileListener(file)
+		_ = file.Close()
+		if err != nil {
+			for _, opened := range listeners {
+				_ = opened.Close()
+			}
+			return nil, fmt.Errorf("socket %q: %v", name, err)
+		}
+		listeners = append(listeners, ln)
+	}
+	return listeners, nil
+}
+
+// listenUnix listens in a Unix socket with the given permissions, removing the socket file left by a previous run
+// that did not close it
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		_ = os.Remove(path)
+	}
+	ln, err := net.Listen("unix", path)
+	if err == nil {
+		if err = os.Chmod(path, mode); err != nil {
+			_ = ln.Close()
+			ln = nil
+		}
+	}
+	return ln, err
+}
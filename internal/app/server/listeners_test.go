@@ -0,0 +1,203 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/LearningByExample/go-microservice/internal/_test"
+	"github.com/LearningByExample/go-microservice/internal/app/config"
+)
+
+func tempSocket(t *testing.T) (string, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "socket")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	return filepath.Join(dir, "http.sock"), func() {
+		_ = os.RemoveAll(dir)
+	}
+}
+
+func unixClient(path string) http.Client {
+	return http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", path)
+			},
+		},
+	}
+}
+
+func TestListenUnix(t *testing.T) {
+	path, clean := tempSocket(t)
+	defer clean()
+
+	t.Run("should listen with the permissions", func(t *testing.T) {
+		ln, err := listenUnix(path, 0600)
+		if err != nil {
+			t.Fatalf("want not error, got %v", err)
+		}
+		defer ln.Close()
+		if info, err := os.Stat(path); err != nil || info.Mode()&os.ModePerm != 0600 {
+			t.Fatalf("got %v and %v, want mode 0600", info, err)
+		}
+	})
+
+	t.Run("should replace a stale socket", func(t *testing.T) {
+		stale, _ := net.Listen("unix", path)
+		stale.(*net.UnixListener).SetUnlinkOnClose(false)
+		_ = stale.Close()
+
+		ln, err := listenUnix(path, 0660)
+		if err != nil {
+			t.Fatalf("want not error, got %v", err)
+		}
+		_ = ln.Close()
+	})
+
+	t.Run("should not replace a file that is not a socket", func(t *testing.T) {
+		file := path + ".txt"
+		_ = ioutil.WriteFile(file, []byte("data"), 0644)
+		if _, err := listenUnix(file, 0660); err == nil {
+			t.Fatal("want error, got nil")
+		}
+		if _, err := os.Stat(file); err != nil {
+			t.Fatalf("got %v, want file kept", err)
+		}
+	})
+}
+
+func setActivationEnv(pid int, fds int, names string) {
+	_ = os.Setenv(listenPidEnv, strconv.Itoa(pid))
+	_ = os.Setenv(listenFdsEnv, strconv.Itoa(fds))
+	_ = os.Setenv(listenFdNamesEnv, names)
+}
+
+func TestActivationListeners(t *testing.T) {
+	t.Run("should fail without socket activation", func(t *testing.T) {
+		_ = os.Unsetenv(listenPidEnv)
+		_ = os.Unsetenv(listenFdsEnv)
+		if _, err := activationListeners(); err != errNoActivation {
+			t.Fatalf("got %v, want %v", err, errNoActivation)
+		}
+	})
+
+	t.Run("should fail with the sockets of other process", func(t *testing.T) {
+		setActivationEnv(os.Getpid()+1, 1, "http")
+		if _, err := activationListeners(); err != errNoActivation {
+			t.Fatalf("got %v, want %v", err, errNoActivation)
+		}
+		if os.Getenv(listenFdsEnv) != "" {
+			t.Fatal("want variables unset")
+		}
+	})
+
+	t.Run("should get the passed sockets", func(t *testing.T) {
+		tcp, err := net.Listen("tcp", "localhost:0")
+		if err != nil {
+			t.Fatalf("error listening: %v", err)
+		}
+		// the duplicated descriptor plays the one systemd passes, it is closed by the activation
+		file, _ := tcp.(*net.TCPListener).File()
+		addr := tcp.Addr().String()
+		_ = tcp.Close()
+		activationFdStart = int(file.Fd())
+		defer func() {
+			activationFdStart = 3
+		}()
+		setActivationEnv(os.Getpid(), 1, "http")
+
+		listeners, err := activationListeners()
+		if err != nil || len(listeners) != 1 || listeners[0].Addr().String() != addr {
+			t.Fatalf("got %v and %v, want listener at %s", listeners, err, addr)
+		}
+		defer listeners[0].Close()
+		if conn, err := net.Dial("tcp", addr); err != nil {
+			t.Fatalf("want not error, got %v", err)
+		} else {
+			_ = conn.Close()
+		}
+		if os.Getenv(listenPidEnv) != "" || os.Getenv(listenFdsEnv) != "" || os.Getenv(listenFdNamesEnv) != "" {
+			t.Fatal("want variables unset")
+		}
+	})
+}
+
+func TestServerWithUnixSocket(t *testing.T) {
+	t.Parallel()
+	path, clean := tempSocket(t)
+	defer clean()
+
+	st := _test.NewSpyStore()
+	cfg := config.CfgData{
+		Server: config.ServerCfg{Socket: path, SocketMode: "0660"},
+	}
+	srv := NewServer(cfg, &st)
+
+	if errs := srv.Listen(nil); len(errs) != 0 {
+		t.Fatalf("got %v, want no errors listening", errs)
+	}
+	if srv.Addr().Network() != "unix" || srv.Addr().String() != path {
+		t.Fatalf("got %v, want unix socket %s", srv.Addr(), path)
+	}
+	done := make(chan []error, 1)
+	go func() {
+		done <- srv.Serve(context.Background())
+	}()
+
+	client := unixClient(path)
+	response, err := client.Get("http://unix/pets/1")
+	if err != nil || response.StatusCode != http.StatusOK {
+		t.Fatalf("got %v and %v, want ok", response, err)
+	}
+	_ = response.Body.Close()
+
+	srv.Stop()
+	if got := <-done; len(got) != 0 {
+		t.Fatalf("want no errors, got %v", got)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("got %v, want socket removed", err)
+	}
+}
+
+func TestServerWithoutListeners(t *testing.T) {
+	st := _test.NewSpyStore()
+	srv := NewServer(config.CfgData{}, &st)
+
+	if got := srv.Listen(nil); len(got) != 1 || got[0] != errNoListeners {
+		t.Fatalf("got %v, want %v", got, errNoListeners)
+	}
+}
@@ -0,0 +1,94 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package server
+
+import (
+	"sync"
+)
+
+type batchFunc func(keys []int) (map[int]interface{}, error)
+
+// loader batches the loads of the fields resolved in the same GraphQL request: each load registers its key and
+// returns a thunk, and the first thunk called gets all the pending keys with a single call to the batch function.
+// Missing keys resolve to nil, and the results are cached for the request.
+type loader struct {
+	mu      sync.Mutex
+	batch   batchFunc
+	pending []int
+	results map[int]interface{}
+	errs    map[int]error
+}
+
+func (l *loader) isLoaded(key int) bool {
+	_, found := l.results[key]
+	return found
+}
+
+func (l *loader) isPending(key int) bool {
+	for _, k := range l.pending {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *loader) dispatch() {
+	keys := l.pending
+	l.pending = nil
+	found, err := l.batch(keys)
+	for _, key := range keys {
+		if err != nil {
+			l.errs[key] = err
+		}
+		l.results[key] = found[key]
+	}
+}
+
+func (l *loader) load(key int) func() (interface{}, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.isLoaded(key) && !l.isPending(key) {
+		l.pending = append(l.pending, key)
+	}
+
+	return func() (interface{}, error) {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if !l.isLoaded(key) {
+			if !l.isPending(key) {
+				l.pending = append(l.pending, key)
+			}
+			l.dispatch()
+		}
+		return l.results[key], l.errs[key]
+	}
+}
+
+func newLoader(batch batchFunc) *loader {
+	return &loader{
+		batch:   batch,
+		results: make(map[int]interface{}),
+		errs:    make(map[int]error),
+	}
+}
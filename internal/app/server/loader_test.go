@@ -0,0 +1,72 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package server
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestLoader(t *testing.T) {
+	t.Run("should batch the pending keys", func(t *testing.T) {
+		batches := make([][]int, 0)
+		l := newLoader(func(keys []int) (map[int]interface{}, error) {
+			batches = append(batches, keys)
+			return map[int]interface{}{1: "one", 2: "two"}, nil
+		})
+
+		thunks := []func() (interface{}, error){l.load(1), l.load(2), l.load(3), l.load(1)}
+		got := make([]interface{}, 0)
+		for _, thunk := range thunks {
+			value, _ := thunk()
+			got = append(got, value)
+		}
+		if value, _ := l.load(2)(); value != "two" {
+			t.Fatalf("got %v, want cached two", value)
+		}
+
+		want := []interface{}{"one", "two", nil, "one"}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+		if !reflect.DeepEqual(batches, [][]int{{1, 2, 3}}) {
+			t.Fatalf("got batches %v, want one batch", batches)
+		}
+	})
+
+	t.Run("should return the batch error for every key", func(t *testing.T) {
+		bErr := errors.New("batch error")
+		l := newLoader(func(keys []int) (map[int]interface{}, error) {
+			return nil, bErr
+		})
+
+		first, second := l.load(1), l.load(2)
+		if _, err := first(); err != bErr {
+			t.Fatalf("got %v, want %v", err, bErr)
+		}
+		if _, err := second(); err != bErr {
+			t.Fatalf("got %v, want %v", err, bErr)
+		}
+	})
+}
@@ -0,0 +1,160 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package server
+
+import (
+	"fmt"
+	"github.com/LearningByExample/go-microservice/internal/app/constants"
+	"io"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type requestKey struct {
+	method string
+	code   int
+}
+
+type requestStats struct {
+	count    int64
+	duration time.Duration
+}
+
+// metrics counts the requests of the service by method and status code, to expose them with the runtime stats in the
+// Prometheus text format.
+type metrics struct {
+	mu       sync.Mutex
+	requests map[requestKey]requestStats
+	started  time.Time
+}
+
+// statusRecorder keeps the status code of a response, flushing the streamed responses as the events
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.code = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (m *metrics) observe(method string, code int, duration time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := requestKey{method: method, code: code}
+	stats := m.requests[key]
+	stats.count++
+	stats.duration += duration
+	m.requests[key] = stats
+}
+
+// methodLabel keeps the label of the methods that the service knows, any other one is counted as OTHER so the clients
+// could not add a label for each method they make up
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete,
+		http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	}
+	return "OTHER"
+}
+
+func (m *metrics) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, code: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+		m.observe(methodLabel(r.Method), recorder.code, time.Since(start))
+	})
+}
+
+func (m *metrics) snapshot() ([]requestKey, map[requestKey]requestStats) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	keys := make([]requestKey, 0, len(m.requests))
+	stats := make(map[requestKey]requestStats, len(m.requests))
+	for key, value := range m.requests {
+		keys = append(keys, key)
+		stats[key] = value
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].method != keys[j].method {
+			return keys[i].method < keys[j].method
+		}
+		return keys[i].code < keys[j].code
+	})
+	return keys, stats
+}
+
+func writeMetric(w io.Writer, name string, kind string, help string) {
+	_, _ = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func (m *metrics) write(w io.Writer) {
+	keys, stats := m.snapshot()
+
+	writeMetric(w, "http_requests_total", "counter", "Requests by method and status code.")
+	for _, key := range keys {
+		_, _ = fmt.Fprintf(w, "http_requests_total{method=%q,code=\"%d\"} %d\n", key.method, key.code,
+			stats[key].count)
+	}
+	writeMetric(w, "http_request_duration_seconds", "summary", "Time serving the requests by method and status code.")
+	for _, key := range keys {
+		labels := fmt.Sprintf("{method=%q,code=\"%d\"}", key.method, key.code)
+		_, _ = fmt.Fprintf(w, "http_request_duration_seconds_sum%s %s\n", labels,
+			strconv.FormatFloat(stats[key].duration.Seconds(), 'g', -1, 64))
+		_, _ = fmt.Fprintf(w, "http_request_duration_seconds_count%s %d\n", labels, stats[key].count)
+	}
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	writeMetric(w, "go_goroutines", "gauge", "Goroutines that currently exist.")
+	_, _ = fmt.Fprintf(w, "go_goroutines %d\n", runtime.NumGoroutine())
+	writeMetric(w, "go_memstats_alloc_bytes", "gauge", "Bytes allocated and still in use.")
+	_, _ = fmt.Fprintf(w, "go_memstats_alloc_bytes %d\n", mem.Alloc)
+	writeMetric(w, "go_gc_cycles_total", "counter", "Completed GC cycles.")
+	_, _ = fmt.Fprintf(w, "go_gc_cycles_total %d\n", mem.NumGC)
+	writeMetric(w, "process_start_time_seconds", "gauge", "Start time of the process since unix epoch in seconds.")
+	_, _ = fmt.Fprintf(w, "process_start_time_seconds %d\n", m.started.Unix())
+}
+
+func (m *metrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set(constants.ContentType, constants.TextMetrics)
+	w.WriteHeader(http.StatusOK)
+	m.write(w)
+}
+
+func newMetrics() *metrics {
+	return &metrics{requests: make(map[requestKey]requestStats), started: time.Now()}
+}
@@ -0,0 +1,292 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/LearningByExample/go-microservice/internal/app/constants"
+	"github.com/LearningByExample/go-microservice/internal/app/data"
+	"github.com/LearningByExample/go-microservice/internal/app/resperr"
+	"github.com/LearningByExample/go-microservice/internal/app/store"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+)
+
+type ownerHandler struct {
+	ownerIdPathReg   *regexp.Regexp
+	ownerNoIdPathReg *regexp.Regexp
+	ownerPetsPathReg *regexp.Regexp
+	data             store.PetStore
+	methods          methodsMap
+}
+
+type petOwner struct {
+	OwnerId int `json:"ownerId"`
+}
+
+const (
+	ownerIdExpr        = `^\/owners\/(\d*)$`
+	ownerNotIdExpr     = `^\/owners$`
+	ownerPetsExpr      = `^\/owners\/(\d+)\/pets$`
+	ownerResource      = "owner"
+	reassignTo         = "reassign_to"
+	ownerLocation      = "/owners/%d"
+	ownerNameNotEmpty  = "owner name cannot be empty"
+	ownerEmailNotEmpty = "owner email cannot be empty"
+	ownerNotValid      = "owner is not valid"
+	ownerHasPets       = "owner has pets"
+)
+
+func hasOwners(ps store.PetStore) bool {
+	_, ok := ps.(store.OwnerStore)
+	return ok
+}
+
+func ownersFor(s store.PetStore, r *http.Request) store.OwnerStore {
+	return s.WithContext(r.Context()).(store.OwnerStore)
+}
+
+func ownerError(err error) error {
+	switch err {
+	case store.OwnerNotFound:
+		return resperr.NotFound
+	case store.InvalidOwner:
+		return resperr.FromErrorMessage(resperr.InvalidResource, []string{ownerNotValid})
+	case store.OwnerHasPets:
+		return resperr.FromErrorMessage(resperr.Conflict, []string{ownerHasPets})
+	default:
+		return err
+	}
+}
+
+func (s ownerHandler) ownerID(path string) (int, error) {
+	matches := s.ownerIdPathReg.FindStringSubmatch(path)
+	if len(matches) == 2 {
+		return strconv.Atoi(matches[1])
+	}
+	return 0, ErrPathNotValid
+}
+
+func writeJson(w http.ResponseWriter, value interface{}) error {
+	w.Header().Add(constants.ContentType, constants.ApplicationJsonUtf8)
+	w.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(w)
+	if err := encoder.Encode(value); err != nil {
+		return resperr.WrittenJson
+	}
+	return nil
+}
+
+func validOwner(owner data.Owner) error {
+	msg := make([]string, 0, 2)
+
+	if owner.Name == "" {
+		msg = append(msg, ownerNameNotEmpty)
+	}
+	if owner.Email == "" {
+		msg = append(msg, ownerEmailNotEmpty)
+	}
+
+	if len(msg) == 0 {
+		return nil
+	} else {
+		return resperr.FromErrorMessage(resperr.InvalidResource, msg)
+	}
+}
+
+func decodeOwner(r *http.Request) (data.Owner, error) {
+	owner := data.Owner{}
+	if r.Body == nil {
+		return owner, resperr.NotBodyProvided
+	}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&owner); err != nil {
+		return owner, resperr.InvalidResource
+	}
+	return owner, validOwner(owner)
+}
+
+func (s ownerHandler) getOwnerRequest(w http.ResponseWriter, r *http.Request) error {
+	if s.ownerNoIdPathReg.MatchString(r.URL.Path) {
+		owners, err := ownersFor(s.data, r).GetAllOwners()
+		if err != nil {
+			return err
+		}
+		return writeJson(w, owners)
+	}
+	if matches := s.ownerPetsPathReg.FindStringSubmatch(r.URL.Path); len(matches) == 2 {
+		id, _ := strconv.Atoi(matches[1])
+		return s.getOwnerPets(w, r, id)
+	}
+	if id, err := s.ownerID(r.URL.Path); err == nil {
+		owner, err := ownersFor(s.data, r).GetOwner(id)
+		if err != nil {
+			return ownerError(err)
+		}
+		return writeJson(w, owner)
+	}
+	return resperr.InvalidUrl
+}
+
+func (s ownerHandler) getOwnerPets(w http.ResponseWriter, r *http.Request, id int) error {
+	if _, err := ownersFor(s.data, r).GetOwner(id); err != nil {
+		return ownerError(err)
+	}
+	pets, err := s.data.WithContext(r.Context()).FindPets(store.PetQuery{OwnerId: id})
+	if err != nil {
+		return err
+	}
+	return writeJson(w, pets)
+}
+
+func (s ownerHandler) postOwnerRequest(w http.ResponseWriter, r *http.Request) error {
+	if !s.ownerNoIdPathReg.MatchString(r.URL.Path) {
+		return resperr.InvalidUrl
+	}
+	owner, err := decodeOwner(r)
+	if err != nil {
+		return err
+	}
+	id, err := ownersFor(s.data, r).AddOwner(owner.Name, owner.Email)
+	if err == nil {
+		w.Header().Add(constants.ContentType, constants.ApplicationJsonUtf8)
+		w.Header().Set(constants.Location, fmt.Sprintf(ownerLocation, id))
+		w.WriteHeader(http.StatusOK)
+	}
+	return err
+}
+
+func (s ownerHandler) putOwnerRequest(w http.ResponseWriter, r *http.Request) error {
+	id, err := s.ownerID(r.URL.Path)
+	if err != nil {
+		return resperr.InvalidUrl
+	}
+	owner, err := decodeOwner(r)
+	if err != nil {
+		return err
+	}
+	change, err := ownersFor(s.data, r).UpdateOwner(id, owner.Name, owner.Email)
+	if err != nil {
+		return ownerError(err)
+	}
+	w.Header().Add(constants.ContentType, constants.ApplicationJsonUtf8)
+	if change {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusNotModified)
+	}
+	return nil
+}
+
+func (s ownerHandler) deleteOwnerRequest(w http.ResponseWriter, r *http.Request) error {
+	id, err := s.ownerID(r.URL.Path)
+	if err != nil {
+		return resperr.InvalidUrl
+	}
+	to, err := queryInt(r, reassignTo, 0, 1, int(^uint(0)>>1))
+	if err != nil {
+		return err
+	}
+	if err = ownersFor(s.data, r).DeleteOwner(id, to); err != nil {
+		return ownerError(err)
+	}
+	w.Header().Add(constants.ContentType, constants.ApplicationJsonUtf8)
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+func (s petHandler) setPetOwner(w http.ResponseWriter, r *http.Request, id int, ownerId int) error {
+	change, err := ownersFor(s.data, r).SetPetOwner(id, ownerId)
+	if err == store.PetNotFound {
+		return resperr.NotFound
+	} else if err != nil {
+		return ownerError(err)
+	}
+	w.Header().Add(constants.ContentType, constants.ApplicationJsonUtf8)
+	if change {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusNotModified)
+	}
+	return nil
+}
+
+func (s petHandler) putPetOwnerRequest(w http.ResponseWriter, r *http.Request, id int) error {
+	if r.Body == nil {
+		return resperr.NotBodyProvided
+	}
+	owner := petOwner{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&owner); err != nil || owner.OwnerId <= 0 {
+		return resperr.InvalidResource
+	}
+	return s.setPetOwner(w, r, id, owner.OwnerId)
+}
+
+func (s petHandler) deletePetOwnerRequest(w http.ResponseWriter, r *http.Request, id int) error {
+	return s.setPetOwner(w, r, id, 0)
+}
+
+func (s ownerHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var rErr = resperr.None
+
+	if method, found := s.methods[r.Method]; found {
+		if err := method(w, r); err != nil {
+			rErr = resperr.FromError(err)
+		}
+	} else {
+		rErr = resperr.BadRequest
+	}
+
+	if rErr.Status() != http.StatusOK {
+		log.Printf("Error %v in %s request %q", rErr, r.Method, r.URL.Path)
+	}
+
+	if rErr.Status() != resperr.None.Status() {
+		rErr.Write(w)
+	}
+}
+
+func (s ownerHandler) addMethod(httpMethod string, handlerFunc handlerFunc) {
+	s.methods[httpMethod] = handlerFunc
+}
+
+func NewOwnerHandler(store store.PetStore) http.Handler {
+	oh := ownerHandler{
+		ownerIdPathReg:   regexp.MustCompile(ownerIdExpr),
+		ownerNoIdPathReg: regexp.MustCompile(ownerNotIdExpr),
+		ownerPetsPathReg: regexp.MustCompile(ownerPetsExpr),
+		data:             store,
+		methods:          make(methodsMap),
+	}
+
+	oh.addMethod(http.MethodGet, oh.getOwnerRequest)
+	oh.addMethod(http.MethodPost, oh.postOwnerRequest)
+	oh.addMethod(http.MethodPut, oh.putOwnerRequest)
+	oh.addMethod(http.MethodDelete, oh.deleteOwnerRequest)
+
+	return oh
+}
@@ -0,0 +1,375 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/LearningByExample/go-microservice/internal/_test"
+	"github.com/LearningByExample/go-microservice/internal/app/constants"
+	"github.com/LearningByExample/go-microservice/internal/app/data"
+	"github.com/LearningByExample/go-microservice/internal/app/resperr"
+	"github.com/LearningByExample/go-microservice/internal/app/store"
+)
+
+func TestOwnerRequests(t *testing.T) {
+	spyStore := _test.NewSpyStore()
+	handler := NewOwnerHandler(&spyStore)
+	owner := data.Owner{Name: "John", Email: "john@example.com"}
+
+	type testCase struct {
+		name    string
+		method  string
+		path    string
+		body    interface{}
+		prepare func()
+		want    resperr.ResponseError
+		status  int
+	}
+
+	var cases = []testCase{
+		{
+			name:   "should get all owners",
+			method: http.MethodGet,
+			path:   "/owners",
+			want:   resperr.None,
+			status: http.StatusOK,
+		},
+		{
+			name:   "should get owner",
+			method: http.MethodGet,
+			path:   "/owners/1",
+			want:   resperr.None,
+			status: http.StatusOK,
+		},
+		{
+			name:   "should not found owner",
+			method: http.MethodGet,
+			path:   "/owners/1",
+			prepare: func() {
+				spyStore.WhenGetOwner(func(id int) (data.Owner, error) {
+					return data.Owner{}, store.OwnerNotFound
+				})
+			},
+			want:   resperr.NotFound,
+			status: http.StatusNotFound,
+		},
+		{
+			name:   "should fail with invalid url",
+			method: http.MethodGet,
+			path:   "/owners/one",
+			want:   resperr.InvalidUrl,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "should add owner",
+			method: http.MethodPost,
+			path:   "/owners",
+			body:   owner,
+			want:   resperr.None,
+			status: http.StatusOK,
+		},
+		{
+			name:   "should fail adding an invalid owner",
+			method: http.MethodPost,
+			path:   "/owners",
+			body:   data.Owner{},
+			want: resperr.FromErrorMessage(resperr.InvalidResource,
+				[]string{ownerNameNotEmpty, ownerEmailNotEmpty}),
+			status: http.StatusUnprocessableEntity,
+		},
+		{
+			name:   "should fail adding without body",
+			method: http.MethodPost,
+			path:   "/owners",
+			want:   resperr.NotBodyProvided,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "should update owner",
+			method: http.MethodPut,
+			path:   "/owners/1",
+			body:   owner,
+			prepare: func() {
+				spyStore.WhenUpdateOwner(func(id int, name string, email string) (bool, error) {
+					return true, nil
+				})
+			},
+			want:   resperr.None,
+			status: http.StatusOK,
+		},
+		{
+			name:   "should not modify owner",
+			method: http.MethodPut,
+			path:   "/owners/1",
+			body:   owner,
+			want:   resperr.None,
+			status: http.StatusNotModified,
+		},
+		{
+			name:   "should delete owner",
+			method: http.MethodDelete,
+			path:   "/owners/1",
+			want:   resperr.None,
+			status: http.StatusOK,
+		},
+		{
+			name:   "should reject deleting an owner with pets",
+			method: http.MethodDelete,
+			path:   "/owners/1",
+			prepare: func() {
+				spyStore.WhenDeleteOwner(func(id int, reassignTo int) error {
+					return store.OwnerHasPets
+				})
+			},
+			want:   resperr.FromErrorMessage(resperr.Conflict, []string{ownerHasPets}),
+			status: http.StatusConflict,
+		},
+		{
+			name:   "should reject reassigning to an invalid owner",
+			method: http.MethodDelete,
+			path:   "/owners/1?reassign_to=2",
+			prepare: func() {
+				spyStore.WhenDeleteOwner(func(id int, reassignTo int) error {
+					return store.InvalidOwner
+				})
+			},
+			want:   resperr.FromErrorMessage(resperr.InvalidResource, []string{ownerNotValid}),
+			status: http.StatusUnprocessableEntity,
+		},
+		{
+			name:   "should fail with invalid reassign",
+			method: http.MethodDelete,
+			path:   "/owners/1?reassign_to=none",
+			want:   resperr.InvalidUrl,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "should fail with bad method",
+			method: http.MethodPatch,
+			path:   "/owners/1",
+			want:   resperr.BadRequest,
+			status: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			spyStore.Reset()
+			if tt.prepare != nil {
+				tt.prepare()
+			}
+
+			var body = ""
+			if tt.body != nil {
+				bytes, _ := json.Marshal(tt.body)
+				body = string(bytes)
+			}
+			response := _test.HeaderRequest(handler, tt.path, tt.method, body, nil)
+
+			if response.Code != tt.status {
+				t.Fatalf("got status %d, want %d", response.Code, tt.status)
+			}
+			if tt.status != http.StatusNotModified {
+				_test.AssertResponseError(t, response, tt.want)
+			}
+		})
+	}
+}
+
+func TestOwnerRequestParameters(t *testing.T) {
+	spyStore := _test.NewSpyStore()
+	handler := NewOwnerHandler(&spyStore)
+
+	t.Run("should add owner with location", func(t *testing.T) {
+		spyStore.Reset()
+		spyStore.WhenAddOwner(func(name string, email string) (int, error) {
+			return 7, nil
+		})
+
+		response := _test.PostRequest(handler, "/owners", data.Owner{Name: "John", Email: "john@example.com"})
+
+		if got := response.Header().Get(constants.Location); got != "/owners/7" {
+			t.Fatalf("got location %q, want %q", got, "/owners/7")
+		}
+		want := data.Owner{Id: 7, Name: "John", Email: "john@example.com"}
+		if !reflect.DeepEqual(spyStore.OwnerParameters, want) {
+			t.Fatalf("got owner %v, want %v", spyStore.OwnerParameters, want)
+		}
+	})
+
+	t.Run("should delete owner reassigning pets", func(t *testing.T) {
+		spyStore.Reset()
+
+		_ = _test.DeleteRequest(handler, "/owners/1?reassign_to=2")
+
+		if !spyStore.DeleteOwnerWasCall || spyStore.OwnerId != 1 || spyStore.ReassignTo != 2 {
+			t.Fatalf("got delete owner %d reassign to %d, want 1 and 2", spyStore.OwnerId, spyStore.ReassignTo)
+		}
+	})
+
+	t.Run("should get owner pets", func(t *testing.T) {
+		spyStore.Reset()
+		ownerId := 1
+		pets := []data.Pet{{Id: 3, Name: "Fluff", Race: "dog", Mod: "happy", OwnerId: &ownerId}}
+		spyStore.WhenFindPets(func(query store.PetQuery) ([]data.Pet, error) {
+			return pets, nil
+		})
+
+		response := _test.GetRequest(handler, "/owners/1/pets")
+
+		got := make([]data.Pet, 0)
+		_ = json.NewDecoder(response.Body).Decode(&got)
+		if response.Code != http.StatusOK || !reflect.DeepEqual(got, pets) {
+			t.Fatalf("got %d %v, want %v", response.Code, got, pets)
+		}
+		if !reflect.DeepEqual(spyStore.Query, store.PetQuery{OwnerId: 1}) {
+			t.Fatalf("got query %v, want owner 1", spyStore.Query)
+		}
+	})
+
+	t.Run("should not found pets of an unknown owner", func(t *testing.T) {
+		spyStore.Reset()
+		spyStore.WhenGetOwner(func(id int) (data.Owner, error) {
+			return data.Owner{}, store.OwnerNotFound
+		})
+
+		response := _test.GetRequest(handler, "/owners/1/pets")
+
+		_test.AssertResponseError(t, response, resperr.NotFound)
+		if spyStore.FindWasCall {
+			t.Fatal("find should not be called")
+		}
+	})
+}
+
+func TestPetOwnerRequest(t *testing.T) {
+	spyStore := _test.NewSpyStore()
+	handler := NewPetHandler(&spyStore)
+
+	type testCase struct {
+		name      string
+		method    string
+		body      interface{}
+		set       func(petId int, ownerId int) (bool, error)
+		want      resperr.ResponseError
+		status    int
+		wantOwner int
+	}
+
+	var cases = []testCase{
+		{
+			name:   "should set pet owner",
+			method: http.MethodPut,
+			body:   petOwner{OwnerId: 2},
+			set: func(petId int, ownerId int) (bool, error) {
+				return true, nil
+			},
+			want:      resperr.None,
+			status:    http.StatusOK,
+			wantOwner: 2,
+		},
+		{
+			name:      "should not modify pet owner",
+			method:    http.MethodPut,
+			body:      petOwner{OwnerId: 2},
+			want:      resperr.None,
+			status:    http.StatusNotModified,
+			wantOwner: 2,
+		},
+		{
+			name:   "should release pet",
+			method: http.MethodDelete,
+			set: func(petId int, ownerId int) (bool, error) {
+				return true, nil
+			},
+			want:      resperr.None,
+			status:    http.StatusOK,
+			wantOwner: 0,
+		},
+		{
+			name:   "should fail with invalid owner",
+			method: http.MethodPut,
+			body:   petOwner{OwnerId: 2},
+			set: func(petId int, ownerId int) (bool, error) {
+				return false, store.InvalidOwner
+			},
+			want:      resperr.FromErrorMessage(resperr.InvalidResource, []string{ownerNotValid}),
+			status:    http.StatusUnprocessableEntity,
+			wantOwner: 2,
+		},
+		{
+			name:   "should not found pet",
+			method: http.MethodPut,
+			body:   petOwner{OwnerId: 2},
+			set: func(petId int, ownerId int) (bool, error) {
+				return false, store.PetNotFound
+			},
+			want:      resperr.NotFound,
+			status:    http.StatusNotFound,
+			wantOwner: 2,
+		},
+		{
+			name:   "should fail without owner",
+			method: http.MethodPut,
+			body:   "{}",
+			want:   resperr.InvalidResource,
+			status: http.StatusUnprocessableEntity,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			spyStore.Reset()
+			if tt.set != nil {
+				spyStore.WhenSetPetOwner(tt.set)
+			}
+
+			var body = ""
+			switch v := tt.body.(type) {
+			case string:
+				body = v
+			case petOwner:
+				bytes, _ := json.Marshal(v)
+				body = string(bytes)
+			}
+			response := _test.HeaderRequest(handler, "/pets/3/owner", tt.method, body, nil)
+
+			if response.Code != tt.status {
+				t.Fatalf("got status %d, want %d", response.Code, tt.status)
+			}
+			if tt.status != http.StatusNotModified {
+				_test.AssertResponseError(t, response, tt.want)
+			}
+			if spyStore.SetOwnerWasCall != (tt.wantOwner != 0 || tt.method == http.MethodDelete) {
+				t.Fatalf("got set owner call %t", spyStore.SetOwnerWasCall)
+			}
+			if spyStore.SetOwnerWasCall && (spyStore.Id != 3 || spyStore.OwnerId != tt.wantOwner) {
+				t.Fatalf("got pet %d owner %d, want pet 3 owner %d", spyStore.Id, spyStore.OwnerId, tt.wantOwner)
+			}
+		})
+	}
+}
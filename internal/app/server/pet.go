@@ -23,32 +23,46 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/LearningByExample/go-microservice/internal/app/constants"
 	"github.com/LearningByExample/go-microservice/internal/app/data"
+	"github.com/LearningByExample/go-microservice/internal/app/reqctx"
 	"github.com/LearningByExample/go-microservice/internal/app/resperr"
 	"github.com/LearningByExample/go-microservice/internal/app/store"
 	"log"
 	"net/http"
 	"regexp"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type handlerFunc func(w http.ResponseWriter, r *http.Request) error
 type methodsMap map[string]handlerFunc
+type subResourceFunc func(w http.ResponseWriter, r *http.Request, id int) error
+type subResourcesMap map[string]map[string]subResourceFunc
 
 type petHandler struct {
 	petIdPathReg   *regexp.Regexp
 	petNoIdPathReg *regexp.Regexp
+	petSubPathReg  *regexp.Regexp
 	data           store.PetStore
+	photos         *photoService
 	methods        methodsMap
+	subResources   subResourcesMap
 }
 
 const (
 	petIdExpr       = `^\/pets\/(\d*)$`
 	petNotIdExpr    = `^\/pets$`
+	petSubExpr      = `^\/pets\/(\d+)\/([a-z]+)$`
+	includeDeleted  = "include_deleted"
+	updatedSince    = "updated_since"
+	sortParam       = "sort"
+	restoreResource = "restore"
 	petLocation     = "/pets/%d"
 	pathNotValid    = "no valid path"
 	petNameNotEmpty = "pet name cannot be empty"
@@ -68,9 +82,116 @@ func (s petHandler) petID(path string) (int, error) {
 	return 0, ErrPathNotValid
 }
 
+func (s petHandler) dataFor(r *http.Request) store.PetStore {
+	return s.data.WithContext(r.Context())
+}
+
+func (s petHandler) subResource(path string) (int, string, bool) {
+	matches := s.petSubPathReg.FindStringSubmatch(path)
+	if len(matches) == 3 {
+		if id, err := strconv.Atoi(matches[1]); err == nil {
+			return id, matches[2], true
+		}
+	}
+	return 0, "", false
+}
+
+func queryBool(r *http.Request, name string) (bool, error) {
+	if value := r.URL.Query().Get(name); value != "" {
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b, nil
+		}
+		return false, resperr.InvalidUrl
+	}
+	return false, nil
+}
+
+func queryTime(r *http.Request, name string) (time.Time, error) {
+	if value := r.URL.Query().Get(name); value != "" {
+		if t, err := time.Parse(time.RFC3339, value); err == nil {
+			return t, nil
+		}
+		return time.Time{}, resperr.InvalidUrl
+	}
+	return time.Time{}, nil
+}
+
+func querySort(r *http.Request) ([]store.PetSort, error) {
+	var sorts []store.PetSort = nil
+	if value := r.URL.Query().Get(sortParam); value != "" {
+		for _, field := range strings.Split(value, ",") {
+			by := store.PetSort{}
+			if strings.HasPrefix(field, "-") {
+				by.Descending = true
+				field = field[1:]
+			}
+			by.Field = store.SortField(field)
+			if !by.Field.IsValid() {
+				return nil, resperr.InvalidUrl
+			}
+			sorts = append(sorts, by)
+		}
+	}
+	return sorts, nil
+}
+
+// allowDeleted rejects the requests for the deleted pets by the callers that are not admins, only they could see them
+func allowDeleted(ctx context.Context, withDeleted bool) error {
+	if withDeleted && !reqctx.Allowed(ctx, data.ScopeAdmin) {
+		return resperr.Forbidden
+	}
+	return nil
+}
+
+func queryDeleted(r *http.Request) (bool, error) {
+	withDeleted, err := queryBool(r, includeDeleted)
+	if err == nil {
+		err = allowDeleted(r.Context(), withDeleted)
+	}
+	return withDeleted, err
+}
+
+func petQuery(r *http.Request) (store.PetQuery, error) {
+	var query = store.PetQuery{}
+	var err error = nil
+
+	if query.IncludeDeleted, err = queryDeleted(r); err == nil {
+		if query.UpdatedSince, err = queryTime(r, updatedSince); err == nil {
+			if query.Sort, err = querySort(r); err == nil {
+				query.Tags, query.Attributes, err = queryTags(r)
+			}
+		}
+	}
+
+	return query, err
+}
+
+func (s petHandler) getPet(id int, r *http.Request) (data.Pet, error) {
+	withDeleted, err := queryDeleted(r)
+	if err != nil {
+		return data.Pet{}, err
+	}
+	if !withDeleted {
+		return s.dataFor(r).GetPet(id)
+	}
+
+	pets, err := s.dataFor(r).FindPets(store.PetQuery{Ids: []int{id}, IncludeDeleted: true})
+	if err == nil && len(pets) == 0 {
+		err = store.PetNotFound
+	}
+	if err != nil {
+		return data.Pet{}, err
+	}
+	return pets[0], nil
+}
+
 func (s petHandler) getPetRequest(w http.ResponseWriter, r *http.Request) error {
 	if s.petNoIdPathReg.MatchString(r.URL.Path) {
-		pets, err := s.data.GetAllPets()
+		query, err := petQuery(r)
+		if err != nil {
+			return err
+		}
+		pets, err := s.dataFor(r).FindPets(query)
 		if err == nil {
 			w.Header().Add(constants.ContentType, constants.ApplicationJsonUtf8)
 			w.WriteHeader(http.StatusOK)
@@ -82,8 +203,10 @@ func (s petHandler) getPetRequest(w http.ResponseWriter, r *http.Request) error
 		return err
 	} else {
 		if id, err := s.petID(r.URL.Path); err == nil {
-			if pet, err := s.data.GetPet(id); err == store.PetNotFound {
+			if pet, err := s.getPet(id, r); err == store.PetNotFound {
 				return resperr.NotFound
+			} else if err != nil {
+				return err
 			} else {
 				w.Header().Add(constants.ContentType, constants.ApplicationJsonUtf8)
 				w.WriteHeader(http.StatusOK)
@@ -99,7 +222,7 @@ func (s petHandler) getPetRequest(w http.ResponseWriter, r *http.Request) error
 	}
 }
 
-func (s petHandler) validPet(pet data.Pet) error {
+func validPet(pet data.Pet) error {
 	msg := make([]string, 0, 3)
 
 	if pet.Name == "" {
@@ -125,8 +248,8 @@ func (s petHandler) postPetRequest(w http.ResponseWriter, r *http.Request) error
 			decoder := json.NewDecoder(r.Body)
 			pet := data.Pet{}
 			if err := decoder.Decode(&pet); err == nil {
-				if err := s.validPet(pet); err == nil {
-					id, err := s.data.AddPet(pet.Name, pet.Race, pet.Mod)
+				if err := validPet(pet); err == nil {
+					id, err := s.dataFor(r).AddPet(pet.Name, pet.Race, pet.Mod)
 					if err == nil {
 						w.Header().Add(constants.ContentType, constants.ApplicationJsonUtf8)
 						w.Header().Set(constants.Location, fmt.Sprintf(petLocation, id))
@@ -149,9 +272,14 @@ func (s petHandler) postPetRequest(w http.ResponseWriter, r *http.Request) error
 
 func (s petHandler) deletePetRequest(w http.ResponseWriter, r *http.Request) error {
 	if id, err := s.petID(r.URL.Path); err == nil {
-		if err := s.data.DeletePet(id); err == store.PetNotFound {
+		if err := s.dataFor(r).DeletePet(id); err == store.PetNotFound {
 			return resperr.NotFound
+		} else if err != nil {
+			return err
 		} else {
+			if s.photos != nil && s.photos.removeOnDelete {
+				s.photos.remove(id)
+			}
 			w.Header().Add(constants.ContentType, constants.ApplicationJsonUtf8)
 			w.WriteHeader(http.StatusOK)
 			return nil
@@ -168,9 +296,11 @@ func (s petHandler) putPetRequest(w http.ResponseWriter, r *http.Request) error
 			decoder := json.NewDecoder(r.Body)
 			pet := data.Pet{}
 			if err := decoder.Decode(&pet); err == nil {
-				if err := s.validPet(pet); err == nil {
-					if change, err = s.data.UpdatePet(id, pet.Name, pet.Race, pet.Mod); err != nil {
+				if err := validPet(pet); err == nil {
+					if change, err = s.dataFor(r).UpdatePet(id, pet.Name, pet.Race, pet.Mod); err == store.PetNotFound {
 						return resperr.NotFound
+					} else if err != nil {
+						return err
 					} else {
 						w.Header().Add(constants.ContentType, constants.ApplicationJsonUtf8)
 						if change {
@@ -194,10 +324,35 @@ func (s petHandler) putPetRequest(w http.ResponseWriter, r *http.Request) error
 	}
 }
 
+func (s petHandler) restorePetRequest(w http.ResponseWriter, r *http.Request, id int) error {
+	if err := s.dataFor(r).RestorePet(id); err == store.PetNotFound {
+		return resperr.NotFound
+	} else if err != nil {
+		return err
+	}
+	w.Header().Add(constants.ContentType, constants.ApplicationJsonUtf8)
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+func (s petHandler) serveSubResource(w http.ResponseWriter, r *http.Request, id int, name string) error {
+	if methods, found := s.subResources[name]; found {
+		if method, found := methods[r.Method]; found {
+			return method(w, r, id)
+		}
+		return resperr.BadRequest
+	}
+	return resperr.NotFound
+}
+
 func (s petHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	var rErr = resperr.None
 
-	if method, found := s.methods[r.Method]; found {
+	if id, name, found := s.subResource(r.URL.Path); found {
+		if err := s.serveSubResource(w, r, id, name); err != nil {
+			rErr = resperr.FromError(err)
+		}
+	} else if method, found := s.methods[r.Method]; found {
 		if err := method(w, r); err != nil {
 			rErr = resperr.FromError(err)
 		}
@@ -218,18 +373,44 @@ func (s petHandler) addMethod(httpMethod string, handlerFunc handlerFunc) {
 	s.methods[httpMethod] = handlerFunc
 }
 
+func (s petHandler) addSubResource(name string, httpMethod string, fn subResourceFunc) {
+	if _, found := s.subResources[name]; !found {
+		s.subResources[name] = make(map[string]subResourceFunc)
+	}
+	s.subResources[name][httpMethod] = fn
+}
+
 func NewPetHandler(store store.PetStore) http.Handler {
+	return newPetHandler(store, nil)
+}
+
+func newPetHandler(store store.PetStore, photos *photoService) http.Handler {
 	ph := petHandler{
 		petIdPathReg:   regexp.MustCompile(petIdExpr),
 		petNoIdPathReg: regexp.MustCompile(petNotIdExpr),
+		petSubPathReg:  regexp.MustCompile(petSubExpr),
 		data:           store,
+		photos:         photos,
 		methods:        make(methodsMap),
+		subResources:   make(subResourcesMap),
 	}
 
 	ph.addMethod(http.MethodGet, ph.getPetRequest)
 	ph.addMethod(http.MethodPost, ph.postPetRequest)
 	ph.addMethod(http.MethodDelete, ph.deletePetRequest)
 	ph.addMethod(http.MethodPut, ph.putPetRequest)
+	ph.addSubResource(restoreResource, http.MethodPost, ph.restorePetRequest)
+	ph.addSubResource(historyResource, http.MethodGet, ph.historyPetRequest)
+	ph.addSubResource(transitionsResource, http.MethodPost, ph.transitionPetRequest)
+	ph.addSubResource(tagsResource, http.MethodPut, ph.putPetTagsRequest)
+	if photos != nil {
+		ph.addSubResource(photoResource, http.MethodPut, ph.putPhotoRequest)
+		ph.addSubResource(photoResource, http.MethodGet, ph.getPhotoRequest)
+	}
+	if hasOwners(store) {
+		ph.addSubResource(ownerResource, http.MethodPut, ph.putPetOwnerRequest)
+		ph.addSubResource(ownerResource, http.MethodDelete, ph.deletePetOwnerRequest)
+	}
 
 	return ph
 }
@@ -29,10 +29,12 @@ import (
 	"net/http/httptest"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/LearningByExample/go-microservice/internal/_test"
 	"github.com/LearningByExample/go-microservice/internal/app/constants"
 	"github.com/LearningByExample/go-microservice/internal/app/data"
+	"github.com/LearningByExample/go-microservice/internal/app/reqctx"
 	"github.com/LearningByExample/go-microservice/internal/app/resperr"
 	"github.com/LearningByExample/go-microservice/internal/app/store"
 )
@@ -141,7 +143,7 @@ func TestGetAllPets(t *testing.T) {
 		},
 	}
 
-	spyStore.WhenGetAllPets(func() ([]data.Pet, error) {
+	spyStore.WhenFindPets(func(query store.PetQuery) ([]data.Pet, error) {
 		return mockPets, nil
 	})
 
@@ -149,8 +151,8 @@ func TestGetAllPets(t *testing.T) {
 
 	assertPetsResponseEquals(t, response, mockPets, 2)
 
-	if spyStore.GetAllWasCall != true {
-		t.Fatalf("get all was not called")
+	if spyStore.FindWasCall != true {
+		t.Fatalf("find was not called")
 	}
 }
 
@@ -161,7 +163,7 @@ func TestGetAllPetsWithError(t *testing.T) {
 	mockPets := make([]data.Pet, 0)
 	mockError := errors.New("nasty error")
 
-	spyStore.WhenGetAllPets(func() ([]data.Pet, error) {
+	spyStore.WhenFindPets(func(query store.PetQuery) ([]data.Pet, error) {
 		return mockPets, mockError
 	})
 
@@ -169,8 +171,8 @@ func TestGetAllPetsWithError(t *testing.T) {
 
 	_test.AssertResponseError(t, response, resperr.FromError(mockError))
 
-	if spyStore.GetAllWasCall != true {
-		t.Fatalf("get all was not called")
+	if spyStore.FindWasCall != true {
+		t.Fatalf("find was not called")
 	}
 }
 
@@ -180,7 +182,7 @@ func TestGetAllPetsWithNoPets(t *testing.T) {
 
 	noPets := make([]data.Pet, 0)
 
-	spyStore.WhenGetAllPets(func() ([]data.Pet, error) {
+	spyStore.WhenFindPets(func(query store.PetQuery) ([]data.Pet, error) {
 		return noPets, nil
 	})
 
@@ -188,8 +190,8 @@ func TestGetAllPetsWithNoPets(t *testing.T) {
 
 	assertPetsResponseEquals(t, response, noPets, 0)
 
-	if spyStore.GetAllWasCall != true {
-		t.Fatalf("get all was not called")
+	if spyStore.FindWasCall != true {
+		t.Fatalf("find was not called")
 	}
 }
 
@@ -350,8 +352,6 @@ func TestPetPostInvalidJson(t *testing.T) {
 }
 
 func TestValidPet(t *testing.T) {
-	handler := petHandler{}
-
 	type TestCase struct {
 		name string
 		pet  data.Pet
@@ -420,7 +420,7 @@ func TestValidPet(t *testing.T) {
 
 	for _, tt := range cases {
 		t.Run(tt.name, func(t *testing.T) {
-			got := handler.validPet(tt.pet)
+			got := validPet(tt.pet)
 
 			if !reflect.DeepEqual(got, tt.want) {
 				t.Fatalf("got %v, want %v", got, tt.want)
@@ -583,6 +583,16 @@ func TestDeletePet(t *testing.T) {
 		}
 	})
 
+	t.Run("we couldn't delete a forbidden pet", func(t *testing.T) {
+		spyStore.Reset()
+		spyStore.WhenDeletePet(func(id int) error {
+			return resperr.Forbidden
+		})
+
+		response := _test.DeleteRequest(handler, "/pets/2")
+		_test.AssertResponseError(t, response, resperr.Forbidden)
+	})
+
 	t.Run("we couldn't delete an invalid url", func(t *testing.T) {
 		spyStore.Reset()
 		spyStore.WhenDeletePet(func(id int) error {
@@ -668,6 +678,22 @@ func TestPetPut(t *testing.T) {
 				storeCalled: true,
 			},
 		},
+		{
+			name: "forbidden pet",
+			url:  "/pets/1",
+			pet: data.Pet{
+				Name: "Lion",
+				Race: "cat",
+				Mod:  "coward",
+			},
+			update: false,
+			err:    resperr.Forbidden,
+			want: Want{
+				id:          1,
+				status:      http.StatusForbidden,
+				storeCalled: true,
+			},
+		},
 		{
 			name: "bad pet",
 			url:  "/pets/1",
@@ -749,3 +775,199 @@ func TestPetPutInvalidJson(t *testing.T) {
 	response := _test.PutRequest(handler, "/pets/1", "{")
 	_test.AssertResponseError(t, response, resperr.InvalidResource)
 }
+
+func TestGetPetsIncludingDeleted(t *testing.T) {
+	spyStore := _test.NewSpyStore()
+	handler := NewPetHandler(&spyStore)
+
+	deletedAt := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+	deletedPet := data.Pet{Id: 1, Name: "Fluffy", Race: "dog", Mod: "happy", DeletedAt: &deletedAt}
+
+	t.Run("should find all pets including deleted", func(t *testing.T) {
+		spyStore.Reset()
+		spyStore.WhenFindPets(func(query store.PetQuery) ([]data.Pet, error) {
+			return []data.Pet{deletedPet}, nil
+		})
+
+		response := _test.GetRequest(handler, "/pets?include_deleted=true")
+
+		assertPetsResponseEquals(t, response, []data.Pet{deletedPet}, 1)
+		if !reflect.DeepEqual(spyStore.Query, store.PetQuery{IncludeDeleted: true}) {
+			t.Fatalf("got query %v, want include deleted", spyStore.Query)
+		}
+	})
+
+	t.Run("should find a deleted pet", func(t *testing.T) {
+		spyStore.Reset()
+		spyStore.WhenFindPets(func(query store.PetQuery) ([]data.Pet, error) {
+			return []data.Pet{deletedPet}, nil
+		})
+
+		response := _test.GetRequest(handler, "/pets/1?include_deleted=true")
+
+		assertPetResponseEquals(t, response, deletedPet)
+		want := store.PetQuery{Ids: []int{1}, IncludeDeleted: true}
+		if !reflect.DeepEqual(spyStore.Query, want) {
+			t.Fatalf("got query %v, want %v", spyStore.Query, want)
+		}
+		if spyStore.GetWasCall {
+			t.Fatal("get should not be called")
+		}
+	})
+
+	t.Run("should not found a purged pet", func(t *testing.T) {
+		spyStore.Reset()
+
+		response := _test.GetRequest(handler, "/pets/1?include_deleted=true")
+
+		_test.AssertResponseError(t, response, resperr.NotFound)
+	})
+
+	t.Run("should only find deleted pets for admins", func(t *testing.T) {
+		withScope := func(scope string) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				grants := data.ApiKey{Scopes: []string{scope}}
+				handler.ServeHTTP(w, r.WithContext(reqctx.WithGrants(r.Context(), grants)))
+			})
+		}
+		spyStore.Reset()
+		spyStore.WhenFindPets(func(query store.PetQuery) ([]data.Pet, error) {
+			return []data.Pet{deletedPet}, nil
+		})
+
+		for _, url := range []string{"/pets?include_deleted=true", "/pets/1?include_deleted=true"} {
+			response := _test.GetRequest(withScope(data.ScopePetsRead), url)
+			_test.AssertResponseError(t, response, resperr.Forbidden)
+		}
+		if spyStore.FindWasCall {
+			t.Fatal("find should not be called")
+		}
+
+		response := _test.GetRequest(withScope(data.ScopeAdmin), "/pets/1?include_deleted=true")
+		assertPetResponseEquals(t, response, deletedPet)
+	})
+
+	t.Run("should fail with invalid include deleted", func(t *testing.T) {
+		spyStore.Reset()
+
+		response := _test.GetRequest(handler, "/pets?include_deleted=maybe")
+
+		_test.AssertResponseError(t, response, resperr.InvalidUrl)
+		if spyStore.FindWasCall {
+			t.Fatal("find should not be called")
+		}
+	})
+}
+
+func TestGetPetsUpdatedSinceAndSorted(t *testing.T) {
+	spyStore := _test.NewSpyStore()
+	handler := NewPetHandler(&spyStore)
+
+	t.Run("should find pets updated since sorted", func(t *testing.T) {
+		spyStore.Reset()
+
+		response := _test.GetRequest(handler, "/pets?updated_since=2020-04-01T00:00:00Z&sort=-updatedAt,name")
+
+		assertPetsResponseEquals(t, response, []data.Pet{}, 0)
+		want := store.PetQuery{
+			UpdatedSince: time.Date(2020, 4, 1, 0, 0, 0, 0, time.UTC),
+			Sort: []store.PetSort{
+				{Field: store.SortByUpdatedAt, Descending: true},
+				{Field: store.SortByName},
+			},
+		}
+		if !reflect.DeepEqual(spyStore.Query, want) {
+			t.Fatalf("got query %v, want %v", spyStore.Query, want)
+		}
+	})
+
+	for _, path := range []string{"/pets?updated_since=yesterday", "/pets?sort=age", "/pets?sort=-"} {
+		t.Run("should fail with "+path, func(t *testing.T) {
+			spyStore.Reset()
+
+			response := _test.GetRequest(handler, path)
+
+			_test.AssertResponseError(t, response, resperr.InvalidUrl)
+			if spyStore.FindWasCall {
+				t.Fatal("find should not be called")
+			}
+		})
+	}
+}
+
+func TestRestorePetRequest(t *testing.T) {
+	spyStore := _test.NewSpyStore()
+	handler := NewPetHandler(&spyStore)
+
+	type testCase struct {
+		name    string
+		path    string
+		method  string
+		restore func(id int) error
+		want    resperr.ResponseError
+		called  bool
+	}
+
+	var cases = []testCase{
+		{
+			name:   "should restore",
+			path:   "/pets/3/restore",
+			method: http.MethodPost,
+			want:   resperr.None,
+			called: true,
+		},
+		{
+			name:   "should not found",
+			path:   "/pets/3/restore",
+			method: http.MethodPost,
+			restore: func(id int) error {
+				return store.PetNotFound
+			},
+			want:   resperr.NotFound,
+			called: true,
+		},
+		{
+			name:   "should fail on store error",
+			path:   "/pets/3/restore",
+			method: http.MethodPost,
+			restore: func(id int) error {
+				return mockError
+			},
+			want:   resperr.FromError(mockError),
+			called: true,
+		},
+		{
+			name:   "should fail with invalid method",
+			path:   "/pets/3/restore",
+			method: http.MethodGet,
+			want:   resperr.BadRequest,
+			called: false,
+		},
+		{
+			name:   "should fail with unknown sub resource",
+			path:   "/pets/3/unknown",
+			method: http.MethodPost,
+			want:   resperr.NotFound,
+			called: false,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			spyStore.Reset()
+			if tt.restore != nil {
+				spyStore.WhenRestorePet(tt.restore)
+			}
+
+			response := _test.HeaderRequest(handler, tt.path, tt.method, "", nil)
+
+			_test.AssertResponseError(t, response, tt.want)
+			if spyStore.RestoreWasCall != tt.called {
+				t.Fatalf("restore was call got %t, want %t", spyStore.RestoreWasCall, tt.called)
+			}
+			if tt.called && spyStore.Id != 3 {
+				t.Fatalf("we didn't restore the right pet, got %v, want %v", spyStore.Id, 3)
+			}
+		})
+	}
+}
@@ -0,0 +1,581 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package server
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"github.com/LearningByExample/go-microservice/internal/app/constants"
+	"github.com/LearningByExample/go-microservice/internal/app/data"
+	"github.com/LearningByExample/go-microservice/internal/app/resperr"
+	"github.com/LearningByExample/go-microservice/internal/app/store"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type petFormat int
+
+const (
+	formatNDJson petFormat = iota
+	formatJson
+	formatCsv
+)
+
+const (
+	petExportPath    = "/pets/export"
+	petImportPath    = "/pets/import"
+	importBatchSize  = 500
+	exportFlushEvery = 100
+	anyMediaType     = "*/*"
+	rowImported      = "imported"
+	rowFailed        = "failed"
+	invalidRowJson   = "invalid json"
+	invalidRowCsv    = "invalid csv row"
+	invalidRowAttrs  = "invalid attributes"
+	invalidRowTime   = "invalid timestamp"
+	deletedRow       = "deleted pets cannot be imported"
+	csvTagSeparator  = ";"
+)
+
+var (
+	csvHeader = []string{"id", "name", "race", "mod", "status", "ownerId", "tags", "attributes", "createdAt",
+		"updatedAt"}
+	csvRequired = []string{"name", "race", "mod"}
+)
+
+type petIOHandler struct {
+	data store.PetStore
+}
+
+func csvRecord(pet data.Pet) ([]string, error) {
+	ownerId, attributes := "", ""
+	if pet.OwnerId != nil {
+		ownerId = strconv.Itoa(pet.Owner())
+	}
+	if len(pet.Attributes) != 0 {
+		bytes, err := json.Marshal(pet.Attributes)
+		if err != nil {
+			return nil, err
+		}
+		attributes = string(bytes)
+	}
+	return []string{strconv.Itoa(pet.Id), pet.Name, pet.Race, pet.Mod, string(pet.Status), ownerId,
+		strings.Join(pet.Tags, csvTagSeparator), attributes, pet.CreatedAt.Format(time.RFC3339Nano),
+		pet.UpdatedAt.Format(time.RFC3339Nano)}, nil
+}
+
+// csvPet reads a pet from a record, the columns not in the header keep their defaults
+func csvPet(record []string, columns map[string]int) (data.Pet, []string) {
+	pet := data.Pet{}
+	msg := make([]string, 0)
+	column := func(name string) string {
+		if i, found := columns[strings.ToLower(name)]; found {
+			return record[i]
+		}
+		return ""
+	}
+
+	pet.Name, pet.Race, pet.Mod = column("name"), column("race"), column("mod")
+	pet.Status = data.PetStatus(column("status"))
+	if value := column("ownerId"); value != "" {
+		if id, err := strconv.Atoi(value); err == nil {
+			pet.OwnerId = &id
+		} else {
+			msg = append(msg, ownerNotValid)
+		}
+	}
+	if value := column("tags"); value != "" {
+		pet.Tags = strings.Split(value, csvTagSeparator)
+	}
+	if value := column("attributes"); value != "" {
+		if err := json.Unmarshal([]byte(value), &pet.Attributes); err != nil {
+			msg = append(msg, invalidRowAttrs)
+		}
+	}
+	for _, field := range []struct {
+		name string
+		dest *time.Time
+	}{{"createdAt", &pet.CreatedAt}, {"updatedAt", &pet.UpdatedAt}} {
+		if value := column(field.name); value != "" {
+			var err error
+			if *field.dest, err = time.Parse(time.RFC3339Nano, value); err != nil {
+				msg = append(msg, invalidRowTime)
+			}
+		}
+	}
+	return pet, msg
+}
+
+type importRow struct {
+	Row    int      `json:"row"`
+	Status string   `json:"status"`
+	Errors []string `json:"errors,omitempty"`
+}
+
+type importReport struct {
+	Total    int         `json:"total"`
+	Imported int         `json:"imported"`
+	Failed   int         `json:"failed"`
+	Error    string      `json:"error,omitempty"`
+	Rows     []importRow `json:"rows"`
+}
+
+func (f petFormat) contentType() string {
+	switch f {
+	case formatJson:
+		return constants.ApplicationJsonUtf8
+	case formatCsv:
+		return constants.TextCsvUtf8
+	default:
+		return constants.ApplicationNDJson
+	}
+}
+
+func formatFromMediaType(mediaType string) (petFormat, bool) {
+	switch mediaType {
+	case constants.ApplicationNDJson, anyMediaType:
+		return formatNDJson, true
+	case constants.ApplicationJson:
+		return formatJson, true
+	case constants.TextCsv:
+		return formatCsv, true
+	}
+	return formatNDJson, false
+}
+
+func exportFormat(accept string) (petFormat, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return formatNDJson, true
+	}
+	for _, part := range strings.Split(accept, ",") {
+		if mediaType, _, err := mime.ParseMediaType(part); err == nil {
+			if format, found := formatFromMediaType(mediaType); found {
+				return format, true
+			}
+		}
+	}
+	return formatNDJson, false
+}
+
+func importFormat(contentType string) (petFormat, bool) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType == anyMediaType {
+		return formatNDJson, false
+	}
+	return formatFromMediaType(mediaType)
+}
+
+type petWriter struct {
+	w       http.ResponseWriter
+	format  petFormat
+	csv     *csv.Writer
+	count   int
+	started bool
+}
+
+func (pw *petWriter) begin() error {
+	pw.started = true
+	pw.w.Header().Add(constants.ContentType, pw.format.contentType())
+	pw.w.WriteHeader(http.StatusOK)
+	switch pw.format {
+	case formatJson:
+		_, err := io.WriteString(pw.w, "[")
+		return err
+	case formatCsv:
+		pw.csv = csv.NewWriter(pw.w)
+		return pw.csv.Write(csvHeader)
+	}
+	return nil
+}
+
+func (pw *petWriter) flush() {
+	if pw.csv != nil {
+		pw.csv.Flush()
+	}
+	if f, ok := pw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (pw *petWriter) write(pet data.Pet) error {
+	var err error = nil
+	if !pw.started {
+		if err = pw.begin(); err != nil {
+			return err
+		}
+	}
+
+	switch pw.format {
+	case formatCsv:
+		var record []string
+		if record, err = csvRecord(pet); err == nil {
+			err = pw.csv.Write(record)
+		}
+	case formatJson:
+		if pw.count != 0 {
+			if _, err = io.WriteString(pw.w, ","); err != nil {
+				return err
+			}
+		}
+		err = json.NewEncoder(pw.w).Encode(pet)
+	default:
+		err = json.NewEncoder(pw.w).Encode(pet)
+	}
+
+	pw.count++
+	if pw.count%exportFlushEvery == 0 {
+		pw.flush()
+	}
+	return err
+}
+
+func (pw *petWriter) close() error {
+	var err error = nil
+	if !pw.started {
+		if err = pw.begin(); err != nil {
+			return err
+		}
+	}
+	if pw.format == formatJson {
+		_, err = io.WriteString(pw.w, "]")
+	}
+	pw.flush()
+	if err == nil && pw.csv != nil {
+		err = pw.csv.Error()
+	}
+	return err
+}
+
+func (h petIOHandler) exportPets(w http.ResponseWriter, r *http.Request) error {
+	format, found := exportFormat(r.Header.Get(constants.Accept))
+	if !found {
+		return resperr.NotAcceptable
+	}
+
+	pw := petWriter{w: w, format: format}
+	err := h.data.ForEachPet(pw.write)
+	if err == nil {
+		err = pw.close()
+	}
+	if err != nil && pw.started {
+		log.Printf("Error %v exporting pets after %d pets", err, pw.count)
+		return nil
+	}
+	return err
+}
+
+type petImporter struct {
+	data    store.PetStore
+	owners  map[int]bool
+	report  importReport
+	pending []data.Pet
+	rows    []int
+}
+
+func (pi *petImporter) fail(row int, msg []string) {
+	pi.report.Total++
+	pi.report.Failed++
+	pi.report.Rows = append(pi.report.Rows, importRow{Row: row, Status: rowFailed, Errors: msg})
+}
+
+// stop fails the row that could not be read, the rows before it are still imported but the ones after are not read
+func (pi *petImporter) stop(row int, msg string) {
+	pi.fail(row, []string{msg})
+	pi.report.Error = msg
+}
+
+// validOwner checks that the owner of an imported pet exists, remembering the owners already found and leaving to
+// the store the errors finding them
+func (pi *petImporter) validOwner(id int) bool {
+	if valid, found := pi.owners[id]; found {
+		return valid
+	}
+	owners, ok := pi.data.(store.OwnerStore)
+	if !ok || id <= 0 {
+		return false
+	}
+	_, err := owners.GetOwner(id)
+	if err != nil && err != store.OwnerNotFound {
+		return true
+	}
+	pi.owners[id] = err == nil
+	return err == nil
+}
+
+// validImport validates all the imported fields of a pet, the id is always a new one
+func (pi *petImporter) validImport(pet data.Pet) []string {
+	msg := make([]string, 0)
+	if err := validPet(pet); err != nil {
+		msg = append(msg, resperr.FromError(err).Message...)
+	}
+	if pet.Status != "" && !pet.Status.IsValid() {
+		msg = append(msg, petStatusNotValid)
+	}
+	if err := validTags(petTags{Tags: pet.Tags, Attributes: pet.Attributes}); err != nil {
+		msg = append(msg, resperr.FromError(err).Message...)
+	}
+	if pet.OwnerId != nil && !pi.validOwner(pet.Owner()) {
+		msg = append(msg, ownerNotValid)
+	}
+	if pet.IsDeleted() {
+		msg = append(msg, deletedRow)
+	}
+	return msg
+}
+
+func (pi *petImporter) add(row int, pet data.Pet) {
+	for i := range pet.Tags {
+		pet.Tags[i] = normalizeTag(pet.Tags[i])
+	}
+	if msg := pi.validImport(pet); len(msg) != 0 {
+		pi.fail(row, msg)
+		return
+	}
+	pi.report.Total++
+	pi.report.Rows = append(pi.report.Rows, importRow{Row: row, Status: rowImported})
+	pet.Id = 0
+	pi.pending = append(pi.pending, pet)
+	pi.rows = append(pi.rows, len(pi.report.Rows)-1)
+	if len(pi.pending) == importBatchSize {
+		pi.flush()
+	}
+}
+
+func (pi *petImporter) flush() {
+	if len(pi.pending) == 0 {
+		return
+	}
+	if err := pi.data.ImportPets(pi.pending); err != nil {
+		log.Printf("Error %v importing batch of %d pets", err, len(pi.pending))
+		for _, i := range pi.rows {
+			pi.report.Rows[i].Status = rowFailed
+			pi.report.Rows[i].Errors = []string{err.Error()}
+		}
+		pi.report.Failed += len(pi.pending)
+	} else {
+		pi.report.Imported += len(pi.pending)
+	}
+	pi.pending = pi.pending[:0]
+	pi.rows = pi.rows[:0]
+}
+
+func (pi *petImporter) readJson(body io.Reader) error {
+	decoder := json.NewDecoder(body)
+	decoder.DisallowUnknownFields()
+	if t, err := decoder.Token(); err != nil || t != json.Delim('[') {
+		return resperr.InvalidResource
+	}
+	for row := 1; decoder.More(); row++ {
+		pet := data.Pet{}
+		if err := decoder.Decode(&pet); err != nil {
+			if _, isValueErr := err.(*json.UnmarshalTypeError); isValueErr || isUnknownField(err) {
+				pi.fail(row, []string{jsonRowError(err)})
+				continue
+			}
+			pi.stop(row, invalidRowJson)
+			return nil
+		}
+		pi.add(row, pet)
+	}
+	return nil
+}
+
+// isUnknownField checks if the error is a field not in the pet, json does not have a type for it
+func isUnknownField(err error) bool {
+	return strings.HasPrefix(err.Error(), "json: unknown field ")
+}
+
+func jsonRowError(err error) string {
+	return strings.TrimPrefix(err.Error(), "json: ")
+}
+
+func (pi *petImporter) readNDJson(body io.Reader) error {
+	scanner := bufio.NewScanner(body)
+	row := 1
+	for ; scanner.Scan(); row++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			row--
+			continue
+		}
+		pet := data.Pet{}
+		decoder := json.NewDecoder(strings.NewReader(line))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&pet); err != nil {
+			msg := invalidRowJson
+			if _, isValueErr := err.(*json.UnmarshalTypeError); isValueErr || isUnknownField(err) {
+				msg = jsonRowError(err)
+			}
+			pi.fail(row, []string{msg})
+			continue
+		}
+		pi.add(row, pet)
+	}
+	if err := scanner.Err(); err != nil {
+		pi.stop(row, err.Error())
+	}
+	return nil
+}
+
+func csvColumns(header []string) (map[string]int, bool) {
+	known := make(map[string]bool, len(csvHeader))
+	for _, name := range csvHeader {
+		known[strings.ToLower(name)] = true
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !known[name] {
+			return nil, false
+		}
+		columns[name] = i
+	}
+	for _, name := range csvRequired {
+		if _, found := columns[name]; !found {
+			return nil, false
+		}
+	}
+	return columns, true
+}
+
+func (pi *petImporter) readCsv(body io.Reader) error {
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return resperr.InvalidResource
+	}
+	columns, valid := csvColumns(header)
+	if !valid {
+		return resperr.InvalidResource
+	}
+	for row := 1; ; row++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if _, isParseErr := err.(*csv.ParseError); !isParseErr {
+				pi.stop(row, err.Error())
+				break
+			}
+			pi.fail(row, []string{invalidRowCsv})
+			continue
+		}
+		if len(record) != len(header) {
+			pi.fail(row, []string{invalidRowCsv})
+			continue
+		}
+		pet, msg := csvPet(record, columns)
+		if len(msg) != 0 {
+			pi.fail(row, msg)
+			continue
+		}
+		pi.add(row, pet)
+	}
+	return nil
+}
+
+func (h petIOHandler) importPets(w http.ResponseWriter, r *http.Request) error {
+	if r.Body == nil {
+		return resperr.NotBodyProvided
+	}
+	format, found := importFormat(r.Header.Get(constants.ContentType))
+	if !found {
+		return resperr.UnsupportedType
+	}
+
+	pi := petImporter{
+		data:    h.data,
+		owners:  make(map[int]bool),
+		report:  importReport{Rows: make([]importRow, 0)},
+		pending: make([]data.Pet, 0, importBatchSize),
+		rows:    make([]int, 0, importBatchSize),
+	}
+
+	var err error = nil
+	switch format {
+	case formatJson:
+		err = pi.readJson(r.Body)
+	case formatCsv:
+		err = pi.readCsv(r.Body)
+	default:
+		err = pi.readNDJson(r.Body)
+	}
+	if err != nil {
+		return err
+	}
+	pi.flush()
+
+	status := http.StatusOK
+	if pi.report.Error != "" {
+		log.Printf("Error %v importing pets, stopped after %d imported pets", pi.report.Error, pi.report.Imported)
+		status = http.StatusBadRequest
+	}
+	w.Header().Add(constants.ContentType, constants.ApplicationJsonUtf8)
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	if err := encoder.Encode(pi.report); err != nil {
+		return resperr.WrittenJson
+	}
+	return nil
+}
+
+func (h petIOHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var rErr = resperr.None
+	h.data = h.data.WithContext(r.Context())
+	var err error = nil
+
+	switch {
+	case r.URL.Path == petExportPath && r.Method == http.MethodGet:
+		err = h.exportPets(w, r)
+	case r.URL.Path == petImportPath && r.Method == http.MethodPost:
+		err = h.importPets(w, r)
+	case r.URL.Path == petExportPath || r.URL.Path == petImportPath:
+		err = resperr.BadRequest
+	default:
+		err = resperr.NotFound
+	}
+
+	if err != nil {
+		rErr = resperr.FromError(err)
+	}
+
+	if rErr.Status() != http.StatusOK {
+		log.Printf("Error %v in %s request %q", rErr, r.Method, r.URL.Path)
+	}
+
+	if rErr.Status() != resperr.None.Status() {
+		rErr.Write(w)
+	}
+}
+
+func NewPetIOHandler(store store.PetStore) http.Handler {
+	return petIOHandler{data: store}
+}
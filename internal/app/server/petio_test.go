@@ -0,0 +1,409 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/LearningByExample/go-microservice/internal/_test"
+	"github.com/LearningByExample/go-microservice/internal/app/constants"
+	"github.com/LearningByExample/go-microservice/internal/app/data"
+	"github.com/LearningByExample/go-microservice/internal/app/resperr"
+	"github.com/LearningByExample/go-microservice/internal/app/store"
+)
+
+const (
+	exportFields = "\"status\":\"available\",\"createdAt\":\"2020-05-01T00:00:00Z\",\"updatedAt\":\"2020-05-01T00:00:00Z\""
+	exportOwned  = "\"status\":\"available\",\"ownerId\":3,\"tags\":[\"indoor\",\"calm\"]," +
+		"\"attributes\":{\"color\":\"red\"},\"createdAt\":\"2020-05-01T00:00:00Z\",\"updatedAt\":\"2020-05-01T00:00:00Z\""
+)
+
+var (
+	exportTime  = time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+	exportOwner = 3
+	exportPets  = []data.Pet{
+		{Id: 1, Name: "Fluffy", Race: "dog", Mod: "happy", Status: data.Available,
+			CreatedAt: exportTime, UpdatedAt: exportTime},
+		{Id: 2, Name: "Lion, the cat", Race: "cat", Mod: "brave", Status: data.Available, OwnerId: &exportOwner,
+			Tags: []string{"indoor", "calm"}, Attributes: map[string]string{"color": "red"},
+			CreatedAt: exportTime, UpdatedAt: exportTime},
+	}
+)
+
+func forEachExportPet(fn func(pet data.Pet) error) error {
+	for _, pet := range exportPets {
+		if err := fn(pet); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestPetExport(t *testing.T) {
+	spyStore := _test.NewSpyStore()
+	handler := NewPetIOHandler(&spyStore)
+
+	type testCase struct {
+		name        string
+		accept      string
+		contentType string
+		want        string
+	}
+
+	var cases = []testCase{
+		{
+			name:        "default to ndjson",
+			accept:      "",
+			contentType: constants.ApplicationNDJson,
+			want: "{\"id\":1,\"name\":\"Fluffy\",\"race\":\"dog\",\"mod\":\"happy\"," + exportFields + "}\n" +
+				"{\"id\":2,\"name\":\"Lion, the cat\",\"race\":\"cat\",\"mod\":\"brave\"," + exportOwned + "}\n",
+		},
+		{
+			name:        "export csv",
+			accept:      "text/csv",
+			contentType: constants.TextCsvUtf8,
+			want: "id,name,race,mod,status,ownerId,tags,attributes,createdAt,updatedAt\n" +
+				"1,Fluffy,dog,happy,available,,,,2020-05-01T00:00:00Z,2020-05-01T00:00:00Z\n" +
+				"2,\"Lion, the cat\",cat,brave,available,3,indoor;calm,\"{\"\"color\"\":\"\"red\"\"}\"," +
+				"2020-05-01T00:00:00Z,2020-05-01T00:00:00Z\n",
+		},
+		{
+			name:        "export json",
+			accept:      "application/xml, application/json;q=0.9",
+			contentType: constants.ApplicationJsonUtf8,
+			want: "[{\"id\":1,\"name\":\"Fluffy\",\"race\":\"dog\",\"mod\":\"happy\"," + exportFields + "}\n," +
+				"{\"id\":2,\"name\":\"Lion, the cat\",\"race\":\"cat\",\"mod\":\"brave\"," + exportOwned + "}\n]",
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			spyStore.Reset()
+			spyStore.WhenForEachPet(forEachExportPet)
+
+			response := _test.HeaderRequest(handler, petExportPath, http.MethodGet, "",
+				map[string]string{constants.Accept: tt.accept})
+
+			if response.Code != http.StatusOK {
+				t.Fatalf("got %v, want %v", response.Code, http.StatusOK)
+			}
+			if got := response.Header().Get(constants.ContentType); got != tt.contentType {
+				t.Fatalf("got %q, want %q", got, tt.contentType)
+			}
+			if got := response.Body.String(); got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+			if !spyStore.ForEachWasCall {
+				t.Fatal("for each was not called")
+			}
+		})
+	}
+}
+
+func TestPetExportErrors(t *testing.T) {
+	spyStore := _test.NewSpyStore()
+	handler := NewPetIOHandler(&spyStore)
+
+	t.Run("should fail with not acceptable", func(t *testing.T) {
+		spyStore.Reset()
+		response := _test.HeaderRequest(handler, petExportPath, http.MethodGet, "",
+			map[string]string{constants.Accept: "application/xml"})
+		_test.AssertResponseError(t, response, resperr.NotAcceptable)
+	})
+
+	t.Run("should fail with store error", func(t *testing.T) {
+		spyStore.Reset()
+		spyStore.WhenForEachPet(func(fn func(pet data.Pet) error) error {
+			return mockError
+		})
+		response := _test.GetRequest(handler, petExportPath)
+		_test.AssertResponseError(t, response, resperr.FromError(mockError))
+	})
+
+	t.Run("should fail with invalid method", func(t *testing.T) {
+		spyStore.Reset()
+		response := _test.PostRequest(handler, petExportPath, nil)
+		_test.AssertResponseError(t, response, resperr.BadRequest)
+	})
+}
+
+func assertImportReport(t *testing.T, body string, want importReport) {
+	t.Helper()
+
+	got := importReport{}
+	if err := json.NewDecoder(strings.NewReader(body)).Decode(&got); err != nil {
+		t.Fatalf("got error, %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestPetImport(t *testing.T) {
+	spyStore := _test.NewSpyStore()
+	handler := NewPetIOHandler(&spyStore)
+
+	wantPets := []data.Pet{
+		{Name: "Fluffy", Race: "dog", Mod: "happy"},
+		{Name: "Lion", Race: "cat", Mod: "brave"},
+	}
+	wantReport := importReport{
+		Total:    3,
+		Imported: 2,
+		Failed:   1,
+		Rows: []importRow{
+			{Row: 1, Status: rowImported},
+			{Row: 2, Status: rowFailed, Errors: []string{petRaceNotEmpty}},
+			{Row: 3, Status: rowImported},
+		},
+	}
+
+	type testCase struct {
+		name        string
+		contentType string
+		body        string
+	}
+
+	var cases = []testCase{
+		{
+			name:        "import json",
+			contentType: constants.ApplicationJson,
+			body: `[{"name":"Fluffy","race":"dog","mod":"happy"},{"name":"Bad","race":"","mod":"sad"},
+				{"id":7,"name":"Lion","race":"cat","mod":"brave"}]`,
+		},
+		{
+			name:        "import ndjson",
+			contentType: constants.ApplicationNDJson,
+			body: "{\"name\":\"Fluffy\",\"race\":\"dog\",\"mod\":\"happy\"}\n" +
+				"{\"name\":\"Bad\",\"race\":\"\",\"mod\":\"sad\"}\n\n" +
+				"{\"name\":\"Lion\",\"race\":\"cat\",\"mod\":\"brave\"}\n",
+		},
+		{
+			name:        "import csv",
+			contentType: constants.TextCsvUtf8,
+			body:        "mod,name,race\nhappy,Fluffy,dog\nsad,Bad,\nbrave,Lion,cat\n",
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			spyStore.Reset()
+			response := _test.HeaderRequest(handler, petImportPath, http.MethodPost, tt.body,
+				map[string]string{constants.ContentType: tt.contentType})
+
+			if response.Code != http.StatusOK {
+				t.Fatalf("got %v, want %v", response.Code, http.StatusOK)
+			}
+			assertImportReport(t, response.Body.String(), wantReport)
+			if !reflect.DeepEqual(spyStore.ImportedPets, wantPets) {
+				t.Fatalf("got %v, want %v", spyStore.ImportedPets, wantPets)
+			}
+		})
+	}
+}
+
+func TestPetImportFields(t *testing.T) {
+	spyStore := _test.NewSpyStore()
+	handler := NewPetIOHandler(&spyStore)
+	spyStore.WhenGetOwner(func(id int) (data.Owner, error) {
+		if id == exportOwner {
+			return data.Owner{Id: id}, nil
+		}
+		return data.Owner{}, store.OwnerNotFound
+	})
+
+	spyStore.WhenForEachPet(forEachExportPet)
+	exported := _test.HeaderRequest(handler, petExportPath, http.MethodGet, "",
+		map[string]string{constants.Accept: constants.TextCsv})
+
+	t.Run("should import all the exported fields", func(t *testing.T) {
+		spyStore.ImportedPets = nil
+		response := _test.HeaderRequest(handler, petImportPath, http.MethodPost, exported.Body.String(),
+			map[string]string{constants.ContentType: constants.TextCsv})
+
+		if response.Code != http.StatusOK {
+			t.Fatalf("got %v, want %v", response.Code, http.StatusOK)
+		}
+		want := make([]data.Pet, 0, len(exportPets))
+		for _, pet := range exportPets {
+			pet.Id = 0
+			want = append(want, pet)
+		}
+		if !reflect.DeepEqual(spyStore.ImportedPets, want) {
+			t.Fatalf("got %v, want %v", spyStore.ImportedPets, want)
+		}
+	})
+
+	t.Run("should fail the rows with fields that can not be imported", func(t *testing.T) {
+		spyStore.ImportedPets, spyStore.ImportWasCall = nil, false
+		body := `[{"name":"Fluffy","race":"dog","mod":"happy","color":"red"},` +
+			`{"name":"Fluffy","race":"dog","mod":"happy","status":"lost","ownerId":5,"tags":["Big Dog"]},` +
+			`{"name":"Fluffy","race":"dog","mod":"happy","deletedAt":"2020-05-01T00:00:00Z"}]`
+		response := _test.HeaderRequest(handler, petImportPath, http.MethodPost, body,
+			map[string]string{constants.ContentType: constants.ApplicationJson})
+
+		assertImportReport(t, response.Body.String(), importReport{
+			Total:  3,
+			Failed: 3,
+			Rows: []importRow{
+				{Row: 1, Status: rowFailed, Errors: []string{"unknown field \"color\""}},
+				{Row: 2, Status: rowFailed, Errors: []string{petStatusNotValid, fmt.Sprintf(tagNotValid, "big dog"),
+					ownerNotValid}},
+				{Row: 3, Status: rowFailed, Errors: []string{deletedRow}},
+			},
+		})
+		if spyStore.ImportWasCall {
+			t.Fatal("import should not be called")
+		}
+	})
+
+	t.Run("should fail csv with unknown columns", func(t *testing.T) {
+		response := _test.HeaderRequest(handler, petImportPath, http.MethodPost, "name,race,mod,color\n",
+			map[string]string{constants.ContentType: constants.TextCsv})
+		_test.AssertResponseError(t, response, resperr.InvalidResource)
+	})
+}
+
+func TestPetImportRowErrors(t *testing.T) {
+	spyStore := _test.NewSpyStore()
+	handler := NewPetIOHandler(&spyStore)
+
+	t.Run("should report invalid rows", func(t *testing.T) {
+		spyStore.Reset()
+		body := "name,race,mod\nFluffy,dog\n"
+		response := _test.HeaderRequest(handler, petImportPath, http.MethodPost, body,
+			map[string]string{constants.ContentType: constants.TextCsv})
+
+		assertImportReport(t, response.Body.String(), importReport{
+			Total:  1,
+			Failed: 1,
+			Rows:   []importRow{{Row: 1, Status: rowFailed, Errors: []string{invalidRowCsv}}},
+		})
+		if spyStore.ImportWasCall {
+			t.Fatal("import should not be called")
+		}
+	})
+
+	t.Run("should report store errors per row", func(t *testing.T) {
+		spyStore.Reset()
+		storeErr := errors.New("store error")
+		spyStore.WhenImportPets(func(pets []data.Pet) error {
+			return storeErr
+		})
+		body := "{\"name\":\"Fluffy\",\"race\":\"dog\",\"mod\":\"happy\"}\nnot json\n"
+		response := _test.HeaderRequest(handler, petImportPath, http.MethodPost, body,
+			map[string]string{constants.ContentType: constants.ApplicationNDJson})
+
+		assertImportReport(t, response.Body.String(), importReport{
+			Total:  2,
+			Failed: 2,
+			Rows: []importRow{
+				{Row: 1, Status: rowFailed, Errors: []string{storeErr.Error()}},
+				{Row: 2, Status: rowFailed, Errors: []string{invalidRowJson}},
+			},
+		})
+	})
+}
+
+func TestPetImportStopped(t *testing.T) {
+	spyStore := _test.NewSpyStore()
+	handler := NewPetIOHandler(&spyStore)
+
+	body := `[{"name":"Fluffy","race":"dog","mod":"happy"},{"name":"Bad","race":"","mod":"sad"},{"name":]`
+	response := _test.HeaderRequest(handler, petImportPath, http.MethodPost, body,
+		map[string]string{constants.ContentType: constants.ApplicationJson})
+
+	if response.Code != http.StatusBadRequest {
+		t.Fatalf("got %v, want %v", response.Code, http.StatusBadRequest)
+	}
+	assertImportReport(t, response.Body.String(), importReport{
+		Total:    3,
+		Imported: 1,
+		Failed:   2,
+		Error:    invalidRowJson,
+		Rows: []importRow{
+			{Row: 1, Status: rowImported},
+			{Row: 2, Status: rowFailed, Errors: []string{petRaceNotEmpty}},
+			{Row: 3, Status: rowFailed, Errors: []string{invalidRowJson}},
+		},
+	})
+	want := []data.Pet{{Name: "Fluffy", Race: "dog", Mod: "happy"}}
+	if !reflect.DeepEqual(spyStore.ImportedPets, want) {
+		t.Fatalf("got %v, want the pets before the failing row %v", spyStore.ImportedPets, want)
+	}
+}
+
+func TestPetImportErrors(t *testing.T) {
+	spyStore := _test.NewSpyStore()
+	handler := NewPetIOHandler(&spyStore)
+
+	type testCase struct {
+		name        string
+		contentType string
+		body        string
+		want        resperr.ResponseError
+	}
+
+	var cases = []testCase{
+		{
+			name:        "no body",
+			contentType: constants.ApplicationJson,
+			body:        "",
+			want:        resperr.NotBodyProvided,
+		},
+		{
+			name:        "unsupported type",
+			contentType: "application/xml",
+			body:        "<pets/>",
+			want:        resperr.UnsupportedType,
+		},
+		{
+			name:        "invalid json",
+			contentType: constants.ApplicationJson,
+			body:        "{",
+			want:        resperr.InvalidResource,
+		},
+		{
+			name:        "invalid csv header",
+			contentType: constants.TextCsv,
+			body:        "id,name\n1,Fluffy\n",
+			want:        resperr.InvalidResource,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			spyStore.Reset()
+			response := _test.HeaderRequest(handler, petImportPath, http.MethodPost, tt.body,
+				map[string]string{constants.ContentType: tt.contentType})
+			_test.AssertResponseError(t, response, tt.want)
+		})
+	}
+}
@@ -0,0 +1,242 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/LearningByExample/go-microservice/internal/app/blob"
+	"github.com/LearningByExample/go-microservice/internal/app/config"
+	"github.com/LearningByExample/go-microservice/internal/app/constants"
+	"github.com/LearningByExample/go-microservice/internal/app/policy"
+	"github.com/LearningByExample/go-microservice/internal/app/resperr"
+	"github.com/LearningByExample/go-microservice/internal/app/store"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	photoResource    = "photo"
+	photoField       = "photo"
+	sizeParam        = "size"
+	photoOriginal    = "original"
+	photoThumb       = "thumb"
+	photoCache       = "private, no-cache"
+	photoNotValid    = "photo is not a valid image"
+	photoTooLarge    = "photo cannot have more than %d pixels"
+	multipartReserve = 1 << 20
+	jpegQuality      = 85
+)
+
+type photoService struct {
+	blobs     blob.Store
+	layout    string
+	maxSize   int64
+	maxPixels int
+	thumbSize int
+	// removeOnDelete removes the photos when the pet is deleted, as without purge nothing would remove them later
+	removeOnDelete bool
+}
+
+func (p photoService) key(id int, size string) string {
+	return strings.NewReplacer(config.PhotoIdVar, strconv.Itoa(id), config.PhotoSizeVar, size).Replace(p.layout)
+}
+
+func (p photoService) remove(id int) {
+	for _, size := range []string{photoOriginal, photoThumb} {
+		if err := p.blobs.Delete(p.key(id, size)); err != nil {
+			log.Printf("Error %v removing photo %q of pet %d", err, size, id)
+		}
+	}
+}
+
+func thumbnail(src image.Image, max int) image.Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= max && h <= max {
+		return src
+	}
+
+	tw, th := max, max
+	if w > h {
+		th = h * max / w
+	} else {
+		tw = w * max / h
+	}
+	if tw < 1 {
+		tw = 1
+	}
+	if th < 1 {
+		th = 1
+	}
+
+	dst := image.NewRGBA64(image.Rect(0, 0, tw, th))
+	for y := 0; y < th; y++ {
+		y0, y1 := b.Min.Y+y*h/th, b.Min.Y+(y+1)*h/th
+		for x := 0; x < tw; x++ {
+			x0, x1 := b.Min.X+x*w/tw, b.Min.X+(x+1)*w/tw
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, bl, a, n = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca), n+1
+				}
+			}
+			dst.SetRGBA64(x, y, color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(bl / n), A: uint16(a / n)})
+		}
+	}
+	return dst
+}
+
+func encodeImage(w io.Writer, img image.Image, contentType string) error {
+	if contentType == constants.ImagePng {
+		return png.Encode(w, img)
+	}
+	return jpeg.Encode(w, img, &jpeg.Options{Quality: jpegQuality})
+}
+
+func (p photoService) readUpload(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get(constants.ContentType)); err != nil ||
+		mediaType != constants.MultipartFormData {
+		return nil, resperr.UnsupportedType
+	}
+	if r.ContentLength > p.maxSize+multipartReserve {
+		return nil, resperr.TooLarge
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, p.maxSize+multipartReserve)
+
+	file, header, err := r.FormFile(photoField)
+	if err != nil {
+		return nil, resperr.BadRequest
+	}
+	//noinspection GoUnhandledErrorResult
+	defer file.Close()
+	if header.Size > p.maxSize {
+		return nil, resperr.TooLarge
+	}
+	return ioutil.ReadAll(io.LimitReader(file, p.maxSize))
+}
+
+func (p photoService) save(id int, content []byte) error {
+	contentType := http.DetectContentType(content)
+	if contentType != constants.ImageJpeg && contentType != constants.ImagePng {
+		return resperr.UnsupportedType
+	}
+	// the size is checked before decoding, a small file could be a huge image that does not fit in memory
+	size, _, err := image.DecodeConfig(bytes.NewReader(content))
+	if err != nil {
+		return resperr.FromErrorMessage(resperr.InvalidResource, []string{photoNotValid})
+	}
+	if int64(size.Width)*int64(size.Height) > int64(p.maxPixels) {
+		return resperr.FromErrorMessage(resperr.InvalidResource, []string{fmt.Sprintf(photoTooLarge, p.maxPixels)})
+	}
+	img, _, err := image.Decode(bytes.NewReader(content))
+	if err != nil {
+		return resperr.FromErrorMessage(resperr.InvalidResource, []string{photoNotValid})
+	}
+
+	var thumb bytes.Buffer
+	if err = encodeImage(&thumb, thumbnail(img, p.thumbSize), contentType); err == nil {
+		if err = p.blobs.Put(p.key(id, photoOriginal), bytes.NewReader(content)); err == nil {
+			err = p.blobs.Put(p.key(id, photoThumb), &thumb)
+		}
+	}
+	return err
+}
+
+// photoPet checks that the pet of a photo is not deleted, getting it authorizes the read, and authorizes any other
+// action on the photo when the store has a policy, as the photos are kept outside the store
+func (s petHandler) photoPet(r *http.Request, id int, action string) error {
+	ps := s.dataFor(r)
+	pet, err := ps.GetPet(id)
+	if err == store.PetNotFound {
+		return resperr.NotFound
+	}
+	if authorizer, ok := ps.(policy.Authorizer); ok && err == nil && action != policy.ActionRead {
+		err = authorizer.AuthorizePet(pet, action, []string{policy.FieldPhoto})
+	}
+	return err
+}
+
+func (s petHandler) putPhotoRequest(w http.ResponseWriter, r *http.Request, id int) error {
+	if err := s.photoPet(r, id, policy.ActionUpdate); err != nil {
+		return err
+	}
+
+	content, err := s.photos.readUpload(w, r)
+	if err == nil {
+		if err = s.photos.save(id, content); err == nil {
+			w.Header().Add(constants.ContentType, constants.ApplicationJsonUtf8)
+			w.WriteHeader(http.StatusOK)
+		}
+	}
+	return err
+}
+
+func (s petHandler) getPhotoRequest(w http.ResponseWriter, r *http.Request, id int) error {
+	size := r.URL.Query().Get(sizeParam)
+	switch size {
+	case "":
+		size = photoOriginal
+	case photoOriginal, photoThumb:
+	default:
+		return resperr.InvalidUrl
+	}
+	if err := s.photoPet(r, id, policy.ActionRead); err != nil {
+		return err
+	}
+
+	content, info, err := s.photos.blobs.Get(s.photos.key(id, size))
+	if err == blob.NotFound {
+		return resperr.NotFound
+	} else if err != nil {
+		return err
+	}
+	//noinspection GoUnhandledErrorResult
+	defer content.Close()
+
+	// photos are only cached by the client and revalidated with the ETag, so a new photo is never served stale
+	w.Header().Set(constants.CacheControl, photoCache)
+	w.Header().Set(constants.ETag, fmt.Sprintf(`"%x-%x"`, info.ModTime.UnixNano(), info.Size))
+	http.ServeContent(w, r, "", info.ModTime, content)
+	return nil
+}
+
+func newPhotoService(cfg config.PhotosCfg) *photoService {
+	return &photoService{
+		blobs:     blob.NewFileStore(cfg.Path),
+		layout:    cfg.Layout,
+		maxSize:   cfg.MaxSize,
+		maxPixels: cfg.MaxPixels,
+		thumbSize: cfg.ThumbSize,
+	}
+}
@@ -0,0 +1,396 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/LearningByExample/go-microservice/internal/_test"
+	"github.com/LearningByExample/go-microservice/internal/app/blob"
+	"github.com/LearningByExample/go-microservice/internal/app/config"
+	"github.com/LearningByExample/go-microservice/internal/app/constants"
+	"github.com/LearningByExample/go-microservice/internal/app/data"
+	"github.com/LearningByExample/go-microservice/internal/app/policy"
+	"github.com/LearningByExample/go-microservice/internal/app/reqctx"
+	"github.com/LearningByExample/go-microservice/internal/app/resperr"
+	"github.com/LearningByExample/go-microservice/internal/app/store"
+	"github.com/LearningByExample/go-microservice/internal/app/store/memory"
+)
+
+func testImage(w int, h int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 100, A: 255})
+		}
+	}
+	return img
+}
+
+func encodedImage(t *testing.T, contentType string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := encodeImage(&buf, testImage(300, 200), contentType); err != nil {
+		t.Fatalf("error encoding image %v", err)
+	}
+	return buf.Bytes()
+}
+
+// largeImage is a small file of an image too large to be decoded
+func largeImage(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 2000, 2000))); err != nil {
+		t.Fatalf("error encoding image %v", err)
+	}
+	return buf.Bytes()
+}
+
+func photoRequest(handler http.Handler, url string, content []byte) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, _ := writer.CreateFormFile(photoField, "photo")
+	_, _ = part.Write(content)
+	_ = writer.Close()
+
+	return _test.HeaderRequest(handler, url, http.MethodPut, body.String(),
+		map[string]string{constants.ContentType: writer.FormDataContentType()})
+}
+
+func newTestPhotoService(t *testing.T) (*photoService, string) {
+	t.Helper()
+	root, err := ioutil.TempDir("", "photos")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	return newPhotoService(config.PhotosCfg{Path: root, Layout: "pets/{id}/{size}", MaxSize: 64 << 10,
+		MaxPixels: 1 << 20, ThumbSize: 128}), root
+}
+
+func TestThumbnail(t *testing.T) {
+	type testCase struct {
+		name  string
+		w     int
+		h     int
+		wantW int
+		wantH int
+	}
+
+	var cases = []testCase{
+		{name: "landscape", w: 300, h: 200, wantW: 128, wantH: 85},
+		{name: "portrait", w: 200, h: 400, wantW: 64, wantH: 128},
+		{name: "small", w: 100, h: 50, wantW: 100, wantH: 50},
+		{name: "thin", w: 1000, h: 1, wantW: 128, wantH: 1},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			b := thumbnail(testImage(tt.w, tt.h), 128).Bounds()
+			if b.Dx() != tt.wantW || b.Dy() != tt.wantH {
+				t.Fatalf("got %dx%d, want %dx%d", b.Dx(), b.Dy(), tt.wantW, tt.wantH)
+			}
+		})
+	}
+}
+
+func TestPhotoRequests(t *testing.T) {
+	spyStore := _test.NewSpyStore()
+	photos, root := newTestPhotoService(t)
+	//noinspection GoUnhandledErrorResult
+	defer os.RemoveAll(root)
+	handler := newPetHandler(&spyStore, photos)
+
+	t.Run("should upload a png photo", func(t *testing.T) {
+		spyStore.Reset()
+
+		response := photoRequest(handler, "/pets/3/photo", encodedImage(t, constants.ImagePng))
+
+		_test.AssertResponseError(t, response, resperr.None)
+		r, _, err := photos.blobs.Get("pets/3/thumb")
+		if err != nil {
+			t.Fatalf("want thumbnail, got %v", err)
+		}
+		//noinspection GoUnhandledErrorResult
+		defer r.Close()
+		thumb, format, err := image.Decode(r)
+		if err != nil || format != "png" || thumb.Bounds().Dx() != 128 {
+			t.Fatalf("got %s thumbnail %v and %v, want png of 128 pixels", format, thumb.Bounds(), err)
+		}
+	})
+
+	t.Run("should serve the thumbnail with caching headers", func(t *testing.T) {
+		spyStore.Reset()
+
+		response := _test.GetRequest(handler, "/pets/3/photo?size=thumb")
+
+		if response.Code != http.StatusOK {
+			t.Fatalf("got %d, want %d", response.Code, http.StatusOK)
+		}
+		if got := response.Header().Get(constants.ContentType); got != constants.ImagePng {
+			t.Fatalf("got content type %q, want %q", got, constants.ImagePng)
+		}
+		if got := response.Header().Get(constants.CacheControl); got != photoCache {
+			t.Fatalf("got cache control %q, want %q", got, photoCache)
+		}
+
+		etag := response.Header().Get(constants.ETag)
+		cached := _test.HeaderRequest(handler, "/pets/3/photo?size=thumb", http.MethodGet, "",
+			map[string]string{"If-None-Match": etag})
+		if etag == "" || cached.Code != http.StatusNotModified {
+			t.Fatalf("got %d for etag %q, want %d", cached.Code, etag, http.StatusNotModified)
+		}
+	})
+
+	t.Run("should upload a jpeg photo", func(t *testing.T) {
+		spyStore.Reset()
+		content := encodedImage(t, constants.ImageJpeg)
+
+		response := photoRequest(handler, "/pets/4/photo", content)
+
+		_test.AssertResponseError(t, response, resperr.None)
+		original := _test.GetRequest(handler, "/pets/4/photo")
+		if !bytes.Equal(original.Body.Bytes(), content) {
+			t.Fatal("want original photo content")
+		}
+		if _, err := jpeg.Decode(_test.GetRequest(handler, "/pets/4/photo?size=thumb").Body); err != nil {
+			t.Fatalf("want jpeg thumbnail, got %v", err)
+		}
+	})
+
+	t.Run("should keep photos when deleting the pet", func(t *testing.T) {
+		spyStore.Reset()
+
+		_ = _test.DeleteRequest(handler, "/pets/4")
+
+		for _, size := range []string{photoOriginal, photoThumb} {
+			if _, _, err := photos.blobs.Get(photos.key(4, size)); err != nil {
+				t.Fatalf("want photo %s, got %v", size, err)
+			}
+		}
+	})
+
+	t.Run("should remove photos when deleting the pet without purge", func(t *testing.T) {
+		spyStore.Reset()
+		photos.removeOnDelete = true
+		defer func() {
+			photos.removeOnDelete = false
+		}()
+
+		_ = _test.DeleteRequest(handler, "/pets/4")
+
+		for _, size := range []string{photoOriginal, photoThumb} {
+			if _, _, err := photos.blobs.Get(photos.key(4, size)); err != blob.NotFound {
+				t.Fatalf("got %v for %s, want %v", err, size, blob.NotFound)
+			}
+		}
+	})
+
+	t.Run("should not remove photos when delete fails", func(t *testing.T) {
+		spyStore.Reset()
+		spyStore.WhenDeletePet(func(id int) error {
+			return store.PetNotFound
+		})
+		photos.removeOnDelete = true
+		defer func() {
+			photos.removeOnDelete = false
+		}()
+
+		_ = _test.DeleteRequest(handler, "/pets/3")
+
+		if _, _, err := photos.blobs.Get(photos.key(3, photoOriginal)); err != nil {
+			t.Fatalf("want photo, got %v", err)
+		}
+	})
+
+	type testCase struct {
+		name    string
+		path    string
+		method  string
+		content []byte
+		getPet  func(id int) (data.Pet, error)
+		want    resperr.ResponseError
+	}
+
+	var cases = []testCase{
+		{
+			name:    "should reject a text file",
+			path:    "/pets/3/photo",
+			method:  http.MethodPut,
+			content: []byte("this is not an image"),
+			want:    resperr.UnsupportedType,
+		},
+		{
+			name:    "should reject a broken image",
+			path:    "/pets/3/photo",
+			method:  http.MethodPut,
+			content: encodedImage(t, constants.ImagePng)[:100],
+			want:    resperr.FromErrorMessage(resperr.InvalidResource, []string{photoNotValid}),
+		},
+		{
+			name:    "should reject a large photo",
+			path:    "/pets/3/photo",
+			method:  http.MethodPut,
+			content: make([]byte, 65<<10),
+			want:    resperr.TooLarge,
+		},
+		{
+			name:    "should reject a photo with too many pixels",
+			path:    "/pets/3/photo",
+			method:  http.MethodPut,
+			content: largeImage(t),
+			want:    resperr.FromErrorMessage(resperr.InvalidResource, []string{fmt.Sprintf(photoTooLarge, 1<<20)}),
+		},
+		{
+			name:    "should not found the pet",
+			path:    "/pets/3/photo",
+			method:  http.MethodPut,
+			content: encodedImage(t, constants.ImagePng),
+			getPet: func(id int) (data.Pet, error) {
+				return data.Pet{}, store.PetNotFound
+			},
+			want: resperr.NotFound,
+		},
+		{
+			name:   "should reject a request that is not multipart",
+			path:   "/pets/3/photo",
+			method: http.MethodPut,
+			want:   resperr.UnsupportedType,
+		},
+		{
+			name:   "should not found a missing photo",
+			path:   "/pets/5/photo",
+			method: http.MethodGet,
+			want:   resperr.NotFound,
+		},
+		{
+			name:   "should fail with an invalid size",
+			path:   "/pets/3/photo?size=huge",
+			method: http.MethodGet,
+			want:   resperr.InvalidUrl,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			spyStore.Reset()
+			if tt.getPet != nil {
+				spyStore.WhenGetPet(tt.getPet)
+			}
+
+			var response *httptest.ResponseRecorder
+			if tt.content != nil {
+				response = photoRequest(handler, tt.path, tt.content)
+			} else {
+				response = _test.HeaderRequest(handler, tt.path, tt.method, "", nil)
+			}
+
+			_test.AssertResponseError(t, response, tt.want)
+		})
+	}
+}
+
+func TestPhotoPolicy(t *testing.T) {
+	pol, err := policy.Parse([]byte(`{"roles": {"owner": [{"actions": ["read", "update"], "when": "owner"}]}}`))
+	if err != nil {
+		t.Fatalf("want not error, got %v", err)
+	}
+	ps := memory.NewInMemoryPetStore(config.CfgData{})
+	owners := ps.(store.OwnerStore)
+	owner, _ := owners.AddOwner("Ann", "ann@example.com")
+	other, _ := owners.AddOwner("Bob", "bob@example.com")
+	own, _ := ps.AddPet("Fluff", "dog", "happy")
+	foreign, _ := ps.AddPet("Lion", "cat", "brave")
+	deleted, _ := ps.AddPet("Snow", "mouse", "shy")
+	_, _ = owners.SetPetOwner(own, owner)
+	_, _ = owners.SetPetOwner(foreign, other)
+	_, _ = owners.SetPetOwner(deleted, owner)
+
+	photos, root := newTestPhotoService(t)
+	//noinspection GoUnhandledErrorResult
+	defer os.RemoveAll(root)
+	for _, id := range []int{foreign, deleted} {
+		if err := photos.save(id, encodedImage(t, constants.ImagePng)); err != nil {
+			t.Fatalf("error saving photo %v", err)
+		}
+	}
+	_ = ps.DeletePet(deleted)
+
+	protected := newPetHandler(policy.NewStore(ps, pol), photos)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := reqctx.WithGrants(reqctx.WithActor(r.Context(), "ann"),
+			data.Principal{Subject: "ann", Roles: []string{"owner"}, OwnerId: owner})
+		protected.ServeHTTP(w, r.WithContext(ctx))
+	})
+
+	type testCase struct {
+		name string
+		id   int
+		put  bool
+		want resperr.ResponseError
+	}
+
+	var cases = []testCase{
+		{name: "should upload the photo of an owned pet", id: own, put: true, want: resperr.None},
+		{name: "should get the photo of an owned pet", id: own, want: resperr.None},
+		{name: "should forbid uploading the photo of another owner pet", id: foreign, put: true, want: resperr.Forbidden},
+		{name: "should forbid getting the photo of another owner pet", id: foreign, want: resperr.Forbidden},
+		{name: "should not upload the photo of a deleted pet", id: deleted, put: true, want: resperr.NotFound},
+		{name: "should not get the photo of a deleted pet", id: deleted, want: resperr.NotFound},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			url := fmt.Sprintf("/pets/%d/photo", tt.id)
+			var response *httptest.ResponseRecorder
+			if tt.put {
+				response = photoRequest(handler, url, encodedImage(t, constants.ImagePng))
+			} else {
+				response = _test.GetRequest(handler, url)
+			}
+
+			if got := response.Code; got != tt.want.Status() {
+				t.Fatalf("got %v, want %v", got, tt.want.Status())
+			}
+		})
+	}
+}
+
+func TestPhotoDisabled(t *testing.T) {
+	spyStore := _test.NewSpyStore()
+	handler := NewPetHandler(&spyStore)
+
+	response := photoRequest(handler, "/pets/3/photo", []byte{})
+
+	_test.AssertResponseError(t, response, resperr.NotFound)
+}
@@ -0,0 +1,104 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package server
+
+import (
+	"github.com/LearningByExample/go-microservice/internal/app/data"
+	"github.com/LearningByExample/go-microservice/internal/app/policy"
+	"github.com/LearningByExample/go-microservice/internal/app/resperr"
+	"net/http"
+	"regexp"
+	"strconv"
+)
+
+type policyHandler struct {
+	petIdPathReg  *regexp.Regexp
+	petSubPathReg *regexp.Regexp
+	policy        policy.Policy
+	next          http.Handler
+}
+
+// subResourceAction gets the action and the fields changed by a request to a pet sub resource
+func subResourceAction(method string, name string) (string, []string) {
+	switch {
+	case method == http.MethodGet || method == http.MethodHead:
+		return policy.ActionRead, nil
+	case name == restoreResource:
+		return policy.ActionDelete, nil
+	case name == transitionsResource:
+		return policy.ActionUpdate, []string{policy.FieldStatus}
+	case name == photoResource:
+		return policy.ActionUpdate, []string{policy.FieldPhoto}
+	case name == ownerResource:
+		return policy.ActionUpdate, []string{policy.FieldOwner}
+	}
+	return policy.ActionUpdate, nil
+}
+
+// request gets the policy request for a pet request, without the pet and the fields that only the store knows
+func (h policyHandler) request(r *http.Request) policy.Request {
+	req := policy.Request{Subject: policy.SubjectFrom(r.Context())}
+	id := 0
+	if matches := h.petSubPathReg.FindStringSubmatch(r.URL.Path); len(matches) == 3 {
+		id, _ = strconv.Atoi(matches[1])
+		req.Action, req.Fields = subResourceAction(r.Method, matches[2])
+	} else {
+		if matches := h.petIdPathReg.FindStringSubmatch(r.URL.Path); len(matches) == 2 {
+			id, _ = strconv.Atoi(matches[1])
+		}
+		switch r.Method {
+		case http.MethodPost:
+			req.Action = policy.ActionCreate
+		case http.MethodPut:
+			req.Action = policy.ActionUpdate
+		case http.MethodDelete:
+			req.Action = policy.ActionDelete
+		default:
+			req.Action = policy.ActionRead
+		}
+	}
+	if id != 0 {
+		req.Pet = &data.Pet{Id: id}
+	}
+	return req
+}
+
+// ServeHTTP denies early the requests that no rule could allow, the store decides the rest once the pet is known
+func (h policyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	req := h.request(r)
+	if decision := h.policy.Permits(req); !decision.Allowed {
+		policy.LogDecision(req, decision)
+		resperr.FromError(decision.Err()).Write(w)
+		return
+	}
+	h.next.ServeHTTP(w, r)
+}
+
+func withPolicy(pol policy.Policy, next http.Handler) http.Handler {
+	return policyHandler{
+		petIdPathReg:  regexp.MustCompile(petIdExpr),
+		petSubPathReg: regexp.MustCompile(petSubExpr),
+		policy:        pol,
+		next:          next,
+	}
+}
@@ -0,0 +1,155 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/LearningByExample/go-microservice/internal/_test"
+	"github.com/LearningByExample/go-microservice/internal/app/data"
+	"github.com/LearningByExample/go-microservice/internal/app/policy"
+	"github.com/LearningByExample/go-microservice/internal/app/reqctx"
+	"github.com/LearningByExample/go-microservice/internal/app/resperr"
+)
+
+func TestPolicyHandler(t *testing.T) {
+	pol, err := policy.Parse([]byte(`{"roles": {
+		"volunteer": [{"actions": ["read"]}, {"actions": ["update"], "fields": ["mod"]}],
+		"owner": [{"actions": ["read", "update"], "when": "owner"}]
+	}}`))
+	if err != nil {
+		t.Fatalf("want not error, got %v", err)
+	}
+	called := false
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		w.WriteHeader(http.StatusOK)
+	})
+	handlerFor := func(roles ...string) http.Handler {
+		protected := withPolicy(pol, next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := reqctx.WithGrants(reqctx.WithActor(r.Context(), "john"), data.Principal{Subject: "john", Roles: roles})
+			protected.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+
+	type testCase struct {
+		name   string
+		roles  []string
+		method string
+		url    string
+		want   resperr.ResponseError
+	}
+
+	var cases = []testCase{
+		{
+			name:   "should allow reading pets",
+			roles:  []string{"volunteer"},
+			method: http.MethodGet,
+			url:    "/pets/1/history",
+			want:   resperr.None,
+		},
+		{
+			name:   "should allow updating a pet leaving the fields to the store",
+			roles:  []string{"volunteer"},
+			method: http.MethodPut,
+			url:    "/pets/1",
+			want:   resperr.None,
+		},
+		{
+			name:   "should allow a request that an owner rule could allow",
+			roles:  []string{"owner"},
+			method: http.MethodPut,
+			url:    "/pets/1",
+			want:   resperr.None,
+		},
+		{
+			name:   "should forbid creating pets",
+			roles:  []string{"volunteer"},
+			method: http.MethodPost,
+			url:    "/pets",
+			want:   resperr.Forbidden,
+		},
+		{
+			name:   "should forbid restoring pets",
+			roles:  []string{"volunteer"},
+			method: http.MethodPost,
+			url:    "/pets/1/restore",
+			want:   resperr.Forbidden,
+		},
+		{
+			name:   "should forbid transitions",
+			roles:  []string{"volunteer"},
+			method: http.MethodPost,
+			url:    "/pets/1/transitions",
+			want:   resperr.Forbidden,
+		},
+		{
+			name:   "should forbid a subject without roles",
+			method: http.MethodGet,
+			url:    "/pets",
+			want:   resperr.Forbidden,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			called = false
+			response := _test.HeaderRequest(handlerFor(tt.roles...), tt.url, tt.method, "", nil)
+
+			if got := response.Code; got != tt.want.Status() {
+				t.Fatalf("got %v, want %v", got, tt.want.Status())
+			}
+			if called != (tt.want.Status() == resperr.None.Status()) {
+				t.Fatalf("got called %t, want called only when allowed", called)
+			}
+		})
+	}
+}
+
+func TestSubResourceAction(t *testing.T) {
+	type testCase struct {
+		method string
+		name   string
+		action string
+		fields []string
+	}
+
+	var cases = []testCase{
+		{http.MethodGet, historyResource, policy.ActionRead, nil},
+		{http.MethodPost, restoreResource, policy.ActionDelete, nil},
+		{http.MethodPost, transitionsResource, policy.ActionUpdate, []string{policy.FieldStatus}},
+		{http.MethodPut, photoResource, policy.ActionUpdate, []string{policy.FieldPhoto}},
+		{http.MethodDelete, ownerResource, policy.ActionUpdate, []string{policy.FieldOwner}},
+		{http.MethodPut, tagsResource, policy.ActionUpdate, nil},
+	}
+
+	for _, tt := range cases {
+		action, fields := subResourceAction(tt.method, tt.name)
+		if action != tt.action || len(fields) != len(tt.fields) || (len(fields) == 1 && fields[0] != tt.fields[0]) {
+			t.Fatalf("got %q on %v, want %q on %v for %s %s", action, fields, tt.action, tt.fields, tt.method,
+				tt.name)
+		}
+	}
+}
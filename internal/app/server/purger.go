@@ -0,0 +1,78 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package server
+
+import (
+	"context"
+	"github.com/LearningByExample/go-microservice/internal/app/config"
+	"github.com/LearningByExample/go-microservice/internal/app/store"
+	"log"
+	"time"
+)
+
+type purger struct {
+	ps        store.PetStore
+	photos    *photoService
+	retention time.Duration
+	interval  time.Duration
+	now       func() time.Time
+}
+
+func (p purger) purge() {
+	before := p.now().Add(-p.retention)
+	if ids, err := p.ps.PurgePets(before); err != nil {
+		log.Printf("Error %v purging pets deleted before %v", err, before)
+	} else if len(ids) != 0 {
+		// photos are kept while the pet can be restored, and only removed once it is gone for good
+		if p.photos != nil {
+			for _, id := range ids {
+				p.photos.remove(id)
+			}
+		}
+		log.Printf("Purged %d pets deleted before %v.", len(ids), before)
+	}
+}
+
+func (p purger) run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.purge()
+		}
+	}
+}
+
+func newPurger(cfg config.PurgeCfg, ps store.PetStore, photos *photoService) purger {
+	return purger{
+		ps:        ps,
+		photos:    photos,
+		retention: time.Duration(cfg.Retention) * time.Millisecond,
+		interval:  time.Duration(cfg.Interval) * time.Millisecond,
+		now:       time.Now,
+	}
+}
@@ -0,0 +1,109 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package server
+
+import (
+	"context"
+	"github.com/LearningByExample/go-microservice/internal/_test"
+	"github.com/LearningByExample/go-microservice/internal/app/blob"
+	"github.com/LearningByExample/go-microservice/internal/app/config"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPurger(t *testing.T) {
+	spyStore := _test.NewSpyStore()
+	now := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	photos, root := newTestPhotoService(t)
+	//noinspection GoUnhandledErrorResult
+	defer os.RemoveAll(root)
+	p := newPurger(config.PurgeCfg{Retention: 60000, Interval: 1}, &spyStore, photos)
+	p.now = func() time.Time {
+		return now
+	}
+
+	t.Run("should purge pets deleted before retention", func(t *testing.T) {
+		spyStore.Reset()
+		p.purge()
+
+		if !spyStore.PurgeWasCall {
+			t.Fatal("purge was not called")
+		}
+		want := now.Add(-time.Minute)
+		if !spyStore.PurgeBefore.Equal(want) {
+			t.Fatalf("error purging got %v, want %v", spyStore.PurgeBefore, want)
+		}
+	})
+
+	t.Run("should remove the photos of purged pets", func(t *testing.T) {
+		spyStore.Reset()
+		for _, id := range []int{1, 2} {
+			if err := photos.blobs.Put(photos.key(id, photoOriginal), strings.NewReader("photo")); err != nil {
+				t.Fatalf("error storing photo got %v", err)
+			}
+		}
+		spyStore.WhenPurgePets(func(before time.Time) ([]int, error) {
+			return []int{1}, nil
+		})
+		p.purge()
+
+		if _, _, err := photos.blobs.Get(photos.key(1, photoOriginal)); err != blob.NotFound {
+			t.Fatalf("got %v, want %v", err, blob.NotFound)
+		}
+		if _, _, err := photos.blobs.Get(photos.key(2, photoOriginal)); err != nil {
+			t.Fatalf("want photo, got %v", err)
+		}
+	})
+
+	t.Run("should log purge errors", func(t *testing.T) {
+		spyStore.Reset()
+		spyStore.WhenPurgePets(func(before time.Time) ([]int, error) {
+			return nil, mockError
+		})
+		p.purge()
+
+		if !spyStore.PurgeWasCall {
+			t.Fatal("purge was not called")
+		}
+	})
+
+	t.Run("should stop when context is done", func(t *testing.T) {
+		spyStore.Reset()
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			p.run(ctx)
+			close(done)
+		}()
+		cancel()
+
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("purger did not stop")
+		}
+	})
+}
@@ -0,0 +1,180 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package server
+
+import (
+	"context"
+	"github.com/LearningByExample/go-microservice/internal/app/config"
+	"github.com/LearningByExample/go-microservice/internal/app/constants"
+	"github.com/LearningByExample/go-microservice/internal/app/ratelimit"
+	"github.com/LearningByExample/go-microservice/internal/app/reqctx"
+	"github.com/LearningByExample/go-microservice/internal/app/resperr"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	clientIpPrefix = "ip:"
+)
+
+type rateLimiter struct {
+	rules    *ratelimit.Rules
+	limiter  *ratelimit.Limiter
+	proxies  []*net.IPNet
+	file     string
+	interval time.Duration
+	now      func() time.Time
+}
+
+// watch reloads the rules file until the context is done, it returns at once when there is no file to reload
+func (l rateLimiter) watch(ctx context.Context) {
+	if l.file != "" && l.interval > 0 {
+		l.rules.Watch(l.interval)(ctx)
+	}
+}
+
+func (l rateLimiter) isTrusted(ip net.IP) bool {
+	for _, proxy := range l.proxies {
+		if proxy.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIp gets the address of the client, walking the X-Forwarded-For addresses back from the trusted proxies. The
+// requests of the Unix socket come from a local proxy so they are trusted too.
+func (l rateLimiter) clientIp(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip != nil && !l.isTrusted(ip) {
+		return ip.String()
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header.Values(constants.XForwardedFor), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if hop == nil {
+			break
+		}
+		ip = hop
+		if !l.isTrusted(hop) {
+			break
+		}
+	}
+	if ip == nil {
+		return host
+	}
+	return ip.String()
+}
+
+// client gets the key of the bucket of a request, the API key or principal when it is authenticated
+func (l rateLimiter) client(r *http.Request) string {
+	if actor := reqctx.Actor(r.Context()); actor != reqctx.Anonymous {
+		return actor
+	}
+	return l.address(r)
+}
+
+// address gets the key of the bucket of the address of a request, whether it is authenticated or not
+func (l rateLimiter) address(r *http.Request) string {
+	return clientIpPrefix + l.clientIp(r)
+}
+
+// decide writes the rate limit headers of the bucket that decides a request, rejecting it when the bucket is empty
+func (l rateLimiter) decide(w http.ResponseWriter, r *http.Request, client string, decision ratelimit.Decision) bool {
+	w.Header().Set(constants.RateLimitLimit, strconv.Itoa(decision.Limit))
+	w.Header().Set(constants.RateLimitRemaining, strconv.Itoa(decision.Remaining))
+	w.Header().Set(constants.RateLimitReset, strconv.Itoa(int(decision.Reset.Seconds())))
+	if !decision.Allowed {
+		rErr := resperr.TooManyRequests
+		log.Printf("Error %v in %s request %q by %s", rErr, r.Method, r.URL.Path, client)
+		w.Header().Set(constants.RetryAfter, strconv.Itoa(int(decision.RetryAfter.Seconds())))
+		rErr.Write(w)
+	}
+	return decision.Allowed
+}
+
+// wrap limits the requests by their principal, or by their address when they are not authenticated
+func (l rateLimiter) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rule, found := l.rules.Match(r.Method, r.URL.Path)
+		if !found || rule.IsUnlimited() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		client := l.client(r)
+		if l.decide(w, r, client, l.limiter.Allow(rule, client, l.now())) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// wrapAuth limits the requests around their authentication. The authenticated requests are only limited by their
+// principal, so the clients behind the same address do not share a bucket, and the ones that fail to authenticate by
+// their address, that is checked before authenticating them so the guessed credentials do not reach the store that
+// keeps the keys once it is empty.
+func (l rateLimiter) wrapAuth(auth *authenticator, next http.Handler) http.Handler {
+	limited := l.wrap(next)
+	unlimited := auth.wrap(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rule, found := l.rules.Match(r.Method, r.URL.Path)
+		if !found || rule.IsUnlimited() {
+			unlimited.ServeHTTP(w, r)
+			return
+		}
+		address := l.address(r)
+		if decision := l.limiter.Peek(rule, address, l.now()); !decision.Allowed {
+			l.decide(w, r, address, decision)
+			return
+		}
+		authenticated := false
+		auth.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authenticated = true
+			limited.ServeHTTP(w, r)
+		})).ServeHTTP(w, r)
+		if !authenticated {
+			_ = l.limiter.Allow(rule, address, l.now())
+		}
+	})
+}
+
+func newRateLimiter(cfg config.RateLimitCfg) (*rateLimiter, error) {
+	rules, err := ratelimit.NewRules(cfg.RulesFile, cfg.Rules)
+	limiter := &rateLimiter{
+		rules:    rules,
+		limiter:  ratelimit.NewLimiter(cfg.MaxClients),
+		proxies:  cfg.Proxies(),
+		file:     cfg.RulesFile,
+		interval: time.Duration(cfg.ReloadInterval) * time.Millisecond,
+		now:      time.Now,
+	}
+	return limiter, err
+}
@@ -0,0 +1,220 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/LearningByExample/go-microservice/internal/_test"
+	"github.com/LearningByExample/go-microservice/internal/app/config"
+	"github.com/LearningByExample/go-microservice/internal/app/constants"
+	"github.com/LearningByExample/go-microservice/internal/app/data"
+	"github.com/LearningByExample/go-microservice/internal/app/reqctx"
+	"github.com/LearningByExample/go-microservice/internal/app/resperr"
+	"github.com/LearningByExample/go-microservice/internal/app/store"
+	"github.com/LearningByExample/go-microservice/internal/app/store/memory"
+)
+
+func newTestRateLimiter(t *testing.T) *rateLimiter {
+	t.Helper()
+	limiter, err := newRateLimiter(config.RateLimitCfg{
+		Rules: json.RawMessage(`[
+			{"path":"/pets","methods":["POST"],"rate":1,"burst":1},
+			{"path":"/health/"},
+			{"path":"/","rate":10,"burst":2}
+		]`),
+		MaxClients:     10,
+		TrustedProxies: []string{"10.0.0.0/8"},
+	})
+	if err != nil {
+		t.Fatalf("error creating rate limiter: %v", err)
+	}
+	now := time.Date(2020, 3, 9, 8, 7, 36, 0, time.UTC)
+	limiter.now = func() time.Time {
+		return now
+	}
+	return limiter
+}
+
+func limitedRequest(handler http.Handler, method string, remote string, forwarded string,
+	actor string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, "/pets", nil)
+	request.RemoteAddr = remote
+	if forwarded != "" {
+		request.Header.Set(constants.XForwardedFor, forwarded)
+	}
+	if actor != "" {
+		request = request.WithContext(reqctx.WithActor(request.Context(), actor))
+	}
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, request)
+	return response
+}
+
+func TestRateLimiterClientIp(t *testing.T) {
+	limiter := newTestRateLimiter(t)
+
+	type testCase struct {
+		name      string
+		remote    string
+		forwarded string
+		want      string
+	}
+	cases := []testCase{
+		{name: "remote address", remote: "192.168.1.2:4000", want: "192.168.1.2"},
+		{name: "ignore forwarded from untrusted", remote: "192.168.1.2:4000", forwarded: "1.2.3.4", want: "192.168.1.2"},
+		{name: "forwarded from trusted", remote: "10.0.0.1:4000", forwarded: "1.2.3.4", want: "1.2.3.4"},
+		{name: "skip trusted hops", remote: "10.0.0.1:4000", forwarded: "5.6.7.8, 1.2.3.4, 10.0.0.2", want: "1.2.3.4"},
+		{name: "trusted without forwarded", remote: "10.0.0.1:4000", want: "10.0.0.1"},
+		{name: "invalid hop", remote: "10.0.0.1:4000", forwarded: "1.2.3.4, unknown", want: "10.0.0.1"},
+		{name: "unix socket", remote: "@", forwarded: "1.2.3.4", want: "1.2.3.4"},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/pets", nil)
+			request.RemoteAddr = tt.remote
+			if tt.forwarded != "" {
+				request.Header.Set(constants.XForwardedFor, tt.forwarded)
+			}
+			if got := limiter.clientIp(request); got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	t.Run("should limit with headers", func(t *testing.T) {
+		handler := newTestRateLimiter(t).wrap(ok)
+
+		response := limitedRequest(handler, http.MethodPost, "192.168.1.2:4000", "", "")
+		if response.Code != http.StatusOK || response.Header().Get(constants.RateLimitLimit) != "1" ||
+			response.Header().Get(constants.RateLimitRemaining) != "0" ||
+			response.Header().Get(constants.RateLimitReset) != "1" {
+			t.Fatalf("got %d and %v, want ok with rate limit headers", response.Code, response.Header())
+		}
+
+		response = limitedRequest(handler, http.MethodPost, "192.168.1.2:4000", "", "")
+		_test.AssertResponseError(t, response, resperr.TooManyRequests)
+		if response.Header().Get(constants.RetryAfter) != "1" {
+			t.Fatalf("got %v, want retry after 1 second", response.Header())
+		}
+	})
+
+	t.Run("should limit by client", func(t *testing.T) {
+		handler := newTestRateLimiter(t).wrap(ok)
+
+		_ = limitedRequest(handler, http.MethodPost, "192.168.1.2:4000", "", "")
+		if response := limitedRequest(handler, http.MethodPost, "192.168.1.3:4000", "", ""); response.Code != http.StatusOK {
+			t.Fatalf("got %d, want ok for other address", response.Code)
+		}
+		_ = limitedRequest(handler, http.MethodPost, "192.168.1.2:4000", "", "john")
+		response := limitedRequest(handler, http.MethodPost, "192.168.1.4:4000", "", "john")
+		if response.Code != http.StatusTooManyRequests {
+			t.Fatalf("got %d, want the principal limited from any address", response.Code)
+		}
+	})
+
+	t.Run("should limit by rule", func(t *testing.T) {
+		handler := newTestRateLimiter(t).wrap(ok)
+
+		_ = limitedRequest(handler, http.MethodPost, "192.168.1.2:4000", "", "")
+		response := limitedRequest(handler, http.MethodGet, "192.168.1.2:4000", "", "")
+		if response.Code != http.StatusOK || response.Header().Get(constants.RateLimitLimit) != "2" {
+			t.Fatalf("got %d and %v, want the limit of the default rule", response.Code, response.Header())
+		}
+	})
+
+	t.Run("should not limit unlimited rules", func(t *testing.T) {
+		handler := newTestRateLimiter(t).wrap(ok)
+
+		for i := 0; i < 5; i++ {
+			response := _test.GetRequest(handler, readinessUrl)
+			if response.Code != http.StatusOK || response.Header().Get(constants.RateLimitLimit) != "" {
+				t.Fatalf("got %d and %v, want not limited", response.Code, response.Header())
+			}
+		}
+	})
+}
+
+func TestServerRateLimitBeforeAuth(t *testing.T) {
+	st := _test.NewSpyStore()
+	lookups := 0
+	st.WhenFindApiKey(func(hash string) (data.ApiKey, error) {
+		lookups++
+		return data.ApiKey{}, store.ApiKeyNotFound
+	})
+	cfg := config.CfgData{
+		Server:    config.ServerCfg{Port: 8080},
+		Auth:      config.AuthCfg{Enabled: true},
+		RateLimit: config.RateLimitCfg{Rules: json.RawMessage(`[{"path":"/","rate":1,"burst":2}]`), MaxClients: 10},
+	}
+	srv := NewServer(cfg, &st).(*server)
+	headers := map[string]string{constants.ApiKey: "pk_guessed"}
+
+	for i := 0; i < 2; i++ {
+		response := _test.HeaderRequest(srv, "/pets", http.MethodGet, "", headers)
+		_test.AssertResponseError(t, response, resperr.Unauthorized)
+	}
+	response := _test.HeaderRequest(srv, "/pets", http.MethodGet, "", headers)
+	_test.AssertResponseError(t, response, resperr.TooManyRequests)
+	if lookups != 2 {
+		t.Fatalf("got %d key lookups, want 2", lookups)
+	}
+}
+
+func TestServerRateLimitByKey(t *testing.T) {
+	cfg := config.CfgData{
+		Server:    config.ServerCfg{Port: 8080},
+		Auth:      config.AuthCfg{Enabled: true},
+		RateLimit: config.RateLimitCfg{Rules: json.RawMessage(`[{"path":"/","rate":0.001,"burst":2}]`), MaxClients: 10},
+	}
+	ps := memory.NewInMemoryPetStore(cfg)
+	keys := make([]map[string]string, 0, 2)
+	for _, name := range []string{"first", "second"} {
+		key, prefix, hash, _ := newApiKey()
+		_, _ = ps.(store.ApiKeyStore).AddApiKey(name, prefix, hash, []string{data.ScopePetsRead})
+		keys = append(keys, map[string]string{constants.ApiKey: key})
+	}
+	srv := NewServer(cfg, ps).(*server)
+
+	for _, headers := range keys {
+		for i, remaining := range []string{"1", "0"} {
+			response := _test.HeaderRequest(srv, "/pets", http.MethodGet, "", headers)
+			if response.Code != http.StatusOK || response.Header().Get(constants.RateLimitRemaining) != remaining {
+				t.Fatalf("got %d and %v in request %d, want ok with the headers of the key", response.Code,
+					response.Header(), i+1)
+			}
+		}
+		response := _test.HeaderRequest(srv, "/pets", http.MethodGet, "", headers)
+		_test.AssertResponseError(t, response, resperr.TooManyRequests)
+	}
+}
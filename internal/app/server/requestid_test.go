@@ -0,0 +1,78 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package server
+
+import (
+	"github.com/LearningByExample/go-microservice/internal/app/constants"
+	"github.com/LearningByExample/go-microservice/internal/app/reqctx"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWithRequestId(t *testing.T) {
+	var got = ""
+	handler := withRequestId(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = reqctx.RequestId(r.Context())
+	}))
+
+	t.Run("should keep request id from header", func(t *testing.T) {
+		request, _ := http.NewRequest(http.MethodGet, "/", nil)
+		request.Header.Set(constants.RequestId, "abc")
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+
+		if got != "abc" {
+			t.Fatalf("got %q, want %q", got, "abc")
+		}
+		if header := response.Header().Get(constants.RequestId); header != "abc" {
+			t.Fatalf("got header %q, want %q", header, "abc")
+		}
+	})
+
+	t.Run("should generate request id", func(t *testing.T) {
+		request, _ := http.NewRequest(http.MethodGet, "/", nil)
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+
+		if len(got) != 32 {
+			t.Fatalf("got %q, want generated request id", got)
+		}
+		if header := response.Header().Get(constants.RequestId); header != got {
+			t.Fatalf("got header %q, want %q", header, got)
+		}
+	})
+
+	t.Run("should replace too long request id", func(t *testing.T) {
+		long := strings.Repeat("a", maxRequestIdLength+1)
+		request, _ := http.NewRequest(http.MethodGet, "/", nil)
+		request.Header.Set(constants.RequestId, long)
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+
+		if got == long || got == "" {
+			t.Fatalf("got %q, want generated request id", got)
+		}
+	})
+}
@@ -0,0 +1,116 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package server
+
+import (
+	"encoding/json"
+	"github.com/LearningByExample/go-microservice/internal/app/constants"
+	"github.com/LearningByExample/go-microservice/internal/app/data"
+	"github.com/LearningByExample/go-microservice/internal/app/resperr"
+	"github.com/LearningByExample/go-microservice/internal/app/store"
+	"log"
+	"net/http"
+	"strings"
+)
+
+const (
+	petSearchPath      = "/pets/search"
+	searchParam        = "q"
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+	maxSearchLength    = 200
+	searchNotEmpty     = "search query cannot be empty"
+	searchTooLong      = "search query is too long"
+)
+
+type searchPage struct {
+	Total  int             `json:"total"`
+	Offset int             `json:"offset"`
+	Limit  int             `json:"limit"`
+	Items  []data.PetMatch `json:"items"`
+}
+
+type searchHandler struct {
+	data store.PetStore
+}
+
+func searchText(r *http.Request) (string, error) {
+	text := strings.TrimSpace(r.URL.Query().Get(searchParam))
+	if text == "" {
+		return "", resperr.FromErrorMessage(resperr.InvalidUrl, []string{searchNotEmpty})
+	}
+	if len(text) > maxSearchLength {
+		return "", resperr.FromErrorMessage(resperr.InvalidUrl, []string{searchTooLong})
+	}
+	return text, nil
+}
+
+func (h searchHandler) getSearchRequest(w http.ResponseWriter, r *http.Request) error {
+	text, err := searchText(r)
+	if err != nil {
+		return err
+	}
+	offset, limit, err := pageParams(r, defaultSearchLimit, maxSearchLimit)
+	if err != nil {
+		return err
+	}
+
+	matches, total, err := h.data.SearchPets(text, offset, limit)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Add(constants.ContentType, constants.ApplicationJsonUtf8)
+	w.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(w)
+	page := searchPage{Total: total, Offset: offset, Limit: limit, Items: matches}
+	if err := encoder.Encode(page); err != nil {
+		return resperr.WrittenJson
+	}
+	return nil
+}
+
+func (h searchHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var rErr = resperr.None
+	h.data = h.data.WithContext(r.Context())
+
+	if r.URL.Path != petSearchPath {
+		rErr = resperr.NotFound
+	} else if r.Method != http.MethodGet {
+		rErr = resperr.BadRequest
+	} else if err := h.getSearchRequest(w, r); err != nil {
+		rErr = resperr.FromError(err)
+	}
+
+	if rErr.Status() != http.StatusOK {
+		log.Printf("Error %v in %s request %q", rErr, r.Method, r.URL.Path)
+	}
+
+	if rErr.Status() != resperr.None.Status() {
+		rErr.Write(w)
+	}
+}
+
+func NewSearchHandler(store store.PetStore) http.Handler {
+	return searchHandler{data: store}
+}
@@ -0,0 +1,149 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/LearningByExample/go-microservice/internal/_test"
+	"github.com/LearningByExample/go-microservice/internal/app/data"
+	"github.com/LearningByExample/go-microservice/internal/app/resperr"
+	"github.com/LearningByExample/go-microservice/internal/app/store"
+)
+
+func TestSearchRequest(t *testing.T) {
+	spyStore := _test.NewSpyStore()
+	handler := NewSearchHandler(&spyStore)
+
+	t.Run("should search pets", func(t *testing.T) {
+		spyStore.Reset()
+		matches := []data.PetMatch{{
+			Pet:        data.Pet{Id: 1, Name: "Fluffy", Race: "dog", Mod: "happy", Status: data.Available},
+			Rank:       1,
+			Highlights: map[string]string{"name": store.HighlightStart + "Fluffy" + store.HighlightStop},
+		}}
+		spyStore.WhenSearchPets(func(text string, offset int, limit int) ([]data.PetMatch, int, error) {
+			return matches, 3, nil
+		})
+
+		response := _test.GetRequest(handler, petSearchPath+"?q=+fluffy+&offset=2&limit=1")
+
+		if response.Code != http.StatusOK {
+			t.Fatalf("got %v, want %v", response.Code, http.StatusOK)
+		}
+		got := searchPage{}
+		if err := json.NewDecoder(response.Body).Decode(&got); err != nil {
+			t.Fatalf("got error, %v", err)
+		}
+		want := searchPage{Total: 3, Offset: 2, Limit: 1, Items: matches}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+		if spyStore.SearchText != "fluffy" || spyStore.Offset != 2 || spyStore.Limit != 1 {
+			t.Fatalf("got %q %d %d, want fluffy 2 1", spyStore.SearchText, spyStore.Offset, spyStore.Limit)
+		}
+	})
+
+	t.Run("should use default page", func(t *testing.T) {
+		spyStore.Reset()
+
+		response := _test.GetRequest(handler, petSearchPath+"?q=dog")
+
+		if response.Code != http.StatusOK || spyStore.Offset != 0 || spyStore.Limit != defaultSearchLimit {
+			t.Fatalf("got %d with %d %d, want %d with 0 %d", response.Code, spyStore.Offset, spyStore.Limit,
+				http.StatusOK, defaultSearchLimit)
+		}
+	})
+
+	type testCase struct {
+		name       string
+		path       string
+		method     string
+		storeFunc  func(text string, offset int, limit int) ([]data.PetMatch, int, error)
+		wantCalled bool
+		want       resperr.ResponseError
+	}
+
+	var cases = []testCase{
+		{
+			name:   "should fail without query",
+			path:   petSearchPath + "?q=+",
+			method: http.MethodGet,
+			want:   resperr.FromErrorMessage(resperr.InvalidUrl, []string{searchNotEmpty}),
+		},
+		{
+			name:   "should fail with a long query",
+			path:   petSearchPath + "?q=" + strings.Repeat("a", maxSearchLength+1),
+			method: http.MethodGet,
+			want:   resperr.FromErrorMessage(resperr.InvalidUrl, []string{searchTooLong}),
+		},
+		{
+			name:   "should fail with invalid limit",
+			path:   petSearchPath + "?q=dog&limit=1000",
+			method: http.MethodGet,
+			want:   resperr.InvalidUrl,
+		},
+		{
+			name:   "should fail with invalid method",
+			path:   petSearchPath + "?q=dog",
+			method: http.MethodPost,
+			want:   resperr.BadRequest,
+		},
+		{
+			name:   "should fail with invalid path",
+			path:   petSearchPath + "/dog",
+			method: http.MethodGet,
+			want:   resperr.NotFound,
+		},
+		{
+			name:   "should fail with store error",
+			path:   petSearchPath + "?q=dog",
+			method: http.MethodGet,
+			storeFunc: func(text string, offset int, limit int) ([]data.PetMatch, int, error) {
+				return nil, 0, mockError
+			},
+			wantCalled: true,
+			want:       resperr.FromError(mockError),
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			spyStore.Reset()
+			if tt.storeFunc != nil {
+				spyStore.WhenSearchPets(tt.storeFunc)
+			}
+
+			response := _test.HeaderRequest(handler, tt.path, tt.method, "", nil)
+
+			_test.AssertResponseError(t, response, tt.want)
+			if spyStore.SearchWasCall != tt.wantCalled {
+				t.Fatalf("got search called %t, want %t", spyStore.SearchWasCall, tt.wantCalled)
+			}
+		})
+	}
+}
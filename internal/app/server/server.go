@@ -26,124 +26,434 @@ import (
 	"context"
 	"fmt"
 	"github.com/LearningByExample/go-microservice/internal/app/config"
+	"github.com/LearningByExample/go-microservice/internal/app/events"
+	"github.com/LearningByExample/go-microservice/internal/app/outbox"
+	"github.com/LearningByExample/go-microservice/internal/app/policy"
 	"github.com/LearningByExample/go-microservice/internal/app/resperr"
 	"github.com/LearningByExample/go-microservice/internal/app/store"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"sync/atomic"
 	"syscall"
+	"time"
 )
 
 const (
-	rootPath     = "/"
-	petPath      = "/pets"
-	petWithSlash = "/pets/"
-	healthPath   = "/health/"
+	rootPath       = "/"
+	petPath        = "/pets"
+	petWithSlash   = "/pets/"
+	healthPath     = "/health/"
+	ownerPath      = "/owners"
+	ownerWithSlash = "/owners/"
+	webhookPath    = "/webhooks"
+	webhookSlash   = "/webhooks/"
 )
 
+// Server runs the service, Start is the shortcut of binding the configured ports and serving until a termination
+// signal, while Listen, Serve and Stop let run several servers in the same process.
 type Server interface {
 	Start() []error
+	// Listen opens the store and binds the listeners, using ln for HTTP instead of the configured port when it is
+	// given besides the configured sockets, everything is closed again when it fails
+	Listen(ln net.Listener) []error
+	// Addr gets the address of the first listener the HTTP server is bound to, nil until it listens
+	Addr() net.Addr
+	// Serve serves until the context is done, Stop is called, a server fails or a termination signal is received,
+	// then it shuts down gracefully and closes the store
+	Serve(ctx context.Context) []error
+	Stop()
 }
 
+type worker func(ctx context.Context)
+
 type server struct {
-	hs  *http.Server
-	ps  store.PetStore
-	ch  chan os.Signal
-	lnf int32
+	hs       *http.Server
+	admin    *http.Server
+	gs       *grpcServer
+	ps       store.PetStore
+	lns      []net.Listener
+	adminLn  net.Listener
+	ch       chan os.Signal
+	stop     chan struct{}
+	stopOnce sync.Once
+	started  int32
+	draining int32
+	inFlight int64
+	workers  []worker
+	tls      config.TlsCfg
+	port     int
+	socket   string
+	mode     os.FileMode
+	activate bool
+	drain    time.Duration
+	timeout  time.Duration
+	// setup has the errors creating the server, that fail the listen before opening anything
+	setup []error
 }
 
-func (s server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	s.hs.Handler.ServeHTTP(w, r)
 }
 
-func (s server) notFound(w http.ResponseWriter, _ *http.Request) {
+func (s *server) notFound(w http.ResponseWriter, _ *http.Request) {
 	resperr.NotFound.Write(w)
 }
 
-const (
-	quitSignal = syscall.SIGQUIT
-)
+// isStarted tells the startup probes when the store is open and the servers are launched
+func (s *server) isStarted() bool {
+	return atomic.LoadInt32(&(s.started)) != 0
+}
 
-func (s *server) isListening() bool {
-	if atomic.LoadInt32(&(s.lnf)) != 0 {
-		return true
+// isDraining tells the readiness probes to fail while the server waits to be removed from the endpoints
+func (s *server) isDraining() bool {
+	return atomic.LoadInt32(&(s.draining)) != 0
+}
+
+// track counts the requests in flight, to know how many are cut off when the shutdown times out
+func (s *server) track(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&(s.inFlight), 1)
+		defer atomic.AddInt64(&(s.inFlight), -1)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// startDraining fails the readiness and waits the drain period while the requests are still served, a new signal
+// stops waiting
+func (s *server) startDraining() {
+	atomic.StoreInt32(&(s.draining), 1)
+	if s.gs != nil {
+		s.gs.health.shutdown()
+	}
+	if s.drain <= 0 {
+		return
+	}
+	log.Printf("Draining HTTP server for %v ...", s.drain)
+	timer := time.NewTimer(s.drain)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-s.ch:
+		log.Print("Got signal while draining closing ...")
 	}
-	return false
 }
 
-func (s *server) setListening(v bool) {
-	var i int32 = 0
-	if v {
-		i = 1
+// shutdownContext bounds the whole shutdown with the shutdown timeout, a timeout of zero waits for everything
+func (s *server) shutdownContext() (context.Context, context.CancelFunc) {
+	if s.timeout > 0 {
+		return context.WithTimeout(context.Background(), s.timeout)
 	}
-	atomic.StoreInt32(&(s.lnf), i)
+	return context.WithCancel(context.Background())
 }
 
-func (s *server) quit() {
-	s.ch <- quitSignal
+// shutdown closes a HTTP server waiting for the requests until the deadline, then it closes their connections
+func (s *server) shutdown(deadline context.Context, hs *http.Server) (int64, error) {
+	err := hs.Shutdown(deadline)
+	if err == context.DeadlineExceeded {
+		cutOff := atomic.LoadInt64(&(s.inFlight))
+		_ = hs.Close()
+		return cutOff, err
+	}
+	return 0, err
 }
 
-func (s *server) Start() []error {
+func (s *server) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+	})
+}
+
+func (s *server) Addr() net.Addr {
+	if len(s.lns) == 0 {
+		return nil
+	}
+	return s.lns[0].Addr()
+}
+
+// startWorkers runs the workers until the returned function is called, that cancels them and waits for them until the
+// deadline, abandoning the ones that have not returned by then
+func (s *server) startWorkers() func(deadline context.Context) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(len(s.workers))
+	for _, w := range s.workers {
+		go func(w worker) {
+			defer wg.Done()
+			w(ctx)
+		}(w)
+	}
+
+	return func(deadline context.Context) error {
+		cancel()
+		if len(s.workers) == 0 {
+			return nil
+		}
+		done := make(chan struct{})
+		go func() {
+			wg.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+			return nil
+		case <-deadline.Done():
+			return deadline.Err()
+		}
+	}
+}
+
+// setupTls loads the certificate and the client CA bundle for HTTP and gRPC, reloading the certificate with the
+// workers
+func (s *server) setupTls() error {
+	tlsConfig, certs, err := newTlsConfig(s.tls)
+	if err == nil {
+		s.hs.TLSConfig = tlsConfig
+		if s.gs != nil {
+			s.gs.setTls(tlsConfig)
+		}
+		s.workers = append(s.workers, certs.watch)
+	}
+	return err
+}
+
+// bindHttp listens in the port when the HTTP server has not been given a listener, the Unix socket and the sockets of
+// the socket activation
+func (s *server) bindHttp(ln net.Listener) error {
+	var err error = nil
+	if ln == nil && s.port != 0 {
+		ln, err = net.Listen("tcp", s.hs.Addr)
+	}
+	if ln != nil {
+		s.lns = append(s.lns, ln)
+	}
+	if err == nil && s.socket != "" {
+		if ln, err = listenUnix(s.socket, s.mode); err == nil {
+			s.lns = append(s.lns, ln)
+		}
+	}
+	if err == nil && s.activate {
+		var activated []net.Listener
+		if activated, err = activationListeners(); err == nil {
+			s.lns = append(s.lns, activated...)
+		}
+	}
+	if err == nil && len(s.lns) == 0 {
+		err = errNoListeners
+	}
+	return err
+}
+
+// bind listens in the configured ports of the servers that have not been given a listener
+func (s *server) bind(ln net.Listener) error {
+	err := s.bindHttp(ln)
+	if err == nil && s.admin != nil && s.adminLn == nil {
+		s.adminLn, err = net.Listen("tcp", s.admin.Addr)
+	}
+	if err == nil && s.gs != nil {
+		err = s.gs.listen()
+	}
+	return err
+}
+
+// unbind closes the listeners that are bound, for a server that is not going to serve
+func (s *server) unbind() {
+	for _, ln := range append(s.lns, s.adminLn) {
+		if ln != nil {
+			_ = ln.Close()
+		}
+	}
+	s.lns, s.adminLn = nil, nil
+	if s.gs != nil && s.gs.ln != nil {
+		_ = s.gs.ln.Close()
+		s.gs.ln = nil
+	}
+}
+
+// closeListener closes a listener given to a server that fails before binding it, as it is not closed with the bound ones
+func closeListener(ln net.Listener) {
+	if ln != nil {
+		_ = ln.Close()
+	}
+}
+
+func (s *server) closeStore(errs []error) []error {
+	log.Print("Closing data store ...")
+	if err := s.ps.Close(); err != nil {
+		errs = append(errs, err)
+	}
+	log.Print("Server stopped.")
+	return errs
+}
+
+func (s *server) Listen(ln net.Listener) []error {
 	log.Print("Starting server ...")
 	errs := make([]error, 0)
+	if len(s.setup) != 0 {
+		closeListener(ln)
+		return append(errs, s.setup...)
+	}
 
 	log.Print("Opening data store ...")
 	if err := s.ps.Open(); err != nil {
 		errs = append(errs, err)
 	}
 
+	if len(errs) == 0 && s.tls.IsEnabled() {
+		log.Print("Loading TLS certificate ...")
+		if err := s.setupTls(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
 	if len(errs) == 0 {
-		s.setListening(false)
-		signal.Notify(s.ch, os.Interrupt, syscall.SIGTERM)
+		if err := s.bind(ln); err != nil {
+			errs = append(errs, err)
+		}
+	} else {
+		closeListener(ln)
+	}
+
+	if len(errs) != 0 {
+		s.unbind()
+		errs = s.closeStore(errs)
+	}
+	return errs
+}
+
+func (s *server) serveHttp(ln net.Listener) error {
+	var err error
+	if s.hs.TLSConfig != nil {
+		err = s.hs.ServeTLS(ln, "", "")
+	} else {
+		err = s.hs.Serve(ln)
+	}
+	if err == http.ErrServerClosed {
+		err = nil
+	}
+	return err
+}
 
-		log.Printf("Opening HTTP server at %s ...", s.hs.Addr)
+func (s *server) serveAdmin() error {
+	err := s.admin.Serve(s.adminLn)
+	if err == http.ErrServerClosed {
+		err = nil
+	}
+	return err
+}
+
+func (s *server) Serve(ctx context.Context) []error {
+	errs := make([]error, 0)
+	signal.Notify(s.ch, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(s.ch)
+
+	log.Print("Starting background workers ...")
+	stopWorkers := s.startWorkers()
+
+	// the servers only report their errors in the channel, that is read once all of them returned
+	failed := make(chan error, len(s.lns)+2)
+	var serving sync.WaitGroup
+	serve := func(fn func() error) {
+		serving.Add(1)
 		go func() {
-			s.setListening(true)
-			if err := s.hs.ListenAndServe(); err != nil {
-				errs = append(errs, err)
-				s.quit()
-				s.setListening(false)
+			defer serving.Done()
+			if err := fn(); err != nil {
+				failed <- err
 			}
 		}()
-		if len(errs) == 0 {
-			log.Print("HTTP server listening ...")
-
-			killSignal := <-s.ch
-			switch killSignal {
-			case os.Interrupt:
-				log.Print("Got interrupt signal closing ...")
-			case syscall.SIGTERM:
-				log.Print("Got termination signal closing ...")
-			case quitSignal:
-				log.Print("Got quit signal closing ...")
-			}
+	}
 
-			if s.isListening() {
-				log.Print("Closing HTTP server ...")
-				err := s.hs.Shutdown(context.Background())
-				if err != nil {
-					errs = append(errs, err)
-				}
-				log.Print("HTTP server closed.")
-				s.setListening(false)
-			}
+	for _, ln := range s.lns {
+		log.Printf("Opening HTTP server at %s ...", ln.Addr())
+		ln := ln
+		serve(func() error {
+			return s.serveHttp(ln)
+		})
+	}
+	if s.admin != nil {
+		log.Printf("Opening admin server at %s ...", s.adminLn.Addr())
+		serve(s.serveAdmin)
+	}
+	if s.gs != nil {
+		log.Printf("Opening gRPC server at %s ...", s.gs.ln.Addr())
+		serve(s.gs.serve)
+	}
+	log.Print("HTTP server listening ...")
+	atomic.StoreInt32(&(s.started), 1)
 
+	select {
+	case killSignal := <-s.ch:
+		switch killSignal {
+		case os.Interrupt:
+			log.Print("Got interrupt signal closing ...")
+		case syscall.SIGTERM:
+			log.Print("Got termination signal closing ...")
 		}
+		s.startDraining()
+	case <-s.stop:
+		log.Print("Got stop closing ...")
+		s.startDraining()
+	case <-ctx.Done():
+		log.Print("Got context done closing ...")
+		s.startDraining()
+	case err := <-failed:
+		log.Printf("Error %v serving closing ...", err)
+		errs = append(errs, err)
 	}
 
-	log.Print("Closing data store ...")
-	if err := s.ps.Close(); err != nil {
+	deadline, cancel := s.shutdownContext()
+	defer cancel()
+
+	log.Print("Closing HTTP server ...")
+	if cutOff, err := s.shutdown(deadline, s.hs); err != nil {
+		log.Printf("Error %v closing HTTP server, %d requests in flight were cut off", err, cutOff)
 		errs = append(errs, err)
 	}
+	log.Print("HTTP server closed.")
 
-	log.Print("Server stopped.")
+	if s.admin != nil {
+		log.Print("Closing admin server ...")
+		if _, err := s.shutdown(deadline, s.admin); err != nil {
+			errs = append(errs, err)
+		}
+		log.Print("Admin server closed.")
+	}
 
-	return errs
+	if s.gs != nil {
+		log.Print("Closing gRPC server ...")
+		if err := s.gs.stop(deadline); err != nil {
+			log.Printf("Error %v closing gRPC server, its calls in flight were cut off", err)
+			errs = append(errs, err)
+		}
+		log.Print("gRPC server closed.")
+	}
+
+	serving.Wait()
+	close(failed)
+	for err := range failed {
+		errs = append(errs, err)
+	}
+
+	log.Print("Stopping background workers ...")
+	if err := stopWorkers(deadline); err != nil {
+		log.Printf("Error %v stopping background workers, they were abandoned", err)
+		errs = append(errs, err)
+	}
+
+	return s.closeStore(errs)
 }
 
-func NewServer(cfg config.CfgData, store store.PetStore) Server {
+func (s *server) Start() []error {
+	if errs := s.Listen(nil); len(errs) != 0 {
+		return errs
+	}
+	return s.Serve(context.Background())
+}
+
+func NewServer(cfg config.CfgData, ps store.PetStore) Server {
 	mux := http.NewServeMux()
 
 	addr := fmt.Sprintf(":%d", cfg.Server.Port)
@@ -151,20 +461,147 @@ func NewServer(cfg config.CfgData, store store.PetStore) Server {
 	srv := server{
 		hs: &http.Server{
 			Addr:    addr,
-			Handler: mux,
+			Handler: withRequestId(mux),
 		},
-		ps: store,
-		ch: make(chan os.Signal, 1),
+		ps:       ps,
+		ch:       make(chan os.Signal, 1),
+		stop:     make(chan struct{}),
+		tls:      cfg.Server.Tls,
+		port:     cfg.Server.Port,
+		socket:   cfg.Server.Socket,
+		mode:     cfg.Server.SocketFileMode(),
+		activate: cfg.Server.SocketActivation,
+		drain:    time.Duration(cfg.Server.DrainPeriod) * time.Millisecond,
+		timeout:  time.Duration(cfg.Server.ShutdownTimeout) * time.Millisecond,
+	}
+
+	if source, ok := srv.ps.(store.OutboxStore); ok && cfg.Store.Outbox.IsEnabled() {
+		if relay, err := outbox.NewRelay(cfg.Store.Outbox, source); err == nil {
+			srv.workers = append(srv.workers, relay.Run)
+		} else {
+			log.Printf("Error %v creating outbox relay", err)
+		}
+	}
+
+	var photos *photoService = nil
+	if cfg.Photos.IsEnabled() {
+		photos = newPhotoService(cfg.Photos)
+		photos.removeOnDelete = !cfg.Store.Purge.IsEnabled()
+	}
+
+	if cfg.Store.Purge.IsEnabled() {
+		srv.workers = append(srv.workers, newPurger(cfg.Store.Purge, srv.ps, photos).run)
+	}
+
+	broker := events.NewBroker(events.DefaultReplaySize)
+	publish := events.Publisher(broker.Publish)
+	if notifier, ok := srv.ps.(store.EventNotifier); ok {
+		publish = events.NotifyPublisher(notifier)
+		srv.workers = append(srv.workers, broker.Listen(notifier))
+	}
+	srv.hs.RegisterOnShutdown(broker.Close)
+	if ws, ok := srv.ps.(store.WebhookStore); ok && cfg.Webhooks.IsEnabled() {
+		publish = events.Combine(publish, events.WebhookPublisher(ws))
+		srv.workers = append(srv.workers, newDeliverer(cfg.Webhooks, ws).run)
+		webhookHandler := NewWebhookHandler(srv.ps)
+		mux.Handle(webhookPath, webhookHandler)
+		mux.Handle(webhookSlash, webhookHandler)
+	}
+	data := events.NewStore(srv.ps, publish)
+
+	var pol *policy.Policy = nil
+	if cfg.Auth.Enabled && cfg.Auth.PolicyFile != "" {
+		loaded, err := policy.Load(cfg.Auth.PolicyFile)
+		if err != nil {
+			log.Printf("Error %v loading policy", err)
+			srv.setup = append(srv.setup, err)
+		}
+		data = policy.NewStore(data, loaded)
+		pol = &loaded
 	}
 
-	srv.setListening(false)
+	var handler http.Handler = mux
+	var limiter *rateLimiter = nil
+	if cfg.RateLimit.IsEnabled() {
+		var err error
+		if limiter, err = newRateLimiter(cfg.RateLimit); err != nil {
+			log.Printf("Error %v loading rate limit rules", err)
+			srv.setup = append(srv.setup, err)
+		}
+		srv.workers = append(srv.workers, limiter.watch)
+		if !cfg.Auth.Enabled {
+			handler = limiter.wrap(handler)
+		}
+	}
+	var auth *authenticator = nil
+	if cfg.Auth.Enabled {
+		var err error
+		if auth, err = newAuthenticator(cfg.Auth, srv.ps); err != nil {
+			log.Printf("Error %v loading token keys", err)
+			srv.setup = append(srv.setup, err)
+		}
+		if auth.verifier != nil {
+			srv.workers = append(srv.workers, auth.verifier.Watch)
+		}
+		if limiter != nil {
+			handler = limiter.wrapAuth(auth, handler)
+		} else {
+			handler = auth.wrap(handler)
+		}
+		if _, ok := srv.ps.(store.ApiKeyStore); ok {
+			apiKeyHandler := NewApiKeyHandler(srv.ps)
+			mux.Handle(apiKeyPath, apiKeyHandler)
+			mux.Handle(apiKeySlash, apiKeyHandler)
+		}
+	}
 
-	petHandler := NewPetHandler(srv.ps)
-	healthHandler := NewHealthHandler(srv.ps)
+	if cfg.Server.Tls.ClientCaFile != "" && !cfg.Auth.Enabled {
+		handler = withClientCert(handler)
+	}
+	healthHandler := newHealthHandler(newChecker(cfg, srv.ps), srv.isStarted, srv.isDraining)
+	if cfg.Server.IsAdminEnabled() {
+		m := newMetrics()
+		srv.admin = &http.Server{
+			Addr:    cfg.Server.AdminAddr(),
+			Handler: withRequestId(newAdminMux(cfg, healthHandler, m)),
+		}
+		handler = m.wrap(handler)
+	} else {
+		mux.Handle(healthPath, healthHandler)
+	}
+	srv.hs.Handler = withRequestId(srv.track(handler))
+
+	if cfg.Server.IsGrpcEnabled() {
+		srv.gs = newGrpcServer(fmt.Sprintf(":%d", cfg.Server.GrpcPort), srv.ps, data, broker, auth,
+			cfg.Server.Tls.IsEnabled())
+	}
+
+	petHandler := newPetHandler(data, photos)
+	if pol != nil {
+		petHandler = withPolicy(*pol, petHandler)
+	}
+	petIOHandler := NewPetIOHandler(data)
+	batchHandler := NewBatchHandler(data)
+	searchHandler := NewSearchHandler(data)
+	eventsHandler := NewEventsHandler(broker, data)
 	mux.HandleFunc(rootPath, srv.notFound)
 	mux.Handle(petPath, petHandler)
 	mux.Handle(petWithSlash, petHandler)
-	mux.Handle(healthPath, healthHandler)
+	mux.Handle(petExportPath, petIOHandler)
+	mux.Handle(petImportPath, petIOHandler)
+	mux.Handle(petBatchPath, batchHandler)
+	mux.Handle(petSearchPath, searchHandler)
+	mux.Handle(petEventsPath, eventsHandler)
+	if graphqlHandler, err := NewGraphqlHandler(cfg.Graphql, data); err == nil {
+		mux.Handle(graphqlPath, graphqlHandler)
+	} else {
+		log.Printf("Error %v creating GraphQL handler", err)
+	}
+	if hasOwners(data) {
+		ownerHandler := NewOwnerHandler(data)
+		mux.Handle(ownerPath, ownerHandler)
+		mux.Handle(ownerWithSlash, ownerHandler)
+	}
 
 	return &srv
 }
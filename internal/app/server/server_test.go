@@ -23,22 +23,30 @@
 package server
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/LearningByExample/go-microservice/internal/_test"
 	"github.com/LearningByExample/go-microservice/internal/app/config"
+	"github.com/LearningByExample/go-microservice/internal/app/constants"
+	"github.com/LearningByExample/go-microservice/internal/app/data"
 	"github.com/LearningByExample/go-microservice/internal/app/store"
-	"math/rand"
+	"github.com/LearningByExample/go-microservice/internal/app/store/memory"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"net"
 	"net/http"
 	"reflect"
 	"testing"
 	"time"
 )
 
-func createServerRandomPort(st store.PetStore) *server {
-	port := rand.Intn(7000-6000) + 6000
+// createServer creates a server with a configured port that the tests never bind, giving it local listeners instead
+func createServer(st store.PetStore) *server {
 	cfg := config.CfgData{
 		Server: config.ServerCfg{
-			Port: port,
+			Port: 8080,
 		},
 		Store: config.StoreCfg{},
 	}
@@ -47,9 +55,33 @@ func createServerRandomPort(st store.PetStore) *server {
 	return srv
 }
 
+// localListener listens in a free local port
+func localListener(t *testing.T) net.Listener {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	return ln
+}
+
+// serveLocal binds a server to a free local port and serves it, sending its errors when it stops
+func serveLocal(t *testing.T, srv Server) (string, chan []error) {
+	t.Helper()
+	ln := localListener(t)
+	if errs := srv.Listen(ln); len(errs) != 0 {
+		t.Fatalf("got %v, want no errors listening", errs)
+	}
+	done := make(chan []error, 1)
+	go func() {
+		done <- srv.Serve(context.Background())
+	}()
+	return srv.Addr().String(), done
+}
+
 func TestServerRoutes(t *testing.T) {
 	st := _test.NewSpyStore()
-	srv := createServerRandomPort(&st)
+	srv := createServer(&st)
 
 	type testCase struct {
 		name string
@@ -73,6 +105,11 @@ func TestServerRoutes(t *testing.T) {
 			path: "/health/readiness",
 			want: http.StatusOK,
 		},
+		{
+			name: "startup must be unavailable before start",
+			path: "/health/startup",
+			want: http.StatusServiceUnavailable,
+		},
 	}
 
 	for _, tt := range cases {
@@ -89,32 +126,176 @@ func TestServerRoutes(t *testing.T) {
 }
 
 func TestServerNoError(t *testing.T) {
+	t.Parallel()
 	st := _test.NewSpyStore()
-	srv := createServerRandomPort(&st)
+	srv := createServer(&st)
 
 	st.Reset()
-	errs := make([]error, 0)
+	_, done := serveLocal(t, srv)
+
+	srv.Stop()
+
+	got := <-done
+	want := make([]error, 0)
+
+	if reflect.DeepEqual(got, want) != true {
+		t.Fatalf("want %v errors, got %v", want, got)
+	}
+
+	if !st.OpenWasCall {
+		t.Fatal("open was not called")
+	}
+
+	if !st.CloseWasCall {
+		t.Fatal("close was not called")
+	}
+}
+
+func TestServerContext(t *testing.T) {
+	t.Parallel()
+	st := _test.NewSpyStore()
+	srv := createServer(&st)
+	ln, _ := net.Listen("tcp", "localhost:0")
+	if errs := srv.Listen(ln); len(errs) != 0 {
+		t.Fatalf("got %v, want no errors listening", errs)
+	}
+	if srv.Addr() != ln.Addr() {
+		t.Fatalf("got %v, want %v", srv.Addr(), ln.Addr())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan []error, 1)
 	go func() {
-		errs = srv.Start()
+		done <- srv.Serve(ctx)
 	}()
+	if code, _ := getBody(t, fmt.Sprintf("http://%s/pets/1", srv.Addr())); code != http.StatusOK {
+		t.Fatalf("got %d, want %d", code, http.StatusOK)
+	}
+	cancel()
 
-	for srv.isListening() != true {
+	if got := <-done; len(got) != 0 {
+		t.Fatalf("want no errors, got %v", got)
+	}
+	if _, err := net.Dial("tcp", ln.Addr().String()); err == nil {
+		t.Fatal("want listener closed, got connected")
 	}
+}
 
-	srv.quit()
+func TestServerListenError(t *testing.T) {
+	t.Parallel()
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer listener.Close()
 
-	time.Sleep(100 * time.Millisecond)
+	st := _test.NewSpyStore()
+	cfg := config.CfgData{
+		Server: config.ServerCfg{Port: listener.Addr().(*net.TCPAddr).Port},
+	}
+	srv := NewServer(cfg, &st)
 
-	want := make([]error, 0)
+	if got := srv.Listen(nil); len(got) != 1 {
+		t.Fatalf("got %v, want the error of the listener", got)
+	}
+	if srv.Addr() != nil {
+		t.Fatalf("got %v, want no address", srv.Addr())
+	}
+	if !st.CloseWasCall {
+		t.Fatal("close was not called")
+	}
+}
 
-	if reflect.DeepEqual(errs, want) != true {
-		t.Fatalf("want %v errors, got %v", want, errs)
+func TestServerSetupError(t *testing.T) {
+	cases := map[string]config.CfgData{
+		"missing policy": {
+			Server: config.ServerCfg{Port: 8080},
+			Auth:   config.AuthCfg{Enabled: true, PolicyFile: "testdata/missing-policy.json"},
+		},
+		"missing token keys": {
+			Server: config.ServerCfg{Port: 8080},
+			Auth:   config.AuthCfg{Enabled: true, Jwt: config.JwtCfg{JwksFile: "testdata/missing-jwks.json"}},
+		},
+		"missing rate limit rules": {
+			Server:    config.ServerCfg{Port: 8080},
+			RateLimit: config.RateLimitCfg{RulesFile: "testdata/missing-rules.json", MaxClients: 10},
+		},
 	}
+	for name, cfg := range cases {
+		t.Run(name, func(t *testing.T) {
+			st := _test.NewSpyStore()
+			srv := NewServer(cfg, &st)
 
-	if !st.OpenWasCall {
-		t.Fatal("open was not called")
+			if got := srv.Listen(nil); len(got) != 1 {
+				t.Fatalf("got %v, want the error creating the server", got)
+			}
+			if st.OpenWasCall {
+				t.Fatal("open was called")
+			}
+		})
 	}
+}
 
+func TestServerGracefulShutdown(t *testing.T) {
+	t.Parallel()
+	st := _test.NewSpyStore()
+	entered := make(chan struct{}, 1)
+	release := make(chan struct{})
+	defer close(release)
+	st.WhenGetPet(func(id int) (data.Pet, error) {
+		entered <- struct{}{}
+		<-release
+		return data.Pet{Id: id}, nil
+	})
+	cfg := config.CfgData{
+		Server: config.ServerCfg{DrainPeriod: 500, ShutdownTimeout: 100},
+	}
+	srv := NewServer(cfg, &st).(*server)
+
+	addr, errs := serveLocal(t, srv)
+	url := "http://" + addr
+	if code, _ := getBody(t, url+readinessUrl); code != http.StatusOK {
+		t.Fatalf("got %d, want %d", code, http.StatusOK)
+	}
+	go func() {
+		_, _ = http.Get(url + "/pets/1")
+	}()
+	<-entered
+
+	srv.Stop()
+	for srv.isDraining() != true {
+	}
+
+	if code, _ := getBody(t, url+readinessUrl); code != http.StatusServiceUnavailable {
+		t.Fatalf("got %d, want readiness failing while draining", code)
+	}
+	if got := <-errs; len(got) != 1 || got[0] != context.DeadlineExceeded {
+		t.Fatalf("got %v, want %v", got, context.DeadlineExceeded)
+	}
+	if !st.CloseWasCall {
+		t.Fatal("close was not called")
+	}
+}
+
+func TestServerShutdownStuckWorker(t *testing.T) {
+	t.Parallel()
+	st := _test.NewSpyStore()
+	cfg := config.CfgData{
+		Server: config.ServerCfg{ShutdownTimeout: 50},
+	}
+	srv := NewServer(cfg, &st).(*server)
+	release := make(chan struct{})
+	defer close(release)
+	srv.workers = append(srv.workers, func(_ context.Context) {
+		<-release
+	})
+
+	_, errs := serveLocal(t, srv)
+	srv.Stop()
+
+	if got := <-errs; len(got) != 1 || got[0] != context.DeadlineExceeded {
+		t.Fatalf("got %v, want %v", got, context.DeadlineExceeded)
+	}
 	if !st.CloseWasCall {
 		t.Fatal("close was not called")
 	}
@@ -122,7 +303,7 @@ func TestServerNoError(t *testing.T) {
 
 func TestServerStoreError(t *testing.T) {
 	st := _test.NewSpyStore()
-	srv := createServerRandomPort(&st)
+	srv := createServer(&st)
 
 	oErr := errors.New("nasty open error")
 	cErr := errors.New("nasty close error")
@@ -151,4 +332,77 @@ func TestServerStoreError(t *testing.T) {
 	if !st.CloseWasCall {
 		t.Fatal("close was not called")
 	}
+
+	ln := localListener(t)
+	if got := srv.Listen(ln); !reflect.DeepEqual(got, want) {
+		t.Fatalf("want %v errors, got %v", want, got)
+	}
+	if conn, err := net.Dial("tcp", ln.Addr().String()); err == nil {
+		_ = conn.Close()
+		t.Fatal("want the given listener closed, got connected")
+	}
+}
+
+func TestServerWithGrpc(t *testing.T) {
+	st := _test.NewSpyStore()
+	cfg := config.CfgData{
+		Server: config.ServerCfg{
+			Port:     8080,
+			GrpcPort: 9090,
+		},
+	}
+	srv := NewServer(cfg, &st).(*server)
+	srv.gs.ln = localListener(t)
+
+	_, errs := serveLocal(t, srv)
+
+	conn, err := grpc.Dial(srv.gs.ln.Addr().String(), grpc.WithInsecure(), grpc.WithBlock(),
+		grpc.WithTimeout(5*time.Second))
+	if err != nil {
+		t.Fatalf("error dialing gRPC server: %v", err)
+	}
+	defer conn.Close()
+
+	got, err := grpc_health_v1.NewHealthClient(conn).Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	if err != nil || got.Status != grpc_health_v1.HealthCheckResponse_SERVING {
+		t.Fatalf("got %v and %v, want %v", got, err, grpc_health_v1.HealthCheckResponse_SERVING)
+	}
+
+	srv.Stop()
+
+	if got := <-errs; len(got) != 0 {
+		t.Fatalf("want no errors, got %v", got)
+	}
+
+	if !st.CloseWasCall {
+		t.Fatal("close was not called")
+	}
+}
+
+func TestServerWithAuth(t *testing.T) {
+	bootstrap := "pk_0123456789abcdef0123456789abcdef"
+	cfg := config.CfgData{
+		Server: config.ServerCfg{Port: 8080},
+		Auth:   config.AuthCfg{Enabled: true, BootstrapKey: bootstrap},
+	}
+	srv := NewServer(cfg, memory.NewInMemoryPetStore(cfg)).(*server)
+
+	if response := _test.GetRequest(srv, "/pets"); response.Code != http.StatusUnauthorized {
+		t.Fatalf("got status %d, want %d", response.Code, http.StatusUnauthorized)
+	}
+
+	body := `{"name":"reader","scopes":["pets:read"]}`
+	response := _test.HeaderRequest(srv, "/apikeys", http.MethodPost, body, map[string]string{constants.ApiKey: bootstrap})
+	created := apiKeyResponse{}
+	if err := json.NewDecoder(response.Body).Decode(&created); err != nil || created.Key == "" {
+		t.Fatalf("got %v and %v, want a created key", created, err)
+	}
+
+	headers := map[string]string{constants.ApiKey: created.Key}
+	if response := _test.HeaderRequest(srv, "/pets", http.MethodGet, "", headers); response.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", response.Code, http.StatusOK)
+	}
+	if response := _test.HeaderRequest(srv, "/apikeys", http.MethodGet, "", headers); response.Code != http.StatusForbidden {
+		t.Fatalf("got status %d, want %d", response.Code, http.StatusForbidden)
+	}
 }
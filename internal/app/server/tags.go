@@ -0,0 +1,154 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/LearningByExample/go-microservice/internal/app/constants"
+	"github.com/LearningByExample/go-microservice/internal/app/resperr"
+	"github.com/LearningByExample/go-microservice/internal/app/store"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+const (
+	tagsResource         = "tags"
+	tagParam             = "tag"
+	attributeParamPrefix = "attr."
+	maxPetTags           = 20
+	maxPetAttributes     = 20
+	maxTagLength         = 32
+	maxAttributeLength   = 256
+	tooManyTags          = "pet cannot have more than %d tags"
+	tooManyAttributes    = "pet cannot have more than %d attributes"
+	tagNotValid          = "tag %q is not valid"
+	attributeNotValid    = "attribute %q is not valid"
+	attributeTooLong     = "attribute %q value is too long"
+)
+
+var (
+	tagExpr = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+)
+
+type petTags struct {
+	Tags       []string          `json:"tags"`
+	Attributes map[string]string `json:"attributes"`
+}
+
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimSpace(tag))
+}
+
+func validTag(tag string) bool {
+	return len(tag) <= maxTagLength && tagExpr.MatchString(tag)
+}
+
+func validTags(tags petTags) error {
+	msg := make([]string, 0)
+
+	if len(tags.Tags) > maxPetTags {
+		msg = append(msg, fmt.Sprintf(tooManyTags, maxPetTags))
+	}
+	for _, tag := range tags.Tags {
+		if !validTag(tag) {
+			msg = append(msg, fmt.Sprintf(tagNotValid, tag))
+		}
+	}
+	if len(tags.Attributes) > maxPetAttributes {
+		msg = append(msg, fmt.Sprintf(tooManyAttributes, maxPetAttributes))
+	}
+	for key, value := range tags.Attributes {
+		if !validTag(key) {
+			msg = append(msg, fmt.Sprintf(attributeNotValid, key))
+		} else if len(value) > maxAttributeLength {
+			msg = append(msg, fmt.Sprintf(attributeTooLong, key))
+		}
+	}
+
+	if len(msg) == 0 {
+		return nil
+	} else {
+		return resperr.FromErrorMessage(resperr.InvalidResource, msg)
+	}
+}
+
+func queryTags(r *http.Request) ([]string, map[string]string, error) {
+	var tags []string = nil
+	var attributes map[string]string = nil
+
+	for name, values := range r.URL.Query() {
+		if name == tagParam {
+			for _, value := range values {
+				tag := normalizeTag(value)
+				if !validTag(tag) {
+					return nil, nil, resperr.InvalidUrl
+				}
+				tags = append(tags, tag)
+			}
+		} else if strings.HasPrefix(name, attributeParamPrefix) {
+			key := strings.TrimPrefix(name, attributeParamPrefix)
+			if !validTag(key) || len(values) != 1 {
+				return nil, nil, resperr.InvalidUrl
+			}
+			if attributes == nil {
+				attributes = make(map[string]string)
+			}
+			attributes[key] = values[0]
+		}
+	}
+
+	return tags, attributes, nil
+}
+
+func (s petHandler) putPetTagsRequest(w http.ResponseWriter, r *http.Request, id int) error {
+	if r.Body == nil {
+		return resperr.NotBodyProvided
+	}
+	tags := petTags{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&tags); err != nil {
+		return resperr.InvalidResource
+	}
+	for i := range tags.Tags {
+		tags.Tags[i] = normalizeTag(tags.Tags[i])
+	}
+	if err := validTags(tags); err != nil {
+		return err
+	}
+
+	change, err := s.dataFor(r).SetPetTags(id, tags.Tags, tags.Attributes)
+	if err == store.PetNotFound {
+		return resperr.NotFound
+	} else if err != nil {
+		return err
+	}
+	w.Header().Add(constants.ContentType, constants.ApplicationJsonUtf8)
+	if change {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusNotModified)
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/LearningByExample/go-microservice/internal/_test"
+	"github.com/LearningByExample/go-microservice/internal/app/resperr"
+	"github.com/LearningByExample/go-microservice/internal/app/store"
+)
+
+func TestPutPetTagsRequest(t *testing.T) {
+	spyStore := _test.NewSpyStore()
+	handler := NewPetHandler(&spyStore)
+
+	t.Run("should set normalized tags", func(t *testing.T) {
+		spyStore.Reset()
+
+		response := _test.HeaderRequest(handler, "/pets/3/tags", http.MethodPut,
+			`{"tags":[" Small ","friendly"],"attributes":{"color":"black"}}`, nil)
+
+		_test.AssertResponseError(t, response, resperr.None)
+		if spyStore.Id != 3 || !reflect.DeepEqual(spyStore.Tags, []string{"small", "friendly"}) ||
+			!reflect.DeepEqual(spyStore.Attributes, map[string]string{"color": "black"}) {
+			t.Fatalf("got %d %v %v, want pet 3 tags", spyStore.Id, spyStore.Tags, spyStore.Attributes)
+		}
+	})
+
+	manyTags := make([]string, maxPetTags+1)
+	for i := range manyTags {
+		manyTags[i] = fmt.Sprintf("%q", fmt.Sprintf("tag%d", i))
+	}
+
+	type testCase struct {
+		name       string
+		body       string
+		setTags    func(id int, tags []string, attributes map[string]string) (bool, error)
+		wantCalled bool
+		want       resperr.ResponseError
+		wantCode   int
+	}
+
+	var cases = []testCase{
+		{
+			name: "should not modify",
+			body: `{"tags":["small"]}`,
+			setTags: func(id int, tags []string, attributes map[string]string) (bool, error) {
+				return false, nil
+			},
+			wantCalled: true,
+			wantCode:   http.StatusNotModified,
+		},
+		{
+			name: "should not found",
+			body: `{"tags":["small"]}`,
+			setTags: func(id int, tags []string, attributes map[string]string) (bool, error) {
+				return false, store.PetNotFound
+			},
+			wantCalled: true,
+			want:       resperr.NotFound,
+		},
+		{
+			name: "should fail with store error",
+			body: `{"tags":["small"]}`,
+			setTags: func(id int, tags []string, attributes map[string]string) (bool, error) {
+				return false, mockError
+			},
+			wantCalled: true,
+			want:       resperr.FromError(mockError),
+		},
+		{
+			name: "should fail with invalid json",
+			body: `{"tags":"small"}`,
+			want: resperr.InvalidResource,
+		},
+		{
+			name: "should fail with invalid tag",
+			body: `{"tags":["small dog",""]}`,
+			want: resperr.FromErrorMessage(resperr.InvalidResource,
+				[]string{fmt.Sprintf(tagNotValid, "small dog"), fmt.Sprintf(tagNotValid, "")}),
+		},
+		{
+			name: "should fail with too many tags",
+			body: `{"tags":[` + strings.Join(manyTags, ",") + `]}`,
+			want: resperr.FromErrorMessage(resperr.InvalidResource, []string{fmt.Sprintf(tooManyTags, maxPetTags)}),
+		},
+		{
+			name: "should fail with invalid attribute",
+			body: `{"attributes":{"Eye Color":"blue"}}`,
+			want: resperr.FromErrorMessage(resperr.InvalidResource, []string{fmt.Sprintf(attributeNotValid, "Eye Color")}),
+		},
+		{
+			name: "should fail with long attribute",
+			body: `{"attributes":{"color":"` + strings.Repeat("a", maxAttributeLength+1) + `"}}`,
+			want: resperr.FromErrorMessage(resperr.InvalidResource, []string{fmt.Sprintf(attributeTooLong, "color")}),
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			spyStore.Reset()
+			if tt.setTags != nil {
+				spyStore.WhenSetPetTags(tt.setTags)
+			}
+
+			response := _test.HeaderRequest(handler, "/pets/3/tags", http.MethodPut, tt.body, nil)
+
+			if tt.wantCode != 0 {
+				if response.Code != tt.wantCode {
+					t.Fatalf("got %d, want %d", response.Code, tt.wantCode)
+				}
+			} else {
+				_test.AssertResponseError(t, response, tt.want)
+			}
+			if spyStore.SetTagsWasCall != tt.wantCalled {
+				t.Fatalf("got set tags called %t, want %t", spyStore.SetTagsWasCall, tt.wantCalled)
+			}
+		})
+	}
+}
+
+func TestGetPetsByTags(t *testing.T) {
+	spyStore := _test.NewSpyStore()
+	handler := NewPetHandler(&spyStore)
+
+	t.Run("should find pets by tags and attributes", func(t *testing.T) {
+		spyStore.Reset()
+
+		response := _test.GetRequest(handler, "/pets?tag=Small&tag=friendly&attr.color=black")
+
+		_test.AssertResponseError(t, response, resperr.None)
+		want := store.PetQuery{Tags: []string{"small", "friendly"}, Attributes: map[string]string{"color": "black"}}
+		if !reflect.DeepEqual(spyStore.Query, want) {
+			t.Fatalf("got query %v, want %v", spyStore.Query, want)
+		}
+	})
+
+	for _, path := range []string{"/pets?tag=", "/pets?tag=a+b", "/pets?attr.Color=black", "/pets?attr.color=a&attr.color=b"} {
+		t.Run("should fail with "+path, func(t *testing.T) {
+			spyStore.Reset()
+
+			response := _test.GetRequest(handler, path)
+
+			_test.AssertResponseError(t, response, resperr.InvalidUrl)
+			if spyStore.FindWasCall {
+				t.Fatal("find should not be called")
+			}
+		})
+	}
+}
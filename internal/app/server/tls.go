@@ -0,0 +1,187 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"github.com/LearningByExample/go-microservice/internal/app/config"
+	"github.com/LearningByExample/go-microservice/internal/app/reqctx"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	clientCertActor = "cert:"
+)
+
+var (
+	errInvalidClientCa = errors.New("no valid certificates in client CA bundle")
+)
+
+// certReloader serves the certificate of the key pair files, that are reloaded when they change keeping the previous
+// certificate if they are not valid, as a rotation could write the certificate and the key at different times.
+type certReloader struct {
+	mu       sync.RWMutex
+	certFile string
+	keyFile  string
+	cert     *tls.Certificate
+	modified time.Time
+	interval time.Duration
+}
+
+func (c *certReloader) lastModified() (time.Time, error) {
+	modified := time.Time{}
+	for _, path := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return modified, err
+		}
+		if info.ModTime().After(modified) {
+			modified = info.ModTime()
+		}
+	}
+	return modified, nil
+}
+
+func (c *certReloader) reload() error {
+	modified, err := c.lastModified()
+	if err != nil {
+		return err
+	}
+	c.mu.RLock()
+	unchanged := c.cert != nil && modified.Equal(c.modified)
+	c.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cert = &cert
+	c.modified = modified
+	return nil
+}
+
+func (c *certReloader) getCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cert, nil
+}
+
+// watch reloads the key pair until the context is done, it returns at once when the reload is disabled
+func (c *certReloader) watch(ctx context.Context) {
+	if c.interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.reload(); err != nil {
+				log.Printf("Error %v reloading certificate from %q", err, c.certFile)
+			}
+		}
+	}
+}
+
+func newCertReloader(cfg config.TlsCfg) (*certReloader, error) {
+	certs := &certReloader{
+		certFile: cfg.CertFile,
+		keyFile:  cfg.KeyFile,
+		interval: time.Duration(cfg.ReloadInterval) * time.Millisecond,
+	}
+	return certs, certs.reload()
+}
+
+func loadClientCa(path string) (*x509.CertPool, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(raw) {
+		return nil, errInvalidClientCa
+	}
+	return pool, nil
+}
+
+func newTlsConfig(cfg config.TlsCfg) (*tls.Config, *certReloader, error) {
+	certs, err := newCertReloader(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	tlsConfig := &tls.Config{
+		GetCertificate: certs.getCertificate,
+		MinVersion:     cfg.Version(),
+		CipherSuites:   cfg.Ciphers(),
+	}
+	if cfg.ClientCaFile != "" {
+		if tlsConfig.ClientCAs, err = loadClientCa(cfg.ClientCaFile); err != nil {
+			return nil, nil, err
+		}
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		if cfg.ClientAuth == config.ClientAuthOptional {
+			tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
+	return tlsConfig, certs, nil
+}
+
+// verifiedSubject gets the subject of the verified client certificate of a connection, empty without one
+func verifiedSubject(state *tls.ConnectionState) string {
+	if state != nil && len(state.VerifiedChains) != 0 && len(state.VerifiedChains[0]) != 0 {
+		return state.VerifiedChains[0][0].Subject.String()
+	}
+	return ""
+}
+
+// clientCertSubject gets the subject of the verified client certificate of a request, empty without one
+func clientCertSubject(r *http.Request) string {
+	return verifiedSubject(r.TLS)
+}
+
+// withClientCert records the subject of a verified client certificate as the actor of the request when
+// authentication is disabled, otherwise the authenticator authenticates the certificate with its scopes
+func withClientCert(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subject := clientCertSubject(r); subject != "" {
+			r = r.WithContext(reqctx.WithActor(r.Context(), clientCertActor+subject))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,384 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/LearningByExample/go-microservice/internal/_test"
+	"github.com/LearningByExample/go-microservice/internal/app/config"
+	"github.com/LearningByExample/go-microservice/internal/app/data"
+	"github.com/LearningByExample/go-microservice/internal/app/petpb"
+	"github.com/LearningByExample/go-microservice/internal/app/reqctx"
+	"github.com/LearningByExample/go-microservice/internal/app/store/memory"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	grpccreds "google.golang.org/grpc/credentials"
+)
+
+func writeTempFile(t *testing.T, content []byte) string {
+	t.Helper()
+	file, err := ioutil.TempFile("", "tls")
+	if err != nil {
+		t.Fatalf("error creating temp file: %v", err)
+	}
+	defer file.Close()
+	if _, err := file.Write(content); err != nil {
+		t.Fatalf("error writing temp file: %v", err)
+	}
+	return file.Name()
+}
+
+// rewriteFile changes a file moving its modification time ahead, as a rotation could happen in the same second
+func rewriteFile(t *testing.T, path string, content []byte, ahead time.Duration) {
+	t.Helper()
+	if err := ioutil.WriteFile(path, content, 0600); err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+	modified := time.Now().Add(ahead)
+	if err := os.Chtimes(path, modified, modified); err != nil {
+		t.Fatalf("error changing file time: %v", err)
+	}
+}
+
+func servedName(t *testing.T, certs *certReloader) string {
+	t.Helper()
+	cert, _ := certs.getCertificate(nil)
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatalf("error parsing certificate: %v", err)
+	}
+	return leaf.Subject.CommonName
+}
+
+func clientFor(ca *_test.TestCa, certs ...tls.Certificate) *http.Client {
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Cert)
+	return &http.Client{
+		Timeout:   5 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certs}},
+	}
+}
+
+func TestCertReloader(t *testing.T) {
+	ca := _test.NewTestCa()
+	certPem, keyPem := ca.Issue("server-1", true)
+	certFile := writeTempFile(t, certPem)
+	keyFile := writeTempFile(t, keyPem)
+	defer os.Remove(certFile)
+	defer os.Remove(keyFile)
+
+	certs, err := newCertReloader(config.TlsCfg{CertFile: certFile, KeyFile: keyFile})
+	if err != nil {
+		t.Fatalf("want not error, got %v", err)
+	}
+
+	t.Run("should serve the loaded certificate", func(t *testing.T) {
+		if got := servedName(t, certs); got != "server-1" {
+			t.Fatalf("got %q, want %q", got, "server-1")
+		}
+	})
+
+	t.Run("should keep the certificate while the rotation is not complete", func(t *testing.T) {
+		rotatedCert, rotatedKey := ca.Issue("server-2", true)
+		rewriteFile(t, certFile, rotatedCert, time.Minute)
+		if err := certs.reload(); err == nil {
+			t.Fatal("want error, got nil")
+		}
+		if got := servedName(t, certs); got != "server-1" {
+			t.Fatalf("got %q, want %q", got, "server-1")
+		}
+
+		rewriteFile(t, keyFile, rotatedKey, 2*time.Minute)
+		if err := certs.reload(); err != nil {
+			t.Fatalf("want not error, got %v", err)
+		}
+		if got := servedName(t, certs); got != "server-2" {
+			t.Fatalf("got %q, want %q", got, "server-2")
+		}
+	})
+
+	t.Run("should fail without the key pair", func(t *testing.T) {
+		if _, err := newCertReloader(config.TlsCfg{CertFile: certFile, KeyFile: keyFile + ".missing"}); err == nil {
+			t.Fatal("want error, got nil")
+		}
+	})
+}
+
+func TestNewTlsConfig(t *testing.T) {
+	ca := _test.NewTestCa()
+	certPem, keyPem := ca.Issue("server", true)
+	certFile := writeTempFile(t, certPem)
+	keyFile := writeTempFile(t, keyPem)
+	caFile := writeTempFile(t, ca.Pem())
+	defer os.Remove(certFile)
+	defer os.Remove(keyFile)
+	defer os.Remove(caFile)
+	cfg := config.TlsCfg{CertFile: certFile, KeyFile: keyFile, MinVersion: "1.3", ClientCaFile: caFile,
+		ClientAuth: config.ClientAuthOptional}
+
+	t.Run("should get the configured settings", func(t *testing.T) {
+		got, _, err := newTlsConfig(cfg)
+		if err != nil {
+			t.Fatalf("want not error, got %v", err)
+		}
+		if got.MinVersion != tls.VersionTLS13 || got.ClientAuth != tls.VerifyClientCertIfGiven || got.ClientCAs == nil {
+			t.Fatalf("got %v, want TLS 1.3 verifying client certificates if given", got)
+		}
+	})
+
+	t.Run("should fail with an invalid client CA bundle", func(t *testing.T) {
+		invalid := cfg
+		invalid.ClientCaFile = certFile + ".missing"
+		if _, _, err := newTlsConfig(invalid); err == nil {
+			t.Fatal("want error, got nil")
+		}
+		invalid.ClientCaFile = keyFile
+		if _, _, err := newTlsConfig(invalid); err != errInvalidClientCa {
+			t.Fatalf("got %v, want %v", err, errInvalidClientCa)
+		}
+	})
+}
+
+func TestWithClientCert(t *testing.T) {
+	ca := _test.NewTestCa()
+	certPem, keyPem := ca.Issue("server", true)
+	certFile := writeTempFile(t, certPem)
+	keyFile := writeTempFile(t, keyPem)
+	caFile := writeTempFile(t, ca.Pem())
+	defer os.Remove(certFile)
+	defer os.Remove(keyFile)
+	defer os.Remove(caFile)
+
+	tlsConfig, _, err := newTlsConfig(config.TlsCfg{CertFile: certFile, KeyFile: keyFile, MinVersion: "1.2",
+		ClientCaFile: caFile, ClientAuth: config.ClientAuthOptional})
+	if err != nil {
+		t.Fatalf("want not error, got %v", err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	actor := ""
+	hs := &http.Server{Handler: withClientCert(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actor = reqctx.Actor(r.Context())
+	}))}
+	go func() {
+		_ = hs.Serve(tls.NewListener(listener, tlsConfig))
+	}()
+	defer hs.Close()
+	url := fmt.Sprintf("https://%s/", listener.Addr().String())
+
+	clientPem, clientKeyPem := ca.Issue("john", false)
+	clientCert, _ := tls.X509KeyPair(clientPem, clientKeyPem)
+
+	t.Run("should record the subject of the client certificate", func(t *testing.T) {
+		response, err := clientFor(ca, clientCert).Get(url)
+		if err != nil || response.StatusCode != http.StatusOK {
+			t.Fatalf("got %v and %v, want ok", response, err)
+		}
+		_ = response.Body.Close()
+		if want := "cert:CN=john,O=Pets"; actor != want {
+			t.Fatalf("got %q, want %q", actor, want)
+		}
+	})
+
+	t.Run("should be anonymous without a client certificate", func(t *testing.T) {
+		response, err := clientFor(ca).Get(url)
+		if err != nil || response.StatusCode != http.StatusOK {
+			t.Fatalf("got %v and %v, want ok", response, err)
+		}
+		_ = response.Body.Close()
+		if actor != reqctx.Anonymous {
+			t.Fatalf("got %q, want %q", actor, reqctx.Anonymous)
+		}
+	})
+
+	t.Run("should reject a certificate of another CA", func(t *testing.T) {
+		otherPem, otherKeyPem := _test.NewTestCa().Issue("mallory", false)
+		otherCert, _ := tls.X509KeyPair(otherPem, otherKeyPem)
+		if response, err := clientFor(ca, otherCert).Get(url); err == nil {
+			_ = response.Body.Close()
+			t.Fatal("want error, got nil")
+		}
+	})
+}
+
+func TestServerWithTls(t *testing.T) {
+	ca := _test.NewTestCa()
+	certPem, keyPem := ca.Issue("server", true)
+	certFile := writeTempFile(t, certPem)
+	keyFile := writeTempFile(t, keyPem)
+	caFile := writeTempFile(t, ca.Pem())
+	defer os.Remove(certFile)
+	defer os.Remove(keyFile)
+	defer os.Remove(caFile)
+
+	st := _test.NewSpyStore()
+	cfg := config.CfgData{
+		Server: config.ServerCfg{
+			Tls: config.TlsCfg{CertFile: certFile, KeyFile: keyFile, MinVersion: "1.2", ClientCaFile: caFile,
+				ClientAuth: config.ClientAuthRequire},
+		},
+	}
+	srv := NewServer(cfg, &st).(*server)
+
+	addr, errs := serveLocal(t, srv)
+
+	clientPem, clientKeyPem := ca.Issue("john", false)
+	clientCert, _ := tls.X509KeyPair(clientPem, clientKeyPem)
+	url := fmt.Sprintf("https://%s/health/readiness", addr)
+
+	var response *http.Response
+	var err error
+	for i := 0; i < 50; i++ {
+		if response, err = clientFor(ca, clientCert).Get(url); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil || response.StatusCode != http.StatusOK {
+		t.Fatalf("got %v and %v, want ok", response, err)
+	}
+	_ = response.Body.Close()
+
+	if response, err := clientFor(ca).Get(url); err == nil {
+		_ = response.Body.Close()
+		t.Fatal("want error without client certificate, got nil")
+	}
+
+	srv.Stop()
+
+	if got := <-errs; len(got) != 0 {
+		t.Fatalf("want no errors, got %v", got)
+	}
+}
+
+func TestServerWithGrpcTls(t *testing.T) {
+	ca := _test.NewTestCa()
+	certPem, keyPem := ca.Issue("server", true)
+	certFile := writeTempFile(t, certPem)
+	keyFile := writeTempFile(t, keyPem)
+	caFile := writeTempFile(t, ca.Pem())
+	defer os.Remove(certFile)
+	defer os.Remove(keyFile)
+	defer os.Remove(caFile)
+
+	grpcListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	grpcPort := grpcListener.Addr().(*net.TCPAddr).Port
+	_ = grpcListener.Close()
+	cfg := config.CfgData{
+		Server: config.ServerCfg{
+			GrpcPort: grpcPort,
+			Tls: config.TlsCfg{CertFile: certFile, KeyFile: keyFile, MinVersion: "1.2", ClientCaFile: caFile,
+				ClientAuth: config.ClientAuthRequire},
+		},
+		Auth: config.AuthCfg{Enabled: true, ClientCerts: map[string][]string{"CN=john,O=Pets": {data.ScopePetsRead}}},
+	}
+	srv := NewServer(cfg, memory.NewInMemoryPetStore(cfg)).(*server)
+	_, errs := serveLocal(t, srv)
+	defer func() {
+		srv.Stop()
+		if got := <-errs; len(got) != 0 {
+			t.Fatalf("want no errors, got %v", got)
+		}
+	}()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Cert)
+	addr := fmt.Sprintf("localhost:%d", grpcPort)
+	getPet := func(t *testing.T, opt grpc.DialOption) error {
+		t.Helper()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		conn, err := grpc.DialContext(ctx, addr, opt)
+		if err != nil {
+			t.Fatalf("error dialing gRPC server: %v", err)
+		}
+		defer conn.Close()
+		_, err = petpb.NewPetServiceClient(conn).GetPet(ctx, &petpb.GetPetRequest{Id: 999}, grpc.WaitForReady(true))
+		return err
+	}
+	withCert := func(name string) grpc.DialOption {
+		clientPem, clientKeyPem := ca.Issue(name, false)
+		clientCert, _ := tls.X509KeyPair(clientPem, clientKeyPem)
+		return grpc.WithTransportCredentials(grpccreds.NewTLS(&tls.Config{RootCAs: roots,
+			Certificates: []tls.Certificate{clientCert}}))
+	}
+
+	t.Run("should authenticate the client certificate", func(t *testing.T) {
+		if err := getPet(t, withCert("john")); codeOf(err) != codes.NotFound {
+			t.Fatalf("got %v, want %v", err, codes.NotFound)
+		}
+	})
+
+	t.Run("should forbid a client certificate without scopes", func(t *testing.T) {
+		if err := getPet(t, withCert("eve")); codeOf(err) != codes.PermissionDenied {
+			t.Fatalf("got %v, want %v", err, codes.PermissionDenied)
+		}
+	})
+
+	t.Run("should reject plaintext", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+		conn, err := grpc.DialContext(ctx, addr, grpc.WithInsecure())
+		if err != nil {
+			t.Fatalf("error dialing gRPC server: %v", err)
+		}
+		defer conn.Close()
+		_, err = petpb.NewPetServiceClient(conn).GetPet(ctx, &petpb.GetPetRequest{Id: 999})
+		if codeOf(err) != codes.Unavailable {
+			t.Fatalf("got %v, want %v", err, codes.Unavailable)
+		}
+	})
+}
+
+func TestServerWithInvalidTls(t *testing.T) {
+	st := _test.NewSpyStore()
+	cfg := config.CfgData{
+		Server: config.ServerCfg{
+			Port: 8443,
+			Tls:  config.TlsCfg{CertFile: "missing.crt", KeyFile: "missing.key", MinVersion: "1.2"},
+		},
+	}
+	srv := NewServer(cfg, &st).(*server)
+
+	if got := srv.Start(); len(got) != 1 {
+		t.Fatalf("got %v, want certificate error", got)
+	}
+	if !st.CloseWasCall {
+		t.Fatal("close was not called")
+	}
+}
@@ -0,0 +1,67 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package server
+
+import (
+	"encoding/json"
+	"github.com/LearningByExample/go-microservice/internal/app/data"
+	"github.com/LearningByExample/go-microservice/internal/app/resperr"
+	"github.com/LearningByExample/go-microservice/internal/app/store"
+	"net/http"
+)
+
+const (
+	transitionsResource = "transitions"
+	petStatusNotValid   = "pet status is not valid"
+	illegalTransition   = "illegal pet status transition"
+)
+
+type petTransition struct {
+	To data.PetStatus `json:"to"`
+}
+
+func (s petHandler) transitionPetRequest(w http.ResponseWriter, r *http.Request, id int) error {
+	if r.Body == nil {
+		return resperr.NotBodyProvided
+	}
+	transition := petTransition{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&transition); err != nil {
+		return resperr.InvalidResource
+	}
+	if !transition.To.IsValid() {
+		return resperr.FromErrorMessage(resperr.InvalidResource, []string{petStatusNotValid})
+	}
+
+	pet, err := s.dataFor(r).TransitionPet(id, transition.To)
+	switch err {
+	case nil:
+		return writeJson(w, pet)
+	case store.PetNotFound:
+		return resperr.NotFound
+	case store.IllegalStatus:
+		return resperr.FromErrorMessage(resperr.Conflict, []string{illegalTransition})
+	default:
+		return err
+	}
+}
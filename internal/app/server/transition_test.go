@@ -0,0 +1,121 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/LearningByExample/go-microservice/internal/_test"
+	"github.com/LearningByExample/go-microservice/internal/app/data"
+	"github.com/LearningByExample/go-microservice/internal/app/resperr"
+	"github.com/LearningByExample/go-microservice/internal/app/store"
+)
+
+func TestTransitionPetRequest(t *testing.T) {
+	spyStore := _test.NewSpyStore()
+	handler := NewPetHandler(&spyStore)
+
+	type testCase struct {
+		name       string
+		body       string
+		transition func(id int, to data.PetStatus) (data.Pet, error)
+		want       resperr.ResponseError
+		called     bool
+	}
+
+	var cases = []testCase{
+		{
+			name:   "should reserve pet",
+			body:   `{"to":"reserved"}`,
+			want:   resperr.None,
+			called: true,
+		},
+		{
+			name: "should reject illegal transition",
+			body: `{"to":"adopted"}`,
+			transition: func(id int, to data.PetStatus) (data.Pet, error) {
+				return data.Pet{}, store.IllegalStatus
+			},
+			want:   resperr.FromErrorMessage(resperr.Conflict, []string{illegalTransition}),
+			called: true,
+		},
+		{
+			name: "should not found pet",
+			body: `{"to":"reserved"}`,
+			transition: func(id int, to data.PetStatus) (data.Pet, error) {
+				return data.Pet{}, store.PetNotFound
+			},
+			want:   resperr.NotFound,
+			called: true,
+		},
+		{
+			name: "should fail on store error",
+			body: `{"to":"reserved"}`,
+			transition: func(id int, to data.PetStatus) (data.Pet, error) {
+				return data.Pet{}, mockError
+			},
+			want:   resperr.FromError(mockError),
+			called: true,
+		},
+		{
+			name:   "should fail with invalid status",
+			body:   `{"to":"lost"}`,
+			want:   resperr.FromErrorMessage(resperr.InvalidResource, []string{petStatusNotValid}),
+			called: false,
+		},
+		{
+			name:   "should fail with invalid body",
+			body:   `{"to":`,
+			want:   resperr.InvalidResource,
+			called: false,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			spyStore.Reset()
+			if tt.transition != nil {
+				spyStore.WhenTransitionPet(tt.transition)
+			}
+
+			response := _test.PostRequest(handler, "/pets/3/transitions", tt.body)
+
+			_test.AssertResponseError(t, response, tt.want)
+			if spyStore.TransitionWasCall != tt.called {
+				t.Fatalf("got transition call %t, want %t", spyStore.TransitionWasCall, tt.called)
+			}
+			if tt.called && spyStore.Id != 3 {
+				t.Fatalf("got pet %d, want 3", spyStore.Id)
+			}
+			if tt.want.Status() == http.StatusOK {
+				got := data.Pet{}
+				_ = json.NewDecoder(response.Body).Decode(&got)
+				if got.Id != 3 || got.Status != data.Reserved {
+					t.Fatalf("got pet %v, want reserved pet 3", got)
+				}
+			}
+		})
+	}
+}
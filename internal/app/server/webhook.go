@@ -0,0 +1,282 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/LearningByExample/go-microservice/internal/app/constants"
+	"github.com/LearningByExample/go-microservice/internal/app/data"
+	"github.com/LearningByExample/go-microservice/internal/app/events"
+	"github.com/LearningByExample/go-microservice/internal/app/resperr"
+	"github.com/LearningByExample/go-microservice/internal/app/store"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+)
+
+type webhookHandler struct {
+	webhookIdPathReg     *regexp.Regexp
+	webhookNoIdPathReg   *regexp.Regexp
+	webhookDeliveriesReg *regexp.Regexp
+	data                 store.PetStore
+	methods              methodsMap
+}
+
+type webhookRequest struct {
+	Url     string   `json:"url"`
+	Events  []string `json:"events"`
+	Secret  string   `json:"secret"`
+	Enabled *bool    `json:"enabled"`
+}
+
+type deliveryPage struct {
+	Total  int             `json:"total"`
+	Offset int             `json:"offset"`
+	Limit  int             `json:"limit"`
+	Items  []data.Delivery `json:"items"`
+}
+
+const (
+	webhookIdExpr          = `^\/webhooks\/(\d*)$`
+	webhookNotIdExpr       = `^\/webhooks$`
+	webhookDeliveriesExpr  = `^\/webhooks\/(\d+)\/deliveries$`
+	webhookLocation        = "/webhooks/%d"
+	maxSecretLength        = 256
+	maxUrlLength           = 2048
+	defaultDeliveriesLimit = 20
+	maxDeliveriesLimit     = 100
+	webhookUrlNotValid     = "webhook url must be an absolute http or https url of at most 2048 characters"
+	webhookEventsEmpty     = "webhook events cannot be empty"
+	webhookEventNotValid   = "webhook event is not valid"
+	webhookSecretEmpty     = "webhook secret cannot be empty"
+	webhookSecretTooLong   = "webhook secret is too long"
+)
+
+func webhooksFor(s store.PetStore, r *http.Request) store.WebhookStore {
+	return s.WithContext(r.Context()).(store.WebhookStore)
+}
+
+func webhookError(err error) error {
+	if err == store.WebhookNotFound {
+		return resperr.NotFound
+	}
+	return err
+}
+
+func (s webhookHandler) webhookID(path string) (int, error) {
+	matches := s.webhookIdPathReg.FindStringSubmatch(path)
+	if len(matches) == 2 {
+		return strconv.Atoi(matches[1])
+	}
+	return 0, ErrPathNotValid
+}
+
+func validWebhookEvent(event string) bool {
+	switch events.Type(event) {
+	case events.Created, events.Updated, events.Deleted:
+		return true
+	}
+	return false
+}
+
+func validWebhook(webhook webhookRequest, secretRequired bool) error {
+	msg := make([]string, 0, 3)
+
+	if u, err := url.Parse(webhook.Url); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" ||
+		len(webhook.Url) > maxUrlLength {
+		msg = append(msg, webhookUrlNotValid)
+	}
+	if len(webhook.Events) == 0 {
+		msg = append(msg, webhookEventsEmpty)
+	}
+	for _, event := range webhook.Events {
+		if !validWebhookEvent(event) {
+			msg = append(msg, webhookEventNotValid)
+			break
+		}
+	}
+	if webhook.Secret == "" && secretRequired {
+		msg = append(msg, webhookSecretEmpty)
+	} else if len(webhook.Secret) > maxSecretLength {
+		msg = append(msg, webhookSecretTooLong)
+	}
+
+	if len(msg) == 0 {
+		return nil
+	} else {
+		return resperr.FromErrorMessage(resperr.InvalidResource, msg)
+	}
+}
+
+func decodeWebhook(r *http.Request, secretRequired bool) (webhookRequest, error) {
+	webhook := webhookRequest{}
+	if r.Body == nil {
+		return webhook, resperr.NotBodyProvided
+	}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&webhook); err != nil {
+		return webhook, resperr.InvalidResource
+	}
+	webhook.Events = data.SortedTags(webhook.Events)
+	return webhook, validWebhook(webhook, secretRequired)
+}
+
+func (s webhookHandler) getWebhookRequest(w http.ResponseWriter, r *http.Request) error {
+	if s.webhookNoIdPathReg.MatchString(r.URL.Path) {
+		webhooks, err := webhooksFor(s.data, r).GetAllWebhooks()
+		if err != nil {
+			return err
+		}
+		return writeJson(w, webhooks)
+	}
+	if matches := s.webhookDeliveriesReg.FindStringSubmatch(r.URL.Path); len(matches) == 2 {
+		id, _ := strconv.Atoi(matches[1])
+		return s.getWebhookDeliveries(w, r, id)
+	}
+	if id, err := s.webhookID(r.URL.Path); err == nil {
+		webhook, err := webhooksFor(s.data, r).GetWebhook(id)
+		if err != nil {
+			return webhookError(err)
+		}
+		return writeJson(w, webhook)
+	}
+	return resperr.InvalidUrl
+}
+
+func (s webhookHandler) getWebhookDeliveries(w http.ResponseWriter, r *http.Request, id int) error {
+	offset, limit, err := pageParams(r, defaultDeliveriesLimit, maxDeliveriesLimit)
+	if err != nil {
+		return err
+	}
+	deliveries, total, err := webhooksFor(s.data, r).WebhookDeliveries(id, offset, limit)
+	if err != nil {
+		return webhookError(err)
+	}
+	return writeJson(w, deliveryPage{Total: total, Offset: offset, Limit: limit, Items: deliveries})
+}
+
+func (s webhookHandler) postWebhookRequest(w http.ResponseWriter, r *http.Request) error {
+	if !s.webhookNoIdPathReg.MatchString(r.URL.Path) {
+		return resperr.InvalidUrl
+	}
+	webhook, err := decodeWebhook(r, true)
+	if err != nil {
+		return err
+	}
+	enabled := webhook.Enabled == nil || *webhook.Enabled
+	id, err := webhooksFor(s.data, r).AddWebhook(webhook.Url, webhook.Events, webhook.Secret, enabled)
+	if err == nil {
+		w.Header().Add(constants.ContentType, constants.ApplicationJsonUtf8)
+		w.Header().Set(constants.Location, fmt.Sprintf(webhookLocation, id))
+		w.WriteHeader(http.StatusOK)
+	}
+	return err
+}
+
+func (s webhookHandler) putWebhookRequest(w http.ResponseWriter, r *http.Request) error {
+	id, err := s.webhookID(r.URL.Path)
+	if err != nil {
+		return resperr.InvalidUrl
+	}
+	webhook, err := decodeWebhook(r, false)
+	if err != nil {
+		return err
+	}
+	ws := webhooksFor(s.data, r)
+	current, err := ws.GetWebhook(id)
+	if err != nil {
+		return webhookError(err)
+	}
+	if webhook.Secret == "" {
+		webhook.Secret = current.Secret
+	}
+	enabled := webhook.Enabled == nil || *webhook.Enabled
+	change, err := ws.UpdateWebhook(id, webhook.Url, webhook.Events, webhook.Secret, enabled)
+	if err != nil {
+		return webhookError(err)
+	}
+	w.Header().Add(constants.ContentType, constants.ApplicationJsonUtf8)
+	if change {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusNotModified)
+	}
+	return nil
+}
+
+func (s webhookHandler) deleteWebhookRequest(w http.ResponseWriter, r *http.Request) error {
+	id, err := s.webhookID(r.URL.Path)
+	if err != nil {
+		return resperr.InvalidUrl
+	}
+	if err = webhooksFor(s.data, r).DeleteWebhook(id); err != nil {
+		return webhookError(err)
+	}
+	w.Header().Add(constants.ContentType, constants.ApplicationJsonUtf8)
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+func (s webhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var rErr = resperr.None
+
+	if method, found := s.methods[r.Method]; found {
+		if err := method(w, r); err != nil {
+			rErr = resperr.FromError(err)
+		}
+	} else {
+		rErr = resperr.BadRequest
+	}
+
+	if rErr.Status() != http.StatusOK {
+		log.Printf("Error %v in %s request %q", rErr, r.Method, r.URL.Path)
+	}
+
+	if rErr.Status() != resperr.None.Status() {
+		rErr.Write(w)
+	}
+}
+
+func (s webhookHandler) addMethod(httpMethod string, handlerFunc handlerFunc) {
+	s.methods[httpMethod] = handlerFunc
+}
+
+func NewWebhookHandler(store store.PetStore) http.Handler {
+	wh := webhookHandler{
+		webhookIdPathReg:     regexp.MustCompile(webhookIdExpr),
+		webhookNoIdPathReg:   regexp.MustCompile(webhookNotIdExpr),
+		webhookDeliveriesReg: regexp.MustCompile(webhookDeliveriesExpr),
+		data:                 store,
+		methods:              make(methodsMap),
+	}
+
+	wh.addMethod(http.MethodGet, wh.getWebhookRequest)
+	wh.addMethod(http.MethodPost, wh.postWebhookRequest)
+	wh.addMethod(http.MethodPut, wh.putWebhookRequest)
+	wh.addMethod(http.MethodDelete, wh.deleteWebhookRequest)
+
+	return wh
+}
@@ -0,0 +1,333 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/LearningByExample/go-microservice/internal/_test"
+	"github.com/LearningByExample/go-microservice/internal/app/constants"
+	"github.com/LearningByExample/go-microservice/internal/app/data"
+	"github.com/LearningByExample/go-microservice/internal/app/resperr"
+	"github.com/LearningByExample/go-microservice/internal/app/store"
+)
+
+func TestWebhookRequests(t *testing.T) {
+	spyStore := _test.NewSpyStore()
+	handler := NewWebhookHandler(&spyStore)
+	webhook := webhookRequest{Url: "https://example.com/hook", Events: []string{"created"}, Secret: "secret"}
+
+	type testCase struct {
+		name    string
+		method  string
+		path    string
+		body    interface{}
+		prepare func()
+		want    resperr.ResponseError
+		status  int
+	}
+
+	var cases = []testCase{
+		{
+			name:   "should get all webhooks",
+			method: http.MethodGet,
+			path:   "/webhooks",
+			want:   resperr.None,
+			status: http.StatusOK,
+		},
+		{
+			name:   "should get webhook",
+			method: http.MethodGet,
+			path:   "/webhooks/1",
+			want:   resperr.None,
+			status: http.StatusOK,
+		},
+		{
+			name:   "should not found webhook",
+			method: http.MethodGet,
+			path:   "/webhooks/1",
+			prepare: func() {
+				spyStore.WhenGetWebhook(func(id int) (data.Webhook, error) {
+					return data.Webhook{}, store.WebhookNotFound
+				})
+			},
+			want:   resperr.NotFound,
+			status: http.StatusNotFound,
+		},
+		{
+			name:   "should fail with invalid url",
+			method: http.MethodGet,
+			path:   "/webhooks/1/other",
+			want:   resperr.InvalidUrl,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "should add webhook",
+			method: http.MethodPost,
+			path:   "/webhooks",
+			body:   webhook,
+			want:   resperr.None,
+			status: http.StatusOK,
+		},
+		{
+			name:   "should not add webhook with an id",
+			method: http.MethodPost,
+			path:   "/webhooks/1",
+			body:   webhook,
+			want:   resperr.InvalidUrl,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "should not add invalid webhook",
+			method: http.MethodPost,
+			path:   "/webhooks",
+			body:   webhookRequest{Url: "ftp://example.com", Events: []string{"adopted"}},
+			want: resperr.FromErrorMessage(resperr.InvalidResource,
+				[]string{webhookUrlNotValid, webhookEventNotValid, webhookSecretEmpty}),
+			status: http.StatusUnprocessableEntity,
+		},
+		{
+			name:   "should not add webhook without events",
+			method: http.MethodPost,
+			path:   "/webhooks",
+			body: webhookRequest{Url: "http://example.com", Events: []string{},
+				Secret: strings.Repeat("s", maxSecretLength+1)},
+			want:   resperr.FromErrorMessage(resperr.InvalidResource, []string{webhookEventsEmpty, webhookSecretTooLong}),
+			status: http.StatusUnprocessableEntity,
+		},
+		{
+			name:   "should not add webhook with a too long url",
+			method: http.MethodPost,
+			path:   "/webhooks",
+			body: webhookRequest{Url: "https://example.com/" + strings.Repeat("a", maxUrlLength), Events: []string{"created"},
+				Secret: "s3cr3t"},
+			want:   resperr.FromErrorMessage(resperr.InvalidResource, []string{webhookUrlNotValid}),
+			status: http.StatusUnprocessableEntity,
+		},
+		{
+			name:   "should not add webhook with a bad body",
+			method: http.MethodPost,
+			path:   "/webhooks",
+			body:   "bad",
+			want:   resperr.InvalidResource,
+			status: http.StatusUnprocessableEntity,
+		},
+		{
+			name:   "should update webhook",
+			method: http.MethodPut,
+			path:   "/webhooks/1",
+			body:   webhook,
+			prepare: func() {
+				spyStore.WhenUpdateWebhook(func(id int, url string, events []string, secret string, enabled bool) (bool, error) {
+					return true, nil
+				})
+			},
+			want:   resperr.None,
+			status: http.StatusOK,
+		},
+		{
+			name:   "should not modify webhook",
+			method: http.MethodPut,
+			path:   "/webhooks/1",
+			body:   webhook,
+			status: http.StatusNotModified,
+		},
+		{
+			name:   "should not update webhook that does not exist",
+			method: http.MethodPut,
+			path:   "/webhooks/1",
+			body:   webhook,
+			prepare: func() {
+				spyStore.WhenGetWebhook(func(id int) (data.Webhook, error) {
+					return data.Webhook{}, store.WebhookNotFound
+				})
+			},
+			want:   resperr.NotFound,
+			status: http.StatusNotFound,
+		},
+		{
+			name:   "should delete webhook",
+			method: http.MethodDelete,
+			path:   "/webhooks/1",
+			want:   resperr.None,
+			status: http.StatusOK,
+		},
+		{
+			name:   "should not delete webhook that does not exist",
+			method: http.MethodDelete,
+			path:   "/webhooks/1",
+			prepare: func() {
+				spyStore.WhenDeleteWebhook(func(id int) error {
+					return store.WebhookNotFound
+				})
+			},
+			want:   resperr.NotFound,
+			status: http.StatusNotFound,
+		},
+		{
+			name:   "should not found deliveries of unknown webhook",
+			method: http.MethodGet,
+			path:   "/webhooks/1/deliveries",
+			prepare: func() {
+				spyStore.WhenWebhookDeliveries(func(id int, offset int, limit int) ([]data.Delivery, int, error) {
+					return nil, 0, store.WebhookNotFound
+				})
+			},
+			want:   resperr.NotFound,
+			status: http.StatusNotFound,
+		},
+		{
+			name:   "should fail deliveries with invalid page",
+			method: http.MethodGet,
+			path:   "/webhooks/1/deliveries?limit=1000",
+			want:   resperr.InvalidUrl,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "should fail with bad method",
+			method: http.MethodPatch,
+			path:   "/webhooks/1",
+			want:   resperr.BadRequest,
+			status: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			spyStore.Reset()
+			if tt.prepare != nil {
+				tt.prepare()
+			}
+
+			response := _test.HeaderRequest(handler, tt.path, tt.method, requestBody(tt.body), nil)
+
+			if response.Code != tt.status {
+				t.Fatalf("got status %d, want %d", response.Code, tt.status)
+			}
+			if tt.status != http.StatusNotModified {
+				_test.AssertResponseError(t, response, tt.want)
+			}
+		})
+	}
+}
+
+func requestBody(body interface{}) string {
+	switch v := body.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	default:
+		bytes, _ := json.Marshal(v)
+		return string(bytes)
+	}
+}
+
+func TestWebhookRequestParameters(t *testing.T) {
+	spyStore := _test.NewSpyStore()
+	handler := NewWebhookHandler(&spyStore)
+
+	t.Run("should add webhook with location", func(t *testing.T) {
+		spyStore.Reset()
+		spyStore.WhenAddWebhook(func(url string, events []string, secret string, enabled bool) (int, error) {
+			return 7, nil
+		})
+
+		response := _test.PostRequest(handler, "/webhooks",
+			webhookRequest{Url: "http://example.com", Events: []string{"deleted", "created", "deleted"}, Secret: "s"})
+
+		if got := response.Header().Get(constants.Location); got != "/webhooks/7" {
+			t.Fatalf("got location %q, want %q", got, "/webhooks/7")
+		}
+		want := data.Webhook{Id: 7, Url: "http://example.com", Events: []string{"created", "deleted"}, Secret: "s",
+			Enabled: true}
+		if !reflect.DeepEqual(spyStore.WebhookParameters, want) || spyStore.UpdateWebhookWasCall {
+			t.Fatalf("got webhook %v, want %v", spyStore.WebhookParameters, want)
+		}
+	})
+
+	t.Run("should add disabled webhook", func(t *testing.T) {
+		spyStore.Reset()
+		enabled := false
+
+		_ = _test.PostRequest(handler, "/webhooks",
+			webhookRequest{Url: "http://example.com", Events: []string{"created"}, Secret: "s", Enabled: &enabled})
+
+		if !spyStore.AddWebhookWasCall || spyStore.UpdateWebhookWasCall || spyStore.WebhookParameters.Enabled {
+			t.Fatalf("got webhook %v, want added disabled", spyStore.WebhookParameters)
+		}
+	})
+
+	t.Run("should keep the secret when updating without it", func(t *testing.T) {
+		spyStore.Reset()
+		spyStore.WhenGetWebhook(func(id int) (data.Webhook, error) {
+			return data.Webhook{Id: id, Secret: "current"}, nil
+		})
+
+		_ = _test.PutRequest(handler, "/webhooks/3", webhookRequest{Url: "http://example.com", Events: []string{"created"}})
+
+		want := data.Webhook{Id: 3, Url: "http://example.com", Events: []string{"created"}, Secret: "current",
+			Enabled: true}
+		if !reflect.DeepEqual(spyStore.WebhookParameters, want) {
+			t.Fatalf("got webhook %v, want %v", spyStore.WebhookParameters, want)
+		}
+	})
+
+	t.Run("should get webhook without secret", func(t *testing.T) {
+		spyStore.Reset()
+		spyStore.WhenGetWebhook(func(id int) (data.Webhook, error) {
+			return data.Webhook{Id: id, Url: "http://example.com", Secret: "secret"}, nil
+		})
+
+		response := _test.GetRequest(handler, "/webhooks/3")
+
+		if body := response.Body.String(); strings.Contains(body, "secret") {
+			t.Fatalf("got %q, want no secret", body)
+		}
+	})
+
+	t.Run("should get webhook deliveries", func(t *testing.T) {
+		spyStore.Reset()
+		deliveries := []data.Delivery{{Id: 1, WebhookId: 3, Event: "created", Payload: "{}",
+			Status: data.DeliveryDelivered, Attempts: []data.DeliveryAttempt{{StatusCode: 200}}}}
+		spyStore.WhenWebhookDeliveries(func(id int, offset int, limit int) ([]data.Delivery, int, error) {
+			return deliveries, 4, nil
+		})
+
+		response := _test.GetRequest(handler, "/webhooks/3/deliveries?offset=3&limit=1")
+
+		got := deliveryPage{}
+		_ = json.NewDecoder(response.Body).Decode(&got)
+		want := deliveryPage{Total: 4, Offset: 3, Limit: 1, Items: deliveries}
+		if response.Code != http.StatusOK || !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %d %v, want %v", response.Code, got, want)
+		}
+		if spyStore.WebhookId != 3 || spyStore.Offset != 3 || spyStore.Limit != 1 {
+			t.Fatalf("got %d %d %d, want 3 3 1", spyStore.WebhookId, spyStore.Offset, spyStore.Limit)
+		}
+	})
+}
@@ -0,0 +1,109 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package memory
+
+import (
+	"github.com/LearningByExample/go-microservice/internal/app/data"
+	"github.com/LearningByExample/go-microservice/internal/app/store"
+	"sort"
+)
+
+func copyApiKey(key data.ApiKey) data.ApiKey {
+	key.Scopes = append(make([]string, 0, len(key.Scopes)), key.Scopes...)
+	return key
+}
+
+func (s *inMemoryPetStore) AddApiKey(name string, prefix string, hash string, scopes []string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastKeyId++
+	s.apiKeys[s.lastKeyId] = copyApiKey(data.ApiKey{Id: s.lastKeyId, Name: name, Prefix: prefix, Hash: hash,
+		Scopes: scopes, CreatedAt: s.now()})
+	return s.lastKeyId, nil
+}
+
+func (s *inMemoryPetStore) GetApiKey(id int) (data.ApiKey, error) {
+	var err error = nil
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	key, found := s.apiKeys[id]
+
+	if !found {
+		err = store.ApiKeyNotFound
+	}
+	return copyApiKey(key), err
+}
+
+func (s *inMemoryPetStore) GetAllApiKeys() ([]data.ApiKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := make([]data.ApiKey, 0, len(s.apiKeys))
+	for _, key := range s.apiKeys {
+		keys = append(keys, copyApiKey(key))
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].Id < keys[j].Id
+	})
+	return keys, nil
+}
+
+func (s *inMemoryPetStore) FindApiKey(hash string) (data.ApiKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, key := range s.apiKeys {
+		if key.Hash == hash && !key.IsRevoked() {
+			return copyApiKey(key), nil
+		}
+	}
+	return data.ApiKey{}, store.ApiKeyNotFound
+}
+
+// RotateApiKey replaces the hash of a key that is not revoked, so the previous key stops working
+func (s *inMemoryPetStore) RotateApiKey(id int, prefix string, hash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, found := s.apiKeys[id]
+	if !found || key.IsRevoked() {
+		return store.ApiKeyNotFound
+	}
+	now := s.now()
+	key.Prefix, key.Hash, key.RotatedAt = prefix, hash, &now
+	s.apiKeys[id] = key
+	return nil
+}
+
+func (s *inMemoryPetStore) RevokeApiKey(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, found := s.apiKeys[id]
+	if !found {
+		return store.ApiKeyNotFound
+	}
+	if !key.IsRevoked() {
+		now := s.now()
+		key.RevokedAt = &now
+		s.apiKeys[id] = key
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package memory
+
+import (
+	"github.com/LearningByExample/go-microservice/internal/app/data"
+	"github.com/LearningByExample/go-microservice/internal/app/store"
+	"reflect"
+	"testing"
+)
+
+func TestApiKeys(t *testing.T) {
+	ps := newTestPetStore()
+
+	id, _ := ps.AddApiKey("ci", "pk_1234", "hash", []string{data.ScopePetsRead})
+
+	t.Run("should get api key", func(t *testing.T) {
+		got, err := ps.GetApiKey(id)
+		want := data.ApiKey{Id: id, Name: "ci", Prefix: "pk_1234", Hash: "hash", Scopes: []string{data.ScopePetsRead},
+			CreatedAt: testNow}
+
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %v and %v, want %v", got, err, want)
+		}
+		if _, err := ps.GetApiKey(id + 1); err != store.ApiKeyNotFound {
+			t.Fatalf("got %v, want %v", err, store.ApiKeyNotFound)
+		}
+	})
+
+	t.Run("should find api key by hash", func(t *testing.T) {
+		if got, err := ps.FindApiKey("hash"); err != nil || got.Id != id {
+			t.Fatalf("got %v and %v, want key %d", got, err, id)
+		}
+		if _, err := ps.FindApiKey("other"); err != store.ApiKeyNotFound {
+			t.Fatalf("got %v, want %v", err, store.ApiKeyNotFound)
+		}
+	})
+
+	t.Run("should rotate api key", func(t *testing.T) {
+		if err := ps.RotateApiKey(id, "pk_5678", "rotated"); err != nil {
+			t.Fatalf("want not error, got %v", err)
+		}
+		if _, err := ps.FindApiKey("hash"); err != store.ApiKeyNotFound {
+			t.Fatalf("got %v, want %v", err, store.ApiKeyNotFound)
+		}
+		if got, err := ps.FindApiKey("rotated"); err != nil || got.Prefix != "pk_5678" || got.RotatedAt == nil {
+			t.Fatalf("got %v and %v, want rotated key", got, err)
+		}
+	})
+
+	t.Run("should revoke api key", func(t *testing.T) {
+		if err := ps.RevokeApiKey(id); err != nil {
+			t.Fatalf("want not error, got %v", err)
+		}
+		if _, err := ps.FindApiKey("rotated"); err != store.ApiKeyNotFound {
+			t.Fatalf("got %v, want %v", err, store.ApiKeyNotFound)
+		}
+		if err := ps.RotateApiKey(id, "pk_9012", "again"); err != store.ApiKeyNotFound {
+			t.Fatalf("got %v, want %v", err, store.ApiKeyNotFound)
+		}
+		if err := ps.RevokeApiKey(id + 1); err != store.ApiKeyNotFound {
+			t.Fatalf("got %v, want %v", err, store.ApiKeyNotFound)
+		}
+	})
+
+	t.Run("should get all api keys", func(t *testing.T) {
+		other, _ := ps.AddApiKey("admin", "pk_0000", "admin", []string{data.ScopeAdmin})
+		keys, _ := ps.GetAllApiKeys()
+
+		if len(keys) != 2 || keys[0].Id != id || !keys[0].IsRevoked() || keys[1].Id != other {
+			t.Fatalf("got %v, want keys %d and %d", keys, id, other)
+		}
+	})
+}
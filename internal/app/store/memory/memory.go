@@ -23,45 +23,275 @@
 package memory
 
 import (
+	"context"
 	"github.com/LearningByExample/go-microservice/internal/app/config"
 	"github.com/LearningByExample/go-microservice/internal/app/data"
+	"github.com/LearningByExample/go-microservice/internal/app/reqctx"
 	"github.com/LearningByExample/go-microservice/internal/app/store"
 	"log"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 )
 
+type memoryState struct {
+	pets         data.PetMap
+	owners       map[int]data.Owner
+	webhooks     map[int]data.Webhook
+	deliveries   map[int]data.Delivery
+	apiKeys      map[int]data.ApiKey
+	history      map[int][]data.PetChange
+	index        termIndex
+	tagIndex     termIndex
+	attrIndex    termIndex
+	mu           sync.RWMutex
+	lastId       int
+	lastOwnerId  int
+	lastChangeId int
+	lastHookId   int
+	lastDelivery int
+	lastKeyId    int
+	now          func() time.Time
+}
+
 type inMemoryPetStore struct {
-	pets   data.PetMap
-	mu     sync.RWMutex
-	lastId int
+	*memoryState
+	ctx context.Context
 }
 
 const (
-	StoreName = "in-memory"
+	StoreName    = "in-memory"
+	historyLimit = 100
 )
 
 func (s *inMemoryPetStore) IsReady() error {
 	return nil
 }
 
-func (s *inMemoryPetStore) DeletePet(id int) error {
-	_, err := s.GetPet(id)
-	if err != nil {
-		return err
+func (s *inMemoryPetStore) livePet(id int) (data.Pet, bool) {
+	pet, found := s.pets[id]
+	if found && pet.IsDeleted() {
+		return data.Pet{}, false
+	}
+	return pet, found
+}
+
+func (s *inMemoryPetStore) findPets(query store.PetQuery) []data.Pet {
+	var ids map[int]bool = nil
+	if len(query.Ids) != 0 {
+		ids = make(map[int]bool, len(query.Ids))
+		for _, id := range query.Ids {
+			ids[id] = true
+		}
+	}
+
+	tagged := s.taggedIds(query)
+
+	result := make([]data.Pet, 0)
+	for _, pet := range s.pets.Values() {
+		if (query.IncludeDeleted || !pet.IsDeleted()) && (ids == nil || ids[pet.Id]) &&
+			(tagged == nil || tagged[pet.Id]) && (query.OwnerId == 0 || pet.Owner() == query.OwnerId) &&
+			!pet.UpdatedAt.Before(query.UpdatedSince) && pet.Id > query.AfterId {
+			result = append(result, pet)
+		}
+	}
+	if len(query.Sort) != 0 {
+		sort.SliceStable(result, func(i, j int) bool {
+			return lessPet(result[i], result[j], query.Sort)
+		})
 	}
+	if query.Limit > 0 && len(result) > query.Limit {
+		result = result[:query.Limit]
+	}
+	return result
+}
 
+func comparePets(a data.Pet, b data.Pet, field store.SortField) int {
+	switch field {
+	case store.SortByName:
+		return strings.Compare(a.Name, b.Name)
+	case store.SortByRace:
+		return strings.Compare(a.Race, b.Race)
+	case store.SortByMod:
+		return strings.Compare(a.Mod, b.Mod)
+	case store.SortByCreatedAt:
+		return compareTimes(a.CreatedAt, b.CreatedAt)
+	case store.SortByUpdatedAt:
+		return compareTimes(a.UpdatedAt, b.UpdatedAt)
+	default:
+		return a.Id - b.Id
+	}
+}
+
+func compareTimes(a time.Time, b time.Time) int {
+	if a.Before(b) {
+		return -1
+	} else if a.After(b) {
+		return 1
+	}
+	return 0
+}
+
+func lessPet(a data.Pet, b data.Pet, sorts []store.PetSort) bool {
+	for _, by := range sorts {
+		if c := comparePets(a, b, by.Field); c != 0 {
+			return (c < 0) != by.Descending
+		}
+	}
+	return false
+}
+
+func (s *inMemoryPetStore) recordChange(action data.PetAction, id int, before *data.Pet, after *data.Pet) func() {
+	s.lastChangeId++
+	change := data.PetChange{
+		Id:        s.lastChangeId,
+		PetId:     id,
+		Action:    action,
+		Timestamp: s.now(),
+		Actor:     reqctx.Actor(s.ctx),
+		RequestId: reqctx.RequestId(s.ctx),
+		Before:    before,
+		After:     after,
+	}
+
+	old := s.history[id]
+	changes := make([]data.PetChange, 0, len(old)+1)
+	if len(old) >= historyLimit {
+		changes = append(changes, old[len(old)-historyLimit+1:]...)
+	} else {
+		changes = append(changes, old...)
+	}
+	s.history[id] = append(changes, change)
+
+	// the undo only rolls back a change that was never committed, like the rollback of a transaction
+	return func() {
+		if old == nil {
+			delete(s.history, id)
+		} else {
+			s.history[id] = old
+		}
+	}
+}
+
+func (s *inMemoryPetStore) createPet(pet data.Pet) (int, func()) {
+	s.lastId++
+	id := s.lastId
+	now := s.now()
+	created := data.Pet{Id: id, Name: pet.Name, Race: pet.Race, Mod: pet.Mod, Status: data.Available,
+		CreatedAt: now, UpdatedAt: now}
+	s.pets[id] = created
+	s.index.add(created)
+	undo := s.recordChange(data.CreateAction, id, nil, &created)
+	return id, func() {
+		undo()
+		s.index.remove(created)
+		delete(s.pets, id)
+	}
+}
+
+func (s *inMemoryPetStore) updatePet(id int, pet data.Pet) (bool, func(), error) {
+	old, found := s.livePet(id)
+	if !found {
+		return false, nil, store.PetNotFound
+	}
+	if petEquals(old, pet.Name, pet.Race, pet.Mod) {
+		return false, func() {}, nil
+	}
+	updated := old
+	updated.Name, updated.Race, updated.Mod = pet.Name, pet.Race, pet.Mod
+	updated.UpdatedAt = s.now()
+	s.pets[id] = updated
+	s.index.remove(old)
+	s.index.add(updated)
+	undo := s.recordChange(data.UpdateAction, id, &old, &updated)
+	return true, func() {
+		undo()
+		s.index.remove(updated)
+		s.index.add(old)
+		s.pets[id] = old
+	}, nil
+}
+
+func (s *inMemoryPetStore) deletePet(id int) (func(), error) {
+	old, found := s.livePet(id)
+	if !found {
+		return nil, store.PetNotFound
+	}
+	deleted := old
+	now := s.now()
+	deleted.DeletedAt = &now
+	deleted.UpdatedAt = now
+	s.pets[id] = deleted
+	s.index.remove(old)
+	undo := s.recordChange(data.DeleteAction, id, &old, &deleted)
+	return func() {
+		undo()
+		s.index.add(old)
+		s.pets[id] = old
+	}, nil
+}
+
+func (s *inMemoryPetStore) DeletePet(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.deletePet(id)
+	return err
+}
+
+func (s *inMemoryPetStore) RestorePet(id int) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	delete(s.pets, id)
+	pet, found := s.pets[id]
+	if !found || !pet.IsDeleted() {
+		return store.PetNotFound
+	}
+	restored := pet
+	restored.DeletedAt = nil
+	restored.UpdatedAt = s.now()
+	s.pets[id] = restored
+	s.index.add(restored)
+	s.recordChange(data.RestoreAction, id, &pet, &restored)
 	return nil
 }
 
+func (s *inMemoryPetStore) TransitionPet(id int, to data.PetStatus) (data.Pet, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pet, found := s.livePet(id)
+	if !found {
+		return data.Pet{}, store.PetNotFound
+	}
+	if !pet.Status.CanTransitionTo(to) {
+		return data.Pet{}, store.IllegalStatus
+	}
+	updated := pet
+	updated.Status = to
+	updated.UpdatedAt = s.now()
+	s.pets[id] = updated
+	s.recordChange(data.TransitionAction, id, &pet, &updated)
+	return updated, nil
+}
+
+func (s *inMemoryPetStore) PurgePets(before time.Time) ([]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := make([]int, 0)
+	for id, pet := range s.pets {
+		if pet.IsDeleted() && pet.DeletedAt.Before(before) {
+			s.unindexTags(pet)
+			delete(s.pets, id)
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
+
 func (s *inMemoryPetStore) AddPet(name string, race string, mod string) (int, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.lastId++
-	id := s.lastId
-	s.pets[id] = data.Pet{Id: id, Name: name, Race: race, Mod: mod}
+	id, _ := s.createPet(data.Pet{Name: name, Race: race, Mod: mod})
 	return id, nil
 }
 
@@ -70,7 +300,7 @@ func (s *inMemoryPetStore) GetPet(id int) (data.Pet, error) {
 
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	value, found := s.pets[id]
+	value, found := s.livePet(id)
 
 	if !found {
 		err = store.PetNotFound
@@ -79,9 +309,20 @@ func (s *inMemoryPetStore) GetPet(id int) (data.Pet, error) {
 }
 
 func (s *inMemoryPetStore) GetAllPets() ([]data.Pet, error) {
+	return s.FindPets(store.PetQuery{})
+}
+
+func (s *inMemoryPetStore) FindPets(query store.PetQuery) ([]data.Pet, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	return s.pets.Values(), nil
+	return s.findPets(query), nil
+}
+
+func (s *inMemoryPetStore) CountPets(query store.PetQuery) (int, error) {
+	query.AfterId, query.Limit = 0, 0
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.findPets(query)), nil
 }
 
 func petEquals(p data.Pet, name string, race string, mod string) bool {
@@ -89,19 +330,131 @@ func petEquals(p data.Pet, name string, race string, mod string) bool {
 }
 
 func (s *inMemoryPetStore) UpdatePet(id int, name string, race string, mod string) (bool, error) {
-	var change = false
-	found, err := s.GetPet(id)
-
-	if err == nil {
-		change = !petEquals(found, name, race, mod)
-		if change {
-			s.mu.Lock()
-			defer s.mu.Unlock()
-			s.pets[id] = data.Pet{Id: id, Name: name, Race: race, Mod: mod}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	change, _, err := s.updatePet(id, data.Pet{Name: name, Race: race, Mod: mod})
+	return change, err
+}
+
+func (s *inMemoryPetStore) ForEachPet(fn func(pet data.Pet) error) error {
+	s.mu.RLock()
+	pets := s.findPets(store.PetQuery{})
+	s.mu.RUnlock()
+
+	for _, pet := range pets {
+		if err := fn(pet); err != nil {
+			return err
 		}
 	}
+	return nil
+}
 
-	return change, err
+func (s *inMemoryPetStore) importPet(pet data.Pet) int {
+	s.lastId++
+	id := s.lastId
+	imported := data.Pet{Id: id, Name: pet.Name, Race: pet.Race, Mod: pet.Mod, Status: pet.Status,
+		OwnerId: ownerRef(pet.Owner()), Tags: data.SortedTags(pet.Tags), Attributes: copyAttributes(pet.Attributes),
+		CreatedAt: pet.CreatedAt, UpdatedAt: pet.UpdatedAt}
+	if imported.Status == "" {
+		imported.Status = data.Available
+	}
+	if imported.CreatedAt.IsZero() {
+		imported.CreatedAt = s.now()
+	}
+	if imported.UpdatedAt.IsZero() {
+		imported.UpdatedAt = imported.CreatedAt
+	}
+	s.pets[id] = imported
+	s.index.add(imported)
+	s.indexTags(imported)
+	s.recordChange(data.CreateAction, id, nil, &imported)
+	return id
+}
+
+func (s *inMemoryPetStore) ImportPets(pets []data.Pet) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, pet := range pets {
+		if _, found := s.owners[pet.Owner()]; pet.OwnerId != nil && !found {
+			return store.InvalidOwner
+		}
+	}
+	for i := range pets {
+		pets[i].Id = s.importPet(pets[i])
+	}
+	return nil
+}
+
+func (s *inMemoryPetStore) applyOperation(op store.PetOperation) (store.PetOperationResult, func()) {
+	var result = store.PetOperationResult{Id: op.Pet.Id}
+	var revert = func() {}
+	pet := op.Pet
+
+	switch op.Type {
+	case store.CreateOperation:
+		result.Id, revert = s.createPet(pet)
+		result.Changed = true
+	case store.UpdateOperation:
+		result.Changed, revert, result.Err = s.updatePet(pet.Id, pet)
+	case store.DeleteOperation:
+		if revert, result.Err = s.deletePet(pet.Id); result.Err == nil {
+			result.Changed = true
+		}
+	default:
+		result.Err = store.InvalidOperation
+	}
+
+	return result, revert
+}
+
+func (s *inMemoryPetStore) BatchPets(ops []store.PetOperation, atomic bool) ([]store.PetOperationResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lastId, lastChangeId := s.lastId, s.lastChangeId
+	results := make([]store.PetOperationResult, len(ops))
+	reverts := make([]func(), 0, len(ops))
+	for i, op := range ops {
+		result, revert := s.applyOperation(op)
+		if result.Err != nil && atomic {
+			for j := len(reverts) - 1; j >= 0; j-- {
+				reverts[j]()
+			}
+			s.lastId, s.lastChangeId = lastId, lastChangeId
+			results = make([]store.PetOperationResult, len(ops))
+			results[i] = result
+			break
+		}
+		results[i] = result
+		reverts = append(reverts, revert)
+	}
+
+	return results, nil
+}
+
+func (s *inMemoryPetStore) PetHistory(id int, offset int, limit int) ([]data.PetChange, int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	changes := s.history[id]
+	total := len(changes)
+	if _, found := s.pets[id]; !found && total == 0 {
+		return nil, 0, store.PetNotFound
+	}
+
+	result := make([]data.PetChange, 0)
+	if offset < total {
+		end := total
+		if limit > 0 && offset+limit < total {
+			end = offset + limit
+		}
+		result = append(result, changes[offset:end]...)
+	}
+	return result, total, nil
+}
+
+func (s *inMemoryPetStore) WithContext(ctx context.Context) store.PetStore {
+	return &inMemoryPetStore{memoryState: s.memoryState, ctx: ctx}
 }
 
 func (s *inMemoryPetStore) Open() error {
@@ -116,8 +469,20 @@ func (s *inMemoryPetStore) Close() error {
 
 func NewInMemoryPetStore(_ config.CfgData) store.PetStore {
 	var petStore = inMemoryPetStore{
-		pets:   make(data.PetMap),
-		lastId: 0,
+		memoryState: &memoryState{
+			pets:       make(data.PetMap),
+			owners:     make(map[int]data.Owner),
+			webhooks:   make(map[int]data.Webhook),
+			deliveries: make(map[int]data.Delivery),
+			apiKeys:    make(map[int]data.ApiKey),
+			history:    make(map[int][]data.PetChange),
+			index:      make(termIndex),
+			tagIndex:   make(termIndex),
+			attrIndex:  make(termIndex),
+			lastId:     0,
+			now:        time.Now,
+		},
+		ctx: context.Background(),
 	}
 
 	return &petStore
@@ -23,15 +23,28 @@
 package memory
 
 import (
+	"context"
 	"fmt"
 	"github.com/LearningByExample/go-microservice/internal/app/config"
 	"github.com/LearningByExample/go-microservice/internal/app/data"
+	"github.com/LearningByExample/go-microservice/internal/app/reqctx"
 	"github.com/LearningByExample/go-microservice/internal/app/store"
 	"reflect"
 	"sync"
 	"testing"
+	"time"
 )
 
+var testNow = time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+
+func newTestPetStore() *inMemoryPetStore {
+	ps := NewInMemoryPetStore(config.CfgData{}).(*inMemoryPetStore)
+	ps.now = func() time.Time {
+		return testNow
+	}
+	return ps
+}
+
 func TestNewPetStore(t *testing.T) {
 
 	got := NewInMemoryPetStore(config.CfgData{})
@@ -42,16 +55,19 @@ func TestNewPetStore(t *testing.T) {
 }
 
 func TestAddNewPet(t *testing.T) {
-	ps := NewInMemoryPetStore(config.CfgData{})
+	ps := newTestPetStore()
 
 	id, _ := ps.AddPet("Fluff", "dog", "happy")
 
 	got, _ := ps.GetPet(id)
 	want := data.Pet{
-		Id:   id,
-		Name: "Fluff",
-		Race: "dog",
-		Mod:  "happy",
+		Id:        id,
+		Name:      "Fluff",
+		Race:      "dog",
+		Mod:       "happy",
+		Status:    data.Available,
+		CreatedAt: testNow,
+		UpdatedAt: testNow,
 	}
 
 	if !reflect.DeepEqual(got, want) {
@@ -60,17 +76,20 @@ func TestAddNewPet(t *testing.T) {
 }
 
 func TestAddMultiplePets(t *testing.T) {
-	ps := NewInMemoryPetStore(config.CfgData{})
+	ps := newTestPetStore()
 
 	_, _ = ps.AddPet("Fluff", "dog", "happy")
 	id, _ := ps.AddPet("Lion", "cat", "brave")
 
 	got, _ := ps.GetPet(id)
 	want := data.Pet{
-		Id:   id,
-		Name: "Lion",
-		Race: "cat",
-		Mod:  "brave",
+		Id:        id,
+		Name:      "Lion",
+		Race:      "cat",
+		Mod:       "brave",
+		Status:    data.Available,
+		CreatedAt: testNow,
+		UpdatedAt: testNow,
 	}
 
 	if !reflect.DeepEqual(got, want) {
@@ -190,7 +209,7 @@ func TestUpdatePet(t *testing.T) {
 }
 
 func TestGetPets(t *testing.T) {
-	ps := NewInMemoryPetStore(config.CfgData{})
+	ps := newTestPetStore()
 
 	idDog, _ := ps.AddPet("Fluff", "dog", "happy")
 	idCat, _ := ps.AddPet("Lion", "cat", "brave")
@@ -198,16 +217,22 @@ func TestGetPets(t *testing.T) {
 	got, _ := ps.GetAllPets()
 	want := []data.Pet{
 		{
-			Id:   idDog,
-			Name: "Fluff",
-			Race: "dog",
-			Mod:  "happy",
+			Id:        idDog,
+			Name:      "Fluff",
+			Race:      "dog",
+			Mod:       "happy",
+			Status:    data.Available,
+			CreatedAt: testNow,
+			UpdatedAt: testNow,
 		},
 		{
-			Id:   idCat,
-			Name: "Lion",
-			Race: "cat",
-			Mod:  "brave",
+			Id:        idCat,
+			Name:      "Lion",
+			Race:      "cat",
+			Mod:       "brave",
+			Status:    data.Available,
+			CreatedAt: testNow,
+			UpdatedAt: testNow,
 		},
 	}
 
@@ -270,3 +295,481 @@ func TestConcurrency(t *testing.T) {
 		t.Fatalf("want %q, got %v", wantTotal, total)
 	}
 }
+
+func TestForEachPet(t *testing.T) {
+	ps := NewInMemoryPetStore(config.CfgData{})
+
+	idDog, _ := ps.AddPet("Fluff", "dog", "happy")
+	idCat, _ := ps.AddPet("Lion", "cat", "brave")
+
+	got := make([]int, 0)
+	err := ps.ForEachPet(func(pet data.Pet) error {
+		got = append(got, pet.Id)
+		return nil
+	})
+
+	if err != nil {
+		t.Fatalf("error in for each got %v, want nil", err)
+	}
+	want := []int{idDog, idCat}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+
+	stopErr := fmt.Errorf("stop")
+	err = ps.ForEachPet(func(pet data.Pet) error {
+		return stopErr
+	})
+	if err != stopErr {
+		t.Fatalf("error in for each got %v, want %v", err, stopErr)
+	}
+}
+
+func TestImportPets(t *testing.T) {
+	ps := newTestPetStore()
+
+	_, _ = ps.AddPet("Fluff", "dog", "happy")
+	imported := []data.Pet{
+		{Id: 10, Name: "Lion", Race: "cat", Mod: "brave", Status: data.Available, CreatedAt: testNow, UpdatedAt: testNow},
+		{Name: "Snowflake", Race: "mouse", Mod: "nervous"},
+	}
+	err := ps.ImportPets(imported)
+
+	if err != nil {
+		t.Fatalf("error importing pets got %v, want nil", err)
+	}
+	if imported[0].Id != 2 || imported[1].Id != 3 {
+		t.Fatalf("error importing pets got ids %d and %d, want 2 and 3", imported[0].Id, imported[1].Id)
+	}
+
+	got, _ := ps.GetAllPets()
+	want := []data.Pet{
+		{Id: 1, Name: "Fluff", Race: "dog", Mod: "happy", Status: data.Available, CreatedAt: testNow, UpdatedAt: testNow},
+		{Id: 2, Name: "Lion", Race: "cat", Mod: "brave", Status: data.Available, CreatedAt: testNow, UpdatedAt: testNow},
+		{Id: 3, Name: "Snowflake", Race: "mouse", Mod: "nervous", Status: data.Available, CreatedAt: testNow, UpdatedAt: testNow},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+}
+
+func TestImportPetsFields(t *testing.T) {
+	ps := newTestPetStore()
+
+	owner, _ := ps.AddOwner("John", "john@example.com")
+	created := testNow.Add(-time.Hour)
+	imported := []data.Pet{{Name: "Lion", Race: "cat", Mod: "brave", Status: data.Adopted, OwnerId: &owner,
+		Tags: []string{"indoor", "calm"}, Attributes: map[string]string{"color": "red"}, CreatedAt: created}}
+
+	if err := ps.ImportPets(imported); err != nil {
+		t.Fatalf("error importing pets got %v, want nil", err)
+	}
+	got, _ := ps.GetPet(imported[0].Id)
+	want := data.Pet{Id: 1, Name: "Lion", Race: "cat", Mod: "brave", Status: data.Adopted, OwnerId: &owner,
+		Tags: []string{"calm", "indoor"}, Attributes: map[string]string{"color": "red"}, CreatedAt: created,
+		UpdatedAt: created}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+	if pets, _ := ps.FindPets(store.PetQuery{Tags: []string{"calm"}}); len(pets) != 1 {
+		t.Fatalf("got %v, want the imported pet by tag", pets)
+	}
+
+	invalid := 100
+	err := ps.ImportPets([]data.Pet{{Name: "Fluff", Race: "dog", Mod: "happy"},
+		{Name: "Snowflake", Race: "mouse", Mod: "nervous", OwnerId: &invalid}})
+	if err != store.InvalidOwner {
+		t.Fatalf("got %v, want %v", err, store.InvalidOwner)
+	}
+	if pets, _ := ps.GetAllPets(); len(pets) != 1 {
+		t.Fatalf("got %v, want no pets imported with an invalid owner", pets)
+	}
+}
+
+func TestBatchPets(t *testing.T) {
+	fluff := data.Pet{Name: "Fluff", Race: "dog", Mod: "happy"}
+	lion := data.Pet{Id: 1, Name: "Lion", Race: "cat", Mod: "brave"}
+
+	type testCase struct {
+		name     string
+		ops      []store.PetOperation
+		atomic   bool
+		want     []store.PetOperationResult
+		wantPets []data.Pet
+	}
+
+	var cases = []testCase{
+		{
+			name: "should apply all operations",
+			ops: []store.PetOperation{
+				{Type: store.CreateOperation, Pet: fluff},
+				{Type: store.UpdateOperation, Pet: lion},
+				{Type: store.DeleteOperation, Pet: data.Pet{Id: 2}},
+			},
+			atomic: true,
+			want: []store.PetOperationResult{
+				{Id: 3, Changed: true},
+				{Id: 1, Changed: true},
+				{Id: 2, Changed: true},
+			},
+			wantPets: []data.Pet{
+				{Id: 1, Name: "Lion", Race: "cat", Mod: "brave", Status: data.Available, CreatedAt: testNow, UpdatedAt: testNow},
+				{Id: 3, Name: "Fluff", Race: "dog", Mod: "happy", Status: data.Available, CreatedAt: testNow, UpdatedAt: testNow},
+			},
+		},
+		{
+			name: "should rollback atomic batch",
+			ops: []store.PetOperation{
+				{Type: store.CreateOperation, Pet: fluff},
+				{Type: store.UpdateOperation, Pet: lion},
+				{Type: store.DeleteOperation, Pet: data.Pet{Id: 2}},
+				{Type: store.DeleteOperation, Pet: data.Pet{Id: 9}},
+			},
+			atomic: true,
+			want: []store.PetOperationResult{
+				{}, {}, {},
+				{Id: 9, Err: store.PetNotFound},
+			},
+			wantPets: []data.Pet{
+				{Id: 1, Name: "Fluffy", Race: "dog", Mod: "happy", Status: data.Available, CreatedAt: testNow, UpdatedAt: testNow},
+				{Id: 2, Name: "Snow", Race: "mouse", Mod: "nervous", Status: data.Available, CreatedAt: testNow, UpdatedAt: testNow},
+			},
+		},
+		{
+			name: "should apply operations independently",
+			ops: []store.PetOperation{
+				{Type: store.DeleteOperation, Pet: data.Pet{Id: 9}},
+				{Type: store.DeleteOperation, Pet: data.Pet{Id: 2}},
+				{Type: "move", Pet: data.Pet{Id: 1}},
+				{Type: store.UpdateOperation, Pet: data.Pet{Id: 1, Name: "Fluffy", Race: "dog", Mod: "happy"}},
+			},
+			atomic: false,
+			want: []store.PetOperationResult{
+				{Id: 9, Err: store.PetNotFound},
+				{Id: 2, Changed: true},
+				{Id: 1, Err: store.InvalidOperation},
+				{Id: 1, Changed: false},
+			},
+			wantPets: []data.Pet{
+				{Id: 1, Name: "Fluffy", Race: "dog", Mod: "happy", Status: data.Available, CreatedAt: testNow, UpdatedAt: testNow},
+			},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			ps := newTestPetStore()
+			_, _ = ps.AddPet("Fluffy", "dog", "happy")
+			_, _ = ps.AddPet("Snow", "mouse", "nervous")
+
+			got, err := ps.BatchPets(tt.ops, tt.atomic)
+
+			if err != nil {
+				t.Fatalf("error in batch got %v, want nil", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("want %v, got %v", tt.want, got)
+			}
+			pets, _ := ps.GetAllPets()
+			if !reflect.DeepEqual(pets, tt.wantPets) {
+				t.Fatalf("want %v, got %v", tt.wantPets, pets)
+			}
+		})
+	}
+
+	t.Run("should reuse the ids of a rolled back batch", func(t *testing.T) {
+		ps := newTestPetStore()
+		id, _ := ps.AddPet("Fluffy", "dog", "happy")
+		_, _ = ps.BatchPets([]store.PetOperation{
+			{Type: store.CreateOperation, Pet: fluff},
+			{Type: store.UpdateOperation, Pet: data.Pet{Id: id, Name: "Lion", Race: "cat", Mod: "brave"}},
+			{Type: store.DeleteOperation, Pet: data.Pet{Id: 9}},
+		}, true)
+
+		next, _ := ps.AddPet("Snow", "mouse", "nervous")
+		if next != id+1 {
+			t.Fatalf("want next id %d, got %d", id+1, next)
+		}
+		if changes, _, _ := ps.PetHistory(next, 0, 0); len(changes) != 1 || changes[0].Id != 2 {
+			t.Fatalf("want a change with id 2, got %v", changes)
+		}
+	})
+}
+
+func TestSoftDelete(t *testing.T) {
+	deletedAt := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+	ps := NewInMemoryPetStore(config.CfgData{}).(*inMemoryPetStore)
+	ps.now = func() time.Time {
+		return deletedAt
+	}
+
+	idDog, _ := ps.AddPet("Fluff", "dog", "happy")
+	idCat, _ := ps.AddPet("Lion", "cat", "brave")
+
+	if err := ps.DeletePet(idDog); err != nil {
+		t.Fatalf("error deleting pet got %v, want nil", err)
+	}
+	if err := ps.DeletePet(idDog); err != store.PetNotFound {
+		t.Fatalf("error deleting deleted pet got %v, want %v", err, store.PetNotFound)
+	}
+	if _, err := ps.GetPet(idDog); err != store.PetNotFound {
+		t.Fatalf("error getting deleted pet got %v, want %v", err, store.PetNotFound)
+	}
+	if _, err := ps.UpdatePet(idDog, "Fluffy", "dog", "happy"); err != store.PetNotFound {
+		t.Fatalf("error updating deleted pet got %v, want %v", err, store.PetNotFound)
+	}
+
+	got, _ := ps.FindPets(store.PetQuery{IncludeDeleted: true})
+	want := []data.Pet{
+		{Id: idDog, Name: "Fluff", Race: "dog", Mod: "happy", Status: data.Available, CreatedAt: deletedAt, UpdatedAt: deletedAt, DeletedAt: &deletedAt},
+		{Id: idCat, Name: "Lion", Race: "cat", Mod: "brave", Status: data.Available, CreatedAt: deletedAt, UpdatedAt: deletedAt},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+
+	got, _ = ps.FindPets(store.PetQuery{Ids: []int{idDog}})
+	if len(got) != 0 {
+		t.Fatalf("want no pets, got %v", got)
+	}
+}
+
+func TestRestorePet(t *testing.T) {
+	ps := newTestPetStore()
+
+	id, _ := ps.AddPet("Fluff", "dog", "happy")
+
+	if err := ps.RestorePet(id); err != store.PetNotFound {
+		t.Fatalf("error restoring live pet got %v, want %v", err, store.PetNotFound)
+	}
+
+	_ = ps.DeletePet(id)
+
+	if err := ps.RestorePet(id); err != nil {
+		t.Fatalf("error restoring pet got %v, want nil", err)
+	}
+
+	got, _ := ps.GetPet(id)
+	want := data.Pet{Id: id, Name: "Fluff", Race: "dog", Mod: "happy", Status: data.Available, CreatedAt: testNow, UpdatedAt: testNow}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+}
+
+func TestPurgePets(t *testing.T) {
+	now := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+	ps := NewInMemoryPetStore(config.CfgData{}).(*inMemoryPetStore)
+	ps.now = func() time.Time {
+		return now
+	}
+
+	idDog, _ := ps.AddPet("Fluff", "dog", "happy")
+	idCat, _ := ps.AddPet("Lion", "cat", "brave")
+	_, _ = ps.AddPet("Snowflake", "mouse", "nervous")
+	_ = ps.DeletePet(idDog)
+	now = now.Add(time.Hour)
+	_ = ps.DeletePet(idCat)
+
+	ids, err := ps.PurgePets(now)
+
+	if err != nil || len(ids) != 1 || ids[0] != idDog {
+		t.Fatalf("error purging pets got %v, %v, want [%d], nil", ids, err, idDog)
+	}
+	got, _ := ps.FindPets(store.PetQuery{IncludeDeleted: true})
+	if len(got) != 2 || got[0].Id != idCat {
+		t.Fatalf("error purging pets got %v", got)
+	}
+	history, total, err := ps.PetHistory(idDog, 0, 10)
+	if err != nil || total != 2 || history[1].Action != data.DeleteAction {
+		t.Fatalf("error getting purged pet history got %v, %d, %v", history, total, err)
+	}
+}
+
+func TestPetHistory(t *testing.T) {
+	now := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+	ctx := reqctx.WithRequestId(reqctx.WithActor(context.Background(), "john"), "abc")
+	ps := NewInMemoryPetStore(config.CfgData{}).(*inMemoryPetStore)
+	ps.now = func() time.Time {
+		return now
+	}
+	cs := ps.WithContext(ctx)
+
+	id, _ := cs.AddPet("Fluff", "dog", "happy")
+	_, _ = cs.UpdatePet(id, "Fluffy", "dog", "happy")
+	_, _ = cs.UpdatePet(id, "Fluffy", "dog", "happy")
+	_ = ps.DeletePet(id)
+	_ = ps.RestorePet(id)
+
+	created := data.Pet{Id: id, Name: "Fluff", Race: "dog", Mod: "happy", Status: data.Available, CreatedAt: now, UpdatedAt: now}
+	updated := data.Pet{Id: id, Name: "Fluffy", Race: "dog", Mod: "happy", Status: data.Available, CreatedAt: now, UpdatedAt: now}
+	deleted := updated
+	deleted.DeletedAt = &now
+
+	t.Run("should record changes", func(t *testing.T) {
+		got, total, err := ps.PetHistory(id, 0, 0)
+		want := []data.PetChange{
+			{Id: 1, PetId: id, Action: data.CreateAction, Timestamp: now, Actor: "john", RequestId: "abc", After: &created},
+			{Id: 2, PetId: id, Action: data.UpdateAction, Timestamp: now, Actor: "john", RequestId: "abc", Before: &created, After: &updated},
+			{Id: 3, PetId: id, Action: data.DeleteAction, Timestamp: now, Actor: reqctx.Anonymous, Before: &updated, After: &deleted},
+			{Id: 4, PetId: id, Action: data.RestoreAction, Timestamp: now, Actor: reqctx.Anonymous, Before: &deleted, After: &updated},
+		}
+
+		if err != nil || total != len(want) {
+			t.Fatalf("error getting history got %d, %v, want %d, nil", total, err, len(want))
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("want %v, got %v", want, got)
+		}
+	})
+
+	t.Run("should paginate changes", func(t *testing.T) {
+		got, total, _ := ps.PetHistory(id, 1, 2)
+		if total != 4 || len(got) != 2 || got[0].Id != 2 || got[1].Id != 3 {
+			t.Fatalf("error paginating history got %v, %d", got, total)
+		}
+
+		got, _, _ = ps.PetHistory(id, 10, 2)
+		if len(got) != 0 {
+			t.Fatalf("want no changes, got %v", got)
+		}
+	})
+
+	t.Run("should not found history of unknown pet", func(t *testing.T) {
+		if _, _, err := ps.PetHistory(99, 0, 10); err != store.PetNotFound {
+			t.Fatalf("want %v, got %v", store.PetNotFound, err)
+		}
+	})
+
+	t.Run("should not record aborted batch", func(t *testing.T) {
+		_, _ = ps.BatchPets([]store.PetOperation{
+			{Type: store.UpdateOperation, Pet: data.Pet{Id: id, Name: "Lion", Race: "cat", Mod: "brave"}},
+			{Type: store.DeleteOperation, Pet: data.Pet{Id: 99}},
+		}, true)
+
+		if _, total, _ := ps.PetHistory(id, 0, 0); total != 4 {
+			t.Fatalf("want 4 changes, got %d", total)
+		}
+	})
+
+	t.Run("should keep history bounded", func(t *testing.T) {
+		for i := 0; i < historyLimit; i++ {
+			_, _ = ps.UpdatePet(id, fmt.Sprintf("Fluffy %d", i), "dog", "happy")
+		}
+
+		got, total, _ := ps.PetHistory(id, 0, 1)
+		if total != historyLimit || got[0].Action != data.UpdateAction {
+			t.Fatalf("want %d changes starting with an update, got %d, %v", historyLimit, total, got)
+		}
+	})
+}
+
+func TestTimestamps(t *testing.T) {
+	now := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+	created := now
+	ps := NewInMemoryPetStore(config.CfgData{}).(*inMemoryPetStore)
+	ps.now = func() time.Time {
+		return now
+	}
+
+	idDog, _ := ps.AddPet("Fluff", "dog", "happy")
+	idCat, _ := ps.AddPet("Lion", "cat", "brave")
+	now = now.Add(time.Hour)
+	_, _ = ps.UpdatePet(idDog, "Fluffy", "dog", "happy")
+
+	t.Run("should set timestamps", func(t *testing.T) {
+		got, _ := ps.GetPet(idDog)
+		if !got.CreatedAt.Equal(created) || !got.UpdatedAt.Equal(now) {
+			t.Fatalf("want created %v and updated %v, got %v and %v", created, now, got.CreatedAt, got.UpdatedAt)
+		}
+	})
+
+	t.Run("should filter by updated since", func(t *testing.T) {
+		got, _ := ps.FindPets(store.PetQuery{UpdatedSince: now})
+		if len(got) != 1 || got[0].Id != idDog {
+			t.Fatalf("want pet %d, got %v", idDog, got)
+		}
+	})
+
+	t.Run("should page and count pets", func(t *testing.T) {
+		query := store.PetQuery{Sort: []store.PetSort{{Field: store.SortById}}, Limit: 1}
+		if got, _ := ps.FindPets(query); len(got) != 1 || got[0].Id != idDog {
+			t.Fatalf("want pet %d, got %v", idDog, got)
+		}
+		query.AfterId = idDog
+		if got, _ := ps.FindPets(query); len(got) != 1 || got[0].Id != idCat {
+			t.Fatalf("want pet %d, got %v", idCat, got)
+		}
+		if got, err := ps.CountPets(query); got != 2 || err != nil {
+			t.Fatalf("want 2 pets, got %d and %v", got, err)
+		}
+	})
+
+	t.Run("should sort pets", func(t *testing.T) {
+		got, _ := ps.FindPets(store.PetQuery{Sort: []store.PetSort{
+			{Field: store.SortByCreatedAt},
+			{Field: store.SortByName, Descending: true},
+		}})
+		if len(got) != 2 || got[0].Id != idCat || got[1].Id != idDog {
+			t.Fatalf("want pets %d and %d, got %v", idCat, idDog, got)
+		}
+
+		got, _ = ps.FindPets(store.PetQuery{Sort: []store.PetSort{{Field: store.SortByUpdatedAt, Descending: true}}})
+		if len(got) != 2 || got[0].Id != idDog {
+			t.Fatalf("want pet %d first, got %v", idDog, got)
+		}
+	})
+}
+
+func TestTransitionPet(t *testing.T) {
+	ps := newTestPetStore()
+	ctx := reqctx.WithActor(context.Background(), "john")
+
+	id, _ := ps.AddPet("Fluff", "dog", "happy")
+
+	t.Run("should reserve pet", func(t *testing.T) {
+		got, err := ps.WithContext(ctx).TransitionPet(id, data.Reserved)
+		if err != nil || got.Status != data.Reserved {
+			t.Fatalf("got %v and %v, want reserved pet", got, err)
+		}
+		changes, _, _ := ps.PetHistory(id, 0, 0)
+		last := changes[len(changes)-1]
+		if last.Action != data.TransitionAction || last.Actor != "john" ||
+			last.Before.Status != data.Available || last.After.Status != data.Reserved {
+			t.Fatalf("got change %v, want transition by john", last)
+		}
+	})
+
+	t.Run("should not reserve a reserved pet", func(t *testing.T) {
+		if _, err := ps.TransitionPet(id, data.Reserved); err != store.IllegalStatus {
+			t.Fatalf("got %v, want %v", err, store.IllegalStatus)
+		}
+	})
+
+	t.Run("should not transition a pet that does not exist", func(t *testing.T) {
+		if _, err := ps.TransitionPet(id+1, data.Reserved); err != store.PetNotFound {
+			t.Fatalf("got %v, want %v", err, store.PetNotFound)
+		}
+	})
+
+	t.Run("should reserve a pet only once concurrently", func(t *testing.T) {
+		other, _ := ps.AddPet("Lion", "cat", "brave")
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		reserved := 0
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := ps.TransitionPet(other, data.Reserved); err == nil {
+					mu.Lock()
+					reserved++
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+		if reserved != 1 {
+			t.Fatalf("got %d reservations, want 1", reserved)
+		}
+	})
+}
@@ -0,0 +1,152 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package memory
+
+import (
+	"github.com/LearningByExample/go-microservice/internal/app/data"
+	"github.com/LearningByExample/go-microservice/internal/app/store"
+	"sort"
+)
+
+func ownerRef(id int) *int {
+	if id == 0 {
+		return nil
+	}
+	return &id
+}
+
+func (s *inMemoryPetStore) setPetOwner(pet data.Pet, ownerId int) bool {
+	if pet.Owner() == ownerId {
+		return false
+	}
+	updated := pet
+	updated.OwnerId = ownerRef(ownerId)
+	updated.UpdatedAt = s.now()
+	s.pets[pet.Id] = updated
+	s.recordChange(data.UpdateAction, pet.Id, &pet, &updated)
+	return true
+}
+
+func (s *inMemoryPetStore) AddOwner(name string, email string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastOwnerId++
+	now := s.now()
+	s.owners[s.lastOwnerId] = data.Owner{Id: s.lastOwnerId, Name: name, Email: email, CreatedAt: now, UpdatedAt: now}
+	return s.lastOwnerId, nil
+}
+
+func (s *inMemoryPetStore) GetOwner(id int) (data.Owner, error) {
+	var err error = nil
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	owner, found := s.owners[id]
+
+	if !found {
+		err = store.OwnerNotFound
+	}
+	return owner, err
+}
+
+func (s *inMemoryPetStore) GetAllOwners() ([]data.Owner, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	owners := make([]data.Owner, 0, len(s.owners))
+	for _, owner := range s.owners {
+		owners = append(owners, owner)
+	}
+	sort.Slice(owners, func(i, j int) bool {
+		return owners[i].Id < owners[j].Id
+	})
+	return owners, nil
+}
+
+func (s *inMemoryPetStore) GetOwners(ids []int) ([]data.Owner, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	owners := make([]data.Owner, 0, len(ids))
+	for _, id := range ids {
+		if owner, found := s.owners[id]; found {
+			owners = append(owners, owner)
+		}
+	}
+	sort.Slice(owners, func(i, j int) bool {
+		return owners[i].Id < owners[j].Id
+	})
+	return owners, nil
+}
+
+func (s *inMemoryPetStore) UpdateOwner(id int, name string, email string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	owner, found := s.owners[id]
+	if !found {
+		return false, store.OwnerNotFound
+	}
+	if owner.Name == name && owner.Email == email {
+		return false, nil
+	}
+	owner.Name, owner.Email = name, email
+	owner.UpdatedAt = s.now()
+	s.owners[id] = owner
+	return true, nil
+}
+
+func (s *inMemoryPetStore) DeleteOwner(id int, reassignTo int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, found := s.owners[id]; !found {
+		return store.OwnerNotFound
+	}
+	if _, found := s.owners[reassignTo]; reassignTo != 0 && (!found || reassignTo == id) {
+		return store.InvalidOwner
+	}
+
+	owned := s.findPets(store.PetQuery{OwnerId: id, IncludeDeleted: true})
+	if reassignTo == 0 {
+		for _, pet := range owned {
+			if !pet.IsDeleted() {
+				return store.OwnerHasPets
+			}
+		}
+	}
+	for _, pet := range owned {
+		s.setPetOwner(pet, reassignTo)
+	}
+	delete(s.owners, id)
+	return nil
+}
+
+func (s *inMemoryPetStore) SetPetOwner(petId int, ownerId int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pet, found := s.livePet(petId)
+	if !found {
+		return false, store.PetNotFound
+	}
+	if _, found := s.owners[ownerId]; ownerId != 0 && !found {
+		return false, store.InvalidOwner
+	}
+	return s.setPetOwner(pet, ownerId), nil
+}
@@ -0,0 +1,179 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package memory
+
+import (
+	"github.com/LearningByExample/go-microservice/internal/app/data"
+	"github.com/LearningByExample/go-microservice/internal/app/store"
+	"reflect"
+	"testing"
+)
+
+func TestOwners(t *testing.T) {
+	ps := newTestPetStore()
+
+	id, _ := ps.AddOwner("John", "john@example.com")
+
+	t.Run("should get owner", func(t *testing.T) {
+		got, err := ps.GetOwner(id)
+		want := data.Owner{Id: id, Name: "John", Email: "john@example.com", CreatedAt: testNow, UpdatedAt: testNow}
+
+		if err != nil {
+			t.Fatalf("want not error, got %v", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("should not get an owner that does not exist", func(t *testing.T) {
+		if _, err := ps.GetOwner(id + 1); err != store.OwnerNotFound {
+			t.Fatalf("got %v, want %v", err, store.OwnerNotFound)
+		}
+	})
+
+	t.Run("should update owner", func(t *testing.T) {
+		if change, err := ps.UpdateOwner(id, "John", "john@example.org"); !change || err != nil {
+			t.Fatalf("got %t and %v, want change", change, err)
+		}
+		if change, err := ps.UpdateOwner(id, "John", "john@example.org"); change || err != nil {
+			t.Fatalf("got %t and %v, want not change", change, err)
+		}
+		if _, err := ps.UpdateOwner(id+1, "John", "john@example.org"); err != store.OwnerNotFound {
+			t.Fatalf("got %v, want %v", err, store.OwnerNotFound)
+		}
+	})
+
+	t.Run("should get all owners", func(t *testing.T) {
+		other, _ := ps.AddOwner("Jane", "jane@example.com")
+		owners, _ := ps.GetAllOwners()
+
+		if len(owners) != 2 || owners[0].Id != id || owners[1].Id != other {
+			t.Fatalf("got %v, want owners %d and %d", owners, id, other)
+		}
+	})
+
+	t.Run("should get owners by id", func(t *testing.T) {
+		other := id + 1
+		owners, err := ps.GetOwners([]int{other, id + 100, id})
+
+		if err != nil || len(owners) != 2 || owners[0].Id != id || owners[1].Id != other {
+			t.Fatalf("got %v and %v, want owners %d and %d", owners, err, id, other)
+		}
+	})
+}
+
+func TestSetPetOwner(t *testing.T) {
+	ps := newTestPetStore()
+
+	owner, _ := ps.AddOwner("John", "john@example.com")
+	id, _ := ps.AddPet("Fluff", "dog", "happy")
+	other, _ := ps.AddPet("Lion", "cat", "brave")
+
+	if change, err := ps.SetPetOwner(id, owner); !change || err != nil {
+		t.Fatalf("got %t and %v, want change", change, err)
+	}
+	if change, err := ps.SetPetOwner(id, owner); change || err != nil {
+		t.Fatalf("got %t and %v, want not change", change, err)
+	}
+	if _, err := ps.SetPetOwner(other, owner+1); err != store.InvalidOwner {
+		t.Fatalf("got %v, want %v", err, store.InvalidOwner)
+	}
+	if _, err := ps.SetPetOwner(other+1, owner); err != store.PetNotFound {
+		t.Fatalf("got %v, want %v", err, store.PetNotFound)
+	}
+
+	pets, _ := ps.FindPets(store.PetQuery{OwnerId: owner})
+	if len(pets) != 1 || pets[0].Id != id || pets[0].Owner() != owner {
+		t.Fatalf("got %v, want pet %d owned by %d", pets, id, owner)
+	}
+	changes, _, _ := ps.PetHistory(id, 0, 0)
+	if len(changes) != 2 || changes[1].Action != data.UpdateAction || changes[1].After.Owner() != owner {
+		t.Fatalf("got %v, want owner change recorded", changes)
+	}
+
+	if change, err := ps.SetPetOwner(id, 0); !change || err != nil {
+		t.Fatalf("got %t and %v, want change", change, err)
+	}
+	if pet, _ := ps.GetPet(id); pet.OwnerId != nil {
+		t.Fatalf("got owner %d, want none", pet.Owner())
+	}
+}
+
+func TestDeleteOwner(t *testing.T) {
+	ps := newTestPetStore()
+
+	owner, _ := ps.AddOwner("John", "john@example.com")
+	other, _ := ps.AddOwner("Jane", "jane@example.com")
+	id, _ := ps.AddPet("Fluff", "dog", "happy")
+	deleted, _ := ps.AddPet("Lion", "cat", "brave")
+	_, _ = ps.SetPetOwner(id, owner)
+	_, _ = ps.SetPetOwner(deleted, owner)
+	_ = ps.DeletePet(deleted)
+
+	t.Run("should reject deleting an owner with pets", func(t *testing.T) {
+		if err := ps.DeleteOwner(owner, 0); err != store.OwnerHasPets {
+			t.Fatalf("got %v, want %v", err, store.OwnerHasPets)
+		}
+	})
+
+	t.Run("should reject reassigning to an invalid owner", func(t *testing.T) {
+		for _, to := range []int{owner, other + 1} {
+			if err := ps.DeleteOwner(owner, to); err != store.InvalidOwner {
+				t.Fatalf("got %v, want %v", err, store.InvalidOwner)
+			}
+		}
+	})
+
+	t.Run("should not delete an owner that does not exist", func(t *testing.T) {
+		if err := ps.DeleteOwner(other+1, 0); err != store.OwnerNotFound {
+			t.Fatalf("got %v, want %v", err, store.OwnerNotFound)
+		}
+	})
+
+	t.Run("should reassign pets", func(t *testing.T) {
+		if err := ps.DeleteOwner(owner, other); err != nil {
+			t.Fatalf("want not error, got %v", err)
+		}
+		if _, err := ps.GetOwner(owner); err != store.OwnerNotFound {
+			t.Fatalf("got %v, want %v", err, store.OwnerNotFound)
+		}
+		pets, _ := ps.FindPets(store.PetQuery{OwnerId: other, IncludeDeleted: true})
+		if len(pets) != 2 {
+			t.Fatalf("got %v, want pets reassigned to %d", pets, other)
+		}
+	})
+
+	t.Run("should release deleted pets", func(t *testing.T) {
+		_ = ps.DeletePet(id)
+		if err := ps.DeleteOwner(other, 0); err != nil {
+			t.Fatalf("want not error, got %v", err)
+		}
+		pets, _ := ps.FindPets(store.PetQuery{IncludeDeleted: true})
+		for _, pet := range pets {
+			if pet.OwnerId != nil {
+				t.Fatalf("got owner %d for pet %d, want none", pet.Owner(), pet.Id)
+			}
+		}
+	})
+}
@@ -0,0 +1,206 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package memory
+
+import (
+	"github.com/LearningByExample/go-microservice/internal/app/data"
+	"github.com/LearningByExample/go-microservice/internal/app/store"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+	"html"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+type termIndex map[string]map[int]bool
+
+type searchToken struct {
+	term  string
+	start int
+	end   int
+}
+
+type searchField struct {
+	name   string
+	weight float64
+	value  func(pet data.Pet) string
+}
+
+// weights mirror the default ts_rank weights used for the A, B and C labels in postgres
+var searchFields = []searchField{
+	{name: string(store.SortByName), weight: 1.0, value: func(pet data.Pet) string { return pet.Name }},
+	{name: string(store.SortByRace), weight: 0.4, value: func(pet data.Pet) string { return pet.Race }},
+	{name: string(store.SortByMod), weight: 0.2, value: func(pet data.Pet) string { return pet.Mod }},
+}
+
+func foldTerm(term string) string {
+	t := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	if folded, _, err := transform.String(t, term); err == nil {
+		term = folded
+	}
+	return strings.ToLower(term)
+}
+
+func tokenize(text string) []searchToken {
+	tokens := make([]searchToken, 0)
+	start := -1
+	for i, r := range text + " " {
+		isWord := unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r)
+		if isWord && start < 0 {
+			start = i
+		} else if !isWord && start >= 0 {
+			tokens = append(tokens, searchToken{term: foldTerm(text[start:i]), start: start, end: i})
+			start = -1
+		}
+	}
+	return tokens
+}
+
+func queryTerms(text string) map[string]bool {
+	terms := make(map[string]bool)
+	for _, token := range tokenize(text) {
+		terms[token.term] = true
+	}
+	return terms
+}
+
+func (idx termIndex) set(key string, id int) {
+	if _, found := idx[key]; !found {
+		idx[key] = make(map[int]bool)
+	}
+	idx[key][id] = true
+}
+
+func (idx termIndex) unset(key string, id int) {
+	if ids, found := idx[key]; found {
+		delete(ids, id)
+		if len(ids) == 0 {
+			delete(idx, key)
+		}
+	}
+}
+
+func (idx termIndex) add(pet data.Pet) {
+	for _, field := range searchFields {
+		for _, token := range tokenize(field.value(pet)) {
+			idx.set(token.term, pet.Id)
+		}
+	}
+}
+
+func (idx termIndex) remove(pet data.Pet) {
+	for _, field := range searchFields {
+		for _, token := range tokenize(field.value(pet)) {
+			idx.unset(token.term, pet.Id)
+		}
+	}
+}
+
+func (idx termIndex) lookup(terms map[string]bool) []int {
+	var smallest map[int]bool = nil
+	for term := range terms {
+		ids, found := idx[term]
+		if !found {
+			return nil
+		}
+		if smallest == nil || len(ids) < len(smallest) {
+			smallest = ids
+		}
+	}
+
+	result := make([]int, 0, len(smallest))
+	for id := range smallest {
+		all := true
+		for term := range terms {
+			if !idx[term][id] {
+				all = false
+				break
+			}
+		}
+		if all {
+			result = append(result, id)
+		}
+	}
+	return result
+}
+
+func highlight(text string, terms map[string]bool) (string, int) {
+	var sb strings.Builder
+	last, hits := 0, 0
+	for _, token := range tokenize(text) {
+		if terms[token.term] {
+			sb.WriteString(html.EscapeString(text[last:token.start]))
+			sb.WriteString(store.HighlightStart)
+			sb.WriteString(html.EscapeString(text[token.start:token.end]))
+			sb.WriteString(store.HighlightStop)
+			last = token.end
+			hits++
+		}
+	}
+	sb.WriteString(html.EscapeString(text[last:]))
+	return sb.String(), hits
+}
+
+func matchPet(pet data.Pet, terms map[string]bool) data.PetMatch {
+	match := data.PetMatch{Pet: pet, Highlights: make(map[string]string)}
+	for _, field := range searchFields {
+		if text, hits := highlight(field.value(pet), terms); hits > 0 {
+			match.Highlights[field.name] = text
+			match.Rank += field.weight * float64(hits)
+		}
+	}
+	return match
+}
+
+func (s *inMemoryPetStore) SearchPets(text string, offset int, limit int) ([]data.PetMatch, int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	matches := make([]data.PetMatch, 0)
+	if terms := queryTerms(text); len(terms) != 0 {
+		for _, id := range s.index.lookup(terms) {
+			if pet, found := s.livePet(id); found {
+				matches = append(matches, matchPet(pet, terms))
+			}
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Rank != matches[j].Rank {
+			return matches[i].Rank > matches[j].Rank
+		}
+		return matches[i].Pet.Id < matches[j].Pet.Id
+	})
+
+	total := len(matches)
+	result := make([]data.PetMatch, 0)
+	if offset < total {
+		end := total
+		if limit > 0 && offset+limit < total {
+			end = offset + limit
+		}
+		result = append(result, matches[offset:end]...)
+	}
+	return result, total, nil
+}
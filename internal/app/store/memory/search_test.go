@@ -0,0 +1,163 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package memory
+
+import (
+	"github.com/LearningByExample/go-microservice/internal/app/data"
+	"github.com/LearningByExample/go-microservice/internal/app/store"
+	"reflect"
+	"testing"
+)
+
+func searchIds(t *testing.T, ps *inMemoryPetStore, text string) []int {
+	t.Helper()
+	matches, total, err := ps.SearchPets(text, 0, 0)
+	if err != nil {
+		t.Fatalf("want not error, got %v", err)
+	}
+	if total != len(matches) {
+		t.Fatalf("got total %d, want %d", total, len(matches))
+	}
+	ids := make([]int, 0)
+	for _, match := range matches {
+		ids = append(ids, match.Pet.Id)
+	}
+	return ids
+}
+
+func TestTokenize(t *testing.T) {
+	got := tokenize("Señor  Café-Noir")
+	want := []searchToken{
+		{term: "senor", start: 0, end: 6},
+		{term: "cafe", start: 8, end: 13},
+		{term: "noir", start: 14, end: 18},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestSearchPets(t *testing.T) {
+	ps := newTestPetStore()
+
+	fluffy, _ := ps.AddPet("Fluffy", "dog", "happy")
+	lion, _ := ps.AddPet("Lion", "cat", "Happy dog")
+	jose, _ := ps.AddPet("José", "Dog", "brave")
+
+	t.Run("should rank name matches first", func(t *testing.T) {
+		matches, total, _ := ps.SearchPets("fluffy", 0, 0)
+		want := []data.PetMatch{{
+			Pet:        data.Pet{Id: fluffy, Name: "Fluffy", Race: "dog", Mod: "happy", Status: data.Available, CreatedAt: testNow, UpdatedAt: testNow},
+			Rank:       1.0,
+			Highlights: map[string]string{"name": store.HighlightStart + "Fluffy" + store.HighlightStop},
+		}}
+
+		if total != 1 || !reflect.DeepEqual(matches, want) {
+			t.Fatalf("got %v and %d, want %v", matches, total, want)
+		}
+	})
+
+	t.Run("should match all terms", func(t *testing.T) {
+		if got, want := searchIds(t, ps, "DOG"), []int{fluffy, jose, lion}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+		if got, want := searchIds(t, ps, "happy dog"), []int{fluffy, lion}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+		if got := searchIds(t, ps, "happy fish"); len(got) != 0 {
+			t.Fatalf("got %v, want no matches", got)
+		}
+		if got := searchIds(t, ps, " - "); len(got) != 0 {
+			t.Fatalf("got %v, want no matches", got)
+		}
+	})
+
+	t.Run("should ignore accents", func(t *testing.T) {
+		matches, _, _ := ps.SearchPets("jose", 0, 0)
+		want := store.HighlightStart + "José" + store.HighlightStop
+
+		if len(matches) != 1 || matches[0].Highlights["name"] != want {
+			t.Fatalf("got %v, want highlight %q", matches, want)
+		}
+	})
+
+	t.Run("should escape highlights", func(t *testing.T) {
+		id, _ := ps.AddPet("<b>Rex</b>", "<mark>bird", "calm")
+		defer func() {
+			_ = ps.DeletePet(id)
+		}()
+		matches, _, _ := ps.SearchPets("rex", 0, 0)
+		want := map[string]string{"name": "&lt;b&gt;" + store.HighlightStart + "Rex" + store.HighlightStop + "&lt;/b&gt;"}
+
+		if len(matches) != 1 || !reflect.DeepEqual(matches[0].Highlights, want) {
+			t.Fatalf("got %v, want highlights %v", matches, want)
+		}
+	})
+
+	t.Run("should paginate matches", func(t *testing.T) {
+		matches, total, _ := ps.SearchPets("dog", 1, 1)
+
+		if total != 3 || len(matches) != 1 || matches[0].Pet.Id != jose {
+			t.Fatalf("got %v and %d, want pet %d of 3", matches, total, jose)
+		}
+		if matches, _, _ = ps.SearchPets("dog", 5, 1); len(matches) != 0 {
+			t.Fatalf("got %v, want no matches", matches)
+		}
+	})
+
+	t.Run("should follow updates and deletes", func(t *testing.T) {
+		_, _ = ps.UpdatePet(fluffy, "Rex", "dog", "happy")
+		_ = ps.DeletePet(lion)
+
+		if got := searchIds(t, ps, "fluffy"); len(got) != 0 {
+			t.Fatalf("got %v, want no matches", got)
+		}
+		if got, want := searchIds(t, ps, "rex"), []int{fluffy}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+		if got, want := searchIds(t, ps, "happy"), []int{fluffy}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+
+		_ = ps.RestorePet(lion)
+		if got, want := searchIds(t, ps, "lion"), []int{lion}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("should revert index on failed atomic batch", func(t *testing.T) {
+		_, _ = ps.BatchPets([]store.PetOperation{
+			{Type: store.CreateOperation, Pet: data.Pet{Name: "Ghost", Race: "cat", Mod: "shy"}},
+			{Type: store.UpdateOperation, Pet: data.Pet{Id: jose, Name: "Ghost", Race: "dog", Mod: "shy"}},
+			{Type: store.DeleteOperation, Pet: data.Pet{Id: 99}},
+		}, true)
+
+		if got := searchIds(t, ps, "ghost"); len(got) != 0 {
+			t.Fatalf("got %v, want no matches", got)
+		}
+		if got, want := searchIds(t, ps, "jose"), []int{jose}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	})
+}
@@ -0,0 +1,113 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package memory
+
+import (
+	"github.com/LearningByExample/go-microservice/internal/app/data"
+	"github.com/LearningByExample/go-microservice/internal/app/store"
+)
+
+func attributeKey(key string, value string) string {
+	return key + "=" + value
+}
+
+func copyAttributes(attributes map[string]string) map[string]string {
+	if len(attributes) == 0 {
+		return nil
+	}
+	result := make(map[string]string, len(attributes))
+	for key, value := range attributes {
+		result[key] = value
+	}
+	return result
+}
+
+func (s *inMemoryPetStore) indexTags(pet data.Pet) {
+	for _, tag := range pet.Tags {
+		s.tagIndex.set(tag, pet.Id)
+	}
+	for key, value := range pet.Attributes {
+		s.attrIndex.set(attributeKey(key, value), pet.Id)
+	}
+}
+
+func (s *inMemoryPetStore) unindexTags(pet data.Pet) {
+	for _, tag := range pet.Tags {
+		s.tagIndex.unset(tag, pet.Id)
+	}
+	for key, value := range pet.Attributes {
+		s.attrIndex.unset(attributeKey(key, value), pet.Id)
+	}
+}
+
+// taggedIds returns the ids of the pets having all the tags and attributes in the query,
+// or nil when the query does not filter by them
+func (s *inMemoryPetStore) taggedIds(query store.PetQuery) map[int]bool {
+	sets := make([]map[int]bool, 0, len(query.Tags)+len(query.Attributes))
+	for _, tag := range query.Tags {
+		sets = append(sets, s.tagIndex[tag])
+	}
+	for key, value := range query.Attributes {
+		sets = append(sets, s.attrIndex[attributeKey(key, value)])
+	}
+	if len(sets) == 0 {
+		return nil
+	}
+
+	result := make(map[int]bool)
+	for id := range sets[0] {
+		all := true
+		for _, ids := range sets[1:] {
+			if !ids[id] {
+				all = false
+				break
+			}
+		}
+		if all {
+			result[id] = true
+		}
+	}
+	return result
+}
+
+func (s *inMemoryPetStore) SetPetTags(id int, tags []string, attributes map[string]string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pet, found := s.livePet(id)
+	if !found {
+		return false, store.PetNotFound
+	}
+	tags = data.SortedTags(tags)
+	if pet.SameTags(tags, attributes) {
+		return false, nil
+	}
+	updated := pet
+	updated.Tags = tags
+	updated.Attributes = copyAttributes(attributes)
+	updated.UpdatedAt = s.now()
+	s.unindexTags(pet)
+	s.pets[id] = updated
+	s.indexTags(updated)
+	s.recordChange(data.UpdateAction, id, &pet, &updated)
+	return true, nil
+}
@@ -0,0 +1,122 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package memory
+
+import (
+	"github.com/LearningByExample/go-microservice/internal/app/store"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func findIds(t *testing.T, ps *inMemoryPetStore, query store.PetQuery) []int {
+	t.Helper()
+	pets, err := ps.FindPets(query)
+	if err != nil {
+		t.Fatalf("want not error, got %v", err)
+	}
+	ids := make([]int, 0)
+	for _, pet := range pets {
+		ids = append(ids, pet.Id)
+	}
+	return ids
+}
+
+func TestSetPetTags(t *testing.T) {
+	ps := newTestPetStore()
+
+	fluffy, _ := ps.AddPet("Fluffy", "dog", "happy")
+	lion, _ := ps.AddPet("Lion", "cat", "brave")
+	black := map[string]string{"color": "black"}
+
+	t.Run("should set tags", func(t *testing.T) {
+		if change, err := ps.SetPetTags(fluffy, []string{"small", "friendly", "small"}, black); !change || err != nil {
+			t.Fatalf("got %t and %v, want change", change, err)
+		}
+		if change, err := ps.SetPetTags(fluffy, []string{"friendly", "small"}, black); change || err != nil {
+			t.Fatalf("got %t and %v, want not change", change, err)
+		}
+		if _, err := ps.SetPetTags(lion+1, nil, nil); err != store.PetNotFound {
+			t.Fatalf("got %v, want %v", err, store.PetNotFound)
+		}
+
+		got, _ := ps.GetPet(fluffy)
+		if want := []string{"friendly", "small"}; !reflect.DeepEqual(got.Tags, want) ||
+			!reflect.DeepEqual(got.Attributes, black) {
+			t.Fatalf("got %v and %v, want %v and %v", got.Tags, got.Attributes, want, black)
+		}
+		if changes, _, _ := ps.PetHistory(fluffy, 0, 0); len(changes) != 2 || changes[1].After.Tags == nil {
+			t.Fatalf("got %v, want tags change", changes)
+		}
+	})
+
+	t.Run("should find pets by tags and attributes", func(t *testing.T) {
+		_, _ = ps.SetPetTags(lion, []string{"friendly"}, map[string]string{"color": "white"})
+
+		if got, want := findIds(t, ps, store.PetQuery{Tags: []string{"friendly"}}), []int{fluffy, lion}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+		if got, want := findIds(t, ps, store.PetQuery{Tags: []string{"friendly"}, Attributes: black}), []int{fluffy}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+		if got := findIds(t, ps, store.PetQuery{Tags: []string{"small"}, Attributes: map[string]string{"color": "white"}}); len(got) != 0 {
+			t.Fatalf("got %v, want no pets", got)
+		}
+		if got := findIds(t, ps, store.PetQuery{Tags: []string{"unknown"}}); len(got) != 0 {
+			t.Fatalf("got %v, want no pets", got)
+		}
+	})
+
+	t.Run("should update indexes", func(t *testing.T) {
+		_, _ = ps.SetPetTags(fluffy, nil, nil)
+
+		if got, want := findIds(t, ps, store.PetQuery{Tags: []string{"friendly"}}), []int{lion}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+		if got := findIds(t, ps, store.PetQuery{Attributes: black}); len(got) != 0 {
+			t.Fatalf("got %v, want no pets", got)
+		}
+
+		_ = ps.DeletePet(lion)
+		if got := findIds(t, ps, store.PetQuery{Tags: []string{"friendly"}}); len(got) != 0 {
+			t.Fatalf("got %v, want no pets", got)
+		}
+		if got := findIds(t, ps, store.PetQuery{Tags: []string{"friendly"}, IncludeDeleted: true}); len(got) != 1 {
+			t.Fatalf("got %v, want deleted pet", got)
+		}
+
+		_, _ = ps.PurgePets(testNow.Add(time.Hour))
+		if len(ps.tagIndex) != 0 || len(ps.attrIndex) != 0 {
+			t.Fatalf("got %v and %v, want empty indexes", ps.tagIndex, ps.attrIndex)
+		}
+	})
+
+	t.Run("should not tag a deleted pet", func(t *testing.T) {
+		_ = ps.DeletePet(fluffy)
+
+		if _, err := ps.SetPetTags(fluffy, []string{"old"}, nil); err != store.PetNotFound {
+			t.Fatalf("got %v, want %v", err, store.PetNotFound)
+		}
+	})
+
+}
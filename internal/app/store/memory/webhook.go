@@ -0,0 +1,258 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package memory
+
+import (
+	"github.com/LearningByExample/go-microservice/internal/app/data"
+	"github.com/LearningByExample/go-microservice/internal/app/store"
+	"sort"
+	"time"
+)
+
+func copyEvents(events []string) []string {
+	return append(make([]string, 0, len(events)), events...)
+}
+
+func sameEvents(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func copyDelivery(delivery data.Delivery) data.Delivery {
+	delivery.Attempts = append(make([]data.DeliveryAttempt, 0, len(delivery.Attempts)), delivery.Attempts...)
+	return delivery
+}
+
+func sortDeliveries(deliveries []data.Delivery) {
+	sort.Slice(deliveries, func(i, j int) bool {
+		return deliveries[i].Id < deliveries[j].Id
+	})
+}
+
+func (s *inMemoryPetStore) AddWebhook(url string, events []string, secret string, enabled bool) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastHookId++
+	now := s.now()
+	s.webhooks[s.lastHookId] = data.Webhook{Id: s.lastHookId, Url: url, Events: copyEvents(events), Secret: secret,
+		Enabled: enabled, CreatedAt: now, UpdatedAt: now}
+	return s.lastHookId, nil
+}
+
+func (s *inMemoryPetStore) GetWebhook(id int) (data.Webhook, error) {
+	var err error = nil
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	webhook, found := s.webhooks[id]
+
+	if !found {
+		err = store.WebhookNotFound
+	}
+	webhook.Events = copyEvents(webhook.Events)
+	return webhook, err
+}
+
+func (s *inMemoryPetStore) sortedWebhooks() []data.Webhook {
+	webhooks := make([]data.Webhook, 0, len(s.webhooks))
+	for _, webhook := range s.webhooks {
+		webhook.Events = copyEvents(webhook.Events)
+		webhooks = append(webhooks, webhook)
+	}
+	sort.Slice(webhooks, func(i, j int) bool {
+		return webhooks[i].Id < webhooks[j].Id
+	})
+	return webhooks
+}
+
+func (s *inMemoryPetStore) GetAllWebhooks() ([]data.Webhook, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.sortedWebhooks(), nil
+}
+
+func (s *inMemoryPetStore) UpdateWebhook(id int, url string, events []string, secret string, enabled bool) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	webhook, found := s.webhooks[id]
+	if !found {
+		return false, store.WebhookNotFound
+	}
+	if webhook.Url == url && sameEvents(webhook.Events, events) && webhook.Secret == secret &&
+		webhook.Enabled == enabled {
+		return false, nil
+	}
+	if enabled && !webhook.Enabled {
+		webhook.Failures = 0
+	}
+	webhook.Url, webhook.Events, webhook.Secret, webhook.Enabled = url, copyEvents(events), secret, enabled
+	webhook.UpdatedAt = s.now()
+	s.webhooks[id] = webhook
+	return true, nil
+}
+
+func (s *inMemoryPetStore) DisableWebhook(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	webhook, found := s.webhooks[id]
+	if !found {
+		return store.WebhookNotFound
+	}
+	if webhook.Enabled {
+		webhook.Enabled = false
+		webhook.UpdatedAt = s.now()
+		s.webhooks[id] = webhook
+	}
+	return nil
+}
+
+func (s *inMemoryPetStore) DeleteWebhook(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, found := s.webhooks[id]; !found {
+		return store.WebhookNotFound
+	}
+	for deliveryId, delivery := range s.deliveries {
+		if delivery.WebhookId == id {
+			delete(s.deliveries, deliveryId)
+		}
+	}
+	delete(s.webhooks, id)
+	return nil
+}
+
+func (s *inMemoryPetStore) EnqueueDeliveries(event string, payload string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	count := 0
+	now := s.now()
+	for _, webhook := range s.sortedWebhooks() {
+		if webhook.Enabled && webhook.Accepts(event) {
+			s.lastDelivery++
+			s.deliveries[s.lastDelivery] = data.Delivery{Id: s.lastDelivery, WebhookId: webhook.Id, Event: event,
+				Payload: payload, Status: data.DeliveryPending, Attempts: make([]data.DeliveryAttempt, 0),
+				NextAttempt: now, CreatedAt: now}
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s *inMemoryPetStore) ClaimDeliveries(now time.Time, lease time.Duration, limit int) ([]data.Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	due := make([]data.Delivery, 0)
+	for _, delivery := range s.deliveries {
+		if delivery.Status == data.DeliveryPending && s.webhooks[delivery.WebhookId].Enabled &&
+			!delivery.NextAttempt.After(now) {
+			due = append(due, delivery)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		if !due[i].NextAttempt.Equal(due[j].NextAttempt) {
+			return due[i].NextAttempt.Before(due[j].NextAttempt)
+		}
+		return due[i].Id < due[j].Id
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+	for i := range due {
+		claimed := due[i]
+		claimed.NextAttempt = now.Add(lease)
+		s.deliveries[claimed.Id] = claimed
+		due[i] = copyDelivery(claimed)
+	}
+	sortDeliveries(due)
+	return due, nil
+}
+
+func (s *inMemoryPetStore) RecordDeliveryAttempt(id int, attempt data.DeliveryAttempt, status data.DeliveryStatus,
+	next time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delivery, found := s.deliveries[id]
+	if !found {
+		return 0, store.DeliveryNotFound
+	}
+	delivery.Attempts = append(delivery.Attempts, attempt)
+	delivery.Status, delivery.NextAttempt = status, next
+	s.deliveries[id] = delivery
+
+	webhook := s.webhooks[delivery.WebhookId]
+	if status == data.DeliveryDelivered {
+		webhook.Failures = 0
+	} else {
+		webhook.Failures++
+	}
+	s.webhooks[webhook.Id] = webhook
+	return webhook.Failures, nil
+}
+
+func (s *inMemoryPetStore) PendingDeliveries() (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	pending := 0
+	for _, delivery := range s.deliveries {
+		if delivery.Status == data.DeliveryPending {
+			pending++
+		}
+	}
+	return pending, nil
+}
+
+func (s *inMemoryPetStore) WebhookDeliveries(id int, offset int, limit int) ([]data.Delivery, int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if _, found := s.webhooks[id]; !found {
+		return nil, 0, store.WebhookNotFound
+	}
+
+	deliveries := make([]data.Delivery, 0)
+	for _, delivery := range s.deliveries {
+		if delivery.WebhookId == id {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	sortDeliveries(deliveries)
+	total := len(deliveries)
+
+	result := make([]data.Delivery, 0)
+	if offset < total {
+		end := total
+		if limit > 0 && offset+limit < total {
+			end = offset + limit
+		}
+		for _, delivery := range deliveries[offset:end] {
+			result = append(result, copyDelivery(delivery))
+		}
+	}
+	return result, total, nil
+}
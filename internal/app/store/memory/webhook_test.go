@@ -0,0 +1,191 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package memory
+
+import (
+	"github.com/LearningByExample/go-microservice/internal/app/data"
+	"github.com/LearningByExample/go-microservice/internal/app/store"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWebhooks(t *testing.T) {
+	ps := newTestPetStore()
+
+	id, _ := ps.AddWebhook("http://example.com/hook", []string{"created"}, "secret", true)
+
+	t.Run("should get webhook", func(t *testing.T) {
+		got, err := ps.GetWebhook(id)
+		want := data.Webhook{Id: id, Url: "http://example.com/hook", Events: []string{"created"}, Secret: "secret",
+			Enabled: true, CreatedAt: testNow, UpdatedAt: testNow}
+
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %v and %v, want %v", got, err, want)
+		}
+		if _, err := ps.GetWebhook(id + 1); err != store.WebhookNotFound {
+			t.Fatalf("got %v, want %v", err, store.WebhookNotFound)
+		}
+	})
+
+	t.Run("should update webhook", func(t *testing.T) {
+		events := []string{"created", "deleted"}
+		if change, err := ps.UpdateWebhook(id, "http://example.com/hook", events, "secret", true); !change || err != nil {
+			t.Fatalf("got %t and %v, want change", change, err)
+		}
+		if change, err := ps.UpdateWebhook(id, "http://example.com/hook", events, "secret", true); change || err != nil {
+			t.Fatalf("got %t and %v, want not change", change, err)
+		}
+		if _, err := ps.UpdateWebhook(id+1, "http://example.com/hook", events, "secret", true); err != store.WebhookNotFound {
+			t.Fatalf("got %v, want %v", err, store.WebhookNotFound)
+		}
+	})
+
+	t.Run("should disable webhook", func(t *testing.T) {
+		if err := ps.DisableWebhook(id); err != nil {
+			t.Fatalf("want not error, got %v", err)
+		}
+		got, _ := ps.GetWebhook(id)
+		if got.Enabled || got.Url != "http://example.com/hook" || len(got.Events) != 2 || got.Secret != "secret" {
+			t.Fatalf("got %v, want only disabled", got)
+		}
+		if err := ps.DisableWebhook(id + 1); err != store.WebhookNotFound {
+			t.Fatalf("got %v, want %v", err, store.WebhookNotFound)
+		}
+		_, _ = ps.UpdateWebhook(id, got.Url, got.Events, got.Secret, true)
+	})
+
+	t.Run("should get all webhooks", func(t *testing.T) {
+		other, _ := ps.AddWebhook("http://example.com/other", []string{"updated"}, "secret", true)
+		webhooks, _ := ps.GetAllWebhooks()
+
+		if len(webhooks) != 2 || webhooks[0].Id != id || webhooks[1].Id != other {
+			t.Fatalf("got %v, want webhooks %d and %d", webhooks, id, other)
+		}
+	})
+
+	t.Run("should delete webhook with its deliveries", func(t *testing.T) {
+		other, _ := ps.AddWebhook("http://example.com/deleted", []string{"deleted"}, "secret", true)
+		_, _ = ps.EnqueueDeliveries("deleted", "{}")
+
+		if err := ps.DeleteWebhook(other); err != nil {
+			t.Fatalf("want not error, got %v", err)
+		}
+		if err := ps.DeleteWebhook(other); err != store.WebhookNotFound {
+			t.Fatalf("got %v, want %v", err, store.WebhookNotFound)
+		}
+		for _, delivery := range ps.deliveries {
+			if delivery.WebhookId == other {
+				t.Fatalf("got delivery %v for deleted webhook", delivery)
+			}
+		}
+	})
+}
+
+func TestDeliveries(t *testing.T) {
+	ps := newTestPetStore()
+
+	created, _ := ps.AddWebhook("http://example.com/created", []string{"created"}, "secret", true)
+	all, _ := ps.AddWebhook("http://example.com/all", []string{"created", "deleted"}, "secret", true)
+	_, _ = ps.AddWebhook("http://example.com/disabled", []string{"created"}, "secret", false)
+
+	t.Run("should enqueue deliveries for subscribed webhooks", func(t *testing.T) {
+		if count, err := ps.EnqueueDeliveries("created", `{"petId":1}`); count != 2 || err != nil {
+			t.Fatalf("got %d and %v, want 2", count, err)
+		}
+		if count, err := ps.EnqueueDeliveries("deleted", `{"petId":1}`); count != 1 || err != nil {
+			t.Fatalf("got %d and %v, want 1", count, err)
+		}
+	})
+
+	t.Run("should claim due deliveries", func(t *testing.T) {
+		claimed, err := ps.ClaimDeliveries(testNow, time.Minute, 2)
+		if err != nil || len(claimed) != 2 || claimed[0].WebhookId != created || claimed[1].WebhookId != all {
+			t.Fatalf("got %v and %v, want 2 deliveries", claimed, err)
+		}
+		if claimed[0].Payload != `{"petId":1}` || claimed[0].Status != data.DeliveryPending {
+			t.Fatalf("got %v, want pending delivery", claimed[0])
+		}
+
+		if claimed, _ := ps.ClaimDeliveries(testNow, time.Minute, 10); len(claimed) != 1 || claimed[0].Event != "deleted" {
+			t.Fatalf("got %v, want the deleted delivery", claimed)
+		}
+		if claimed, _ := ps.ClaimDeliveries(testNow.Add(time.Second), time.Minute, 10); len(claimed) != 0 {
+			t.Fatalf("got %v, want no deliveries", claimed)
+		}
+		if claimed, _ := ps.ClaimDeliveries(testNow.Add(time.Minute), time.Minute, 10); len(claimed) != 3 {
+			t.Fatalf("got %v, want expired claims", claimed)
+		}
+	})
+
+	t.Run("should record attempts", func(t *testing.T) {
+		deliveries, _, _ := ps.WebhookDeliveries(all, 0, 10)
+		failed := data.DeliveryAttempt{At: testNow, StatusCode: 500}
+		next := testNow.Add(time.Hour)
+
+		if failures, err := ps.RecordDeliveryAttempt(deliveries[0].Id, failed, data.DeliveryPending, next); failures != 1 || err != nil {
+			t.Fatalf("got %d and %v, want 1 failure", failures, err)
+		}
+		if failures, err := ps.RecordDeliveryAttempt(deliveries[1].Id, failed, data.DeliveryFailed, next); failures != 2 || err != nil {
+			t.Fatalf("got %d and %v, want 2 failures", failures, err)
+		}
+		delivered := data.DeliveryAttempt{At: testNow, StatusCode: 200}
+		if failures, err := ps.RecordDeliveryAttempt(deliveries[0].Id, delivered, data.DeliveryDelivered, next); failures != 0 || err != nil {
+			t.Fatalf("got %d and %v, want no failures", failures, err)
+		}
+		if _, err := ps.RecordDeliveryAttempt(100, delivered, data.DeliveryDelivered, next); err != store.DeliveryNotFound {
+			t.Fatalf("got %v, want %v", err, store.DeliveryNotFound)
+		}
+
+		got, total, _ := ps.WebhookDeliveries(all, 0, 1)
+		want := []data.DeliveryAttempt{failed, delivered}
+		if total != 2 || len(got) != 1 || got[0].Status != data.DeliveryDelivered || !reflect.DeepEqual(got[0].Attempts, want) {
+			t.Fatalf("got %v of %d, want delivered with attempts %v", got, total, want)
+		}
+	})
+
+	t.Run("should count pending deliveries", func(t *testing.T) {
+		if count, err := ps.PendingDeliveries(); count != 1 || err != nil {
+			t.Fatalf("got %d and %v, want 1", count, err)
+		}
+	})
+
+	t.Run("should reset failures when enabled again", func(t *testing.T) {
+		_, _ = ps.RecordDeliveryAttempt(1, data.DeliveryAttempt{Error: "timeout"}, data.DeliveryPending, testNow)
+		_, _ = ps.UpdateWebhook(created, "http://example.com/created", []string{"created"}, "secret", false)
+		if claimed, _ := ps.ClaimDeliveries(testNow, time.Minute, 10); len(claimed) != 0 {
+			t.Fatalf("got %v, want no deliveries for disabled webhooks", claimed)
+		}
+		_, _ = ps.UpdateWebhook(created, "http://example.com/created", []string{"created"}, "secret", true)
+
+		if webhook, _ := ps.GetWebhook(created); webhook.Failures != 0 {
+			t.Fatalf("got %d failures, want 0", webhook.Failures)
+		}
+	})
+
+	t.Run("should not get deliveries of a webhook that does not exist", func(t *testing.T) {
+		if _, _, err := ps.WebhookDeliveries(100, 0, 10); err != store.WebhookNotFound {
+			t.Fatalf("got %v, want %v", err, store.WebhookNotFound)
+		}
+	})
+}
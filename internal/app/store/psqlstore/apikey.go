@@ -0,0 +1,106 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package psqlstore
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/LearningByExample/go-microservice/internal/app/data"
+	"github.com/LearningByExample/go-microservice/internal/app/store"
+	"github.com/lib/pq"
+)
+
+func scanApiKey(r rowScanner) (data.ApiKey, error) {
+	var key = data.ApiKey{}
+	var scopes pq.StringArray
+	err := r.Scan(&key.Id, &key.Name, &key.Prefix, &key.Hash, &scopes, &key.CreatedAt, &key.RotatedAt, &key.RevokedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = store.ApiKeyNotFound
+	}
+	key.Scopes = scopes
+	return key, err
+}
+
+func (p posgreSQLPetStore) AddApiKey(name string, prefix string, hash string, scopes []string) (int, error) {
+	var id = 0
+	var err error = nil
+
+	if r := p.queryRow(sqlInsertApiKey, name, prefix, hash, pq.Array(scopes)); r != nil {
+		err = r.Scan(&id)
+	}
+
+	return id, err
+}
+
+func (p posgreSQLPetStore) GetApiKey(id int) (data.ApiKey, error) {
+	return scanApiKey(p.queryRow(sqlGetApiKey, id))
+}
+
+func (p posgreSQLPetStore) GetAllApiKeys() ([]data.ApiKey, error) {
+	var err error = nil
+	var keys = make([]data.ApiKey, 0)
+	var r *sql.Rows
+
+	if r, err = p.query(sqlGetAllApiKeys); err == nil {
+		//noinspection GoUnhandledErrorResult
+		defer r.Close()
+		for r.Next() {
+			var key data.ApiKey
+			if key, err = scanApiKey(r); err != nil {
+				break
+			}
+			keys = append(keys, key)
+		}
+		if err == nil {
+			err = r.Err()
+		}
+	}
+
+	return keys, err
+}
+
+func (p posgreSQLPetStore) FindApiKey(hash string) (data.ApiKey, error) {
+	return scanApiKey(p.queryRow(sqlFindApiKey, hash))
+}
+
+func (p posgreSQLPetStore) updateApiKey(query string, args ...interface{}) error {
+	var err error = nil
+	var r sql.Result
+	var count int64 = 0
+
+	if r, err = p.exec(query, args...); err == nil {
+		if count, err = r.RowsAffected(); err == nil && count == 0 {
+			err = store.ApiKeyNotFound
+		}
+	}
+
+	return err
+}
+
+func (p posgreSQLPetStore) RotateApiKey(id int, prefix string, hash string) error {
+	return p.updateApiKey(sqlRotateApiKey, id, prefix, hash)
+}
+
+func (p posgreSQLPetStore) RevokeApiKey(id int) error {
+	return p.updateApiKey(sqlRevokeApiKey, id)
+}
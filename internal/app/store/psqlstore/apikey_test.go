@@ -0,0 +1,145 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package psqlstore
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/LearningByExample/go-microservice/internal/app/data"
+	"github.com/LearningByExample/go-microservice/internal/app/store"
+	"reflect"
+	"testing"
+)
+
+const (
+	sqlInsertApiKeyMock  = "INSERT INTO api_keys .* RETURNING id;"
+	sqlSelectApiKeyMock  = "SELECT .* FROM api_keys WHERE id = \\$1;"
+	sqlSelectApiKeysMock = "SELECT .* FROM api_keys ORDER BY .*"
+	sqlFindApiKeyMock    = "SELECT .* FROM api_keys WHERE hash = \\$1 AND revoked_at IS NULL;"
+	sqlRotateApiKeyMock  = "UPDATE api_keys SET prefix = \\$2, hash = \\$3, .* revoked_at IS NULL;"
+	sqlRevokeApiKeyMock  = "UPDATE api_keys SET revoked_at = .*"
+)
+
+var (
+	apiKeyColumns = []string{"id", "name", "prefix", "hash", "scopes", "created_at", "rotated_at", "revoked_at"}
+)
+
+func apiKeyRows(mock sqlmock.Sqlmock, ids ...int) *sqlmock.Rows {
+	rows := mock.NewRows(apiKeyColumns)
+	for _, id := range ids {
+		rows.AddRow(id, "ci", "pk_1234", "hash", "{pets:read,pets:write}", mockTime, nil, mockTime)
+	}
+	return rows
+}
+
+func TestMockPosgreSQLPetStore_ApiKeys(t *testing.T) {
+	t.Run("should add api key", func(t *testing.T) {
+		ps, mock := initDBMock(t)
+		defer ps.Close()
+		mock.ExpectQuery(sqlInsertApiKeyMock).WithArgs("ci", "pk_1234", "hash", "{\"pets:read\"}").
+			WillReturnRows(mock.NewRows([]string{"id"}).AddRow(3))
+
+		if id, err := ps.AddApiKey("ci", "pk_1234", "hash", []string{data.ScopePetsRead}); id != 3 || err != nil {
+			t.Fatalf("got %d and %v, want 3", id, err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatalf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("should get api key", func(t *testing.T) {
+		ps, mock := initDBMock(t)
+		defer ps.Close()
+		mock.ExpectQuery(sqlSelectApiKeyMock).WithArgs(3).WillReturnRows(apiKeyRows(mock, 3))
+
+		got, err := ps.GetApiKey(3)
+		revoked := mockTime
+		want := data.ApiKey{Id: 3, Name: "ci", Prefix: "pk_1234", Hash: "hash",
+			Scopes: []string{data.ScopePetsRead, data.ScopePetsWrite}, CreatedAt: mockTime, RevokedAt: &revoked}
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %v and %v, want %v", got, err, want)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatalf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("should not find api key", func(t *testing.T) {
+		ps, mock := initDBMock(t)
+		defer ps.Close()
+		mock.ExpectQuery(sqlFindApiKeyMock).WithArgs("hash").WillReturnRows(apiKeyRows(mock))
+
+		if _, err := ps.FindApiKey("hash"); err != store.ApiKeyNotFound {
+			t.Fatalf("got %v, want %v", err, store.ApiKeyNotFound)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatalf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("should get all api keys", func(t *testing.T) {
+		ps, mock := initDBMock(t)
+		defer ps.Close()
+		mock.ExpectQuery(sqlSelectApiKeysMock).WillReturnRows(apiKeyRows(mock, 1, 2))
+
+		if keys, err := ps.GetAllApiKeys(); len(keys) != 2 || err != nil {
+			t.Fatalf("got %v and %v, want 2 api keys", keys, err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatalf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("should rotate api key", func(t *testing.T) {
+		ps, mock := initDBMock(t)
+		defer ps.Close()
+		mock.ExpectExec(sqlRotateApiKeyMock).WithArgs(3, "pk_5678", "rotated").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(sqlRotateApiKeyMock).WithArgs(4, "pk_5678", "rotated").WillReturnResult(sqlmock.NewResult(0, 0))
+
+		if err := ps.RotateApiKey(3, "pk_5678", "rotated"); err != nil {
+			t.Fatalf("want not error, got %v", err)
+		}
+		if err := ps.RotateApiKey(4, "pk_5678", "rotated"); err != store.ApiKeyNotFound {
+			t.Fatalf("got %v, want %v", err, store.ApiKeyNotFound)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatalf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("should revoke api key", func(t *testing.T) {
+		ps, mock := initDBMock(t)
+		defer ps.Close()
+		mock.ExpectExec(sqlRevokeApiKeyMock).WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(sqlRevokeApiKeyMock).WithArgs(4).WillReturnError(mockErr)
+
+		if err := ps.RevokeApiKey(3); err != nil {
+			t.Fatalf("want not error, got %v", err)
+		}
+		if err := ps.RevokeApiKey(4); err != mockErr {
+			t.Fatalf("got %v, want %v", err, mockErr)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatalf("there were unfulfilled expectations: %s", err)
+		}
+	})
+}
@@ -0,0 +1,80 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package psqlstore
+
+import (
+	"context"
+	"github.com/lib/pq"
+	"log"
+	"time"
+)
+
+const (
+	eventsChannel      = "pet_events"
+	listenerMinBackoff = 10 * time.Second
+	listenerMaxBackoff = time.Minute
+	listenerPing       = 90 * time.Second
+)
+
+func (p posgreSQLPetStore) NotifyEvent(payload string) error {
+	_, err := p.exec(sqlNotifyEvent, eventsChannel, payload)
+	return err
+}
+
+func logListenerEvent(event pq.ListenerEventType, err error) {
+	if err != nil {
+		log.Printf("Error %v listening to %q, event %d", err, eventsChannel, event)
+	}
+}
+
+// ListenEvents calls fn with the payload of every notification in the events channel, including the ones of this
+// store, until the context is done.
+func (p posgreSQLPetStore) ListenEvents(ctx context.Context, fn func(payload string)) error {
+	listener := pq.NewListener(p.connectionString(), listenerMinBackoff, listenerMaxBackoff, logListenerEvent)
+	//noinspection GoUnhandledErrorResult
+	defer listener.Close()
+
+	if err := listener.Listen(eventsChannel); err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(listenerPing)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case n := <-listener.Notify:
+			// a nil notification means the connection was re-established
+			if n != nil {
+				fn(n.Extra)
+			}
+		case <-ticker.C:
+			go func() {
+				if err := listener.Ping(); err != nil {
+					log.Printf("Error %v pinging listener of %q", err, eventsChannel)
+				}
+			}()
+		}
+	}
+}
@@ -0,0 +1,74 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package psqlstore
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"testing"
+)
+
+const (
+	sqlNotifyEventMock = "SELECT pg_notify\\(\\$1, \\$2\\);"
+)
+
+func TestMockPosgreSQLPetStore_NotifyEvent(t *testing.T) {
+	type testCase struct {
+		name    string
+		prepare func(mock sqlmock.Sqlmock)
+		err     error
+	}
+
+	var cases = []testCase{
+		{
+			name: "should notify event",
+			prepare: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(sqlNotifyEventMock).WithArgs(eventsChannel, `{"type":"created","petId":1}`).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+			err: nil,
+		},
+		{
+			name: "should error on notify error",
+			prepare: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(sqlNotifyEventMock).WithArgs(eventsChannel, `{"type":"created","petId":1}`).
+					WillReturnError(mockErr)
+			},
+			err: mockErr,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			ps, mock := initDBMock(t)
+			defer ps.Close()
+			tt.prepare(mock)
+
+			if err := ps.NotifyEvent(`{"type":"created","petId":1}`); err != tt.err {
+				t.Fatalf("error notifying event, got %v, want %v", err, tt.err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatalf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
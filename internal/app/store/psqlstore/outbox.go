@@ -0,0 +1,91 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package psqlstore
+
+import (
+	"database/sql"
+	"github.com/LearningByExample/go-microservice/internal/app/data"
+	"github.com/lib/pq"
+)
+
+// outboxLock is the advisory lock taken while relaying, so only one replica relays the events and keeps their order.
+const outboxLock = 0x6f7574626f78
+
+func (p posgreSQLPetStore) txUnpublished(tx *sql.Tx, limit int) ([]data.OutboxEvent, error) {
+	var err error = nil
+	var events = make([]data.OutboxEvent, 0)
+	var r *sql.Rows
+
+	p.logger("SQL query:", sqlGetUnpublished, limit)
+	if r, err = tx.QueryContext(p.ctx, sqlGetUnpublished, limit); err == nil {
+		//noinspection GoUnhandledErrorResult
+		defer r.Close()
+		for r.Next() {
+			var event = data.OutboxEvent{}
+			var action string
+			var payload []byte
+			if err = r.Scan(&event.Id, &event.PetId, &action, &payload, &event.CreatedAt); err != nil {
+				break
+			}
+			event.Type = data.PetAction(action)
+			event.Payload = payload
+			events = append(events, event)
+		}
+		if err == nil {
+			err = r.Err()
+		}
+	}
+
+	return events, err
+}
+
+func (p posgreSQLPetStore) RelayOutbox(limit int, publish func(event data.OutboxEvent) error) (int, error) {
+	var published = make([]int64, 0)
+	var publishErr error = nil
+
+	err := p.inTransaction(func(tx *sql.Tx) error {
+		var err error = nil
+		var locked = false
+		var events []data.OutboxEvent
+
+		if err = p.txQueryRow(tx, sqlLockOutbox, outboxLock).Scan(&locked); err == nil && locked {
+			if events, err = p.txUnpublished(tx, limit); err == nil {
+				for _, event := range events {
+					if publishErr = publish(event); publishErr != nil {
+						break
+					}
+					published = append(published, event.Id)
+				}
+				if len(published) != 0 {
+					_, err = p.txExec(tx, sqlMarkPublished, pq.Array(published))
+				}
+			}
+		}
+		return err
+	})
+
+	if err != nil {
+		return 0, err
+	}
+	return len(published), publishErr
+}
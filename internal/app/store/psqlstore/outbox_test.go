@@ -0,0 +1,144 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package psqlstore
+
+import (
+	"encoding/json"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/LearningByExample/go-microservice/internal/app/data"
+	"reflect"
+	"testing"
+)
+
+const (
+	sqlLockOutboxMock     = "SELECT pg_try_advisory_xact_lock\\(\\$1\\);"
+	sqlGetUnpublishedMock = "SELECT .* FROM outbox WHERE published_at IS NULL ORDER BY id ASC LIMIT \\$1;"
+	sqlMarkPublishedMock  = "UPDATE outbox SET published_at = now\\(\\) WHERE id = ANY\\(\\$1\\);"
+)
+
+var (
+	outboxColumns = []string{"id", "pet_id", "type", "payload", "created_at"}
+	mockPayload   = []byte(`{"petId":1}`)
+)
+
+func outboxRows(mock sqlmock.Sqlmock, ids ...int64) *sqlmock.Rows {
+	rows := mock.NewRows(outboxColumns)
+	for _, id := range ids {
+		rows.AddRow(id, 1, "create", mockPayload, mockTime)
+	}
+	return rows
+}
+
+func TestMockPosgreSQLPetStore_RelayOutbox(t *testing.T) {
+	t.Run("should publish events in order and mark them", func(t *testing.T) {
+		ps, mock := initDBMock(t)
+		defer ps.Close()
+		mock.ExpectBegin()
+		mock.ExpectQuery(sqlLockOutboxMock).WithArgs(outboxLock).WillReturnRows(mock.NewRows([]string{""}).AddRow(true))
+		mock.ExpectQuery(sqlGetUnpublishedMock).WithArgs(10).WillReturnRows(outboxRows(mock, 1, 2))
+		mock.ExpectExec(sqlMarkPublishedMock).WithArgs("{1,2}").WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectCommit()
+
+		got := make([]data.OutboxEvent, 0)
+		count, err := ps.RelayOutbox(10, func(event data.OutboxEvent) error {
+			got = append(got, event)
+			return nil
+		})
+
+		want := []data.OutboxEvent{
+			{Id: 1, PetId: 1, Type: data.CreateAction, Payload: json.RawMessage(mockPayload), CreatedAt: mockTime},
+			{Id: 2, PetId: 1, Type: data.CreateAction, Payload: json.RawMessage(mockPayload), CreatedAt: mockTime},
+		}
+		if count != 2 || err != nil || !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %d, %v and %v, want %v", count, err, got, want)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatalf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("should mark only the events published before an error", func(t *testing.T) {
+		ps, mock := initDBMock(t)
+		defer ps.Close()
+		mock.ExpectBegin()
+		mock.ExpectQuery(sqlLockOutboxMock).WillReturnRows(mock.NewRows([]string{""}).AddRow(true))
+		mock.ExpectQuery(sqlGetUnpublishedMock).WillReturnRows(outboxRows(mock, 1, 2, 3))
+		mock.ExpectExec(sqlMarkPublishedMock).WithArgs("{1}").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		count, err := ps.RelayOutbox(10, func(event data.OutboxEvent) error {
+			if event.Id == 2 {
+				return mockErr
+			}
+			return nil
+		})
+
+		if count != 1 || err != mockErr {
+			t.Fatalf("got %d and %v, want 1 and %v", count, err, mockErr)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatalf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("should not relay when other replica is relaying", func(t *testing.T) {
+		ps, mock := initDBMock(t)
+		defer ps.Close()
+		mock.ExpectBegin()
+		mock.ExpectQuery(sqlLockOutboxMock).WillReturnRows(mock.NewRows([]string{""}).AddRow(false))
+		mock.ExpectCommit()
+
+		count, err := ps.RelayOutbox(10, func(event data.OutboxEvent) error {
+			t.Fatalf("got event %v, want none", event)
+			return nil
+		})
+
+		if count != 0 || err != nil {
+			t.Fatalf("got %d and %v, want nothing relayed", count, err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatalf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("should fail when marking fails", func(t *testing.T) {
+		ps, mock := initDBMock(t)
+		defer ps.Close()
+		mock.ExpectBegin()
+		mock.ExpectQuery(sqlLockOutboxMock).WillReturnRows(mock.NewRows([]string{""}).AddRow(true))
+		mock.ExpectQuery(sqlGetUnpublishedMock).WillReturnRows(outboxRows(mock, 1))
+		mock.ExpectExec(sqlMarkPublishedMock).WillReturnError(mockErr)
+		mock.ExpectRollback()
+
+		count, err := ps.RelayOutbox(10, func(event data.OutboxEvent) error {
+			return nil
+		})
+
+		if count != 0 || err != mockErr {
+			t.Fatalf("got %d and %v, want %v", count, err, mockErr)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatalf("there were unfulfilled expectations: %s", err)
+		}
+	})
+}
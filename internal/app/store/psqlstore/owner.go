@@ -0,0 +1,220 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package psqlstore
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/LearningByExample/go-microservice/internal/app/data"
+	"github.com/LearningByExample/go-microservice/internal/app/store"
+	"github.com/lib/pq"
+)
+
+func scanOwner(r rowScanner) (data.Owner, error) {
+	var owner = data.Owner{}
+	err := r.Scan(&owner.Id, &owner.Name, &owner.Email, &owner.CreatedAt, &owner.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = store.OwnerNotFound
+	}
+	return owner, err
+}
+
+func ownerValue(id int) interface{} {
+	if id == 0 {
+		return nil
+	}
+	return id
+}
+
+func (p posgreSQLPetStore) txSetPetOwner(tx *sql.Tx, pet data.Pet, ownerId int) (bool, error) {
+	if pet.Owner() == ownerId {
+		return false, nil
+	}
+
+	after := pet
+	after.OwnerId = nil
+	if ownerId != 0 {
+		after.OwnerId = &ownerId
+	}
+	err := p.txQueryRow(tx, sqlSetPetOwner, pet.Id, ownerValue(ownerId)).Scan(&after.UpdatedAt)
+	if err == nil {
+		err = p.txRecordChange(tx, data.UpdateAction, pet.Id, &pet, &after)
+	}
+
+	return err == nil, err
+}
+
+func (p posgreSQLPetStore) txLockOwner(tx *sql.Tx, id int) error {
+	_, err := scanOwner(p.txQueryRow(tx, sqlGetOwnerForUpdate, id))
+	return err
+}
+
+func (p posgreSQLPetStore) txValidOwner(tx *sql.Tx, id int) error {
+	_, err := scanOwner(p.txQueryRow(tx, sqlGetOwner, id))
+	if err == store.OwnerNotFound {
+		err = store.InvalidOwner
+	}
+	return err
+}
+
+func (p posgreSQLPetStore) txOwnedPets(tx *sql.Tx, id int) ([]data.Pet, error) {
+	var err error = nil
+	var pets = make([]data.Pet, 0)
+	var r *sql.Rows
+
+	p.logger("SQL query:", sqlGetOwnedPetsForUpdate, id)
+	if r, err = tx.QueryContext(p.ctx, sqlGetOwnedPetsForUpdate, id); err == nil {
+		//noinspection GoUnhandledErrorResult
+		defer r.Close()
+		for r.Next() {
+			var pet data.Pet
+			if pet, err = scanPet(r); err != nil {
+				break
+			}
+			pets = append(pets, pet)
+		}
+		if err == nil {
+			err = r.Err()
+		}
+	}
+
+	return pets, err
+}
+
+func (p posgreSQLPetStore) AddOwner(name string, email string) (int, error) {
+	var id = 0
+	var err error = nil
+
+	if r := p.queryRow(sqlInsertOwner, name, email); r != nil {
+		err = r.Scan(&id)
+	}
+
+	return id, err
+}
+
+func (p posgreSQLPetStore) GetOwner(id int) (data.Owner, error) {
+	return scanOwner(p.queryRow(sqlGetOwner, id))
+}
+
+func (p posgreSQLPetStore) GetAllOwners() ([]data.Owner, error) {
+	return p.queryOwners(sqlGetAllOwners)
+}
+
+func (p posgreSQLPetStore) GetOwners(ids []int) ([]data.Owner, error) {
+	return p.queryOwners(sqlGetOwners, pq.Array(ids))
+}
+
+func (p posgreSQLPetStore) queryOwners(query string, args ...interface{}) ([]data.Owner, error) {
+	var err error = nil
+	var owners = make([]data.Owner, 0)
+	var r *sql.Rows
+
+	if r, err = p.query(query, args...); err == nil {
+		//noinspection GoUnhandledErrorResult
+		defer r.Close()
+		for r.Next() {
+			var owner data.Owner
+			if owner, err = scanOwner(r); err != nil {
+				break
+			}
+			owners = append(owners, owner)
+		}
+		if err == nil {
+			err = r.Err()
+		}
+	}
+
+	return owners, err
+}
+
+func (p posgreSQLPetStore) UpdateOwner(id int, name string, email string) (bool, error) {
+	var change = false
+	err := p.inTransaction(func(tx *sql.Tx) error {
+		var err error = nil
+		var r sql.Result
+		var count int64 = 0
+
+		if err = p.txLockOwner(tx, id); err == nil {
+			if r, err = p.txExec(tx, sqlUpdateOwner, id, name, email); err == nil {
+				if count, err = r.RowsAffected(); err == nil {
+					change = count == 1
+				}
+			}
+		}
+		return err
+	})
+	return change && err == nil, err
+}
+
+func (p posgreSQLPetStore) DeleteOwner(id int, reassignTo int) error {
+	return p.inTransaction(func(tx *sql.Tx) error {
+		var err error = nil
+		var pets []data.Pet
+
+		if err = p.txLockOwner(tx, id); err == nil && reassignTo != 0 {
+			if reassignTo == id {
+				err = store.InvalidOwner
+			} else {
+				err = p.txValidOwner(tx, reassignTo)
+			}
+		}
+		if err == nil {
+			pets, err = p.txOwnedPets(tx, id)
+		}
+		for _, pet := range pets {
+			if err != nil {
+				break
+			}
+			if reassignTo == 0 && !pet.IsDeleted() {
+				err = store.OwnerHasPets
+			} else {
+				_, err = p.txSetPetOwner(tx, pet, reassignTo)
+			}
+		}
+		if err == nil {
+			_, err = p.txExec(tx, sqlDeleteOwner, id)
+		}
+		return err
+	})
+}
+
+func (p posgreSQLPetStore) SetPetOwner(petId int, ownerId int) (bool, error) {
+	var change = false
+	err := p.inTransaction(func(tx *sql.Tx) error {
+		var err error = nil
+		var pet data.Pet
+
+		if pet, err = p.txGetPetForUpdate(tx, petId); err == nil {
+			if pet.IsDeleted() {
+				err = store.PetNotFound
+			} else if ownerId != 0 {
+				err = p.txValidOwner(tx, ownerId)
+			}
+			if err == nil {
+				change, err = p.txSetPetOwner(tx, pet, ownerId)
+			}
+		}
+		return err
+	})
+	return change && err == nil, err
+}
@@ -0,0 +1,429 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package psqlstore
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/LearningByExample/go-microservice/internal/app/data"
+	"github.com/LearningByExample/go-microservice/internal/app/store"
+	"github.com/lib/pq"
+	"reflect"
+	"testing"
+)
+
+const (
+	sqlInsertOwnerMock  = "INSERT INTO owners .* RETURNING id;"
+	sqlSelectOwnerMock  = "SELECT .* FROM owners WHERE id = \\$1;"
+	sqlLockOwnerMock    = "SELECT .* FROM owners WHERE id = \\$1 FOR UPDATE;"
+	sqlSelectOwnersMock = "SELECT .* FROM owners ORDER BY .*"
+	sqlSelectByIdsMock  = "SELECT .* FROM owners WHERE id = ANY\\(\\$1\\) ORDER BY .*"
+	sqlUpdateOwnerMock  = "UPDATE owners SET .*"
+	sqlDeleteOwnerMock  = "DELETE FROM owners WHERE id = \\$1;"
+	sqlSelectOwnedMock  = "SELECT .* FROM pets WHERE owner_id = \\$1 .* FOR UPDATE;"
+	sqlSetPetOwnerMock  = "UPDATE pets SET owner_id = \\$2 .*"
+)
+
+var (
+	ownerColumns = []string{"id", "name", "email", "created_at", "updated_at"}
+)
+
+func ownerRows(mock sqlmock.Sqlmock, ids ...int) *sqlmock.Rows {
+	rows := mock.NewRows(ownerColumns)
+	for _, id := range ids {
+		rows.AddRow(id, "name", "email", mockTime, mockTime)
+	}
+	return rows
+}
+
+func TestMockPosgreSQLPetStore_Owners(t *testing.T) {
+	t.Run("should add owner", func(t *testing.T) {
+		ps, mock := initDBMock(t)
+		defer ps.Close()
+		mock.ExpectQuery(sqlInsertOwnerMock).WithArgs("name", "email").
+			WillReturnRows(mock.NewRows([]string{"id"}).AddRow(3))
+
+		if id, err := ps.AddOwner("name", "email"); id != 3 || err != nil {
+			t.Fatalf("got %d and %v, want 3", id, err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatalf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("should get owner", func(t *testing.T) {
+		ps, mock := initDBMock(t)
+		defer ps.Close()
+		mock.ExpectQuery(sqlSelectOwnerMock).WithArgs(3).WillReturnRows(ownerRows(mock, 3))
+
+		got, err := ps.GetOwner(3)
+		want := data.Owner{Id: 3, Name: "name", Email: "email", CreatedAt: mockTime, UpdatedAt: mockTime}
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %v and %v, want %v", got, err, want)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatalf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("should not found owner", func(t *testing.T) {
+		ps, mock := initDBMock(t)
+		defer ps.Close()
+		mock.ExpectQuery(sqlSelectOwnerMock).WithArgs(3).WillReturnRows(ownerRows(mock))
+
+		if _, err := ps.GetOwner(3); err != store.OwnerNotFound {
+			t.Fatalf("got %v, want %v", err, store.OwnerNotFound)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatalf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("should get all owners", func(t *testing.T) {
+		ps, mock := initDBMock(t)
+		defer ps.Close()
+		mock.ExpectQuery(sqlSelectOwnersMock).WillReturnRows(ownerRows(mock, 1, 2))
+
+		if owners, err := ps.GetAllOwners(); len(owners) != 2 || err != nil {
+			t.Fatalf("got %v and %v, want 2 owners", owners, err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatalf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("should get owners by id", func(t *testing.T) {
+		ps, mock := initDBMock(t)
+		defer ps.Close()
+		mock.ExpectQuery(sqlSelectByIdsMock).WithArgs(pq.Array([]int{2, 1})).WillReturnRows(ownerRows(mock, 1, 2))
+
+		if owners, err := ps.GetOwners([]int{2, 1}); len(owners) != 2 || err != nil {
+			t.Fatalf("got %v and %v, want 2 owners", owners, err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatalf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("should get all owners with error", func(t *testing.T) {
+		ps, mock := initDBMock(t)
+		defer ps.Close()
+		mock.ExpectQuery(sqlSelectOwnersMock).WillReturnError(mockErr)
+
+		if _, err := ps.GetAllOwners(); err != mockErr {
+			t.Fatalf("got %v, want %v", err, mockErr)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatalf("there were unfulfilled expectations: %s", err)
+		}
+	})
+}
+
+func TestMockPosgreSQLPetStore_UpdateOwner(t *testing.T) {
+	type testCase struct {
+		name    string
+		prepare func(mock sqlmock.Sqlmock)
+		want    bool
+		err     error
+	}
+
+	var cases = []testCase{
+		{
+			name: "should update owner",
+			prepare: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(sqlLockOwnerMock).WithArgs(3).WillReturnRows(ownerRows(mock, 3))
+				mock.ExpectExec(sqlUpdateOwnerMock).WithArgs(3, "name", "email").WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+			want: true,
+			err:  nil,
+		},
+		{
+			name: "should not change owner",
+			prepare: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(sqlLockOwnerMock).WithArgs(3).WillReturnRows(ownerRows(mock, 3))
+				mock.ExpectExec(sqlUpdateOwnerMock).WithArgs(3, "name", "email").WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectCommit()
+			},
+			want: false,
+			err:  nil,
+		},
+		{
+			name: "should not found owner",
+			prepare: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(sqlLockOwnerMock).WithArgs(3).WillReturnRows(ownerRows(mock))
+				mock.ExpectRollback()
+			},
+			want: false,
+			err:  store.OwnerNotFound,
+		},
+		{
+			name: "should error on update error",
+			prepare: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(sqlLockOwnerMock).WithArgs(3).WillReturnRows(ownerRows(mock, 3))
+				mock.ExpectExec(sqlUpdateOwnerMock).WillReturnError(mockErr)
+				mock.ExpectRollback()
+			},
+			want: false,
+			err:  mockErr,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			ps, mock := initDBMock(t)
+			defer ps.Close()
+			tt.prepare(mock)
+
+			got, err := ps.UpdateOwner(3, "name", "email")
+			if got != tt.want || err != tt.err {
+				t.Fatalf("error updating owner, got %t and %v, want %t and %v", got, err, tt.want, tt.err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatalf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+func TestMockPosgreSQLPetStore_DeleteOwner(t *testing.T) {
+	type testCase struct {
+		name       string
+		reassignTo int
+		prepare    func(mock sqlmock.Sqlmock)
+		err        error
+	}
+
+	var cases = []testCase{
+		{
+			name:       "should delete owner without pets",
+			reassignTo: 0,
+			prepare: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(sqlLockOwnerMock).WithArgs(3).WillReturnRows(ownerRows(mock, 3))
+				mock.ExpectQuery(sqlSelectOwnedMock).WithArgs(3).WillReturnRows(mock.NewRows(petColumns))
+				mock.ExpectExec(sqlDeleteOwnerMock).WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+			err: nil,
+		},
+		{
+			name:       "should release deleted pets",
+			reassignTo: 0,
+			prepare: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(sqlLockOwnerMock).WithArgs(3).WillReturnRows(ownerRows(mock, 3))
+				mock.ExpectQuery(sqlSelectOwnedMock).WithArgs(3).WillReturnRows(mock.NewRows(petColumns).
+					AddRow(5, "name", "race", "mod", mockTime, mockTime, mockTime, 3, "available", mockAttributes, mockTags))
+				mock.ExpectQuery(sqlSetPetOwnerMock).WithArgs(5, nil).
+					WillReturnRows(mock.NewRows([]string{"updated_at"}).AddRow(mockTime))
+				mock.ExpectExec(sqlInsertHistory).WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec(sqlDeleteOwnerMock).WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+			err: nil,
+		},
+		{
+			name:       "should reject owner with pets",
+			reassignTo: 0,
+			prepare: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(sqlLockOwnerMock).WithArgs(3).WillReturnRows(ownerRows(mock, 3))
+				mock.ExpectQuery(sqlSelectOwnedMock).WithArgs(3).WillReturnRows(mock.NewRows(petColumns).
+					AddRow(5, "name", "race", "mod", mockTime, mockTime, nil, 3, "available", mockAttributes, mockTags))
+				mock.ExpectRollback()
+			},
+			err: store.OwnerHasPets,
+		},
+		{
+			name:       "should reassign pets",
+			reassignTo: 4,
+			prepare: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(sqlLockOwnerMock).WithArgs(3).WillReturnRows(ownerRows(mock, 3))
+				mock.ExpectQuery(sqlSelectOwnerMock).WithArgs(4).WillReturnRows(ownerRows(mock, 4))
+				mock.ExpectQuery(sqlSelectOwnedMock).WithArgs(3).WillReturnRows(mock.NewRows(petColumns).
+					AddRow(5, "name", "race", "mod", mockTime, mockTime, nil, 3, "available", mockAttributes, mockTags))
+				mock.ExpectQuery(sqlSetPetOwnerMock).WithArgs(5, 4).
+					WillReturnRows(mock.NewRows([]string{"updated_at"}).AddRow(mockTime))
+				mock.ExpectExec(sqlInsertHistory).WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec(sqlDeleteOwnerMock).WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+			err: nil,
+		},
+		{
+			name:       "should reject reassign to same owner",
+			reassignTo: 3,
+			prepare: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(sqlLockOwnerMock).WithArgs(3).WillReturnRows(ownerRows(mock, 3))
+				mock.ExpectRollback()
+			},
+			err: store.InvalidOwner,
+		},
+		{
+			name:       "should reject reassign to missing owner",
+			reassignTo: 4,
+			prepare: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(sqlLockOwnerMock).WithArgs(3).WillReturnRows(ownerRows(mock, 3))
+				mock.ExpectQuery(sqlSelectOwnerMock).WithArgs(4).WillReturnRows(ownerRows(mock))
+				mock.ExpectRollback()
+			},
+			err: store.InvalidOwner,
+		},
+		{
+			name:       "should not found owner",
+			reassignTo: 0,
+			prepare: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(sqlLockOwnerMock).WithArgs(3).WillReturnRows(ownerRows(mock))
+				mock.ExpectRollback()
+			},
+			err: store.OwnerNotFound,
+		},
+		{
+			name:       "should error on pets query error",
+			reassignTo: 0,
+			prepare: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(sqlLockOwnerMock).WithArgs(3).WillReturnRows(ownerRows(mock, 3))
+				mock.ExpectQuery(sqlSelectOwnedMock).WithArgs(3).WillReturnError(mockErr)
+				mock.ExpectRollback()
+			},
+			err: mockErr,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			ps, mock := initDBMock(t)
+			defer ps.Close()
+			tt.prepare(mock)
+
+			if err := ps.DeleteOwner(3, tt.reassignTo); err != tt.err {
+				t.Fatalf("error deleting owner, got %v, want %v", err, tt.err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatalf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+func TestMockPosgreSQLPetStore_SetPetOwner(t *testing.T) {
+	type testCase struct {
+		name    string
+		ownerId int
+		prepare func(mock sqlmock.Sqlmock)
+		want    bool
+		err     error
+	}
+
+	var cases = []testCase{
+		{
+			name:    "should set pet owner",
+			ownerId: 3,
+			prepare: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(sqlForUpdate).WithArgs(5).WillReturnRows(petForUpdateRows(mock, 5, false))
+				mock.ExpectQuery(sqlSelectOwnerMock).WithArgs(3).WillReturnRows(ownerRows(mock, 3))
+				mock.ExpectQuery(sqlSetPetOwnerMock).WithArgs(5, 3).
+					WillReturnRows(mock.NewRows([]string{"updated_at"}).AddRow(mockTime))
+				mock.ExpectExec(sqlInsertHistory).
+					WithArgs(5, "update", "anonymous", "", sqlmock.AnyArg(), sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectCommit()
+			},
+			want: true,
+			err:  nil,
+		},
+		{
+			name:    "should not change a pet without owner",
+			ownerId: 0,
+			prepare: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(sqlForUpdate).WithArgs(5).WillReturnRows(petForUpdateRows(mock, 5, false))
+				mock.ExpectCommit()
+			},
+			want: false,
+			err:  nil,
+		},
+		{
+			name:    "should not found deleted pet",
+			ownerId: 3,
+			prepare: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(sqlForUpdate).WithArgs(5).WillReturnRows(petForUpdateRows(mock, 5, true))
+				mock.ExpectRollback()
+			},
+			want: false,
+			err:  store.PetNotFound,
+		},
+		{
+			name:    "should reject missing owner",
+			ownerId: 3,
+			prepare: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(sqlForUpdate).WithArgs(5).WillReturnRows(petForUpdateRows(mock, 5, false))
+				mock.ExpectQuery(sqlSelectOwnerMock).WithArgs(3).WillReturnRows(ownerRows(mock))
+				mock.ExpectRollback()
+			},
+			want: false,
+			err:  store.InvalidOwner,
+		},
+		{
+			name:    "should error on update error",
+			ownerId: 3,
+			prepare: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(sqlForUpdate).WithArgs(5).WillReturnRows(petForUpdateRows(mock, 5, false))
+				mock.ExpectQuery(sqlSelectOwnerMock).WithArgs(3).WillReturnRows(ownerRows(mock, 3))
+				mock.ExpectQuery(sqlSetPetOwnerMock).WithArgs(5, 3).WillReturnError(mockErr)
+				mock.ExpectRollback()
+			},
+			want: false,
+			err:  mockErr,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			ps, mock := initDBMock(t)
+			defer ps.Close()
+			tt.prepare(mock)
+
+			got, err := ps.SetPetOwner(5, tt.ownerId)
+			if got != tt.want || err != tt.err {
+				t.Fatalf("error setting pet owner, got %t and %v, want %t and %v", got, err, tt.want, tt.err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatalf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
@@ -25,12 +25,14 @@ package psqlstore
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/LearningByExample/go-microservice/internal/app/config"
 	"github.com/LearningByExample/go-microservice/internal/app/data"
+	"github.com/LearningByExample/go-microservice/internal/app/reqctx"
 	"github.com/LearningByExample/go-microservice/internal/app/store"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	"log"
 	"time"
 )
@@ -52,6 +54,30 @@ type posgreSQLPetStore struct {
 	db     *sql.DB
 	logger func(v ...interface{})
 	open   conFunc
+	ctx    context.Context
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanPet(r rowScanner, extra ...interface{}) (data.Pet, error) {
+	var pet = data.Pet{}
+	var ownerId sql.NullInt64
+	var attributes []byte
+	var tags pq.StringArray
+	dest := []interface{}{&pet.Id, &pet.Name, &pet.Race, &pet.Mod, &pet.CreatedAt, &pet.UpdatedAt, &pet.DeletedAt,
+		&ownerId, &pet.Status, &attributes, &tags}
+	err := r.Scan(append(dest, extra...)...)
+	if err == nil && ownerId.Valid {
+		id := int(ownerId.Int64)
+		pet.OwnerId = &id
+	}
+	if err == nil {
+		pet.Tags = data.SortedTags(tags)
+		err = attributesFromJson(attributes, &pet)
+	}
+	return pet, err
 }
 
 func (p posgreSQLPetStore) IsReady() error {
@@ -71,19 +97,11 @@ func (p posgreSQLPetStore) IsReady() error {
 
 func (p posgreSQLPetStore) AddPet(name string, race string, mod string) (int, error) {
 	var id = 0
-	var err error = nil
-	var tx *sql.Tx = nil
-
-	if tx, err = p.beginTransaction(); err == nil {
-		if r := p.txQueryRow(tx, sqlInsertPet, name, race, mod); r != nil {
-			if err = r.Scan(&id); err == nil {
-				err = tx.Commit()
-			} else {
-				_ = tx.Rollback()
-			}
-		}
-	}
-
+	err := p.inTransaction(func(tx *sql.Tx) error {
+		var err error = nil
+		id, err = p.txCreatePet(tx, data.Pet{Name: name, Race: race, Mod: mod})
+		return err
+	})
 	return id, err
 }
 
@@ -91,7 +109,7 @@ func (p posgreSQLPetStore) GetPet(id int) (data.Pet, error) {
 	var err error = nil
 	var pet = data.Pet{}
 	if r := p.queryRow(sqlGetPet, id); r != nil {
-		err = r.Scan(&pet.Id, &pet.Name, &pet.Race, &pet.Mod)
+		pet, err = scanPet(r)
 		if errors.Is(err, sql.ErrNoRows) {
 			err = store.PetNotFound
 		}
@@ -108,8 +126,8 @@ func (p posgreSQLPetStore) GetAllPets() ([]data.Pet, error) {
 		//noinspection GoUnhandledErrorResult
 		defer r.Close()
 		for r.Next() {
-			var pet = data.Pet{}
-			if err = r.Scan(&pet.Id, &pet.Name, &pet.Race, &pet.Mod); err != nil {
+			var pet data.Pet
+			if pet, err = scanPet(r); err != nil {
 				break
 			}
 			pets = append(pets, pet)
@@ -119,41 +137,484 @@ func (p posgreSQLPetStore) GetAllPets() ([]data.Pet, error) {
 	return pets, err
 }
 
+func (p posgreSQLPetStore) ForEachPet(fn func(pet data.Pet) error) error {
+	var err error = nil
+	var r *sql.Rows
+
+	if r, err = p.query(sqlGetAllPets); err == nil {
+		//noinspection GoUnhandledErrorResult
+		defer r.Close()
+		for r.Next() {
+			var pet data.Pet
+			if pet, err = scanPet(r); err != nil {
+				break
+			}
+			if err = fn(pet); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = r.Err()
+		}
+	}
+
+	return err
+}
+
+func (p posgreSQLPetStore) txNextPetIds(tx *sql.Tx, count int) ([]int, time.Time, error) {
+	var err error = nil
+	var ids = make([]int, 0, count)
+	var now time.Time
+	var r *sql.Rows
+
+	p.logger("SQL query:", sqlNextPetIds, count)
+	if r, err = tx.QueryContext(p.ctx, sqlNextPetIds, count); err == nil {
+		//noinspection GoUnhandledErrorResult
+		defer r.Close()
+		for r.Next() {
+			var id = 0
+			if err = r.Scan(&id, &now); err != nil {
+				break
+			}
+			ids = append(ids, id)
+		}
+		if err == nil {
+			err = r.Err()
+		}
+	}
+
+	return ids, now, err
+}
+
+func (p posgreSQLPetStore) txCopy(tx *sql.Tx, query string, rows [][]interface{}) error {
+	p.logger("SQL copy:", query, len(rows))
+	stmt, err := tx.PrepareContext(p.ctx, query)
+	if err == nil {
+		//noinspection GoUnhandledErrorResult
+		defer stmt.Close()
+		for _, row := range rows {
+			if _, err = stmt.ExecContext(p.ctx, row...); err != nil {
+				return err
+			}
+		}
+		_, err = stmt.ExecContext(p.ctx)
+	}
+	return err
+}
+
+// importedPet is the pet to copy with all its fields, the status and the timestamps when not given are the defaults
+func importedPet(id int, pet data.Pet, now time.Time) data.Pet {
+	imported := data.Pet{Id: id, Name: pet.Name, Race: pet.Race, Mod: pet.Mod, Status: pet.Status,
+		OwnerId: pet.OwnerId, Tags: data.SortedTags(pet.Tags), Attributes: pet.Attributes,
+		CreatedAt: pet.CreatedAt, UpdatedAt: pet.UpdatedAt}
+	if imported.Status == "" {
+		imported.Status = data.Available
+	}
+	if len(imported.Attributes) == 0 {
+		imported.Attributes = nil
+	}
+	if imported.CreatedAt.IsZero() {
+		imported.CreatedAt = now
+	}
+	if imported.UpdatedAt.IsZero() {
+		imported.UpdatedAt = imported.CreatedAt
+	}
+	return imported
+}
+
+func (p posgreSQLPetStore) txValidOwners(tx *sql.Tx, pets []data.Pet) error {
+	valid := make(map[int]bool)
+	for _, pet := range pets {
+		if id := pet.Owner(); pet.OwnerId != nil && !valid[id] {
+			if err := p.txValidOwner(tx, id); err != nil {
+				return err
+			}
+			valid[id] = true
+		}
+	}
+	return nil
+}
+
+func (p posgreSQLPetStore) copyPets(tx *sql.Tx, pets []data.Pet) error {
+	err := p.txValidOwners(tx, pets)
+	if err != nil {
+		return err
+	}
+	ids, now, err := p.txNextPetIds(tx, len(pets))
+	if err == nil {
+		petRows := make([][]interface{}, len(ids))
+		tagRows := make([][]interface{}, 0)
+		changeRows := make([][]interface{}, len(ids))
+		actor, requestId := reqctx.Actor(p.ctx), reqctx.RequestId(p.ctx)
+		for i, id := range ids {
+			pet := importedPet(id, pets[i], now)
+			var after interface{}
+			var attrs []byte
+			if after, err = petJson(&pet); err != nil {
+				return err
+			}
+			if attrs, err = attributesJson(pet.Attributes); err != nil {
+				return err
+			}
+			var ownerId interface{} = nil
+			if pet.OwnerId != nil {
+				ownerId = pet.Owner()
+			}
+			petRows[i] = []interface{}{pet.Id, pet.Name, pet.Race, pet.Mod, string(pet.Status), ownerId, string(attrs),
+				pet.CreatedAt, pet.UpdatedAt}
+			for _, tag := range pet.Tags {
+				tagRows = append(tagRows, []interface{}{pet.Id, tag})
+			}
+			changeRows[i] = []interface{}{pet.Id, string(data.CreateAction), actor, requestId, after}
+		}
+		if err = p.txCopy(tx, sqlCopyPets, petRows); err == nil && len(tagRows) != 0 {
+			err = p.txCopy(tx, sqlCopyTags, tagRows)
+		}
+		if err == nil {
+			err = p.txCopy(tx, sqlCopyChanges, changeRows)
+		}
+		if err == nil {
+			for i, id := range ids {
+				pets[i].Id = id
+			}
+		}
+	}
+	return err
+}
+
+func (p posgreSQLPetStore) ImportPets(pets []data.Pet) error {
+	return p.inTransaction(func(tx *sql.Tx) error {
+		return p.copyPets(tx, pets)
+	})
+}
+
+func petJson(pet *data.Pet) (interface{}, error) {
+	if pet == nil {
+		return nil, nil
+	}
+	bytes, err := json.Marshal(pet)
+	if err != nil {
+		return nil, err
+	}
+	return string(bytes), nil
+}
+
+func (p posgreSQLPetStore) txRecordChange(tx *sql.Tx, action data.PetAction, id int, before *data.Pet, after *data.Pet) error {
+	var err error = nil
+	var beforeJson, afterJson interface{}
+
+	if beforeJson, err = petJson(before); err == nil {
+		if afterJson, err = petJson(after); err == nil {
+			_, err = p.txExec(tx, sqlInsertChange, id, string(action),
+				reqctx.Actor(p.ctx), reqctx.RequestId(p.ctx), beforeJson, afterJson)
+		}
+	}
+
+	return err
+}
+
+func (p posgreSQLPetStore) txGetPetForUpdate(tx *sql.Tx, id int) (data.Pet, error) {
+	pet, err := scanPet(p.txQueryRow(tx, sqlGetPetForUpdate, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		err = store.PetNotFound
+	}
+	return pet, err
+}
+
+func (p posgreSQLPetStore) txCreatePet(tx *sql.Tx, pet data.Pet) (int, error) {
+	var id = 0
+	var err error = nil
+
+	if r := p.txQueryRow(tx, sqlInsertPet, pet.Name, pet.Race, pet.Mod); r != nil {
+		created := data.Pet{Name: pet.Name, Race: pet.Race, Mod: pet.Mod, Status: data.Available}
+		if err = r.Scan(&created.Id, &created.CreatedAt, &created.UpdatedAt); err == nil {
+			id = created.Id
+			err = p.txRecordChange(tx, data.CreateAction, id, nil, &created)
+		}
+	}
+
+	return id, err
+}
+
+func (p posgreSQLPetStore) txUpdatePet(tx *sql.Tx, id int, pet data.Pet) (bool, error) {
+	var change = false
+	var err error = nil
+	var before data.Pet
+
+	if before, err = p.txGetPetForUpdate(tx, id); err == nil {
+		if before.IsDeleted() {
+			err = store.PetNotFound
+		} else {
+			after := before
+			after.Name, after.Race, after.Mod = pet.Name, pet.Race, pet.Mod
+			err = p.txQueryRow(tx, sqlUpdatePet, id, pet.Name, pet.Race, pet.Mod).Scan(&after.UpdatedAt)
+			if err == nil {
+				change = true
+				err = p.txRecordChange(tx, data.UpdateAction, id, &before, &after)
+			} else if errors.Is(err, sql.ErrNoRows) {
+				err = nil
+			}
+		}
+	}
+
+	return change, err
+}
+
+func (p posgreSQLPetStore) txDeletePet(tx *sql.Tx, id int) error {
+	var err error = nil
+	var before data.Pet
+
+	if before, err = p.txGetPetForUpdate(tx, id); err == nil {
+		if before.IsDeleted() {
+			err = store.PetNotFound
+		} else {
+			after := before
+			if err = p.txQueryRow(tx, sqlDeletePet, id).Scan(&after.DeletedAt, &after.UpdatedAt); err == nil {
+				err = p.txRecordChange(tx, data.DeleteAction, id, &before, &after)
+			} else if errors.Is(err, sql.ErrNoRows) {
+				err = store.PetNotFound
+			}
+		}
+	}
+
+	return err
+}
+
+func (p posgreSQLPetStore) txRestorePet(tx *sql.Tx, id int) error {
+	var err error = nil
+	var before data.Pet
+
+	if before, err = p.txGetPetForUpdate(tx, id); err == nil {
+		if !before.IsDeleted() {
+			err = store.PetNotFound
+		} else {
+			after := before
+			after.DeletedAt = nil
+			if err = p.txQueryRow(tx, sqlRestorePet, id).Scan(&after.UpdatedAt); err == nil {
+				err = p.txRecordChange(tx, data.RestoreAction, id, &before, &after)
+			} else if errors.Is(err, sql.ErrNoRows) {
+				err = store.PetNotFound
+			}
+		}
+	}
+
+	return err
+}
+
+func (p posgreSQLPetStore) txTransitionPet(tx *sql.Tx, id int, to data.PetStatus) (data.Pet, error) {
+	var err error = nil
+	var before, after data.Pet
+
+	if before, err = p.txGetPetForUpdate(tx, id); err == nil {
+		if before.IsDeleted() {
+			err = store.PetNotFound
+		} else if !before.Status.CanTransitionTo(to) {
+			err = store.IllegalStatus
+		} else {
+			after = before
+			after.Status = to
+			err = p.txQueryRow(tx, sqlTransitionPet, id, string(before.Status), string(to)).Scan(&after.UpdatedAt)
+			if err == nil {
+				err = p.txRecordChange(tx, data.TransitionAction, id, &before, &after)
+			} else if errors.Is(err, sql.ErrNoRows) {
+				err = store.IllegalStatus
+			}
+		}
+	}
+
+	return after, err
+}
+
+func (p posgreSQLPetStore) txApplyOperation(tx *sql.Tx, op store.PetOperation) store.PetOperationResult {
+	var result = store.PetOperationResult{Id: op.Pet.Id}
+	pet := op.Pet
+
+	switch op.Type {
+	case store.CreateOperation:
+		if result.Id, result.Err = p.txCreatePet(tx, pet); result.Err == nil {
+			result.Changed = true
+		}
+	case store.UpdateOperation:
+		result.Changed, result.Err = p.txUpdatePet(tx, pet.Id, pet)
+	case store.DeleteOperation:
+		if result.Err = p.txDeletePet(tx, pet.Id); result.Err == nil {
+			result.Changed = true
+		}
+	default:
+		result.Err = store.InvalidOperation
+	}
+
+	return result
+}
+
+func (p posgreSQLPetStore) txApplyIndependent(tx *sql.Tx, op store.PetOperation) (store.PetOperationResult, error) {
+	var result = store.PetOperationResult{}
+	var err error = nil
+
+	if _, err = p.txExec(tx, sqlSavepoint); err == nil {
+		if result = p.txApplyOperation(tx, op); result.Err == nil {
+			_, err = p.txExec(tx, sqlReleaseSavepoint)
+		} else {
+			_, err = p.txExec(tx, sqlRollbackToSavepoint)
+		}
+	}
+
+	return result, err
+}
+
+func (p posgreSQLPetStore) BatchPets(ops []store.PetOperation, atomic bool) ([]store.PetOperationResult, error) {
+	var err error = nil
+	var tx *sql.Tx = nil
+	var results = make([]store.PetOperationResult, len(ops))
+
+	if tx, err = p.beginTransaction(); err == nil {
+		for i, op := range ops {
+			if atomic {
+				if results[i] = p.txApplyOperation(tx, op); results[i].Err != nil {
+					failed := results[i]
+					results = make([]store.PetOperationResult, len(ops))
+					results[i] = failed
+					break
+				}
+			} else if results[i], err = p.txApplyIndependent(tx, op); err != nil {
+				break
+			}
+		}
+		if err == nil && !(atomic && hasFailed(results)) {
+			err = tx.Commit()
+		} else {
+			_ = tx.Rollback()
+		}
+	}
+
+	return results, err
+}
+
+func hasFailed(results []store.PetOperationResult) bool {
+	for _, result := range results {
+		if result.Err != nil {
+			return true
+		}
+	}
+	return false
+}
+
 func (p posgreSQLPetStore) beginTransaction() (*sql.Tx, error) {
 	ops := sql.TxOptions{
 		Isolation: sql.LevelDefault,
 		ReadOnly:  false,
 	}
-	return p.db.BeginTx(context.Background(), &ops)
+	return p.db.BeginTx(p.ctx, &ops)
 }
 
-func (p posgreSQLPetStore) DeletePet(id int) error {
+func (p posgreSQLPetStore) inTransaction(fn func(tx *sql.Tx) error) error {
 	var err error = nil
-	var r sql.Result = nil
-	var count int64 = 0
-	var tx *sql.Tx
+	var tx *sql.Tx = nil
+
 	if tx, err = p.beginTransaction(); err == nil {
-		if r, err = p.txExec(tx, sqlDeletePet, id); err == nil {
-			if count, err = r.RowsAffected(); err == nil {
-				if count == 0 {
-					err = store.PetNotFound
-					_ = tx.Rollback()
-				} else {
-					err = tx.Commit()
-				}
-			} else {
-				_ = tx.Rollback()
-			}
+		if err = fn(tx); err == nil {
+			err = tx.Commit()
+		} else {
+			_ = tx.Rollback()
 		}
 	}
 
 	return err
 }
 
-func (p posgreSQLPetStore) verifyPetExists(id int) error {
+func (p posgreSQLPetStore) DeletePet(id int) error {
+	return p.inTransaction(func(tx *sql.Tx) error {
+		return p.txDeletePet(tx, id)
+	})
+}
+
+func (p posgreSQLPetStore) RestorePet(id int) error {
+	return p.inTransaction(func(tx *sql.Tx) error {
+		return p.txRestorePet(tx, id)
+	})
+}
+
+func (p posgreSQLPetStore) TransitionPet(id int, to data.PetStatus) (data.Pet, error) {
+	var pet = data.Pet{}
+	err := p.inTransaction(func(tx *sql.Tx) error {
+		var err error = nil
+		pet, err = p.txTransitionPet(tx, id, to)
+		return err
+	})
+	if err != nil {
+		return data.Pet{}, err
+	}
+	return pet, nil
+}
+
+func (p posgreSQLPetStore) PurgePets(before time.Time) ([]int, error) {
+	var err error = nil
+	var ids = make([]int, 0)
+	var r *sql.Rows
+
+	if r, err = p.query(sqlPurgePets, before); err == nil {
+		//noinspection GoUnhandledErrorResult
+		defer r.Close()
+		for r.Next() {
+			var id = 0
+			if err = r.Scan(&id); err != nil {
+				break
+			}
+			ids = append(ids, id)
+		}
+		if err == nil {
+			err = r.Err()
+		}
+	}
+
+	return ids, err
+}
+
+func (p posgreSQLPetStore) FindPets(query store.PetQuery) ([]data.Pet, error) {
+	var err error = nil
+	var pets = make([]data.Pet, 0)
+	var r *sql.Rows
+
+	sqlQuery, args := findPetsQuery(query)
+	if r, err = p.query(sqlQuery, args...); err == nil {
+		//noinspection GoUnhandledErrorResult
+		defer r.Close()
+		for r.Next() {
+			var pet data.Pet
+			if pet, err = scanPet(r); err != nil {
+				break
+			}
+			pets = append(pets, pet)
+		}
+		if err == nil {
+			err = r.Err()
+		}
+	}
+
+	return pets, err
+}
+
+func (p posgreSQLPetStore) CountPets(query store.PetQuery) (int, error) {
+	var count = 0
+	sqlQuery, args := countPetsQuery(query)
+	err := p.queryRow(sqlQuery, args...).Scan(&count)
+	return count, err
+}
+
+func (p posgreSQLPetStore) UpdatePet(id int, name string, race string, mod string) (bool, error) {
+	var change = false
+	err := p.inTransaction(func(tx *sql.Tx) error {
+		var err error = nil
+		change, err = p.txUpdatePet(tx, id, data.Pet{Name: name, Race: race, Mod: mod})
+		return err
+	})
+	return change && err == nil, err
+}
+
+func (p posgreSQLPetStore) petRecordExists(id int) error {
 	var err error = nil
 	var petId = 0
-	if r := p.queryRow(sqlVerifyPetExists, id); r != nil {
+	if r := p.queryRow(sqlPetRecordExists, id); r != nil {
 		err = r.Scan(&petId)
 		if errors.Is(err, sql.ErrNoRows) {
 			err = store.PetNotFound
@@ -162,33 +623,69 @@ func (p posgreSQLPetStore) verifyPetExists(id int) error {
 	return err
 }
 
-func (p posgreSQLPetStore) UpdatePet(id int, name string, race string, mod string) (bool, error) {
-	var count int64 = 0
+func (p posgreSQLPetStore) PetHistory(id int, offset int, limit int) ([]data.PetChange, int, error) {
+	var total = 0
 	var err error = nil
-	var r sql.Result = nil
-	var tx *sql.Tx = nil
+	var changes = make([]data.PetChange, 0)
+	var r *sql.Rows
+	var pageLimit interface{} = nil
 
-	if err = p.verifyPetExists(id); err == nil {
-		if tx, err = p.beginTransaction(); err == nil {
-			if r, err = p.txExec(tx, sqlUpdatePet, id, name, race, mod); err == nil {
-				if count, err = r.RowsAffected(); err == nil {
-					if count == 0 {
-						err = tx.Rollback()
-					} else {
-						err = tx.Commit()
-					}
-				} else {
-					_ = tx.Rollback()
+	if limit > 0 {
+		pageLimit = limit
+	}
+
+	if err = p.queryRow(sqlCountChanges, id).Scan(&total); err == nil && total == 0 {
+		err = p.petRecordExists(id)
+	}
+	if err == nil && offset < total {
+		if r, err = p.query(sqlGetChanges, id, pageLimit, offset); err == nil {
+			//noinspection GoUnhandledErrorResult
+			defer r.Close()
+			for r.Next() {
+				var change = data.PetChange{}
+				var action string
+				var before, after []byte
+				if err = r.Scan(&change.Id, &change.PetId, &action, &change.Timestamp,
+					&change.Actor, &change.RequestId, &before, &after); err != nil {
+					break
+				}
+				change.Action = data.PetAction(action)
+				if change.Before, err = petFromJson(before); err != nil {
+					break
+				}
+				if change.After, err = petFromJson(after); err != nil {
+					break
 				}
+				changes = append(changes, change)
+			}
+			if err == nil {
+				err = r.Err()
 			}
 		}
 	}
-	return count == 1, err
+
+	return changes, total, err
 }
 
-func (p *posgreSQLPetStore) openConnection() (*sql.DB, error) {
+func petFromJson(bytes []byte) (*data.Pet, error) {
+	if bytes == nil {
+		return nil, nil
+	}
+	pet := data.Pet{}
+	if err := json.Unmarshal(bytes, &pet); err != nil {
+		return nil, err
+	}
+	return &pet, nil
+}
+
+func (p posgreSQLPetStore) WithContext(ctx context.Context) store.PetStore {
+	p.ctx = ctx
+	return &p
+}
+
+func (p posgreSQLPetStore) connectionString() string {
 	postgreSQLCfg := p.cfg.Store.Postgresql
-	connStr := fmt.Sprintf(connectionString,
+	return fmt.Sprintf(connectionString,
 		postgreSQLCfg.Host,
 		postgreSQLCfg.Port,
 		postgreSQLCfg.SSLMode,
@@ -196,7 +693,11 @@ func (p *posgreSQLPetStore) openConnection() (*sql.DB, error) {
 		postgreSQLCfg.User,
 		postgreSQLCfg.Password,
 	)
-	conn, err := p.open(postgreSQLCfg.Driver, connStr)
+}
+
+func (p *posgreSQLPetStore) openConnection() (*sql.DB, error) {
+	postgreSQLCfg := p.cfg.Store.Postgresql
+	conn, err := p.open(postgreSQLCfg.Driver, p.connectionString())
 	if err != nil && conn != nil {
 		conn.SetMaxOpenConns(postgreSQLCfg.Pool.MaxOpenConns)
 		conn.SetMaxIdleConns(postgreSQLCfg.Pool.MaxIdleConns)
@@ -209,34 +710,101 @@ func (p posgreSQLPetStore) checkConnection() error {
 	return p.db.Ping()
 }
 
-func (p posgreSQLPetStore) createTables() error {
-	_, err := p.exec(sqlCreateTable)
+// sqlMigration is a version of the schema with the statements that migrate to it from the previous one
+type sqlMigration struct {
+	version    int
+	statements []string
+}
+
+// schemaLock is the advisory lock taken while migrating, so the replicas that start together migrate one at a time
+const schemaLock = 0x736368656d61
+
+func (p posgreSQLPetStore) txSchemaVersion(tx *sql.Tx) (int, error) {
+	var version = 0
+	err := p.txQueryRow(tx, sqlGetSchemaVersion).Scan(&version)
+	if err == sql.ErrNoRows {
+		err = nil
+	}
+	return version, err
+}
+
+// txApplyMigration runs the statements of a migration and records its version
+func (p posgreSQLPetStore) txApplyMigration(tx *sql.Tx, migration sqlMigration) error {
+	for _, statement := range migration.statements {
+		if _, err := p.txExec(tx, statement); err != nil {
+			return err
+		}
+	}
+	_, err := p.txExec(tx, sqlRecordSchemaVersion, migration.version)
 	return err
 }
 
+// migrate applies the migrations newer than the version recorded in the database, leaving untouched a schema that a
+// newer release has migrated so its functions and triggers are not replaced by older ones
+func (p posgreSQLPetStore) migrate(migrations []sqlMigration) error {
+	return p.inTransaction(func(tx *sql.Tx) error {
+		var err error = nil
+		var version = 0
+
+		if _, err = p.txExec(tx, sqlLockSchema, schemaLock); err == nil {
+			if _, err = p.txExec(tx, sqlCreateSchemaVersionTable); err == nil {
+				version, err = p.txSchemaVersion(tx)
+			}
+		}
+		for i := 0; err == nil && i < len(migrations); i++ {
+			if migrations[i].version > version {
+				err = p.txApplyMigration(tx, migrations[i])
+			}
+		}
+		return err
+	})
+}
+
+func (p posgreSQLPetStore) createTables() error {
+	return p.migrate(sqlMigrations)
+}
+
+// SchemaVersion gets the version recorded in the database, that is never lowered by an older version of the service
+func (p posgreSQLPetStore) SchemaVersion() (int, int, error) {
+	var version = 0
+	err := p.queryRow(sqlGetSchemaVersion).Scan(&version)
+	return version, schemaVersion, err
+}
+
+func (p posgreSQLPetStore) PoolStats() store.PoolStats {
+	stats := p.db.Stats()
+	return store.PoolStats{
+		MaxOpen:   stats.MaxOpenConnections,
+		Open:      stats.OpenConnections,
+		InUse:     stats.InUse,
+		Idle:      stats.Idle,
+		WaitCount: stats.WaitCount,
+	}
+}
+
 func (p posgreSQLPetStore) exec(query string, args ...interface{}) (sql.Result, error) {
 	p.logger("SQL query:", query, args)
-	return p.db.Exec(query, args...)
+	return p.db.ExecContext(p.ctx, query, args...)
 }
 
 func (p posgreSQLPetStore) txExec(tx *sql.Tx, query string, args ...interface{}) (sql.Result, error) {
 	p.logger("SQL query:", query, args)
-	return tx.Exec(query, args...)
+	return tx.ExecContext(p.ctx, query, args...)
 }
 
 func (p posgreSQLPetStore) queryRow(query string, args ...interface{}) *sql.Row {
 	p.logger("SQL query:", query, args)
-	return p.db.QueryRow(query, args...)
+	return p.db.QueryRowContext(p.ctx, query, args...)
 }
 
 func (p posgreSQLPetStore) txQueryRow(tx *sql.Tx, query string, args ...interface{}) *sql.Row {
 	p.logger("SQL query:", query, args)
-	return tx.QueryRow(query, args...)
+	return tx.QueryRowContext(p.ctx, query, args...)
 }
 
 func (p posgreSQLPetStore) query(query string, args ...interface{}) (*sql.Rows, error) {
 	p.logger("SQL query:", query, args)
-	return p.db.Query(query, args...)
+	return p.db.QueryContext(p.ctx, query, args...)
 }
 
 func (p *posgreSQLPetStore) Open() error {
@@ -267,6 +835,7 @@ func NewPostgresSQLPetStore(cfg config.CfgData) store.PetStore {
 		db:     nil,
 		logger: log.Println,
 		open:   sql.Open,
+		ctx:    context.Background(),
 	}
 
 	if !cfg.Store.Postgresql.LogQueries {
@@ -26,12 +26,14 @@ import (
 	"context"
 	"fmt"
 	"github.com/LearningByExample/go-microservice/internal/app/data"
+	"github.com/LearningByExample/go-microservice/internal/app/reqctx"
 	"github.com/LearningByExample/go-microservice/internal/app/store"
 	"github.com/docker/go-connections/nat"
 	"github.com/testcontainers/testcontainers-go"
 	"github.com/testcontainers/testcontainers-go/wait"
 	"os"
 	"reflect"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -39,7 +41,7 @@ import (
 
 const (
 	postgreSQLFile         = "postgresql.json"
-	sqlResetDB             = "DROP TABLE PETS"
+	sqlResetDB             = "DROP TABLE IF EXISTS PET_TAGS, PETS, PET_HISTORY, OWNERS, WEBHOOK_DELIVERIES, WEBHOOKS, OUTBOX, API_KEYS, SCHEMA_VERSION"
 	integrationTestSkipped = "Integration test are skipped"
 )
 
@@ -439,3 +441,561 @@ func TestPosgreSQLPetStore_Concurrency(t *testing.T) {
 
 	wg.Wait()
 }
+
+func TestPosgreSQLPetStore_ImportPets(t *testing.T) {
+	if testing.Short() {
+		t.Skip(integrationTestSkipped)
+	}
+
+	ps := getIntegrationPetStore(t)
+	resetDB(t)
+	_ = ps.Open()
+	//noinspection GoUnhandledErrorResult
+	defer ps.Close()
+
+	err := ps.ImportPets([]data.Pet{
+		{Name: "Fluff", Race: "dog", Mod: "happy"},
+		{Name: "Lion", Race: "cat", Mod: "brave"},
+	})
+	if err != nil {
+		t.Fatalf("error on import pets got %v, want nil", err)
+	}
+
+	got := make([]string, 0)
+	err = ps.ForEachPet(func(pet data.Pet) error {
+		got = append(got, pet.Name)
+		return nil
+	})
+	want := []string{"Fluff", "Lion"}
+
+	if err != nil {
+		t.Fatalf("error on for each pet got %v, want nil", err)
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("error importing pets got %v, want %v", got, want)
+	}
+
+	owner, _ := ps.AddOwner("John", "john@example.com")
+	created := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	imported := []data.Pet{{Name: "Snowflake", Race: "mouse", Mod: "nervous", Status: data.Adopted, OwnerId: &owner,
+		Tags: []string{"small", "calm"}, Attributes: map[string]string{"color": "white"}, CreatedAt: created}}
+	if err = ps.ImportPets(imported); err != nil {
+		t.Fatalf("error on import pets got %v, want nil", err)
+	}
+	pet, _ := ps.GetPet(imported[0].Id)
+	if pet.Status != data.Adopted || pet.Owner() != owner || !reflect.DeepEqual(pet.Tags, []string{"calm", "small"}) ||
+		pet.Attributes["color"] != "white" || !pet.CreatedAt.Equal(created) || !pet.UpdatedAt.Equal(created) {
+		t.Fatalf("error importing pet fields got %v", pet)
+	}
+
+	invalid := owner + 1
+	err = ps.ImportPets([]data.Pet{{Name: "Bad", Race: "dog", Mod: "sad", OwnerId: &invalid}})
+	if err != store.InvalidOwner {
+		t.Fatalf("error on import pets got %v, want %v", err, store.InvalidOwner)
+	}
+}
+
+func TestPosgreSQLPetStore_BatchPets(t *testing.T) {
+	if testing.Short() {
+		t.Skip(integrationTestSkipped)
+	}
+
+	ps := getIntegrationPetStore(t)
+	resetDB(t)
+	_ = ps.Open()
+	//noinspection GoUnhandledErrorResult
+	defer ps.Close()
+
+	idDog, _ := ps.AddPet("Fluff", "dog", "happy")
+
+	t.Run("should rollback atomic batch", func(t *testing.T) {
+		_, err := ps.BatchPets([]store.PetOperation{
+			{Type: store.DeleteOperation, Pet: data.Pet{Id: idDog}},
+			{Type: store.DeleteOperation, Pet: data.Pet{Id: idDog + 100}},
+		}, true)
+
+		if err != nil {
+			t.Fatalf("error on batch got %v, want nil", err)
+		}
+		if _, err = ps.GetPet(idDog); err != nil {
+			t.Fatalf("error getting pet got %v, want nil", err)
+		}
+	})
+
+	t.Run("should apply independent batch", func(t *testing.T) {
+		got, err := ps.BatchPets([]store.PetOperation{
+			{Type: store.DeleteOperation, Pet: data.Pet{Id: idDog + 100}},
+			{Type: store.DeleteOperation, Pet: data.Pet{Id: idDog}},
+		}, false)
+
+		if err != nil {
+			t.Fatalf("error on batch got %v, want nil", err)
+		}
+		if got[0].Err != store.PetNotFound || got[1].Err != nil {
+			t.Fatalf("error on batch results got %v", got)
+		}
+		if _, err = ps.GetPet(idDog); err != store.PetNotFound {
+			t.Fatalf("error getting pet got %v, want %v", err, store.PetNotFound)
+		}
+	})
+}
+
+func TestPosgreSQLPetStore_SoftDelete(t *testing.T) {
+	if testing.Short() {
+		t.Skip(integrationTestSkipped)
+	}
+
+	ps := getIntegrationPetStore(t)
+	resetDB(t)
+	_ = ps.Open()
+	//noinspection GoUnhandledErrorResult
+	defer ps.Close()
+
+	id, _ := ps.AddPet("Fluff", "dog", "happy")
+	_ = ps.DeletePet(id)
+
+	if _, err := ps.GetPet(id); err != store.PetNotFound {
+		t.Fatalf("error getting deleted pet got %v, want %v", err, store.PetNotFound)
+	}
+
+	got, err := ps.FindPets(store.PetQuery{Ids: []int{id}, IncludeDeleted: true})
+	if err != nil || len(got) != 1 || !got[0].IsDeleted() {
+		t.Fatalf("error finding deleted pet got %v, %v", got, err)
+	}
+
+	if err = ps.RestorePet(id); err != nil {
+		t.Fatalf("error restoring pet got %v, want nil", err)
+	}
+	if _, err = ps.GetPet(id); err != nil {
+		t.Fatalf("error getting restored pet got %v, want nil", err)
+	}
+
+	_ = ps.DeletePet(id)
+	ids, err := ps.PurgePets(time.Now().Add(time.Hour))
+	if err != nil || len(ids) != 1 || ids[0] != id {
+		t.Fatalf("error purging pets got %v, %v, want [%d], nil", ids, err, id)
+	}
+	if err = ps.RestorePet(id); err != store.PetNotFound {
+		t.Fatalf("error restoring purged pet got %v, want %v", err, store.PetNotFound)
+	}
+}
+
+func TestPosgreSQLPetStore_PetHistory(t *testing.T) {
+	if testing.Short() {
+		t.Skip(integrationTestSkipped)
+	}
+
+	ps := getIntegrationPetStore(t)
+	resetDB(t)
+	_ = ps.Open()
+	//noinspection GoUnhandledErrorResult
+	defer ps.Close()
+
+	cs := ps.WithContext(reqctx.WithRequestId(reqctx.WithActor(context.Background(), "john"), "abc"))
+	id, _ := cs.AddPet("Fluff", "dog", "happy")
+	_, _ = cs.UpdatePet(id, "Fluffy", "dog", "happy")
+	_ = cs.DeletePet(id)
+	_ = cs.RestorePet(id)
+	_ = cs.ImportPets([]data.Pet{{Name: "Lion", Race: "cat", Mod: "brave"}})
+
+	got, total, err := ps.PetHistory(id, 0, 10)
+	if err != nil || total != 4 || len(got) != 4 {
+		t.Fatalf("error getting history got %v, %d, %v", got, total, err)
+	}
+	wantActions := []data.PetAction{data.CreateAction, data.UpdateAction, data.DeleteAction, data.RestoreAction}
+	for i, change := range got {
+		if change.Action != wantActions[i] || change.Actor != "john" || change.RequestId != "abc" {
+			t.Fatalf("error in change %d got %v", i, change)
+		}
+	}
+	if got[1].Before.Name != "Fluff" || got[1].After.Name != "Fluffy" {
+		t.Fatalf("error in update change got %v", got[1])
+	}
+	if !got[2].After.IsDeleted() || got[3].After.IsDeleted() {
+		t.Fatalf("error in delete and restore changes got %v", got[2:])
+	}
+
+	page, _, _ := ps.PetHistory(id, 3, 10)
+	if len(page) != 1 || page[0].Action != data.RestoreAction {
+		t.Fatalf("error paginating history got %v", page)
+	}
+
+	pets, _ := ps.GetAllPets()
+	imported, total, err := ps.PetHistory(pets[len(pets)-1].Id, 0, 10)
+	if err != nil || total != 1 || imported[0].After.Name != "Lion" {
+		t.Fatalf("error getting imported pet history got %v, %v", imported, err)
+	}
+
+	if _, _, err = ps.PetHistory(id+100, 0, 10); err != store.PetNotFound {
+		t.Fatalf("error getting unknown pet history got %v, want %v", err, store.PetNotFound)
+	}
+
+	_ = cs.DeletePet(id)
+	_, _ = ps.PurgePets(time.Now().Add(time.Hour))
+	if purged, total, err := ps.PetHistory(id, 0, 10); err != nil || total != 5 || purged[4].Action != data.DeleteAction {
+		t.Fatalf("error getting purged pet history got %v, %d, %v", purged, total, err)
+	}
+}
+
+func TestPosgreSQLPetStore_Owners(t *testing.T) {
+	if testing.Short() {
+		t.Skip(integrationTestSkipped)
+	}
+
+	ps := getIntegrationPetStore(t)
+	resetDB(t)
+	_ = ps.Open()
+	//noinspection GoUnhandledErrorResult
+	defer ps.Close()
+
+	owner, _ := ps.AddOwner("John", "john@example.com")
+	other, _ := ps.AddOwner("Jane", "jane@example.com")
+	id, _ := ps.AddPet("Fluff", "dog", "happy")
+
+	if change, err := ps.UpdateOwner(owner, "John", "john@example.org"); !change || err != nil {
+		t.Fatalf("error updating owner got %t, %v", change, err)
+	}
+	if change, err := ps.SetPetOwner(id, owner); !change || err != nil {
+		t.Fatalf("error setting pet owner got %t, %v", change, err)
+	}
+	if _, err := ps.SetPetOwner(id, other+100); err != store.InvalidOwner {
+		t.Fatalf("error setting invalid owner got %v, want %v", err, store.InvalidOwner)
+	}
+
+	pets, err := ps.FindPets(store.PetQuery{OwnerId: owner})
+	if err != nil || len(pets) != 1 || pets[0].Owner() != owner {
+		t.Fatalf("error finding owner pets got %v, %v", pets, err)
+	}
+
+	if err = ps.DeleteOwner(owner, 0); err != store.OwnerHasPets {
+		t.Fatalf("error deleting owner with pets got %v, want %v", err, store.OwnerHasPets)
+	}
+	if err = ps.DeleteOwner(owner, other); err != nil {
+		t.Fatalf("error reassigning owner pets got %v", err)
+	}
+	if pet, _ := ps.GetPet(id); pet.Owner() != other {
+		t.Fatalf("error reassigning pet got owner %d, want %d", pet.Owner(), other)
+	}
+	if _, err = ps.GetOwner(owner); err != store.OwnerNotFound {
+		t.Fatalf("error getting deleted owner got %v, want %v", err, store.OwnerNotFound)
+	}
+	if owners, _ := ps.GetAllOwners(); len(owners) != 1 || owners[0].Id != other {
+		t.Fatalf("error getting all owners got %v", owners)
+	}
+	if owners, err := ps.GetOwners([]int{owner, other}); err != nil || len(owners) != 1 || owners[0].Id != other {
+		t.Fatalf("error getting owners by id got %v, %v", owners, err)
+	}
+}
+
+func TestPosgreSQLPetStore_TransitionPet(t *testing.T) {
+	if testing.Short() {
+		t.Skip(integrationTestSkipped)
+	}
+
+	ps := getIntegrationPetStore(t)
+	resetDB(t)
+	_ = ps.Open()
+	//noinspection GoUnhandledErrorResult
+	defer ps.Close()
+
+	id, _ := ps.AddPet("Fluff", "dog", "happy")
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	reserved := 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := ps.TransitionPet(id, data.Reserved); err == nil {
+				mu.Lock()
+				reserved++
+				mu.Unlock()
+			} else if err != store.IllegalStatus {
+				t.Errorf("error reserving pet got %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if reserved != 1 {
+		t.Fatalf("error reserving pet got %d reservations, want 1", reserved)
+	}
+
+	got, err := ps.TransitionPet(id, data.Adopted)
+	if err != nil || got.Status != data.Adopted {
+		t.Fatalf("error adopting pet got %v, %v", got, err)
+	}
+	if _, err = ps.TransitionPet(id, data.Reserved); err != store.IllegalStatus {
+		t.Fatalf("error reserving adopted pet got %v, want %v", err, store.IllegalStatus)
+	}
+	if pet, _ := ps.GetPet(id); pet.Status != data.Adopted {
+		t.Fatalf("error getting pet status got %q, want %q", pet.Status, data.Adopted)
+	}
+}
+
+func TestPosgreSQLPetStore_SearchPets(t *testing.T) {
+	if testing.Short() {
+		t.Skip(integrationTestSkipped)
+	}
+
+	ps := getIntegrationPetStore(t)
+	resetDB(t)
+	_ = ps.Open()
+	//noinspection GoUnhandledErrorResult
+	defer ps.Close()
+
+	fluffy, _ := ps.AddPet("Fluffy", "dog", "happy")
+	jose, _ := ps.AddPet("José", "Dog", "brave")
+	lion, _ := ps.AddPet("Lion", "cat", "happy dog")
+	_ = ps.DeletePet(lion)
+
+	matches, total, err := ps.SearchPets("DOG", 0, 10)
+	if err != nil || total != 2 || len(matches) != 2 {
+		t.Fatalf("error searching pets got %v, %d, %v", matches, total, err)
+	}
+
+	matches, _, _ = ps.SearchPets("jose", 0, 10)
+	want := store.HighlightStart + "José" + store.HighlightStop
+	if len(matches) != 1 || matches[0].Pet.Id != jose || matches[0].Highlights["name"] != want {
+		t.Fatalf("error searching pets got %v, want highlight %q", matches, want)
+	}
+
+	_, _ = ps.AddPet("<b>Toby</b>", "<mark>bird", "calm")
+	matches, _, _ = ps.SearchPets("toby", 0, 10)
+	want = "&lt;b&gt;" + store.HighlightStart + "Toby" + store.HighlightStop + "&lt;/b&gt;"
+	if len(matches) != 1 || len(matches[0].Highlights) != 1 || matches[0].Highlights["name"] != want {
+		t.Fatalf("error searching pets got %v, want escaped highlight %q", matches, want)
+	}
+
+	_, _ = ps.UpdatePet(fluffy, "Rex", "dog", "happy")
+	if matches, total, _ = ps.SearchPets("fluffy", 0, 10); total != 0 {
+		t.Fatalf("error searching updated pet got %v", matches)
+	}
+	if matches, total, _ = ps.SearchPets("rex happy", 0, 10); total != 1 || matches[0].Pet.Id != fluffy {
+		t.Fatalf("error searching updated pet got %v", matches)
+	}
+}
+
+func TestPosgreSQLPetStore_SetPetTags(t *testing.T) {
+	if testing.Short() {
+		t.Skip(integrationTestSkipped)
+	}
+
+	ps := getIntegrationPetStore(t)
+	resetDB(t)
+	_ = ps.Open()
+	//noinspection GoUnhandledErrorResult
+	defer ps.Close()
+
+	fluffy, _ := ps.AddPet("Fluffy", "dog", "happy")
+	lion, _ := ps.AddPet("Lion", "cat", "brave")
+	black := map[string]string{"color": "black"}
+
+	if change, err := ps.SetPetTags(fluffy, []string{"small", "friendly"}, black); !change || err != nil {
+		t.Fatalf("error setting pet tags got %t, %v", change, err)
+	}
+	if change, err := ps.SetPetTags(fluffy, []string{"friendly", "small"}, black); change || err != nil {
+		t.Fatalf("error setting same pet tags got %t, %v", change, err)
+	}
+	_, _ = ps.SetPetTags(lion, []string{"friendly"}, map[string]string{"color": "white"})
+
+	if pet, _ := ps.GetPet(fluffy); !pet.SameTags([]string{"friendly", "small"}, black) {
+		t.Fatalf("error getting pet tags got %v, %v", pet.Tags, pet.Attributes)
+	}
+	if pets, _ := ps.FindPets(store.PetQuery{Tags: []string{"friendly"}}); len(pets) != 2 {
+		t.Fatalf("error finding pets by tag got %v", pets)
+	}
+	pets, _ := ps.FindPets(store.PetQuery{Tags: []string{"friendly"}, Attributes: black})
+	if len(pets) != 1 || pets[0].Id != fluffy {
+		t.Fatalf("error finding pets by attribute got %v", pets)
+	}
+}
+
+func TestPosgreSQLPetStore_ListenEvents(t *testing.T) {
+	if testing.Short() {
+		t.Skip(integrationTestSkipped)
+	}
+
+	ps := getIntegrationPetStore(t)
+	_ = ps.Open()
+	//noinspection GoUnhandledErrorResult
+	defer ps.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	payloads := make(chan string, 1)
+	done := make(chan error)
+	go func() {
+		done <- ps.ListenEvents(ctx, func(payload string) {
+			select {
+			case payloads <- payload:
+			default:
+			}
+		})
+	}()
+
+	var got string
+	for got == "" {
+		if err := ps.NotifyEvent("event"); err != nil {
+			t.Fatalf("error notifying event got %v", err)
+		}
+		select {
+		case got = <-payloads:
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	cancel()
+
+	if got != "event" {
+		t.Fatalf("error listening events got %q, want %q", got, "event")
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("error listening events got %v", err)
+	}
+}
+
+func TestPosgreSQLPetStore_Webhooks(t *testing.T) {
+	if testing.Short() {
+		t.Skip(integrationTestSkipped)
+	}
+
+	ps := getIntegrationPetStore(t)
+	resetDB(t)
+	_ = ps.Open()
+	//noinspection GoUnhandledErrorResult
+	defer ps.Close()
+
+	id, _ := ps.AddWebhook("http://example.com/hook", []string{"created", "deleted"}, "secret", true)
+	other, _ := ps.AddWebhook("http://example.com/other", []string{"updated"}, "secret", true)
+
+	if change, err := ps.UpdateWebhook(other, "http://example.com/other", []string{"created"}, "secret", true); !change || err != nil {
+		t.Fatalf("error updating webhook got %t, %v", change, err)
+	}
+	if change, err := ps.UpdateWebhook(other, "http://example.com/other", []string{"created"}, "secret", true); change || err != nil {
+		t.Fatalf("error updating webhook without changes got %t, %v", change, err)
+	}
+	if _, err := ps.UpdateWebhook(other+100, "http://example.com", []string{"created"}, "secret", true); err != store.WebhookNotFound {
+		t.Fatalf("error updating missing webhook got %v, want %v", err, store.WebhookNotFound)
+	}
+
+	if count, err := ps.EnqueueDeliveries("created", `{"petId":1}`); count != 2 || err != nil {
+		t.Fatalf("error enqueuing deliveries got %d, %v", count, err)
+	}
+	claimed, err := ps.ClaimDeliveries(time.Now(), time.Minute, 10)
+	if err != nil || len(claimed) != 2 || claimed[0].WebhookId != id || claimed[0].Payload != `{"petId":1}` {
+		t.Fatalf("error claiming deliveries got %v, %v", claimed, err)
+	}
+	if again, err := ps.ClaimDeliveries(time.Now(), time.Minute, 10); len(again) != 0 || err != nil {
+		t.Fatalf("error claiming deliveries twice got %v, %v", again, err)
+	}
+
+	attempt := data.DeliveryAttempt{At: time.Now().UTC().Truncate(time.Second), StatusCode: 500}
+	if failures, err := ps.RecordDeliveryAttempt(claimed[0].Id, attempt, data.DeliveryPending, time.Now()); failures != 1 || err != nil {
+		t.Fatalf("error recording attempt got %d, %v", failures, err)
+	}
+	deliveries, total, err := ps.WebhookDeliveries(id, 0, 10)
+	if err != nil || total != 1 || len(deliveries[0].Attempts) != 1 || !deliveries[0].Attempts[0].At.Equal(attempt.At) {
+		t.Fatalf("error getting deliveries got %v, %d, %v", deliveries, total, err)
+	}
+
+	if err = ps.DeleteWebhook(id); err != nil {
+		t.Fatalf("error deleting webhook got %v", err)
+	}
+	if _, _, err = ps.WebhookDeliveries(id, 0, 10); err != store.WebhookNotFound {
+		t.Fatalf("error getting deleted webhook deliveries got %v, want %v", err, store.WebhookNotFound)
+	}
+	if webhooks, err := ps.GetAllWebhooks(); err != nil || len(webhooks) != 1 || webhooks[0].Id != other {
+		t.Fatalf("error getting webhooks got %v, %v", webhooks, err)
+	}
+
+	if err = ps.DisableWebhook(other); err != nil {
+		t.Fatalf("error disabling webhook got %v", err)
+	}
+	if webhook, err := ps.GetWebhook(other); err != nil || webhook.Enabled || webhook.Url != "http://example.com/other" {
+		t.Fatalf("error getting disabled webhook got %v, %v", webhook, err)
+	}
+	disabled, _ := ps.AddWebhook("http://example.com/disabled", []string{"created"}, "secret", false)
+	if webhook, err := ps.GetWebhook(disabled); err != nil || webhook.Enabled {
+		t.Fatalf("error adding disabled webhook got %v, %v", webhook, err)
+	}
+}
+
+func TestPosgreSQLPetStore_ApiKeys(t *testing.T) {
+	if testing.Short() {
+		t.Skip(integrationTestSkipped)
+	}
+
+	ps := getIntegrationPetStore(t)
+	resetDB(t)
+	_ = ps.Open()
+	//noinspection GoUnhandledErrorResult
+	defer ps.Close()
+
+	scopes := []string{data.ScopePetsRead, data.ScopePetsWrite}
+	id, err := ps.AddApiKey("ci", "pk_1234", strings.Repeat("a", 64), scopes)
+	if err != nil {
+		t.Fatalf("error adding api key got %v", err)
+	}
+	if key, err := ps.FindApiKey(strings.Repeat("a", 64)); err != nil || key.Id != id || !reflect.DeepEqual(key.Scopes, scopes) {
+		t.Fatalf("error finding api key got %v, %v", key, err)
+	}
+
+	if err = ps.RotateApiKey(id, "pk_5678", strings.Repeat("b", 64)); err != nil {
+		t.Fatalf("error rotating api key got %v", err)
+	}
+	if _, err = ps.FindApiKey(strings.Repeat("a", 64)); err != store.ApiKeyNotFound {
+		t.Fatalf("error finding rotated api key got %v, want %v", err, store.ApiKeyNotFound)
+	}
+	if key, err := ps.GetApiKey(id); err != nil || key.Prefix != "pk_5678" || key.RotatedAt == nil {
+		t.Fatalf("error getting rotated api key got %v, %v", key, err)
+	}
+
+	if err = ps.RevokeApiKey(id); err != nil {
+		t.Fatalf("error revoking api key got %v", err)
+	}
+	if err = ps.RevokeApiKey(id); err != nil {
+		t.Fatalf("error revoking api key twice got %v", err)
+	}
+	if _, err = ps.FindApiKey(strings.Repeat("b", 64)); err != store.ApiKeyNotFound {
+		t.Fatalf("error finding revoked api key got %v, want %v", err, store.ApiKeyNotFound)
+	}
+	if err = ps.RotateApiKey(id, "pk_9012", strings.Repeat("c", 64)); err != store.ApiKeyNotFound {
+		t.Fatalf("error rotating revoked api key got %v, want %v", err, store.ApiKeyNotFound)
+	}
+	if keys, err := ps.GetAllApiKeys(); err != nil || len(keys) != 1 || !keys[0].IsRevoked() {
+		t.Fatalf("error getting api keys got %v, %v", keys, err)
+	}
+}
+
+func TestPosgreSQLPetStore_Outbox(t *testing.T) {
+	if testing.Short() {
+		t.Skip(integrationTestSkipped)
+	}
+
+	ps := getIntegrationPetStore(t)
+	resetDB(t)
+	_ = ps.Open()
+	//noinspection GoUnhandledErrorResult
+	defer ps.Close()
+
+	id, _ := ps.AddPet("Fluff", "dog", "happy")
+	_, _ = ps.UpdatePet(id, "Fluff", "dog", "sad")
+	_ = ps.DeletePet(id)
+	_ = ps.ImportPets([]data.Pet{{Name: "Lion", Race: "cat", Mod: "brave"}})
+
+	got := make([]data.PetAction, 0)
+	count, err := ps.RelayOutbox(10, func(event data.OutboxEvent) error {
+		got = append(got, event.Type)
+		return nil
+	})
+	want := []data.PetAction{data.CreateAction, data.UpdateAction, data.DeleteAction, data.CreateAction}
+	if count != 4 || err != nil || !reflect.DeepEqual(got, want) {
+		t.Fatalf("error relaying outbox got %d, %v, %v, want %v", count, err, got, want)
+	}
+
+	if count, err = ps.RelayOutbox(10, func(event data.OutboxEvent) error {
+		return nil
+	}); count != 0 || err != nil {
+		t.Fatalf("error relaying published outbox got %d, %v", count, err)
+	}
+}
@@ -23,35 +23,70 @@
 package psqlstore
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
 	"errors"
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/LearningByExample/go-microservice/internal/app/config"
 	"github.com/LearningByExample/go-microservice/internal/app/data"
+	"github.com/LearningByExample/go-microservice/internal/app/reqctx"
 	"github.com/LearningByExample/go-microservice/internal/app/store"
 	"log"
 	"path/filepath"
 	"reflect"
 	"testing"
+	"time"
 )
 
 const (
 	testDataFolder              = "testdata"
 	postgreSQLFileWithoutLogger = "postgresql-no-logger.json"
 	postgreSQLBadFile           = "postgresql-bad.json"
-	sqlInsert                   = "INSERT INTO pets .* RETURNING id;"
+	sqlInsert                   = "INSERT INTO pets .* RETURNING id, created_at, updated_at;"
 	sqlSelect                   = "SELECT .* FROM pets WHERE .*"
-	sqlSelectAll                = "SELECT .* FROM pets ORDER BY .*"
-	sqlDelete                   = "DELETE FROM pets WHERE .*"
+	sqlSelectAll                = "SELECT .* FROM pets WHERE deleted_at IS NULL ORDER BY .*"
+	sqlDelete                   = "UPDATE pets SET deleted_at = now\\(\\) WHERE .*"
+	sqlRestore                  = "UPDATE pets SET deleted_at = NULL WHERE .*"
+	sqlTransition               = "UPDATE pets SET status = \\$3 WHERE .*"
+	sqlPurge                    = "DELETE FROM pets WHERE deleted_at < .*"
+	sqlFind                     = "SELECT .* FROM pets .*ORDER BY .*"
 	sqlUpdate                   = "UPDATE pets .*"
-	mockSqlCreateTable          = "CREATE TABLE .*"
+	sqlCopy                     = "COPY \"pets\" .* FROM STDIN"
+	sqlCopyHistory              = "COPY \"pet_history\" .* FROM STDIN"
+	sqlCopyTagsMock             = "COPY \"pet_tags\" .* FROM STDIN"
+	sqlNextIds                  = "SELECT nextval.*"
+	sqlForUpdate                = "SELECT .* FROM pets WHERE id = \\$1 FOR UPDATE;"
+	sqlInsertHistory            = "INSERT INTO pet_history .*"
+	sqlCountHistory             = "SELECT count\\(\\*\\) FROM pet_history .*"
+	sqlSelectHistory            = "SELECT .* FROM pet_history .*"
+	sqlSavepointMock            = "SAVEPOINT batch_operation"
+	sqlReleaseMock              = "RELEASE SAVEPOINT batch_operation"
+	sqlRollbackToMock           = "ROLLBACK TO SAVEPOINT batch_operation"
+	sqlRecordSchemaVersionMock  = "INSERT INTO schema_version .*"
+	sqlGetSchemaVersionMock     = "SELECT version FROM schema_version WHERE id = 1;"
+	sqlLockSchemaMock           = "SELECT pg_advisory_xact_lock\\(\\$1\\);"
+	sqlCreateSchemaVersionMock  = "CREATE TABLE IF NOT EXISTS schema_version .*"
 	mockFile                    = "mock.json"
 )
 
 var (
-	mockErr = errors.New("an error has been produced")
+	mockErr        = errors.New("an error has been produced")
+	mockTime       = time.Date(2020, 4, 1, 0, 0, 0, 0, time.UTC)
+	mockAttributes = []byte("{}")
+	mockTags       = "{}"
+	petColumns     = []string{"id", "name", "race", "mod", "created_at", "updated_at", "deleted_at", "owner_id", "status", "attributes", "tags"}
 )
 
+func petForUpdateRows(mock sqlmock.Sqlmock, id int, deleted bool) *sqlmock.Rows {
+	var deletedAt interface{} = nil
+	if deleted {
+		deletedAt = time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+	}
+	return mock.NewRows(petColumns).
+		AddRow(id, "old name", "old race", "old mod", mockTime, mockTime, deletedAt, nil, "available", mockAttributes, mockTags)
+}
+
 func getPetStore(cfgFile string) *posgreSQLPetStore {
 	path := filepath.Join(testDataFolder, cfgFile)
 	cfg, _ := config.GetConfig(path)
@@ -165,7 +200,10 @@ func TestMockPosgreSQLPetStore_AddPet(t *testing.T) {
 			prepare: func(mock sqlmock.Sqlmock, tt testCase) {
 				mock.ExpectBegin()
 				mock.ExpectQuery(sqlInsert).WithArgs("name", "race", "mod").
-					WillReturnRows(mock.NewRows([]string{"id"}).AddRow(tt.want))
+					WillReturnRows(mock.NewRows([]string{"id", "created_at", "updated_at"}).AddRow(tt.want, mockTime, mockTime))
+				mock.ExpectExec(sqlInsertHistory).
+					WithArgs(tt.want, "create", "anonymous", "", nil, sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(1, 1))
 				mock.ExpectCommit()
 			},
 			want: 1,
@@ -194,7 +232,8 @@ func TestMockPosgreSQLPetStore_AddPet(t *testing.T) {
 			prepare: func(mock sqlmock.Sqlmock, tt testCase) {
 				mock.ExpectBegin()
 				mock.ExpectQuery(sqlInsert).WithArgs("name", "race", "mod").
-					WillReturnRows(mock.NewRows([]string{"id"}).AddRow(12))
+					WillReturnRows(mock.NewRows([]string{"id", "created_at", "updated_at"}).AddRow(12, mockTime, mockTime))
+				mock.ExpectExec(sqlInsertHistory).WillReturnResult(sqlmock.NewResult(1, 1))
 				mock.ExpectCommit().WillReturnError(tt.err)
 			},
 			want: 0,
@@ -211,6 +250,18 @@ func TestMockPosgreSQLPetStore_AddPet(t *testing.T) {
 			want: 0,
 			err:  mockErr,
 		},
+		{
+			name: "should rollback on history error",
+			prepare: func(mock sqlmock.Sqlmock, tt testCase) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(sqlInsert).WithArgs("name", "race", "mod").
+					WillReturnRows(mock.NewRows([]string{"id", "created_at", "updated_at"}).AddRow(12, mockTime, mockTime))
+				mock.ExpectExec(sqlInsertHistory).WillReturnError(tt.err)
+				mock.ExpectRollback()
+			},
+			want: 0,
+			err:  mockErr,
+		},
 	}
 
 	for _, tt := range cases {
@@ -248,14 +299,17 @@ func TestMockPosgreSQLPetStore_GetPet(t *testing.T) {
 			name: "should get row",
 			prepare: func(mock sqlmock.Sqlmock, tt testCase) {
 				var id int64 = 1
-				rows := mock.NewRows([]string{"id", "name", "race", "mod"}).AddRow(id, tt.want.Name, tt.want.Race, tt.want.Mod)
+				rows := mock.NewRows(petColumns).AddRow(id, tt.want.Name, tt.want.Race, tt.want.Mod, mockTime, mockTime, nil, nil, "available", mockAttributes, mockTags)
 				mock.ExpectQuery(sqlSelect).WithArgs(1).WillReturnRows(rows)
 			},
 			want: data.Pet{
-				Id:   1,
-				Name: "fuffly",
-				Race: "dog",
-				Mod:  "happy",
+				Id:        1,
+				Name:      "fuffly",
+				Race:      "dog",
+				Mod:       "happy",
+				Status:    data.Available,
+				CreatedAt: mockTime,
+				UpdatedAt: mockTime,
 			},
 			err: nil,
 		},
@@ -311,30 +365,39 @@ func TestMockPosgreSQLPetStore_GetAllPets(t *testing.T) {
 		{
 			name: "should get rows",
 			prepare: func(mock sqlmock.Sqlmock, tt testCase) {
-				rows := mock.NewRows([]string{"id", "name", "race", "mod"})
+				rows := mock.NewRows(petColumns)
 				for _, pet := range tt.want {
-					rows.AddRow(pet.Id, pet.Name, pet.Race, pet.Mod)
+					rows.AddRow(pet.Id, pet.Name, pet.Race, pet.Mod, pet.CreatedAt, pet.UpdatedAt, nil, nil, pet.Status, mockAttributes, mockTags)
 				}
 				mock.ExpectQuery(sqlSelectAll).WillReturnRows(rows)
 			},
 			want: []data.Pet{
 				{
-					Id:   1,
-					Name: "name1",
-					Race: "race1",
-					Mod:  "mod1",
+					Id:        1,
+					Name:      "name1",
+					Race:      "race1",
+					Mod:       "mod1",
+					Status:    data.Available,
+					CreatedAt: mockTime,
+					UpdatedAt: mockTime,
 				},
 				{
-					Id:   2,
-					Name: "name2",
-					Race: "race2",
-					Mod:  "mod2",
+					Id:        2,
+					Name:      "name2",
+					Race:      "race2",
+					Mod:       "mod2",
+					Status:    data.Available,
+					CreatedAt: mockTime,
+					UpdatedAt: mockTime,
 				},
 				{
-					Id:   3,
-					Name: "name3",
-					Race: "race3",
-					Mod:  "mod3",
+					Id:        3,
+					Name:      "name3",
+					Race:      "race3",
+					Mod:       "mod3",
+					Status:    data.Available,
+					CreatedAt: mockTime,
+					UpdatedAt: mockTime,
 				},
 			},
 			err:         nil,
@@ -343,7 +406,7 @@ func TestMockPosgreSQLPetStore_GetAllPets(t *testing.T) {
 		{
 			name: "should get no rows",
 			prepare: func(mock sqlmock.Sqlmock, tt testCase) {
-				mock.ExpectQuery(sqlSelectAll).WillReturnRows(mock.NewRows([]string{"id", "name", "race", "mod"}))
+				mock.ExpectQuery(sqlSelectAll).WillReturnRows(mock.NewRows(petColumns))
 			},
 			want:        []data.Pet{},
 			err:         nil,
@@ -361,8 +424,8 @@ func TestMockPosgreSQLPetStore_GetAllPets(t *testing.T) {
 		{
 			name: "should error on scan error",
 			prepare: func(mock sqlmock.Sqlmock, tt testCase) {
-				rows := mock.NewRows([]string{"id", "name", "race", "mod"}).
-					AddRow("35pp", "name1", "race1", "mod1")
+				rows := mock.NewRows(petColumns).
+					AddRow("35pp", "name1", "race1", "mod1", mockTime, mockTime, nil, nil, "available", mockAttributes, mockTags)
 				mock.ExpectQuery(sqlSelectAll).WillReturnRows(rows)
 			},
 			want:        nil,
@@ -404,12 +467,19 @@ func TestMockPosgreSQLPetStore_DeletePet(t *testing.T) {
 		err     error
 	}
 
+	deletedAt := time.Date(2020, 5, 2, 0, 0, 0, 0, time.UTC)
+
 	var cases = []testCase{
 		{
 			name: "should delete",
 			prepare: func(mock sqlmock.Sqlmock, tt testCase) {
 				mock.ExpectBegin()
-				mock.ExpectExec(sqlDelete).WithArgs(1).WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectQuery(sqlForUpdate).WithArgs(1).WillReturnRows(petForUpdateRows(mock, 1, false))
+				mock.ExpectQuery(sqlDelete).WithArgs(1).
+					WillReturnRows(mock.NewRows([]string{"deleted_at", "updated_at"}).AddRow(deletedAt, deletedAt))
+				mock.ExpectExec(sqlInsertHistory).
+					WithArgs(1, "delete", "anonymous", "", sqlmock.AnyArg(), sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(1, 1))
 				mock.ExpectCommit()
 			},
 			err: nil,
@@ -418,7 +488,17 @@ func TestMockPosgreSQLPetStore_DeletePet(t *testing.T) {
 			name: "should not found when delete not existing pet",
 			prepare: func(mock sqlmock.Sqlmock, tt testCase) {
 				mock.ExpectBegin()
-				mock.ExpectExec(sqlDelete).WithArgs(1).WillReturnResult(sqlmock.NewResult(1, 0))
+				mock.ExpectQuery(sqlForUpdate).WithArgs(1).WillReturnError(sql.ErrNoRows)
+				mock.ExpectRollback()
+			},
+			err: store.PetNotFound,
+		},
+		{
+			name: "should not found when delete already deleted pet",
+			prepare: func(mock sqlmock.Sqlmock, tt testCase) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(sqlForUpdate).WithArgs(1).WillReturnRows(petForUpdateRows(mock, 1, true))
+				mock.ExpectRollback()
 			},
 			err: store.PetNotFound,
 		},
@@ -433,7 +513,9 @@ func TestMockPosgreSQLPetStore_DeletePet(t *testing.T) {
 			name: "should error on query error",
 			prepare: func(mock sqlmock.Sqlmock, tt testCase) {
 				mock.ExpectBegin()
-				mock.ExpectExec(sqlDelete).WithArgs(1).WillReturnError(tt.err)
+				mock.ExpectQuery(sqlForUpdate).WithArgs(1).WillReturnRows(petForUpdateRows(mock, 1, false))
+				mock.ExpectQuery(sqlDelete).WithArgs(1).WillReturnError(tt.err)
+				mock.ExpectRollback()
 			},
 			err: mockErr,
 		},
@@ -441,26 +523,22 @@ func TestMockPosgreSQLPetStore_DeletePet(t *testing.T) {
 			name: "should error on tx commit error",
 			prepare: func(mock sqlmock.Sqlmock, tt testCase) {
 				mock.ExpectBegin()
-				mock.ExpectExec(sqlDelete).WithArgs(1).WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectQuery(sqlForUpdate).WithArgs(1).WillReturnRows(petForUpdateRows(mock, 1, false))
+				mock.ExpectQuery(sqlDelete).WithArgs(1).
+					WillReturnRows(mock.NewRows([]string{"deleted_at", "updated_at"}).AddRow(deletedAt, deletedAt))
+				mock.ExpectExec(sqlInsertHistory).WillReturnResult(sqlmock.NewResult(1, 1))
 				mock.ExpectCommit().WillReturnError(tt.err)
-
-			},
-			err: mockErr,
-		},
-		{
-			name: "should error on rows affected error",
-			prepare: func(mock sqlmock.Sqlmock, tt testCase) {
-				mock.ExpectBegin()
-				mock.ExpectExec(sqlDelete).WithArgs(1).WillReturnResult(sqlmock.NewErrorResult(tt.err))
-				mock.ExpectRollback()
 			},
 			err: mockErr,
 		},
 		{
-			name: "should error on rows affected error and rollback error",
+			name: "should error on history error and rollback error",
 			prepare: func(mock sqlmock.Sqlmock, tt testCase) {
 				mock.ExpectBegin()
-				mock.ExpectExec(sqlDelete).WithArgs(1).WillReturnResult(sqlmock.NewErrorResult(mockErr))
+				mock.ExpectQuery(sqlForUpdate).WithArgs(1).WillReturnRows(petForUpdateRows(mock, 1, false))
+				mock.ExpectQuery(sqlDelete).WithArgs(1).
+					WillReturnRows(mock.NewRows([]string{"deleted_at", "updated_at"}).AddRow(deletedAt, deletedAt))
+				mock.ExpectExec(sqlInsertHistory).WillReturnError(tt.err)
 				mock.ExpectRollback().WillReturnError(errors.New("error in rollback"))
 			},
 			err: mockErr,
@@ -498,9 +576,13 @@ func TestMockPosgreSQLPetStore_UpdatePet(t *testing.T) {
 		{
 			name: "should update",
 			prepare: func(mock sqlmock.Sqlmock, tt testCase) {
-				mock.ExpectQuery(sqlSelect).WithArgs(5).WillReturnRows(mock.NewRows([]string{"id"}).AddRow(1))
 				mock.ExpectBegin()
-				mock.ExpectExec(sqlUpdate).WillReturnResult(sqlmock.NewResult(5, 1))
+				mock.ExpectQuery(sqlForUpdate).WithArgs(5).WillReturnRows(petForUpdateRows(mock, 5, false))
+				mock.ExpectQuery(sqlUpdate).WithArgs(5, "name", "race", "mod").
+					WillReturnRows(mock.NewRows([]string{"updated_at"}).AddRow(mockTime))
+				mock.ExpectExec(sqlInsertHistory).
+					WithArgs(5, "update", "anonymous", "", sqlmock.AnyArg(), sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(1, 1))
 				mock.ExpectCommit()
 			},
 			want:        true,
@@ -510,10 +592,10 @@ func TestMockPosgreSQLPetStore_UpdatePet(t *testing.T) {
 		{
 			name: "should not update when no changes",
 			prepare: func(mock sqlmock.Sqlmock, tt testCase) {
-				mock.ExpectQuery(sqlSelect).WithArgs(5).WillReturnRows(mock.NewRows([]string{"id"}).AddRow(1))
 				mock.ExpectBegin()
-				mock.ExpectExec(sqlUpdate).WillReturnResult(sqlmock.NewResult(5, 0))
-				mock.ExpectRollback()
+				mock.ExpectQuery(sqlForUpdate).WithArgs(5).WillReturnRows(petForUpdateRows(mock, 5, false))
+				mock.ExpectQuery(sqlUpdate).WillReturnRows(mock.NewRows([]string{"updated_at"}))
+				mock.ExpectCommit()
 			},
 			want:        false,
 			err:         nil,
@@ -522,51 +604,56 @@ func TestMockPosgreSQLPetStore_UpdatePet(t *testing.T) {
 		{
 			name: "should not found when pet does not exist",
 			prepare: func(mock sqlmock.Sqlmock, tt testCase) {
-				mock.ExpectQuery(sqlSelect).WithArgs(5).WillReturnError(sql.ErrNoRows)
+				mock.ExpectBegin()
+				mock.ExpectQuery(sqlForUpdate).WithArgs(5).WillReturnError(sql.ErrNoRows)
+				mock.ExpectRollback()
 			},
 			want:        false,
 			err:         store.PetNotFound,
 			externalErr: false,
 		},
 		{
-			name: "should error on verify pet error",
+			name: "should not found when pet is deleted",
 			prepare: func(mock sqlmock.Sqlmock, tt testCase) {
-				mock.ExpectQuery(sqlSelect).WithArgs(5).WillReturnError(tt.err)
+				mock.ExpectBegin()
+				mock.ExpectQuery(sqlForUpdate).WithArgs(5).WillReturnRows(petForUpdateRows(mock, 5, true))
+				mock.ExpectRollback()
 			},
 			want:        false,
-			err:         mockErr,
+			err:         store.PetNotFound,
 			externalErr: false,
 		},
 		{
-			name: "should error on query error",
+			name: "should error on verify pet error",
 			prepare: func(mock sqlmock.Sqlmock, tt testCase) {
-				mock.ExpectQuery(sqlSelect).WithArgs(5).WillReturnRows(mock.NewRows([]string{"id"}).AddRow(1))
 				mock.ExpectBegin()
-				mock.ExpectExec(sqlUpdate).WillReturnError(mockErr)
+				mock.ExpectQuery(sqlForUpdate).WithArgs(5).WillReturnError(tt.err)
+				mock.ExpectRollback()
 			},
 			want:        false,
 			err:         mockErr,
 			externalErr: false,
 		},
 		{
-			name: "should error on commit error",
+			name: "should error on query error",
 			prepare: func(mock sqlmock.Sqlmock, tt testCase) {
-				mock.ExpectQuery(sqlSelect).WithArgs(5).WillReturnRows(mock.NewRows([]string{"id"}).AddRow(1))
 				mock.ExpectBegin()
-				mock.ExpectExec(sqlUpdate).WillReturnResult(sqlmock.NewResult(5, 1))
-				mock.ExpectCommit().WillReturnError(mockErr)
+				mock.ExpectQuery(sqlForUpdate).WithArgs(5).WillReturnRows(petForUpdateRows(mock, 5, false))
+				mock.ExpectQuery(sqlUpdate).WillReturnError(mockErr)
+				mock.ExpectRollback()
 			},
 			want:        false,
 			err:         mockErr,
 			externalErr: false,
 		},
 		{
-			name: "should error on rows affected error",
+			name: "should error on commit error",
 			prepare: func(mock sqlmock.Sqlmock, tt testCase) {
-				mock.ExpectQuery(sqlSelect).WithArgs(5).WillReturnRows(mock.NewRows([]string{"id"}).AddRow(1))
 				mock.ExpectBegin()
-				mock.ExpectExec(sqlUpdate).WillReturnResult(sqlmock.NewErrorResult(tt.err))
-				mock.ExpectRollback()
+				mock.ExpectQuery(sqlForUpdate).WithArgs(5).WillReturnRows(petForUpdateRows(mock, 5, false))
+				mock.ExpectQuery(sqlUpdate).WillReturnRows(mock.NewRows([]string{"updated_at"}).AddRow(mockTime))
+				mock.ExpectExec(sqlInsertHistory).WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectCommit().WillReturnError(mockErr)
 			},
 			want:        false,
 			err:         mockErr,
@@ -594,45 +681,923 @@ func TestMockPosgreSQLPetStore_UpdatePet(t *testing.T) {
 	}
 }
 
-func TestPosgreSQLPetStore_Open(t *testing.T) {
-	t.Run("we should be able to open a connection", func(t *testing.T) {
-		ps := getPetStore(mockFile)
-		var mock sqlmock.Sqlmock
+func TestMockPosgreSQLPetStore_ForEachPet(t *testing.T) {
+	type testCase struct {
+		name    string
+		prepare func(mock sqlmock.Sqlmock, tt testCase)
+		fnErr   error
+		want    []int
+		err     error
+	}
 
-		ps.open = func(driverName, dataSourceName string) (db *sql.DB, err error) {
-			db, mock, err = sqlmock.New(sqlmock.MonitorPingsOption(true))
+	var cases = []testCase{
+		{
+			name: "should visit rows",
+			prepare: func(mock sqlmock.Sqlmock, tt testCase) {
+				rows := mock.NewRows(petColumns).
+					AddRow(1, "name1", "race1", "mod1", mockTime, mockTime, nil, nil, "available", mockAttributes, mockTags).
+					AddRow(2, "name2", "race2", "mod2", mockTime, mockTime, nil, nil, "available", mockAttributes, mockTags)
+				mock.ExpectQuery(sqlSelectAll).WillReturnRows(rows)
+			},
+			want: []int{1, 2},
+			err:  nil,
+		},
+		{
+			name: "should stop on callback error",
+			prepare: func(mock sqlmock.Sqlmock, tt testCase) {
+				rows := mock.NewRows(petColumns).
+					AddRow(1, "name1", "race1", "mod1", mockTime, mockTime, nil, nil, "available", mockAttributes, mockTags).
+					AddRow(2, "name2", "race2", "mod2", mockTime, mockTime, nil, nil, "available", mockAttributes, mockTags)
+				mock.ExpectQuery(sqlSelectAll).WillReturnRows(rows)
+			},
+			fnErr: mockErr,
+			want:  []int{1},
+			err:   mockErr,
+		},
+		{
+			name: "should error on query error",
+			prepare: func(mock sqlmock.Sqlmock, tt testCase) {
+				mock.ExpectQuery(sqlSelectAll).WillReturnError(tt.err)
+			},
+			want: []int{},
+			err:  mockErr,
+		},
+	}
 
-			if err == nil && mock != nil {
-				mock.ExpectPing()
-				mock.ExpectExec(mockSqlCreateTable).WillReturnResult(sqlmock.NewResult(0, 0))
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			ps, mock := initDBMock(t)
+			defer ps.Close()
+			tt.prepare(mock, tt)
+
+			got := make([]int, 0)
+			err := ps.ForEachPet(func(pet data.Pet) error {
+				got = append(got, pet.Id)
+				return tt.fnErr
+			})
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("error visiting pets, got %v, want %v", got, tt.want)
+			}
+			if err != tt.err {
+				t.Fatalf("error visiting pets, want %q, got %q", tt.err, err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatalf("there were unfulfilled expectations: %s", err)
 			}
+		})
+	}
+}
 
-			return
-		}
+func TestMockPosgreSQLPetStore_ImportPets(t *testing.T) {
+	owner := 3
+	created := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	pets := []data.Pet{
+		{Name: "name1", Race: "race1", Mod: "mod1"},
+		{Name: "name2", Race: "race2", Mod: "mod2", Status: data.Adopted, OwnerId: &owner, Tags: []string{"b", "a"},
+			Attributes: map[string]string{"color": "red"}, CreatedAt: created},
+	}
+	rows := [][]driver.Value{
+		{10, "name1", "race1", "mod1", "available", nil, "{}", mockTime, mockTime},
+		{11, "name2", "race2", "mod2", "adopted", 3, `{"color":"red"}`, created, created},
+	}
 
-		err := ps.Open()
+	type testCase struct {
+		name    string
+		prepare func(mock sqlmock.Sqlmock, tt testCase)
+		err     error
+	}
 
-		if err != nil {
-			t.Fatalf("expect no error got %q", err)
-		}
+	var cases = []testCase{
+		{
+			name: "should copy pets",
+			prepare: func(mock sqlmock.Sqlmock, tt testCase) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(sqlSelectOwnerMock).WithArgs(3).WillReturnRows(ownerRows(mock, 3))
+				mock.ExpectQuery(sqlNextIds).WithArgs(len(pets)).
+					WillReturnRows(mock.NewRows([]string{"nextval", "now"}).AddRow(10, mockTime).AddRow(11, mockTime))
+				prepared := mock.ExpectPrepare(sqlCopy)
+				for _, row := range rows {
+					prepared.ExpectExec().WithArgs(row...).WillReturnResult(sqlmock.NewResult(0, 1))
+				}
+				prepared.ExpectExec().WithArgs().WillReturnResult(sqlmock.NewResult(0, 0))
+				tags := mock.ExpectPrepare(sqlCopyTagsMock)
+				tags.ExpectExec().WithArgs(11, "a").WillReturnResult(sqlmock.NewResult(0, 1))
+				tags.ExpectExec().WithArgs(11, "b").WillReturnResult(sqlmock.NewResult(0, 1))
+				tags.ExpectExec().WithArgs().WillReturnResult(sqlmock.NewResult(0, 0))
+				history := mock.ExpectPrepare(sqlCopyHistory)
+				for i := range pets {
+					history.ExpectExec().WithArgs(10+i, "create", "anonymous", "", sqlmock.AnyArg()).
+						WillReturnResult(sqlmock.NewResult(0, 1))
+				}
+				history.ExpectExec().WithArgs().WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectCommit()
+			},
+			err: nil,
+		},
+		{
+			name: "should error on tx begin error",
+			prepare: func(mock sqlmock.Sqlmock, tt testCase) {
+				mock.ExpectBegin().WillReturnError(tt.err)
+			},
+			err: mockErr,
+		},
+		{
+			name: "should rollback on copy error",
+			prepare: func(mock sqlmock.Sqlmock, tt testCase) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(sqlSelectOwnerMock).WithArgs(3).WillReturnRows(ownerRows(mock, 3))
+				mock.ExpectQuery(sqlNextIds).WithArgs(len(pets)).
+					WillReturnRows(mock.NewRows([]string{"nextval", "now"}).AddRow(10, mockTime).AddRow(11, mockTime))
+				prepared := mock.ExpectPrepare(sqlCopy)
+				prepared.ExpectExec().WithArgs(rows[0]...).WillReturnError(tt.err)
+				mock.ExpectRollback()
+			},
+			err: mockErr,
+		},
+		{
+			name: "should rollback on prepare error",
+			prepare: func(mock sqlmock.Sqlmock, tt testCase) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(sqlSelectOwnerMock).WithArgs(3).WillReturnRows(ownerRows(mock, 3))
+				mock.ExpectQuery(sqlNextIds).WithArgs(len(pets)).
+					WillReturnRows(mock.NewRows([]string{"nextval", "now"}).AddRow(10, mockTime).AddRow(11, mockTime))
+				mock.ExpectPrepare(sqlCopy).WillReturnError(tt.err)
+				mock.ExpectRollback()
+			},
+			err: mockErr,
+		},
+		{
+			name: "should rollback on invalid owner",
+			prepare: func(mock sqlmock.Sqlmock, tt testCase) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(sqlSelectOwnerMock).WithArgs(3).WillReturnRows(ownerRows(mock))
+				mock.ExpectRollback()
+			},
+			err: store.InvalidOwner,
+		},
+		{
+			name: "should rollback on next ids error",
+			prepare: func(mock sqlmock.Sqlmock, tt testCase) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(sqlSelectOwnerMock).WithArgs(3).WillReturnRows(ownerRows(mock, 3))
+				mock.ExpectQuery(sqlNextIds).WithArgs(len(pets)).WillReturnError(tt.err)
+				mock.ExpectRollback()
+			},
+			err: mockErr,
+		},
+	}
 
-		if err := mock.ExpectationsWereMet(); err != nil {
-			t.Errorf("there were unfulfilled expectations: %s", err)
-		}
-	})
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			ps, mock := initDBMock(t)
+			defer ps.Close()
+			tt.prepare(mock, tt)
 
-	t.Run("we should not been able to open a connection", func(t *testing.T) {
-		mockError := errors.New("invalid connection")
-		ps := getPetStore(mockFile)
+			imported := append([]data.Pet{}, pets...)
+			err := ps.ImportPets(imported)
 
-		ps.open = func(driverName, dataSourceName string) (db *sql.DB, err error) {
-			return nil, mockError
-		}
+			if err != tt.err {
+				t.Fatalf("error importing pets, want %q, got %q", tt.err, err)
+			}
+			if err == nil && (imported[0].Id != 10 || imported[1].Id != 11) {
+				t.Fatalf("error importing pets, want ids 10 and 11, got %v", imported)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatalf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
 
-		err := ps.Open()
+func TestMockPosgreSQLPetStore_BatchPets(t *testing.T) {
+	ops := []store.PetOperation{
+		{Type: store.CreateOperation, Pet: data.Pet{Name: "name", Race: "race", Mod: "mod"}},
+		{Type: store.UpdateOperation, Pet: data.Pet{Id: 5, Name: "name", Race: "race", Mod: "mod"}},
+		{Type: store.DeleteOperation, Pet: data.Pet{Id: 6}},
+	}
 
-		if err != mockError {
-			t.Fatalf("invalid error, got %v, want %v", err, mockError)
-		}
-	})
+	type testCase struct {
+		name    string
+		atomic  bool
+		prepare func(mock sqlmock.Sqlmock, tt testCase)
+		want    []store.PetOperationResult
+		err     error
+	}
+
+	var cases = []testCase{
+		{
+			name:   "should commit atomic batch",
+			atomic: true,
+			prepare: func(mock sqlmock.Sqlmock, tt testCase) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(sqlInsert).WithArgs("name", "race", "mod").
+					WillReturnRows(mock.NewRows([]string{"id", "created_at", "updated_at"}).AddRow(7, mockTime, mockTime))
+				mock.ExpectExec(sqlInsertHistory).WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectQuery(sqlForUpdate).WithArgs(5).WillReturnRows(petForUpdateRows(mock, 5, false))
+				mock.ExpectQuery(sqlUpdate).WillReturnRows(mock.NewRows([]string{"updated_at"}).AddRow(mockTime))
+				mock.ExpectExec(sqlInsertHistory).WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectQuery(sqlForUpdate).WithArgs(6).WillReturnRows(petForUpdateRows(mock, 6, false))
+				mock.ExpectQuery(sqlDelete).WithArgs(6).
+					WillReturnRows(mock.NewRows([]string{"deleted_at", "updated_at"}).AddRow(mockTime, mockTime))
+				mock.ExpectExec(sqlInsertHistory).WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectCommit()
+			},
+			want: []store.PetOperationResult{
+				{Id: 7, Changed: true},
+				{Id: 5, Changed: true},
+				{Id: 6, Changed: true},
+			},
+			err: nil,
+		},
+		{
+			name:   "should rollback atomic batch",
+			atomic: true,
+			prepare: func(mock sqlmock.Sqlmock, tt testCase) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(sqlInsert).WithArgs("name", "race", "mod").
+					WillReturnRows(mock.NewRows([]string{"id", "created_at", "updated_at"}).AddRow(7, mockTime, mockTime))
+				mock.ExpectExec(sqlInsertHistory).WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectQuery(sqlForUpdate).WithArgs(5).WillReturnError(sql.ErrNoRows)
+				mock.ExpectRollback()
+			},
+			want: []store.PetOperationResult{
+				{},
+				{Id: 5, Err: store.PetNotFound},
+				{},
+			},
+			err: nil,
+		},
+		{
+			name:   "should use savepoints on independent batch",
+			atomic: false,
+			prepare: func(mock sqlmock.Sqlmock, tt testCase) {
+				mock.ExpectBegin()
+				mock.ExpectExec(sqlSavepointMock).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectQuery(sqlInsert).WithArgs("name", "race", "mod").
+					WillReturnRows(mock.NewRows([]string{"id", "created_at", "updated_at"}).AddRow(7, mockTime, mockTime))
+				mock.ExpectExec(sqlInsertHistory).WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec(sqlReleaseMock).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec(sqlSavepointMock).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectQuery(sqlForUpdate).WithArgs(5).WillReturnError(sql.ErrNoRows)
+				mock.ExpectExec(sqlRollbackToMock).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec(sqlSavepointMock).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectQuery(sqlForUpdate).WithArgs(6).WillReturnRows(petForUpdateRows(mock, 6, false))
+				mock.ExpectQuery(sqlDelete).WithArgs(6).
+					WillReturnRows(mock.NewRows([]string{"deleted_at", "updated_at"}).AddRow(mockTime, mockTime))
+				mock.ExpectExec(sqlInsertHistory).WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec(sqlReleaseMock).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectCommit()
+			},
+			want: []store.PetOperationResult{
+				{Id: 7, Changed: true},
+				{Id: 5, Err: store.PetNotFound},
+				{Id: 6, Changed: true},
+			},
+			err: nil,
+		},
+		{
+			name:   "should error on tx begin error",
+			atomic: true,
+			prepare: func(mock sqlmock.Sqlmock, tt testCase) {
+				mock.ExpectBegin().WillReturnError(tt.err)
+			},
+			want: []store.PetOperationResult{{}, {}, {}},
+			err:  mockErr,
+		},
+		{
+			name:   "should error on savepoint error",
+			atomic: false,
+			prepare: func(mock sqlmock.Sqlmock, tt testCase) {
+				mock.ExpectBegin()
+				mock.ExpectExec(sqlSavepointMock).WillReturnError(tt.err)
+				mock.ExpectRollback()
+			},
+			want: []store.PetOperationResult{{}, {}, {}},
+			err:  mockErr,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			ps, mock := initDBMock(t)
+			defer ps.Close()
+			tt.prepare(mock, tt)
+
+			got, err := ps.BatchPets(ops, tt.atomic)
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("error in batch, got %v, want %v", got, tt.want)
+			}
+			if err != tt.err {
+				t.Fatalf("error in batch, want %q, got %q", tt.err, err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatalf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+func TestMockPosgreSQLPetStore_RestorePet(t *testing.T) {
+	type testCase struct {
+		name    string
+		prepare func(mock sqlmock.Sqlmock, tt testCase)
+		err     error
+	}
+
+	var cases = []testCase{
+		{
+			name: "should restore",
+			prepare: func(mock sqlmock.Sqlmock, tt testCase) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(sqlForUpdate).WithArgs(1).WillReturnRows(petForUpdateRows(mock, 1, true))
+				mock.ExpectQuery(sqlRestore).WithArgs(1).WillReturnRows(mock.NewRows([]string{"updated_at"}).AddRow(mockTime))
+				mock.ExpectExec(sqlInsertHistory).
+					WithArgs(1, "restore", "anonymous", "", sqlmock.AnyArg(), sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectCommit()
+			},
+			err: nil,
+		},
+		{
+			name: "should not found when pet is not deleted",
+			prepare: func(mock sqlmock.Sqlmock, tt testCase) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(sqlForUpdate).WithArgs(1).WillReturnRows(petForUpdateRows(mock, 1, false))
+				mock.ExpectRollback()
+			},
+			err: store.PetNotFound,
+		},
+		{
+			name: "should not found when pet does not exist",
+			prepare: func(mock sqlmock.Sqlmock, tt testCase) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(sqlForUpdate).WithArgs(1).WillReturnError(sql.ErrNoRows)
+				mock.ExpectRollback()
+			},
+			err: store.PetNotFound,
+		},
+		{
+			name: "should error on query error",
+			prepare: func(mock sqlmock.Sqlmock, tt testCase) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(sqlForUpdate).WithArgs(1).WillReturnRows(petForUpdateRows(mock, 1, true))
+				mock.ExpectQuery(sqlRestore).WithArgs(1).WillReturnError(tt.err)
+				mock.ExpectRollback()
+			},
+			err: mockErr,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			ps, mock := initDBMock(t)
+			defer ps.Close()
+			tt.prepare(mock, tt)
+
+			got := ps.RestorePet(1)
+
+			if tt.err != got {
+				t.Fatalf("error restoring pet, got %v, want %v", got, tt.err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatalf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+func TestMockPosgreSQLPetStore_PetHistory(t *testing.T) {
+	changedAt := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+	historyColumns := []string{"id", "pet_id", "action", "changed_at", "actor", "request_id", "before", "after"}
+
+	type testCase struct {
+		name      string
+		prepare   func(mock sqlmock.Sqlmock, tt testCase)
+		want      []data.PetChange
+		wantTotal int
+		err       error
+	}
+
+	var cases = []testCase{
+		{
+			name: "should get changes",
+			prepare: func(mock sqlmock.Sqlmock, tt testCase) {
+				mock.ExpectQuery(sqlCountHistory).WithArgs(1).WillReturnRows(mock.NewRows([]string{"count"}).AddRow(2))
+				mock.ExpectQuery(sqlSelectHistory).WithArgs(1, 10, 0).WillReturnRows(mock.NewRows(historyColumns).
+					AddRow(1, 1, "create", changedAt, "john", "abc", nil, []byte(`{"id":1,"name":"a","race":"b","mod":"c"}`)).
+					AddRow(2, 1, "update", changedAt, "jane", "", []byte(`{"id":1,"name":"a","race":"b","mod":"c"}`),
+						[]byte(`{"id":1,"name":"d","race":"b","mod":"c"}`)))
+			},
+			want: []data.PetChange{
+				{Id: 1, PetId: 1, Action: data.CreateAction, Timestamp: changedAt, Actor: "john", RequestId: "abc",
+					After: &data.Pet{Id: 1, Name: "a", Race: "b", Mod: "c"}},
+				{Id: 2, PetId: 1, Action: data.UpdateAction, Timestamp: changedAt, Actor: "jane",
+					Before: &data.Pet{Id: 1, Name: "a", Race: "b", Mod: "c"},
+					After:  &data.Pet{Id: 1, Name: "d", Race: "b", Mod: "c"}},
+			},
+			wantTotal: 2,
+			err:       nil,
+		},
+		{
+			name: "should get empty history for existing pet",
+			prepare: func(mock sqlmock.Sqlmock, tt testCase) {
+				mock.ExpectQuery(sqlCountHistory).WithArgs(1).WillReturnRows(mock.NewRows([]string{"count"}).AddRow(0))
+				mock.ExpectQuery(sqlSelect).WithArgs(1).WillReturnRows(mock.NewRows([]string{"id"}).AddRow(1))
+			},
+			want:      []data.PetChange{},
+			wantTotal: 0,
+			err:       nil,
+		},
+		{
+			name: "should not found when pet does not exist",
+			prepare: func(mock sqlmock.Sqlmock, tt testCase) {
+				mock.ExpectQuery(sqlCountHistory).WithArgs(1).WillReturnRows(mock.NewRows([]string{"count"}).AddRow(0))
+				mock.ExpectQuery(sqlSelect).WithArgs(1).WillReturnError(sql.ErrNoRows)
+			},
+			want:      []data.PetChange{},
+			wantTotal: 0,
+			err:       store.PetNotFound,
+		},
+		{
+			name: "should error on count error",
+			prepare: func(mock sqlmock.Sqlmock, tt testCase) {
+				mock.ExpectQuery(sqlCountHistory).WithArgs(1).WillReturnError(mockErr)
+			},
+			want:      []data.PetChange{},
+			wantTotal: 0,
+			err:       mockErr,
+		},
+		{
+			name: "should error on query error",
+			prepare: func(mock sqlmock.Sqlmock, tt testCase) {
+				mock.ExpectQuery(sqlCountHistory).WithArgs(1).WillReturnRows(mock.NewRows([]string{"count"}).AddRow(2))
+				mock.ExpectQuery(sqlSelectHistory).WithArgs(1, 10, 0).WillReturnError(mockErr)
+			},
+			want:      []data.PetChange{},
+			wantTotal: 2,
+			err:       mockErr,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			ps, mock := initDBMock(t)
+			defer ps.Close()
+			tt.prepare(mock, tt)
+
+			got, total, err := ps.PetHistory(1, 0, 10)
+
+			if err != tt.err {
+				t.Fatalf("error getting history, got %v, want %v", err, tt.err)
+			}
+			if !reflect.DeepEqual(got, tt.want) || total != tt.wantTotal {
+				t.Fatalf("error getting history, got %v (%d), want %v (%d)", got, total, tt.want, tt.wantTotal)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatalf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+func TestMockPosgreSQLPetStore_WithContext(t *testing.T) {
+	ps, mock := initDBMock(t)
+	defer ps.Close()
+
+	ctx := reqctx.WithRequestId(reqctx.WithActor(context.Background(), "john"), "abc")
+	mock.ExpectBegin()
+	mock.ExpectQuery(sqlInsert).WithArgs("name", "race", "mod").
+		WillReturnRows(mock.NewRows([]string{"id", "created_at", "updated_at"}).AddRow(1, mockTime, mockTime))
+	mock.ExpectExec(sqlInsertHistory).WithArgs(1, "create", "john", "abc", nil, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	if _, err := ps.WithContext(ctx).AddPet("name", "race", "mod"); err != nil {
+		t.Fatalf("error adding pet, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestMockPosgreSQLPetStore_WithContextQuery(t *testing.T) {
+	ps, mock := initDBMock(t)
+	defer ps.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := ps.WithContext(ctx).PurgePets(time.Now()); err != context.Canceled {
+		t.Fatalf("error purging pets, got %v, want %v", err, context.Canceled)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestMockPosgreSQLPetStore_PurgePets(t *testing.T) {
+	before := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("should purge", func(t *testing.T) {
+		ps, mock := initDBMock(t)
+		defer ps.Close()
+		mock.ExpectQuery(sqlPurge).WithArgs(before).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(3))
+
+		got, err := ps.PurgePets(before)
+
+		if err != nil || !reflect.DeepEqual(got, []int{1, 3}) {
+			t.Fatalf("error purging pets, got %v, %v, want [1 3], nil", got, err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatalf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("should error on query error", func(t *testing.T) {
+		ps, mock := initDBMock(t)
+		defer ps.Close()
+		mock.ExpectQuery(sqlPurge).WithArgs(before).WillReturnError(mockErr)
+
+		_, err := ps.PurgePets(before)
+
+		if err != mockErr {
+			t.Fatalf("error purging pets, got %v, want %v", err, mockErr)
+		}
+	})
+}
+
+func TestMockPosgreSQLPetStore_FindPets(t *testing.T) {
+	deletedAt := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+	type testCase struct {
+		name    string
+		query   store.PetQuery
+		prepare func(mock sqlmock.Sqlmock, tt testCase)
+		want    []data.Pet
+		err     error
+	}
+
+	var cases = []testCase{
+		{
+			name:  "should find live pets",
+			query: store.PetQuery{},
+			prepare: func(mock sqlmock.Sqlmock, tt testCase) {
+				rows := mock.NewRows(petColumns).AddRow(1, "name1", "race1", "mod1", mockTime, mockTime, nil, nil, "available", mockAttributes, mockTags)
+				mock.ExpectQuery("SELECT .* FROM pets WHERE deleted_at IS NULL ORDER BY .*").
+					WithArgs().WillReturnRows(rows)
+			},
+			want: []data.Pet{{Id: 1, Name: "name1", Race: "race1", Mod: "mod1", Status: data.Available, CreatedAt: mockTime, UpdatedAt: mockTime}},
+			err:  nil,
+		},
+		{
+			name:  "should find deleted pets by id",
+			query: store.PetQuery{Ids: []int{2}, IncludeDeleted: true},
+			prepare: func(mock sqlmock.Sqlmock, tt testCase) {
+				rows := mock.NewRows(petColumns).AddRow(2, "name2", "race2", "mod2", mockTime, mockTime, deletedAt, nil, "available", mockAttributes, mockTags)
+				mock.ExpectQuery("SELECT .* FROM pets WHERE id = ANY\\(\\$1\\) ORDER BY .*").
+					WithArgs("{2}").WillReturnRows(rows)
+			},
+			want: []data.Pet{{Id: 2, Name: "name2", Race: "race2", Mod: "mod2", Status: data.Available, CreatedAt: mockTime, UpdatedAt: mockTime,
+				DeletedAt: &deletedAt}},
+			err: nil,
+		},
+		{
+			name: "should find updated pets sorted",
+			query: store.PetQuery{UpdatedSince: mockTime, Sort: []store.PetSort{
+				{Field: store.SortByUpdatedAt, Descending: true},
+				{Field: store.SortByName},
+			}},
+			prepare: func(mock sqlmock.Sqlmock, tt testCase) {
+				rows := mock.NewRows(petColumns).AddRow(1, "name1", "race1", "mod1", mockTime, mockTime, nil, nil, "available", mockAttributes, mockTags)
+				mock.ExpectQuery("SELECT .* FROM pets WHERE deleted_at IS NULL AND updated_at >= \\$1 " +
+					"ORDER BY updated_at DESC, name ASC, id ASC;").
+					WithArgs(mockTime).WillReturnRows(rows)
+			},
+			want: []data.Pet{{Id: 1, Name: "name1", Race: "race1", Mod: "mod1", Status: data.Available, CreatedAt: mockTime, UpdatedAt: mockTime}},
+			err:  nil,
+		},
+		{
+			name:  "should find pets by tags and attributes",
+			query: store.PetQuery{Tags: []string{"small", "friendly"}, Attributes: map[string]string{"color": "black"}},
+			prepare: func(mock sqlmock.Sqlmock, tt testCase) {
+				rows := mock.NewRows(petColumns).AddRow(1, "name1", "race1", "mod1", mockTime, mockTime, nil, nil, "available",
+					[]byte(`{"color":"black"}`), "{friendly,small}")
+				mock.ExpectQuery("SELECT .* FROM pets WHERE deleted_at IS NULL AND "+
+					"id IN \\(SELECT pet_id FROM pet_tags WHERE tag = ANY\\(\\$1\\) GROUP BY pet_id HAVING count\\(\\*\\) = \\$2\\) AND "+
+					"attributes @> \\$3::jsonb ORDER BY .*").
+					WithArgs("{\"friendly\",\"small\"}", 2, `{"color":"black"}`).WillReturnRows(rows)
+			},
+			want: []data.Pet{{Id: 1, Name: "name1", Race: "race1", Mod: "mod1", Status: data.Available,
+				Tags: []string{"friendly", "small"}, Attributes: map[string]string{"color": "black"},
+				CreatedAt: mockTime, UpdatedAt: mockTime}},
+			err: nil,
+		},
+		{
+			name:  "should find a page of pets",
+			query: store.PetQuery{Sort: []store.PetSort{{Field: store.SortById}}, AfterId: 3, Limit: 2},
+			prepare: func(mock sqlmock.Sqlmock, tt testCase) {
+				rows := mock.NewRows(petColumns).AddRow(4, "name4", "race4", "mod4", mockTime, mockTime, nil, nil, "available", mockAttributes, mockTags)
+				mock.ExpectQuery("SELECT .* FROM pets WHERE deleted_at IS NULL AND id > \\$1 ORDER BY id ASC, id ASC LIMIT \\$2;").
+					WithArgs(3, 2).WillReturnRows(rows)
+			},
+			want: []data.Pet{{Id: 4, Name: "name4", Race: "race4", Mod: "mod4", Status: data.Available, CreatedAt: mockTime, UpdatedAt: mockTime}},
+			err:  nil,
+		},
+		{
+			name:  "should error on query error",
+			query: store.PetQuery{},
+			prepare: func(mock sqlmock.Sqlmock, tt testCase) {
+				mock.ExpectQuery(sqlFind).WillReturnError(tt.err)
+			},
+			want: []data.Pet{},
+			err:  mockErr,
+		}}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			ps, mock := initDBMock(t)
+			defer ps.Close()
+			tt.prepare(mock, tt)
+
+			got, err := ps.FindPets(tt.query)
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("error finding pets, got %v, want %v", got, tt.want)
+			}
+			if err != tt.err {
+				t.Fatalf("error finding pets, want %q, got %q", tt.err, err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatalf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+func TestMockPosgreSQLPetStore_CountPets(t *testing.T) {
+	t.Run("should count the pets without the page", func(t *testing.T) {
+		ps, mock := initDBMock(t)
+		defer ps.Close()
+		mock.ExpectQuery("SELECT count\\(\\*\\) FROM pets WHERE deleted_at IS NULL AND owner_id = \\$1;").
+			WithArgs(7).WillReturnRows(mock.NewRows([]string{"count"}).AddRow(5))
+
+		got, err := ps.CountPets(store.PetQuery{OwnerId: 7, AfterId: 3, Limit: 2})
+
+		if got != 5 || err != nil {
+			t.Fatalf("error counting pets, got %d and %v, want 5", got, err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatalf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("should error on query error", func(t *testing.T) {
+		ps, mock := initDBMock(t)
+		defer ps.Close()
+		mock.ExpectQuery("SELECT count").WillReturnError(mockErr)
+
+		if _, err := ps.CountPets(store.PetQuery{}); err != mockErr {
+			t.Fatalf("error counting pets, want %q, got %q", mockErr, err)
+		}
+	})
+}
+
+func TestPosgreSQLPetStore_Open(t *testing.T) {
+	t.Run("we should be able to open a connection", func(t *testing.T) {
+		ps := getPetStore(mockFile)
+		var mock sqlmock.Sqlmock
+
+		ps.open = func(driverName, dataSourceName string) (db *sql.DB, err error) {
+			db, mock, err = sqlmock.New(sqlmock.MonitorPingsOption(true))
+
+			if err == nil && mock != nil {
+				mock.ExpectPing()
+				mock.ExpectBegin()
+				mock.ExpectExec(sqlLockSchemaMock).WithArgs(schemaLock).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec(sqlCreateSchemaVersionMock).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectQuery(sqlGetSchemaVersionMock).WillReturnRows(mock.NewRows([]string{"version"}))
+				for _, migration := range sqlMigrations {
+					for range migration.statements {
+						mock.ExpectExec(".*").WillReturnResult(sqlmock.NewResult(0, 0))
+					}
+					mock.ExpectExec(sqlRecordSchemaVersionMock).WithArgs(migration.version).
+						WillReturnResult(sqlmock.NewResult(0, 1))
+				}
+				mock.ExpectCommit()
+			}
+
+			return
+		}
+
+		err := ps.Open()
+
+		if err != nil {
+			t.Fatalf("expect no error got %q", err)
+		}
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("we should not been able to open a connection", func(t *testing.T) {
+		mockError := errors.New("invalid connection")
+		ps := getPetStore(mockFile)
+
+		ps.open = func(driverName, dataSourceName string) (db *sql.DB, err error) {
+			return nil, mockError
+		}
+
+		err := ps.Open()
+
+		if err != mockError {
+			t.Fatalf("invalid error, got %v, want %v", err, mockError)
+		}
+	})
+}
+
+func TestMockPosgreSQLPetStore_Migrate(t *testing.T) {
+	migrations := []sqlMigration{
+		{version: 1, statements: []string{"CREATE TABLE first"}},
+		{version: 2, statements: []string{"CREATE TABLE second", "CREATE INDEX second"}},
+	}
+	expectLock := func(mock sqlmock.Sqlmock, recorded int) {
+		mock.ExpectBegin()
+		mock.ExpectExec(sqlLockSchemaMock).WithArgs(schemaLock).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(sqlCreateSchemaVersionMock).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(sqlGetSchemaVersionMock).WillReturnRows(mock.NewRows([]string{"version"}).AddRow(recorded))
+	}
+
+	t.Run("should apply only the newer migrations", func(t *testing.T) {
+		ps, mock := initDBMock(t)
+		defer ps.Close()
+		expectLock(mock, 1)
+		mock.ExpectExec("CREATE TABLE second").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("CREATE INDEX second").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(sqlRecordSchemaVersionMock).WithArgs(2).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		if err := ps.migrate(migrations); err != nil {
+			t.Fatalf("error migrating, got %v", err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatalf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("should not touch a schema migrated by a newer release", func(t *testing.T) {
+		ps, mock := initDBMock(t)
+		defer ps.Close()
+		expectLock(mock, 3)
+		mock.ExpectCommit()
+
+		if err := ps.migrate(migrations); err != nil {
+			t.Fatalf("error migrating, got %v", err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatalf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("should rollback a failed migration", func(t *testing.T) {
+		ps, mock := initDBMock(t)
+		defer ps.Close()
+		expectLock(mock, 1)
+		mock.ExpectExec("CREATE TABLE second").WillReturnError(mockErr)
+		mock.ExpectRollback()
+
+		if err := ps.migrate(migrations); err != mockErr {
+			t.Fatalf("error migrating, got %v, want %v", err, mockErr)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatalf("there were unfulfilled expectations: %s", err)
+		}
+	})
+}
+
+func TestMockPosgreSQLPetStore_SchemaVersion(t *testing.T) {
+	t.Run("should get schema version", func(t *testing.T) {
+		ps, mock := initDBMock(t)
+		defer ps.Close()
+		mock.ExpectQuery(sqlGetSchemaVersionMock).WillReturnRows(mock.NewRows([]string{"version"}).AddRow(3))
+
+		got, want, err := ps.SchemaVersion()
+		if err != nil || got != 3 || want != schemaVersion {
+			t.Fatalf("got %d, %d and %v, want 3 and %d", got, want, err, schemaVersion)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatalf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("should fail getting schema version", func(t *testing.T) {
+		ps, mock := initDBMock(t)
+		defer ps.Close()
+		mockError := errors.New("no table")
+		mock.ExpectQuery(sqlGetSchemaVersionMock).WillReturnError(mockError)
+
+		if _, _, err := ps.SchemaVersion(); err != mockError {
+			t.Fatalf("got %v, want %v", err, mockError)
+		}
+	})
+}
+
+func TestMockPosgreSQLPetStore_PoolStats(t *testing.T) {
+	ps, _ := initDBMock(t)
+	defer ps.Close()
+
+	if got := ps.PoolStats(); got.InUse != 0 || got.MaxOpen != 0 {
+		t.Fatalf("got %v, want an unused unlimited pool", got)
+	}
+}
+
+func TestMockPosgreSQLPetStore_TransitionPet(t *testing.T) {
+	type testCase struct {
+		name    string
+		to      data.PetStatus
+		prepare func(mock sqlmock.Sqlmock)
+		want    data.PetStatus
+		err     error
+	}
+
+	var cases = []testCase{
+		{
+			name: "should reserve pet",
+			to:   data.Reserved,
+			prepare: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(sqlForUpdate).WithArgs(5).WillReturnRows(petForUpdateRows(mock, 5, false))
+				mock.ExpectQuery(sqlTransition).WithArgs(5, "available", "reserved").
+					WillReturnRows(mock.NewRows([]string{"updated_at"}).AddRow(mockTime))
+				mock.ExpectExec(sqlInsertHistory).
+					WithArgs(5, "transition", "anonymous", "", sqlmock.AnyArg(), sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectCommit()
+			},
+			want: data.Reserved,
+			err:  nil,
+		},
+		{
+			name: "should reject illegal transition",
+			to:   data.Adopted,
+			prepare: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(sqlForUpdate).WithArgs(5).WillReturnRows(petForUpdateRows(mock, 5, false))
+				mock.ExpectRollback()
+			},
+			err: store.IllegalStatus,
+		},
+		{
+			name: "should reject when status has changed",
+			to:   data.Reserved,
+			prepare: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(sqlForUpdate).WithArgs(5).WillReturnRows(petForUpdateRows(mock, 5, false))
+				mock.ExpectQuery(sqlTransition).WithArgs(5, "available", "reserved").
+					WillReturnRows(mock.NewRows([]string{"updated_at"}))
+				mock.ExpectRollback()
+			},
+			err: store.IllegalStatus,
+		},
+		{
+			name: "should not found deleted pet",
+			to:   data.Reserved,
+			prepare: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(sqlForUpdate).WithArgs(5).WillReturnRows(petForUpdateRows(mock, 5, true))
+				mock.ExpectRollback()
+			},
+			err: store.PetNotFound,
+		},
+		{
+			name: "should error on commit error",
+			to:   data.Reserved,
+			prepare: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(sqlForUpdate).WithArgs(5).WillReturnRows(petForUpdateRows(mock, 5, false))
+				mock.ExpectQuery(sqlTransition).WithArgs(5, "available", "reserved").
+					WillReturnRows(mock.NewRows([]string{"updated_at"}).AddRow(mockTime))
+				mock.ExpectExec(sqlInsertHistory).WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectCommit().WillReturnError(mockErr)
+			},
+			err: mockErr,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			ps, mock := initDBMock(t)
+			defer ps.Close()
+			tt.prepare(mock)
+
+			got, err := ps.TransitionPet(5, tt.to)
+			if err != tt.err || got.Status != tt.want {
+				t.Fatalf("error in transition, got %v and %v, want %q and %v", got, err, tt.want, tt.err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatalf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
 }
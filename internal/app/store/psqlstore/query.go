@@ -0,0 +1,125 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package psqlstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/LearningByExample/go-microservice/internal/app/data"
+	"github.com/LearningByExample/go-microservice/internal/app/store"
+	"github.com/lib/pq"
+	"strings"
+)
+
+var (
+	sortColumns = map[store.SortField]string{
+		store.SortById:        "id",
+		store.SortByName:      "name",
+		store.SortByRace:      "race",
+		store.SortByMod:       "mod",
+		store.SortByCreatedAt: "created_at",
+		store.SortByUpdatedAt: "updated_at",
+	}
+)
+
+type queryBuilder struct {
+	conditions []string
+	args       []interface{}
+}
+
+func (qb *queryBuilder) where(condition string, args ...interface{}) {
+	placeholders := make([]interface{}, len(args))
+	for i := range args {
+		qb.args = append(qb.args, args[i])
+		placeholders[i] = fmt.Sprintf("$%d", len(qb.args))
+	}
+	qb.conditions = append(qb.conditions, fmt.Sprintf(condition, placeholders...))
+}
+
+func (qb queryBuilder) build(base string, order string) string {
+	var sb strings.Builder
+	sb.WriteString(base)
+	if len(qb.conditions) != 0 {
+		sb.WriteString("\n\t\tWHERE\n\t\t\t")
+		sb.WriteString(strings.Join(qb.conditions, " AND\n\t\t\t"))
+	}
+	sb.WriteString(order)
+	return sb.String()
+}
+
+func petConditions(query store.PetQuery) queryBuilder {
+	qb := queryBuilder{}
+	if !query.IncludeDeleted {
+		qb.where("deleted_at IS NULL")
+	}
+	if len(query.Ids) != 0 {
+		qb.where("id = ANY(%s)", pq.Array(query.Ids))
+	}
+	if query.OwnerId != 0 {
+		qb.where("owner_id = %s", query.OwnerId)
+	}
+	if tags := data.SortedTags(query.Tags); len(tags) != 0 {
+		qb.where("id IN (SELECT pet_id FROM pet_tags WHERE tag = ANY(%s) GROUP BY pet_id HAVING count(*) = %s)",
+			pq.Array(tags), len(tags))
+	}
+	if len(query.Attributes) != 0 {
+		attributes, _ := json.Marshal(query.Attributes)
+		qb.where("attributes @> %s::jsonb", string(attributes))
+	}
+	if !query.UpdatedSince.IsZero() {
+		qb.where("updated_at >= %s", query.UpdatedSince)
+	}
+	return qb
+}
+
+func findPetsQuery(query store.PetQuery) (string, []interface{}) {
+	qb := petConditions(query)
+	if query.AfterId != 0 {
+		qb.where("id > %s", query.AfterId)
+	}
+	order := fmt.Sprintf(sqlFindPetsOrder, orderBy(query.Sort))
+	if query.Limit > 0 {
+		qb.args = append(qb.args, query.Limit)
+		order = fmt.Sprintf(sqlFindPetsPage, orderBy(query.Sort), len(qb.args))
+	}
+	return qb.build(sqlFindPets, order), qb.args
+}
+
+func countPetsQuery(query store.PetQuery) (string, []interface{}) {
+	qb := petConditions(query)
+	return qb.build(sqlCountPets, ";"), qb.args
+}
+
+func orderBy(sorts []store.PetSort) string {
+	terms := make([]string, 0, len(sorts)+1)
+	for _, by := range sorts {
+		if column, found := sortColumns[by.Field]; found {
+			direction := "ASC"
+			if by.Descending {
+				direction = "DESC"
+			}
+			terms = append(terms, column+" "+direction)
+		}
+	}
+	return strings.Join(append(terms, "id ASC"), ", ")
+}
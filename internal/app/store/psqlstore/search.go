@@ -0,0 +1,95 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package psqlstore
+
+import (
+	"database/sql"
+	"github.com/LearningByExample/go-microservice/internal/app/data"
+	"github.com/LearningByExample/go-microservice/internal/app/store"
+	"html"
+	"strings"
+)
+
+// ts_headline marks the matches with control characters, they are not escaped as HTML so they can be replaced with
+// the highlight tags once the text is escaped
+const (
+	headlineStart   = "\x02"
+	headlineStop    = "\x03"
+	headlineOptions = `StartSel="` + headlineStart + `", StopSel="` + headlineStop + `", HighlightAll=true`
+)
+
+var headlineMarks = strings.NewReplacer(headlineStart, store.HighlightStart, headlineStop, store.HighlightStop)
+
+// headline returns the escaped highlight of a field, a value already containing the marks is never highlighted
+func headline(text string, value string) (string, bool) {
+	if !strings.Contains(text, headlineStart) || strings.ContainsAny(value, headlineStart+headlineStop) {
+		return "", false
+	}
+	return headlineMarks.Replace(html.EscapeString(text)), true
+}
+
+func scanMatch(r rowScanner) (data.PetMatch, error) {
+	var match = data.PetMatch{Highlights: make(map[string]string)}
+	var name, race, mod string
+	var err error = nil
+	if match.Pet, err = scanPet(r, &match.Rank, &name, &race, &mod); err == nil {
+		for field, text := range map[store.SortField][2]string{store.SortByName: {name, match.Pet.Name},
+			store.SortByRace: {race, match.Pet.Race}, store.SortByMod: {mod, match.Pet.Mod}} {
+			if highlight, ok := headline(text[0], text[1]); ok {
+				match.Highlights[string(field)] = highlight
+			}
+		}
+	}
+	return match, err
+}
+
+func (p posgreSQLPetStore) SearchPets(text string, offset int, limit int) ([]data.PetMatch, int, error) {
+	var total = 0
+	var err error = nil
+	var matches = make([]data.PetMatch, 0)
+	var r *sql.Rows
+	var pageLimit interface{} = nil
+
+	if limit > 0 {
+		pageLimit = limit
+	}
+
+	if err = p.queryRow(sqlCountSearch, text).Scan(&total); err == nil && offset < total {
+		if r, err = p.query(sqlSearchPets, text, pageLimit, offset, headlineOptions); err == nil {
+			//noinspection GoUnhandledErrorResult
+			defer r.Close()
+			for r.Next() {
+				var match data.PetMatch
+				if match, err = scanMatch(r); err != nil {
+					break
+				}
+				matches = append(matches, match)
+			}
+			if err == nil {
+				err = r.Err()
+			}
+		}
+	}
+
+	return matches, total, err
+}
@@ -0,0 +1,158 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package psqlstore
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/LearningByExample/go-microservice/internal/app/data"
+	"github.com/LearningByExample/go-microservice/internal/app/store"
+	"reflect"
+	"testing"
+)
+
+const (
+	sqlCountSearchMock = "SELECT count\\(\\*\\) FROM pets, plainto_tsquery.*"
+	sqlSearchPetsMock  = "SELECT .* ts_rank\\(search, query\\) .* FROM pets, plainto_tsquery.*"
+)
+
+func TestMockPosgreSQLPetStore_SearchPets(t *testing.T) {
+	matchColumns := append(append([]string{}, petColumns...), "rank", "name", "race", "mod")
+	ownerId := 3
+	mark := func(s string) string {
+		return store.HighlightStart + s + store.HighlightStop
+	}
+	headlineMark := func(s string) string {
+		return headlineStart + s + headlineStop
+	}
+
+	type testCase struct {
+		name      string
+		prepare   func(mock sqlmock.Sqlmock)
+		want      []data.PetMatch
+		wantTotal int
+		err       error
+	}
+
+	var cases = []testCase{
+		{
+			name: "should get matches",
+			prepare: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(sqlCountSearchMock).WithArgs("dog").
+					WillReturnRows(mock.NewRows([]string{"count"}).AddRow(2))
+				mock.ExpectQuery(sqlSearchPetsMock).WithArgs("dog", 10, 0, headlineOptions).
+					WillReturnRows(mock.NewRows(matchColumns).
+						AddRow(1, "Dog", "dog", "happy", mockTime, mockTime, nil, 3, "available", mockAttributes, mockTags, 0.9,
+							headlineMark("Dog"), headlineMark("dog"), "happy").
+						AddRow(2, "Lion", "cat", "dog", mockTime, mockTime, nil, nil, "available", mockAttributes, mockTags, 0.1,
+							"Lion", "cat", headlineMark("dog")))
+			},
+			want: []data.PetMatch{
+				{
+					Pet: data.Pet{Id: 1, Name: "Dog", Race: "dog", Mod: "happy", Status: data.Available,
+						CreatedAt: mockTime, UpdatedAt: mockTime, OwnerId: &ownerId},
+					Rank:       0.9,
+					Highlights: map[string]string{"name": mark("Dog"), "race": mark("dog")},
+				},
+				{
+					Pet: data.Pet{Id: 2, Name: "Lion", Race: "cat", Mod: "dog", Status: data.Available,
+						CreatedAt: mockTime, UpdatedAt: mockTime},
+					Rank:       0.1,
+					Highlights: map[string]string{"mod": mark("dog")},
+				},
+			},
+			wantTotal: 2,
+			err:       nil,
+		},
+		{
+			name: "should escape the highlights",
+			prepare: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(sqlCountSearchMock).WithArgs("dog").
+					WillReturnRows(mock.NewRows([]string{"count"}).AddRow(1))
+				mock.ExpectQuery(sqlSearchPetsMock).WithArgs("dog", 10, 0, headlineOptions).
+					WillReturnRows(mock.NewRows(matchColumns).
+						AddRow(1, "<b>Dog</b>", "<mark>dog", "happy", mockTime, mockTime, nil, nil, "available",
+							mockAttributes, mockTags, 0.9, "<b>"+headlineMark("Dog")+"</b>", "<mark>dog", "happy"))
+			},
+			want: []data.PetMatch{
+				{
+					Pet: data.Pet{Id: 1, Name: "<b>Dog</b>", Race: "<mark>dog", Mod: "happy", Status: data.Available,
+						CreatedAt: mockTime, UpdatedAt: mockTime},
+					Rank:       0.9,
+					Highlights: map[string]string{"name": "&lt;b&gt;" + mark("Dog") + "&lt;/b&gt;"},
+				},
+			},
+			wantTotal: 1,
+			err:       nil,
+		},
+		{
+			name: "should not query without matches",
+			prepare: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(sqlCountSearchMock).WithArgs("dog").
+					WillReturnRows(mock.NewRows([]string{"count"}).AddRow(0))
+			},
+			want:      []data.PetMatch{},
+			wantTotal: 0,
+			err:       nil,
+		},
+		{
+			name: "should error on count error",
+			prepare: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(sqlCountSearchMock).WithArgs("dog").WillReturnError(mockErr)
+			},
+			want:      []data.PetMatch{},
+			wantTotal: 0,
+			err:       mockErr,
+		},
+		{
+			name: "should error on query error",
+			prepare: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(sqlCountSearchMock).WithArgs("dog").
+					WillReturnRows(mock.NewRows([]string{"count"}).AddRow(2))
+				mock.ExpectQuery(sqlSearchPetsMock).WithArgs("dog", 10, 0, headlineOptions).WillReturnError(mockErr)
+			},
+			want:      []data.PetMatch{},
+			wantTotal: 2,
+			err:       mockErr,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			ps, mock := initDBMock(t)
+			defer ps.Close()
+			tt.prepare(mock)
+
+			got, total, err := ps.SearchPets("dog", 0, 10)
+
+			if err != tt.err {
+				t.Fatalf("error searching pets, got %v, want %v", err, tt.err)
+			}
+			if !reflect.DeepEqual(got, tt.want) || total != tt.wantTotal {
+				t.Fatalf("error searching pets, got %v (%d), want %v (%d)", got, total, tt.want, tt.wantTotal)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatalf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
@@ -22,10 +22,69 @@
 
 package psqlstore
 
+import "github.com/lib/pq"
+
+var (
+	sqlCopyPets = pq.CopyIn("pets", "id", "name", "race", "mod", "status", "owner_id", "attributes", "created_at",
+		"updated_at")
+	sqlCopyTags    = pq.CopyIn("pet_tags", "pet_id", "tag")
+	sqlCopyChanges = pq.CopyIn("pet_history", "pet_id", "action", "actor", "request_id", "after")
+
+	// sqlMigrations are the versions of the schema, a change is a new version appended with the next number as the
+	// statements of a released version never change, only the versions newer than the recorded one are applied
+	sqlMigrations = []sqlMigration{
+		{
+			version: 1,
+			statements: []string{
+				sqlCreateTable,
+				sqlAddDeletedAt,
+				sqlAddTimestamps,
+				sqlCreateUpdatedAtFunction,
+				sqlDropUpdatedAtTrigger,
+				sqlCreateUpdatedAtTrigger,
+				sqlCreateHistoryTable,
+				sqlCreateHistoryIndex,
+				sqlHistoryNoUpdate,
+				sqlHistoryNoDelete,
+				sqlCreateOwnersTable,
+				sqlDropOwnerUpdatedAtTrigger,
+				sqlCreateOwnerUpdatedAtTrigger,
+				sqlAddPetOwner,
+				sqlCreatePetOwnerIndex,
+				sqlAddStatus,
+				sqlCreateUnaccent,
+				sqlCreateSearchConfig,
+				sqlAddSearch,
+				sqlCreateSearchFunction,
+				sqlDropSearchTrigger,
+				sqlCreateSearchTrigger,
+				sqlBackfillSearch,
+				sqlCreateSearchIndex,
+				sqlAddAttributes,
+				sqlCreateAttributesIndex,
+				sqlCreateTagsTable,
+				sqlCreateTagsIndex,
+				sqlCreateWebhooksTable,
+				sqlDropWebhookUpdatedAtTrigger,
+				sqlCreateWebhookUpdatedAtTrigger,
+				sqlCreateDeliveriesTable,
+				sqlCreateDeliveriesIndex,
+				sqlCreateOutboxTable,
+				sqlCreateOutboxIndex,
+				sqlCreateOutboxFunction,
+				sqlDropOutboxTrigger,
+				sqlCreateOutboxTrigger,
+				sqlCreateApiKeysTable,
+			},
+		},
+	}
+	schemaVersion = sqlMigrations[len(sqlMigrations)-1].version
+)
+
 const (
 	sqlIsReady = `
 		SELECT 1;`
-	sqlVerifyPetExists = `
+	sqlPetRecordExists = `
 		SELECT
 			id
 		FROM
@@ -41,6 +100,339 @@ const (
 				mod 	varchar(25) NOT NULL,
 				race 	varchar(25) NOT NULL
 			);`
+	sqlAddDeletedAt = `
+		ALTER TABLE
+			pets
+		ADD COLUMN IF NOT EXISTS
+			deleted_at TIMESTAMP WITH TIME ZONE;`
+	sqlAddTimestamps = `
+		ALTER TABLE
+			pets
+		ADD COLUMN IF NOT EXISTS
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+		ADD COLUMN IF NOT EXISTS
+			updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now();`
+	sqlCreateUpdatedAtFunction = `
+		CREATE OR REPLACE FUNCTION
+			pets_set_updated_at()
+		RETURNS TRIGGER AS $$
+		BEGIN
+			NEW.created_at = OLD.created_at;
+			NEW.updated_at = now();
+			RETURN NEW;
+		END;
+		$$ LANGUAGE plpgsql;`
+	sqlDropUpdatedAtTrigger = `
+		DROP TRIGGER IF EXISTS
+			pets_updated_at
+		ON
+			pets;`
+	sqlCreateUpdatedAtTrigger = `
+		CREATE TRIGGER
+			pets_updated_at
+		BEFORE UPDATE ON
+			pets
+		FOR EACH ROW EXECUTE PROCEDURE
+			pets_set_updated_at();`
+	sqlCreateHistoryTable = `
+		CREATE TABLE IF NOT EXISTS
+			pet_history
+			(
+				id 			BIGSERIAL 					PRIMARY KEY,
+				pet_id 		INTEGER 					NOT NULL,
+				action 		varchar(10) 				NOT NULL,
+				changed_at 	TIMESTAMP WITH TIME ZONE 	NOT NULL DEFAULT now(),
+				actor 		varchar(100) 				NOT NULL,
+				request_id 	varchar(100) 				NOT NULL,
+				before 		JSONB,
+				after 		JSONB
+			);`
+	sqlCreateHistoryIndex = `
+		CREATE INDEX IF NOT EXISTS
+			pet_history_pet_id
+		ON
+			pet_history (pet_id, id);`
+	sqlHistoryNoUpdate = `
+		CREATE OR REPLACE RULE
+			pet_history_no_update
+		AS ON UPDATE TO
+			pet_history
+		DO INSTEAD NOTHING;`
+	sqlHistoryNoDelete = `
+		CREATE OR REPLACE RULE
+			pet_history_no_delete
+		AS ON DELETE TO
+			pet_history
+		DO INSTEAD NOTHING;`
+	sqlCreateOwnersTable = `
+		CREATE TABLE IF NOT EXISTS
+			owners
+			(
+				id 			SERIAL 						PRIMARY KEY,
+				name 		varchar(45) 				NOT NULL,
+				email 		varchar(100) 				NOT NULL,
+				created_at 	TIMESTAMP WITH TIME ZONE 	NOT NULL DEFAULT now(),
+				updated_at 	TIMESTAMP WITH TIME ZONE 	NOT NULL DEFAULT now()
+			);`
+	sqlDropOwnerUpdatedAtTrigger = `
+		DROP TRIGGER IF EXISTS
+			owners_updated_at
+		ON
+			owners;`
+	sqlCreateOwnerUpdatedAtTrigger = `
+		CREATE TRIGGER
+			owners_updated_at
+		BEFORE UPDATE ON
+			owners
+		FOR EACH ROW EXECUTE PROCEDURE
+			pets_set_updated_at();`
+	sqlAddPetOwner = `
+		ALTER TABLE
+			pets
+		ADD COLUMN IF NOT EXISTS
+			owner_id INTEGER REFERENCES owners (id) ON DELETE RESTRICT;`
+	sqlCreatePetOwnerIndex = `
+		CREATE INDEX IF NOT EXISTS
+			pets_owner_id
+		ON
+			pets (owner_id);`
+	sqlAddStatus = `
+		ALTER TABLE
+			pets
+		ADD COLUMN IF NOT EXISTS
+			status varchar(10) NOT NULL DEFAULT 'available';`
+	sqlCreateUnaccent = `
+		CREATE EXTENSION IF NOT EXISTS
+			unaccent;`
+	sqlCreateSearchConfig = `
+		DO $$
+		BEGIN
+			IF NOT EXISTS (SELECT 1 FROM pg_ts_config WHERE cfgname = 'pets_unaccent') THEN
+				CREATE TEXT SEARCH CONFIGURATION pets_unaccent (COPY = simple);
+				ALTER TEXT SEARCH CONFIGURATION pets_unaccent
+					ALTER MAPPING FOR hword, hword_part, word WITH unaccent, simple;
+			END IF;
+		END
+		$$;`
+	sqlAddSearch = `
+		ALTER TABLE
+			pets
+		ADD COLUMN IF NOT EXISTS
+			search tsvector;`
+	sqlCreateSearchFunction = `
+		CREATE OR REPLACE FUNCTION
+			pets_set_search()
+		RETURNS TRIGGER AS $$
+		BEGIN
+			NEW.search =
+				setweight(to_tsvector('pets_unaccent', NEW.name), 'A') ||
+				setweight(to_tsvector('pets_unaccent', NEW.race), 'B') ||
+				setweight(to_tsvector('pets_unaccent', NEW.mod), 'C');
+			RETURN NEW;
+		END;
+		$$ LANGUAGE plpgsql;`
+	sqlDropSearchTrigger = `
+		DROP TRIGGER IF EXISTS
+			pets_search
+		ON
+			pets;`
+	sqlCreateSearchTrigger = `
+		CREATE TRIGGER
+			pets_search
+		BEFORE INSERT OR UPDATE OF name, race, mod ON
+			pets
+		FOR EACH ROW EXECUTE PROCEDURE
+			pets_set_search();`
+	sqlBackfillSearch = `
+		DO $$
+		BEGIN
+			IF EXISTS (SELECT 1 FROM pets WHERE search IS NULL) THEN
+				ALTER TABLE pets DISABLE TRIGGER pets_updated_at;
+				UPDATE pets SET name = name WHERE search IS NULL;
+				ALTER TABLE pets ENABLE TRIGGER pets_updated_at;
+			END IF;
+		END
+		$$;`
+	sqlCreateSearchIndex = `
+		CREATE INDEX IF NOT EXISTS
+			pets_search_gin
+		ON
+			pets USING GIN (search);`
+	sqlAddAttributes = `
+		ALTER TABLE
+			pets
+		ADD COLUMN IF NOT EXISTS
+			attributes JSONB NOT NULL DEFAULT '{}';`
+	sqlCreateAttributesIndex = `
+		CREATE INDEX IF NOT EXISTS
+			pets_attributes
+		ON
+			pets USING GIN (attributes jsonb_path_ops);`
+	sqlCreateTagsTable = `
+		CREATE TABLE IF NOT EXISTS
+			pet_tags
+			(
+				pet_id 	INTEGER 	NOT NULL REFERENCES pets (id) ON DELETE CASCADE,
+				tag 	varchar(32) NOT NULL,
+				PRIMARY KEY (pet_id, tag)
+			);`
+	sqlCreateTagsIndex = `
+		CREATE INDEX IF NOT EXISTS
+			pet_tags_tag
+		ON
+			pet_tags (tag);`
+	sqlCreateWebhooksTable = `
+		CREATE TABLE IF NOT EXISTS
+			webhooks
+			(
+				id 			SERIAL 						PRIMARY KEY,
+				url 		varchar(2048) 				NOT NULL,
+				events 		varchar(16)[] 				NOT NULL,
+				secret 		varchar(256) 				NOT NULL,
+				enabled 	BOOLEAN 					NOT NULL DEFAULT true,
+				failures 	INTEGER 					NOT NULL DEFAULT 0,
+				created_at 	TIMESTAMP WITH TIME ZONE 	NOT NULL DEFAULT now(),
+				updated_at 	TIMESTAMP WITH TIME ZONE 	NOT NULL DEFAULT now()
+			);`
+	sqlDropWebhookUpdatedAtTrigger = `
+		DROP TRIGGER IF EXISTS
+			webhooks_updated_at
+		ON
+			webhooks;`
+	sqlCreateWebhookUpdatedAtTrigger = `
+		CREATE TRIGGER
+			webhooks_updated_at
+		BEFORE UPDATE OF url, events, secret, enabled ON
+			webhooks
+		FOR EACH ROW EXECUTE PROCEDURE
+			pets_set_updated_at();`
+	sqlCreateDeliveriesTable = `
+		CREATE TABLE IF NOT EXISTS
+			webhook_deliveries
+			(
+				id 				SERIAL 						PRIMARY KEY,
+				webhook_id 		INTEGER 					NOT NULL REFERENCES webhooks (id) ON DELETE CASCADE,
+				event 			varchar(16) 				NOT NULL,
+				payload 		TEXT 						NOT NULL,
+				status 			varchar(16) 				NOT NULL DEFAULT 'pending',
+				attempts 		JSONB 						NOT NULL DEFAULT '[]',
+				next_attempt 	TIMESTAMP WITH TIME ZONE 	NOT NULL DEFAULT now(),
+				created_at 		TIMESTAMP WITH TIME ZONE 	NOT NULL DEFAULT now()
+			);`
+	sqlCreateDeliveriesIndex = `
+		CREATE INDEX IF NOT EXISTS
+			webhook_deliveries_pending
+		ON
+			webhook_deliveries (next_attempt)
+		WHERE
+			status = 'pending';`
+	sqlCreateOutboxTable = `
+		CREATE TABLE IF NOT EXISTS
+			outbox
+			(
+				id 				BIGSERIAL 					PRIMARY KEY,
+				pet_id 			INTEGER 					NOT NULL,
+				type 			varchar(10) 				NOT NULL,
+				payload 		JSONB 						NOT NULL,
+				created_at 		TIMESTAMP WITH TIME ZONE 	NOT NULL DEFAULT now(),
+				published_at 	TIMESTAMP WITH TIME ZONE
+			);`
+	sqlCreateOutboxIndex = `
+		CREATE INDEX IF NOT EXISTS
+			outbox_unpublished
+		ON
+			outbox (id)
+		WHERE
+			published_at IS NULL;`
+	sqlCreateOutboxFunction = `
+		CREATE OR REPLACE FUNCTION
+			pet_history_to_outbox()
+		RETURNS TRIGGER AS $$
+		BEGIN
+			INSERT INTO
+				outbox
+				(
+					pet_id,
+					type,
+					payload
+				)
+			VALUES
+				(
+					NEW.pet_id,
+					NEW.action,
+					jsonb_build_object(
+						'id', NEW.id,
+						'petId', NEW.pet_id,
+						'action', NEW.action,
+						'timestamp', NEW.changed_at,
+						'actor', NEW.actor,
+						'requestId', NEW.request_id,
+						'before', NEW.before,
+						'after', NEW.after
+					)
+				);
+			RETURN NEW;
+		END;
+		$$ LANGUAGE plpgsql;`
+	sqlDropOutboxTrigger = `
+		DROP TRIGGER IF EXISTS
+			pet_history_outbox
+		ON
+			pet_history;`
+	sqlCreateOutboxTrigger = `
+		CREATE TRIGGER
+			pet_history_outbox
+		AFTER INSERT ON
+			pet_history
+		FOR EACH ROW EXECUTE PROCEDURE
+			pet_history_to_outbox();`
+	sqlCreateApiKeysTable = `
+		CREATE TABLE IF NOT EXISTS
+			api_keys
+			(
+				id 			SERIAL 						PRIMARY KEY,
+				name 		varchar(255) 				NOT NULL,
+				prefix 		varchar(16) 				NOT NULL,
+				hash 		char(64) 					NOT NULL UNIQUE,
+				scopes 		varchar(16)[] 				NOT NULL,
+				created_at 	TIMESTAMP WITH TIME ZONE 	NOT NULL DEFAULT now(),
+				rotated_at 	TIMESTAMP WITH TIME ZONE,
+				revoked_at 	TIMESTAMP WITH TIME ZONE
+			);`
+	sqlCreateSchemaVersionTable = `
+		CREATE TABLE IF NOT EXISTS
+			schema_version
+			(
+				id 			INTEGER 	PRIMARY KEY CHECK (id = 1),
+				version 	INTEGER 	NOT NULL
+			);`
+	sqlRecordSchemaVersion = `
+		INSERT INTO
+			schema_version
+			(
+				id,
+				version
+			)
+		VALUES
+			(1, $1)
+		ON CONFLICT (id) DO UPDATE SET
+			version = GREATEST(schema_version.version, EXCLUDED.version);`
+	sqlLockSchema = `
+		SELECT
+			pg_advisory_xact_lock($1);`
+	sqlGetSchemaVersion = `
+		SELECT
+			version
+		FROM
+			schema_version
+		WHERE
+			id = 1;`
+	sqlNextPetIds = `
+		SELECT
+			nextval('pets_id_seq'),
+			now()
+		FROM
+			generate_series(1, $1);`
 	sqlInsertPet = `
 		INSERT INTO
 			pets
@@ -56,27 +448,91 @@ const (
 				$3
 			)
 		RETURNING
-			id;`
+			id,
+			created_at,
+			updated_at;`
 	sqlGetPet = `
 		SELECT
 			id,
 			name,
 			race,
-			mod
+			mod,
+			created_at,
+			updated_at,
+			deleted_at,
+			owner_id,
+			status,
+			attributes,
+			ARRAY(SELECT tag FROM pet_tags WHERE pet_id = pets.id ORDER BY tag) AS tags
 		FROM
 			pets
 		WHERE
-			id = $1;`
+			id = $1 AND
+			deleted_at IS NULL;`
+	sqlGetPetForUpdate = `
+		SELECT
+			id,
+			name,
+			race,
+			mod,
+			created_at,
+			updated_at,
+			deleted_at,
+			owner_id,
+			status,
+			attributes,
+			ARRAY(SELECT tag FROM pet_tags WHERE pet_id = pets.id ORDER BY tag) AS tags
+		FROM
+			pets
+		WHERE
+			id = $1
+		FOR UPDATE;`
 	sqlGetAllPets = `
 		SELECT
 			id,
 			name,
 			race,
-			mod
+			mod,
+			created_at,
+			updated_at,
+			deleted_at,
+			owner_id,
+			status,
+			attributes,
+			ARRAY(SELECT tag FROM pet_tags WHERE pet_id = pets.id ORDER BY tag) AS tags
 		FROM
 			pets
+		WHERE
+			deleted_at IS NULL
 		ORDER BY
 			id ASC;`
+	sqlFindPets = `
+		SELECT
+			id,
+			name,
+			race,
+			mod,
+			created_at,
+			updated_at,
+			deleted_at,
+			owner_id,
+			status,
+			attributes,
+			ARRAY(SELECT tag FROM pet_tags WHERE pet_id = pets.id ORDER BY tag) AS tags
+		FROM
+			pets`
+	sqlFindPetsOrder = `
+		ORDER BY
+			%s;`
+	sqlFindPetsPage = `
+		ORDER BY
+			%s
+		LIMIT $%d;`
+	sqlCountPets = `
+		SELECT
+			count(*)
+		FROM
+			pets`
 	sqlUpdatePet = `
 		UPDATE
 			pets
@@ -86,13 +542,553 @@ const (
 			mod 	= $4
 		WHERE
 			id = $1 AND
-			name <> $2 AND
-			race <> $3 AND
-			mod <> $4;`
+			(name, race, mod) <> ($2, $3, $4) AND
+			deleted_at IS NULL
+		RETURNING
+			updated_at;`
+	sqlSavepoint = `
+		SAVEPOINT batch_operation;`
+	sqlReleaseSavepoint = `
+		RELEASE SAVEPOINT batch_operation;`
+	sqlRollbackToSavepoint = `
+		ROLLBACK TO SAVEPOINT batch_operation;`
 	sqlDeletePet = `
+		UPDATE
+			pets
+		SET
+			deleted_at = now()
+		WHERE
+			id = $1 AND
+			deleted_at IS NULL
+		RETURNING
+			deleted_at,
+			updated_at;`
+	sqlRestorePet = `
+		UPDATE
+			pets
+		SET
+			deleted_at = NULL
+		WHERE
+			id = $1 AND
+			deleted_at IS NOT NULL
+		RETURNING
+			updated_at;`
+	sqlTransitionPet = `
+		UPDATE
+			pets
+		SET
+			status = $3
+		WHERE
+			id = $1 AND
+			status = $2 AND
+			deleted_at IS NULL
+		RETURNING
+			updated_at;`
+	sqlPurgePets = `
+		DELETE
+		FROM
+			pets
+		WHERE
+			deleted_at < $1
+		RETURNING
+			id;`
+	sqlInsertChange = `
+		INSERT INTO
+			pet_history
+			(
+				pet_id,
+				action,
+				actor,
+				request_id,
+				before,
+				after
+			)
+		VALUES
+			(
+				$1,
+				$2,
+				$3,
+				$4,
+				$5,
+				$6
+			);`
+	sqlCountChanges = `
+		SELECT
+			count(*)
+		FROM
+			pet_history
+		WHERE
+			pet_id = $1;`
+	sqlGetChanges = `
+		SELECT
+			id,
+			pet_id,
+			action,
+			changed_at,
+			actor,
+			request_id,
+			before,
+			after
+		FROM
+			pet_history
+		WHERE
+			pet_id = $1
+		ORDER BY
+			id ASC
+		LIMIT
+			$2
+		OFFSET
+			$3;`
+	sqlCountSearch = `
+		SELECT
+			count(*)
+		FROM
+			pets,
+			plainto_tsquery('pets_unaccent', $1) query
+		WHERE
+			deleted_at IS NULL AND
+			search @@ query;`
+	sqlSearchPets = `
+		SELECT
+			id,
+			name,
+			race,
+			mod,
+			created_at,
+			updated_at,
+			deleted_at,
+			owner_id,
+			status,
+			attributes,
+			ARRAY(SELECT tag FROM pet_tags WHERE pet_id = pets.id ORDER BY tag) AS tags,
+			ts_rank(search, query) AS rank,
+			ts_headline('pets_unaccent', name, query, $4),
+			ts_headline('pets_unaccent', race, query, $4),
+			ts_headline('pets_unaccent', mod, query, $4)
+		FROM
+			pets,
+			plainto_tsquery('pets_unaccent', $1) query
+		WHERE
+			deleted_at IS NULL AND
+			search @@ query
+		ORDER BY
+			rank DESC,
+			id ASC
+		LIMIT
+			$2
+		OFFSET
+			$3;`
+	sqlDeletePetTags = `
 		DELETE
 		FROM
+			pet_tags
+		WHERE
+			pet_id = $1;`
+	sqlInsertPetTags = `
+		INSERT INTO
+			pet_tags
+			(
+				pet_id,
+				tag
+			)
+		SELECT
+			$1,
+			unnest($2::varchar[]);`
+	sqlSetPetAttributes = `
+		UPDATE
 			pets
+		SET
+			attributes = $2
+		WHERE
+			id = $1
+		RETURNING
+			updated_at;`
+	sqlNotifyEvent = `
+		SELECT
+			pg_notify($1, $2);`
+	sqlInsertOwner = `
+		INSERT INTO
+			owners
+			(
+				name,
+				email
+			)
+		VALUES
+			(
+				$1,
+				$2
+			)
+		RETURNING
+			id;`
+	sqlGetOwner = `
+		SELECT
+			id,
+			name,
+			email,
+			created_at,
+			updated_at
+		FROM
+			owners
+		WHERE
+			id = $1;`
+	sqlGetOwnerForUpdate = `
+		SELECT
+			id,
+			name,
+			email,
+			created_at,
+			updated_at
+		FROM
+			owners
+		WHERE
+			id = $1
+		FOR UPDATE;`
+	sqlGetAllOwners = `
+		SELECT
+			id,
+			name,
+			email,
+			created_at,
+			updated_at
+		FROM
+			owners
+		ORDER BY
+			id ASC;`
+	sqlGetOwners = `
+		SELECT
+			id,
+			name,
+			email,
+			created_at,
+			updated_at
+		FROM
+			owners
+		WHERE
+			id = ANY($1)
+		ORDER BY
+			id ASC;`
+	sqlUpdateOwner = `
+		UPDATE
+			owners
+		SET
+			name 	= $2,
+			email 	= $3
+		WHERE
+			id = $1 AND
+			(name, email) <> ($2, $3);`
+	sqlDeleteOwner = `
+		DELETE
+		FROM
+			owners
+		WHERE
+			id = $1;`
+	sqlGetOwnedPetsForUpdate = `
+		SELECT
+			id,
+			name,
+			race,
+			mod,
+			created_at,
+			updated_at,
+			deleted_at,
+			owner_id,
+			status,
+			attributes,
+			ARRAY(SELECT tag FROM pet_tags WHERE pet_id = pets.id ORDER BY tag) AS tags
+		FROM
+			pets
+		WHERE
+			owner_id = $1
+		ORDER BY
+			id ASC
+		FOR UPDATE;`
+	sqlSetPetOwner = `
+		UPDATE
+			pets
+		SET
+			owner_id = $2
+		WHERE
+			id = $1
+		RETURNING
+			updated_at;`
+	sqlInsertWebhook = `
+		INSERT INTO
+			webhooks
+			(
+				url,
+				events,
+				secret,
+				enabled
+			)
+		VALUES
+			(
+				$1,
+				$2,
+				$3,
+				$4
+			)
+		RETURNING
+			id;`
+	sqlGetWebhook = `
+		SELECT
+			id,
+			url,
+			events,
+			secret,
+			enabled,
+			failures,
+			created_at,
+			updated_at
+		FROM
+			webhooks
+		WHERE
+			id = $1;`
+	sqlGetAllWebhooks = `
+		SELECT
+			id,
+			url,
+			events,
+			secret,
+			enabled,
+			failures,
+			created_at,
+			updated_at
+		FROM
+			webhooks
+		ORDER BY
+			id ASC;`
+	sqlUpdateWebhook = `
+		UPDATE
+			webhooks
+		SET
+			url 		= $2,
+			events 		= $3,
+			secret 		= $4,
+			enabled 	= $5,
+			failures 	= CASE WHEN $5 AND NOT enabled THEN 0 ELSE failures END
+		WHERE
+			id = $1 AND
+			(url, events, secret, enabled) <> ($2, $3, $4, $5);`
+	sqlDisableWebhook = `
+		UPDATE
+			webhooks
+		SET
+			enabled = false
+		WHERE
+			id = $1;`
+	sqlDeleteWebhook = `
+		DELETE
+		FROM
+			webhooks
+		WHERE
+			id = $1;`
+	sqlEnqueueDeliveries = `
+		INSERT INTO
+			webhook_deliveries
+			(
+				webhook_id,
+				event,
+				payload
+			)
+		SELECT
+			id,
+			$1,
+			$2
+		FROM
+			webhooks
+		WHERE
+			enabled AND
+			$1 = ANY(events)
+		ORDER BY
+			id ASC;`
+	sqlClaimDeliveries = `
+		UPDATE
+			webhook_deliveries
+		SET
+			next_attempt = $2
+		WHERE
+			id IN (
+				SELECT
+					d.id
+				FROM
+					webhook_deliveries d
+				JOIN
+					webhooks w ON w.id = d.webhook_id
+				WHERE
+					d.status = 'pending' AND
+					w.enabled AND
+					d.next_attempt <= $1
+				ORDER BY
+					d.next_attempt ASC,
+					d.id ASC
+				LIMIT
+					$3
+				FOR UPDATE OF d SKIP LOCKED
+			)
+		RETURNING
+			id,
+			webhook_id,
+			event,
+			payload,
+			status,
+			attempts,
+			next_attempt,
+			created_at;`
+	sqlRecordDeliveryAttempt = `
+		UPDATE
+			webhook_deliveries
+		SET
+			attempts 		= attempts || $2::jsonb,
+			status 			= $3,
+			next_attempt 	= $4
+		WHERE
+			id = $1
+		RETURNING
+			webhook_id;`
+	sqlRecordWebhookResult = `
+		UPDATE
+			webhooks
+		SET
+			failures = CASE WHEN $2 THEN 0 ELSE failures + 1 END
+		WHERE
+			id = $1
+		RETURNING
+			failures;`
+	sqlCountPendingDeliveries = `
+		SELECT
+			count(*)
+		FROM
+			webhook_deliveries
+		WHERE
+			status = 'pending';`
+	sqlCountDeliveries = `
+		SELECT
+			count(*)
+		FROM
+			webhook_deliveries
+		WHERE
+			webhook_id = $1;`
+	sqlGetDeliveries = `
+		SELECT
+			id,
+			webhook_id,
+			event,
+			payload,
+			status,
+			attempts,
+			next_attempt,
+			created_at
+		FROM
+			webhook_deliveries
+		WHERE
+			webhook_id = $1
+		ORDER BY
+			id ASC
+		LIMIT
+			$2
+		OFFSET
+			$3;`
+	sqlLockOutbox = `
+		SELECT
+			pg_try_advisory_xact_lock($1);`
+	sqlGetUnpublished = `
+		SELECT
+			id,
+			pet_id,
+			type,
+			payload,
+			created_at
+		FROM
+			outbox
+		WHERE
+			published_at IS NULL
+		ORDER BY
+			id ASC
+		LIMIT
+			$1;`
+	sqlMarkPublished = `
+		UPDATE
+			outbox
+		SET
+			published_at = now()
+		WHERE
+			id = ANY($1);`
+	sqlInsertApiKey = `
+		INSERT INTO
+			api_keys
+			(
+				name,
+				prefix,
+				hash,
+				scopes
+			)
+		VALUES
+			(
+				$1,
+				$2,
+				$3,
+				$4
+			)
+		RETURNING
+			id;`
+	sqlGetApiKey = `
+		SELECT
+			id,
+			name,
+			prefix,
+			hash,
+			scopes,
+			created_at,
+			rotated_at,
+			revoked_at
+		FROM
+			api_keys
+		WHERE
+			id = $1;`
+	sqlGetAllApiKeys = `
+		SELECT
+			id,
+			name,
+			prefix,
+			hash,
+			scopes,
+			created_at,
+			rotated_at,
+			revoked_at
+		FROM
+			api_keys
+		ORDER BY
+			id ASC;`
+	sqlFindApiKey = `
+		SELECT
+			id,
+			name,
+			prefix,
+			hash,
+			scopes,
+			created_at,
+			rotated_at,
+			revoked_at
+		FROM
+			api_keys
+		WHERE
+			hash = $1 AND
+			revoked_at IS NULL;`
+	sqlRotateApiKey = `
+		UPDATE
+			api_keys
+		SET
+			prefix 		= $2,
+			hash 		= $3,
+			rotated_at 	= now()
+		WHERE
+			id = $1 AND
+			revoked_at IS NULL;`
+	sqlRevokeApiKey = `
+		UPDATE
+			api_keys
+		SET
+			revoked_at = COALESCE(revoked_at, now())
 		WHERE
 			id = $1;`
 )
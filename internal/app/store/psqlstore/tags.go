@@ -0,0 +1,96 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package psqlstore
+
+import (
+	"database/sql"
+	"encoding/json"
+	"github.com/LearningByExample/go-microservice/internal/app/data"
+	"github.com/LearningByExample/go-microservice/internal/app/store"
+	"github.com/lib/pq"
+)
+
+func attributesFromJson(bytes []byte, pet *data.Pet) error {
+	var attributes map[string]string = nil
+	if len(bytes) != 0 {
+		if err := json.Unmarshal(bytes, &attributes); err != nil {
+			return err
+		}
+	}
+	if len(attributes) != 0 {
+		pet.Attributes = attributes
+	}
+	return nil
+}
+
+func attributesJson(attributes map[string]string) ([]byte, error) {
+	if attributes == nil {
+		attributes = map[string]string{}
+	}
+	return json.Marshal(attributes)
+}
+
+func (p posgreSQLPetStore) txSetPetTags(tx *sql.Tx, pet data.Pet, tags []string, attributes map[string]string) error {
+	var err error = nil
+	var attrs []byte
+
+	after := pet
+	after.Tags = tags
+	after.Attributes = nil
+	if len(attributes) != 0 {
+		after.Attributes = attributes
+	}
+	if _, err = p.txExec(tx, sqlDeletePetTags, pet.Id); err == nil && len(tags) != 0 {
+		_, err = p.txExec(tx, sqlInsertPetTags, pet.Id, pq.Array(tags))
+	}
+	if err == nil {
+		if attrs, err = attributesJson(attributes); err == nil {
+			err = p.txQueryRow(tx, sqlSetPetAttributes, pet.Id, attrs).Scan(&after.UpdatedAt)
+		}
+	}
+	if err == nil {
+		err = p.txRecordChange(tx, data.UpdateAction, pet.Id, &pet, &after)
+	}
+
+	return err
+}
+
+func (p posgreSQLPetStore) SetPetTags(id int, tags []string, attributes map[string]string) (bool, error) {
+	var change = false
+	tags = data.SortedTags(tags)
+	err := p.inTransaction(func(tx *sql.Tx) error {
+		var err error = nil
+		var pet data.Pet
+
+		if pet, err = p.txGetPetForUpdate(tx, id); err == nil {
+			if pet.IsDeleted() {
+				err = store.PetNotFound
+			} else if !pet.SameTags(tags, attributes) {
+				change = true
+				err = p.txSetPetTags(tx, pet, tags, attributes)
+			}
+		}
+		return err
+	})
+	return change && err == nil, err
+}
@@ -0,0 +1,141 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package psqlstore
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/LearningByExample/go-microservice/internal/app/store"
+	"testing"
+)
+
+const (
+	sqlDeletePetTagsMock    = "DELETE FROM pet_tags WHERE pet_id = \\$1;"
+	sqlInsertPetTagsMock    = "INSERT INTO pet_tags .*"
+	sqlSetPetAttributesMock = "UPDATE pets SET attributes = \\$2 .*"
+)
+
+func TestMockPosgreSQLPetStore_SetPetTags(t *testing.T) {
+	black := map[string]string{"color": "black"}
+
+	type testCase struct {
+		name       string
+		tags       []string
+		attributes map[string]string
+		prepare    func(mock sqlmock.Sqlmock)
+		want       bool
+		err        error
+	}
+
+	var cases = []testCase{
+		{
+			name:       "should set pet tags",
+			tags:       []string{"small", "friendly", "small"},
+			attributes: black,
+			prepare: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(sqlForUpdate).WithArgs(5).WillReturnRows(petForUpdateRows(mock, 5, false))
+				mock.ExpectExec(sqlDeletePetTagsMock).WithArgs(5).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec(sqlInsertPetTagsMock).WithArgs(5, "{\"friendly\",\"small\"}").
+					WillReturnResult(sqlmock.NewResult(0, 2))
+				mock.ExpectQuery(sqlSetPetAttributesMock).WithArgs(5, []byte(`{"color":"black"}`)).
+					WillReturnRows(mock.NewRows([]string{"updated_at"}).AddRow(mockTime))
+				mock.ExpectExec(sqlInsertHistory).
+					WithArgs(5, "update", "anonymous", "", sqlmock.AnyArg(), sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectCommit()
+			},
+			want: true,
+			err:  nil,
+		},
+		{
+			name:       "should clear pet tags",
+			tags:       nil,
+			attributes: black,
+			prepare: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(sqlForUpdate).WithArgs(5).WillReturnRows(petForUpdateRows(mock, 5, false))
+				mock.ExpectExec(sqlDeletePetTagsMock).WithArgs(5).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectQuery(sqlSetPetAttributesMock).WithArgs(5, []byte(`{"color":"black"}`)).
+					WillReturnRows(mock.NewRows([]string{"updated_at"}).AddRow(mockTime))
+				mock.ExpectExec(sqlInsertHistory).
+					WithArgs(5, "update", "anonymous", "", sqlmock.AnyArg(), sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectCommit()
+			},
+			want: true,
+			err:  nil,
+		},
+		{
+			name:       "should not change a pet without tags",
+			tags:       []string{},
+			attributes: map[string]string{},
+			prepare: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(sqlForUpdate).WithArgs(5).WillReturnRows(petForUpdateRows(mock, 5, false))
+				mock.ExpectCommit()
+			},
+			want: false,
+			err:  nil,
+		},
+		{
+			name: "should not found deleted pet",
+			tags: []string{"small"},
+			prepare: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(sqlForUpdate).WithArgs(5).WillReturnRows(petForUpdateRows(mock, 5, true))
+				mock.ExpectRollback()
+			},
+			want: false,
+			err:  store.PetNotFound,
+		},
+		{
+			name: "should error on insert error",
+			tags: []string{"small"},
+			prepare: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(sqlForUpdate).WithArgs(5).WillReturnRows(petForUpdateRows(mock, 5, false))
+				mock.ExpectExec(sqlDeletePetTagsMock).WithArgs(5).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec(sqlInsertPetTagsMock).WithArgs(5, "{\"small\"}").WillReturnError(mockErr)
+				mock.ExpectRollback()
+			},
+			want: false,
+			err:  mockErr,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			ps, mock := initDBMock(t)
+			defer ps.Close()
+			tt.prepare(mock)
+
+			got, err := ps.SetPetTags(5, tt.tags, tt.attributes)
+			if got != tt.want || err != tt.err {
+				t.Fatalf("error setting pet tags, got %t and %v, want %t and %v", got, err, tt.want, tt.err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatalf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,239 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package psqlstore
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/LearningByExample/go-microservice/internal/app/data"
+	"github.com/LearningByExample/go-microservice/internal/app/store"
+	"github.com/lib/pq"
+	"sort"
+	"time"
+)
+
+func scanWebhook(r rowScanner) (data.Webhook, error) {
+	var webhook = data.Webhook{}
+	var events pq.StringArray
+	err := r.Scan(&webhook.Id, &webhook.Url, &events, &webhook.Secret, &webhook.Enabled, &webhook.Failures,
+		&webhook.CreatedAt, &webhook.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = store.WebhookNotFound
+	}
+	webhook.Events = events
+	return webhook, err
+}
+
+func scanDelivery(r rowScanner) (data.Delivery, error) {
+	var delivery = data.Delivery{}
+	var status string
+	var attempts []byte
+	err := r.Scan(&delivery.Id, &delivery.WebhookId, &delivery.Event, &delivery.Payload, &status, &attempts,
+		&delivery.NextAttempt, &delivery.CreatedAt)
+	if err == nil {
+		delivery.Status = data.DeliveryStatus(status)
+		err = json.Unmarshal(attempts, &delivery.Attempts)
+	}
+	return delivery, err
+}
+
+func scanDeliveries(r *sql.Rows) ([]data.Delivery, error) {
+	var err error = nil
+	var deliveries = make([]data.Delivery, 0)
+
+	//noinspection GoUnhandledErrorResult
+	defer r.Close()
+	for r.Next() {
+		var delivery data.Delivery
+		if delivery, err = scanDelivery(r); err != nil {
+			break
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	if err == nil {
+		err = r.Err()
+	}
+
+	return deliveries, err
+}
+
+func (p posgreSQLPetStore) AddWebhook(url string, events []string, secret string, enabled bool) (int, error) {
+	var id = 0
+	var err error = nil
+
+	if r := p.queryRow(sqlInsertWebhook, url, pq.Array(events), secret, enabled); r != nil {
+		err = r.Scan(&id)
+	}
+
+	return id, err
+}
+
+func (p posgreSQLPetStore) GetWebhook(id int) (data.Webhook, error) {
+	return scanWebhook(p.queryRow(sqlGetWebhook, id))
+}
+
+func (p posgreSQLPetStore) GetAllWebhooks() ([]data.Webhook, error) {
+	var err error = nil
+	var webhooks = make([]data.Webhook, 0)
+	var r *sql.Rows
+
+	if r, err = p.query(sqlGetAllWebhooks); err == nil {
+		//noinspection GoUnhandledErrorResult
+		defer r.Close()
+		for r.Next() {
+			var webhook data.Webhook
+			if webhook, err = scanWebhook(r); err != nil {
+				break
+			}
+			webhooks = append(webhooks, webhook)
+		}
+		if err == nil {
+			err = r.Err()
+		}
+	}
+
+	return webhooks, err
+}
+
+func (p posgreSQLPetStore) UpdateWebhook(id int, url string, events []string, secret string, enabled bool) (bool, error) {
+	var err error = nil
+	var r sql.Result
+	var count int64 = 0
+
+	if r, err = p.exec(sqlUpdateWebhook, id, url, pq.Array(events), secret, enabled); err == nil {
+		if count, err = r.RowsAffected(); err == nil && count == 0 {
+			_, err = p.GetWebhook(id)
+		}
+	}
+
+	return count == 1 && err == nil, err
+}
+
+func (p posgreSQLPetStore) DisableWebhook(id int) error {
+	var err error = nil
+	var r sql.Result
+	var count int64 = 0
+
+	if r, err = p.exec(sqlDisableWebhook, id); err == nil {
+		if count, err = r.RowsAffected(); err == nil && count == 0 {
+			err = store.WebhookNotFound
+		}
+	}
+
+	return err
+}
+
+func (p posgreSQLPetStore) DeleteWebhook(id int) error {
+	var err error = nil
+	var r sql.Result
+	var count int64 = 0
+
+	if r, err = p.exec(sqlDeleteWebhook, id); err == nil {
+		if count, err = r.RowsAffected(); err == nil && count == 0 {
+			err = store.WebhookNotFound
+		}
+	}
+
+	return err
+}
+
+func (p posgreSQLPetStore) EnqueueDeliveries(event string, payload string) (int, error) {
+	var err error = nil
+	var r sql.Result
+	var count int64 = 0
+
+	if r, err = p.exec(sqlEnqueueDeliveries, event, payload); err == nil {
+		count, err = r.RowsAffected()
+	}
+
+	return int(count), err
+}
+
+func (p posgreSQLPetStore) ClaimDeliveries(now time.Time, lease time.Duration, limit int) ([]data.Delivery, error) {
+	var err error = nil
+	var deliveries = make([]data.Delivery, 0)
+	var r *sql.Rows
+
+	if r, err = p.query(sqlClaimDeliveries, now, now.Add(lease), limit); err == nil {
+		if deliveries, err = scanDeliveries(r); err == nil {
+			sort.Slice(deliveries, func(i, j int) bool {
+				return deliveries[i].Id < deliveries[j].Id
+			})
+		}
+	}
+
+	return deliveries, err
+}
+
+func (p posgreSQLPetStore) RecordDeliveryAttempt(id int, attempt data.DeliveryAttempt, status data.DeliveryStatus,
+	next time.Time) (int, error) {
+	var failures = 0
+	err := p.inTransaction(func(tx *sql.Tx) error {
+		var err error = nil
+		var attempts []byte
+		var webhookId = 0
+
+		if attempts, err = json.Marshal([]data.DeliveryAttempt{attempt}); err == nil {
+			err = p.txQueryRow(tx, sqlRecordDeliveryAttempt, id, attempts, string(status), next).Scan(&webhookId)
+			if errors.Is(err, sql.ErrNoRows) {
+				err = store.DeliveryNotFound
+			}
+		}
+		if err == nil {
+			delivered := status == data.DeliveryDelivered
+			err = p.txQueryRow(tx, sqlRecordWebhookResult, webhookId, delivered).Scan(&failures)
+		}
+		return err
+	})
+	return failures, err
+}
+
+func (p posgreSQLPetStore) PendingDeliveries() (int, error) {
+	var pending = 0
+	err := p.queryRow(sqlCountPendingDeliveries).Scan(&pending)
+	return pending, err
+}
+
+func (p posgreSQLPetStore) WebhookDeliveries(id int, offset int, limit int) ([]data.Delivery, int, error) {
+	var total = 0
+	var err error = nil
+	var deliveries = make([]data.Delivery, 0)
+	var r *sql.Rows
+	var pageLimit interface{} = nil
+
+	if limit > 0 {
+		pageLimit = limit
+	}
+
+	if err = p.queryRow(sqlCountDeliveries, id).Scan(&total); err == nil && total == 0 {
+		_, err = p.GetWebhook(id)
+	}
+	if err == nil && offset < total {
+		if r, err = p.query(sqlGetDeliveries, id, pageLimit, offset); err == nil {
+			deliveries, err = scanDeliveries(r)
+		}
+	}
+
+	return deliveries, total, err
+}
@@ -0,0 +1,324 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package psqlstore
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/LearningByExample/go-microservice/internal/app/data"
+	"github.com/LearningByExample/go-microservice/internal/app/store"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const (
+	sqlInsertWebhookMock     = "INSERT INTO webhooks .* RETURNING id;"
+	sqlSelectWebhookMock     = "SELECT .* FROM webhooks WHERE id = \\$1;"
+	sqlSelectWebhooksMock    = "SELECT .* FROM webhooks ORDER BY .*"
+	sqlUpdateWebhookMock     = "UPDATE webhooks SET url .*"
+	sqlDisableWebhookMock    = "UPDATE webhooks SET enabled = false WHERE id = \\$1;"
+	sqlDeleteWebhookMock     = "DELETE FROM webhooks WHERE id = \\$1;"
+	sqlEnqueueDeliveriesMock = "INSERT INTO webhook_deliveries .* SELECT .* FROM webhooks .*"
+	sqlClaimDeliveriesMock   = "UPDATE webhook_deliveries SET next_attempt = \\$2 .* SKIP LOCKED .*"
+	sqlRecordAttemptMock     = "UPDATE webhook_deliveries SET attempts .* RETURNING webhook_id;"
+	sqlRecordResultMock      = "UPDATE webhooks SET failures .* RETURNING failures;"
+	sqlCountDeliveriesMock   = "SELECT count\\(\\*\\) FROM webhook_deliveries WHERE webhook_id = \\$1;"
+	sqlSelectDeliveriesMock  = "SELECT .* FROM webhook_deliveries WHERE webhook_id = \\$1 .*"
+	sqlCountPendingMock      = "SELECT count\\(\\*\\) FROM webhook_deliveries WHERE status = 'pending';"
+)
+
+var (
+	webhookColumns  = []string{"id", "url", "events", "secret", "enabled", "failures", "created_at", "updated_at"}
+	deliveryColumns = []string{"id", "webhook_id", "event", "payload", "status", "attempts", "next_attempt",
+		"created_at"}
+	mockAttempts = []byte(`[{"at":"2020-04-01T00:00:00Z","statusCode":500}]`)
+)
+
+func webhookRows(mock sqlmock.Sqlmock, ids ...int) *sqlmock.Rows {
+	rows := mock.NewRows(webhookColumns)
+	for _, id := range ids {
+		rows.AddRow(id, "http://example.com", "{created,deleted}", "secret", true, 0, mockTime, mockTime)
+	}
+	return rows
+}
+
+func deliveryRows(mock sqlmock.Sqlmock, ids ...int) *sqlmock.Rows {
+	rows := mock.NewRows(deliveryColumns)
+	for _, id := range ids {
+		rows.AddRow(id, 3, "created", "{}", "pending", mockAttempts, mockTime, mockTime)
+	}
+	return rows
+}
+
+func mockDelivery(id int) data.Delivery {
+	return data.Delivery{Id: id, WebhookId: 3, Event: "created", Payload: "{}", Status: data.DeliveryPending,
+		Attempts: []data.DeliveryAttempt{{At: mockTime, StatusCode: 500}}, NextAttempt: mockTime, CreatedAt: mockTime}
+}
+
+func TestMockPosgreSQLPetStore_Webhooks(t *testing.T) {
+	t.Run("should add webhook", func(t *testing.T) {
+		ps, mock := initDBMock(t)
+		defer ps.Close()
+		mock.ExpectQuery(sqlInsertWebhookMock).WithArgs("http://example.com", "{\"created\"}", "secret", true).
+			WillReturnRows(mock.NewRows([]string{"id"}).AddRow(3))
+
+		if id, err := ps.AddWebhook("http://example.com", []string{"created"}, "secret", true); id != 3 || err != nil {
+			t.Fatalf("got %d and %v, want 3", id, err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatalf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("should get webhook", func(t *testing.T) {
+		ps, mock := initDBMock(t)
+		defer ps.Close()
+		mock.ExpectQuery(sqlSelectWebhookMock).WithArgs(3).WillReturnRows(webhookRows(mock, 3))
+
+		got, err := ps.GetWebhook(3)
+		want := data.Webhook{Id: 3, Url: "http://example.com", Events: []string{"created", "deleted"},
+			Secret: "secret", Enabled: true, CreatedAt: mockTime, UpdatedAt: mockTime}
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %v and %v, want %v", got, err, want)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatalf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("should not found webhook", func(t *testing.T) {
+		ps, mock := initDBMock(t)
+		defer ps.Close()
+		mock.ExpectQuery(sqlSelectWebhookMock).WithArgs(3).WillReturnRows(webhookRows(mock))
+
+		if _, err := ps.GetWebhook(3); err != store.WebhookNotFound {
+			t.Fatalf("got %v, want %v", err, store.WebhookNotFound)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatalf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("should get all webhooks", func(t *testing.T) {
+		ps, mock := initDBMock(t)
+		defer ps.Close()
+		mock.ExpectQuery(sqlSelectWebhooksMock).WillReturnRows(webhookRows(mock, 1, 2))
+
+		if webhooks, err := ps.GetAllWebhooks(); len(webhooks) != 2 || err != nil {
+			t.Fatalf("got %v and %v, want 2 webhooks", webhooks, err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatalf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("should disable webhook", func(t *testing.T) {
+		ps, mock := initDBMock(t)
+		defer ps.Close()
+		mock.ExpectExec(sqlDisableWebhookMock).WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(sqlDisableWebhookMock).WithArgs(4).WillReturnResult(sqlmock.NewResult(0, 0))
+
+		if err := ps.DisableWebhook(3); err != nil {
+			t.Fatalf("want not error, got %v", err)
+		}
+		if err := ps.DisableWebhook(4); err != store.WebhookNotFound {
+			t.Fatalf("got %v, want %v", err, store.WebhookNotFound)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatalf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	type updateCase struct {
+		name       string
+		prepare    func(mock sqlmock.Sqlmock)
+		wantChange bool
+		wantErr    error
+	}
+	var updateCases = []updateCase{
+		{
+			name: "should update webhook",
+			prepare: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(sqlUpdateWebhookMock).WithArgs(3, "http://example.com", "{\"created\"}", "secret", false).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+			wantChange: true,
+		},
+		{
+			name: "should not update unchanged webhook",
+			prepare: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(sqlUpdateWebhookMock).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectQuery(sqlSelectWebhookMock).WithArgs(3).WillReturnRows(webhookRows(mock, 3))
+			},
+		},
+		{
+			name: "should not update missing webhook",
+			prepare: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(sqlUpdateWebhookMock).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectQuery(sqlSelectWebhookMock).WithArgs(3).WillReturnRows(webhookRows(mock))
+			},
+			wantErr: store.WebhookNotFound,
+		},
+	}
+
+	for _, tt := range updateCases {
+		t.Run(tt.name, func(t *testing.T) {
+			ps, mock := initDBMock(t)
+			defer ps.Close()
+			tt.prepare(mock)
+
+			change, err := ps.UpdateWebhook(3, "http://example.com", []string{"created"}, "secret", false)
+			if change != tt.wantChange || err != tt.wantErr {
+				t.Fatalf("got %t and %v, want %t and %v", change, err, tt.wantChange, tt.wantErr)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatalf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+
+	t.Run("should delete webhook", func(t *testing.T) {
+		ps, mock := initDBMock(t)
+		defer ps.Close()
+		mock.ExpectExec(sqlDeleteWebhookMock).WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(sqlDeleteWebhookMock).WithArgs(4).WillReturnResult(sqlmock.NewResult(0, 0))
+
+		if err := ps.DeleteWebhook(3); err != nil {
+			t.Fatalf("want not error, got %v", err)
+		}
+		if err := ps.DeleteWebhook(4); err != store.WebhookNotFound {
+			t.Fatalf("got %v, want %v", err, store.WebhookNotFound)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatalf("there were unfulfilled expectations: %s", err)
+		}
+	})
+}
+
+func TestMockPosgreSQLPetStore_Deliveries(t *testing.T) {
+	t.Run("should enqueue deliveries", func(t *testing.T) {
+		ps, mock := initDBMock(t)
+		defer ps.Close()
+		mock.ExpectExec(sqlEnqueueDeliveriesMock).WithArgs("created", "{}").WillReturnResult(sqlmock.NewResult(0, 2))
+
+		if count, err := ps.EnqueueDeliveries("created", "{}"); count != 2 || err != nil {
+			t.Fatalf("got %d and %v, want 2", count, err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatalf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("should claim deliveries", func(t *testing.T) {
+		ps, mock := initDBMock(t)
+		defer ps.Close()
+		mock.ExpectQuery(sqlClaimDeliveriesMock).WithArgs(mockTime, mockTime.Add(time.Minute), 10).
+			WillReturnRows(deliveryRows(mock, 2, 1))
+
+		got, err := ps.ClaimDeliveries(mockTime, time.Minute, 10)
+		want := []data.Delivery{mockDelivery(1), mockDelivery(2)}
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %v and %v, want %v", got, err, want)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatalf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("should record attempt", func(t *testing.T) {
+		ps, mock := initDBMock(t)
+		defer ps.Close()
+		attempt := data.DeliveryAttempt{At: mockTime, StatusCode: 500}
+		mock.ExpectBegin()
+		mock.ExpectQuery(sqlRecordAttemptMock).WithArgs(1, mockAttempts, "pending", mockTime).
+			WillReturnRows(mock.NewRows([]string{"webhook_id"}).AddRow(3))
+		mock.ExpectQuery(sqlRecordResultMock).WithArgs(3, false).
+			WillReturnRows(mock.NewRows([]string{"failures"}).AddRow(4))
+		mock.ExpectCommit()
+
+		if failures, err := ps.RecordDeliveryAttempt(1, attempt, data.DeliveryPending, mockTime); failures != 4 || err != nil {
+			t.Fatalf("got %d and %v, want 4 failures", failures, err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatalf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("should not record attempt of missing delivery", func(t *testing.T) {
+		ps, mock := initDBMock(t)
+		defer ps.Close()
+		mock.ExpectBegin()
+		mock.ExpectQuery(sqlRecordAttemptMock).WillReturnRows(mock.NewRows([]string{"webhook_id"}))
+		mock.ExpectRollback()
+
+		_, err := ps.RecordDeliveryAttempt(1, data.DeliveryAttempt{}, data.DeliveryDelivered, mockTime)
+		if err != store.DeliveryNotFound {
+			t.Fatalf("got %v, want %v", err, store.DeliveryNotFound)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatalf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("should get webhook deliveries", func(t *testing.T) {
+		ps, mock := initDBMock(t)
+		defer ps.Close()
+		mock.ExpectQuery(sqlCountDeliveriesMock).WithArgs(3).WillReturnRows(mock.NewRows([]string{""}).AddRow(5))
+		mock.ExpectQuery(sqlSelectDeliveriesMock).WithArgs(3, 1, 2).WillReturnRows(deliveryRows(mock, 3))
+
+		got, total, err := ps.WebhookDeliveries(3, 2, 1)
+		if err != nil || total != 5 || !reflect.DeepEqual(got, []data.Delivery{mockDelivery(3)}) {
+			t.Fatalf("got %v, %d and %v, want delivery 3 of 5", got, total, err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatalf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("should count pending deliveries", func(t *testing.T) {
+		ps, mock := initDBMock(t)
+		defer ps.Close()
+		mock.ExpectQuery(sqlCountPendingMock).WillReturnRows(mock.NewRows([]string{""}).AddRow(7))
+
+		if count, err := ps.PendingDeliveries(); count != 7 || err != nil {
+			t.Fatalf("got %d and %v, want 7", count, err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatalf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("should not get deliveries of missing webhook", func(t *testing.T) {
+		ps, mock := initDBMock(t)
+		defer ps.Close()
+		mock.ExpectQuery(sqlCountDeliveriesMock).WithArgs(3).WillReturnRows(mock.NewRows([]string{""}).AddRow(0))
+		mock.ExpectQuery(sqlSelectWebhookMock).WithArgs(3).WillReturnRows(webhookRows(mock))
+
+		if _, _, err := ps.WebhookDeliveries(3, 0, 10); err != store.WebhookNotFound {
+			t.Fatalf("got %v, want %v", err, store.WebhookNotFound)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatalf("there were unfulfilled expectations: %s", err)
+		}
+	})
+}
@@ -23,10 +23,12 @@
 package store
 
 import (
+	"context"
 	"errors"
 	"github.com/LearningByExample/go-microservice/internal/app/config"
 	"github.com/LearningByExample/go-microservice/internal/app/data"
 	"log"
+	"time"
 )
 
 type PetStore interface {
@@ -35,16 +37,165 @@ type PetStore interface {
 	GetAllPets() ([]data.Pet, error)
 	DeletePet(id int) error
 	UpdatePet(id int, name string, race string, mod string) (bool, error)
+	ForEachPet(fn func(pet data.Pet) error) error
+	ImportPets(pets []data.Pet) error
+	BatchPets(ops []PetOperation, atomic bool) ([]PetOperationResult, error)
+	FindPets(query PetQuery) ([]data.Pet, error)
+	CountPets(query PetQuery) (int, error)
+	RestorePet(id int) error
+	TransitionPet(id int, to data.PetStatus) (data.Pet, error)
+	SetPetTags(id int, tags []string, attributes map[string]string) (bool, error)
+	PurgePets(before time.Time) ([]int, error)
+	PetHistory(id int, offset int, limit int) ([]data.PetChange, int, error)
+	SearchPets(text string, offset int, limit int) ([]data.PetMatch, int, error)
+	WithContext(ctx context.Context) PetStore
 	Open() error
 	Close() error
 	IsReady() error
 }
 
+type OwnerStore interface {
+	AddOwner(name string, email string) (int, error)
+	GetOwner(id int) (data.Owner, error)
+	GetAllOwners() ([]data.Owner, error)
+	GetOwners(ids []int) ([]data.Owner, error)
+	UpdateOwner(id int, name string, email string) (bool, error)
+	DeleteOwner(id int, reassignTo int) error
+	SetPetOwner(petId int, ownerId int) (bool, error)
+}
+
+// WebhookStore keeps the webhook subscriptions and their pending deliveries, so they survive restarts.
+type WebhookStore interface {
+	AddWebhook(url string, events []string, secret string, enabled bool) (int, error)
+	GetWebhook(id int) (data.Webhook, error)
+	GetAllWebhooks() ([]data.Webhook, error)
+	UpdateWebhook(id int, url string, events []string, secret string, enabled bool) (bool, error)
+	DisableWebhook(id int) error
+	DeleteWebhook(id int) error
+	EnqueueDeliveries(event string, payload string) (int, error)
+	ClaimDeliveries(now time.Time, lease time.Duration, limit int) ([]data.Delivery, error)
+	RecordDeliveryAttempt(id int, attempt data.DeliveryAttempt, status data.DeliveryStatus, next time.Time) (int, error)
+	WebhookDeliveries(id int, offset int, limit int) ([]data.Delivery, int, error)
+	PendingDeliveries() (int, error)
+}
+
+// ApiKeyStore keeps the API keys by the hash of the key, the keys themselves are never stored.
+type ApiKeyStore interface {
+	AddApiKey(name string, prefix string, hash string, scopes []string) (int, error)
+	GetApiKey(id int) (data.ApiKey, error)
+	GetAllApiKeys() ([]data.ApiKey, error)
+	FindApiKey(hash string) (data.ApiKey, error)
+	RotateApiKey(id int, prefix string, hash string) error
+	RevokeApiKey(id int) error
+}
+
+// OutboxStore relays the domain events recorded in the same transaction as each change, in the order they were
+// recorded, marking as published the ones the publish function accepts.
+type OutboxStore interface {
+	RelayOutbox(limit int, publish func(event data.OutboxEvent) error) (int, error)
+}
+
+// PoolStats are the connections of the pool of a store
+type PoolStats struct {
+	MaxOpen   int
+	Open      int
+	InUse     int
+	Idle      int
+	WaitCount int64
+}
+
+// PoolMonitor reports the usage of the connection pool of the stores that have one.
+type PoolMonitor interface {
+	PoolStats() PoolStats
+}
+
+// SchemaStore reports the schema version of the database and the version the store expects, a database migrated by
+// another version of the service could have a different one.
+type SchemaStore interface {
+	SchemaVersion() (int, int, error)
+}
+
+type EventNotifier interface {
+	NotifyEvent(payload string) error
+	ListenEvents(ctx context.Context, fn func(payload string)) error
+}
+
+// PetQuery filters the pets to find, AfterId and Limit take a page of them that only follows on from the previous one
+// when sorting by id, and are ignored counting them
+type PetQuery struct {
+	Ids            []int
+	OwnerId        int
+	Tags           []string
+	Attributes     map[string]string
+	IncludeDeleted bool
+	UpdatedSince   time.Time
+	Sort           []PetSort
+	AfterId        int
+	Limit          int
+}
+
+type SortField string
+
+const (
+	SortById        SortField = "id"
+	SortByName      SortField = "name"
+	SortByRace      SortField = "race"
+	SortByMod       SortField = "mod"
+	SortByCreatedAt SortField = "createdAt"
+	SortByUpdatedAt SortField = "updatedAt"
+)
+
+type PetSort struct {
+	Field      SortField
+	Descending bool
+}
+
+func (f SortField) IsValid() bool {
+	switch f {
+	case SortById, SortByName, SortByRace, SortByMod, SortByCreatedAt, SortByUpdatedAt:
+		return true
+	}
+	return false
+}
+
+// highlights are HTML, the text is escaped and only the matches are wrapped with HighlightStart and HighlightStop
+const (
+	HighlightStart = "<mark>"
+	HighlightStop  = "</mark>"
+)
+
+type OperationType string
+
+const (
+	CreateOperation OperationType = "create"
+	UpdateOperation OperationType = "update"
+	DeleteOperation OperationType = "delete"
+)
+
+type PetOperation struct {
+	Type OperationType
+	Pet  data.Pet
+}
+
+type PetOperationResult struct {
+	Id      int
+	Changed bool
+	Err     error
+}
+
 type Provider func(cfg config.CfgData) PetStore
 type providersMap map[string]Provider
 
 var (
 	PetNotFound      = errors.New("can not find pet")
+	OwnerNotFound    = errors.New("can not find owner")
+	OwnerHasPets     = errors.New("owner has pets")
+	InvalidOwner     = errors.New("invalid owner")
+	WebhookNotFound  = errors.New("can not find webhook")
+	DeliveryNotFound = errors.New("can not find delivery")
+	ApiKeyNotFound   = errors.New("can not find api key")
+	IllegalStatus    = errors.New("illegal pet status transition")
+	InvalidOperation = errors.New("invalid operation")
 	ProviderNotFound = errors.New("can not find provider")
 	providers        = make(providersMap)
 )
@@ -0,0 +1,240 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package token
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+	ES256 = "ES256"
+)
+
+var (
+	UnsupportedKey = errors.New("unsupported key")
+	InvalidKey     = errors.New("invalid key")
+)
+
+// Key is a verification key of a JWKS with the only algorithm it could be used with
+type Key struct {
+	Id        string
+	Algorithm string
+	Key       interface{}
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+}
+
+type jwks struct {
+	Keys []jwk `json:"keys"`
+}
+
+func decodeBase64(value string) ([]byte, error) {
+	if value == "" {
+		return nil, InvalidKey
+	}
+	return base64.RawURLEncoding.DecodeString(value)
+}
+
+func decodeInt(value string) (*big.Int, error) {
+	bytes, err := decodeBase64(value)
+	if err != nil {
+		return nil, InvalidKey
+	}
+	return new(big.Int).SetBytes(bytes), nil
+}
+
+func (k jwk) key() (Key, error) {
+	key := Key{Id: k.Kid}
+	var err error = nil
+
+	switch k.Kty {
+	case "RSA":
+		key.Algorithm = RS256
+		var n, e *big.Int
+		if n, err = decodeInt(k.N); err == nil {
+			if e, err = decodeInt(k.E); err == nil {
+				if e.IsInt64() && e.Int64() > 1 && e.Int64() <= 1<<31-1 {
+					key.Key = &rsa.PublicKey{N: n, E: int(e.Int64())}
+				} else {
+					err = InvalidKey
+				}
+			}
+		}
+	case "EC":
+		key.Algorithm = ES256
+		if k.Crv != "P-256" {
+			return key, UnsupportedKey
+		}
+		var x, y *big.Int
+		if x, err = decodeInt(k.X); err == nil {
+			if y, err = decodeInt(k.Y); err == nil {
+				if elliptic.P256().IsOnCurve(x, y) {
+					key.Key = &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+				} else {
+					err = InvalidKey
+				}
+			}
+		}
+	case "oct":
+		key.Algorithm = HS256
+		var secret []byte
+		if secret, err = decodeBase64(k.K); err == nil {
+			key.Key = secret
+		} else {
+			err = InvalidKey
+		}
+	default:
+		err = UnsupportedKey
+	}
+
+	if err == nil && k.Alg != "" && k.Alg != key.Algorithm {
+		err = UnsupportedKey
+	}
+	return key, err
+}
+
+// ParseKeys gets the signing keys of a JWKS, skipping the ones for encryption or with unsupported algorithms
+func ParseKeys(raw []byte) ([]Key, error) {
+	set := jwks{}
+	if err := json.Unmarshal(raw, &set); err != nil {
+		return nil, err
+	}
+	keys := make([]Key, 0, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.key()
+		if err == UnsupportedKey {
+			log.Printf("Skipping unsupported key %q of type %q", k.Kid, k.Kty)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// KeySet has the keys inlined in the configuration and the ones of a JWKS file, that are reloaded when it changes
+// keeping the previous keys if the file is not valid.
+type KeySet struct {
+	mu       sync.RWMutex
+	static   []Key
+	loaded   []Key
+	path     string
+	modified time.Time
+}
+
+func (s *KeySet) Keys() []Key {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append(append(make([]Key, 0, len(s.static)+len(s.loaded)), s.static...), s.loaded...)
+}
+
+func (s *KeySet) Reload() error {
+	if s.path == "" {
+		return nil
+	}
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+	s.mu.RLock()
+	unchanged := info.ModTime().Equal(s.modified)
+	s.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+	raw, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+	keys, err := ParseKeys(raw)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.loaded = keys
+	s.modified = info.ModTime()
+	log.Printf("Loaded %d keys from %q.", len(keys), s.path)
+	return nil
+}
+
+func (s *KeySet) Watch(interval time.Duration) func(ctx context.Context) {
+	return func(ctx context.Context) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := s.Reload(); err != nil {
+					log.Printf("Error %v reloading keys from %q", err, s.path)
+				}
+			}
+		}
+	}
+}
+
+func NewKeySet(path string, inline []byte) (*KeySet, error) {
+	set := &KeySet{path: path}
+	var err error = nil
+	if len(inline) != 0 {
+		set.static, err = ParseKeys(inline)
+	}
+	if err == nil {
+		err = set.Reload()
+	}
+	return set, err
+}
@@ -0,0 +1,209 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package token
+
+import (
+	"github.com/LearningByExample/go-microservice/internal/_test"
+	"github.com/LearningByExample/go-microservice/internal/app/config"
+	"github.com/LearningByExample/go-microservice/internal/app/data"
+	"github.com/golang-jwt/jwt/v4"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var (
+	testNow = time.Date(2020, 3, 9, 8, 7, 36, 0, time.UTC)
+)
+
+func testClaims(changes jwt.MapClaims) jwt.MapClaims {
+	claims := jwt.MapClaims{
+		"sub":   "john",
+		"iss":   "https://gateway.example.com",
+		"aud":   "pets",
+		"exp":   testNow.Add(time.Hour).Unix(),
+		"roles": []string{"editor"},
+	}
+	for k, v := range changes {
+		if v == nil {
+			delete(claims, k)
+		} else {
+			claims[k] = v
+		}
+	}
+	return claims
+}
+
+func newTestVerifier(t *testing.T, keys _test.TokenKeys) Verifier {
+	t.Helper()
+	v, err := NewVerifier(config.JwtCfg{Jwks: keys.Jwks(), Issuer: "https://gateway.example.com", Audience: "pets",
+		ClockSkew: 60000, RolesClaim: "roles", OwnerClaim: "owner_id", Roles: map[string][]string{
+			"editor": {data.ScopePetsWrite, data.ScopePetsRead},
+			"viewer": {data.ScopePetsRead},
+		}})
+	if err != nil {
+		t.Fatalf("error creating verifier: %v", err)
+	}
+	v.now = func() time.Time {
+		return testNow
+	}
+	return v
+}
+
+func TestVerifier(t *testing.T) {
+	keys := _test.NewTokenKeys()
+	other := _test.NewTokenKeys()
+	v := newTestVerifier(t, keys)
+
+	type testCase struct {
+		name  string
+		token string
+		want  error
+	}
+
+	var cases = []testCase{
+		{name: "should verify RS256", token: keys.Sign(RS256, "rsa", testClaims(nil))},
+		{name: "should verify ES256", token: keys.Sign(ES256, "ec", testClaims(nil))},
+		{name: "should verify HS256", token: keys.Sign(HS256, "hmac", testClaims(nil))},
+		{name: "should verify without key id", token: keys.Sign(RS256, "", testClaims(nil))},
+		{
+			name:  "should verify with an audience list",
+			token: keys.Sign(RS256, "rsa", testClaims(jwt.MapClaims{"aud": []string{"orders", "pets"}})),
+		},
+		{
+			name:  "should verify expired within the clock skew",
+			token: keys.Sign(RS256, "rsa", testClaims(jwt.MapClaims{"exp": testNow.Add(-30 * time.Second).Unix()})),
+		},
+		{
+			name:  "should fail when expired",
+			token: keys.Sign(RS256, "rsa", testClaims(jwt.MapClaims{"exp": testNow.Add(-2 * time.Minute).Unix()})),
+			want:  TokenExpired,
+		},
+		{
+			name:  "should fail without expiration",
+			token: keys.Sign(RS256, "rsa", testClaims(jwt.MapClaims{"exp": nil})),
+			want:  InvalidToken,
+		},
+		{
+			name:  "should fail when not valid yet",
+			token: keys.Sign(RS256, "rsa", testClaims(jwt.MapClaims{"nbf": testNow.Add(2 * time.Minute).Unix()})),
+			want:  TokenNotValidYet,
+		},
+		{
+			name:  "should fail with other issuer",
+			token: keys.Sign(RS256, "rsa", testClaims(jwt.MapClaims{"iss": "https://other.example.com"})),
+			want:  InvalidIssuer,
+		},
+		{
+			name:  "should fail with other audience",
+			token: keys.Sign(RS256, "rsa", testClaims(jwt.MapClaims{"aud": "orders"})),
+			want:  InvalidAudience,
+		},
+		{
+			name:  "should fail without subject",
+			token: keys.Sign(RS256, "rsa", testClaims(jwt.MapClaims{"sub": nil})),
+			want:  MissingSubject,
+		},
+		{name: "should fail with unknown key id", token: keys.Sign(RS256, "other", testClaims(nil)), want: UnknownKey},
+		{name: "should fail with other key", token: other.Sign(RS256, "rsa", testClaims(nil)), want: InvalidToken},
+		{name: "should fail with an unsupported algorithm", token: keys.Sign("HS512", "", testClaims(nil)), want: InvalidToken},
+		{name: "should fail with garbage", token: "not.a.token", want: InvalidToken},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := v.Verify(tt.token); err != tt.want {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	t.Run("should map roles to scopes", func(t *testing.T) {
+		token := keys.Sign(ES256, "ec", testClaims(jwt.MapClaims{"roles": "viewer editor unknown", "owner_id": 7}))
+		got, err := v.Verify(token)
+		want := data.Principal{Subject: "john", Roles: []string{"editor", "unknown", "viewer"},
+			Scopes: []string{data.ScopePetsRead, data.ScopePetsWrite}, OwnerId: 7}
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %v and %v, want %v", got, err, want)
+		}
+	})
+
+	t.Run("should reject unsigned tokens", func(t *testing.T) {
+		token, _ := jwt.NewWithClaims(jwt.SigningMethodNone, testClaims(nil)).SignedString(jwt.UnsafeAllowNoneSignatureType)
+		if _, err := v.Verify(token); err != InvalidToken {
+			t.Fatalf("got %v, want %v", err, InvalidToken)
+		}
+	})
+}
+
+func TestParseKeys(t *testing.T) {
+	t.Run("should skip encryption and unsupported keys", func(t *testing.T) {
+		raw := `{"keys":[{"kty":"RSA","use":"enc","n":"AQAB","e":"AQAB"},{"kty":"OKP","crv":"Ed25519","x":"AQAB"},
+			{"kty":"oct","kid":"hmac","k":"c2VjcmV0"}]}`
+		keys, err := ParseKeys([]byte(raw))
+		if err != nil || len(keys) != 1 || keys[0].Id != "hmac" || keys[0].Algorithm != HS256 {
+			t.Fatalf("got %v and %v, want the hmac key", keys, err)
+		}
+	})
+
+	t.Run("should fail with an invalid key", func(t *testing.T) {
+		if _, err := ParseKeys([]byte(`{"keys":[{"kty":"EC","crv":"P-256","x":"AQAB","y":"AQAB"}]}`)); err != InvalidKey {
+			t.Fatalf("got %v, want %v", err, InvalidKey)
+		}
+	})
+
+	t.Run("should skip a key for other algorithm", func(t *testing.T) {
+		keys, _ := ParseKeys([]byte(`{"keys":[{"kty":"oct","alg":"RS256","k":"c2VjcmV0"}]}`))
+		if len(keys) != 0 {
+			t.Fatalf("got %v, want no keys", keys)
+		}
+	})
+}
+
+func TestKeySetReload(t *testing.T) {
+	file, _ := ioutil.TempFile("", "jwks-*.json")
+	//noinspection GoUnhandledErrorResult
+	defer os.Remove(file.Name())
+	_ = file.Close()
+	keys := _test.NewTokenKeys()
+	_ = ioutil.WriteFile(file.Name(), []byte(`{"keys":[]}`), 0600)
+
+	set, err := NewKeySet(file.Name(), nil)
+	if err != nil || len(set.Keys()) != 0 {
+		t.Fatalf("got %v and %v, want no keys", set.Keys(), err)
+	}
+
+	_ = ioutil.WriteFile(file.Name(), keys.Jwks(), 0600)
+	_ = os.Chtimes(file.Name(), testNow, testNow)
+	if err = set.Reload(); err != nil || len(set.Keys()) != 3 {
+		t.Fatalf("got %v and %v, want reloaded keys", set.Keys(), err)
+	}
+
+	_ = ioutil.WriteFile(file.Name(), []byte("bad"), 0600)
+	_ = os.Chtimes(file.Name(), testNow.Add(time.Hour), testNow.Add(time.Hour))
+	if err = set.Reload(); err == nil || len(set.Keys()) != 3 {
+		t.Fatalf("got %v and %v, want previous keys kept", set.Keys(), err)
+	}
+}
@@ -0,0 +1,177 @@
+/*
+ * Copyright (c) 2020 Learning by Example maintainers.
+ *
+ *  Permission is hereby granted, free of charge, to any person obtaining a copy
+ *  of this software and associated documentation files (the "Software"), to deal
+ *  in the Software without restriction, including without limitation the rights
+ *  to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ *  copies of the Software, and to permit persons to whom the Software is
+ *  furnished to do so, subject to the following conditions:
+ *
+ *  The above copyright notice and this permission notice shall be included in
+ *  all copies or substantial portions of the Software.
+ *
+ *  THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ *  IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ *  FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ *  AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ *  LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ *  OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+ *  THE SOFTWARE.
+ */
+
+package token
+
+import (
+	"context"
+	"errors"
+	"github.com/LearningByExample/go-microservice/internal/app/config"
+	"github.com/LearningByExample/go-microservice/internal/app/data"
+	"github.com/golang-jwt/jwt/v4"
+	"strings"
+	"time"
+)
+
+var (
+	InvalidToken     = errors.New("invalid token")
+	UnknownKey       = errors.New("unknown signing key")
+	TokenExpired     = errors.New("token is expired")
+	TokenNotValidYet = errors.New("token is not valid yet")
+	InvalidIssuer    = errors.New("invalid token issuer")
+	InvalidAudience  = errors.New("invalid token audience")
+	MissingSubject   = errors.New("token without subject")
+)
+
+// Verifier validates the signature and the claims of the tokens, allowing a clock skew for the times, and maps the
+// roles in the tokens to the scopes that they grant.
+type Verifier struct {
+	keys       *KeySet
+	methods    []string
+	issuer     string
+	audience   string
+	skew       time.Duration
+	interval   time.Duration
+	rolesClaim string
+	ownerClaim string
+	roles      map[string][]string
+	now        func() time.Time
+}
+
+// keyFor finds the key for the algorithm of the token, using the key id of the token when there is more than one
+func (v Verifier) keyFor(t *jwt.Token) (interface{}, error) {
+	kid, _ := t.Header["kid"].(string)
+	alg := t.Method.Alg()
+	var found interface{} = nil
+	for _, k := range v.keys.Keys() {
+		if k.Algorithm == alg && (kid == "" || k.Id == kid) {
+			if found != nil {
+				return nil, UnknownKey
+			}
+			found = k.Key
+		}
+	}
+	if found == nil {
+		return nil, UnknownKey
+	}
+	return found, nil
+}
+
+func claimTime(claims jwt.MapClaims, name string) (time.Time, bool) {
+	if value, ok := claims[name].(float64); ok {
+		return time.Unix(int64(value), 0), true
+	}
+	return time.Time{}, false
+}
+
+func claimStrings(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return strings.Fields(v)
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+func (v Verifier) validClaims(claims jwt.MapClaims) error {
+	now := v.now()
+	if exp, ok := claimTime(claims, "exp"); !ok {
+		return InvalidToken
+	} else if now.After(exp.Add(v.skew)) {
+		return TokenExpired
+	}
+	if nbf, ok := claimTime(claims, "nbf"); ok && now.Add(v.skew).Before(nbf) {
+		return TokenNotValidYet
+	}
+	if iss, _ := claims["iss"].(string); v.issuer != "" && iss != v.issuer {
+		return InvalidIssuer
+	}
+	if v.audience != "" {
+		found := false
+		for _, aud := range claimStrings(claims["aud"]) {
+			found = found || aud == v.audience
+		}
+		if !found {
+			return InvalidAudience
+		}
+	}
+	if sub, _ := claims["sub"].(string); sub == "" {
+		return MissingSubject
+	}
+	return nil
+}
+
+func (v Verifier) Verify(raw string) (data.Principal, error) {
+	claims := jwt.MapClaims{}
+	parser := jwt.Parser{ValidMethods: v.methods, SkipClaimsValidation: true}
+	if _, err := parser.ParseWithClaims(raw, claims, v.keyFor); err != nil {
+		if ve, ok := err.(*jwt.ValidationError); ok && ve.Inner == UnknownKey {
+			return data.Principal{}, UnknownKey
+		}
+		return data.Principal{}, InvalidToken
+	}
+	if err := v.validClaims(claims); err != nil {
+		return data.Principal{}, err
+	}
+
+	principal := data.Principal{Roles: data.SortedTags(claimStrings(claims[v.rolesClaim]))}
+	principal.Subject, _ = claims["sub"].(string)
+	if owner, ok := claims[v.ownerClaim].(float64); ok && owner > 0 {
+		principal.OwnerId = int(owner)
+	}
+	scopes := make([]string, 0)
+	for _, role := range principal.Roles {
+		scopes = append(scopes, v.roles[role]...)
+	}
+	principal.Scopes = data.SortedTags(scopes)
+	return principal, nil
+}
+
+// Watch reloads the JWKS file until the context is done, it returns at once when there is no file to reload
+func (v Verifier) Watch(ctx context.Context) {
+	if v.keys.path != "" && v.interval > 0 {
+		v.keys.Watch(v.interval)(ctx)
+	}
+}
+
+func NewVerifier(cfg config.JwtCfg) (Verifier, error) {
+	keys, err := NewKeySet(cfg.JwksFile, cfg.Jwks)
+	return Verifier{
+		keys:       keys,
+		methods:    []string{HS256, RS256, ES256},
+		issuer:     cfg.Issuer,
+		audience:   cfg.Audience,
+		skew:       time.Duration(cfg.ClockSkew) * time.Millisecond,
+		interval:   time.Duration(cfg.ReloadInterval) * time.Millisecond,
+		rolesClaim: cfg.RolesClaim,
+		ownerClaim: cfg.OwnerClaim,
+		roles:      cfg.Roles,
+		now:        time.Now,
+	}, err
+}